@@ -31,6 +31,9 @@ Flags:
 	-L dir1 -L dir2
 		Search for imported packages in dir1, dir2, etc,
 		after consulting $GOROOT/pkg/$GOOS_$GOARCH.
+	-M
+		Print a map of the section and symbol layout of the output
+		to standard output, in the text format of -linkmap.
 	-R quantum
 		Set address rounding quantum.
 	-T address
@@ -43,14 +46,66 @@ Flags:
 		or initialized to a constant string expression. -X will not work if the initializer makes
 		a function call or refers to other variables.
 		Note that before Go 1.5 this option took two separate arguments.
+	-add-note section:owner:type=file
+		For ELF, add a note with the owner name owner, the type type,
+		such as 0xcafe1a7e for the .note.package descriptor of systemd,
+		and the contents of file as its description to a read-only
+		SHT_NOTE section called section, covered by a PT_NOTE program
+		header when linking internally. May be repeated; notes for the
+		same section are written in order.
+	-add-section name=file
+		Add a read-only section called name with the contents of file,
+		such as an SBOM, to an ELF or Mach-O output. For Mach-O, name
+		is __TEXT,section. May be repeated.
+	-adhocsign
+		Give the Mach-O output of the external linker a fresh ad hoc
+		code signature, with SHA-256 hashes of its final contents,
+		after the LC_UUID rewrite and the other post-link passes,
+		without running codesign. An existing signature is replaced;
+		output with none gets one added at the end of __LINKEDIT,
+		which needs 16 bytes of header padding for the
+		LC_CODE_SIGNATURE command. Requires external linking.
+	-arnames flavor
+		Store the names of the members of the archive of
+		-buildmode=c-archive as flavor gnu, in the header or, if longer
+		than 15 bytes, in a "//" member, or bsd, as #1/len followed by
+		the name, padded so that the data of each member is 8-byte
+		aligned. The default is bsd on darwin and gnu elsewhere.
+		The members are named by their base names, and have dates,
+		owners and groups of 0 and mode 0644, so that the archive
+		depends only on them. Not supported with -extar or on aix,
+		where the archive program writes the archive.
+	-arsymtab flavor
+		Write the symbol index of the archive of -buildmode=c-archive
+		as flavor gnu, the "/" member of GNU ar, which becomes
+		"/SYM64/" once the archive is larger than 4GB; gnu64, always
+		"/SYM64/"; bsd, the "__.SYMDEF SORTED" member of ranlib; or
+		none. The default is bsd on darwin and gnu elsewhere.
 	-asan
 		Link with C/C++ address sanitizer support.
 	-aslr
 		Enable ASLR for buildmode=c-shared on windows (default true).
 	-bindnow
 		Mark a dynamically linked ELF object for immediate function binding (default false).
+	-btf
+		Write the types of the DWARF in BTF, the type format of the
+		BPF tools of Linux, to a .BTF section of an ELF output. The
+		types keep their Go names.
 	-buildid id
 		Record id as Go toolchain build id.
+	-buildidhash algorithm
+		Derive the note of -B gobuildid from the Go build ID with
+		algorithm: notsha256 (the default on ELF) and sha1 give 20
+		bytes, sha256 gives 32, and uuid gives the 16-byte UUID
+		-B gobuildid sets on Mach-O (the default there). The sha1
+		ID is taken from the SHA-256 of the Go build ID, with the
+		size of the IDs of GNU ld --build-id=sha1. On Mach-O, the
+		algorithms other than uuid give a UUID of their first 16
+		bytes.
+	-buildidlen n
+		Truncate the note of -B gobuildid to n bytes, or, with a
+		hex -B note, check that it is n bytes long. A Mach-O UUID
+		is always 16 bytes.
 	-buildmode mode
 		Set build mode (default exe).
 	-c
@@ -61,8 +116,20 @@ Flags:
 		linknames are permitted.
 	-compressdwarf
 		Compress DWARF if possible (default true).
+		The value may name the compression instead: zlib, the default,
+		or zstd, optionally followed by a level from 1 to 22, as in
+		-compressdwarf=zstd:7 (default level 3). zstd compresses better
+		than zlib, and higher levels better still but more slowly; its
+		sections are ELF SHF_COMPRESSED ones of type ELFCOMPRESS_ZSTD,
+		which only recent debuggers and tools read. zstd is for ELF only.
 	-cpuprofile file
 		Write CPU profile to file.
+	-cref file
+		Write a cross-reference table to file, like GNU ld --cref:
+		each symbol of the link, with the package and object file or
+		host object it comes from, followed by the symbols that
+		refer to it, to show what pulls a symbol or package into the
+		output.
 	-d
 		Disable generation of dynamic executables.
 		The emitted code is the same in either case; the option
@@ -70,25 +137,123 @@ Flags:
 		The dynamic header is on by default, even without any
 		references to dynamic libraries, because many common
 		system tools now assume the presence of the header.
+	-debugnames
+		Write a DWARF 5 .debug_names index of the functions, inlined
+		calls, global variables, constants and types in the DWARF, with
+		their names in a .debug_str section, so that debuggers such as
+		lldb look names up in it instead of indexing the DWARF when
+		they load the program.
+	-disallow-packages list
+		Fail the link if a package of the comma-separated list of
+		import paths is linked in, that is, if any of its functions or
+		variables is live after dead code elimination, printing the
+		chain of references that keeps one of them live, as -why-live
+		does. A path ending in /... stands for the packages under it
+		too. Given as @file, the paths are read from file, one per
+		line, where blank lines and lines starting with # are ignored.
 	-dumpdep
 		Dump symbol dependency graph.
+	-dwarf-prefix-map old=new
+		Rewrite the paths in DWARF that start with the directory old
+		to start with new instead, as -fdebug-prefix-map does for C
+		compilers: the file names of the line tables, the names and
+		directories of the compilation units and the path of the gdb
+		script. When linking externally, the paths in the DWARF of
+		host objects, such as the C code of cgo packages, are rewritten
+		too, padded with slashes to keep their length, so new must not
+		be longer than old for those. If more than one old prefix
+		matches, the last given wins. Can be repeated. The file names
+		that runtime.Caller reports are left as they are; use -trimpath
+		when compiling for those.
+	-emitrelocs
+		For an internally linked ELF executable on amd64 or arm64, keep
+		the relocations applied to the Go sections in the output, in a
+		SHT_RELA .rela section for each, as GNU ld's --emit-relocs does.
+		Post-link optimizers and binary rewriters use them to move code
+		and data. The relocations refer to STT_SECTION symbols; those
+		to dynamic imports and TLS, and section-relative offsets, are
+		dropped.
+	-export-dynamic-symbol symbol
+		Export symbol, and only the symbols given by this flag or
+		-export-symbols, from a -buildmode=c-shared library, instead of
+		every symbol that cgo exports and the global symbols of the
+		C code of runtime/cgo and other host objects. Symbols are named
+		as in C. A -buildmode=plugin library still exports its Go
+		symbols, which it shares with the program that opens it, but
+		not the other global symbols of the host objects. Supported
+		for ELF, Mach-O and XCOFF. Can be repeated.
+	-export-symbols file
+		Export the symbols listed in file, one per line, as for
+		-export-dynamic-symbol. Blank lines and lines starting with #
+		are ignored. Can be repeated.
 	-extar ar
-		Set the external archive program (default "ar").
-		Used only for -buildmode=c-archive.
+		Set the external archive program, to write the archive of
+		-buildmode=c-archive with instead of the linker. Used only
+		for -buildmode=c-archive. On aix, the default is "ar".
 	-extld linker
 		Set the external linker (default "clang" or "gcc").
+	-extld-verbose
+		Print each command run of the external linker, with the
+		probes of the flags it supports and the tools it finds, as a
+		shell command line that runs it again, and the contents of
+		the response file the arguments are passed in when they are
+		too long for the command line.
+	-extldcache dir
+		Cache the results of probing the external linker in dir, so
+		that later links with the same linker do not run the probes
+		again. Each result is keyed by the path, size and modification
+		time of the linker and the arguments of the probe.
 	-extldflags flags
 		Set space-separated flags to pass to the external linker.
 	-f
 		Ignore version mismatch in the linked archives.
+	-forceuuid uuid
+		Deprecated: use -macho-uuid=0x followed by the 32 hex digits of
+		uuid. Sets the LC_UUID of the Mach-O output to uuid, given in
+		the form XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX, as that policy
+		does, and cannot be combined with -macho-uuid.
+	-funcalign [hot:]n
+		Align the Go functions to n bytes, a power of two up to 4096,
+		in place of the default of the architecture, for example to
+		32 or 64 so that benchmarks do not vary with the placement of
+		their loops. Functions that need a larger alignment keep it.
+		With hot:, align only the functions of the -pgo profile. Not
+		supported for wasm.
 	-g
 		Disable Go package data checks.
+	-icf mode
+		Fold identical functions, such as instantiations of generic
+		functions and wrappers that compile to the same code, into
+		one copy. The folded functions keep their names in the symbol
+		table, at the address of the copy; tracebacks name the copy.
+		With mode safe, only functions whose address is not taken
+		fold; with mode all, any may, so that distinct func values
+		can compare equal in reflect. The default mode, none, folds
+		nothing. Supported only when linking internally.
 	-importcfg file
 		Read import configuration from file.
 		In the file, set packagefile, packageshlib to specify import resolution.
+	-incremental dir
+		Experimental: keep the output of the link and the state it was
+		laid out from in the directory dir, keyed by the linker, its
+		flags and the contents of the linked packages. A later link of
+		the same program whose symbols only changed in the contents of
+		functions, such as after an edit of a constant, patches those
+		functions in a copy of the kept output instead of linking from
+		scratch; any other change links in full and replaces the state.
+		Only supported for executables linked internally to ELF without
+		host objects. With -v, the link reports which it did and why.
 	-installsuffix suffix
 		Look for packages in $GOROOT/pkg/$GOOS_$GOARCH_suffix
 		instead of $GOROOT/pkg/$GOOS_$GOARCH.
+	-json-report file
+		Write a JSON report to file that attributes the size of the
+		output to its sections, to kinds of symbol (text, rodata,
+		pclntab, data, bss, dwarf and unwind), to the Go packages the
+		symbols come from, by kind, and, when linking internally, to
+		the host objects. Bytes no symbol holds are counted as the
+		padding of their section. Unlike -linkmap, the report lists
+		no symbols, only totals, for checking size budgets.
 	-k symbol
 		Set field tracking symbol. Use this flag when GOEXPERIMENT=fieldtrack is set.
 	-libgcc file
@@ -97,11 +262,100 @@ Flags:
 		If not set, default value comes from running the compiler,
 		which may be set by the -extld option.
 		Set to "none" to use no support library.
+	-linkmap file
+		Write a map of the section and symbol layout of the output
+		to file, like the map GNU ld -Map writes: each section with
+		its address, size and file offset, and under it each symbol
+		placed in it, with its address, size, package and the object
+		file or archive it comes from.
+	-linkmapformat format
+		Write the -linkmap file in format: text (the default), with
+		a line for each section and for each symbol, or json, a
+		single object with the same contents.
 	-linkmode mode
 		Set link mode (internal, external, auto).
 		This sets the linking mode as described in cmd/cgo/doc.go.
+		Host objects that are LLVM bitcode, as clang writes for C
+		code built with -flto, make the auto mode link externally.
+		Linking internally, each is first compiled to a native
+		object with the -extld compiler, as clang -c -x ir does.
 	-linkshared
 		Link against installed Go shared libraries (experimental).
+	-macho-uuid policy
+		Set the LC_UUID of the Mach-O output by policy: gobuildid
+		derives it from the Go build ID, as external links do by
+		default (requires -buildid); random gives a fresh version 4
+		UUID; none sets it to all zeros; and 0x followed by 32 hex
+		digits gives those bytes. Applies to internal and external
+		links, and cannot be combined with -uuidfrom or -B. The
+		gobuildid policy derives the UUID as -B gobuildid does, so
+		-buildidhash applies to it.
+	-machofat files
+		Combine the Mach-O output with the thin Mach-O files, a
+		comma-separated list of the outputs of links of the same
+		program for other architectures, into a universal binary, as
+		lipo -create would. Each slice is aligned to 16 KiB for arm64
+		and 4 KiB otherwise, and the slices are sorted so that the
+		result does not depend on the order of files. The slices must
+		be of the same file type and have different LC_UUIDs, for
+		example from -uuidpercpu. Records such as -uuidinfo describe
+		the output before it is combined.
+	-machofixups format
+		Encode the rebases and binds of an internally linked Mach-O
+		PIE as format: chained, as LC_DYLD_CHAINED_FIXUPS chains of
+		DYLD_CHAINED_PTR_64 pointers, which dyld reads from macOS 12;
+		opcodes, as the rebase and bind opcodes of LC_DYLD_INFO_ONLY,
+		which older versions need; or auto, the default, which is
+		chained if the minimum macOS version of the output is 12.0 or
+		later and opcodes otherwise. Chained raises the minimum macOS
+		version of the output to 12.0, and is an error if a host
+		object declares an older one.
+	-machosdk dir
+		When linking internally for darwin or ios, resolve the symbols
+		that host objects import from dynamic libraries, and that no
+		cgo_import_dynamic directive imports, from the text-based stubs
+		(.tbd files) of the SDK at dir instead of requiring external
+		linking: the stub of libSystem, and those of the libraries that
+		the -l and -framework options of the cgo LDFLAGS and of
+		-extldflags name, looked for in their -L and -F directories and
+		then in the SDK. A symbol a library re-exports is imported from
+		that library. On macOS, the SDK defaults to the one xcrun finds.
+		When linking externally, dir is passed to the external linker
+		as -isysroot.
+	-machosigner command
+		Sign the Mach-O output with a signing identity instead of ad
+		hoc, so that it is ready for notarization. Once the output is
+		otherwise complete, the linker writes its code signature: a
+		code directory with the SHA-256 hashes of its pages, flagged
+		for the hardened runtime, an empty set of internal
+		requirements, and a CMS signature of the code directory that
+		command makes. The command is run with the path of a file
+		holding the code directory as its last argument and must print
+		the DER-encoded CMS signature, of at most 16 KiB, to its
+		standard output. Because the go command cannot update the build
+		ID of a file so signed, the linker gives the build ID its
+		content ID before signing. Requires -machosignid.
+	-machosignid identifier
+		Set the identifier of the -machosigner code signature, such as
+		com.example.tool.
+	-machosignteam id
+		Record the team ID of the signing identity in the -machosigner
+		code signature.
+	-machostub file
+		Resolve symbols as -machosdk does from the .tbd stub file too,
+		before the stubs that -machosdk finds. Can be repeated. When
+		linking externally, file is passed to the external linker.
+	-max-binary-size size
+		Fail the link, removing the output, if the output is larger
+		than size bytes. A size can be followed by K, M or G for KiB,
+		MiB or GiB. The linker then prints the largest sections, kinds
+		of symbol and packages, as -json-report counts them, or, with
+		-size-baseline, how they changed. Not supported with
+		-buildmode=c-archive.
+	-max-section-size section=size
+		Fail the link as -max-binary-size does if the sections named
+		section are larger than size bytes in all, or if the output
+		has none. Can be repeated.
 	-memprofile file
 		Write memory profile to file.
 	-memprofilerate rate
@@ -110,20 +364,464 @@ Flags:
 		Link with C/C++ memory sanitizer support.
 	-o file
 		Write output to file (default a.out, or a.out.exe on Windows).
+	-packrelativerelocs
+		For an internally linked ELF PIE on amd64 or arm64, pack the
+		relative relocations into a SHT_RELR .relr.dyn section instead
+		of .rela, which makes the output smaller. The resulting binary
+		needs a dynamic linker that supports DT_RELR, such as glibc
+		2.36 or later. With -linkmode=external, pass
+		-z pack-relative-relocs to the external linker.
+	-pedllcharacteristics list
+		Set the DLL characteristics of the PE output in the
+		comma-separated list, or clear those prefixed with no:
+		dynamicbase, highentropyva, nxcompat and tsaware, which the
+		linker otherwise sets as the build mode needs; guardcf, which
+		enables Control Flow Guard with a load config directory and a
+		table of the valid indirect call targets, every function and
+		each callback entry of the runtime, against which the code
+		built with /guard:cf, such as the system DLLs, checks its
+		calls; and cetcompat, which marks the output compatible with
+		CET shadow stacks in an extended DLL characteristics debug
+		directory entry. When linking externally, pass the matching
+		options, such as --disable-nxcompat or --guard-cf, which only
+		lld has, to the external linker, which has none for
+		cetcompat.
+	-peimplib file
+		Write an import library for the DLL of -buildmode=c-shared
+		to file, such as libfoo.dll.a or foo.lib, for MSVC, lld and
+		MinGW linkers to link programs against the DLL with. It has
+		an import for each export of the DLL, with the exported
+		variables imported as data.
+	-pesubsystemversion major.minor
+		Set the subsystem version of the PE output, the oldest
+		Windows version it runs on (default 6.1). It cannot be older
+		than the default.
+	-pgo file
+		Place functions in the text segment by the CPU profile file,
+		a pprof profile or one preprocessed by go tool preprofile, as
+		taken by the compiler's -pgo: the functions of the hottest
+		calls come first, with callees after their callers, for
+		fewer instruction cache and TLB misses. Functions not in the
+		profile follow in the usual order. The go command does not
+		pass its -pgo profile on; use -ldflags=-pgo=file.
+	-platform-version platform,minos[,sdk]
+		Set the platform, minimum OS version and SDK version of the
+		Mach-O output, where the versions have the form x[.y[.z]] and
+		the SDK version defaults to the minimum OS version. For darwin,
+		platform is macos or maccatalyst; for ios, it is ios or
+		iossimulator. When linking internally, write them to the
+		LC_BUILD_VERSION command in place of the defaults and of what
+		host objects declare; when linking externally, pass them to the
+		external linker as -platform_version.
 	-pluginpath path
 		The path name used to prefix exported plugin symbols.
+	-post-link-tool command
+		Once the output is complete, after any external linker and
+		archiver, run command with two more arguments: the path of the
+		output and that of a JSON file describing the link, with the
+		target, build mode and link mode and, when linking internally,
+		the address, size and file offset of each section. The command
+		may change or replace the output, for example to sign it;
+		because it runs as part of the link, the build ID the go
+		command records covers its changes. A failure of the command
+		fails the link.
+	-provenance builder
+		Embed a record of how the output was built, in the form of a
+		SLSA provenance predicate naming builder as the builder: the
+		target, build mode and link mode, the toolchain, Go build ID
+		and build settings, the VCS revision of the main module, the
+		modules linked with their go.sum hashes, and a SHA-256 digest
+		of the import paths and fingerprints of the packages linked.
+		The record is a line of JSON with its fields in a fixed order,
+		so that the same inputs give the same bytes. It is written to
+		a .note.go.provenance note, of owner Go and type 5, for ELF,
+		and to a __TEXT,__go_provenance section for Mach-O.
 	-r dir1:dir2:...
-		Set the ELF dynamic linker search path.
+	Set the ELF dynamic linker search path.
 	-race
 		Link with race detection libraries.
+	-reprobuildinfo
+		Write a record of how the Mach-O output was made to the output
+		path with ".buildinfo" appended, in the format of the
+		.buildinfo files of reproducible-builds.org: the binary, its
+		SHA-256 checksum, the Go version and $SOURCE_DATE_EPOCH, and,
+		in fields starting with X-Go-, the Go build ID, how the LC_UUID
+		of each architecture was derived from it (as for -uuidinfo),
+		and the status of each post-link pass. Only supported when
+		linking for darwin or ios.
+	-reproducible
+		Normalize fields of the Mach-O output of the external linker
+		that can vary between otherwise identical links. The tool
+		versions in LC_BUILD_VERSION and the LC_SOURCE_VERSION version
+		are cleared, as are any padding in LC_MAIN after the entry point
+		and stack size and the reserved field of the 64-bit Mach-O
+		header (the header flags are kept), timestamps are set to
+		$SOURCE_DATE_EPOCH if it is set, or to a fixed value if not,
+		and zero padding at the end of __LINKEDIT is removed. With
+		-buildmode=c-archive, the Mach-O objects in the archive get the
+		LC_UUID an executable would, and the archive member dates are
+		cleared. The link fails if there is no Go build ID (-buildid)
+		to derive the LC_UUID from and no flag sets it another way. Has
+		no effect on other platforms or with internal linking.
+	-reproduciblemtime
+		Set the access and modification times of the Mach-O output of
+		the external linker to $SOURCE_DATE_EPOCH if it is set, or to
+		the Unix epoch if not, for archives that record them. Has no
+		effect on other platforms or with internal linking.
+	-reproorder
+		Sort the load commands of the Mach-O output of the external
+		linker into a canonical order, for external linkers that emit
+		the same commands in an order that varies between runs. The
+		commands whose order matters, such as segments, dylib loads
+		and LC_RPATH, keep their relative order; the link fails if the
+		output has a command whose constraints are not known. Requires
+		external linking.
+	-repropasses passes
+		Run only the post-link Mach-O passes in the comma-separated
+		list passes: loadorder (see -reproorder), uuid (the LC_UUID
+		rewrite), buildversion, sourceversion, main, header,
+		timestamps and linkedit (see -reproducible), and label (see
+		-uuidlabel). Implies -reproducible; the loadorder, uuid and
+		label passes still need the flags that ask for them. Leaving
+		out uuid keeps the external linker's LC_UUID, for example when
+		a later signing step sets it. Requires external linking.
+	-reproreport file
+		Append to file a row for each change the LC_UUID rewrite and
+		the -reproducible passes made to the Mach-O output of the
+		external linker: the output path, the load command changed,
+		the file offset, and the old and new bytes in hex. Each pass
+		run also gets a row with its name and status: applied, or
+		skipped and why, as when the output has no command for it to
+		act on. The links of a multi-binary build can share one file,
+		to have a single record of every change made to make the
+		build reproducible. Later steps such as code signing are not
+		covered. Requires external linking.
+	-reproreportformat format
+		Write the -reproreport rows in format: csv (the default), with
+		a header row when the file is created, or json, with one
+		object per line.
+	-reprostrict
+		Fail the link, leaving the Mach-O output of the external
+		linker unchanged, if a post-link pass that runs (see
+		-reproducible and -repropasses) finds nothing to act on, such
+		as the buildversion pass on an output with no
+		LC_BUILD_VERSION. Requires external linking.
+	-rsrc file
+		Add the resources of file, a compiled resource (.res) file as
+		rc and windres write, or a COFF object with a .rsrc section, to
+		the .rsrc section of a PE file, such as manifests, icons and
+		version info. May be given more than once. The resources of
+		the files and of the .rsrc sections of host objects, such as
+		.syso files, are merged into a single resource directory, and
+		the link fails if two of them have the same type, name and
+		language. With external linking, the resources of the files
+		are passed to the external linker in a COFF object.
 	-s
 		Omit the symbol table and debug information.
+	-size-baseline file
+		When the link exceeds -max-binary-size or -max-section-size,
+		print how the sizes of the sections, of the kinds of symbol
+		and of the packages, with their kinds, changed since the link
+		whose -json-report is file, largest change first.
+	-soname name
+		Set the DT_SONAME of the ELF output, such as a shared library
+		of -buildmode=c-shared, to name. When linking externally,
+		pass it on to the external linker with -soname.
+	-split-dwarf file
+		Write the DWARF debug information to file instead of into the
+		output, which is left without it. For ELF and PE, file is a
+		copy of the output as linked, and the output gets a
+		.gnu_debuglink section naming file, which debuggers use to
+		find it; an ELF GNU build ID note (see -B) is in both, for
+		lookup by build ID. For Mach-O, file is a dSYM bundle, such as
+		prog.dSYM, and the output is signed again if it was signed.
+		Requires internal linking.
+	-splitcold
+		With -pgo, place the functions that the profile does not see
+		called, and -symbol-ordering-file does not list, in a
+		.text.unlikely section after .text, starting on a page of its
+		own, so that the code that runs is packed into fewer pages.
+		Only supported for ELF on amd64 and arm64, linking internally.
+	-static-pie
+		With -buildmode=pie, write an ELF executable that needs no
+		dynamic linker: it has no interpreter, and its entry point
+		applies the relocations of its .rela and .relr.dyn sections
+		before the program starts. Linking internally, this works on
+		linux/amd64 and linux/arm64, for programs with no dynamic
+		imports. With -linkmode=external, as on linux/riscv64, pass
+		-static-pie to the external linker instead of -pie, which
+		links the C library statically.
+	-streamout
+		Write the output file as a stream, through a buffer of a few
+		megabytes, instead of mapping it into memory, for builders
+		with little memory or file systems that cannot map files. The
+		output is the same, but it is written by a single goroutine.
+		The linker also writes the output this way, without the flag,
+		if the output file cannot be mapped.
+	-strip kinds
+		Strip the comma-separated kinds from the output, as a finer
+		form of -s and -w, with which it cannot be combined: symtab,
+		the symbol table, leaving the DWARF unlike -s; dwarf, all of
+		the DWARF, as -w does; lines, the rows of the DWARF line
+		tables, keeping their file tables, which the rest of the DWARF
+		refers to; frame, the .debug_frame section; and all, both the
+		symbol table and the DWARF. The function names and line
+		tables the runtime uses for tracebacks are always kept.
+	-symbol-ordering-file file
+		Place the functions named in file, one on each line, first in
+		the text segment, and the data named in it first in their
+		sections, in the order listed, as lld's --symbol-ordering-file
+		does. Blank lines and lines starting with # are ignored. The
+		linker warns of the names that are neither functions nor data
+		of the link. With -pgo, the functions the profile orders
+		follow those of file.
 	-tmpdir dir
 		Write temporary files to dir.
 		Temporary files are only used in external linking mode.
+	-trace file
+		Write the wall time, the bytes and number of allocations, the
+		live or in-use heap, the peak resident set size and the
+		counts of symbols, sections and host objects at the end of
+		each phase of the link, the phases that -benchmark measures,
+		to file, for the performance of links to be tracked across
+		builds and releases.
+	-traceformat format
+		Write the -trace file as JSON, the default, which has a
+		version field and keeps its fields from release to release,
+		or, for format go, as a runtime/trace execution trace, in
+		which each phase of the link is a region, for go tool trace.
+	-tramp mode
+		Set when direct calls and jumps go through a trampoline, a
+		stub that reaches any address, on arm, arm64, loong64, ppc64
+		and riscv64, whose call instructions have a limited range.
+		With auto, the default, calls whose target is out of range
+		use one. With always, every call between packages uses one,
+		to test programs as if they were too large for direct calls.
+		With never, none are inserted and a call that is out of range
+		fails to link; not supported on riscv64, whose calls need
+		trampolines to reach beyond 1MB.
+	-undefs
+		Report every undefined symbol, grouped by the package or host
+		object whose code refers to it, with the site of each
+		reference: the referring symbol, the offset and type of the
+		relocation and, for a Go function, the source line. Without
+		it, the link reports each undefined symbol once per referring
+		symbol and stops after 20 errors.
+	-uuidcontent
+		Derive the LC_UUID from the content ID of the Go build ID, its
+		last slash-separated component, instead of the whole build ID,
+		so that binaries with the same content get the same UUID however
+		the build reached them. Can be combined with -uuidpgo,
+		-uuidimage and -uuidpercpu.
+	-uuidexplain
+		Print to standard output how the LC_UUID of the Mach-O output
+		was derived: the Go build ID or other input, the hash, its
+		truncation to 16 bytes and the RFC 4122 version and variant
+		bits, ending with the UUID. This helps to compare the UUIDs of
+		two builds. Only supported when linking for darwin or ios.
+	-uuidfrom file
+		Set the LC_UUID of the Mach-O output to that of the existing
+		Mach-O file, which may be the output of a previous link to the
+		same path, instead of deriving it from the Go build ID. This
+		keeps symbol servers matching across relinks that only change
+		debug information. The architectures of a fat file must share
+		one UUID, and the file must be of the same type, executable or
+		dylib, as the output. Cannot be combined with -macho-uuid.
+	-uuidfromcode sections
+		Derive the LC_UUID of the Mach-O output from a hash of the
+		contents of sections instead of the Go build ID. sections is
+		a comma-separated list of "text" (__TEXT,__text) and "data"
+		(__DATA,__data). Requires external linking.
+	-uuidfromconvert
+		Let -uuidfrom carry the LC_UUID of an executable to a dylib
+		output, or of a dylib to an executable, for a module relinked
+		in the other form that should keep its UUID.
+	-uuidimage digest
+		Salt the LC_UUID derived from the Go build ID with digest, the
+		digest of the container image holding the toolchain, such as
+		sha256: followed by 64 hex digits. Binaries built from the same
+		build ID in different toolchain images get distinct UUIDs, and
+		still get the same UUID on every link within one image. The
+		default is $GO_IMAGE_DIGEST, if set. Can be combined with
+		-uuidpgo and -uuidpercpu.
+	-uuidinfo
+		Write a JSON record of how the LC_UUID of the Mach-O output was
+		derived to the output path with ".uuidinfo" appended: the Go
+		build ID, the algorithm ("buildid", or the flag that set the
+		UUID otherwise), the RFC 4122 version and salts, and for each
+		architecture the hashed input, the math/rand seed of a version
+		4 UUID and the UUID. Unlike -uuidexplain the record is meant to
+		be kept with the binary, for checking its UUID against the
+		inputs it was derived from. Only supported when linking for
+		darwin or ios.
+	-uuidinsert
+		Add an LC_UUID load command to the Mach-O output of the
+		external linker if it has none, as when ld64 is passed
+		-no_uuid, with the UUID the link would otherwise set. The
+		command is placed in the header padding; the link fails
+		rather than move section data if there is no room for it.
+		Cannot be combined with -uuidld64. Requires external linking.
+	-uuidjson
+		Print the LC_UUID of the Mach-O output to standard output as a
+		line of JSON: {"path": path, "arches": [{"cpu": "arm64",
+		"uuid": "01234567-89AB-CDEF-0123-456789ABCDEF"}]}, with one
+		entry per architecture. Only supported when linking for darwin
+		or ios.
+	-uuidlabel label
+		Add an LC_NOTE load command to the Mach-O output carrying label,
+		for example a module path and version, so that the LC_UUID can
+		be matched to a human-readable description. The command and
+		label are placed in the header padding, which must be large
+		enough to hold them. Requires external linking.
+	-uuidld64
+		Derive the LC_UUID of the Mach-O output from a SHA-256 hash of
+		the finished output, as ld64 derives its own UUID, for tools
+		that expect one computed that way. The hash covers the file up
+		to its code signature, with the LC_UUID itself zeroed. This is
+		an approximation: unlike ld64, it also hashes the stabs that
+		record object file paths and times, and the hash ld64 uses has
+		varied between versions. The other flags that set the LC_UUID
+		cannot be combined with it. Requires external linking.
+	-uuidmanifest file
+		Do not set the LC_UUID of the Mach-O output. Instead, write to
+		file the changes that setting it would make to the finished
+		output, including the code signature hashes, in the format of
+		-uuidpatch, for a separate build step to apply. Requires
+		external linking.
+	-uuidmanifestmin
+		With -uuidmanifest, write only the change to the LC_UUID
+		payload, 16 bytes at their offset, and not the code signature
+		hashes it invalidates. This is for sandboxed builds in which
+		the output is read-only to the linker and a separate,
+		privileged step applies the record and signs the binary.
+	-uuidout file
+		Write the LC_UUID of the Mach-O output to file, one line per
+		architecture in the format printed by "dwarfdump --uuid":
+		"UUID: 01234567-89AB-CDEF-0123-456789ABCDEF (arm64) path".
+		Only supported when linking for darwin or ios.
+	-uuidpatch file
+		Write to file a record of every byte the linker changed in the
+		external linker's output when setting its LC_UUID, including
+		the code signature hashes it updated, with the old and new
+		bytes and their file offsets. The record can be checked,
+		re-applied or reverted independently of the linker. It does
+		not cover later steps such as -reproducible or code signing.
+		Requires external linking.
+	-uuidpercpu
+		Salt the LC_UUID derived from the Go build ID with the cpu type
+		of the output, so that the architecture slices of a fat binary
+		built from the same build ID get distinct, still deterministic,
+		UUIDs, for symbol servers that index each slice separately. By
+		default every architecture gets the same UUID.
+	-uuidpgo file
+		Salt the LC_UUID derived from the Go build ID with a hash of
+		the contents of the PGO profile file, so that binaries built
+		from the same build ID with different profiles get distinct,
+		still deterministic, UUIDs. Where the profile is kept does not
+		matter. Can be combined with -uuidpercpu.
+	-uuidstore file
+		Record the LC_UUID derived from the Go build ID in file, a list
+		of build IDs and their UUIDs, and on later links with the same
+		build ID use the recorded UUID instead of deriving it again, so
+		that a rebuild keeps its UUID even if a newer toolchain derives
+		UUIDs differently. Links may share file concurrently; the first
+		UUID recorded for a build ID is the one they all use.
+	-uuidverify
+		After linking, check that the LC_UUID of the Mach-O output is
+		the one derived from the Go build ID embedded in it, and fail
+		if the two are out of sync. Cannot be combined with the flags
+		that set the UUID some other way.
+	-uuidversion version
+		Set the RFC 4122 version of the LC_UUID derived from the Go
+		build ID (see -B gobuildid). Version 3, the default, and version
+		5 take the UUID from a hash of the build ID; version 4 mixes
+		that hash with the output of a random number generator seeded
+		with it. All three are reproducible.
 	-v
 		Print trace of linker operations.
+	-verify-reproducible
+		Once the output is complete, link a second time, with the same
+		arguments, in a linker process of its own writing to a
+		temporary directory, and fail if the output of the two links
+		differs. The error gives the number of bytes that differ and
+		the sections and symbols that hold them. When linking
+		externally, the external linker is run again too, so that
+		nondeterminism of the host toolchain is caught as well. The
+		file of -split-dwarf is compared too; the reports and profiles
+		that other flags ask for are only written by the first link.
+	-version-script file
+		Give the dynamic symbols of the ELF output the versions of
+		the GNU version script file, which names the symbols, or
+		patterns of them, exported in each version, and those to
+		hide. Linking internally, the versions are written to the
+		.gnu.version_d section, after a base version named for
+		-soname or the output; other exports stay in the base
+		version. When linking externally, pass the script on to the
+		external linker with --version-script.
 	-w
 		Omit the DWARF symbol table.
+	-wasmnames
+		For wasm, name the host imports, the parameters and locals of
+		every function and the globals in the name section, as well
+		as the Go functions it names by default, for profilers and
+		tools such as wasm-opt. Locals that hold Go registers are
+		named by their type and index.
+	-wasmproducer field=name[@version]
+		For wasm, add the value name, with version, to field of the
+		producers section, where field is language, processed-by or
+		sdk. May be repeated.
+	-wasmsection name=file
+		For wasm, add a custom section called name with the contents
+		of file. May be repeated; the sections are written in order,
+		after the data section.
+	-wasmtargetfeatures features
+		For wasm, write a target_features section listing the
+		comma-separated features, such as sign-ext, each used by the
+		module or, if prefixed with a minus sign, disallowed in
+		modules linked with it.
+	-why-live symbol
+		Print why the deadcode pass kept symbol: the symbol that
+		first referred to it, the one that first referred to that,
+		and so on back to a root of the pass, such as the entry
+		point, an init task or a dynamic export.
+	-wrap symbol
+		As GNU ld --wrap does, make the references of host objects
+		to symbol refer to __wrap_symbol instead, and those to
+		__real_symbol refer to symbol, so that a function of a cgo
+		library can be interposed. __wrap_symbol may be defined in C
+		or exported from Go. May be repeated. With external linking,
+		the flag is passed on to the external linker, which then
+		applies it to the references of Go code too.
+	-z keyword
+		As GNU ld -z does, harden an ELF output by keyword: relro
+		puts the data that only dynamic relocations write, with the
+		GOT and the dynamic section, in a PT_GNU_RELRO segment, which
+		every build mode but exe does by default; norelro, which only
+		-buildmode=exe supports, leaves it out; now is -bindnow and
+		lazy undoes it. With -z relro -z now the GOT used by the PLT
+		is read-only too ("full RELRO"). The layout keywords are
+		max-page-size=size, which aligns the segments to size, a
+		power of two of at least 4096, as -R does, for kernels with
+		16K or 64K pages; and separate-code, which puts the ELF
+		headers, the notes and the interpreter name in a read-only
+		segment of their own, so that the executable segment holds
+		only code and no page is both executable and mapped for
+		anything else, and noseparate-code, the default, which undoes
+		it. The keywords ibt and shstk on 386 and amd64, and force-bti
+		on arm64, mark the output as compatible with the hardware
+		control-flow protection features of the same names in a
+		.note.gnu.property section, with a PT_GNU_PROPERTY header, so
+		that the loader enables them for the process. The Go code has
+		no landing pads for indirect branches and the runtime switches
+		stacks, so these keywords only assert what the code must be
+		for a loader that enforces the features, as with GNU ld; the
+		output otherwise has only the properties that the host objects
+		of an internal link all have. cet-report=report on 386 and
+		amd64, and bti-report=report on arm64, report the host objects
+		without the IBT and SHSTK, or BTI, properties the keywords do
+		not assert, as warnings for report warning, as errors for
+		error, or not at all for none, the default. May be repeated;
+		the last keyword of each pair wins. With external linking, the
+		keywords are passed on to the external linker.
 */
 package main
@@ -372,6 +372,12 @@ func adddynrel(target *ld.Target, ldr *loader.Loader, syms *ld.ArchSyms, s loade
 			// AddAddrPlus is used for r_offset and r_addend to
 			// generate new R_ADDR relocations that will update
 			// these fields in the 'reloc' phase.
+			if r.Siz() == 8 && ld.ElfAddRelr(target, s, int64(r.Off())) {
+				// The pointer holds its link-time value, as
+				// for Mach-O below, which is all .relr.dyn
+				// needs.
+				return true
+			}
 			rela := ldr.MakeSymbolUpdater(syms.Rela)
 			rela.AddAddrPlus(target.Arch, s, int64(r.Off()))
 			if r.Siz() == 8 {
@@ -46,6 +46,7 @@ func Init() (*sys.Arch, ld.Arch) {
 		Dwarfregsp: dwarfRegSP,
 		Dwarfreglr: dwarfRegLR,
 		TrampLimit: 0x1c00000, // 24-bit signed offset * 4, leave room for PLT etc.
+		TrampSize:  20,        // Trampolines in ARM range from 3 to 5 instructions.
 
 		Plan9Magic: 0x647,
 
@@ -0,0 +1,54 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arm64
+
+import (
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	"cmd/link/internal/ld"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"testing"
+)
+
+// TestTrampoline checks that a call gets a trampoline only once its
+// target is out of the range of a BL.
+func TestTrampoline(t *testing.T) {
+	const caller = 1 << 28
+	for _, tt := range []struct {
+		off   int64 // of the callee from the call
+		tramp bool
+	}{
+		{1<<27 - 4, false},
+		{1 << 27, true},
+		{-1 << 27, false},
+		{-1<<27 - 4, true},
+	} {
+		ldr := loader.NewLoader(0, &loader.ErrorReporter{})
+		ctxt := &ld.Link{Target: ld.Target{Arch: sys.ArchARM64, LinkMode: ld.LinkInternal}}
+		text := func(name string, value int64) loader.Sym {
+			s := ldr.CreateExtSym(name, 0)
+			sb := ldr.MakeSymbolUpdater(s)
+			sb.SetType(sym.STEXT)
+			sb.SetReachable(true)
+			sb.SetSize(8)
+			sb.SetValue(value)
+			ldr.SetSymPkg(s, "main")
+			return s
+		}
+		callee := text("main.callee", caller+4+tt.off)
+		s := text("main.caller", caller)
+		r, _ := ldr.MakeSymbolUpdater(s).AddRel(objabi.R_CALLARM64)
+		r.SetOff(4)
+		r.SetSiz(4)
+		r.SetSym(callee)
+
+		trampoline(ctxt, ldr, 0, callee, s)
+		relocs := ldr.Relocs(s)
+		if got := relocs.At(0).Sym() != callee; got != tt.tramp {
+			t.Errorf("call of offset %#x: trampoline %v, want %v", tt.off, got, tt.tramp)
+		}
+	}
+}
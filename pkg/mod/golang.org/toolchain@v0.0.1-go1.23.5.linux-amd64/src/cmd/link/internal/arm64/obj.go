@@ -46,6 +46,7 @@ func Init() (*sys.Arch, ld.Arch) {
 		Dwarfregsp: dwarfRegSP,
 		Dwarfreglr: dwarfRegLR,
 		TrampLimit: 0x7c00000, // 26-bit signed offset * 4, leave room for PLT etc.
+		TrampSize:  12,        // Trampolines in ARM64 are 3 instructions.
 
 		Adddynrel:        adddynrel,
 		Archinit:         archinit,
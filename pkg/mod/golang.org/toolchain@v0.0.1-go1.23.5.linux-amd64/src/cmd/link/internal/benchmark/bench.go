@@ -10,11 +10,13 @@
 package benchmark
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"runtime/trace"
 	"time"
 	"unicode"
 )
@@ -32,12 +34,31 @@ type Metrics struct {
 	curMark   *mark
 	filebase  string
 	pprofFile *os.File
+	counts    func() map[string]int64
 }
 
 type mark struct {
 	name              string
 	startM, endM, gcM runtime.MemStats
 	startT, endT      time.Time
+	peakRSS           uint64
+	counts            map[string]int64
+	region            *trace.Region
+}
+
+// A Phase is the measurements of a phase, as Phases returns them.
+type Phase struct {
+	Name       string `json:"name"`
+	WallNs     int64  `json:"wall_ns"`
+	AllocBytes uint64 `json:"alloc_bytes"`
+	Allocs     uint64 `json:"allocs"`
+	// HeapBytes is the live heap after a GC at the end of the phase
+	// with GC, and the heap in use at its end without.
+	HeapBytes uint64 `json:"heap_bytes"`
+	// PeakRSS is the peak resident set size of the process by the end
+	// of the phase, or 0 where it is not known.
+	PeakRSS uint64           `json:"peak_rss_bytes,omitempty"`
+	Counts  map[string]int64 `json:"counts,omitempty"`
 }
 
 // New creates a new Metrics object.
@@ -106,6 +127,41 @@ func (m *Metrics) Report(w io.Writer) {
 	fmt.Fprintf(w, "%s 1 %d ns/op\n", makeBenchString("total time"+gcString), totTime.Nanoseconds())
 }
 
+// SetCounts makes counts give the counts, such as of symbols, to record
+// at the end of each phase.
+func (m *Metrics) SetCounts(counts func() map[string]int64) {
+	if m == nil {
+		return
+	}
+	m.counts = counts
+}
+
+// Phases returns the measurements of the phases in order.
+// Closes the currently Start(ed) range.
+func (m *Metrics) Phases() []Phase {
+	if m == nil {
+		return nil
+	}
+	m.closeMark()
+	var phases []Phase
+	for _, mk := range m.marks {
+		p := Phase{
+			Name:       mk.name,
+			WallNs:     mk.endT.Sub(mk.startT).Nanoseconds(),
+			AllocBytes: mk.endM.TotalAlloc - mk.startM.TotalAlloc,
+			Allocs:     mk.endM.Mallocs - mk.startM.Mallocs,
+			HeapBytes:  mk.endM.HeapAlloc,
+			PeakRSS:    mk.peakRSS,
+			Counts:     mk.counts,
+		}
+		if m.gc == GC {
+			p.HeapBytes = mk.gcM.HeapAlloc
+		}
+		phases = append(phases, p)
+	}
+	return phases
+}
+
 // Start marks the beginning of a new measurement phase.
 // Once a metric is started, it continues until either a Report is issued, or another Start is called.
 func (m *Metrics) Start(name string) {
@@ -126,6 +182,9 @@ func (m *Metrics) Start(name string) {
 		}
 	}
 	runtime.ReadMemStats(&m.curMark.startM)
+	if trace.IsEnabled() {
+		m.curMark.region = trace.StartRegion(context.Background(), name)
+	}
 	m.curMark.startT = time.Now()
 }
 
@@ -134,12 +193,19 @@ func (m *Metrics) closeMark() {
 		return
 	}
 	m.curMark.endT = time.Now()
+	if m.curMark.region != nil {
+		m.curMark.region.End()
+	}
 	if m.shouldPProf() {
 		pprof.StopCPUProfile()
 		m.pprofFile.Close()
 		m.pprofFile = nil
 	}
 	runtime.ReadMemStats(&m.curMark.endM)
+	m.curMark.peakRSS = peakRSS()
+	if m.counts != nil {
+		m.curMark.counts = m.counts()
+	}
 	if m.gc == GC {
 		runtime.GC()
 		runtime.ReadMemStats(&m.curMark.gcM)
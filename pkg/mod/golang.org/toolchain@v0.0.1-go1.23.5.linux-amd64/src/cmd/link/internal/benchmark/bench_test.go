@@ -52,3 +52,33 @@ func TestNilBenchmarkObject(t *testing.T) {
 	b.Start("TEST")
 	b.Report(nil)
 }
+
+func TestPhases(t *testing.T) {
+	b := New(NoGC, "")
+	n := int64(0)
+	b.SetCounts(func() map[string]int64 {
+		n++
+		return map[string]int64{"n": n}
+	})
+	b.Start("one")
+	sink = make([]byte, 1<<20)
+	b.Start("two")
+	phases := b.Phases()
+	if len(phases) != 2 || phases[0].Name != "one" || phases[1].Name != "two" {
+		t.Fatalf("got phases %+v, want one and two", phases)
+	}
+	if phases[0].AllocBytes < 1<<20 || phases[0].Allocs == 0 {
+		t.Errorf("phase one allocated %d bytes in %d allocations, want at least 1MB", phases[0].AllocBytes, phases[0].Allocs)
+	}
+	for i, p := range phases {
+		if p.WallNs < 0 || p.Counts["n"] != int64(i+1) {
+			t.Errorf("phase %s: wall time %d ns, counts %v", p.Name, p.WallNs, p.Counts)
+		}
+	}
+	var nilMetrics *Metrics
+	if p := nilMetrics.Phases(); p != nil {
+		t.Errorf("nil Metrics has phases %v", p)
+	}
+}
+
+var sink []byte
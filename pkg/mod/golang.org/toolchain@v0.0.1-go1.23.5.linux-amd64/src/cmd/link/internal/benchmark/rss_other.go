@@ -0,0 +1,12 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package benchmark
+
+// peakRSS returns 0, as the peak resident set size is not known.
+func peakRSS() uint64 {
+	return 0
+}
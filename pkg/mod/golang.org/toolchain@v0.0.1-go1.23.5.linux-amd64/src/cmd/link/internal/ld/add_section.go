@@ -0,0 +1,198 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -add-section and -add-note, which put the contents
+// of files, such as an SBOM or the .note.package descriptor of systemd,
+// into ELF and Mach-O output as read-only sections of their own. Unlike
+// sections added by objcopy after the link, they are laid out with the
+// rest of the output, so that they are mapped by its read-only segment
+// and, for notes, by a PT_NOTE program header, and the build ID and
+// code signature cover them.
+
+import (
+	"cmd/link/internal/sym"
+	"debug/elf"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An addedSection is a section that -add-section or -add-note adds to
+// the output.
+type addedSection struct {
+	name  string // for Mach-O, segment,section
+	data  []byte // the contents given by -add-section
+	notes []addedNote
+}
+
+// An addedNote is a note that -add-note adds to an ELF SHT_NOTE
+// section.
+type addedNote struct {
+	owner string
+	typ   uint32
+	desc  []byte
+}
+
+// addedSections are the sections given by -add-section and -add-note,
+// in the order first given.
+var addedSections []*addedSection
+
+// addedSectionNamed returns the section of addedSections called name,
+// or nil.
+func addedSectionNamed(name string) *addedSection {
+	for _, s := range addedSections {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// addSection handles -add-section name=file.
+func addSection(s string) {
+	name, file, ok := strings.Cut(s, "=")
+	if !ok || name == "" || file == "" {
+		Exitf("-add-section: %q is not of the form name=file", s)
+	}
+	if addedSectionNamed(name) != nil {
+		Exitf("-add-section: section %s is given twice", name)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		Exitf("-add-section: %v", err)
+	}
+	addedSections = append(addedSections, &addedSection{name: name, data: data})
+}
+
+// addNote handles -add-note section:owner:type=file.
+func addNote(s string) {
+	if err := parseAddedNote(s); err != nil {
+		Exitf("-add-note: %v", err)
+	}
+}
+
+// parseAddedNote parses the section:owner:type=file argument of
+// -add-note and adds the note to its section of addedSections. The
+// notes given for the same section are written in order.
+func parseAddedNote(s string) error {
+	key, file, ok := strings.Cut(s, "=")
+	f := strings.Split(key, ":")
+	if !ok || file == "" || len(f) != 3 || f[0] == "" || f[1] == "" {
+		return fmt.Errorf("%q is not of the form section:owner:type=file", s)
+	}
+	typ, err := strconv.ParseUint(f[2], 0, 32)
+	if err != nil {
+		return fmt.Errorf("note type %q is not a 32-bit number", f[2])
+	}
+	desc, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	sect := addedSectionNamed(f[0])
+	if sect == nil {
+		sect = &addedSection{name: f[0]}
+		addedSections = append(addedSections, sect)
+	} else if sect.notes == nil {
+		return fmt.Errorf("section %s is also given to -add-section", f[0])
+	}
+	sect.notes = append(sect.notes, addedNote{f[1], uint32(typ), desc})
+	return nil
+}
+
+// checkAddedSections checks that the output format takes the sections
+// of -add-section and -add-note: any name for ELF, and a __TEXT section
+// given as __TEXT,section for Mach-O, which has no notes.
+func checkAddedSections(ctxt *Link) {
+	for _, s := range addedSections {
+		switch {
+		case ctxt.IsELF:
+		case ctxt.IsDarwin():
+			if s.notes != nil {
+				Exitf("-add-note is only supported for ELF")
+			}
+			seg, sect, _ := strings.Cut(s.name, ",")
+			if seg != "__TEXT" || sect == "" || len(sect) > 16 {
+				Exitf("-add-section: Mach-O section %q is not of the form __TEXT,section with a section name of at most 16 bytes", s.name)
+			}
+		default:
+			Exitf("-add-section and -add-note are only supported for ELF and Mach-O")
+		}
+	}
+}
+
+// addSectionSyms creates a symbol for each section of addedSections,
+// which dodata gives a section of its own in the read-only data.
+func addSectionSyms(ctxt *Link) {
+	ldr := ctxt.loader
+	for _, s := range addedSections {
+		if ldr.Lookup(s.name, 0) != 0 {
+			Exitf("-add-section: the output already has a section %s", s.name)
+		}
+		sb := ldr.CreateSymForUpdate(s.name, 0)
+		sb.SetType(sym.SELFROSECT)
+		sb.SetReachable(true)
+		if s.notes == nil {
+			sb.AddBytes(s.data)
+			sb.SetAlign(1)
+			continue
+		}
+		for _, n := range s.notes {
+			sb.AddUint32(ctxt.Arch, uint32(len(n.owner)+1))
+			sb.AddUint32(ctxt.Arch, uint32(len(n.desc)))
+			sb.AddUint32(ctxt.Arch, n.typ)
+			sb.AddBytes([]byte(n.owner))
+			sb.AddUint8(0)
+			for len(sb.Data())%4 != 0 {
+				sb.AddUint8(0)
+			}
+			sb.AddBytes(n.desc)
+			for len(sb.Data())%4 != 0 {
+				sb.AddUint8(0)
+			}
+		}
+		sb.SetAlign(4)
+	}
+}
+
+// elfAddSections adds the names of the sections of addedSections to
+// .shstrtab, with those of their relocation sections when linking
+// externally, and creates their symbols. It is called by doelf once the
+// names of the sections the linker writes itself are added.
+func elfAddSections(ctxt *Link, shstrtabAddstring func(string)) {
+	for _, s := range addedSections {
+		for i := 0; i < nelfstr; i++ {
+			if elfstr[i].s == s.name {
+				Exitf("-add-section: the linker writes the %s section itself", s.name)
+			}
+		}
+		shstrtabAddstring(s.name)
+		if ctxt.IsExternal() && s.notes == nil {
+			shstrtabAddstring(elfRelType + s.name)
+		}
+	}
+	addSectionSyms(ctxt)
+}
+
+// elfAddNoteHeaders makes the sections of -add-note SHT_NOTE sections
+// and, when linking internally, covers each with a PT_NOTE program
+// header. It is called by asmbElf once the section headers of the
+// segments are set up.
+func elfAddNoteHeaders(ctxt *Link) {
+	for _, s := range addedSections {
+		if s.notes == nil {
+			continue
+		}
+		sh := elfshname(s.name)
+		sh.Type = uint32(elf.SHT_NOTE)
+		if ctxt.IsInternal() {
+			ph := newElfPhdr()
+			ph.Type = elf.PT_NOTE
+			ph.Flags = elf.PF_R
+			phsh(ph, sh)
+		}
+	}
+}
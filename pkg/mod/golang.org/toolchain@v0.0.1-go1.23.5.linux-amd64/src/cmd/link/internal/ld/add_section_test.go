@@ -0,0 +1,145 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAddedNote(t *testing.T) {
+	defer func(s []*addedSection) { addedSections = s }(addedSections)
+	addedSections = nil
+
+	file := filepath.Join(t.TempDir(), "desc")
+	if err := os.WriteFile(file, []byte("desc"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{".note.a:FDO:0xcafe1a7e=", ".note.b:Go:1=", ".note.a:GNU:5="} {
+		if err := parseAddedNote(s + file); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+	want := []*addedSection{
+		{name: ".note.a", notes: []addedNote{{"FDO", 0xcafe1a7e, []byte("desc")}, {"GNU", 5, []byte("desc")}}},
+		{name: ".note.b", notes: []addedNote{{"Go", 1, []byte("desc")}}},
+	}
+	if !reflect.DeepEqual(addedSections, want) {
+		t.Errorf("got %+v, want %+v", addedSections, want)
+	}
+
+	addedSections = append(addedSections, &addedSection{name: ".sbom"})
+	for _, s := range []string{
+		".note.a:FDO=" + file,
+		".note.a:FDO:1",
+		":FDO:1=" + file,
+		".note.a::1=" + file,
+		".note.a:FDO:0x100000000=" + file,
+		".note.a:FDO:1=" + file + ".missing",
+		".sbom:FDO:1=" + file,
+	} {
+		if err := parseAddedNote(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+// TestAddSection links with -add-section and -add-note for linux and
+// darwin and checks the sections of the output and how it maps them.
+func TestAddSection(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64 and darwin/arm64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	sbom := []byte("an SBOM")
+	pkg := []byte(`{"type":"rpm","name":"hello"}` + "\x00")
+	for name, data := range map[string][]byte{"sbom": sbom, "pkg": pkg} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := func(goos, goarch, ldflags string) string {
+		out := filepath.Join(dir, goos+".out")
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags="+ldflags, "-o", out, src)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		return out
+	}
+
+	f, err := elf.Open(build("linux", "amd64", "-add-section=.sbom=sbom -add-note=.note.package:FDO:0xcafe1a7e=pkg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// mapped returns the program header of type typ that maps s.
+	mapped := func(s *elf.Section, typ elf.ProgType) *elf.Prog {
+		for _, p := range f.Progs {
+			if p.Type == typ && p.Vaddr <= s.Addr && s.Addr+s.Size <= p.Vaddr+p.Memsz && p.Off <= s.Offset && s.Offset+s.Size <= p.Off+p.Filesz {
+				return p
+			}
+		}
+		return nil
+	}
+	s := f.Section(".sbom")
+	if s == nil {
+		t.Fatal("no .sbom section")
+	}
+	if b, err := s.Data(); err != nil || !bytes.Equal(b, sbom) || s.Type != elf.SHT_PROGBITS || s.Flags != elf.SHF_ALLOC {
+		t.Errorf(".sbom: %v, %v, %q, %v", s.Type, s.Flags, b, err)
+	}
+	if p := mapped(s, elf.PT_LOAD); p == nil || p.Flags != elf.PF_R {
+		t.Errorf(".sbom is not mapped by a read-only PT_LOAD: %v", p)
+	}
+	s = f.Section(".note.package")
+	if s == nil {
+		t.Fatal("no .note.package section")
+	}
+	b, err := s.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	want := le.AppendUint32(nil, 4)
+	want = le.AppendUint32(want, uint32(len(pkg)))
+	want = le.AppendUint32(want, 0xcafe1a7e)
+	want = append(append(want, "FDO\x00"...), pkg...)
+	want = append(want, make([]byte, -len(want)&3)...)
+	if !bytes.Equal(b, want) || s.Type != elf.SHT_NOTE || s.Addralign != 4 {
+		t.Errorf(".note.package: %v, align %d, %q, want %q", s.Type, s.Addralign, b, want)
+	}
+	if mapped(s, elf.PT_LOAD) == nil || mapped(s, elf.PT_NOTE) == nil {
+		t.Errorf(".note.package is not mapped by PT_LOAD and PT_NOTE program headers")
+	}
+
+	m, err := macho.Open(build("darwin", "arm64", "-add-section=__TEXT,__sbom=sbom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ms := m.Section("__sbom")
+	if ms == nil || ms.Seg != "__TEXT" {
+		t.Fatalf("no __TEXT,__sbom section: %+v", ms)
+	}
+	if b, err := ms.Data(); err != nil || !bytes.Equal(b, sbom) {
+		t.Errorf("__sbom: %q, %v", b, err)
+	}
+}
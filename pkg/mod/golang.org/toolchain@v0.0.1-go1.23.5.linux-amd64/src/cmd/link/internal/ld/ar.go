@@ -40,6 +40,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -49,7 +50,8 @@ const (
 )
 
 const (
-	ARMAG = "!<arch>\n"
+	ARMAG     = "!<arch>\n"
+	ARMAGTHIN = "!<thin>\n" // GNU thin archive, as written by ar -T
 )
 
 type ArHdr struct {
@@ -100,6 +102,10 @@ func pruneUndefsForWindows(ldr *loader.Loader, undefs, froms []loader.Sym) ([]lo
 // file, but it has an armap listing symbols and the objects that
 // define them. This is used for the compiler support library
 // libgcc.a.
+//
+// The archive may be a GNU thin archive, whose members hold only the
+// path of the object, relative to the directory of the archive unless
+// absolute, as some build systems write them.
 func hostArchive(ctxt *Link, name string) {
 	if ctxt.Debugvlog > 1 {
 		ctxt.Logf("hostArchive(%s)\n", name)
@@ -122,7 +128,8 @@ func hostArchive(ctxt *Link, name string) {
 		Exitf("file %s too short", name)
 	}
 
-	if string(magbuf[:]) != ARMAG {
+	thin := string(magbuf[:]) == ARMAGTHIN
+	if !thin && string(magbuf[:]) != ARMAG {
 		Exitf("%s is not an archive file", name)
 	}
 
@@ -139,6 +146,16 @@ func hostArchive(ctxt *Link, name string) {
 		Exitf("%s missing armap", name)
 	}
 
+	// The long member names follow the armap. A thin archive names
+	// all of its members there.
+	var longNames []byte
+	if l := nextar(f, f.Offset(), &arhdr); l > 0 && arhdr.name == "//" {
+		longNames = make([]byte, atolwhex(arhdr.size))
+		if _, err := io.ReadFull(f, longNames); err != nil {
+			Exitf("short read from %s", name)
+		}
+	}
+
 	loaded := make(map[uint64]bool)
 	any := true
 	for any {
@@ -164,7 +181,14 @@ func hostArchive(ctxt *Link, name string) {
 			if l <= 0 {
 				Exitf("%s missing archive entry at offset %d", name, off)
 			}
-			pname := fmt.Sprintf("%s(%s)", name, arhdr.name)
+			member := arMemberName(arhdr.name, longNames)
+			if member == "" {
+				if thin {
+					Exitf("%s: bad member name %q at offset %d", name, arhdr.name, off)
+				}
+				member = arhdr.name
+			}
+			pname := fmt.Sprintf("%s(%s)", name, member)
 			l = atolwhex(arhdr.size)
 
 			pkname := filepath.Base(name)
@@ -172,6 +196,10 @@ func hostArchive(ctxt *Link, name string) {
 				pkname = pkname[:i]
 			}
 			libar := sym.Library{Pkg: pkname}
+			if thin {
+				hostArchiveThinMember(ctxt, &libar, l, pname, thinMemberPath(name, member))
+				continue
+			}
 			h := ldobj(ctxt, f, &libar, l, pname, name)
 			if h.ld == nil {
 				Errorf(nil, "%s unrecognized object file at offset %d", name, off)
@@ -188,6 +216,56 @@ func hostArchive(ctxt *Link, name string) {
 	}
 }
 
+// hostArchiveThinMember links in the object at path, of size bytes,
+// that a member of a thin archive refers to.
+func hostArchiveThinMember(ctxt *Link, lib *sym.Library, size int64, pname, path string) {
+	f, err := bio.Open(path)
+	if err != nil {
+		Exitf("cannot open %s: %v", pname, err)
+	}
+	defer f.Close()
+	if n := f.MustSeek(0, 2); n != size {
+		Exitf("%s: %s is %d bytes, but the archive says %d; was it rebuilt without the archive?", pname, path, n, size)
+	}
+	f.MustSeek(0, 0)
+	h := ldobj(ctxt, f, lib, size, pname, path)
+	if h.ld == nil {
+		Errorf(nil, "%s: unrecognized object file %s", pname, path)
+		return
+	}
+	f.MustSeek(h.off, 0)
+	h.ld(ctxt, f, h.pkg, h.length, h.pn)
+	if *flagCaptureHostObjs != "" {
+		captureHostObj(h)
+	}
+}
+
+// arMemberName returns the name of an archive member from the name
+// field of its header: the name itself, with the slash GNU ar ends it
+// with removed, or, for "/offset", the name at offset in longNames,
+// the contents of the "//" member. It returns "" for a bad offset.
+func arMemberName(name string, longNames []byte) string {
+	off, ok := strings.CutPrefix(name, "/")
+	if !ok || off == "" || off == "/" {
+		return strings.TrimSuffix(name, "/")
+	}
+	i, err := strconv.Atoi(off)
+	if err != nil || i < 0 || i >= len(longNames) {
+		return ""
+	}
+	n, _, _ := strings.Cut(string(longNames[i:]), "\n")
+	return strings.TrimSuffix(n, "/")
+}
+
+// thinMemberPath returns the path of the object that member of the
+// thin archive at archive refers to.
+func thinMemberPath(archive, member string) string {
+	if filepath.IsAbs(member) {
+		return member
+	}
+	return filepath.Join(filepath.Dir(archive), member)
+}
+
 // archiveMap is an archive symbol map: a mapping from symbol name to
 // offset within the archive file.
 type archiveMap map[string]uint64
@@ -0,0 +1,122 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"internal/testenv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestArMemberName(t *testing.T) {
+	longNames := []byte("lib/a.o/\nsub/a_very_long_object_name.o/\n")
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"a.o/", "a.o"},
+		{"a.o", "a.o"},
+		{"/0", "lib/a.o"},
+		{"/9", "sub/a_very_long_object_name.o"},
+		{"/", ""},
+		{"//", "/"},
+		{"/99", ""},
+		{"/x", ""},
+	} {
+		if got := arMemberName(tt.name, longNames); got != tt.want {
+			t.Errorf("arMemberName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestThinMemberPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("paths are Unix ones")
+	}
+	for _, tt := range []struct {
+		archive, member, want string
+	}{
+		{"/build/out/libx.a", "obj/a.o", "/build/out/obj/a.o"},
+		{"/build/out/libx.a", "../obj/a.o", "/build/obj/a.o"},
+		{"/build/out/libx.a", "/abs/a.o", "/abs/a.o"},
+		{"libx.a", "a.o", "a.o"},
+	} {
+		if got := thinMemberPath(tt.archive, tt.member); got != tt.want {
+			t.Errorf("thinMemberPath(%q, %q) = %q, want %q", tt.archive, tt.member, got, tt.want)
+		}
+	}
+}
+
+// TestHostArchiveThin links a cgo program internally against a thin
+// archive, made with ar -T in another directory than its objects, that
+// defines the C function the program calls.
+func TestHostArchiveThin(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	testenv.MustInternalLink(t, true)
+	if runtime.GOOS != "linux" {
+		t.Skip("needs GNU ar")
+	}
+	ar, err := exec.LookPath("ar")
+	if err != nil {
+		t.Skip("no ar")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	objdir := filepath.Join(dir, "obj")
+	libdir := filepath.Join(dir, "lib")
+	for _, d := range []string{objdir, libdir} {
+		if err := os.Mkdir(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	csrc := filepath.Join(objdir, "twice.c")
+	if err := os.WriteFile(csrc, []byte("int twice(int x) { return 2*x; }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out, err := testenv.Command(t, testenv.GoToolPath(t), "env", "CC").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := strings.Fields(string(out))
+	if len(cc) == 0 {
+		t.Skip("no C compiler")
+	}
+	obj := filepath.Join(objdir, "twice.o")
+	if out, err := testenv.Command(t, cc[0], append(cc[1:], "-c", "-o", obj, csrc)...).CombinedOutput(); err != nil {
+		t.Fatalf("compiling %s: %v\n%s", csrc, err, out)
+	}
+	lib := filepath.Join(libdir, "libtwice.a")
+	cmd := testenv.Command(t, ar, "rcT", lib, "../obj/twice.o")
+	cmd.Dir = libdir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(`package main
+
+// #cgo LDFLAGS: `+lib+`
+// int twice(int);
+import "C"
+
+func main() { println(C.twice(21)) }
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "x")
+	cmd = testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -libgcc="+lib, "-o", exe, src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	out, err = testenv.Command(t, exe).CombinedOutput()
+	if err != nil || string(out) != "42\n" {
+		t.Errorf("%s: %v: %q", exe, err, out)
+	}
+}
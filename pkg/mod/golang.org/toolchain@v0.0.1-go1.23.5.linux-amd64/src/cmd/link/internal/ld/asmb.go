@@ -41,7 +41,7 @@ func asmb(ctxt *Link) {
 	for _, sect := range Segtext.Sections {
 		offset := sect.Vaddr - Segtext.Vaddr + Segtext.Fileoff
 		// Handle text sections with Codeblk
-		if sect.Name == ".text" {
+		if isTextSect(sect) {
 			writeParallel(&wg, f, ctxt, offset, sect.Vaddr, sect.Length)
 		} else {
 			writeParallel(&wg, datblk, ctxt, offset, sect.Vaddr, sect.Length)
@@ -176,9 +176,14 @@ func sizeExtRelocs(ctxt *Link, relsize uint32) {
 		}
 	}
 	filesz := ctxt.Out.Offset() + sz
-	err := ctxt.Out.Mmap(uint64(filesz))
-	if err != nil {
-		Exitf("mapping output file failed: %v", err)
+	ctxt.mmapOutput(uint64(filesz))
+}
+
+// mmapOutput maps the output file with the given size, or, with
+// -streamout or if it cannot be mapped, writes it as a stream.
+func (ctxt *Link) mmapOutput(filesize uint64) {
+	if err := ctxt.Out.MmapOrStream(filesize, *flagStreamOut); err != nil && ctxt.Debugvlog != 0 {
+		ctxt.Logf("mapping output file failed, writing it as a stream: %v\n", err)
 	}
 }
 
@@ -0,0 +1,89 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"cmd/internal/bio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// bitcodeObjs are the host objects that are LLVM bitcode, as clang
+// writes with -flto. The external linker links them with link-time
+// optimization, so a link in auto mode is external if there are any.
+// Linking internally, each is compiled to a native object with the
+// host compiler first.
+var bitcodeObjs []string
+
+// isBitcode reports whether an object that starts with the bytes c1
+// to c4 is LLVM bitcode, either bare ("BC" 0xC0DE) or in the wrapper
+// that Apple's tools put around it (0x0B17C0DE, little-endian).
+func isBitcode(c1, c2, c3, c4 int) bool {
+	return c1 == 'B' && c2 == 'C' && c3 == 0xC0 && c4 == 0xDE ||
+		c1 == 0xDE && c2 == 0xC0 && c3 == 0x17 && c4 == 0x0B
+}
+
+// ldbitcode loads the LLVM bitcode object pn, of length bytes at the
+// offset of f, by compiling it to a native object with the host
+// compiler and loading that instead.
+func ldbitcode(ctxt *Link, f *bio.Reader, pkg string, length int64, pn string) {
+	native, err := compileBitcode(ctxt, f, length)
+	if err != nil {
+		Exitf("%s is LLVM bitcode, from C code built with -flto, which linking internally needs compiled to a native object: %v\n"+
+			"link externally (-linkmode=external), which does link-time optimization, or build the C code without -flto", pn, err)
+	}
+	defer os.Remove(native)
+	nf, err := bio.Open(native)
+	if err != nil {
+		Exitf("%s: %v", pn, err)
+	}
+	defer nf.Close()
+	c1, c2, c3, c4 := bgetc(nf), bgetc(nf), bgetc(nf), bgetc(nf)
+	ld := hostObjLoader(c1, c2, c3, c4)
+	if ld == nil {
+		Exitf("%s: compiling the LLVM bitcode with %s did not give a native object", pn, ctxt.extld()[0])
+	}
+	size := nf.MustSeek(0, 2)
+	nf.MustSeek(0, 0)
+	ld(ctxt, nf, pkg, size, pn)
+}
+
+// compileBitcode copies the LLVM bitcode object of length bytes at the
+// offset of f to a temporary file and compiles it with the host
+// compiler, returning the path of the native object.
+func compileBitcode(ctxt *Link, f *bio.Reader, length int64) (string, error) {
+	bc, err := os.CreateTemp(*flagTmpdir, "go-link-bitcode-*.bc")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(bc.Name())
+	if _, err := io.CopyN(bc, f, length); err != nil {
+		bc.Close()
+		return "", err
+	}
+	if err := bc.Close(); err != nil {
+		return "", err
+	}
+	native := bc.Name()[:len(bc.Name())-len(".bc")] + ".o"
+
+	extld := ctxt.extld()
+	argv := append(extld[1:len(extld):len(extld)], hostlinkArchArgs(ctxt.Arch)...)
+	argv = append(argv, "-c", "-x", "ir", "-o", native, bc.Name())
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("bitcode: %s %q\n", extld[0], argv)
+	}
+	cmd := exec.Command(extld[0], argv...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		os.Remove(native)
+		return "", fmt.Errorf("running %s failed: %v\n%s", extld[0], err, bytes.TrimSpace(out.Bytes()))
+	}
+	return native, nil
+}
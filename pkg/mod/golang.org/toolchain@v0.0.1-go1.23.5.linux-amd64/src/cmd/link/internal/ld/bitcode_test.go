@@ -0,0 +1,115 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"internal/testenv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestIsBitcode(t *testing.T) {
+	for _, tt := range []struct {
+		magic []byte
+		want  bool
+	}{
+		{[]byte{'B', 'C', 0xC0, 0xDE}, true},
+		{[]byte{0xDE, 0xC0, 0x17, 0x0B}, true},
+		{[]byte{0x7F, 'E', 'L', 'F'}, false},
+		{[]byte{'B', 'C', 0xC0, 0xDF}, false},
+		{[]byte{'g', 'o', ' ', 'o'}, false},
+	} {
+		m := tt.magic
+		if got := isBitcode(int(m[0]), int(m[1]), int(m[2]), int(m[3])); got != tt.want {
+			t.Errorf("isBitcode(% x) = %v, want %v", m, got, tt.want)
+		}
+	}
+}
+
+// fakeClang compiles LLVM IR as clang -c -x ir does, with llc, for
+// systems without clang.
+const fakeClang = `#!/bin/sh
+out= in=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-o) out=$2; shift;;
+	-c|-x|ir|-m64) ;;
+	*) in=$1;;
+	esac
+	shift
+done
+exec llc -filetype=obj -relocation-model=pic -o "$out" "$in"
+`
+
+// TestBitcodeInternal links a program with an LLVM bitcode .syso
+// internally, which compiles the bitcode to a native object with the
+// host compiler, and checks that it fails with an actionable error
+// when the host compiler cannot.
+func TestBitcodeInternal(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("test is linux/amd64 only")
+	}
+	for _, tool := range []string{"llvm-as", "llc"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("no %s", tool)
+		}
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module bitcode\n",
+		"main.go": "package main\n\nfunc get() int32\n\nfunc main() { println(get()) }\n",
+		"get_amd64.s": `#include "textflag.h"
+
+TEXT ·get(SB),NOSPLIT,$0-4
+	MOVL	answer(SB), AX
+	MOVL	AX, ret+0(FP)
+	RET
+`,
+		"answer.ll": "target triple = \"x86_64-pc-linux-gnu\"\n\n@answer = global i32 42\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out, err := testenv.Command(t, "llvm-as", "-o", filepath.Join(dir, "answer.syso"), filepath.Join(dir, "answer.ll")).CombinedOutput(); err != nil {
+		t.Fatalf("llvm-as: %v\n%s", err, out)
+	}
+	if err := os.Remove(filepath.Join(dir, "answer.ll")); err != nil {
+		t.Fatal(err)
+	}
+	cc := filepath.Join(t.TempDir(), "cc")
+	if err := os.WriteFile(cc, []byte(fakeClang), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := filepath.Join(dir, "x")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -extld="+cc, "-o", exe, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	out, err := testenv.Command(t, exe).CombinedOutput()
+	if err != nil || string(out) != "42\n" {
+		t.Errorf("%s: %v: %q", exe, err, out)
+	}
+
+	cmd = testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -extld=false", "-o", exe, ".")
+	cmd.Dir = dir
+	out, err = cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("%v: linking succeeded without a host compiler", cmd)
+	}
+	if want := "answer.syso) is LLVM bitcode"; !strings.Contains(string(out), want) {
+		t.Errorf("%v: output does not contain %q:\n%s", cmd, want, out)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the generation of the .BTF section of -btf, which
+// holds the types of the DWARF in BTF, the type format of the BPF
+// tooling of Linux, for the tools that read the types of a binary
+// without parsing DWARF, such as bpftool and the uprobe tracers.
+//
+// The types keep their Go names, which are not all C identifiers, so
+// the section is for tools reading the file: the kernel would not
+// accept it as the BTF of a program. The results of a func type are
+// out parameters in the DWARF, so its prototype returns void.
+
+import (
+	"cmd/internal/dwarf"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"fmt"
+)
+
+const (
+	btfMagic   = 0xeB9F
+	btfVersion = 1
+	btfHdrLen  = 24
+	btfMaxVlen = 0xffff
+)
+
+// Kinds of BTF types.
+const (
+	btfKindInt       = 1
+	btfKindPtr       = 2
+	btfKindArray     = 3
+	btfKindStruct    = 4
+	btfKindTypedef   = 8
+	btfKindFuncProto = 13
+	btfKindFloat     = 16
+)
+
+// Encodings of a BTF int.
+const (
+	btfIntSigned = 1 << 0
+	btfIntBool   = 1 << 2
+)
+
+// A btfType is a BTF type: its btf_type struct and what follows it, as
+// words, some of which are the IDs of the types of DIEs.
+type btfType struct {
+	words []uint32
+	refs  []btfRef
+}
+
+// A btfRef is a word of a btfType that holds the ID of the type of the
+// DIE symbol die, which is not known until all types are added.
+type btfRef struct {
+	word int
+	die  loader.Sym
+}
+
+type btfBuilder struct {
+	types  []btfType
+	strs   []byte
+	strOff map[string]uint32
+	ids    map[loader.Sym]uint32 // the types of the DIE symbols
+	floats map[uint32]uint32     // the float types by size
+}
+
+// str returns the offset of s in the string section.
+func (b *btfBuilder) str(s string) uint32 {
+	if s == "" {
+		return 0
+	}
+	if off, ok := b.strOff[s]; ok {
+		return off
+	}
+	off := uint32(len(b.strs))
+	b.strs = append(b.strs, s...)
+	b.strs = append(b.strs, 0)
+	b.strOff[s] = off
+	return off
+}
+
+// newType adds a type with the name and the kind and vlen of its info
+// word, and returns its ID. The size or type word and what follows it
+// are added with word and ref.
+func (b *btfBuilder) newType(name string, kind, vlen int) uint32 {
+	b.types = append(b.types, btfType{words: []uint32{b.str(name), uint32(kind)<<24 | uint32(vlen)}})
+	return uint32(len(b.types))
+}
+
+// word adds v to the last type.
+func (b *btfBuilder) word(v uint32) {
+	t := &b.types[len(b.types)-1]
+	t.words = append(t.words, v)
+}
+
+// ref adds the ID of the type of the DIE symbol s to the last type. It
+// is 0, void, for a DIE that has no type.
+func (b *btfBuilder) ref(s loader.Sym) {
+	t := &b.types[len(b.types)-1]
+	t.refs = append(t.refs, btfRef{len(t.words), s})
+	t.words = append(t.words, 0)
+}
+
+// float returns a float type of size bytes, adding one if there is
+// none.
+func (b *btfBuilder) float(size uint32) uint32 {
+	if id, ok := b.floats[size]; ok {
+		return id
+	}
+	id := b.newType(fmt.Sprintf("float%d", size*8), btfKindFloat, 0)
+	b.word(size)
+	b.floats[size] = id
+	return id
+}
+
+// btfAttrValue returns the value of the attribute attr of die, or 0.
+func btfAttrValue(die *dwarf.DWDie, attr uint16) int64 {
+	if a := getattr(die, attr); a != nil {
+		return a.Value
+	}
+	return 0
+}
+
+// btfTypeRef returns the DIE symbol that the DW_AT_type of die refers
+// to, or 0.
+func btfTypeRef(die *dwarf.DWDie) loader.Sym {
+	a := getattr(die, dwarf.DW_AT_type)
+	if a == nil {
+		return 0
+	}
+	s, _ := a.Data.(dwSym)
+	return loader.Sym(s)
+}
+
+// writebtf translates the type DIEs into the .BTF section.
+func (d *dwctxt) writebtf() dwarfSecInfo {
+	b := &btfBuilder{
+		strs:   []byte{0},
+		strOff: make(map[string]uint32),
+		ids:    make(map[loader.Sym]uint32),
+		floats: make(map[uint32]uint32),
+	}
+	for die := dwtypes.Child; die != nil; die = die.Link {
+		d.addbtftype(b, die)
+	}
+
+	s := d.ldr.CreateSymForUpdate(".BTF", 0)
+	s.SetType(sym.SDWARFSECT)
+	s.SetReachable(true)
+	typeLen := 0
+	for _, t := range b.types {
+		typeLen += 4 * len(t.words)
+	}
+	s.AddUint16(d.arch, btfMagic)
+	s.AddUint8(btfVersion)
+	s.AddUint8(0) // flags
+	s.AddUint32(d.arch, btfHdrLen)
+	s.AddUint32(d.arch, 0)               // type_off
+	s.AddUint32(d.arch, uint32(typeLen)) // type_len
+	s.AddUint32(d.arch, uint32(typeLen)) // str_off
+	s.AddUint32(d.arch, uint32(len(b.strs)))
+	for _, t := range b.types {
+		for _, r := range t.refs {
+			t.words[r.word] = b.ids[r.die]
+		}
+		for _, w := range t.words {
+			s.AddUint32(d.arch, w)
+		}
+	}
+	s.AddBytes(b.strs)
+	return dwarfSecInfo{syms: []loader.Sym{s.Sym()}}
+}
+
+// addbtftype adds the BTF type of the type DIE die.
+func (d *dwctxt) addbtftype(b *btfBuilder, die *dwarf.DWDie) {
+	s := d.dtolsym(die.Sym)
+	if s == 0 {
+		return
+	}
+	name, _ := getattr(die, dwarf.DW_AT_name).Data.(string)
+	size := uint32(btfAttrValue(die, dwarf.DW_AT_byte_size))
+	var id uint32
+	switch die.Abbrev {
+	case dwarf.DW_ABRV_BASETYPE:
+		switch btfAttrValue(die, dwarf.DW_AT_encoding) {
+		case dwarf.DW_ATE_boolean:
+			id = b.newType(name, btfKindInt, 0)
+			b.word(size)
+			b.word(btfIntBool<<24 | 8)
+		case dwarf.DW_ATE_signed:
+			id = b.newType(name, btfKindInt, 0)
+			b.word(size)
+			b.word(btfIntSigned<<24 | size*8)
+		case dwarf.DW_ATE_unsigned:
+			id = b.newType(name, btfKindInt, 0)
+			b.word(size)
+			b.word(size * 8)
+		case dwarf.DW_ATE_float:
+			id = b.newType(name, btfKindFloat, 0)
+			b.word(size)
+			if _, ok := b.floats[size]; !ok {
+				b.floats[size] = id
+			}
+		case dwarf.DW_ATE_complex_float:
+			// BTF has no complex numbers: make it a struct of the
+			// real and imaginary parts.
+			f := b.float(size / 2)
+			id = b.newType(name, btfKindStruct, 2)
+			b.word(size)
+			b.word(b.str("real"))
+			b.word(f)
+			b.word(0)
+			b.word(b.str("imag"))
+			b.word(f)
+			b.word(size / 2 * 8)
+		default:
+			return
+		}
+
+	case dwarf.DW_ABRV_BARE_PTRTYPE:
+		// A pointer is nameless in BTF.
+		p := b.newType("", btfKindPtr, 0)
+		b.word(0)
+		id = b.newType(name, btfKindTypedef, 0)
+		b.word(p)
+
+	case dwarf.DW_ABRV_PTRTYPE:
+		id = b.newType("", btfKindPtr, 0)
+		b.ref(btfTypeRef(die))
+
+	case dwarf.DW_ABRV_ARRAYTYPE:
+		id = b.newType("", btfKindArray, 0)
+		b.word(0)
+		b.ref(btfTypeRef(die))
+		if r := die.Child; r != nil && r.Abbrev == dwarf.DW_ABRV_ARRAYRANGE {
+			b.ref(btfTypeRef(r))
+			b.word(uint32(btfAttrValue(r, dwarf.DW_AT_count)))
+		} else {
+			b.word(0)
+			b.word(0)
+		}
+
+	case dwarf.DW_ABRV_STRUCTTYPE, dwarf.DW_ABRV_STRINGTYPE, dwarf.DW_ABRV_SLICETYPE:
+		// Strings and slices have the fields of their runtime
+		// structs.
+		n := 0
+		for f := die.Child; f != nil; f = f.Link {
+			if f.Abbrev == dwarf.DW_ABRV_STRUCTFIELD {
+				n++
+			}
+		}
+		if n > btfMaxVlen {
+			Errorf(nil, "btf: struct %s has too many fields (%d)", name, n)
+			return
+		}
+		id = b.newType(name, btfKindStruct, n)
+		b.word(size)
+		for f := die.Child; f != nil; f = f.Link {
+			if f.Abbrev != dwarf.DW_ABRV_STRUCTFIELD {
+				continue
+			}
+			fname, _ := getattr(f, dwarf.DW_AT_name).Data.(string)
+			b.word(b.str(fname))
+			b.ref(btfTypeRef(f))
+			b.word(uint32(btfAttrValue(f, dwarf.DW_AT_data_member_location)) * 8)
+		}
+
+	case dwarf.DW_ABRV_FUNCTYPE:
+		// A func value is a pointer to its closure, called as the
+		// prototype of the params. The ... of a variadic func is
+		// left out, as its last param is already a slice.
+		n := 0
+		for p := die.Child; p != nil; p = p.Link {
+			if p.Abbrev == dwarf.DW_ABRV_FUNCTYPEPARAM {
+				n++
+			}
+		}
+		if n > btfMaxVlen {
+			Errorf(nil, "btf: func %s has too many params (%d)", name, n)
+			return
+		}
+		proto := b.newType("", btfKindFuncProto, n)
+		b.word(0)
+		for p := die.Child; p != nil; p = p.Link {
+			if p.Abbrev == dwarf.DW_ABRV_FUNCTYPEPARAM {
+				b.word(0)
+				b.ref(btfTypeRef(p))
+			}
+		}
+		id = b.newType("", btfKindPtr, 0)
+		b.word(proto)
+
+	case dwarf.DW_ABRV_IFACETYPE, dwarf.DW_ABRV_MAPTYPE, dwarf.DW_ABRV_CHANTYPE, dwarf.DW_ABRV_TYPEDECL:
+		// Interfaces are their runtime structs, and maps and
+		// channels pointers to theirs.
+		id = b.newType(name, btfKindTypedef, 0)
+		b.ref(btfTypeRef(die))
+
+	default:
+		// The unspecified type is void.
+		return
+	}
+	b.ids[s] = id
+}
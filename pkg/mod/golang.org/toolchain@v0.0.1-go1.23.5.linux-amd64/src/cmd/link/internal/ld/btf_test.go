@@ -0,0 +1,150 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const btfSrc = `package main
+
+type T struct {
+	A int32
+	B string
+	C *T
+	D [3]uint16
+	E complex64
+	F bool
+}
+
+var t T
+
+func main() { t.A = 1; println(t.A, t.B) }
+`
+
+// TestBTF links for linux/amd64 with -btf and checks the .BTF section
+// for the struct type of the program.
+func TestBTF(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(btfSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.out")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-btf", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	ef, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	sect := ef.Section(".BTF")
+	if sect == nil {
+		t.Fatal("no .BTF section")
+	}
+	if sect.Flags != 0 {
+		t.Errorf(".BTF has flags %v, want none", sect.Flags)
+	}
+	b, err := sect.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	if len(b) < btfHdrLen || le.Uint16(b) != btfMagic || le.Uint32(b[4:]) != btfHdrLen {
+		t.Fatalf(".BTF header %x", b[:min(len(b), btfHdrLen)])
+	}
+	typeOff, typeLen := btfHdrLen+le.Uint32(b[8:]), le.Uint32(b[12:])
+	strOff, strLen := btfHdrLen+le.Uint32(b[16:]), le.Uint32(b[20:])
+	if int(strOff+strLen) != len(b) {
+		t.Fatalf(".BTF strings end at %d, section at %d", strOff+strLen, len(b))
+	}
+	strs := b[strOff : strOff+strLen]
+	str := func(off uint32) string {
+		s := strs[off:]
+		return string(s[:bytes.IndexByte(s, 0)])
+	}
+
+	// Find the types and the members of the structs.
+	type member struct {
+		name       string
+		typ, shift uint32
+	}
+	type btfT struct {
+		name             string
+		kind, sizeOrType uint32
+		members          []member
+	}
+	types := []btfT{{name: "void"}}
+	for ts := b[typeOff : typeOff+typeLen]; len(ts) > 0; {
+		info := le.Uint32(ts[4:])
+		bt := btfT{name: str(le.Uint32(ts)), kind: info >> 24 & 0x1f, sizeOrType: le.Uint32(ts[8:])}
+		vlen := int(info & 0xffff)
+		ts = ts[12:]
+		switch bt.kind {
+		case btfKindInt:
+			ts = ts[4:]
+		case btfKindArray:
+			ts = ts[12:]
+		case btfKindStruct:
+			for i := 0; i < vlen; i++ {
+				bt.members = append(bt.members, member{str(le.Uint32(ts)), le.Uint32(ts[4:]), le.Uint32(ts[8:])})
+				ts = ts[12:]
+			}
+		case btfKindFuncProto:
+			ts = ts[8*vlen:]
+		case btfKindPtr, btfKindTypedef, btfKindFloat:
+		default:
+			t.Fatalf("type %d has kind %d", len(types), bt.kind)
+		}
+		types = append(types, bt)
+	}
+	var tt *btfT
+	for i := range types {
+		if types[i].name == "main.T" && types[i].kind == btfKindStruct {
+			tt = &types[i]
+		}
+	}
+	if tt == nil {
+		t.Fatal("no struct main.T")
+	}
+	if tt.sizeOrType != 56 {
+		t.Errorf("main.T has size %d, want 56", tt.sizeOrType)
+	}
+	var got []member
+	for _, m := range tt.members {
+		got = append(got, member{m.name, types[m.typ].kind, m.shift})
+	}
+	want := []member{
+		{"A", btfKindInt, 0},
+		{"B", btfKindStruct, 64},
+		{"C", btfKindPtr, 192},
+		{"D", btfKindArray, 256},
+		{"E", btfKindStruct, 320},
+		{"F", btfKindInt, 384},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("main.T has members %v, want %v", got, want)
+	}
+	if c := types[tt.members[2].typ]; types[c.sizeOrType].name != "main.T" {
+		t.Errorf("main.T.C points to %q, want main.T", types[c.sizeOrType].name)
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the writer of the archive of -buildmode=c-archive,
+// which the linker writes itself unless -extar names an archive program
+// or the target is AIX, whose big archives are written by ar. Unlike
+// that of ar, the output depends on nothing but the members: their
+// dates, owners and groups are 0 and their modes 0644, they are in the
+// order of the link, named by their base names, and the symbol index
+// lists the global symbols each defines in the order of the members.
+//
+// The consumers of the archive do not all read the same flavor of it.
+// -arsymtab chooses the symbol index: gnu, the "/" member of System V
+// and GNU ar, which becomes the "/SYM64/" member of gnu64 once the
+// archive outgrows 32-bit offsets; gnu64; bsd, the "__.SYMDEF SORTED"
+// member of ranlib on BSD and darwin; or none. -arnames chooses how the
+// names of the members are stored: gnu, in the header up to 15 bytes and
+// in the "//" member otherwise, or bsd, as "#1/len" followed by the name
+// at the start of the data, padded so that the data of each member is
+// 8-byte aligned. Both default to gnu, and to bsd on darwin.
+
+import (
+	"bufio"
+	"bytes"
+	"cmd/internal/objabi"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An arMember is a member of an archive written by writeArchive.
+type arMember struct {
+	name string   // name of the member, without directories
+	file string   // file with the contents of the member
+	size int64    // size of the contents
+	syms []string // global symbols the member defines
+}
+
+// checkArchiveFlags checks -arsymtab and -arnames.
+func checkArchiveFlags(ctxt *Link) {
+	if *flagArSymtab == "auto" && *flagArNames == "auto" {
+		return
+	}
+	if ctxt.BuildMode != BuildModeCArchive {
+		Exitf("-arsymtab and -arnames are only supported with -buildmode=c-archive")
+	}
+	if *flagExtar != "" || ctxt.HeadType == objabi.Haix {
+		Exitf("-arsymtab and -arnames are not supported with -extar or on aix, where ar writes the archive")
+	}
+	switch *flagArSymtab {
+	case "auto", "gnu", "gnu64", "bsd", "none":
+	default:
+		Exitf("-arsymtab: unknown flavor %q, want gnu, gnu64, bsd or none", *flagArSymtab)
+	}
+	switch *flagArNames {
+	case "auto", "gnu", "bsd":
+	default:
+		Exitf("-arnames: unknown flavor %q, want gnu or bsd", *flagArNames)
+	}
+}
+
+// writeCArchive writes the archive of -buildmode=c-archive with the
+// object files files.
+func (ctxt *Link) writeCArchive(files []string) {
+	symtab, names := *flagArSymtab, *flagArNames
+	if symtab == "auto" {
+		symtab = "gnu"
+		if ctxt.IsDarwin() {
+			symtab = "bsd"
+		}
+	}
+	if names == "auto" {
+		names = "gnu"
+		if ctxt.IsDarwin() {
+			names = "bsd"
+		}
+	}
+
+	members := make([]arMember, len(files))
+	for i, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			Exitf("%v", err)
+		}
+		m := arMember{name: filepath.Base(file), file: file, size: fi.Size()}
+		if symtab != "none" {
+			if m.syms, err = arObjectSymbols(file); err != nil {
+				Exitf("reading the symbols of %s: %v", file, err)
+			}
+		}
+		members[i] = m
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("archive: writing %s, with -arsymtab=%s -arnames=%s: %s\n", *flagOutfile, symtab, names, strings.Join(files, " "))
+	}
+
+	f, err := os.OpenFile(*flagOutfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		Exitf("cannot create %s: %v", *flagOutfile, err)
+	}
+	w := bufio.NewWriter(f)
+	err = writeArchive(w, members, symtab, names, ctxt.Arch.ByteOrder)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		Exitf("writing %s: %v", *flagOutfile, err)
+	}
+}
+
+// arObjectSymbols returns the names of the global symbols the ELF,
+// Mach-O or PE object file defines, sorted. Other files define none.
+func arObjectSymbols(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	if ef, err := elf.NewFile(f); err == nil {
+		syms, err := ef.Symbols()
+		if err != nil && err != elf.ErrNoSymbols {
+			return nil, err
+		}
+		for _, s := range syms {
+			bind := elf.ST_BIND(s.Info)
+			if s.Name != "" && s.Section != elf.SHN_UNDEF && (bind == elf.STB_GLOBAL || bind == elf.STB_WEAK) {
+				names = append(names, s.Name)
+			}
+		}
+	} else if mf, err := macho.NewFile(f); err == nil {
+		if mf.Symtab != nil {
+			for _, s := range mf.Symtab.Syms {
+				const (
+					N_STAB = 0xe0
+					N_TYPE = 0x0e
+					N_EXT  = 0x01
+					N_UNDF = 0x00
+					N_PBUD = 0x0c
+				)
+				t := s.Type & N_TYPE
+				if s.Type&N_STAB != 0 || s.Type&N_EXT == 0 || t == N_PBUD || t == N_UNDF && s.Value == 0 {
+					continue
+				}
+				names = append(names, s.Name)
+			}
+		}
+	} else if pf, err := pe.NewFile(f); err == nil {
+		for _, s := range pf.Symbols {
+			if s.StorageClass == IMAGE_SYM_CLASS_EXTERNAL && (s.SectionNumber > 0 || s.SectionNumber == 0 && s.Value > 0) {
+				names = append(names, s.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// arHeader returns the header of an archive member with the given name
+// field, mode and size.
+func arHeader(name string, mode, size int64) string {
+	return fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, mode, size)
+}
+
+// writeArchive writes to w the archive of members, with the symbol index
+// and the member names of the given flavors. A bsd symbol index is in
+// the byte order bo, that of the target.
+func writeArchive(w io.Writer, members []arMember, symtab, names string, bo binary.ByteOrder) error {
+	nsyms, strsize := 0, 0
+	for _, m := range members {
+		nsyms += len(m.syms)
+		for _, s := range m.syms {
+			strsize += len(s) + 1
+		}
+	}
+	if nsyms == 0 {
+		symtab = "none"
+	}
+
+	// The names of the members in their headers, and those of gnu that
+	// do not fit, in the "//" member.
+	hdrNames := make([]string, len(members))
+	var longNames bytes.Buffer
+	for i, m := range members {
+		switch {
+		case names == "bsd":
+			// Set by layout.
+		case len(m.name) < 16 && !strings.Contains(m.name, "/"):
+			hdrNames[i] = m.name + "/"
+		default:
+			hdrNames[i] = "/" + strconv.Itoa(longNames.Len())
+			longNames.WriteString(m.name + "/\n")
+		}
+	}
+
+	// bsdName returns the name of bsd of the member whose header is at
+	// off, padded with NULs so that its data is 8-byte aligned.
+	bsdName := func(name string, off int64) string {
+		n := int64(len(name) + 1)
+		n += -(off + SAR_HDR + n) & 7
+		return name + strings.Repeat("\x00", int(n)-len(name))
+	}
+	const bsdSymdef = "__.SYMDEF SORTED"
+
+	// layout sets the size of the symbol index of the given flavor,
+	// the names of bsd that precede the data of the members and the
+	// offsets of the headers of the members.
+	var symtabSize int64
+	prefixes := make([]string, len(members))
+	offsets := make([]int64, len(members))
+	layout := func(symtab string) {
+		off := int64(SARMAG)
+		switch symtab {
+		case "gnu":
+			symtabSize = int64(4 + 4*nsyms + strsize)
+		case "gnu64":
+			symtabSize = int64(8 + 8*nsyms + strsize)
+		case "bsd":
+			strs := int64(strsize + -strsize&3)
+			symtabSize = int64(len(bsdName(bsdSymdef, off))) + 4 + 8*int64(nsyms) + 4 + strs
+		}
+		if symtab != "none" {
+			off += SAR_HDR + symtabSize + symtabSize&1
+		}
+		if longNames.Len() > 0 {
+			off += SAR_HDR + int64(longNames.Len()) + int64(longNames.Len())&1
+		}
+		for i, m := range members {
+			offsets[i] = off
+			size := m.size
+			if names == "bsd" {
+				prefixes[i] = bsdName(m.name, off)
+				size += int64(len(prefixes[i]))
+			}
+			off += SAR_HDR + size + size&1
+		}
+	}
+	layout(symtab)
+	if len(offsets) > 0 && offsets[len(offsets)-1] >= 1<<32 {
+		switch symtab {
+		case "gnu":
+			symtab = "gnu64"
+			layout(symtab)
+		case "bsd":
+			return fmt.Errorf("the archive is too large for the 32-bit offsets of -arsymtab=bsd")
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(ARMAG)
+	switch symtab {
+	case "gnu", "gnu64":
+		word := func(x int64) {
+			if symtab == "gnu64" {
+				b.Write(binary.BigEndian.AppendUint64(nil, uint64(x)))
+			} else {
+				b.Write(binary.BigEndian.AppendUint32(nil, uint32(x)))
+			}
+		}
+		name := "/"
+		if symtab == "gnu64" {
+			name = "/SYM64/"
+		}
+		b.WriteString(arHeader(name, 0, symtabSize))
+		word(int64(nsyms))
+		for i, m := range members {
+			for range m.syms {
+				word(offsets[i])
+			}
+		}
+		for _, m := range members {
+			for _, s := range m.syms {
+				b.WriteString(s)
+				b.WriteByte(0)
+			}
+		}
+	case "bsd":
+		type ranlib struct {
+			name string
+			off  int64
+		}
+		var syms []ranlib
+		for i, m := range members {
+			for _, s := range m.syms {
+				syms = append(syms, ranlib{s, offsets[i]})
+			}
+		}
+		sort.SliceStable(syms, func(i, j int) bool { return syms[i].name < syms[j].name })
+		word := func(x int64) {
+			var a [4]byte
+			bo.PutUint32(a[:], uint32(x))
+			b.Write(a[:])
+		}
+		name := bsdName(bsdSymdef, int64(b.Len()))
+		b.WriteString(arHeader(arBSDPrefix+strconv.Itoa(len(name)), 0644, symtabSize))
+		b.WriteString(name)
+		word(int64(8 * len(syms)))
+		strx := 0
+		for _, s := range syms {
+			word(int64(strx))
+			word(s.off)
+			strx += len(s.name) + 1
+		}
+		word(int64(strsize + -strsize&3))
+		for _, s := range syms {
+			b.WriteString(s.name)
+			b.WriteByte(0)
+		}
+		b.Write(make([]byte, -strsize&3))
+	}
+	if b.Len()&1 != 0 {
+		b.WriteByte('\n')
+	}
+	if longNames.Len() > 0 {
+		fmt.Fprintf(&b, "%-48s%-10d`\n", "//", longNames.Len())
+		b.Write(longNames.Bytes())
+		if b.Len()&1 != 0 {
+			b.WriteByte('\n')
+		}
+	}
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	for i, m := range members {
+		b.Reset()
+		if names == "bsd" {
+			b.WriteString(arHeader(arBSDPrefix+strconv.Itoa(len(prefixes[i])), 0644, int64(len(prefixes[i]))+m.size))
+			b.WriteString(prefixes[i])
+		} else {
+			b.WriteString(arHeader(hdrNames[i], 0644, m.size))
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return err
+		}
+		f, err := os.Open(m.file)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, f, m.size)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("copying %s: %v", m.file, err)
+		}
+		if (int64(len(prefixes[i]))+m.size)&1 != 0 {
+			if _, err := w.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readTestArchive returns the members of the archive data, by name, with
+// their contents, and its symbol index, mapping each symbol to the name
+// of the member that defines it.
+func readTestArchive(t *testing.T, data []byte) (members map[string]string, syms map[string]string) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(ARMAG)) {
+		t.Fatalf("no archive magic")
+	}
+	members = make(map[string]string)
+	byOff := make(map[int64]string)
+	var index []byte
+	var indexName string
+	var longNames []byte
+	for off := int64(SARMAG); off < int64(len(data)); {
+		hdr := data[off : off+SAR_HDR]
+		if string(hdr[58:]) != "`\n" {
+			t.Fatalf("bad header at %#x: %q", off, hdr)
+		}
+		if date := strings.TrimSpace(string(hdr[16:28])); date != "" && date != "0" {
+			t.Errorf("date of the member at %#x is %s", off, date)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimRight(string(hdr[:16]), " ")
+		contents := data[off+SAR_HDR : off+SAR_HDR+size]
+		if n, ok := strings.CutPrefix(name, arBSDPrefix); ok {
+			nameLen, _ := strconv.Atoi(n)
+			name = strings.TrimRight(string(contents[:nameLen]), "\x00")
+			contents = contents[nameLen:]
+			if (off+SAR_HDR+int64(nameLen))%8 != 0 {
+				t.Errorf("data of %s is not 8-byte aligned", name)
+			}
+		}
+		switch name {
+		case "/", "/SYM64/", "__.SYMDEF SORTED":
+			index, indexName = contents, name
+		case "//":
+			longNames = contents
+		default:
+			name = arMemberName(name, longNames)
+			members[name] = string(contents)
+			byOff[off] = name
+		}
+		off += SAR_HDR + size + size&1
+	}
+
+	syms = make(map[string]string)
+	switch indexName {
+	case "/", "/SYM64/":
+		w := 4
+		if indexName == "/SYM64/" {
+			w = 8
+		}
+		word := func(b []byte) int64 {
+			if w == 8 {
+				return int64(binary.BigEndian.Uint64(b))
+			}
+			return int64(binary.BigEndian.Uint32(b))
+		}
+		n := word(index)
+		strs := strings.Split(string(index[int64(w)*(n+1):]), "\x00")
+		for i := int64(0); i < n; i++ {
+			syms[strs[i]] = byOff[word(index[int64(w)*(i+1):])]
+		}
+	case "__.SYMDEF SORTED":
+		n := int(binary.LittleEndian.Uint32(index)) / 8
+		strs := index[4+8*n+4:]
+		for i := 0; i < n; i++ {
+			e := index[4+8*i:]
+			strx := binary.LittleEndian.Uint32(e)
+			name, _, _ := strings.Cut(string(strs[strx:]), "\x00")
+			syms[name] = byOff[int64(binary.LittleEndian.Uint32(e[4:]))]
+		}
+	}
+	return members, syms
+}
+
+func TestWriteArchive(t *testing.T) {
+	dir := t.TempDir()
+	var members []arMember
+	for _, m := range []struct {
+		name, contents string
+		syms           []string
+	}{
+		{"go.o", "Go code", []string{"Add", "_cgo_panic"}},
+		{"a-rather-long-name.o", "odd", []string{"x_cgo_init"}},
+		{"000001.o", "C code", nil},
+	} {
+		file := filepath.Join(dir, m.name)
+		if err := os.WriteFile(file, []byte(m.contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, arMember{m.name, file, int64(len(m.contents)), m.syms})
+	}
+
+	for _, tt := range []struct{ symtab, names string }{
+		{"gnu", "gnu"},
+		{"gnu64", "gnu"},
+		{"bsd", "bsd"},
+		{"gnu", "bsd"},
+		{"none", "gnu"},
+	} {
+		t.Run(tt.symtab+"-"+tt.names, func(t *testing.T) {
+			var b, b2 bytes.Buffer
+			if err := writeArchive(&b, members, tt.symtab, tt.names, binary.LittleEndian); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeArchive(&b2, members, tt.symtab, tt.names, binary.LittleEndian); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), b2.Bytes()) {
+				t.Errorf("two writes of the archive differ")
+			}
+
+			got, syms := readTestArchive(t, b.Bytes())
+			for _, m := range members {
+				if contents, _ := os.ReadFile(m.file); got[m.name] != string(contents) {
+					t.Errorf("member %s is %q, want %q", m.name, got[m.name], contents)
+				}
+			}
+			want := map[string]string{"Add": "go.o", "_cgo_panic": "go.o", "x_cgo_init": "a-rather-long-name.o"}
+			if tt.symtab == "none" {
+				want = map[string]string{}
+			}
+			if len(syms) != len(want) {
+				t.Errorf("symbol index %v, want %v", syms, want)
+			}
+			for s, m := range want {
+				if syms[s] != m {
+					t.Errorf("symbol %s is in %q, want %q", s, syms[s], m)
+				}
+			}
+		})
+	}
+}
+
+// TestCArchiveDeterministic builds a c-archive twice, in different
+// temporary directories, and checks that the archives are the same.
+func TestCArchiveDeterministic(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	testenv.MustHaveBuildMode(t, "c-archive")
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "lib.go")
+	if err := os.WriteFile(src, []byte(exportsSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var archives [][]byte
+	for i := 0; i < 2; i++ {
+		out := filepath.Join(dir, "lib"+strconv.Itoa(i)+".a")
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode=c-archive", "-o", out, src)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, b)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, data)
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Errorf("the archives of two builds differ")
+	}
+	members, syms := readTestArchive(t, archives[0])
+	if _, ok := members["go.o"]; !ok {
+		t.Errorf("no go.o in the archive")
+	}
+	if syms["_cgo_panic"] != "go.o" {
+		t.Errorf("symbol _cgo_panic is in %q, want go.o", syms["_cgo_panic"])
+	}
+	if _, ok := members[syms["Foo"]]; !ok {
+		t.Errorf("exported symbol Foo is not in the symbol index")
+	}
+
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-arsymtab=bsd", "-o", filepath.Join(dir, "exe"), src)
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "only supported with -buildmode=c-archive") {
+		t.Errorf("build of an executable with -arsymtab: %v\n%s", err, out)
+	}
+}
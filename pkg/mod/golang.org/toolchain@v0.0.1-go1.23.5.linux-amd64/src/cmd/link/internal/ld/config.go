@@ -180,7 +180,8 @@ func mustLinkExternal(ctxt *Link) (res bool, reason string) {
 //
 // It is called after flags are processed and inputs are processed,
 // so the ctxt.LinkMode variable has an initial value from the -linkmode
-// flag and the iscgo, externalobj, and unknownObjFormat variables are set.
+// flag and the iscgo, externalobj, unknownObjFormat and bitcodeObjs
+// variables are set.
 func determineLinkMode(ctxt *Link) {
 	extNeeded, extReason := mustLinkExternal(ctxt)
 	via := ""
@@ -202,7 +203,10 @@ func determineLinkMode(ctxt *Link) {
 			if preferExternal && ctxt.Debugvlog > 0 {
 				ctxt.Logf("external linking prefer list is %v\n", preferlinkext)
 			}
-			if extNeeded || (iscgo && (externalobj || preferExternal)) {
+			if len(bitcodeObjs) > 0 && ctxt.Debugvlog > 0 {
+				ctxt.Logf("LLVM bitcode host objects %v prefer external linking\n", bitcodeObjs)
+			}
+			if extNeeded || (iscgo && (externalobj || preferExternal)) || len(bitcodeObjs) > 0 {
 				ctxt.LinkMode = LinkExternal
 			} else {
 				ctxt.LinkMode = LinkInternal
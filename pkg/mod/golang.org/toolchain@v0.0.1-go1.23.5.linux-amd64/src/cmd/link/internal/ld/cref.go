@@ -0,0 +1,167 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -cref, which writes a cross-reference table like
+// the one GNU ld --cref writes: each symbol of the link, with where it
+// is defined, followed by the symbols that refer to it, with where
+// they are defined. That is, for a symbol the table tells which code
+// pulls it in, and from which package, object file or host object.
+//
+// The table is taken before the relocations are turned into dynamic
+// relocations, so that a reference to a dynamic import is to the import
+// and not to its PLT or GOT entry. A relocation in a section of a host
+// object is a reference of the symbol of the object that holds the
+// relocated bytes. The references of the DWARF of the output, which
+// refers to nearly every symbol, are left out.
+
+import (
+	"bufio"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A crefSymbol is a symbol in the cross-reference table, with the
+// symbols that refer to it.
+type crefSymbol struct {
+	crefRef
+	refs []crefRef
+}
+
+// A crefRef is a symbol and where it is defined: its package, with the
+// object file or host object it was read from, or, for a dynamic
+// import, its library.
+type crefRef struct {
+	name  string
+	where string
+}
+
+// crefWhere returns where the symbol s is defined.
+func crefWhere(ldr *loader.Loader, s loader.Sym) string {
+	if ldr.SymType(s) == sym.SDYNIMPORT {
+		if lib := ldr.SymDynimplib(s); lib != "" {
+			return lib + " (dynamic)"
+		}
+		return "(dynamic)"
+	}
+	o := s
+	if outer := ldr.OuterSym(s); outer != 0 {
+		o = outer
+	}
+	for _, h := range hostobj {
+		if h.symlo <= o && o < h.symhi {
+			return h.pkg + " (" + h.pn + ")"
+		}
+	}
+	pkg := ldr.SymPkg(s)
+	if unit := ldr.SymUnit(s); unit != nil && unit.Lib != nil && unit.Lib.File != "" {
+		return pkg + " (" + unit.Lib.File + ")"
+	}
+	return pkg
+}
+
+// crefSymbols returns the reachable symbols of the link, sorted by
+// name, with the symbols that refer to them.
+func crefSymbols(ctxt *Link) []crefSymbol {
+	ldr := ctxt.loader
+	refs := make(map[loader.Sym]map[loader.Sym]bool)
+	for s := loader.Sym(1); s < loader.Sym(ldr.NSym()); s++ {
+		if !ldr.AttrReachable(s) || ldr.SymType(s).IsDWARF() {
+			continue
+		}
+		relocs := ldr.Relocs(s)
+		for ri := 0; ri < relocs.Count(); ri++ {
+			r := relocs.At(ri)
+			t := r.Sym()
+			if t == 0 || ldr.SymName(t) == "" {
+				continue
+			}
+			from := s
+			if ldr.SubSym(s) != 0 {
+				// The relocation is in the bytes of a sub-symbol,
+				// such as a function of a host object section.
+				off := ldr.SymValue(s) + int64(r.Off())
+				for sub := ldr.SubSym(s); sub != 0; sub = ldr.SubSym(sub) {
+					if v := ldr.SymValue(sub); v <= off && off < v+ldr.SymSize(sub) {
+						from = sub
+						break
+					}
+				}
+			}
+			if from == t {
+				continue
+			}
+			if refs[t] == nil {
+				refs[t] = make(map[loader.Sym]bool)
+			}
+			refs[t][from] = true
+		}
+	}
+
+	var syms []crefSymbol
+	for s := loader.Sym(1); s < loader.Sym(ldr.NSym()); s++ {
+		name := ldr.SymName(s)
+		if !ldr.AttrReachable(s) || name == "" {
+			continue
+		}
+		if t := ldr.SymType(s); t == sym.Sxxx || t == sym.SXREF || t.IsDWARF() {
+			continue
+		}
+		c := crefSymbol{crefRef: crefRef{name, crefWhere(ldr, s)}}
+		for r := range refs[s] {
+			c.refs = append(c.refs, crefRef{ldr.SymName(r), crefWhere(ldr, r)})
+		}
+		sort.Slice(c.refs, func(i, j int) bool {
+			if c.refs[i].name != c.refs[j].name {
+				return c.refs[i].name < c.refs[j].name
+			}
+			return c.refs[i].where < c.refs[j].where
+		})
+		syms = append(syms, c)
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return syms[i].name < syms[j].name })
+	return syms
+}
+
+// writeCref writes the cross-reference table of syms to w. Like that
+// of GNU ld, it has a line for each symbol, with its name, or on its
+// own line a name too long for the column, and where it is defined,
+// followed by a line for each symbol that refers to it.
+func writeCref(w io.Writer, syms []crefSymbol) error {
+	const col = 40
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%-*s %s\n\n", col-1, "Symbol", "File")
+	for _, s := range syms {
+		if len(s.name) >= col {
+			fmt.Fprintf(bw, "%s\n%*s", s.name, col, "")
+		} else {
+			fmt.Fprintf(bw, "%-*s", col, s.name)
+		}
+		fmt.Fprintf(bw, "%s\n", s.where)
+		for _, r := range s.refs {
+			fmt.Fprintf(bw, "%*s%s  %s\n", col, "", r.name, r.where)
+		}
+	}
+	return bw.Flush()
+}
+
+// cref writes the -cref file.
+func cref(ctxt *Link) {
+	f, err := os.Create(*flagCref)
+	if err != nil {
+		Exitf("writing -cref file: %v", err)
+	}
+	if err := writeCref(f, crefSymbols(ctxt)); err != nil {
+		f.Close()
+		Exitf("writing -cref file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		Exitf("writing -cref file: %v", err)
+	}
+}
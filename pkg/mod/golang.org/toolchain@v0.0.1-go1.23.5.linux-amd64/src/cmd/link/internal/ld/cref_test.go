@@ -0,0 +1,103 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteCref(t *testing.T) {
+	syms := []crefSymbol{
+		{crefRef{"main.f", "main (/tmp/main.a)"}, []crefRef{
+			{"main.main", "main (/tmp/main.a)"},
+		}},
+		{crefRef{"main.aVeryLongFunctionNameThatFillsTheColumn", "main (/tmp/main.a)"}, nil},
+		{crefRef{"puts", "libc.so.6 (dynamic)"}, []crefRef{
+			{"_cgo_puts", "main (/tmp/main.a(_x002.o))"},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := writeCref(&buf, syms); err != nil {
+		t.Fatal(err)
+	}
+	want := `Symbol                                  File
+
+main.f                                  main (/tmp/main.a)
+                                        main.main  main (/tmp/main.a)
+main.aVeryLongFunctionNameThatFillsTheColumn
+                                        main (/tmp/main.a)
+puts                                    libc.so.6 (dynamic)
+                                        _cgo_puts  main (/tmp/main.a(_x002.o))
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestCref links a program with -cref and checks the references of its
+// functions in the table.
+func TestCref(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(`package main
+
+//go:noinline
+func f() int { return 1 }
+
+func main() { println(f()) }
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "cref.txt")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-cref="+out, "-o", filepath.Join(dir, "a.out"), src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The symbols that refer to each symbol.
+	refs := make(map[string][]string)
+	var cur string
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case line[0] != ' ':
+			cur = fields[0]
+			refs[cur] = nil
+		case strings.Contains(strings.TrimLeft(line, " "), "  "):
+			// Not where a symbol with a long name is defined.
+			refs[cur] = append(refs[cur], fields[0])
+		}
+	}
+	for sym, ref := range map[string]string{
+		"main.f":           "main.main",
+		"main.main":        "runtime.main_main·f",
+		"runtime.printint": "main.main",
+	} {
+		found := false
+		for _, r := range refs[sym] {
+			found = found || r == ref
+		}
+		if !found {
+			t.Errorf("%s is referred to by %q, want %s among them", sym, refs[sym], ref)
+		}
+	}
+}
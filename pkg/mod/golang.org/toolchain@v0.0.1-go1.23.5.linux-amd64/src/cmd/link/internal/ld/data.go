@@ -74,7 +74,7 @@ func isRuntimeDepPkg(pkg string) bool {
 func maxSizeTrampolines(ctxt *Link, ldr *loader.Loader, s loader.Sym, isTramp bool) uint64 {
 	// If thearch.Trampoline is nil, then trampoline support is not available on this arch.
 	// A trampoline does not need any dependent trampolines.
-	if thearch.Trampoline == nil || isTramp {
+	if thearch.Trampoline == nil || isTramp || *flagTramp == "never" {
 		return 0
 	}
 
@@ -87,25 +87,25 @@ func maxSizeTrampolines(ctxt *Link, ldr *loader.Loader, s loader.Sym, isTramp bo
 		}
 	}
 
-	switch {
-	case ctxt.IsARM():
-		return n * 20 // Trampolines in ARM range from 3 to 5 instructions.
-	case ctxt.IsARM64():
-		return n * 12 // Trampolines in ARM64 are 3 instructions.
-	case ctxt.IsPPC64():
-		return n * 16 // Trampolines in PPC64 are 4 instructions.
-	case ctxt.IsRISCV64():
-		return n * 8 // Trampolines in RISCV64 are 2 instructions.
-	}
-	panic("unreachable")
+	return n * thearch.TrampSize
+}
+
+// ForceTramps reports whether direct calls between packages are to use
+// trampolines whatever their distance, as -tramp=always and -debugtramp=2
+// ask for.
+func ForceTramps() bool {
+	return *flagTramp == "always" || *FlagDebugTramp > 1
 }
 
 // Detect too-far jumps in function s, and add trampolines if necessary.
-// ARM, PPC64, PPC64LE and RISCV64 support trampoline insertion for internal
-// and external linking. On PPC64 and PPC64LE the text sections might be split
-// but will still insert trampolines where necessary.
+// ARM, ARM64, LOONG64, PPC64, PPC64LE and RISCV64 support trampoline
+// insertion for internal and external linking, each with its own
+// thearch.Trampoline, TrampLimit and TrampSize. On PPC64 and PPC64LE the
+// text sections might be split but will still insert trampolines where
+// necessary. With -tramp=never no trampolines are inserted, and calls
+// that are out of range fail to relocate.
 func trampoline(ctxt *Link, s loader.Sym) {
-	if thearch.Trampoline == nil {
+	if thearch.Trampoline == nil || *flagTramp == "never" {
 		return // no need or no support of trampolines on this arch
 	}
 
@@ -124,10 +124,10 @@ func trampoline(ctxt *Link, s loader.Sym) {
 
 		if ldr.SymValue(rs) == 0 && ldr.SymType(rs) != sym.SDYNIMPORT && ldr.SymType(rs) != sym.SUNDEFEXT {
 			// Symbols in the same package are laid out together (if we
-			// don't randomize the function order).
+			// don't randomize or reorder the functions).
 			// Except that if SymPkg(s) == "", it is a host object symbol
 			// which may call an external symbol via PLT.
-			if ldr.SymPkg(s) != "" && ldr.SymPkg(rs) == ldr.SymPkg(s) && *flagRandLayout == 0 {
+			if ldr.SymPkg(s) != "" && ldr.SymPkg(rs) == ldr.SymPkg(s) && !textReordered {
 				// RISC-V is only able to reach +/-1MiB via a JAL instruction.
 				// We need to generate a trampoline when an address is
 				// currently unknown.
@@ -136,7 +136,7 @@ func trampoline(ctxt *Link, s loader.Sym) {
 				}
 			}
 			// Runtime packages are laid out together.
-			if isRuntimeDepPkg(ldr.SymPkg(s)) && isRuntimeDepPkg(ldr.SymPkg(rs)) && *flagRandLayout == 0 {
+			if isRuntimeDepPkg(ldr.SymPkg(s)) && isRuntimeDepPkg(ldr.SymPkg(rs)) && !textReordered {
 				continue
 			}
 		}
@@ -244,7 +244,7 @@ func (st *relocSymState) relocsym(s loader.Sym, P []byte) {
 				// (see the address pass) and we can resolve it.
 				// TODO: give it a type.
 			} else {
-				st.err.errorUnresolved(ldr, s, rs)
+				st.err.errorUnresolved(ldr, s, rs, r)
 				continue
 			}
 		}
@@ -475,7 +475,7 @@ func (st *relocSymState) relocsym(s loader.Sym, P []byte) {
 
 			// The method offset tables using this relocation expect the offset to be relative
 			// to the start of the first text section, even if there are multiple.
-			if sect.Name == ".text" {
+			if isTextSect(sect) {
 				o = ldr.SymValue(rs) - int64(Segtext.Sections[0].Vaddr) + r.Add()
 			} else {
 				o = ldr.SymValue(rs) - int64(ldr.SymSect(rs).Vaddr) + r.Add()
@@ -1633,6 +1633,10 @@ func (ctxt *Link) dodata(symGroupType []sym.SymKind) {
 	lastSym := loader.Sym(ldr.NSym() - 1)
 	ldr.SetSymAlign(lastSym, ldr.SymAlign(lastSym))
 
+	if len(textOrderNames) > 0 {
+		state.orderData(ctxt)
+	}
+
 	// Sort symbols.
 	var wg sync.WaitGroup
 	for symn := range state.data {
@@ -2110,6 +2114,9 @@ func (state *dodataState) allocateDataSections(ctxt *Link) {
 		}
 		sect.Length = uint64(state.datsize) - sect.Vaddr
 
+		if ctxt.IsELF {
+			elfSizeRelr(ctxt)
+		}
 		state.allocateSingleSymSections(segrelro, sym.SELFRELROSECT, sym.SRODATA, relroSecPerm)
 	}
 
@@ -2219,10 +2226,11 @@ func (state *dodataState) allocateSEHSections(ctxt *Link) {
 }
 
 type symNameSize struct {
-	name string
-	sz   int64
-	val  int64
-	sym  loader.Sym
+	name  string
+	sz    int64
+	val   int64
+	sym   loader.Sym
+	order int // place in -symbol-ordering-file, or 0
 }
 
 func (state *dodataState) dodataSect(ctxt *Link, symn sym.SymKind, syms []loader.Sym) (result []loader.Sym, maxAlign int32) {
@@ -2238,7 +2246,7 @@ func (state *dodataState) dodataSect(ctxt *Link, symn sym.SymKind, syms []loader
 
 	for k, s := range syms {
 		ss := ldr.SymSize(s)
-		sl[k] = symNameSize{sz: ss, sym: s}
+		sl[k] = symNameSize{sz: ss, sym: s, order: dataOrder[s]}
 		if !checkSize {
 			sl[k].name = ldr.SymName(s)
 		}
@@ -2287,6 +2295,16 @@ func (state *dodataState) dodataSect(ctxt *Link, symn sym.SymKind, syms []loader
 			case sj == zerobase:
 				return isz == 0 // 0-sized < zerobase
 			}
+			// The symbols -symbol-ordering-file names come first,
+			// after the zero-sized ones, in the order of the file.
+			if io, jo := sl[i].order, sl[j].order; (io != 0 || jo != 0) && isz != 0 && jsz != 0 {
+				if io == 0 || jo == 0 {
+					return jo == 0
+				}
+				if io != jo {
+					return io < jo
+				}
+			}
 			if checkSize {
 				if isz != jsz {
 					return isz < jsz
@@ -2404,22 +2422,16 @@ func (ctxt *Link) textaddress() {
 
 	if *flagRandLayout != 0 {
 		r := rand.New(rand.NewSource(*flagRandLayout))
-		textp := ctxt.Textp
-		i := 0
-		// don't move the buildid symbol
-		if len(textp) > 0 && ldr.SymName(textp[0]) == "go:buildid" {
-			i++
-		}
-		// Skip over C symbols, as functions in a (C object) section must stay together.
-		// TODO: maybe we can move a section as a whole.
-		// Note: we load C symbols before Go symbols, so we can scan from the start.
-		for i < len(textp) && (ldr.SubSym(textp[i]) != 0 || ldr.AttrSubSymbol(textp[i])) {
-			i++
-		}
-		textp = textp[i:]
+		textp := movableText(ctxt)
 		r.Shuffle(len(textp), func(i, j int) {
 			textp[i], textp[j] = textp[j], textp[i]
 		})
+		textReordered = true
+	} else if len(textOrderNames) > 0 || textOrderProfile != nil {
+		ctxt.orderText()
+	}
+	if funcAlign != 0 {
+		ctxt.alignText()
 	}
 
 	text := ctxt.xdefine("runtime.text", sym.STEXT, 0)
@@ -2441,7 +2453,13 @@ func (ctxt *Link) textaddress() {
 		ctxt.Textp[0] = text
 	}
 
-	start := uint64(Rnd(*FlagTextAddr, int64(Funcalign)))
+	// The functions of -funcalign are aligned in the section as well
+	// as in memory, so that an external linker keeps them aligned.
+	textAlign := int64(Funcalign)
+	if int64(funcAlign) > textAlign {
+		textAlign = int64(funcAlign)
+	}
+	start := uint64(Rnd(*FlagTextAddr, textAlign))
 	va := start
 	n := 1
 	sect.Vaddr = va
@@ -2453,8 +2471,8 @@ func (ctxt *Link) textaddress() {
 	if *FlagDebugTextSize != 0 {
 		limit = uint64(*FlagDebugTextSize)
 	}
-	if *FlagDebugTramp > 1 {
-		limit = 1 // debug mode, force generating trampolines for everything
+	if ForceTramps() {
+		limit = 1 // force generating trampolines for everything
 	}
 
 	if ctxt.IsAIX() && ctxt.IsExternal() {
@@ -2466,10 +2484,15 @@ func (ctxt *Link) textaddress() {
 		limit = 1
 	}
 
+	if thearch.Relax != nil && ctxt.IsInternal() {
+		ctxt.relaxText(sect, start, text)
+	}
+
 	// First pass: assign addresses assuming the program is small and will
 	// not require trampoline generation.
 	big := false
 	for _, s := range ctxt.Textp {
+		sect, va = splitColdText(ctxt, sect, s, va)
 		sect, n, va = assignAddress(ctxt, sect, n, s, va, false, big)
 		if va-start >= limit {
 			big = true
@@ -2487,6 +2510,7 @@ func (ctxt *Link) textaddress() {
 			}
 		}
 		va = start
+		sect = Segtext.Sections[0]
 
 		ntramps := 0
 		var curPkg string
@@ -2517,6 +2541,7 @@ func (ctxt *Link) textaddress() {
 			}
 
 			// Assign actual address for current symbol.
+			sect, va = splitColdText(ctxt, sect, s, va)
 			sect, n, va = assignAddress(ctxt, sect, n, s, va, false, big)
 
 			// Resolve jumps, adding trampolines if they are needed.
@@ -2561,6 +2586,28 @@ func (ctxt *Link) textaddress() {
 	}
 }
 
+// relaxText assigns addresses to the text from start, with no
+// trampolines, and has the architecture relax it for them, until it
+// no longer changes. The addresses are reset afterwards.
+func (ctxt *Link) relaxText(sect *sym.Section, start uint64, text loader.Sym) {
+	ldr := ctxt.loader
+	for {
+		va := start
+		for _, s := range ctxt.Textp {
+			_, _, va = assignAddress(ctxt, sect, 1, s, va, false, false)
+		}
+		changed := thearch.Relax(ctxt, ldr)
+		for _, s := range ctxt.Textp {
+			if s != text {
+				resetAddress(ctxt, s)
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+}
+
 // assigns address for a text symbol, returns (possibly new) section, its number, and the address.
 func assignAddress(ctxt *Link, sect *sym.Section, n int, s loader.Sym, va uint64, isTramp, big bool) (*sym.Section, int, uint64) {
 	ldr := ctxt.loader
@@ -2662,6 +2709,10 @@ func assignAddress(ctxt *Link, sect *sym.Section, n int, s loader.Sym, va uint64
 			fmt.Println("assign text address:", ldr.SymName(sub), ldr.SymValue(sub))
 		}
 	}
+	for _, a := range ctxt.icfAliases[s] {
+		ldr.SetSymSect(a, ldr.SymSect(s))
+		ldr.SetSymValue(a, ldr.SymValue(s))
+	}
 
 	va += funcsize
 
@@ -2969,6 +3020,10 @@ func (ctxt *Link) address() []*sym.Segment {
 	ctxt.xdefine("runtime.covctrs", sym.SCOVERAGE_COUNTER, int64(noptrbss.Vaddr+covCounterDataStartOff))
 	ctxt.xdefine("runtime.ecovctrs", sym.SCOVERAGE_COUNTER, int64(noptrbss.Vaddr+covCounterDataStartOff+covCounterDataLen))
 	ctxt.xdefine("runtime.end", sym.SBSS, int64(Segdata.Vaddr+Segdata.Length))
+	if ctxt.isStaticPIE() {
+		s := ctxt.xdefine("_DYNAMIC", ldr.SymType(ctxt.Dynamic), ldr.SymValue(ctxt.Dynamic))
+		ldr.SetSymSect(s, ldr.SymSect(ctxt.Dynamic))
+	}
 
 	if fuzzCounters != nil {
 		ctxt.xdefine("runtime.__start___sancov_cntrs", sym.SLIBFUZZER_8BIT_COUNTER, int64(fuzzCounters.Vaddr))
@@ -3070,18 +3125,22 @@ func compressSyms(ctxt *Link, syms []loader.Sym) []byte {
 		total += ldr.SymSize(sym)
 	}
 
+	typ := elf.COMPRESS_ZLIB
+	if ctxt.compressZstd != 0 {
+		typ = elf.COMPRESS_ZSTD
+	}
 	var buf bytes.Buffer
 	if ctxt.IsELF {
 		switch ctxt.Arch.PtrSize {
 		case 8:
 			binary.Write(&buf, ctxt.Arch.ByteOrder, elf.Chdr64{
-				Type:      uint32(elf.COMPRESS_ZLIB),
+				Type:      uint32(typ),
 				Size:      uint64(total),
 				Addralign: uint64(ctxt.Arch.Alignment),
 			})
 		case 4:
 			binary.Write(&buf, ctxt.Arch.ByteOrder, elf.Chdr32{
-				Type:      uint32(elf.COMPRESS_ZLIB),
+				Type:      uint32(typ),
 				Size:      uint32(total),
 				Addralign: uint32(ctxt.Arch.Alignment),
 			})
@@ -3097,6 +3156,28 @@ func compressSyms(ctxt *Link, syms []loader.Sym) []byte {
 
 	var relocbuf []byte // temporary buffer for applying relocations
 
+	if ctxt.compressZstd != 0 {
+		// The zstd compressor needs all of the data at once.
+		data := make([]byte, 0, total)
+		st := ctxt.makeRelocSymState()
+		for _, s := range syms {
+			P := ldr.Data(s)
+			relocs := ldr.Relocs(s)
+			if relocs.Count() != 0 {
+				relocbuf = append(relocbuf[:0], P...)
+				P = relocbuf
+				st.relocsym(s, P)
+			}
+			data = append(data, P...)
+			data = append(data, make([]byte, ldr.SymSize(s)-int64(len(P)))...)
+		}
+		out := zstdCompress(buf.Bytes(), data, ctxt.compressZstd)
+		if int64(len(out)) >= total {
+			return nil
+		}
+		return out
+	}
+
 	// Using zlib.BestSpeed achieves very nearly the same
 	// compression levels of zlib.DefaultCompression, but takes
 	// substantially less time. This is important because DWARF
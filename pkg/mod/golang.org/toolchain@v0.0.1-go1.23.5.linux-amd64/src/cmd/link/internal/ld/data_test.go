@@ -8,7 +8,11 @@ import (
 	"cmd/internal/objabi"
 	"cmd/internal/sys"
 	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"fmt"
 	"internal/buildcfg"
+	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -90,3 +94,66 @@ func TestAddGotSym(t *testing.T) {
 		}
 	}
 }
+
+// BenchmarkWriteBlocks measures writing and relocating 64MB of text,
+// in symbols of 4KB with a relocation every 64 bytes, with writeBlocks
+// running a single block at a time and as many as a link does.
+func BenchmarkWriteBlocks(b *testing.B) {
+	const (
+		nsym    = 16 << 10
+		symSize = 4 << 10
+		perSym  = symSize / 64
+		base    = 0x400000
+	)
+	ctxt := setUpContext(sys.ArchAMD64, true, objabi.Hlinux, "exe", "internal")
+	ldr := ctxt.loader
+	syms := make([]loader.Sym, nsym)
+	for i := range syms {
+		sb := ldr.CreateSymForUpdate(fmt.Sprintf("sym%d", i), 0)
+		sb.SetType(sym.STEXT)
+		sb.SetReachable(true)
+		sb.SetValue(base + int64(i)*symSize)
+		sb.SetData(make([]byte, symSize))
+		sb.SetSize(symSize)
+		syms[i] = sb.Sym()
+	}
+	for i, s := range syms {
+		sb := ldr.MakeSymbolUpdater(s)
+		for j := 0; j < perSym; j++ {
+			typ := objabi.R_ADDR
+			if j%2 == 1 {
+				typ = objabi.R_PCREL
+			}
+			r, _ := sb.AddRel(typ)
+			r.SetOff(int32(j * 64))
+			r.SetSiz(8 - 4*uint8(j%2))
+			r.SetSym(syms[(i*perSym+j)%nsym])
+		}
+	}
+
+	for _, par := range []int{1, 2 * runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("par=%d", par), func(b *testing.B) {
+			out := NewOutBuf(sys.ArchAMD64)
+			if err := out.Open(filepath.Join(b.TempDir(), "out")); err != nil {
+				b.Fatal(err)
+			}
+			defer out.Close()
+			if err := out.Mmap(nsym * symSize); err != nil {
+				b.Skipf("cannot mmap the output: %v", err)
+			}
+			sem := make(chan int, par)
+			b.SetBytes(nsym * symSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Writing a symbol frees its data.
+				b.StopTimer()
+				for _, s := range syms {
+					ldr.MakeSymbolUpdater(s).SetData(make([]byte, symSize))
+				}
+				out.SeekSet(0)
+				b.StartTimer()
+				writeBlocks(ctxt, out, sem, ldr, syms, base, nsym*symSize, nil)
+			}
+		})
+	}
+}
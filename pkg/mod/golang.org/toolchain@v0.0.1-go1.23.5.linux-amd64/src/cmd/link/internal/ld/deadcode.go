@@ -39,7 +39,7 @@ func (d *deadcodePass) init() {
 	d.ldr.InitReachable()
 	d.ifaceMethod = make(map[methodsig]bool)
 	d.genericIfaceMethod = make(map[string]bool)
-	if buildcfg.Experiment.FieldTrack {
+	if buildcfg.Experiment.FieldTrack || *flagWhyLive != "" || len(disallowedPackages) > 0 {
 		d.ldr.Reachparent = make([]loader.Sym, d.ldr.NSym())
 	}
 	d.dynlink = d.ctxt.DynlinkingGo()
@@ -353,7 +353,7 @@ func (d *deadcodePass) mark(symIdx, parent loader.Sym) {
 	if symIdx != 0 && !d.ldr.AttrReachable(symIdx) {
 		d.wq.push(symIdx)
 		d.ldr.SetAttrReachable(symIdx, true)
-		if buildcfg.Experiment.FieldTrack && d.ldr.Reachparent[symIdx] == 0 {
+		if d.ldr.Reachparent != nil && d.ldr.Reachparent[symIdx] == 0 {
 			d.ldr.Reachparent[symIdx] = parent
 		}
 		if *flagDumpDep {
@@ -469,6 +469,61 @@ func deadcode(ctxt *Link) {
 	if *flagPruneWeakMap {
 		d.mapinitcleanup()
 	}
+	if *flagWhyLive != "" {
+		d.whyLive(*flagWhyLive)
+	}
+	if len(disallowedPackages) > 0 {
+		d.checkDisallowedPackages()
+	}
+	if !buildcfg.Experiment.FieldTrack {
+		ldr.Reachparent = nil // only fieldtrack uses it after this
+	}
+}
+
+// whyLive prints, for -why-live, the chain of symbols through which
+// the pass reached the symbol named name: the symbol itself, then each
+// symbol that first referred to the one before it, ending at a root of
+// the pass, such as the entry point or an init task.
+func (d *deadcodePass) whyLive(name string) {
+	var live []loader.Sym
+	found := false
+	vers := []int{0}
+	if abiInternalVer != 0 {
+		vers = append(vers, abiInternalVer)
+	}
+	for _, v := range vers {
+		s := d.ldr.Lookup(name, v)
+		if s == 0 {
+			continue
+		}
+		found = true
+		if d.ldr.AttrReachable(s) {
+			live = append(live, s)
+		}
+	}
+	switch {
+	case !found:
+		fmt.Printf("%s: no such symbol\n", name)
+	case len(live) == 0:
+		fmt.Printf("%s is not live\n", name)
+	}
+	for _, s := range live {
+		fmt.Print(d.reachChain(name, s))
+	}
+}
+
+// reachChain returns the chain of symbols through which the pass
+// reached the symbol s named name, a line for each, as -why-live
+// prints it.
+func (d *deadcodePass) reachChain(name string, s loader.Sym) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", d.dumpDepAddFlags(name, s))
+	p := s
+	for ; d.ldr.Reachparent[p] != 0; p = d.ldr.Reachparent[p] {
+		fmt.Fprintf(&b, "\treferenced by %s\n", d.dumpDepAddFlags(d.ldr.SymName(d.ldr.Reachparent[p]), d.ldr.Reachparent[p]))
+	}
+	fmt.Fprintf(&b, "\t%s is a root\n", d.ldr.SymName(p))
+	return b.String()
 }
 
 // methodsig is a typed method signature (name + type).
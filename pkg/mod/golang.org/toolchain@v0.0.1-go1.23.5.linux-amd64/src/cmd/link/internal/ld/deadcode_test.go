@@ -7,6 +7,7 @@ package ld
 import (
 	"bytes"
 	"internal/testenv"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -57,3 +58,86 @@ func TestDeadcode(t *testing.T) {
 		})
 	}
 }
+
+func TestDeadcodeWhyLive(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program for each symbol")
+	}
+	t.Parallel()
+
+	tmpdir := t.TempDir()
+	src := filepath.Join("testdata", "deadcode", "whylive.go")
+	tests := []struct {
+		sym  string
+		want []string
+	}{
+		{"main.leaf", []string{"main.leaf\n\treferenced by main.middle\n\treferenced by main.main\n", " is a root\n"}},
+		{"main.unused", []string{"main.unused is not live\n"}},
+		{"main.nosuch", []string{"main.nosuch: no such symbol\n"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.sym, func(t *testing.T) {
+			t.Parallel()
+			exe := filepath.Join(tmpdir, test.sym+".exe")
+			cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-why-live="+test.sym, "-o", exe, src)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v: %v:\n%s", cmd.Args, err, out)
+			}
+			for _, want := range test.want {
+				if !bytes.Contains(out, []byte(want)) {
+					t.Errorf("output does not contain %q. Output:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestDeadcodeDisallowPackages(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program for each policy")
+	}
+	t.Parallel()
+
+	tmpdir := t.TempDir()
+	src := filepath.Join("testdata", "deadcode", "disallow.go")
+	policy := filepath.Join(tmpdir, "policy")
+	if err := os.WriteFile(policy, []byte("# Not in production.\nnet/http/pprof\nencoding/...\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		packages string
+		want     string // in the error, or "" for a link that succeeds
+	}{
+		{"live", "encoding/hex", "package encoding/hex is disallowed by -disallow-packages, but has "},
+		{"dead", "container/list", ""},
+		{"tree", "plugin,encoding/...", "encoding/hex.Dump\n\treferenced by main.encode\n\treferenced by main.main\n"},
+		{"file", "@" + policy, "package encoding/hex is disallowed"},
+		{"pattern", "a/.../b", "invalid package pattern"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			exe := filepath.Join(tmpdir, test.name+".exe")
+			cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-disallow-packages="+test.packages, "-o", exe, src)
+			out, err := cmd.CombinedOutput()
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("%v: %v:\n%s", cmd.Args, err, out)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("%v succeeded, want an error", cmd.Args)
+			}
+			if !bytes.Contains(out, []byte(test.want)) {
+				t.Errorf("output does not contain %q. Output:\n%s", test.want, out)
+			}
+		})
+	}
+}
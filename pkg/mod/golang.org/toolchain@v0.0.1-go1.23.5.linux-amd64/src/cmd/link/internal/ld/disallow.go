@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the check of -disallow-packages, a policy of
+// packages that must not be linked into the output, such as
+// net/http/pprof or plugin in a production binary. The check runs once
+// the deadcode pass is done, so a package that is imported but has no
+// live symbols passes it. For each disallowed package that has live
+// symbols the link fails, printing, as -why-live does, the chain of
+// references that keeps one of them live.
+
+import (
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"os"
+	"sort"
+	"strings"
+)
+
+// disallowedPackages are the package patterns of -disallow-packages:
+// an import path, or a path followed by /... for the packages in the
+// tree under it.
+var disallowedPackages []string
+
+// readDisallowedPackages reads the package patterns of arg, the value
+// of -disallow-packages: a comma-separated list of patterns, or
+// @file for those of file, a pattern per line, where blank lines and
+// lines starting with # are ignored.
+func readDisallowedPackages(arg string) {
+	var patterns []string
+	if file, ok := strings.CutPrefix(arg, "@"); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			Exitf("-disallow-packages: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	} else {
+		for _, p := range strings.Split(arg, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	for _, p := range patterns {
+		if strings.Contains(strings.TrimSuffix(p, "/..."), "...") {
+			Exitf("-disallow-packages: invalid package pattern %q: ... can only end a pattern, after a slash", p)
+		}
+	}
+	disallowedPackages = patterns
+}
+
+// packageDisallowed reports whether -disallow-packages disallows the
+// package pkg.
+func packageDisallowed(pkg string) bool {
+	for _, p := range disallowedPackages {
+		if dir, ok := strings.CutSuffix(p, "/..."); ok {
+			if pkg == dir || strings.HasPrefix(pkg, dir+"/") {
+				return true
+			}
+		} else if pkg == p {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDisallowedPackages fails the link if a package that
+// -disallow-packages disallows has live symbols, printing the chain of
+// references that keeps the first of them live. Only the functions and
+// variables of a package count, and not those that may be duplicated in
+// other packages, such as generic instantiations.
+func (d *deadcodePass) checkDisallowedPackages() {
+	first := make(map[string]loader.Sym)
+	count := make(map[string]int)
+	var pkgs []string
+	for s, n := loader.Sym(1), loader.Sym(d.ldr.NSym()); s < n; s++ {
+		if !d.ldr.AttrReachable(s) || d.ldr.AttrDuplicateOK(s) {
+			continue
+		}
+		// Read-only data, such as strings and stack object records,
+		// may be content-addressable and so shared with the packages
+		// that have the same.
+		if t := d.ldr.SymType(s); t != sym.STEXT && !t.IsData() {
+			continue
+		}
+		pkg := d.ldr.SymPkg(s)
+		if pkg == "" || !packageDisallowed(pkg) {
+			continue
+		}
+		if count[pkg] == 0 {
+			first[pkg] = s
+			pkgs = append(pkgs, pkg)
+		}
+		count[pkg]++
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		s := first[pkg]
+		chain := d.reachChain(d.ldr.SymName(s), s)
+		Errorf(nil, "package %s is disallowed by -disallow-packages, but has %d live symbols, such as %s", pkg, count[pkg], strings.TrimSuffix(chain, "\n"))
+	}
+	exitIfErrors()
+}
@@ -1156,7 +1156,7 @@ func (d *dwctxt) writeDirFileTables(unit *sym.CompilationUnit, lsu *loader.Symbo
 	// Preprocess files to collect directories. This assumes that the
 	// file table is already de-duped.
 	for i, name := range unit.FileTable {
-		name := expandFile(name)
+		name := remapDwarfPath(expandFile(name))
 		if len(name) == 0 {
 			// Can't have empty filenames, and having a unique
 			// filename is quite useful for debugging.
@@ -1261,8 +1261,13 @@ func (d *dwctxt) writelines(unit *sym.CompilationUnit, lineProlog loader.Sym) []
 	headerend = lsu.Size()
 	unitlen := lsu.Size() - unitstart
 
-	// Output the state machine for each function remaining.
-	for _, s := range unit.Textp {
+	// Output the state machine for each function remaining, unless
+	// -strip=lines leaves the table with only its header.
+	textp := unit.Textp
+	if stripLines {
+		textp = nil
+	}
+	for _, s := range textp {
 		fnSym := loader.Sym(s)
 		_, _, _, lines := d.ldr.GetFuncDwarfAuxSyms(fnSym)
 
@@ -1816,10 +1821,10 @@ func dwarfGenerateDebugInfo(ctxt *Link) {
 			if len(unit.Textp) == 0 {
 				cuabrv = dwarf.DW_ABRV_COMPUNIT_TEXTLESS
 			}
-			unit.DWInfo = d.newdie(&dwroot, cuabrv, unit.Lib.Pkg)
+			unit.DWInfo = d.newdie(&dwroot, cuabrv, remapDwarfPath(unit.Lib.Pkg))
 			newattr(unit.DWInfo, dwarf.DW_AT_language, dwarf.DW_CLS_CONSTANT, int64(dwarf.DW_LANG_Go), 0)
 			// OS X linker requires compilation dir or absolute path in comp unit name to output debug info.
-			compDir := getCompilationDir()
+			compDir := remapDwarfPath(getCompilationDir())
 			// TODO: Make this be the actual compilation directory, not
 			// the linker directory. If we move CU construction into the
 			// compiler, this should happen naturally.
@@ -1979,7 +1984,6 @@ func (d *dwctxt) dwUnitPortion(u *sym.CompilationUnit, abbrevsym loader.Sym, us
 
 func (d *dwctxt) dwarfGenerateDebugSyms() {
 	abbrevSec := d.writeabbrev()
-	dwarfp = append(dwarfp, abbrevSec)
 	d.calcCompUnitRanges()
 	sort.Sort(compilationUnitByStartPC(d.linkctxt.compUnits))
 
@@ -1990,6 +1994,12 @@ func (d *dwctxt) dwarfGenerateDebugSyms() {
 		reversetree(&u.DWInfo.Child)
 	}
 	reversetree(&dwtypes.Child)
+	// .BTF comes first, so that -split-dwarf, which moves the sections
+	// at the end of Segdwarf to the debug file, leaves it in place.
+	if *flagBTF {
+		dwarfp = append(dwarfp, d.writebtf())
+	}
+	dwarfp = append(dwarfp, abbrevSec)
 	movetomodule(d.linkctxt, &dwtypes)
 
 	mkSecSym := func(name string) loader.Sym {
@@ -2035,15 +2045,17 @@ func (d *dwctxt) dwarfGenerateDebugSyms() {
 
 	// Kick off generation of .debug_frame, since it doesn't have
 	// any entanglements and can be started right away.
-	wg.Add(1)
-	go func() {
-		sema <- struct{}{}
-		defer func() {
-			<-sema
-			wg.Done()
+	if !stripFrame {
+		wg.Add(1)
+		go func() {
+			sema <- struct{}{}
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			frameSec = d.writeframes(frameSym)
 		}()
-		frameSec = d.writeframes(frameSym)
-	}()
+	}
 
 	// Create a goroutine per comp unit to handle the generation that
 	// unit's portion of .debug_line, .debug_loc, .debug_ranges, and
@@ -2078,7 +2090,9 @@ func (d *dwctxt) dwarfGenerateDebugSyms() {
 		rangesSec.syms = append(rangesSec.syms, markReachable(r.rangessyms)...)
 	}
 	dwarfp = append(dwarfp, lineSec)
-	dwarfp = append(dwarfp, frameSec)
+	if !stripFrame {
+		dwarfp = append(dwarfp, frameSec)
+	}
 	gdbScriptSec := d.writegdbscript()
 	if gdbScriptSec.secSym() != 0 {
 		dwarfp = append(dwarfp, gdbScriptSec)
@@ -2088,6 +2102,13 @@ func (d *dwctxt) dwarfGenerateDebugSyms() {
 		dwarfp = append(dwarfp, locSec)
 	}
 	dwarfp = append(dwarfp, rangesSec)
+	if *flagDebugNames {
+		infosyms := make([][]loader.Sym, ncu)
+		for i := range unitSyms {
+			infosyms[i] = unitSyms[i].infosyms
+		}
+		dwarfp = append(dwarfp, d.writeDebugNames(infosyms)...)
+	}
 
 	// Check to make sure we haven't listed any symbols more than once
 	// in the info section. This used to be done by setting and
@@ -2131,11 +2152,28 @@ func dwarfaddshstrings(ctxt *Link, add func(string)) {
 
 	secs := []string{"abbrev", "frame", "info", "loc", "line", "gdb_scripts", "ranges"}
 	for _, sec := range secs {
+		if sec == "frame" && stripFrame {
+			continue
+		}
 		add(".debug_" + sec)
 		if ctxt.IsExternal() {
 			add(elfRelType + ".debug_" + sec)
 		}
 	}
+	if *flagDebugNames {
+		for _, sec := range []string{"names", "str"} {
+			add(".debug_" + sec)
+			if ctxt.IsExternal() {
+				add(elfRelType + ".debug_" + sec)
+			}
+		}
+	}
+	if *flagBTF {
+		add(".BTF")
+		if ctxt.IsExternal() {
+			add(elfRelType + ".BTF")
+		}
+	}
 }
 
 func dwarfaddelfsectionsyms(ctxt *Link) {
@@ -2173,8 +2211,14 @@ func dwarfcompress(ctxt *Link) {
 	var compressedCount int
 	resChannel := make(chan compressedSect)
 	for i := range dwarfp {
+		// The readers of BTF do not expect it compressed.
+		compress := ctxt.loader.SymName(dwarfp[i].secSym()) != ".BTF"
 		go func(resIndex int, syms []loader.Sym) {
-			resChannel <- compressedSect{resIndex, compressSyms(ctxt, syms), syms}
+			var compressed []byte
+			if compress {
+				compressed = compressSyms(ctxt, syms)
+			}
+			resChannel <- compressedSect{resIndex, compressed, syms}
 		}(compressedCount, dwarfp[i].syms)
 		compressedCount++
 	}
@@ -0,0 +1,418 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file writes the .debug_names section of -debugnames, the DWARF 5
+// name index (section 6.1.1) of the compilation units of the program,
+// with which a debugger finds the DIEs of a name without reading all of
+// .debug_info first. The names it holds are in a .debug_str section of
+// their own, as the DIEs have theirs inline.
+//
+// The index covers the DIEs that LLVM indexes: those of functions with
+// code, inlined calls, global variables, constants and types, under
+// their DW_AT_name or, for a DIE with an abstract origin, that of the
+// origin. It walks the DIEs of each unit as written, with the forms of
+// .debug_abbrev, before any relocation is applied.
+
+import (
+	"cmd/internal/dwarf"
+	"cmd/internal/objabi"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"encoding/binary"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The attributes of the entries of the name index (section 6.1.1.4.7).
+const (
+	dwIdxCompileUnit = 1 // DW_IDX_compile_unit
+	dwIdxDieOffset   = 3 // DW_IDX_die_offset
+)
+
+// dwNameAbbrev is an abbrev of .debug_abbrev.
+type dwNameAbbrev struct {
+	tag   uint64
+	attrs [][2]uint64 // attribute and form
+}
+
+// dwNameEntry is a DIE in the name index.
+type dwNameEntry struct {
+	tag uint64
+	cu  int   // the index of the unit in the list of units
+	off int64 // the offset of the DIE from the start of the unit
+}
+
+// dwNameIndex builds the name index.
+type dwNameIndex struct {
+	d       *dwctxt
+	abbrevs []dwNameAbbrev
+	names   map[string][]dwNameEntry
+}
+
+// writeDebugNames returns the .debug_names and .debug_str sections that
+// index the DIEs of the units, whose .debug_info symbols are infosyms.
+func (d *dwctxt) writeDebugNames(infosyms [][]loader.Sym) []dwarfSecInfo {
+	x := &dwNameIndex{
+		d:       d,
+		abbrevs: parseAbbrevs(dwarf.GetAbbrev()),
+		names:   make(map[string][]dwNameEntry),
+	}
+	var cus []loader.Sym
+	for _, syms := range infosyms {
+		if len(syms) == 0 {
+			continue
+		}
+		off := int64(0)
+		for _, s := range syms {
+			data := d.ldr.Data(s)
+			pos := 0
+			if off == 0 {
+				pos = COMPUNITHEADERSIZE
+			}
+			x.addDIEs(s, data, pos, len(cus), off)
+			off += int64(len(data))
+		}
+		cus = append(cus, syms[0])
+	}
+
+	// Lay the names out by bucket, and by hash within it.
+	type name struct {
+		name string
+		hash uint32
+	}
+	names := make([]name, 0, len(x.names))
+	hashes := make(map[uint32]bool)
+	for n := range x.names {
+		h := dwarfNameHash(n)
+		names = append(names, name{n, h})
+		hashes[h] = true
+	}
+	nbucket := len(hashes)
+	switch {
+	case nbucket > 1024:
+		nbucket /= 4
+	case nbucket > 16:
+		nbucket /= 2
+	case nbucket == 0:
+		nbucket = 1
+	}
+	sort.Slice(names, func(i, j int) bool {
+		bi, bj := names[i].hash%uint32(nbucket), names[j].hash%uint32(nbucket)
+		if bi != bj {
+			return bi < bj
+		}
+		if names[i].hash != names[j].hash {
+			return names[i].hash < names[j].hash
+		}
+		return names[i].name < names[j].name
+	})
+
+	// An abbrev for each tag, with the unit in the smallest data form
+	// that holds the number of units.
+	cuForm, cuSize := uint64(dwarf.DW_FORM_data1), 1
+	switch {
+	case len(cus) > 1<<16:
+		cuForm, cuSize = dwarf.DW_FORM_data4, 4
+	case len(cus) > 1<<8:
+		cuForm, cuSize = dwarf.DW_FORM_data2, 2
+	}
+	codes := make(map[uint64]uint64)
+	var tags []uint64
+	for _, n := range names {
+		for _, e := range x.names[n.name] {
+			if codes[e.tag] == 0 {
+				codes[e.tag] = 1
+				tags = append(tags, e.tag)
+			}
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	var abbrevTab []byte
+	for i, tag := range tags {
+		codes[tag] = uint64(i + 1)
+		abbrevTab = dwarf.AppendUleb128(abbrevTab, uint64(i+1))
+		abbrevTab = dwarf.AppendUleb128(abbrevTab, tag)
+		abbrevTab = dwarf.AppendUleb128(abbrevTab, dwIdxCompileUnit)
+		abbrevTab = dwarf.AppendUleb128(abbrevTab, cuForm)
+		abbrevTab = dwarf.AppendUleb128(abbrevTab, dwIdxDieOffset)
+		abbrevTab = dwarf.AppendUleb128(abbrevTab, dwarf.DW_FORM_ref4)
+		abbrevTab = append(abbrevTab, 0, 0)
+	}
+	abbrevTab = append(abbrevTab, 0)
+
+	// The entries of each name, ended by a zero abbrev code.
+	var pool []byte
+	entryOff := make([]uint32, len(names))
+	for i, n := range names {
+		entryOff[i] = uint32(len(pool))
+		entries := x.names[n.name]
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].cu != entries[j].cu {
+				return entries[i].cu < entries[j].cu
+			}
+			return entries[i].off < entries[j].off
+		})
+		for _, e := range entries {
+			pool = dwarf.AppendUleb128(pool, codes[e.tag])
+			var b [8]byte
+			switch cuSize {
+			case 1:
+				b[0] = uint8(e.cu)
+			case 2:
+				d.arch.ByteOrder.PutUint16(b[:], uint16(e.cu))
+			default:
+				d.arch.ByteOrder.PutUint32(b[:], uint32(e.cu))
+			}
+			d.arch.ByteOrder.PutUint32(b[cuSize:], uint32(e.off))
+			pool = append(pool, b[:cuSize+4]...)
+		}
+		pool = append(pool, 0)
+	}
+
+	str := d.ldr.CreateSymForUpdate(".debug_str", 0)
+	str.SetType(sym.SDWARFSECT)
+	str.SetReachable(true)
+	s := d.ldr.CreateSymForUpdate(".debug_names", 0)
+	s.SetType(sym.SDWARFSECT)
+	s.SetReachable(true)
+	d.createUnitLength(s, 0) // filled in below
+	s.AddUint16(d.arch, 5)   // version
+	s.AddUint16(d.arch, 0)   // padding
+	s.AddUint32(d.arch, uint32(len(cus)))
+	s.AddUint32(d.arch, 0) // local_type_unit_count
+	s.AddUint32(d.arch, 0) // foreign_type_unit_count
+	s.AddUint32(d.arch, uint32(nbucket))
+	s.AddUint32(d.arch, uint32(len(names)))
+	s.AddUint32(d.arch, uint32(len(abbrevTab)))
+	s.AddUint32(d.arch, 0) // augmentation_string_size
+	for _, cu := range cus {
+		d.addDwarfAddrRef(s, cu)
+	}
+	buckets := make([]uint32, nbucket)
+	for i := len(names) - 1; i >= 0; i-- {
+		buckets[names[i].hash%uint32(nbucket)] = uint32(i + 1)
+	}
+	for _, b := range buckets {
+		s.AddUint32(d.arch, b)
+	}
+	for _, n := range names {
+		s.AddUint32(d.arch, n.hash)
+	}
+	var strs []byte
+	for _, n := range names {
+		s.AddSymRef(d.arch, str.Sym(), int64(len(strs)), objabi.R_DWARFSECREF, 4)
+		strs = append(append(strs, n.name...), 0)
+	}
+	str.AddBytes(strs)
+	for _, off := range entryOff {
+		s.AddUint32(d.arch, off)
+	}
+	s.AddBytes(abbrevTab)
+	s.AddBytes(pool)
+	s.SetUint32(d.arch, 0, uint32(s.Size()-4))
+
+	return []dwarfSecInfo{{syms: []loader.Sym{s.Sym()}}, {syms: []loader.Sym{str.Sym()}}}
+}
+
+// addDIEs adds the DIEs that data, the contents of the .debug_info
+// symbol s at off in the unit cu, holds from pos on to the index.
+func (x *dwNameIndex) addDIEs(s loader.Sym, data []byte, pos, cu int, off int64) {
+	ldr := x.d.ldr
+	relocs := ldr.Relocs(s)
+	ri := 0
+	for pos < len(data) {
+		start := pos
+		code, n := binary.Uvarint(data[pos:])
+		pos += n
+		if n <= 0 || code >= uint64(len(x.abbrevs)) || (code != 0 && x.abbrevs[code].attrs == nil) {
+			ldr.Errorf(s, "bad DWARF abbrev at offset %d", start)
+			return
+		}
+		if code == 0 {
+			continue
+		}
+		ab := &x.abbrevs[code]
+		var name []byte
+		var origin, hasPC, hasAddr bool
+		for _, a := range ab.attrs {
+			attr, form := a[0], a[1]
+			apos, block := pos, -1
+			if pos >= len(data) && form != dwarf.DW_FORM_flag_present {
+				pos = len(data) + 1
+				break
+			}
+			switch form {
+			case dwarf.DW_FORM_string:
+				end := pos
+				for end < len(data) && data[end] != 0 {
+					end++
+				}
+				if attr == dwarf.DW_AT_name {
+					name = data[pos:end]
+				}
+				pos = end + 1
+			case dwarf.DW_FORM_addr:
+				pos += x.d.arch.PtrSize
+			case dwarf.DW_FORM_data1, dwarf.DW_FORM_ref1, dwarf.DW_FORM_flag:
+				pos++
+			case dwarf.DW_FORM_data2, dwarf.DW_FORM_ref2:
+				pos += 2
+			case dwarf.DW_FORM_data4, dwarf.DW_FORM_ref4, dwarf.DW_FORM_ref_addr, dwarf.DW_FORM_sec_offset, dwarf.DW_FORM_strp:
+				pos += 4
+			case dwarf.DW_FORM_data8, dwarf.DW_FORM_ref8, dwarf.DW_FORM_ref_sig8:
+				pos += 8
+			case dwarf.DW_FORM_udata, dwarf.DW_FORM_sdata, dwarf.DW_FORM_ref_udata:
+				// Skip the LEB128 bytes, as an SLEB128 can be one
+				// that Uvarint reports as overflowing.
+				for pos < len(data) && data[pos]&0x80 != 0 {
+					pos++
+				}
+				pos++
+			case dwarf.DW_FORM_block1:
+				block = pos + 1
+				pos = block + int(data[pos])
+			case dwarf.DW_FORM_block, dwarf.DW_FORM_exprloc:
+				v, n := binary.Uvarint(data[pos:])
+				block = pos + n
+				pos = block + int(v)
+			case dwarf.DW_FORM_flag_present:
+			default:
+				ldr.Errorf(s, "DWARF form %#x not supported by -debugnames", form)
+				return
+			}
+			switch attr {
+			case dwarf.DW_AT_low_pc, dwarf.DW_AT_ranges:
+				hasPC = true
+			case dwarf.DW_AT_location:
+				hasAddr = 0 <= block && block < pos && pos <= len(data) && data[block] == dwarf.DW_OP_addr
+			case dwarf.DW_AT_abstract_origin:
+				if form != dwarf.DW_FORM_ref_addr {
+					break
+				}
+				for ri < relocs.Count() && int(relocs.At(ri).Off()) < apos {
+					ri++
+				}
+				if ri < relocs.Count() && int(relocs.At(ri).Off()) == apos {
+					r := relocs.At(ri)
+					name = x.dieName(r.Sym(), r.Add())
+					origin = true
+				}
+			}
+		}
+		if pos > len(data) {
+			ldr.Errorf(s, "short DWARF DIE at offset %d", start)
+			return
+		}
+		if len(name) == 0 {
+			continue
+		}
+		switch ab.tag {
+		case dwarf.DW_TAG_compile_unit, dwarf.DW_TAG_member, dwarf.DW_TAG_formal_parameter, dwarf.DW_TAG_lexical_block:
+			continue
+		case dwarf.DW_TAG_subprogram, dwarf.DW_TAG_inlined_subroutine:
+			if !hasPC {
+				continue
+			}
+		case dwarf.DW_TAG_variable:
+			if !hasAddr || origin {
+				continue
+			}
+		}
+		e := dwNameEntry{tag: ab.tag, cu: cu, off: off + int64(start)}
+		if es, ok := x.names[string(name)]; ok {
+			x.names[string(name)] = append(es, e)
+		} else {
+			x.names[string(name)] = []dwNameEntry{e}
+		}
+	}
+}
+
+// dieName returns the DW_AT_name of the DIE at off in the .debug_info
+// symbol s.
+func (x *dwNameIndex) dieName(s loader.Sym, off int64) []byte {
+	data := x.d.ldr.Data(s)
+	if off < 0 || off >= int64(len(data)) {
+		return nil
+	}
+	data = data[off:]
+	code, n := binary.Uvarint(data)
+	if n <= 0 || code == 0 || code >= uint64(len(x.abbrevs)) {
+		return nil
+	}
+	// The Go abbrevs that have a DW_AT_name have it first.
+	a := x.abbrevs[code].attrs
+	if len(a) == 0 || a[0][0] != dwarf.DW_AT_name || a[0][1] != dwarf.DW_FORM_string {
+		return nil
+	}
+	for i := n; i < len(data); i++ {
+		if data[i] == 0 {
+			return data[n:i]
+		}
+	}
+	return nil
+}
+
+// parseAbbrevs returns the abbrevs of the .debug_abbrev contents b,
+// indexed by code.
+func parseAbbrevs(b []byte) []dwNameAbbrev {
+	uleb := func() uint64 {
+		v, n := binary.Uvarint(b)
+		b = b[n:]
+		return v
+	}
+	abbrevs := []dwNameAbbrev{{}}
+	for len(b) > 0 {
+		code := uleb()
+		if code == 0 {
+			break
+		}
+		ab := dwNameAbbrev{tag: uleb(), attrs: [][2]uint64{}}
+		b = b[1:] // children
+		for {
+			attr, form := uleb(), uleb()
+			if attr == 0 && form == 0 {
+				break
+			}
+			ab.attrs = append(ab.attrs, [2]uint64{attr, form})
+		}
+		for uint64(len(abbrevs)) <= code {
+			abbrevs = append(abbrevs, dwNameAbbrev{})
+		}
+		abbrevs[code] = ab
+	}
+	return abbrevs
+}
+
+// dwarfNameHash returns the hash of name in the name index: the DJB hash
+// of its case folding (section 7.33), which unicode.ToLower stands in
+// for outside ASCII.
+func dwarfNameHash(name string) uint32 {
+	h := uint32(5381)
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < len(name); {
+		c := name[i]
+		if c < utf8.RuneSelf {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			h = h*33 + uint32(c)
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(name[i:])
+		i += n
+		if r == 0x130 || r == 0x131 { // İ and ı fold to i
+			r = 'i'
+		} else {
+			r = unicode.ToLower(r)
+		}
+		for _, c := range buf[:utf8.EncodeRune(buf[:], r)] {
+			h = h*33 + uint32(c)
+		}
+	}
+	return h
+}
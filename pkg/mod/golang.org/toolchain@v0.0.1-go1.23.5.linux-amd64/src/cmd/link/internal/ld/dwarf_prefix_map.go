@@ -0,0 +1,164 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -dwarf-prefix-map, which rewrites the prefixes of
+// the paths in DWARF, as -fdebug-prefix-map does for C compilers, so
+// that the output names no directory of the machine it was built on.
+// The paths are those of the file tables of the line tables, the names
+// and compilation directories of the compilation units and the script
+// of .debug_gdb_scripts. Unlike -trimpath, it leaves the file names of
+// the pclntab, which runtime.Caller reports, as they are.
+//
+// When linking externally, the external linker copies the DWARF of the
+// host objects, such as the C code of cgo packages, which names the
+// directories they were compiled in. Those paths are rewritten in the
+// copies of the host objects, in place: each path keeps its length, the
+// new prefix being padded with slashes, so that no offset into the
+// DWARF changes. On Mach-O, the stabs that name the host objects, and
+// the directories of their sources, are stripped once dsymutil has read
+// them, so what remains is the DWARF.
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A dwarfPrefix is a mapping of -dwarf-prefix-map.
+type dwarfPrefix struct {
+	old, new string
+}
+
+// dwarfPrefixMaps are the mappings of -dwarf-prefix-map, in the order
+// given.
+var dwarfPrefixMaps []dwarfPrefix
+
+// addDwarfPrefixMap adds the mapping arg, of the form old=new.
+func addDwarfPrefixMap(arg string) {
+	old, new, ok := strings.Cut(arg, "=")
+	if !ok || old == "" {
+		Exitf("-dwarf-prefix-map: %q is not of the form old=new", arg)
+	}
+	dwarfPrefixMaps = append(dwarfPrefixMaps, dwarfPrefix{old, new})
+}
+
+// dwarfPrefixMatch returns the mapping of the prefix of path p, the last
+// given whose old prefix is p or a directory of it.
+func dwarfPrefixMatch(p string) (dwarfPrefix, bool) {
+	for i := len(dwarfPrefixMaps) - 1; i >= 0; i-- {
+		m := dwarfPrefixMaps[i]
+		if rest, ok := strings.CutPrefix(p, m.old); ok && (rest == "" || rest[0] == '/' || rest[0] == '\\' || strings.HasSuffix(m.old, "/")) {
+			return m, true
+		}
+	}
+	return dwarfPrefix{}, false
+}
+
+// remapDwarfPath returns the path p with its prefix rewritten by
+// -dwarf-prefix-map.
+func remapDwarfPath(p string) string {
+	if m, ok := dwarfPrefixMatch(p); ok {
+		return m.new + p[len(m.old):]
+	}
+	return p
+}
+
+// remapHostObjDwarf rewrites the paths in the DWARF sections of the ELF,
+// Mach-O or PE host object file in place. Other files are left as
+// they are.
+func remapHostObjDwarf(file string) error {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	type section struct {
+		name      string
+		off, size int64
+	}
+	var sects []section
+	if ef, err := elf.NewFile(f); err == nil {
+		for _, s := range ef.Sections {
+			// Compressed sections are left as they are.
+			if strings.HasPrefix(s.Name, ".debug_") && s.Type != elf.SHT_NOBITS && s.Flags&elf.SHF_COMPRESSED == 0 {
+				sects = append(sects, section{s.Name, int64(s.Offset), int64(s.FileSize)})
+			}
+		}
+	} else if mf, err := macho.NewFile(f); err == nil {
+		for _, s := range mf.Sections {
+			if s.Seg == "__DWARF" {
+				sects = append(sects, section{s.Name, int64(s.Offset), int64(s.Size)})
+			}
+		}
+	} else if pf, err := pe.NewFile(f); err == nil {
+		for _, s := range pf.Sections {
+			if strings.HasPrefix(s.Name, ".debug_") {
+				sects = append(sects, section{s.Name, int64(s.Offset), int64(s.Size)})
+			}
+		}
+	}
+
+	for _, s := range sects {
+		data := make([]byte, s.size)
+		if _, err := f.ReadAt(data, s.off); err != nil {
+			return fmt.Errorf("reading %s: %v", s.name, err)
+		}
+		changed, err := remapDwarfBytes(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.name, err)
+		}
+		if changed {
+			if _, err := f.WriteAt(data, s.off); err != nil {
+				return err
+			}
+		}
+	}
+	return f.Close()
+}
+
+// remapDwarfBytes rewrites the paths in data, the contents of a DWARF
+// section, that start with the old prefix of a mapping, keeping the
+// length of each by padding the new prefix with slashes. It returns
+// whether it changed data, or an error if the new prefix of a path is
+// longer than its old one.
+func remapDwarfBytes(data []byte) (bool, error) {
+	changed := false
+	for i := 0; i < len(data); {
+		// Find the next path that starts with an old prefix.
+		start := -1
+		for _, m := range dwarfPrefixMaps {
+			if j := bytes.Index(data[i:], []byte(m.old)); j >= 0 && (start < 0 || i+j < start) {
+				start = i + j
+			}
+		}
+		if start < 0 {
+			break
+		}
+		end := start + bytes.IndexByte(data[start:], 0)
+		if end < start {
+			end = len(data)
+		}
+		p := string(data[start:end])
+		m, ok := dwarfPrefixMatch(p)
+		if !ok {
+			i = start + 1
+			continue
+		}
+		pad := len(m.old) - len(m.new)
+		if pad < 0 {
+			return changed, fmt.Errorf("cannot rewrite %s, as %s is longer than %s", p, m.new, m.old)
+		}
+		copy(data[start:], m.new+strings.Repeat("/", pad))
+		changed = true
+		i = end
+	}
+	return changed, nil
+}
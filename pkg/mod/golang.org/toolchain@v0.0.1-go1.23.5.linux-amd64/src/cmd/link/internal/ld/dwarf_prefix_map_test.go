@@ -0,0 +1,134 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRemapDwarfPath(t *testing.T) {
+	defer func(maps []dwarfPrefix) { dwarfPrefixMaps = maps }(dwarfPrefixMaps)
+	dwarfPrefixMaps = []dwarfPrefix{
+		{"/home/gopher", "/src"},
+		{"/home/gopher/go/pkg/mod", "/mod"},
+		{`C:\Users\gopher`, "/src"},
+	}
+	for _, tt := range []struct{ in, want string }{
+		{"/home/gopher/p/main.go", "/src/p/main.go"},
+		{"/home/gopher", "/src"},
+		{"/home/gopher/go/pkg/mod/golang.org/x/sys@v0.1.0/unix/a.go", "/mod/golang.org/x/sys@v0.1.0/unix/a.go"},
+		{"/home/gophers/main.go", "/home/gophers/main.go"},
+		{`C:\Users\gopher\p\main.go`, `/src\p\main.go`},
+		{"main.go", "main.go"},
+	} {
+		if got := remapDwarfPath(tt.in); got != tt.want {
+			t.Errorf("remapDwarfPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	data := []byte("\x00/home/gopher/p\x00\x01\x02/home/gopher/go/pkg/mod/m@v1/a.c\x00/home/gophers\x00")
+	want := "\x00/src" + strings.Repeat("/", 8) + "/p\x00\x01\x02/mod" + strings.Repeat("/", 19) + "/m@v1/a.c\x00/home/gophers\x00"
+	if changed, err := remapDwarfBytes(data); err != nil || !changed || string(data) != want {
+		t.Errorf("remapDwarfBytes = %v, %v, %q, want %q", changed, err, data, want)
+	}
+	dwarfPrefixMaps = []dwarfPrefix{{"/a", "/longer"}}
+	if _, err := remapDwarfBytes([]byte("/a/b.c\x00")); err == nil {
+		t.Errorf("remapDwarfBytes with a longer new prefix succeeded")
+	}
+}
+
+// TestDwarfPrefixMap links a cgo program externally with its directory
+// mapped, and checks that the directory is in none of the paths of the
+// DWARF, of the Go or the C code.
+func TestDwarfPrefixMap(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "workspace")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":  "module example.com/p\n",
+		"main.go": "package main\n\n// int add(int a, int b);\nimport \"C\"\n\nfunc main() { println(C.add(1, 2)) }\n",
+		"add.c":   "int add(int a, int b) { return a + b; }\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exe := filepath.Join(dir, "p")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=external -dwarf-prefix-map="+dir+"=/src", "-o", exe, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		for _, f := range e.Field {
+			if s, ok := f.Val.(string); ok && strings.Contains(s, dir) {
+				t.Errorf("%v of compilation unit is %s", f.Attr, s)
+			}
+		}
+		if compDir, _ := e.Val(dwarf.AttrCompDir).(string); strings.HasPrefix(compDir, "/src/") || compDir == "/src" {
+			found["C compilation directory"] = true
+		}
+		lr, err := d.LineReader(e)
+		if err != nil || lr == nil {
+			continue
+		}
+		for _, lf := range lr.Files() {
+			if lf == nil {
+				continue
+			}
+			if strings.Contains(lf.Name, dir) {
+				t.Errorf("file %s of the line table of %s", lf.Name, e.Val(dwarf.AttrName))
+			}
+			if lf.Name == "/src/main.go" {
+				found["main.go"] = true
+			}
+		}
+		r.SkipChildren()
+	}
+	for _, what := range []string{"main.go", "C compilation directory"} {
+		if !found[what] {
+			t.Errorf("no mapped %s in the DWARF", what)
+		}
+	}
+}
@@ -5,8 +5,11 @@
 package ld
 
 import (
+	"bytes"
 	"debug/dwarf"
+	"debug/elf"
 	"debug/pe"
+	"encoding/binary"
 	"fmt"
 	"internal/platform"
 	"internal/testenv"
@@ -2075,3 +2078,186 @@ func TestConsistentGoKindAndRuntimeType(t *testing.T) {
 		t.Logf("%d types checked\n", typesChecked)
 	}
 }
+
+func TestDebugNames(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	mustHaveDWARF(t)
+	t.Parallel()
+
+	const prog = `
+package main
+
+import "os"
+
+type T struct{ A, B int }
+
+const C = 42
+
+var x = T{1, 2}
+
+//go:noinline
+func (t *T) Sum() int { return t.A + t.B }
+
+func inl(a int) int { return a * C }
+
+func main() { println(x.Sum(), inl(len(os.Args))) }
+`
+	dir := t.TempDir()
+	f := gobuild(t, dir, prog, "-ldflags=-debugnames")
+	defer f.Close()
+	ef, err := elf.Open(f.path)
+	if err != nil {
+		t.Skipf("not an ELF executable: %v", err)
+	}
+	defer ef.Close()
+	d, err := ef.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, str := ef.Section(".debug_names"), ef.Section(".debug_str")
+	if names == nil || str == nil {
+		t.Fatalf("no .debug_names or .debug_str section")
+	}
+	b, err := names.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	strs, err := str.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The header, and the arrays that follow it.
+	bo := ef.ByteOrder
+	if len(b) < 40 || int(bo.Uint32(b))+4 != len(b) || bo.Uint16(b[4:]) != 5 {
+		t.Fatalf("bad .debug_names header % x", b[:min(len(b), 40)])
+	}
+	ncu, nbucket, nname := int(bo.Uint32(b[8:])), int(bo.Uint32(b[20:])), int(bo.Uint32(b[24:]))
+	abbrevSize := int(bo.Uint32(b[28:]))
+	words := func(off, n int) []uint32 {
+		w := make([]uint32, n)
+		for i := range w {
+			w[i] = bo.Uint32(b[off+4*i:])
+		}
+		return w
+	}
+	off := 36
+	cus := words(off, ncu)
+	off += 4 * ncu
+	buckets := words(off, nbucket)
+	off += 4 * nbucket
+	hashes := words(off, nname)
+	off += 4 * nname
+	strOffs := words(off, nname)
+	off += 4 * nname
+	entryOffs := words(off, nname)
+	off += 4 * nname
+	// The abbrevs of .debug_names have no DW_CHILDREN byte.
+	abbrevs := make(map[uint64]dwNameAbbrev)
+	for a := b[off : off+abbrevSize]; len(a) > 0; {
+		uleb := func() uint64 {
+			v, n := binary.Uvarint(a)
+			a = a[n:]
+			return v
+		}
+		code := uleb()
+		if code == 0 {
+			break
+		}
+		ab := dwNameAbbrev{tag: uleb()}
+		for {
+			idx, form := uleb(), uleb()
+			if idx == 0 && form == 0 {
+				break
+			}
+			ab.attrs = append(ab.attrs, [2]uint64{idx, form})
+		}
+		abbrevs[code] = ab
+	}
+	pool := b[off+abbrevSize:]
+
+	cstring := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			return string(b[:i])
+		}
+		return string(b)
+	}
+	for i := range hashes {
+		if name := cstring(strs[strOffs[i]:]); hashes[i] != dwarfNameHash(name) {
+			t.Errorf("hash of %q is %#x, want %#x", name, hashes[i], dwarfNameHash(name))
+		}
+	}
+
+	// lookup returns the DIEs indexed under name, as a debugger would
+	// find them.
+	lookup := func(name string) []*dwarf.Entry {
+		h := dwarfNameHash(name)
+		var dies []*dwarf.Entry
+		for i := int(buckets[h%uint32(nbucket)]) - 1; i >= 0 && i < nname && hashes[i]%uint32(nbucket) == h%uint32(nbucket); i++ {
+			if hashes[i] != h || cstring(strs[strOffs[i]:]) != name {
+				continue
+			}
+			e := pool[entryOffs[i]:]
+			for {
+				code, n := binary.Uvarint(e)
+				e = e[n:]
+				if code == 0 {
+					break
+				}
+				var cu, dieOff uint64
+				for _, a := range abbrevs[code].attrs {
+					var v uint64
+					switch a[1] {
+					case intdwarf.DW_FORM_data1:
+						v, e = uint64(e[0]), e[1:]
+					case intdwarf.DW_FORM_data2:
+						v, e = uint64(bo.Uint16(e)), e[2:]
+					case intdwarf.DW_FORM_data4, intdwarf.DW_FORM_ref4:
+						v, e = uint64(bo.Uint32(e)), e[4:]
+					default:
+						t.Fatalf("unexpected form %#x in the abbrevs of .debug_names", a[1])
+					}
+					switch a[0] {
+					case dwIdxCompileUnit:
+						cu = v
+					case dwIdxDieOffset:
+						dieOff = v
+					}
+				}
+				r := d.Reader()
+				r.Seek(dwarf.Offset(uint64(cus[cu]) + dieOff))
+				die, err := r.Next()
+				if err != nil || die == nil || uint64(die.Tag) != abbrevs[code].tag {
+					t.Fatalf("%s: entry at CU %d offset %#x is %v, %v, want a DIE with tag %#x", name, cu, dieOff, die, err, abbrevs[code].tag)
+				}
+				dies = append(dies, die)
+			}
+		}
+		return dies
+	}
+	for _, tc := range []struct {
+		name string
+		tag  dwarf.Tag
+	}{
+		{"main.main", dwarf.TagSubprogram},
+		{"main.(*T).Sum", dwarf.TagSubprogram},
+		{"MAIN.main", 0},
+		{"main.inl", dwarf.TagInlinedSubroutine},
+		{"main.x", dwarf.TagVariable},
+		{"main.C", dwarf.TagConstant},
+		{"main.T", dwarf.TagStructType},
+		{"*main.T", dwarf.TagPointerType},
+	} {
+		dies := lookup(tc.name)
+		found := false
+		for _, die := range dies {
+			found = found || die.Tag == tc.tag
+		}
+		if !found && tc.tag != 0 {
+			t.Errorf("%s: found %v, want a DIE with tag %v", tc.name, dies, tc.tag)
+		}
+		if tc.tag == 0 && len(dies) != 0 {
+			t.Errorf("%s: found %v, want none", tc.name, dies)
+		}
+	}
+}
@@ -807,6 +807,9 @@ func elfwritefreebsdsig(out *OutBuf) int {
 
 func addbuildinfo(ctxt *Link) {
 	val := *flagHostBuildid
+	if machoUuidPolicy == "gobuildid" {
+		val = "gobuildid" // as for the default -B gobuildid
+	}
 	if val == "gobuildid" {
 		buildID := *flagBuildid
 		if buildID == "" {
@@ -814,16 +817,40 @@ func addbuildinfo(ctxt *Link) {
 		}
 
 		if ctxt.IsDarwin() {
-			buildinfo = uuidFromGoBuildId(buildID)
+			if *flagBuildidLen != 0 && *flagBuildidLen != 16 {
+				Exitf("-buildidlen must be 16 for a Mach-O UUID, not %d", *flagBuildidLen)
+			}
+			if *flagBuildidHash == "" || *flagBuildidHash == "uuid" {
+				buildinfo = machoBuildIdUuid(buildID, machoTargetCpu(ctxt.Arch))
+				return
+			}
+			h, err := hostBuildIDFromGoBuildID(machoSaltBuildId(buildID), *flagBuildidHash)
+			if err != nil {
+				Exitf("-buildidhash: %v", err)
+			}
+			buildinfo = uuidFromHash(h)
 			return
 		}
 
-		hashedBuildID := notsha256.Sum256([]byte(buildID))
-		buildinfo = hashedBuildID[:20]
+		h, err := hostBuildIDFromGoBuildID(buildID, *flagBuildidHash)
+		if err != nil {
+			Exitf("-buildidhash: %v", err)
+		}
+		if n := *flagBuildidLen; n != 0 {
+			if n < 1 || n > len(h) {
+				Exitf("-buildidlen must be between 1 and %d, not %d", len(h), n)
+			}
+			h = h[:n]
+		}
+		buildinfo = h
 
 		return
 	}
 
+	if *flagBuildidHash != "" {
+		Exitf("-buildidhash requires -B gobuildid")
+	}
+
 	if !strings.HasPrefix(val, "0x") {
 		Exitf("-B argument must start with 0x: %s", val)
 	}
@@ -848,10 +875,47 @@ func addbuildinfo(ctxt *Link) {
 		}
 		Exitf("-B argument contains invalid hex: %s", ov)
 	}
+	if n := *flagBuildidLen; n != 0 && n != len(b) {
+		Exitf("-B argument is %d bytes, but -buildidlen is %d: %s", len(b), n, ov)
+	}
 
 	buildinfo = b
 }
 
+// hostBuildIDFromGoBuildID returns the ELF NT_GNU_BUILD_ID note that
+// -B gobuildid derives from the Go build ID with the -buildidhash
+// algorithm alg:
+//
+//	notsha256 (or "") the first 20 bytes of the NOTSHA256 of buildID
+//	sha1              the first 20 bytes of the SHA-256 of buildID, the
+//	                  size of the IDs of GNU ld --build-id=sha1
+//	sha256            the SHA-256 of buildID
+//	uuid              the 16-byte UUID -B gobuildid gives on Mach-O
+//
+// The linker does not depend on crypto/sha1, which uses cgo in a
+// boringcrypto toolchain, so sha1 only gives an ID of its size; the
+// build ID is no hash of the output contents that could be checked
+// against one anyway.
+func hostBuildIDFromGoBuildID(buildID, alg string) ([]byte, error) {
+	switch alg {
+	case "", "notsha256":
+		h := notsha256.Sum256([]byte(buildID))
+		return h[:20], nil
+	case "sha1", "sha256":
+		h := notsha256.Sum256([]byte(buildID))
+		for i := range h {
+			h[i] ^= 0xFF // convert notsha256 to sha256
+		}
+		if alg == "sha1" {
+			return h[:20], nil
+		}
+		return h[:], nil
+	case "uuid":
+		return uuidFromGoBuildId(buildID), nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q (want notsha256, sha1, sha256 or uuid)", alg)
+}
+
 // Build info note
 const (
 	ELF_NOTE_BUILDINFO_NAMESZ = 4
@@ -900,10 +964,11 @@ func elfwritegobuildid(out *OutBuf) int {
 
 // Go specific notes
 const (
-	ELF_NOTE_GOPKGLIST_TAG = 1
-	ELF_NOTE_GOABIHASH_TAG = 2
-	ELF_NOTE_GODEPS_TAG    = 3
-	ELF_NOTE_GOBUILDID_TAG = 4
+	ELF_NOTE_GOPKGLIST_TAG    = 1
+	ELF_NOTE_GOABIHASH_TAG    = 2
+	ELF_NOTE_GODEPS_TAG       = 3
+	ELF_NOTE_GOBUILDID_TAG    = 4
+	ELF_NOTE_GOPROVENANCE_TAG = 5
 )
 
 var ELF_NOTE_GO_NAME = []byte("Go\x00\x00")
@@ -968,6 +1033,7 @@ func elfdynhash(ctxt *Link) {
 
 	var needlib *Elflib
 	need := make([]*Elfaux, nsym)
+	def := make([]uint16, nsym)
 	chain := make([]uint32, nsym)
 	buckets := make([]uint32, nbucket)
 
@@ -979,6 +1045,9 @@ func elfdynhash(ctxt *Link) {
 		}
 
 		name := ldr.SymExtname(sy)
+		if ldr.SymType(sy) != sym.SDYNIMPORT {
+			def[dynid], _ = elfSymVersion(name)
+		}
 		hc := elfhash(name)
 
 		b := hc % uint32(nbucket)
@@ -986,6 +1055,18 @@ func elfdynhash(ctxt *Link) {
 		buckets[b] = uint32(dynid)
 	}
 
+	// glibc refuses to load an object with DT_RELR that links against
+	// it without a dependency on the GLIBC_ABI_DT_RELR version, which
+	// keeps versions that do not know DT_RELR from loading it at all.
+	if len(elfrelr) > 0 {
+		for lib := needlib; lib != nil; lib = lib.next {
+			if lib.file == "libc.so.6" {
+				addelflib(&needlib, lib.file, "GLIBC_ABI_DT_RELR")
+				break
+			}
+		}
+	}
+
 	// s390x (ELF64) hash table entries are 8 bytes
 	if ctxt.Arch.Family == sys.S390X {
 		s.AddUint64(ctxt.Arch, uint64(nbucket))
@@ -1012,7 +1093,7 @@ func elfdynhash(ctxt *Link) {
 	// version symbols
 	gnuVersionR := ldr.CreateSymForUpdate(".gnu.version_r", 0)
 	s = gnuVersionR
-	i = 2
+	i = 2 + elfNamedVersions() // after the versions of .gnu.version_d
 	nfile := 0
 	for l := needlib; l != nil; l = l.next {
 		nfile++
@@ -1049,6 +1130,44 @@ func elfdynhash(ctxt *Link) {
 		}
 	}
 
+	// version definitions, those of -version-script after the base
+	// version, which names the object
+	gnuVersionD := ldr.CreateSymForUpdate(".gnu.version_d", 0)
+	s = gnuVersionD
+	elfverdef = 0
+	if n := elfNamedVersions(); n > 0 {
+		base := *flagSoname
+		if base == "" {
+			base = filepath.Base(*flagOutfile)
+		}
+		for i := 0; i <= n; i++ {
+			names, flags := []string{base}, uint16(VER_FLG_BASE)
+			if i > 0 {
+				names, flags = append([]string{elfVersions[i-1].name}, elfVersions[i-1].parents...), 0
+			}
+			s.AddUint16(ctxt.Arch, 1)                  // table version
+			s.AddUint16(ctxt.Arch, flags)              // flags
+			s.AddUint16(ctxt.Arch, uint16(i+1))        // index we refer to this by
+			s.AddUint16(ctxt.Arch, uint16(len(names))) // aux count: the version and its parents
+			s.AddUint32(ctxt.Arch, elfhash(names[0]))  // hash
+			s.AddUint32(ctxt.Arch, 20)                 // offset from header to first aux
+			if i < n {
+				s.AddUint32(ctxt.Arch, 20+uint32(len(names))*8) // offset from this header to next
+			} else {
+				s.AddUint32(ctxt.Arch, 0)
+			}
+			for j, name := range names {
+				s.AddUint32(ctxt.Arch, uint32(dynstr.Addstring(name))) // version string offset
+				if j < len(names)-1 {
+					s.AddUint32(ctxt.Arch, 8) // offset from this aux to next
+				} else {
+					s.AddUint32(ctxt.Arch, 0)
+				}
+			}
+		}
+		elfverdef = n + 1
+	}
+
 	// version references
 	gnuVersion := ldr.CreateSymForUpdate(".gnu.version", 0)
 	s = gnuVersion
@@ -1056,10 +1175,12 @@ func elfdynhash(ctxt *Link) {
 	for i := 0; i < nsym; i++ {
 		if i == 0 {
 			s.AddUint16(ctxt.Arch, 0) // first entry - no symbol
-		} else if need[i] == nil {
-			s.AddUint16(ctxt.Arch, 1) // global
-		} else {
+		} else if need[i] != nil {
 			s.AddUint16(ctxt.Arch, uint16(need[i].num))
+		} else if def[i] != 0 {
+			s.AddUint16(ctxt.Arch, def[i])
+		} else {
+			s.AddUint16(ctxt.Arch, 1) // global
 		}
 	}
 
@@ -1079,6 +1200,12 @@ func elfdynhash(ctxt *Link) {
 	if elfverneed != 0 {
 		elfWriteDynEntSym(ctxt, s, elf.DT_VERNEED, gnuVersionR.Sym())
 		Elfwritedynent(ctxt.Arch, s, elf.DT_VERNEEDNUM, uint64(nfile))
+	}
+	if elfverdef != 0 {
+		elfWriteDynEntSym(ctxt, s, elf.DT_VERDEF, gnuVersionD.Sym())
+		Elfwritedynent(ctxt.Arch, s, elf.DT_VERDEFNUM, uint64(elfverdef))
+	}
+	if elfverneed != 0 || elfverdef != 0 {
 		elfWriteDynEntSym(ctxt, s, elf.DT_VERSYM, gnuVersion.Sym())
 	}
 
@@ -1228,7 +1355,7 @@ func elfshbits(linkmode LinkMode, sect *sym.Section) *ElfShdr {
 		sh.Addr = sect.Vaddr
 	}
 
-	if strings.HasPrefix(sect.Name, ".debug") || strings.HasPrefix(sect.Name, ".zdebug") {
+	if strings.HasPrefix(sect.Name, ".debug") || strings.HasPrefix(sect.Name, ".zdebug") || sect.Name == ".BTF" {
 		sh.Flags = 0
 		sh.Addr = 0
 		if sect.Compressed {
@@ -1428,6 +1555,9 @@ func (ctxt *Link) doelf() {
 
 	shstrtabAddstring("")
 	shstrtabAddstring(".text")
+	if *flagSplitCold {
+		shstrtabAddstring(coldTextSect)
+	}
 	shstrtabAddstring(".noptrdata")
 	shstrtabAddstring(".data")
 	shstrtabAddstring(".bss")
@@ -1478,8 +1608,12 @@ func (ctxt *Link) doelf() {
 
 	if ctxt.IsExternal() {
 		*FlagD = true
-
+	}
+	if ctxt.IsExternal() || *flagEmitRelocs {
 		shstrtabAddstring(elfRelType + ".text")
+		if *flagSplitCold {
+			shstrtabAddstring(elfRelType + coldTextSect)
+		}
 		shstrtabAddstring(elfRelType + ".rodata")
 		shstrtabAddstring(elfRelType + relro_prefix + ".typelink")
 		shstrtabAddstring(elfRelType + relro_prefix + ".itablink")
@@ -1495,7 +1629,8 @@ func (ctxt *Link) doelf() {
 			shstrtabAddstring(elfRelType + ".MIPS.abiflags")
 			shstrtabAddstring(elfRelType + ".gnu.attributes")
 		}
-
+	}
+	if ctxt.IsExternal() {
 		// add a .note.GNU-stack section to mark the stack as non-executable
 		shstrtabAddstring(".note.GNU-stack")
 
@@ -1526,11 +1661,16 @@ func (ctxt *Link) doelf() {
 	if !*FlagW {
 		dwarfaddshstrings(ctxt, shstrtabAddstring)
 	}
+	if *flagSplitDwarf != "" {
+		shstrtabAddstring(".gnu_debuglink")
+	}
 
 	shstrtabAddstring(".shstrtab")
 
 	if !*FlagD { /* -d suppresses dynamic loader format */
-		shstrtabAddstring(".interp")
+		if !ctxt.isStaticPIE() {
+			shstrtabAddstring(".interp")
+		}
 		shstrtabAddstring(".hash")
 		shstrtabAddstring(".got")
 		if ctxt.IsPPC64() {
@@ -1542,10 +1682,16 @@ func (ctxt *Link) doelf() {
 		shstrtabAddstring(".dynstr")
 		shstrtabAddstring(elfRelType)
 		shstrtabAddstring(elfRelType + ".plt")
+		if *flagPackRelativeRelocs {
+			shstrtabAddstring(".relr.dyn")
+		}
 
 		shstrtabAddstring(".plt")
 		shstrtabAddstring(".gnu.version")
 		shstrtabAddstring(".gnu.version_r")
+		if elfNamedVersions() > 0 {
+			shstrtabAddstring(".gnu.version_d")
+		}
 
 		/* dynamic symbol table - first entry all zeros */
 		dynsym := ldr.CreateSymForUpdate(".dynsym", 0)
@@ -1606,12 +1752,23 @@ func (ctxt *Link) doelf() {
 		s = ldr.CreateSymForUpdate(elfRelType+".plt", 0)
 		s.SetType(sym.SELFROSECT)
 
+		/* packed relative relocations, sized by elfSizeRelr */
+		if *flagPackRelativeRelocs {
+			s = ldr.CreateSymForUpdate(".relr.dyn", 0)
+			s.SetType(sym.SELFRELROSECT)
+		}
+
 		s = ldr.CreateSymForUpdate(".gnu.version", 0)
 		s.SetType(sym.SELFROSECT)
 
 		s = ldr.CreateSymForUpdate(".gnu.version_r", 0)
 		s.SetType(sym.SELFROSECT)
 
+		if elfNamedVersions() > 0 {
+			s = ldr.CreateSymForUpdate(".gnu.version_d", 0)
+			s.SetType(sym.SELFROSECT)
+		}
+
 		/* define dynamic elf table */
 		dynamic := ldr.CreateSymForUpdate(".dynamic", 0)
 		switch {
@@ -1653,10 +1810,19 @@ func (ctxt *Link) doelf() {
 			elfwritedynentsymsize(ctxt, dynamic, elf.DT_RELSZ, rel)
 			Elfwritedynent(ctxt.Arch, dynamic, elf.DT_RELENT, ELF32RELSIZE)
 		}
+		if *flagPackRelativeRelocs {
+			relr := ldr.Lookup(".relr.dyn", 0)
+			elfWriteDynEntSym(ctxt, dynamic, DT_RELR, relr)
+			elfwritedynentsymsize(ctxt, dynamic, DT_RELRSZ, relr)
+			Elfwritedynent(ctxt.Arch, dynamic, DT_RELRENT, 8)
+		}
 
 		if rpath.val != "" {
 			Elfwritedynent(ctxt.Arch, dynamic, elf.DT_RUNPATH, uint64(dynstr.Addstring(rpath.val)))
 		}
+		if *flagSoname != "" {
+			Elfwritedynent(ctxt.Arch, dynamic, elf.DT_SONAME, uint64(dynstr.Addstring(*flagSoname)))
+		}
 
 		if ctxt.IsPPC64() {
 			elfWriteDynEntSym(ctxt, dynamic, elf.DT_PLTGOT, plt.Sym())
@@ -1733,6 +1899,13 @@ func (ctxt *Link) doelf() {
 			gnuattributes.AddUint8(MIPS_FPABI_ANY)
 		}
 	}
+
+	if len(addedSections) > 0 {
+		elfAddSections(ctxt, shstrtabAddstring)
+	}
+	if ctxt.IsInternal() {
+		elfAddGNUProperty(ctxt, shstrtabAddstring)
+	}
 }
 
 // Do not write DT_NULL.  elfdynhash will finish it.
@@ -1781,8 +1954,12 @@ func Asmbelfsetup() {
 	for _, sect := range Segdata.Sections {
 		elfshalloc(sect)
 	}
-	for _, sect := range Segdwarf.Sections {
-		elfshalloc(sect)
+	// For -split-dwarf, the DWARF sections get the last section headers
+	// in asmbElf, so that they can be dropped.
+	if *flagSplitDwarf == "" {
+		for _, sect := range Segdwarf.Sections {
+			elfshalloc(sect)
+		}
 	}
 }
 
@@ -1800,6 +1977,8 @@ func asmbElf(ctxt *Link) {
 		ctxt.Out.Write(elfshstrdat)
 		if ctxt.IsExternal() {
 			elfEmitReloc(ctxt)
+		} else if *flagEmitRelocs {
+			elfEmitRelocs(ctxt)
 		}
 	}
 	ctxt.Out.SeekSet(0)
@@ -1902,9 +2081,10 @@ func asmbElf(ctxt *Link) {
 
 	/*
 	 * PHDR must be in a loaded segment. Adjust the text
-	 * segment boundaries downwards to include it.
+	 * segment boundaries downwards to include it, unless
+	 * -z separate-code gives the headers a segment of their own.
 	 */
-	{
+	if elfZSeparateCode <= 0 {
 		o := int64(Segtext.Vaddr - pph.Vaddr)
 		Segtext.Vaddr -= uint64(o)
 		Segtext.Length += uint64(o)
@@ -1913,7 +2093,7 @@ func asmbElf(ctxt *Link) {
 		Segtext.Filelen += uint64(o)
 	}
 
-	if !*FlagD { /* -d suppresses dynamic loader format */
+	if !*FlagD && !ctxt.isStaticPIE() { /* -d suppresses dynamic loader format */
 		/* interpreter */
 		sh := elfshname(".interp")
 
@@ -2008,6 +2188,18 @@ func asmbElf(ctxt *Link) {
 
 	// Additions to the reserved area must be above this line.
 
+	if elfZSeparateCode > 0 {
+		// The headers and the reserved area, which elfZLayout
+		// rounded up to a page, are read-only.
+		ph := newElfPhdr()
+		ph.Type = elf.PT_LOAD
+		ph.Flags = elf.PF_R
+		ph.Vaddr = uint64(startva)
+		ph.Paddr = uint64(startva)
+		ph.Filesz = uint64(HEADR)
+		ph.Memsz = uint64(HEADR)
+		ph.Align = uint64(*FlagRound)
+	}
 	elfphload(&Segtext)
 	if len(Segrodata.Sections) > 0 {
 		elfphload(&Segrodata)
@@ -2049,7 +2241,7 @@ func asmbElf(ctxt *Link) {
 		sh.Addralign = 1
 		shsym(sh, ldr, ldr.Lookup(".dynstr", 0))
 
-		if elfverneed != 0 {
+		if elfverneed != 0 || elfverdef != 0 {
 			sh := elfshname(".gnu.version")
 			sh.Type = uint32(elf.SHT_GNU_VERSYM)
 			sh.Flags = uint64(elf.SHF_ALLOC)
@@ -2057,8 +2249,10 @@ func asmbElf(ctxt *Link) {
 			sh.Link = uint32(elfshname(".dynsym").shnum)
 			sh.Entsize = 2
 			shsym(sh, ldr, ldr.Lookup(".gnu.version", 0))
+		}
 
-			sh = elfshname(".gnu.version_r")
+		if elfverneed != 0 {
+			sh := elfshname(".gnu.version_r")
 			sh.Type = uint32(elf.SHT_GNU_VERNEED)
 			sh.Flags = uint64(elf.SHF_ALLOC)
 			sh.Addralign = uint64(ctxt.Arch.RegSize)
@@ -2067,6 +2261,16 @@ func asmbElf(ctxt *Link) {
 			shsym(sh, ldr, ldr.Lookup(".gnu.version_r", 0))
 		}
 
+		if elfverdef != 0 {
+			sh := elfshname(".gnu.version_d")
+			sh.Type = uint32(elf.SHT_GNU_VERDEF)
+			sh.Flags = uint64(elf.SHF_ALLOC)
+			sh.Addralign = 4
+			sh.Info = uint32(elfverdef)
+			sh.Link = uint32(elfshname(".dynstr").shnum)
+			shsym(sh, ldr, ldr.Lookup(".gnu.version_d", 0))
+		}
+
 		if elfRelType == ".rela" {
 			sh := elfshname(".rela.plt")
 			sh.Type = uint32(elf.SHT_RELA)
@@ -2084,6 +2288,15 @@ func asmbElf(ctxt *Link) {
 			sh.Addralign = 8
 			sh.Link = uint32(elfshname(".dynsym").shnum)
 			shsym(sh, ldr, ldr.Lookup(".rela", 0))
+
+			if *flagPackRelativeRelocs {
+				sh = elfshname(".relr.dyn")
+				sh.Type = uint32(SHT_RELR)
+				sh.Flags = uint64(elf.SHF_ALLOC)
+				sh.Entsize = 8
+				sh.Addralign = 8
+				shsym(sh, ldr, ldr.Lookup(".relr.dyn", 0))
+			}
 		} else {
 			sh := elfshname(".rel.plt")
 			sh.Type = uint32(elf.SHT_REL)
@@ -2245,6 +2458,25 @@ elfobj:
 	for _, sect := range Segdata.Sections {
 		elfshbits(ctxt.LinkMode, sect)
 	}
+	if len(addedSections) > 0 {
+		elfAddNoteHeaders(ctxt)
+	}
+	if ctxt.IsInternal() {
+		elfAddGNUPropertyHeaders(ctxt)
+	}
+	if *flagEmitRelocs {
+		for _, sect := range emitRelocsSections() {
+			if sect.Rellen != 0 {
+				elfshreloc(ctxt.Arch, sect)
+			}
+		}
+	}
+	if *flagSplitDwarf != "" {
+		// Filled in by elfSplitDwarf.
+		sh := elfshname(".gnu_debuglink")
+		sh.Type = uint32(elf.SHT_PROGBITS)
+		sh.Addralign = 4
+	}
 	for _, sect := range Segdwarf.Sections {
 		elfshbits(ctxt.LinkMode, sect)
 	}
@@ -2353,7 +2585,7 @@ elfobj:
 	a += int64(elfwritehdr(ctxt.Out))
 	a += int64(elfwritephdrs(ctxt.Out))
 	a += int64(elfwriteshdrs(ctxt.Out))
-	if !*FlagD {
+	if !*FlagD && !ctxt.isStaticPIE() {
 		a += int64(elfwriteinterp(ctxt.Out))
 	}
 	if ctxt.IsMIPS() {
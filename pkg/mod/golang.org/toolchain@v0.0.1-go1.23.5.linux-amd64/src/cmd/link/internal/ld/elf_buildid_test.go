@@ -0,0 +1,54 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestHostBuildIDFromGoBuildID(t *testing.T) {
+	const id = "abc/def/ghi/jkl"
+	sum := sha256.Sum256([]byte(id))
+	for _, tc := range []struct {
+		alg  string
+		want []byte
+	}{
+		{"", nil},
+		{"notsha256", nil},
+		{"sha1", sum[:20]},
+		{"sha256", sum[:]},
+		{"uuid", uuidFromGoBuildId(id)},
+	} {
+		got, err := hostBuildIDFromGoBuildID(id, tc.alg)
+		if err != nil {
+			t.Errorf("%q: %v", tc.alg, err)
+			continue
+		}
+		if tc.want == nil {
+			// NOTSHA256, the build ID -B gobuildid has always given.
+			for i, b := range sum[:20] {
+				if got[i] != ^b {
+					t.Errorf("%q: got %x, want the NOT of %x", tc.alg, got, sum[:20])
+					break
+				}
+			}
+			if len(got) != 20 {
+				t.Errorf("%q: got %d bytes, want 20", tc.alg, len(got))
+			}
+			continue
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("%q: got %x, want %x", tc.alg, got, tc.want)
+		}
+	}
+	if again, _ := hostBuildIDFromGoBuildID(id, "sha256"); !bytes.Equal(again, sum[:]) {
+		t.Errorf("sha256 is not deterministic: %x, then %x", sum, again)
+	}
+	if _, err := hostBuildIDFromGoBuildID(id, "md5"); err == nil {
+		t.Error("md5 accepted")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the .note.gnu.property section of ELF output. Its
+// NT_GNU_PROPERTY_TYPE_0 note says which hardware control-flow
+// protection features all of the code is compatible with, IBT and SHSTK
+// on x86 and BTI on arm64, so that the loader can enable them, and on
+// x86 which ISA level and features the code needs.
+//
+// When linking internally, the properties of the host objects are merged
+// as GNU ld merges them: the feature properties by and, as each feature
+// can only be enabled if all of the code has it, and the x86 ones of
+// the needed ISA and features by or. The Go code has none of the
+// features, as it has no landing pads for indirect branches and the
+// runtime switches stacks without call and return, so the output has
+// only those that -z ibt, -z shstk or -z force-bti assert it has.
+
+import (
+	"cmd/internal/sys"
+	"cmd/link/internal/loadelf"
+	"cmd/link/internal/sym"
+	"debug/elf"
+	"sort"
+	"strings"
+)
+
+const (
+	ntGNUPropertyType0 = 5
+
+	gnuPropertyX86Feature1And   = 0xc0000002
+	gnuPropertyX86Feature1IBT   = 1 << 0
+	gnuPropertyX86Feature1SHSTK = 1 << 1
+	gnuPropertyX86UintOrLo      = 0xc0008000 // needed ISA and features
+	gnuPropertyX86UintOrHi      = 0xc000ffff
+
+	gnuPropertyAArch64Feature1And = 0xc0000000
+	gnuPropertyAArch64Feature1BTI = 1 << 0
+)
+
+var (
+	// elfZIBT, elfZSHSTK and elfZForceBTI are set by -z ibt, -z shstk
+	// and -z force-bti.
+	elfZIBT, elfZSHSTK, elfZForceBTI bool
+
+	// elfZCETReport and elfZBTIReport are the values of
+	// -z cet-report and -z bti-report: none, warning or error.
+	elfZCETReport, elfZBTIReport string
+)
+
+// A hostObjProperties holds the GNU properties of a host object.
+type hostObjProperties struct {
+	pn    string
+	props loadelf.GNUProperties
+}
+
+// hostObjProps are the GNU properties of the ELF host objects, in the
+// order loaded.
+var hostObjProps []hostObjProperties
+
+// addHostObjProperties records props, the GNU properties of the host
+// object pn, which are nil if it has none.
+func addHostObjProperties(pn string, props loadelf.GNUProperties) {
+	hostObjProps = append(hostObjProps, hostObjProperties{pn, props})
+}
+
+// elfZReport handles the value of -z cet-report or -z bti-report.
+func elfZReport(kw, v string) string {
+	switch v {
+	case "none", "warning", "error":
+		return v
+	}
+	Exitf("-z %s: %q is not none, warning or error", kw, v)
+	return ""
+}
+
+// checkElfZProperties checks that the -z keywords of the GNU properties
+// are for the architecture.
+func checkElfZProperties(ctxt *Link) {
+	x86 := ctxt.Arch.Family == sys.AMD64 || ctxt.Arch.Family == sys.I386
+	if (elfZIBT || elfZSHSTK || elfZCETReport != "") && !x86 {
+		Exitf("-z ibt, -z shstk and -z cet-report are only supported on 386 and amd64")
+	}
+	if (elfZForceBTI || elfZBTIReport != "") && !ctxt.IsARM64() {
+		Exitf("-z force-bti and -z bti-report are only supported on arm64")
+	}
+}
+
+// elfFeature1 returns the type of the feature property of the
+// architecture, the features that -z asserts the output has, and those
+// that -z reports the host objects lacking, as warnings if warn is set.
+// The type is 0 if the architecture has no feature property.
+func elfFeature1(ctxt *Link) (typ, forced, report uint32, warn bool) {
+	switch ctxt.Arch.Family {
+	case sys.AMD64, sys.I386:
+		typ = gnuPropertyX86Feature1And
+		if elfZIBT {
+			forced |= gnuPropertyX86Feature1IBT
+		}
+		if elfZSHSTK {
+			forced |= gnuPropertyX86Feature1SHSTK
+		}
+		if elfZCETReport == "warning" || elfZCETReport == "error" {
+			report = (gnuPropertyX86Feature1IBT | gnuPropertyX86Feature1SHSTK) &^ forced
+		}
+		warn = elfZCETReport == "warning"
+	case sys.ARM64:
+		typ = gnuPropertyAArch64Feature1And
+		if elfZForceBTI {
+			forced = gnuPropertyAArch64Feature1BTI
+		}
+		if elfZBTIReport == "warning" || elfZBTIReport == "error" {
+			report = gnuPropertyAArch64Feature1BTI &^ forced
+		}
+		warn = elfZBTIReport == "warning"
+	}
+	return typ, forced, report, warn
+}
+
+// featureNames returns the names of the features f of the feature
+// property of the architecture.
+func featureNames(arch *sys.Arch, f uint32) string {
+	var names []string
+	if arch.Family == sys.ARM64 {
+		if f&gnuPropertyAArch64Feature1BTI != 0 {
+			names = append(names, "BTI")
+		}
+	} else {
+		if f&gnuPropertyX86Feature1IBT != 0 {
+			names = append(names, "IBT")
+		}
+		if f&gnuPropertyX86Feature1SHSTK != 0 {
+			names = append(names, "SHSTK")
+		}
+	}
+	return strings.Join(names, " and ")
+}
+
+// elfGNUProperties reports the host objects that lack the features
+// that -z cet-report or -z bti-report ask about, and returns the GNU
+// properties of the output as type and value pairs, sorted by type.
+func elfGNUProperties(ctxt *Link) [][2]uint32 {
+	typ, forced, report, warn := elfFeature1(ctxt)
+	if typ == 0 {
+		return nil
+	}
+	e := ctxt.Arch.ByteOrder
+	or := make(map[uint32]uint32)
+	for _, h := range hostObjProps {
+		var have uint32
+		if d := h.props[typ]; len(d) == 4 {
+			have = e.Uint32(d)
+		}
+		if missing := report &^ have; missing != 0 {
+			if warn {
+				ctxt.Logf("warning: %s: missing %s property\n", h.pn, featureNames(ctxt.Arch, missing))
+			} else {
+				Errorf(nil, "%s: missing %s property", h.pn, featureNames(ctxt.Arch, missing))
+			}
+		}
+		if typ != gnuPropertyX86Feature1And {
+			continue
+		}
+		for t, d := range h.props {
+			if t >= gnuPropertyX86UintOrLo && t <= gnuPropertyX86UintOrHi && len(d) == 4 {
+				or[t] |= e.Uint32(d)
+			}
+		}
+	}
+
+	var props [][2]uint32
+	if forced != 0 {
+		props = append(props, [2]uint32{typ, forced})
+	}
+	for t, v := range or {
+		if v != 0 {
+			props = append(props, [2]uint32{t, v})
+		}
+	}
+	sort.Slice(props, func(i, j int) bool { return props[i][0] < props[j][0] })
+	return props
+}
+
+// elfAddGNUProperty adds the .note.gnu.property section, if the output
+// has GNU properties, and its name to .shstrtab. It is called by doelf
+// when linking internally.
+func elfAddGNUProperty(ctxt *Link, shstrtabAddstring func(string)) {
+	props := elfGNUProperties(ctxt)
+	if len(props) == 0 {
+		return
+	}
+	// The properties are 8-byte aligned in 64-bit objects and 4-byte
+	// aligned in 32-bit ones, as the note is.
+	align := ctxt.Arch.PtrSize
+	descsz := len(props) * int(Rnd(12, int64(align)))
+
+	ldr := ctxt.loader
+	sb := ldr.CreateSymForUpdate(".note.gnu.property", 0)
+	sb.SetType(sym.SELFROSECT)
+	sb.SetReachable(true)
+	sb.AddUint32(ctxt.Arch, 4)
+	sb.AddUint32(ctxt.Arch, uint32(descsz))
+	sb.AddUint32(ctxt.Arch, ntGNUPropertyType0)
+	sb.AddBytes([]byte("GNU\x00"))
+	for _, p := range props {
+		sb.AddUint32(ctxt.Arch, p[0])
+		sb.AddUint32(ctxt.Arch, 4)
+		sb.AddUint32(ctxt.Arch, p[1])
+		for len(sb.Data())%align != 0 {
+			sb.AddUint8(0)
+		}
+	}
+	sb.SetAlign(int32(align))
+	shstrtabAddstring(".note.gnu.property")
+}
+
+// elfAddGNUPropertyHeaders makes .note.gnu.property an SHT_NOTE section
+// and covers it with the PT_NOTE and PT_GNU_PROPERTY program headers the
+// loader reads it by. It is called by asmbElf once the section headers
+// of the segments are set up.
+func elfAddGNUPropertyHeaders(ctxt *Link) {
+	if ctxt.loader.Lookup(".note.gnu.property", 0) == 0 {
+		return
+	}
+	sh := elfshname(".note.gnu.property")
+	sh.Type = uint32(elf.SHT_NOTE)
+	ph := newElfPhdr()
+	ph.Type = elf.PT_NOTE
+	ph.Flags = elf.PF_R
+	phsh(ph, sh)
+	ph = newElfPhdr()
+	ph.Type = elf.PT_GNU_PROPERTY
+	ph.Flags = elf.PF_R
+	phsh(ph, sh)
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGNUPropertyNote links programs with the -z keywords that assert
+// the hardware control-flow protection features and checks the
+// .note.gnu.property section and the program headers that cover it.
+func TestGNUPropertyNote(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		goarch  string
+		ldflags string
+		typ     uint32
+		want    uint32 // 0 for no note
+		err     string
+	}{
+		{"amd64", "", 0, 0, ""},
+		{"amd64", "-z ibt -z shstk", gnuPropertyX86Feature1And, gnuPropertyX86Feature1IBT | gnuPropertyX86Feature1SHSTK, ""},
+		{"386", "-z shstk", gnuPropertyX86Feature1And, gnuPropertyX86Feature1SHSTK, ""},
+		{"arm64", "-z force-bti", gnuPropertyAArch64Feature1And, gnuPropertyAArch64Feature1BTI, ""},
+		{"arm64", "-z ibt", 0, 0, "only supported on 386 and amd64"},
+		{"amd64", "-z bti-report=warning", 0, 0, "only supported on arm64"},
+		{"amd64", "-z cet-report=loud", 0, 0, "is not none, warning or error"},
+	} {
+		exe := filepath.Join(dir, tt.goarch)
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags="+tt.ldflags, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+tt.goarch, "CGO_ENABLED=0")
+		out, err := cmd.CombinedOutput()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(string(out), tt.err) {
+				t.Errorf("%v: want error %q, got %v\n%s", cmd, tt.err, err, out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+
+		f, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sect := f.Section(".note.gnu.property")
+		var nnote, nprop int
+		for _, p := range f.Progs {
+			if p.Type == elf.PT_GNU_PROPERTY {
+				nprop++
+			}
+			if sect != nil && p.Type == elf.PT_NOTE && p.Off == sect.Offset && p.Filesz == sect.Size {
+				nnote++
+			}
+		}
+		if tt.want == 0 {
+			if sect != nil || nprop != 0 {
+				t.Errorf("%s %q: has a .note.gnu.property section or PT_GNU_PROPERTY", tt.goarch, tt.ldflags)
+			}
+			f.Close()
+			continue
+		}
+		if sect == nil || sect.Type != elf.SHT_NOTE {
+			t.Fatalf("%s %q: no SHT_NOTE .note.gnu.property section", tt.goarch, tt.ldflags)
+		}
+		if nnote != 1 || nprop != 1 {
+			t.Errorf("%s %q: .note.gnu.property is covered by %d PT_NOTE and %d PT_GNU_PROPERTY headers, want 1 of each", tt.goarch, tt.ldflags, nnote, nprop)
+		}
+		data, err := sect.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := f.ByteOrder
+		if len(data) < 28 || e.Uint32(data[8:]) != ntGNUPropertyType0 || string(data[12:16]) != "GNU\x00" {
+			t.Fatalf("%s %q: malformed note % x", tt.goarch, tt.ldflags, data)
+		}
+		if typ, size, v := e.Uint32(data[16:]), e.Uint32(data[20:]), e.Uint32(data[24:]); typ != tt.typ || size != 4 || v != tt.want {
+			t.Errorf("%s %q: property %#x of %d bytes = %#x, want %#x of 4 bytes = %#x", tt.goarch, tt.ldflags, typ, size, v, tt.typ, tt.want)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -packrelativerelocs, which moves the relative
+// relocations of an internally linked ELF PIE out of .rela, where each
+// takes an Elf64_Rela of 24 bytes, into a SHT_RELR .relr.dyn section,
+// as lld and GNU ld -z pack-relative-relocs do. A SHT_RELR section is a
+// list of words: an even word is the address of a pointer to relocate,
+// and an odd word is a bitmap of which of the 63 pointers after the last
+// one relocated are to be relocated too. The pointers hold their
+// link-time values, which the dynamic linker adds the load address to.
+//
+// The relocations are recorded before the data symbols are laid out,
+// and .relr.dyn has to be sized before it has an address itself, so it
+// is sized for an encoding that depends only on the offsets of the
+// pointers in their sections (see elfSizeRelr), and filled in once
+// addresses are assigned.
+
+import (
+	"cmd/internal/sys"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"debug/elf"
+	"sort"
+)
+
+// Constants from the gABI that debug/elf does not have.
+const (
+	SHT_RELR   elf.SectionType = 19
+	DT_RELRSZ  elf.DynTag      = 35
+	DT_RELR    elf.DynTag      = 36
+	DT_RELRENT elf.DynTag      = 37
+)
+
+// elfRelrBits is the number of pointers a SHT_RELR bitmap word covers.
+const elfRelrBits = 63
+
+type elfRelrRecord struct {
+	sym  loader.Sym
+	off  int64
+	sect *sym.Section // the section of sym when elfSizeRelr ran
+}
+
+// elfrelr holds the pointers to relocate through .relr.dyn.
+var elfrelr []elfRelrRecord
+
+// elfRelrSize is the number of words reserved for .relr.dyn.
+var elfRelrSize int
+
+// ElfAddRelr records that the pointer at offset off in s is to be
+// relocated by .relr.dyn instead of an R_*_RELATIVE relocation, and
+// reports whether it did, which it does if -packrelativerelocs is set.
+// The pointer must hold its link-time value in the output.
+func ElfAddRelr(target *Target, s loader.Sym, off int64) bool {
+	if !*flagPackRelativeRelocs || !target.IsElf() || !target.IsPIE() || !target.IsInternal() {
+		return false
+	}
+	elfrelr = append(elfrelr, elfRelrRecord{sym: s, off: off})
+	return true
+}
+
+// elfRelrEncode returns the SHT_RELR words that relocate the pointers at
+// addrs. Each group of addrs must be increasing, and all of them even
+// and after those of the group before. The words for a group start with
+// an address word, and so depend only on the differences between its
+// addrs.
+func elfRelrEncode(groups [][]uint64) []uint64 {
+	var words []uint64
+	for _, addrs := range groups {
+		for i := 0; i < len(addrs); {
+			words = append(words, addrs[i])
+			where := addrs[i] + 8
+			i++
+			for {
+				var bitmap uint64
+				for ; i < len(addrs); i++ {
+					a := addrs[i]
+					if a < where || (a-where)%8 != 0 || (a-where)/8 >= elfRelrBits {
+						break
+					}
+					bitmap |= 1 << ((a - where) / 8)
+				}
+				if bitmap == 0 {
+					break
+				}
+				words = append(words, bitmap<<1|1)
+				where += elfRelrBits * 8
+			}
+		}
+	}
+	return words
+}
+
+// elfRelrGroups returns the addresses of the pointers of elfrelr, in
+// increasing order, grouped by the section their symbol had when
+// elfSizeRelr ran, or by symbol for those not yet in one. The groups
+// are sorted by their first address, as placed by addr.
+func elfRelrGroups(ldr *loader.Loader, addr func(loader.Sym) uint64) [][]uint64 {
+	type key struct {
+		sect *sym.Section
+		sym  loader.Sym
+	}
+	index := make(map[key]int)
+	var groups [][]uint64
+	for _, r := range elfrelr {
+		k := key{sect: r.sect}
+		if r.sect == nil {
+			k.sym = r.sym
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], addr(r.sym)+uint64(r.off))
+	}
+	for _, g := range groups {
+		sort.Slice(g, func(i, j int) bool { return g[i] < g[j] })
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// elfSizeRelr reserves room in .relr.dyn for the pointers recorded by
+// ElfAddRelr. It runs while the data sections are laid out, just before
+// .relr.dyn is: the symbols already in a section have their offsets in
+// it, which address assignment only adds the address of the section
+// to, so the pointers of each section can be encoded as they will be
+// placed. The pointers of later sections are encoded symbol by symbol.
+func elfSizeRelr(ctxt *Link) {
+	ldr := ctxt.loader
+	relr := ldr.Lookup(".relr.dyn", 0)
+	if relr == 0 {
+		return
+	}
+	for i := range elfrelr {
+		elfrelr[i].sect = ldr.SymSect(elfrelr[i].sym)
+	}
+	offset := func(s loader.Sym) uint64 {
+		if ldr.SymSect(s) == nil {
+			return 0
+		}
+		return uint64(ldr.SymValue(s))
+	}
+	elfRelrSize = len(elfRelrEncode(elfRelrGroups(ldr, offset)))
+	sb := ldr.MakeSymbolUpdater(relr)
+	sb.SetAlign(8)
+	sb.Grow(int64(8 * elfRelrSize))
+	sb.SetSize(int64(8 * elfRelrSize))
+}
+
+// elfWriteRelr fills in .relr.dyn now that addresses are assigned.
+func elfWriteRelr(ctxt *Link) {
+	if elfRelrSize == 0 {
+		return
+	}
+	ldr := ctxt.loader
+	addr := func(s loader.Sym) uint64 { return uint64(ldr.SymValue(s)) }
+	groups := elfRelrGroups(ldr, addr)
+	var all []uint64
+	for _, g := range groups {
+		for _, a := range g {
+			if a%2 != 0 {
+				Exitf("-packrelativerelocs: pointer to relocate at odd address %#x", a)
+			}
+		}
+		all = append(all, g...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	words := elfRelrEncode([][]uint64{all})
+	if len(words) > elfRelrSize {
+		// Sharing bitmaps across the groups took more words than
+		// encoding them as elfSizeRelr did, which fits.
+		words = elfRelrEncode(groups)
+		if len(words) > elfRelrSize {
+			Exitf("-packrelativerelocs: %d words of relocations do not fit in the %d reserved", len(words), elfRelrSize)
+		}
+	}
+	// Fill the rest with empty bitmaps, which relocate nothing.
+	for len(words) < elfRelrSize {
+		words = append(words, 1)
+	}
+	s := ldr.MakeSymbolUpdater(ldr.Lookup(".relr.dyn", 0))
+	for i, w := range words {
+		s.SetUint(ctxt.Arch, int64(8*i), w)
+	}
+}
+
+// staticPIESupported reports whether -static-pie links internally for
+// the architecture of the link, which needs a runtime entry point that
+// applies the relocations of the executable.
+func staticPIESupported(arch *sys.Arch) bool {
+	return arch.Family == sys.AMD64 || arch.Family == sys.ARM64
+}
+
+// isStaticPIE reports whether the link writes a PIE that relocates
+// itself, with no interpreter.
+func (ctxt *Link) isStaticPIE() bool {
+	return *flagStaticPIE && ctxt.IsInternal()
+}
+
+// elfRelrSupported reports whether -packrelativerelocs works for the
+// architecture of the link.
+func elfRelrSupported(arch *sys.Arch) bool {
+	return arch.Family == sys.AMD64 || arch.Family == sys.ARM64
+}
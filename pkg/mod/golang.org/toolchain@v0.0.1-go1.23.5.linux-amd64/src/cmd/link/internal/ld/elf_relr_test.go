@@ -0,0 +1,267 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"internal/testenv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testDecodeRelr returns the addresses the SHT_RELR words relocate, as
+// a dynamic linker would.
+func testDecodeRelr(words []uint64) []uint64 {
+	var addrs []uint64
+	var where uint64
+	for _, w := range words {
+		if w&1 == 0 {
+			addrs = append(addrs, w)
+			where = w + 8
+			continue
+		}
+		for i := uint64(0); w>>1>>i != 0; i++ {
+			if w>>1>>i&1 != 0 {
+				addrs = append(addrs, where+8*i)
+			}
+		}
+		where += elfRelrBits * 8
+	}
+	return addrs
+}
+
+func TestElfRelrEncode(t *testing.T) {
+	var sparse, dense []uint64
+	for a := uint64(0x1000); a < 0x1000+200*8; a += 8 {
+		dense = append(dense, a)
+	}
+	sparse = []uint64{0x10, 0x18, 0x28, 0x400, 0x400 + 8*63, 0x400 + 8*64, 0x10000}
+	for _, tc := range []struct {
+		name   string
+		groups [][]uint64
+		nword  int
+	}{
+		{"empty", nil, 0},
+		{"one", [][]uint64{{0x2000}}, 1},
+		{"dense", [][]uint64{dense}, 5},
+		{"sparse", [][]uint64{sparse}, 6},
+		{"groups", [][]uint64{{0x10, 0x18}, {0x20, 0x28}}, 4},
+	} {
+		words := elfRelrEncode(tc.groups)
+		if len(words) != tc.nword {
+			t.Errorf("%s: encoded in %d words, want %d: %#x", tc.name, len(words), tc.nword, words)
+		}
+		var want []uint64
+		for _, g := range tc.groups {
+			want = append(want, g...)
+		}
+		if got := testDecodeRelr(words); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: decodes to %#x, want %#x", tc.name, got, want)
+		}
+	}
+
+	// Shifting a group by a multiple of 8 shifts only its address words.
+	a := elfRelrEncode([][]uint64{sparse})
+	shifted := make([]uint64, len(sparse))
+	for i, s := range sparse {
+		shifted[i] = s + 0x12340
+	}
+	b := elfRelrEncode([][]uint64{shifted})
+	if len(a) != len(b) {
+		t.Errorf("shifted group encodes in %d words, want %d", len(b), len(a))
+	}
+}
+
+// TestElfRelrLink links a PIE internally with -packrelativerelocs and
+// checks that its relative relocations moved to .relr.dyn and that it
+// runs.
+func TestElfRelrLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		t.Skipf("-packrelativerelocs is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	testenv.MustInternalLinkPIE(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	prog := "package main\n\nvar x, y int\n\nvar p = []*int{&x, &y, &x}\n\nvar m = map[string]int{\"a\": 1}\n\nfunc main() { *p[2] = 1; println(x, m[\"a\"]) }\n"
+	if err := os.WriteFile(src, []byte(prog), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.exe")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode=pie", "-ldflags=-linkmode=internal -packrelativerelocs", "-o", exe, src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	relr := f.Section(".relr.dyn")
+	if relr == nil || relr.Type != SHT_RELR {
+		t.Fatalf("no SHT_RELR .relr.dyn section in %v", f.Sections)
+	}
+	data, err := relr.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := make([]uint64, len(data)/8)
+	for i := range words {
+		words[i] = f.ByteOrder.Uint64(data[8*i:])
+	}
+	addrs := testDecodeRelr(words)
+	if len(addrs) == 0 {
+		t.Error(".relr.dyn relocates nothing")
+	}
+	for _, a := range addrs {
+		if s := testSectionAt(f, a); s == nil || s.Flags&elf.SHF_WRITE == 0 && s.Name != ".data.rel.ro" {
+			t.Errorf("pointer to relocate at %#x is not in a writable or relro section", a)
+		}
+	}
+	if rela := f.Section(".rela"); rela != nil {
+		data, err := rela.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		relative := uint32(elf.R_X86_64_RELATIVE)
+		if f.Machine == elf.EM_AARCH64 {
+			relative = uint32(elf.R_AARCH64_RELATIVE)
+		}
+		for i := 0; i+24 <= len(data); i += 24 {
+			if typ := uint32(binary.LittleEndian.Uint64(data[i+8:])); typ == relative {
+				t.Errorf(".rela has a relative relocation at %#x", binary.LittleEndian.Uint64(data[i:]))
+			}
+		}
+	}
+
+	out, err := testenv.Command(t, exe).CombinedOutput()
+	if bytes.Contains(out, []byte("GLIBC_ABI_DT_RELR")) {
+		t.Skipf("dynamic linker does not support DT_RELR:\n%s", out)
+	}
+	if err != nil || strings.TrimSpace(string(out)) != "1 1" {
+		t.Errorf("%s: %v\n%s, want 1 1", exe, err, out)
+	}
+}
+
+// testSectionAt returns the allocated section of f that holds addr.
+func testSectionAt(f *elf.File, addr uint64) *elf.Section {
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC != 0 && s.Addr <= addr && addr < s.Addr+s.Size {
+			return s
+		}
+	}
+	return nil
+}
+
+// TestStaticPIE links a PIE internally with -static-pie, with and
+// without -packrelativerelocs, and checks that it has no interpreter,
+// that it enters at the code that relocates it and that it runs.
+func TestStaticPIE(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		t.Skipf("-static-pie does not link internally on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	testenv.MustInternalLinkPIE(t)
+	t.Parallel()
+
+	testStaticPIE(t, runtime.GOARCH, func(exe string) *exec.Cmd { return testenv.Command(t, exe) })
+}
+
+// TestStaticPIEArm64 is TestStaticPIE for linux/arm64 on another linux
+// host, running the programs under qemu-aarch64.
+func TestStaticPIEArm64(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	if runtime.GOOS != "linux" || runtime.GOARCH == "arm64" {
+		t.Skip("TestStaticPIE runs linux/arm64 programs on linux/arm64")
+	}
+	qemu, err := exec.LookPath("qemu-aarch64")
+	if err != nil {
+		if qemu, err = exec.LookPath("qemu-aarch64-static"); err != nil {
+			t.Skip("skipping: no qemu-aarch64 to run linux/arm64 programs")
+		}
+	}
+	t.Parallel()
+
+	testStaticPIE(t, "arm64", func(exe string) *exec.Cmd { return testenv.Command(t, qemu, exe) })
+}
+
+// testStaticPIE builds the programs of TestStaticPIE for linux/goarch
+// and runs them with run.
+func testStaticPIE(t *testing.T, goarch string, run func(exe string) *exec.Cmd) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	prog := "package main\n\nimport \"fmt\"\n\nvar x, y int\n\nvar p = []*int{&x, &y, &x}\n\nvar m = map[string]int{\"a\": 1}\n\nfunc main() { *p[2] = 1; fmt.Println(x, m[\"a\"]) }\n"
+	if err := os.WriteFile(src, []byte(prog), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for i, ldflags := range []string{"-static-pie", "-static-pie -packrelativerelocs"} {
+		exe := filepath.Join(dir, fmt.Sprintf("a%d.exe", i))
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode=pie", "-ldflags=-linkmode=internal "+ldflags, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH="+goarch)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+
+		f, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Type != elf.ET_DYN {
+			t.Errorf("%s: type is %v, want ET_DYN", ldflags, f.Type)
+		}
+		var dynamic bool
+		for _, p := range f.Progs {
+			switch p.Type {
+			case elf.PT_INTERP:
+				t.Errorf("%s: has a PT_INTERP program header", ldflags)
+			case elf.PT_DYNAMIC:
+				dynamic = true
+			}
+		}
+		if !dynamic {
+			t.Errorf("%s: has no PT_DYNAMIC program header", ldflags)
+		}
+		syms, err := f.Symbols()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := "_rt0_" + goarch + "_linux_static_pie"
+		addr := uint64(0)
+		for _, s := range syms {
+			if s.Name == entry {
+				addr = s.Value
+			}
+		}
+		if addr == 0 || addr != f.Entry {
+			t.Errorf("%s: entry is %#x, want %s at %#x", ldflags, f.Entry, entry, addr)
+		}
+		f.Close()
+
+		out, err := run(exe).CombinedOutput()
+		if err != nil || strings.TrimSpace(string(out)) != "1 1" {
+			t.Errorf("%s: %v\n%s, want 1 1", exe, err, out)
+		}
+	}
+}
@@ -254,6 +254,29 @@ func TestElfBindNow(t *testing.T) {
 			wantSecsRO:           []string{".dynamic"},
 			wantSecsROIfPresent:  []string{".got", ".got.plt"},
 		},
+		{
+			name:                "z-relro-now-linkmode-internal",
+			args:                []string{"-ldflags", "-z relro -z now -linkmode=internal"},
+			prog:                progC,
+			mustHaveCGO:         true,
+			mustInternalLink:    true,
+			wantDfBindNow:       true,
+			wantDf1Now:          true,
+			wantSecsRO:          []string{".dynamic"},
+			wantSecsROIfPresent: []string{".got", ".got.plt"},
+		},
+		{
+			name:                 "z-now-pie-linkmode-internal",
+			args:                 []string{"-buildmode=pie", "-ldflags", "-z now -linkmode=internal"},
+			prog:                 prog,
+			mustHaveBuildModePIE: true,
+			mustInternalLink:     true,
+			wantDfBindNow:        true,
+			wantDf1Now:           true,
+			wantDf1Pie:           true,
+			wantSecsRO:           []string{".dynamic"},
+			wantSecsROIfPresent:  []string{".got", ".got.plt"},
+		},
 		{
 			name:                 "bindnow-pie-linkmode-external",
 			args:                 []string{"-buildmode=pie", "-ldflags", "-bindnow -linkmode=external"},
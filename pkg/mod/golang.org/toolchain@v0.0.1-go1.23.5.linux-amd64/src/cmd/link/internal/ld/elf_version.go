@@ -0,0 +1,252 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// An elfVersion is a node of a -version-script: a version with the
+// symbols defined in it, or, when the node has no name, just the
+// symbols to export and to hide.
+type elfVersion struct {
+	name    string
+	parents []string // the versions the version succeeds
+	global  []string // the patterns of the symbols to export
+	local   []string // the patterns of the symbols to hide
+}
+
+// elfVersions are the versions of -version-script. The named ones have
+// the indexes 2 on of the .gnu.version_d section, after the base
+// version at 1.
+var elfVersions []*elfVersion
+
+// elfverdef is the number of entries of .gnu.version_d, or 0.
+var elfverdef int
+
+// VER_FLG_BASE marks the entry of .gnu.version_d for the object itself.
+const VER_FLG_BASE = 0x1
+
+// elfNamedVersions returns the number of named versions of
+// -version-script, which is 0 for a script of a single anonymous node.
+func elfNamedVersions() int {
+	if len(elfVersions) == 1 && elfVersions[0].name == "" {
+		return 0
+	}
+	return len(elfVersions)
+}
+
+// parseElfVersionScript parses a version script of the form the GNU
+// linkers take, such as
+//
+//	LIBFOO_1.0 {
+//		global: foo_open; foo_close;
+//		local: *;
+//	};
+//	LIBFOO_1.1 { global: foo_reset; } LIBFOO_1.0;
+//
+// with # and /* */ comments and extern "C" blocks. It does not take the
+// C++ symbols of extern "C++" blocks, which Go cannot export.
+func parseElfVersionScript(script string) ([]*elfVersion, error) {
+	toks, err := elfVersionTokens(script)
+	if err != nil {
+		return nil, err
+	}
+	next := func() string {
+		if len(toks) == 0 {
+			return ""
+		}
+		t := toks[0]
+		toks = toks[1:]
+		return t
+	}
+	expect := func(want string) error {
+		if t := next(); t != want {
+			if t == "" {
+				t = "end of script"
+			}
+			return fmt.Errorf("unexpected %s, want %s", t, want)
+		}
+		return nil
+	}
+
+	var versions []*elfVersion
+	names := make(map[string]bool)
+	for len(toks) > 0 {
+		v := new(elfVersion)
+		if toks[0] != "{" {
+			v.name = next()
+			if names[v.name] {
+				return nil, fmt.Errorf("version %s defined twice", v.name)
+			}
+			names[v.name] = true
+		}
+		if err := expect("{"); err != nil {
+			return nil, err
+		}
+		patterns := &v.global
+		for len(toks) > 0 && toks[0] != "}" {
+			switch t := next(); {
+			case (t == "global" || t == "local") && len(toks) > 0 && toks[0] == ":":
+				next()
+				patterns = &v.global
+				if t == "local" {
+					patterns = &v.local
+				}
+			case t == "extern":
+				if lang := next(); lang != `"C"` {
+					return nil, fmt.Errorf("extern %s is not supported", lang)
+				}
+				if err := expect("{"); err != nil {
+					return nil, err
+				}
+				for len(toks) > 0 && toks[0] != "}" {
+					if t := next(); t != ";" {
+						*patterns = append(*patterns, strings.Trim(t, `"`))
+					}
+				}
+				if err := expect("}"); err != nil {
+					return nil, err
+				}
+				if len(toks) > 0 && toks[0] == ";" {
+					next()
+				}
+			case t == "{" || t == ":" || t == ";":
+				return nil, fmt.Errorf("unexpected %s", t)
+			default:
+				*patterns = append(*patterns, strings.Trim(t, `"`))
+				if err := expect(";"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := expect("}"); err != nil {
+			return nil, err
+		}
+		for len(toks) > 0 && toks[0] != ";" {
+			p := next()
+			if !names[p] || p == v.name {
+				return nil, fmt.Errorf("version %s succeeds undefined version %s", v.name, p)
+			}
+			v.parents = append(v.parents, p)
+		}
+		if err := expect(";"); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no versions")
+	}
+	for _, v := range versions {
+		if v.name == "" && len(versions) > 1 {
+			return nil, fmt.Errorf("an anonymous version must be the only version")
+		}
+	}
+	return versions, nil
+}
+
+// elfVersionTokens splits a version script into the tokens {, }, :, ;
+// and the names, patterns and strings between them.
+func elfVersionTokens(script string) ([]string, error) {
+	var toks []string
+	for s := script; s != ""; {
+		switch c := s[0]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			s = s[1:]
+		case c == '#':
+			if i := strings.IndexByte(s, '\n'); i >= 0 {
+				s = s[i:]
+			} else {
+				s = ""
+			}
+		case strings.HasPrefix(s, "/*"):
+			i := strings.Index(s, "*/")
+			if i < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			s = s[i+2:]
+		case c == '{' || c == '}' || c == ':' || c == ';':
+			toks = append(toks, s[:1])
+			s = s[1:]
+		case c == '"':
+			i := strings.IndexByte(s[1:], '"')
+			if i < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, s[:i+2])
+			s = s[i+2:]
+		default:
+			i := strings.IndexAny(s, " \t\n\r#{}:;\"")
+			if i < 0 {
+				i = len(s)
+			}
+			toks = append(toks, s[:i])
+			s = s[i:]
+		}
+	}
+	return toks, nil
+}
+
+// elfSymVersion returns the index in .gnu.version_d of the version of
+// the -version-script that the exported symbol name is in, or 1 for
+// the base version, the index of the symbols of no named version, and
+// reports whether the symbol is exported at all.
+//
+// As with the GNU linkers, a pattern that is the name itself comes
+// before one with wildcards, which comes before *. Among patterns of
+// the same kind the first in the script wins, and in a version a
+// global pattern comes before a local one.
+func elfSymVersion(name string) (ndx uint16, export bool) {
+	if elfVersions == nil {
+		return 1, true
+	}
+	for _, kind := range []int{elfPatternName, elfPatternGlob, elfPatternAll} {
+		for i, v := range elfVersions {
+			ndx := uint16(1)
+			if v.name != "" {
+				ndx = uint16(i + 2)
+			}
+			if elfVersionMatch(v.global, kind, name) {
+				return ndx, true
+			}
+			if elfVersionMatch(v.local, kind, name) {
+				return 0, false
+			}
+		}
+	}
+	return 1, true
+}
+
+// The kinds of version script patterns, in the order they match.
+const (
+	elfPatternName = iota
+	elfPatternGlob
+	elfPatternAll
+)
+
+// elfVersionMatch reports whether a pattern of kind in patterns
+// matches name.
+func elfVersionMatch(patterns []string, kind int, name string) bool {
+	for _, p := range patterns {
+		switch {
+		case p == "*":
+			if kind == elfPatternAll {
+				return true
+			}
+		case strings.ContainsAny(p, "*?["):
+			if ok, _ := path.Match(p, name); ok && kind == elfPatternGlob {
+				return true
+			}
+		default:
+			if p == name && kind == elfPatternName {
+				return true
+			}
+		}
+	}
+	return false
+}
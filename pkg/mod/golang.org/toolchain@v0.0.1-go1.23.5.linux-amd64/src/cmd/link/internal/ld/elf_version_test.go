@@ -0,0 +1,203 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const versionScript = `# The versions of libfoo.
+LIBFOO_1.0 {
+	global: FooOpen; /* the first */
+	local: foo*;
+};
+LIBFOO_1.1 {
+	extern "C" { "FooReset"; };
+} LIBFOO_1.0;
+`
+
+func TestParseElfVersionScript(t *testing.T) {
+	versions, err := parseElfVersionScript(versionScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*elfVersion{
+		{name: "LIBFOO_1.0", global: []string{"FooOpen"}, local: []string{"foo*"}},
+		{name: "LIBFOO_1.1", parents: []string{"LIBFOO_1.0"}, global: []string{"FooReset"}},
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("got %+v, want %+v", versions, want)
+	}
+
+	for _, script := range []string{
+		"",
+		"{ global: a; local: *; }",
+		"V1 { a; }; V1 { b; };",
+		"V2 { a; } V1;",
+		"{ a; }; V1 { b; };",
+		`V1 { extern "C++" { "ns::f"; }; };`,
+		"V1 { a }; ",
+		"V1 { a; /* };",
+	} {
+		if _, err := parseElfVersionScript(script); err == nil {
+			t.Errorf("%q: no error", script)
+		}
+	}
+}
+
+func TestElfSymVersion(t *testing.T) {
+	defer func(v []*elfVersion) { elfVersions = v }(elfVersions)
+	var err error
+	elfVersions, err = parseElfVersionScript(`V1 { global: f*; FooX; local: *; }; V2 { global: foo; local: fo?; } V1;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		ndx    uint16
+		export bool
+	}{
+		{"foo", 3, true}, // the name comes before the wildcards of V1
+		{"fox", 2, true}, // V1 comes before V2
+		{"FooX", 2, true},
+		{"Foo", 0, false},
+	} {
+		if ndx, export := elfSymVersion(tc.name); ndx != tc.ndx || export != tc.export {
+			t.Errorf("%s: got %d, %v, want %d, %v", tc.name, ndx, export, tc.ndx, tc.export)
+		}
+	}
+}
+
+const versionScriptSrc = `package main
+
+import "C"
+
+//export FooOpen
+func FooOpen() C.int { return 1 }
+
+//export FooReset
+func FooReset() C.int { return 2 }
+
+//export fooHidden
+func fooHidden() C.int { return 3 }
+
+func main() { println("ok") }
+`
+
+// TestVersionScript links a cgo program with exports internally with
+// -soname and -version-script and checks its dynamic symbols and their
+// versions.
+func TestVersionScript(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	testenv.MustInternalLink(t, true)
+	if runtime.GOOS != "linux" {
+		t.Skip("skipping: the versions of the dynamic symbols are checked for linux")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(versionScriptSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "libfoo.map")
+	if err := os.WriteFile(script, []byte(versionScript), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.out")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -soname=libfoo.so.1 -version-script="+script, "-o", exe, src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	if out, err := testenv.Command(t, exe).CombinedOutput(); err != nil || string(out) != "ok\n" {
+		t.Errorf("%s: %v\n%s", exe, err, out)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if soname, err := f.DynString(elf.DT_SONAME); err != nil || !reflect.DeepEqual(soname, []string{"libfoo.so.1"}) {
+		t.Errorf("DT_SONAME %q, %v", soname, err)
+	}
+	sect := func(name string) []byte {
+		s := f.Section(name)
+		if s == nil {
+			t.Fatalf("no %s section", name)
+		}
+		b, err := s.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	dynstr := sect(".dynstr")
+	str := func(off uint32) string {
+		s := dynstr[off:]
+		return string(s[:bytes.IndexByte(s, 0)])
+	}
+
+	// The version definitions, by index.
+	le := binary.LittleEndian
+	defs := make(map[uint16][]string)
+	for d := sect(".gnu.version_d"); ; {
+		ndx, cnt := le.Uint16(d[4:]), int(le.Uint16(d[6:]))
+		for a, i := d[le.Uint32(d[12:]):], 0; i < cnt; a, i = a[le.Uint32(a[4:]):], i+1 {
+			defs[ndx] = append(defs[ndx], str(le.Uint32(a)))
+		}
+		next := le.Uint32(d[16:])
+		if next == 0 {
+			break
+		}
+		d = d[next:]
+	}
+	wantDefs := map[uint16][]string{1: {"libfoo.so.1"}, 2: {"LIBFOO_1.0"}, 3: {"LIBFOO_1.1", "LIBFOO_1.0"}}
+	if !reflect.DeepEqual(defs, wantDefs) {
+		t.Errorf("version definitions %q, want %q", defs, wantDefs)
+	}
+
+	syms, err := f.DynamicSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	versym := sect(".gnu.version")
+	got := make(map[string]string)
+	for i, s := range syms {
+		if s.Section == elf.SHN_UNDEF {
+			continue
+		}
+		// DynamicSymbols leaves out the null symbol, entry 0.
+		got[s.Name] = defs[le.Uint16(versym[2*(i+1):])][0]
+	}
+	want := map[string]string{"FooOpen": "LIBFOO_1.0", "FooReset": "LIBFOO_1.1"}
+	for name, v := range got {
+		// The exports of runtime/cgo, which no pattern matches, are
+		// in the base version.
+		if want[name] == "" {
+			want[name] = "libfoo.so.1"
+		}
+		if name == "fooHidden" {
+			want[name] = "(hidden)"
+		}
+		if v != want[name] {
+			t.Errorf("dynamic symbol %s has version %s, want %s", name, v, want[name])
+		}
+	}
+	for name := range want {
+		if got[name] == "" {
+			t.Errorf("no dynamic symbol %s", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"strconv"
+	"strings"
+)
+
+var (
+	// elfZRelro is 1 after -z relro and -1 after -z norelro, whichever
+	// comes last, and 0 if neither is given.
+	elfZRelro int
+
+	// elfZSeparateCode is 1 after -z separate-code and -1 after
+	// -z noseparate-code, whichever comes last, and 0 if neither is
+	// given.
+	elfZSeparateCode int
+
+	// elfZMaxPageSize is the value of -z max-page-size, or 0.
+	elfZMaxPageSize int64
+
+	// elfZKeywords are the -z keywords given.
+	elfZKeywords []string
+)
+
+// elfZ handles -z keyword, which takes the GNU ld keywords that control
+// the hardening and layout of an ELF output: relro, which asks for the
+// PT_GNU_RELRO segment that every build mode but exe has, and norelro,
+// which only an exe can do without; now and lazy, which are -bindnow
+// and its absence; max-page-size=size, which is -R; and separate-code
+// and noseparate-code, which put the headers in a segment of their own
+// so that the executable segment holds only code, or not; and ibt, shstk
+// and force-bti, which assert that the output is compatible with the
+// hardware control-flow protection features, and cet-report=report and
+// bti-report=report, which report the host objects that are not (see
+// elf_property.go).
+func elfZ(kw string) {
+	switch kw {
+	case "relro":
+		elfZRelro = 1
+	case "norelro":
+		elfZRelro = -1
+	case "now":
+		*flagBindNow = true
+	case "lazy":
+		*flagBindNow = false
+	case "separate-code":
+		elfZSeparateCode = 1
+	case "noseparate-code":
+		elfZSeparateCode = -1
+	case "ibt":
+		elfZIBT = true
+	case "shstk":
+		elfZSHSTK = true
+	case "force-bti":
+		elfZForceBTI = true
+	default:
+		if v, ok := strings.CutPrefix(kw, "cet-report="); ok {
+			elfZCETReport = elfZReport("cet-report", v)
+			break
+		}
+		if v, ok := strings.CutPrefix(kw, "bti-report="); ok {
+			elfZBTIReport = elfZReport("bti-report", v)
+			break
+		}
+		v, ok := strings.CutPrefix(kw, "max-page-size=")
+		if !ok {
+			Exitf("-z: unknown keyword %q; use relro, norelro, now, lazy, max-page-size=size, separate-code, noseparate-code, ibt, shstk, cet-report=report, force-bti or bti-report=report", kw)
+		}
+		n, err := strconv.ParseInt(v, 0, 64)
+		if err != nil || n < 4096 || n&(n-1) != 0 {
+			Exitf("-z max-page-size: %q is not a power of two of at least 4096", v)
+		}
+		elfZMaxPageSize = n
+	}
+	elfZKeywords = append(elfZKeywords, kw)
+}
+
+// elfZSetPageSize makes -z max-page-size the segment alignment, before
+// archinit picks the default one for the architecture.
+func elfZSetPageSize() {
+	if elfZMaxPageSize == 0 {
+		return
+	}
+	if *FlagRound != -1 && *FlagRound != elfZMaxPageSize {
+		Exitf("-z max-page-size=%#x conflicts with -R 0x%x", elfZMaxPageSize, *FlagRound)
+	}
+	*FlagRound = elfZMaxPageSize
+}
+
+// elfZLayout rounds the area reserved for the headers up to a page for
+// -z separate-code, so that the text segment starts a page of its own,
+// as asmbElf gives the headers a segment of their own. It is called once
+// the link mode is known, as only internal linking writes the headers.
+func elfZLayout(ctxt *Link) {
+	if elfZSeparateCode <= 0 || !ctxt.IsInternal() {
+		return
+	}
+	pad := Rnd(int64(HEADR), *FlagRound) - int64(HEADR)
+	HEADR += int32(pad)
+	*FlagTextAddr += pad
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestElfZLayout links for linux/amd64 and linux/arm64 with
+// -z separate-code -z max-page-size and checks the segments of the
+// output: aligned to the page size, with the headers in a read-only
+// segment and only code in pages of the executable one.
+func TestElfZLayout(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64 and linux/arm64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, goarch := range []string{"amd64", "arm64"} {
+		const page = 0x4000
+		exe := filepath.Join(dir, goarch)
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-z separate-code -z max-page-size=0x4000", "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+goarch, "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		f, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var loads []*elf.Prog
+		var phdr *elf.Prog
+		for _, p := range f.Progs {
+			switch p.Type {
+			case elf.PT_LOAD:
+				loads = append(loads, p)
+			case elf.PT_PHDR:
+				phdr = p
+			}
+		}
+		if len(loads) == 0 || loads[0].Off != 0 || loads[0].Flags != elf.PF_R || phdr == nil || phdr.Vaddr < loads[0].Vaddr || phdr.Vaddr+phdr.Memsz > loads[0].Vaddr+loads[0].Memsz {
+			t.Fatalf("%s: the headers are not in a read-only segment of their own: %v", goarch, loads)
+		}
+		// pages returns the pages that [off, off+n) spans.
+		pages := func(off, n uint64) (first, last uint64) {
+			return off / page, (off + n - 1) / page
+		}
+		for _, p := range loads {
+			if p.Align != page || p.Vaddr%page != p.Off%page {
+				t.Errorf("%s: segment at %#x (offset %#x) has alignment %#x, want %#x", goarch, p.Vaddr, p.Off, p.Align, page)
+			}
+			if p.Flags&elf.PF_X == 0 {
+				continue
+			}
+			for _, s := range f.Sections {
+				if s.Flags&elf.SHF_ALLOC != 0 && s.Addr >= p.Vaddr && s.Addr < p.Vaddr+p.Memsz && s.Flags&elf.SHF_EXECINSTR == 0 {
+					t.Errorf("%s: section %s is in the executable segment", goarch, s.Name)
+				}
+			}
+			xfirst, xlast := pages(p.Vaddr, p.Memsz)
+			xfirstOff, xlastOff := pages(p.Off, p.Filesz)
+			for _, q := range loads {
+				if q == p {
+					continue
+				}
+				if first, last := pages(q.Vaddr, q.Memsz); first <= xlast && xfirst <= last {
+					t.Errorf("%s: segment at %#x shares a page with the executable segment at %#x", goarch, q.Vaddr, p.Vaddr)
+				}
+				if first, last := pages(q.Off, q.Filesz); q.Filesz > 0 && first <= xlastOff && xfirstOff <= last {
+					t.Errorf("%s: segment at offset %#x shares a file page with the executable segment at offset %#x", goarch, q.Off, p.Off)
+				}
+			}
+		}
+
+		if runtime.GOOS == "linux" && runtime.GOARCH == goarch {
+			out, err := testenv.Command(t, exe).CombinedOutput()
+			if err != nil || string(out) != "hello\n" {
+				t.Errorf("%s: %v: %q", exe, err, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"debug/elf"
+)
+
+// -emitrelocs keeps the relocations of an internally linked ELF
+// executable in the output, as GNU ld's --emit-relocs does, for
+// post-link optimizers and binary rewriters. Each relocation that the
+// linker applied to a Go section is written to the SHT_RELA section
+// .rela<section>, with its offset a virtual address and its target
+// rewritten to the STT_SECTION symbol of the section it refers to.
+// Relocations with nothing in the output for a tool to adjust, such as
+// those to dynamic imports, TLS and section-relative offsets, are
+// dropped.
+
+// emitRelocsSectSyms maps the sections whose relocations -emitrelocs
+// keeps to their STT_SECTION symbols.
+var emitRelocsSectSyms map[*sym.Section]loader.Sym
+
+// emitRelocsSupported reports whether -emitrelocs supports arch.
+func emitRelocsSupported(arch *sys.Arch) bool {
+	return arch.Family == sys.AMD64 || arch.Family == sys.ARM64
+}
+
+// emitRelocsSections returns the sections whose relocations -emitrelocs
+// keeps: those of the Go data that an external link relocates, for which
+// doelf reserves a relocation section name.
+func emitRelocsSections() []*sym.Section {
+	var sects []*sym.Section
+	for _, seg := range []*sym.Segment{&Segtext, &Segrodata, &Segrelrodata, &Segdata} {
+		for _, sect := range seg.Sections {
+			if sect.Vaddr >= sect.Seg.Vaddr+sect.Seg.Filelen {
+				continue // SHT_NOBITS
+			}
+			for i := 0; i < nelfstr; i++ {
+				if elfstr[i].s == elfRelType+sect.Name {
+					sects = append(sects, sect)
+					break
+				}
+			}
+		}
+	}
+	return sects
+}
+
+// emitRelocsSectionSyms writes the STT_SECTION symbols that the kept
+// relocations refer to. Unlike those of a relocatable object, their
+// values are the section addresses.
+func emitRelocsSectionSyms(ctxt *Link) {
+	if !*flagEmitRelocs {
+		return
+	}
+	ldr := ctxt.loader
+	emitRelocsSectSyms = make(map[*sym.Section]loader.Sym)
+	for _, sect := range emitRelocsSections() {
+		s := ldr.CreateStaticSym(sect.Name)
+		putelfsyment(ctxt.Out, 0, int64(sect.Vaddr), 0, elf.ST_INFO(elf.STB_LOCAL, elf.STT_SECTION), sect.Elfsect.(*ElfShdr).shnum, 0)
+		ldr.SetSymElfSym(s, int32(ctxt.numelfsym))
+		ctxt.numelfsym++
+		emitRelocsSectSyms[sect] = s
+	}
+}
+
+// emitreloc converts the relocation r, which the linker applied, to one
+// relative to the STT_SECTION symbol of its target's section. It reports
+// false for relocations that the output cannot represent.
+func emitreloc(ctxt *Link, ldr *loader.Loader, r loader.Reloc) (loader.ExtReloc, bool) {
+	var rr loader.ExtReloc
+	rs := r.Sym()
+	if r.Siz() == 0 || rs == 0 {
+		return rr, false
+	}
+	rt := r.Type()
+	switch rt {
+	default:
+		return rr, false
+	case objabi.R_ADDR:
+	case objabi.R_CALL, objabi.R_PCREL:
+		if !ctxt.IsAMD64() {
+			return rr, false
+		}
+	case objabi.R_ADDRARM64, objabi.R_CALLARM64,
+		objabi.R_ARM64_PCREL_LDST8, objabi.R_ARM64_PCREL_LDST16,
+		objabi.R_ARM64_PCREL_LDST32, objabi.R_ARM64_PCREL_LDST64:
+		if !ctxt.IsARM64() {
+			return rr, false
+		}
+	}
+	if r.Weak() && !ldr.AttrReachable(rs) {
+		rs = ctxt.ArchSyms.unreachableMethod
+	}
+	switch ldr.SymType(rs) {
+	case sym.SDYNIMPORT, sym.SUNDEFEXT:
+		return rr, false
+	}
+	xsym, ok := emitRelocsSectSyms[ldr.SymSect(rs)]
+	if !ok {
+		return rr, false
+	}
+	rr.Type = rt
+	rr.Size = r.Siz()
+	rr.Xsym = xsym
+	rr.Xadd = r.Add() + ldr.SymValue(rs) - int64(ldr.SymSect(rs).Vaddr)
+	if rt == objabi.R_CALL || rt == objabi.R_PCREL {
+		rr.Xadd -= int64(r.Siz()) // relative to address after the relocated chunk
+	}
+	return rr, true
+}
+
+// emitRelocsSect writes the kept relocations of sect, which holds syms,
+// to the output, setting the offset and size of its relocation section.
+func emitRelocsSect(ctxt *Link, sect *sym.Section, syms []loader.Sym) {
+	ldr := ctxt.loader
+	out := NewOutBuf(ctxt.Arch)
+	eaddr := int64(sect.Vaddr + sect.Length)
+	for _, s := range syms {
+		if ldr.SymValue(s) >= eaddr {
+			break
+		}
+		if !ldr.AttrReachable(s) || ldr.SymSect(s) != sect {
+			continue
+		}
+		relocs := ldr.Relocs(s)
+		for ri := 0; ri < relocs.Count(); ri++ {
+			r := relocs.At(ri)
+			rr, ok := emitreloc(ctxt, ldr, r)
+			if !ok {
+				continue
+			}
+			if !thearch.ELF.Reloc1(ctxt, out, ldr, s, rr, ri, ldr.SymValue(s)+int64(r.Off())) {
+				ldr.Errorf(s, "unsupported emitted reloc %d (%s)/%d to %s", r.Type(), sym.RelocName(ctxt.Arch, r.Type()), r.Siz(), ldr.SymName(r.Sym()))
+			}
+		}
+	}
+	sect.Reloff = uint64(ctxt.Out.Offset())
+	sect.Rellen = uint64(out.Offset())
+	ctxt.Out.Write(out.Data())
+}
+
+// elfEmitRelocs writes the relocations that -emitrelocs keeps.
+func elfEmitRelocs(ctxt *Link) {
+	for ctxt.Out.Offset()&7 != 0 {
+		ctxt.Out.Write8(0)
+	}
+	for _, sect := range emitRelocsSections() {
+		if isTextSect(sect) {
+			emitRelocsSect(ctxt, sect, ctxt.Textp)
+		} else {
+			emitRelocsSect(ctxt, sect, ctxt.datap)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEmitRelocs links for linux/amd64 with -emitrelocs and checks that
+// the kept relocations resolve to the values the linker applied.
+func TestEmitRelocs(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nvar p = &x\nvar x = 42\n\nfunc main() { println(*p) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.out")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -emitrelocs", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".rela.text", ".rela.data"} {
+		rs := f.Section(name)
+		if rs == nil {
+			t.Errorf("no %s section", name)
+			continue
+		}
+		if rs.Type != elf.SHT_RELA || int(rs.Info) >= len(f.Sections) || f.Sections[rs.Info].Name != name[len(".rela"):] {
+			t.Errorf("%s has type %v and relocates section %d", name, rs.Type, rs.Info)
+			continue
+		}
+		target := f.Sections[rs.Info]
+		data, err := target.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := rs.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) == 0 || len(b)%24 != 0 {
+			t.Fatalf("%s is %d bytes", name, len(b))
+		}
+		for ; len(b) > 0; b = b[24:] {
+			off := f.ByteOrder.Uint64(b)
+			info := f.ByteOrder.Uint64(b[8:])
+			add := int64(f.ByteOrder.Uint64(b[16:]))
+			// Symbols omits the null symbol.
+			s := syms[elf.R_SYM64(info)-1]
+			if elf.ST_TYPE(s.Info) != elf.STT_SECTION {
+				t.Fatalf("%s: relocation at %#x to %s, not a section symbol", name, off, s.Name)
+			}
+			v := int64(s.Value) + add
+			at := data[off-target.Addr:]
+			var got int64
+			switch typ := elf.R_X86_64(elf.R_TYPE64(info)); typ {
+			case elf.R_X86_64_PC32:
+				v -= int64(off)
+				got = int64(int32(f.ByteOrder.Uint32(at)))
+			case elf.R_X86_64_32:
+				got = int64(f.ByteOrder.Uint32(at))
+			case elf.R_X86_64_64:
+				got = int64(f.ByteOrder.Uint64(at))
+			default:
+				t.Fatalf("%s: relocation at %#x of type %v", name, off, typ)
+			}
+			if got != v {
+				t.Fatalf("%s: relocation at %#x resolves to %#x, but the linker applied %#x", name, off, v, got)
+			}
+		}
+	}
+}
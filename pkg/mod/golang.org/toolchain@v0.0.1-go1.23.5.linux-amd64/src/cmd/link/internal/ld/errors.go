@@ -6,8 +6,12 @@ package ld
 
 import (
 	"cmd/internal/obj"
+	"cmd/internal/objabi"
 	"cmd/link/internal/loader"
 	"cmd/link/internal/sym"
+	"fmt"
+	"os"
+	"sort"
 	"sync"
 )
 
@@ -16,21 +20,40 @@ type unresolvedSymKey struct {
 	to   loader.Sym // Unresolved symbol referenced by "from"
 }
 
+// An unresolvedRef is a relocation of from, at off, to the unresolved
+// symbol to, recorded for -undefs.
+type unresolvedRef struct {
+	unresolvedSymKey
+	off int32
+	typ objabi.RelocType
+}
+
 type symNameFn func(s loader.Sym) string
 
 // ErrorReporter is used to make error reporting thread safe.
 type ErrorReporter struct {
 	loader.ErrorReporter
 	unresSyms  map[unresolvedSymKey]bool
+	unresRefs  map[unresolvedRef]bool // with -undefs
 	unresMutex sync.Mutex
 	SymName    symNameFn
 }
 
-// errorUnresolved prints unresolved symbol error for rs that is referenced from s.
-func (reporter *ErrorReporter) errorUnresolved(ldr *loader.Loader, s, rs loader.Sym) {
+// errorUnresolved prints unresolved symbol error for rs that is referenced from s
+// by relocation r. With -undefs, it records the reference for reportUnresolved
+// instead.
+func (reporter *ErrorReporter) errorUnresolved(ldr *loader.Loader, s, rs loader.Sym, r loader.Reloc) {
 	reporter.unresMutex.Lock()
 	defer reporter.unresMutex.Unlock()
 
+	if *flagUndefs {
+		if reporter.unresRefs == nil {
+			reporter.unresRefs = make(map[unresolvedRef]bool)
+		}
+		reporter.unresRefs[unresolvedRef{unresolvedSymKey{from: s, to: rs}, r.Off(), r.Type()}] = true
+		return
+	}
+
 	if reporter.unresSyms == nil {
 		reporter.unresSyms = make(map[unresolvedSymKey]bool)
 	}
@@ -38,30 +61,139 @@ func (reporter *ErrorReporter) errorUnresolved(ldr *loader.Loader, s, rs loader.
 	if !reporter.unresSyms[k] {
 		reporter.unresSyms[k] = true
 		name := ldr.SymName(rs)
-
-		// Try to find symbol under another ABI.
-		var reqABI, haveABI obj.ABI
-		haveABI = ^obj.ABI(0)
-		reqABI, ok := sym.VersionToABI(ldr.SymVersion(rs))
-		if ok {
-			for abi := obj.ABI(0); abi < obj.ABICount; abi++ {
-				v := sym.ABIToVersion(abi)
-				if v == -1 {
-					continue
-				}
-				if rs1 := ldr.Lookup(name, v); rs1 != 0 && ldr.SymType(rs1) != sym.Sxxx && ldr.SymType(rs1) != sym.SXREF {
-					haveABI = abi
-				}
-			}
-		}
+		reqABI, haveABI, ok := unresolvedABIs(ldr, rs)
 
 		// Give a special error message for main symbol (see #24809).
 		if name == "main.main" {
 			reporter.Errorf(s, "function main is undeclared in the main package")
-		} else if haveABI != ^obj.ABI(0) {
+		} else if ok {
 			reporter.Errorf(s, "relocation target %s not defined for %s (but is defined for %s)", name, reqABI, haveABI)
 		} else {
 			reporter.Errorf(s, "relocation target %s not defined", name)
 		}
 	}
 }
+
+// unresolvedABIs returns the ABI that the unresolved symbol rs is
+// referred to by and one it is defined for, if there is one.
+func unresolvedABIs(ldr *loader.Loader, rs loader.Sym) (reqABI, haveABI obj.ABI, ok bool) {
+	// Try to find symbol under another ABI.
+	name := ldr.SymName(rs)
+	reqABI, ok = sym.VersionToABI(ldr.SymVersion(rs))
+	if !ok {
+		return 0, 0, false
+	}
+	haveABI = ^obj.ABI(0)
+	for abi := obj.ABI(0); abi < obj.ABICount; abi++ {
+		v := sym.ABIToVersion(abi)
+		if v == -1 {
+			continue
+		}
+		if rs1 := ldr.Lookup(name, v); rs1 != 0 && ldr.SymType(rs1) != sym.Sxxx && ldr.SymType(rs1) != sym.SXREF {
+			haveABI = abi
+		}
+	}
+	return reqABI, haveABI, haveABI != ^obj.ABI(0)
+}
+
+// reportUnresolved prints the unresolved references -undefs records,
+// once the relocations are all applied: for each package or host
+// object with references to undefined symbols, the symbols, sorted by
+// name, each with the sites of the references to it, and their
+// source positions where the referring symbol is a Go function.
+func (ctxt *Link) reportUnresolved() {
+	reporter := &ctxt.ErrorReporter
+	if len(reporter.unresRefs) == 0 {
+		return
+	}
+	ldr := ctxt.loader
+	type site struct {
+		from string
+		ref  unresolvedRef
+	}
+	// Package or host object, then undefined symbol name.
+	groups := make(map[string]map[string][]site)
+	for ref := range reporter.unresRefs {
+		where := crefWhere(ldr, ref.from)
+		if where == "" {
+			where = "the linker"
+		}
+		g := groups[where]
+		if g == nil {
+			g = make(map[string][]site)
+			groups[where] = g
+		}
+		name := ldr.SymName(ref.to)
+		g[name] = append(g[name], site{ldr.SymName(ref.from), ref})
+	}
+
+	nsyms := make(map[string]bool)
+	for _, where := range sortedKeys(groups) {
+		g := groups[where]
+		fmt.Fprintf(os.Stderr, "undefined symbols referenced by %s:\n", where)
+		for _, name := range sortedKeys(g) {
+			sites := g[name]
+			sort.Slice(sites, func(i, j int) bool {
+				if sites[i].from != sites[j].from {
+					return sites[i].from < sites[j].from
+				}
+				return sites[i].ref.off < sites[j].ref.off
+			})
+			nsyms[name] = true
+			if name == "main.main" {
+				fmt.Fprintf(os.Stderr, "\t%s (function main is undeclared in the main package)\n", name)
+			} else if reqABI, haveABI, ok := unresolvedABIs(ldr, sites[0].ref.to); ok {
+				fmt.Fprintf(os.Stderr, "\t%s (not defined for %s, but is defined for %s)\n", name, reqABI, haveABI)
+			} else {
+				fmt.Fprintf(os.Stderr, "\t%s\n", name)
+			}
+			for _, s := range sites {
+				fmt.Fprintf(os.Stderr, "\t\t%s+%#x: %s", s.from, s.ref.off, sym.RelocName(ctxt.Arch, s.ref.typ))
+				if pos := funcPosition(ctxt, s.ref.from, s.ref.off); pos != "" {
+					fmt.Fprintf(os.Stderr, " at %s", pos)
+				}
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d undefined symbols, %d references\n", len(nsyms), len(reporter.unresRefs))
+	nerrors++
+}
+
+// funcPosition returns the file:line of the source code at offset off
+// into the Go function s, or "" if s is not one.
+func funcPosition(ctxt *Link, s loader.Sym, off int32) string {
+	ldr := ctxt.loader
+	fi := ldr.FuncInfo(s)
+	if !fi.Valid() {
+		return ""
+	}
+	_, pcfile, pcline, _, _ := ldr.PcdataAuxs(s, nil)
+	value := func(tab loader.Sym) (int32, bool) {
+		it := obj.NewPCIter(uint32(ctxt.Arch.MinLC))
+		for it.Init(ldr.Data(tab)); !it.Done; it.Next() {
+			if uint32(off) >= it.PC && uint32(off) < it.NextPC {
+				return it.Value, true
+			}
+		}
+		return 0, false
+	}
+	file, ok1 := value(pcfile)
+	line, ok2 := value(pcline)
+	cu := ldr.SymUnit(s)
+	if !ok1 || !ok2 || cu == nil || file < 0 || int(file) >= len(cu.FileTable) {
+		return ""
+	}
+	// The pcfile values index the file table of the compilation unit.
+	return fmt.Sprintf("%s:%d", expandFile(cu.FileTable[file]), line)
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the control lists of the symbols a shared library
+// of -buildmode=c-shared or plugin exports, given by -export-symbols and
+// -export-dynamic-symbol. Without one, the library exports each symbol
+// that cgo exports and each global symbol of the host objects, such as
+// the C functions of runtime/cgo.
+//
+// A c-shared library exports just the symbols of the list: the external
+// linker is given a version script for ELF, an exported symbols list for
+// Mach-O and the export file for XCOFF. A plugin shares the runtime and
+// the packages of the program that opens it by the Go symbols of its
+// dynamic symbol table, so it still exports those, and the list instead
+// hides the global symbols of the host objects that are not on it.
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// flagExportSymbols are the files of -export-symbols.
+	flagExportSymbols []string
+
+	// flagExportDynamicSymbol are the symbols of -export-dynamic-symbol.
+	flagExportDynamicSymbol []string
+
+	// exportSymbols are the names of the symbols of the control list,
+	// once readExportSymbols has read them, as C names them.
+	exportSymbols []string
+)
+
+// exportsRestricted reports whether the exported symbols are restricted
+// to those of a control list.
+func exportsRestricted() bool {
+	return len(flagExportSymbols) > 0 || len(flagExportDynamicSymbol) > 0
+}
+
+// readExportSymbols reads the files of -export-symbols, a symbol name
+// per line, where blank lines and lines starting with # are ignored,
+// and adds the symbols of -export-dynamic-symbol.
+func readExportSymbols(ctxt *Link) {
+	if ctxt.BuildMode != BuildModeCShared && ctxt.BuildMode != BuildModePlugin {
+		Exitf("-export-symbols and -export-dynamic-symbol are only supported with -buildmode=c-shared or plugin")
+	}
+	if !ctxt.IsELF && !ctxt.IsDarwin() && !ctxt.IsAIX() {
+		Exitf("-export-symbols and -export-dynamic-symbol are not supported on %s", ctxt.HeadType)
+	}
+	seen := make(map[string]bool)
+	add := func(flag, name string) {
+		if strings.ContainsAny(name, " \t\"';{}") {
+			Exitf("%s: invalid symbol name %q", flag, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			exportSymbols = append(exportSymbols, name)
+		}
+	}
+	for _, file := range flagExportSymbols {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			Exitf("-export-symbols: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			add("-export-symbols", line)
+		}
+	}
+	for _, name := range flagExportDynamicSymbol {
+		add("-export-dynamic-symbol", name)
+	}
+	sort.Strings(exportSymbols)
+}
+
+// exportArgs returns the arguments of the external linker that restrict
+// the exported symbols to those of the control list, for the host
+// objects hostObjs.
+func (ctxt *Link) exportArgs(hostObjs []string) []string {
+	if !exportsRestricted() || ctxt.IsAIX() {
+		// On AIX, the list is the export file.
+		return nil
+	}
+	var names []string
+	if ctxt.BuildMode == BuildModePlugin {
+		names = ctxt.hiddenHostSymbols(hostObjs)
+	} else {
+		names = exportSymbols
+	}
+
+	var b bytes.Buffer
+	var file, arg string
+	if ctxt.IsDarwin() {
+		for _, name := range names {
+			fmt.Fprintf(&b, "_%s\n", name)
+		}
+		file = filepath.Join(*flagTmpdir, "exports.list")
+		if ctxt.BuildMode == BuildModePlugin {
+			arg = "-Wl,-unexported_symbols_list," + file
+		} else {
+			arg = "-Wl,-exported_symbols_list," + file
+		}
+	} else {
+		// The names of the list are quoted, so that they are not
+		// patterns, and * stands for all of the others.
+		quoted := func(scope string) {
+			fmt.Fprintf(&b, "  %s:\n", scope)
+			for _, name := range names {
+				fmt.Fprintf(&b, "    \"%s\";\n", name)
+			}
+		}
+		b.WriteString("{\n")
+		if ctxt.BuildMode == BuildModePlugin {
+			b.WriteString("  global:\n    *;\n")
+			if len(names) > 0 {
+				quoted("local")
+			}
+		} else {
+			if len(names) > 0 {
+				quoted("global")
+			}
+			b.WriteString("  local:\n    *;\n")
+		}
+		b.WriteString("};\n")
+		file = filepath.Join(*flagTmpdir, "exports.map")
+		arg = "-Wl,--version-script=" + file
+	}
+	if err := os.WriteFile(file, b.Bytes(), 0666); err != nil {
+		Exitf("cannot write the export list: %v", err)
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("export list %s:\n%s", file, b.Bytes())
+	}
+	return []string{arg}
+}
+
+// hiddenHostSymbols returns the names of the global symbols the host
+// objects hostObjs define that are not on the control list, as C names
+// them.
+func (ctxt *Link) hiddenHostSymbols(hostObjs []string) []string {
+	keep := make(map[string]bool)
+	for _, name := range exportSymbols {
+		keep[name] = true
+	}
+	hidden := make(map[string]bool)
+	for _, file := range hostObjs {
+		names, err := hostObjGlobals(file, ctxt.IsDarwin())
+		if err != nil {
+			Exitf("reading the symbols of host object %s: %v", file, err)
+		}
+		for _, name := range names {
+			if !keep[name] && !strings.ContainsAny(name, " \t\"';{}") {
+				hidden[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(hidden))
+	for name := range hidden {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hostObjGlobals returns the names of the global symbols of default
+// visibility the ELF or, if isMacho, Mach-O object file defines, without
+// the leading underscore of Mach-O.
+func hostObjGlobals(file string, isMacho bool) ([]string, error) {
+	var names []string
+	if isMacho {
+		f, err := macho.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if f.Symtab == nil {
+			return nil, nil
+		}
+		for _, s := range f.Symtab.Syms {
+			if s.Type == 0x0f { // N_SECT | N_EXT
+				names = append(names, strings.TrimPrefix(s.Name, "_"))
+			}
+		}
+		return names, nil
+	}
+
+	f, err := elf.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	for _, s := range syms {
+		bind := elf.ST_BIND(s.Info)
+		if s.Section == elf.SHN_UNDEF || bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+			continue
+		}
+		if elf.ST_VISIBILITY(s.Other) != elf.STV_DEFAULT {
+			continue
+		}
+		names = append(names, s.Name)
+	}
+	return names, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const exportsSrc = `package main
+
+import "C"
+
+//export Foo
+func Foo() C.int { return 1 }
+
+//export Bar
+func Bar() C.int { return 2 }
+
+func F() int { return 3 }
+
+func main() {}
+`
+
+// dynamicSymbols returns the defined symbols of the dynamic symbol table
+// of the ELF file exe.
+func dynamicSymbols(t *testing.T, exe string) map[string]bool {
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	syms, err := f.DynamicSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, s := range syms {
+		if s.Section != elf.SHN_UNDEF {
+			names[s.Name] = true
+		}
+	}
+	return names
+}
+
+func TestExportSymbols(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	testenv.MustHaveBuildMode(t, "c-shared")
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "lib.go")
+	if err := os.WriteFile(src, []byte(exportsSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	list := filepath.Join(dir, "exports")
+	if err := os.WriteFile(list, []byte("# The API.\nFoo\n\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	build := func(out, buildmode, ldflags string) ([]byte, error) {
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode="+buildmode, "-ldflags="+ldflags, "-o", out, src)
+		return cmd.CombinedOutput()
+	}
+
+	lib := filepath.Join(dir, "lib.so")
+	if out, err := build(lib, "c-shared", "-export-symbols="+list); err != nil {
+		t.Fatalf("build: %v\n%s", err, out)
+	}
+	syms := dynamicSymbols(t, lib)
+	if !syms["Foo"] {
+		t.Errorf("Foo is not exported")
+	}
+	for _, name := range []string{"Bar", "crosscall2", "_cgo_topofstack", "x_cgo_init"} {
+		if syms[name] {
+			t.Errorf("%s is exported, but not listed", name)
+		}
+	}
+
+	out, err := build(filepath.Join(dir, "exe"), "exe", "-export-dynamic-symbol=Foo")
+	if err == nil || !strings.Contains(string(out), "only supported with -buildmode=c-shared or plugin") {
+		t.Errorf("build of an executable with -export-dynamic-symbol: %v\n%s", err, out)
+	}
+}
+
+func TestExportSymbolsPlugin(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	testenv.MustHaveBuildMode(t, "plugin")
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	if testing.Short() {
+		t.Skip("skipping plugin build in short mode")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "p.go")
+	if err := os.WriteFile(src, []byte(exportsSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	host := filepath.Join(dir, "host.go")
+	hostSrc := `package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+)
+
+func main() {
+	p, err := plugin.Open(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	f, err := p.Lookup("F")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(f.(func() int)())
+}
+`
+	if err := os.WriteFile(host, []byte(hostSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	plug := filepath.Join(dir, "p.so")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode=plugin", "-ldflags=-export-dynamic-symbol=Foo", "-o", plug, src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	syms := dynamicSymbols(t, plug)
+	if !syms["Foo"] || syms["Bar"] || syms["x_cgo_init"] {
+		t.Errorf("plugin exports Foo %v, Bar %v and x_cgo_init %v, want just Foo", syms["Foo"], syms["Bar"], syms["x_cgo_init"])
+	}
+	if !syms["runtime.mallocgc"] {
+		t.Errorf("plugin does not export the Go symbol runtime.mallocgc")
+	}
+
+	exe := filepath.Join(dir, "host")
+	cmd = testenv.Command(t, testenv.GoToolPath(t), "build", "-o", exe, host)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	out, err := testenv.Command(t, exe, plug).CombinedOutput()
+	if err != nil || strings.TrimSpace(string(out)) != "3" {
+		t.Errorf("running the plugin: %v\n%s", err, out)
+	}
+}
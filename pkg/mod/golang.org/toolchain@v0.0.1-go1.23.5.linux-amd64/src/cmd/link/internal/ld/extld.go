@@ -0,0 +1,317 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the invocation of the external linker, and of the
+// probes of what it supports. A command is an extldCmd, a list of
+// arguments that is never joined into a string to be split again, so
+// that arguments with spaces, quotes or non-ASCII characters reach the
+// external linker as they are, and the probes run the whole command of
+// -extld, not just its first word.
+//
+// The command line of the external link is passed in a response file,
+// quoted as the GCC driver and clang read them on every platform, if it
+// is too long for the platform. The result of each probe is cached,
+// keyed by the identity of the linker (its path, size and modification
+// time) and the arguments of the probe, for the link and, with
+// -extldcache, for later links. With -extld-verbose each command is
+// logged as a shell command line that runs it again.
+
+import (
+	"bytes"
+	"cmd/internal/notsha256"
+	"cmd/internal/sys"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// An extldCmd is a command line of the external linker or of one of
+// the tools it finds.
+type extldCmd struct {
+	name string
+	args []string
+	env  []string // added to the environment
+}
+
+// String returns the command as a shell command line.
+func (c *extldCmd) String() string {
+	var b strings.Builder
+	for _, e := range c.env {
+		b.WriteString(shellQuote(e))
+		b.WriteByte(' ')
+	}
+	b.WriteString(shellQuote(c.name))
+	for _, a := range c.args {
+		b.WriteByte(' ')
+		b.WriteString(shellQuote(a))
+	}
+	return b.String()
+}
+
+// extldCommand returns the command of the external linker with the
+// arguments args.
+func (ctxt *Link) extldCommand(args ...string) *extldCmd {
+	extld := ctxt.extld()
+	return &extldCmd{name: extld[0], args: append(extld[1:len(extld):len(extld)], args...)}
+}
+
+// command returns the exec.Cmd that runs c.
+func (c *extldCmd) command() *exec.Cmd {
+	cmd := exec.Command(c.name, c.args...)
+	if len(c.env) > 0 {
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+	return cmd
+}
+
+// runExtld runs the command c and returns its combined output.
+func (ctxt *Link) runExtld(c *extldCmd) ([]byte, error) {
+	if *flagExtldVerbose {
+		ctxt.Logf("extld: %s\n", c)
+	}
+	return c.command().CombinedOutput()
+}
+
+// An extldProbe is the result of a probe of the external linker.
+type extldProbe struct {
+	Key string // identifies the linker and the probe
+	Out []byte // the combined output
+	OK  bool   // whether the command succeeded
+}
+
+var (
+	extldProbeMu    sync.Mutex
+	extldProbeCache = make(map[string]extldProbe)
+)
+
+// probeExtld runs the probe c of the external linker, unless it is
+// cached, and returns its output and whether it succeeded.
+func (ctxt *Link) probeExtld(c *extldCmd) ([]byte, bool) {
+	key := extldProbeKey(c)
+	extldProbeMu.Lock()
+	defer extldProbeMu.Unlock()
+	p, ok := extldProbeCache[key]
+	if !ok && *flagExtldCache != "" {
+		p, ok = readExtldProbe(*flagExtldCache, key)
+	}
+	if ok {
+		if *flagExtldVerbose {
+			ctxt.Logf("extld: %s (cached: %s)\n", c, okString(p.OK))
+		}
+		extldProbeCache[key] = p
+		return p.Out, p.OK
+	}
+
+	if *flagExtldVerbose {
+		ctxt.Logf("extld: %s\n", c)
+	}
+	out, err := c.command().CombinedOutput()
+	p = extldProbe{Key: key, Out: out, OK: err == nil}
+	extldProbeCache[key] = p
+	if *flagExtldCache != "" {
+		if err := writeExtldProbe(*flagExtldCache, p); err != nil && *flagExtldVerbose {
+			ctxt.Logf("extld: not caching the probe: %v\n", err)
+		}
+	}
+	return p.Out, p.OK
+}
+
+func okString(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "failed"
+}
+
+// extldProbeKey returns the key of the probe c: the identity of the
+// linker, its environment and its arguments, in which the temporary
+// directory, which differs from link to link, is replaced by $TMPDIR.
+func extldProbeKey(c *extldCmd) string {
+	var b strings.Builder
+	b.WriteString(extldIdentity(c.name))
+	for _, s := range [][]string{c.env, c.args} {
+		b.WriteString("\x00")
+		for _, a := range s {
+			if *flagTmpdir != "" {
+				a = strings.ReplaceAll(a, *flagTmpdir, "$TMPDIR")
+			}
+			b.WriteString(a)
+			b.WriteString("\x00")
+		}
+	}
+	return b.String()
+}
+
+// extldIdentity returns the identity of the program name: its path,
+// size and modification time, so that the probes of a linker are run
+// again once it is updated.
+func extldIdentity(name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return name
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	return fmt.Sprintf("%s %d %d", path, fi.Size(), fi.ModTime().UnixNano())
+}
+
+// extldProbeFile returns the file of the probe with the given key in
+// the cache directory dir.
+func extldProbeFile(dir, key string) string {
+	h := notsha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(h[:16])+".json")
+}
+
+// readExtldProbe reads the probe with the given key from the cache
+// directory dir.
+func readExtldProbe(dir, key string) (extldProbe, bool) {
+	data, err := os.ReadFile(extldProbeFile(dir, key))
+	if err != nil {
+		return extldProbe{}, false
+	}
+	var p extldProbe
+	if err := json.Unmarshal(data, &p); err != nil || p.Key != key {
+		return extldProbe{}, false
+	}
+	return p, true
+}
+
+// writeExtldProbe writes the probe p to the cache directory dir,
+// replacing the file so that concurrent links read either version.
+func writeExtldProbe(dir string, p extldProbe) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "probe")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), extldProbeFile(dir, p.Key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+var createTrivialCOnce sync.Once
+
+// linkerFlagSupported reports whether the external linker, run with
+// -fuse-ld=altLinker if altLinker is set, supports flag, by linking a
+// trivial C program with it.
+func (ctxt *Link) linkerFlagSupported(altLinker, flag string) bool {
+	createTrivialCOnce.Do(func() {
+		src := filepath.Join(*flagTmpdir, "trivial.c")
+		if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0666); err != nil {
+			Errorf(nil, "WriteFile trivial.c failed: %v", err)
+		}
+	})
+
+	flags := hostlinkArchArgs(ctxt.Arch)
+
+	moreFlags := trimLinkerArgv(append(flagExtldflags, ldflag...))
+	flags = append(flags, moreFlags...)
+
+	if altLinker != "" {
+		flags = append(flags, "-fuse-ld="+altLinker)
+	}
+	trivialPath := filepath.Join(*flagTmpdir, "trivial.c")
+	outPath := filepath.Join(*flagTmpdir, "a.out")
+	flags = append(flags, "-o", outPath, flag, trivialPath)
+
+	c := ctxt.extldCommand(flags...)
+	c.env = []string{"LC_ALL=C"}
+	out, ok := ctxt.probeExtld(c)
+	// GCC says "unrecognized command line option ‘-no-pie’"
+	// clang says "unknown argument: '-no-pie'"
+	return ok && !bytes.Contains(out, []byte("unrecognized")) && !bytes.Contains(out, []byte("unknown"))
+}
+
+// passLongArgsInResponseFile writes the arguments into a file if they
+// are too long for the command line of the platform.
+func (ctxt *Link) passLongArgsInResponseFile(argv []string, altLinker string) []string {
+	c := 0
+	for _, arg := range argv {
+		c += len(arg) + 1
+	}
+
+	if c < sys.ExecArgLengthLimit {
+		return argv
+	}
+
+	// Only use response files if they are supported.
+	response := filepath.Join(*flagTmpdir, "response")
+	if err := os.WriteFile(response, nil, 0644); err != nil {
+		Exitf("failed while testing response file: %v", err)
+	}
+	if !ctxt.linkerFlagSupported(altLinker, "@"+response) {
+		if ctxt.Debugvlog != 0 || *flagExtldVerbose {
+			ctxt.Logf("not using response file because linker does not support one\n")
+		}
+		return argv
+	}
+
+	data := responseFile(argv[1:])
+	if err := os.WriteFile(response, data, 0644); err != nil {
+		Exitf("failed while writing response file: %v", err)
+	}
+	if ctxt.Debugvlog != 0 || *flagExtldVerbose {
+		ctxt.Logf("response file %s contents:\n%s", response, data)
+	}
+	return []string{
+		argv[0],
+		"@" + response,
+	}
+}
+
+// responseFile returns a response file of the arguments args, as the
+// GCC driver and clang read them: an argument per line, with a
+// backslash before each space, quote and backslash, which leaves
+// other characters, such as non-ASCII ones, as they are.
+func responseFile(args []string) []byte {
+	var b bytes.Buffer
+	for _, arg := range args {
+		if arg == "" {
+			b.WriteString(`""`)
+		}
+		for i := 0; i < len(arg); i++ {
+			switch c := arg[i]; c {
+			case ' ', '\t', '\n', '\r', '\v', '\f', '\\', '\'', '"':
+				b.WriteByte('\\')
+			}
+			b.WriteByte(arg[i])
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// shellQuote returns s quoted for a POSIX shell, if it needs to be.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
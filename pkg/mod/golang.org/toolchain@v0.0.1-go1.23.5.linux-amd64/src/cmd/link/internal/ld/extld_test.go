@@ -0,0 +1,239 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// buildargv splits a response file into arguments as the GCC driver
+// does, with libiberty's buildargv.
+func buildargv(data []byte) []string {
+	var args []string
+	var arg []byte
+	inArg, squote, dquote, bsquote := false, false, false, false
+	for _, c := range data {
+		switch {
+		case bsquote:
+			bsquote = false
+			arg = append(arg, c)
+		case c == '\\':
+			bsquote = true
+		case squote:
+			if c == '\'' {
+				squote = false
+			} else {
+				arg = append(arg, c)
+			}
+		case dquote:
+			if c == '"' {
+				dquote = false
+			} else {
+				arg = append(arg, c)
+			}
+		case strings.IndexByte(" \t\n\r\v\f", c) >= 0:
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = nil, false
+			}
+			continue
+		case c == '\'':
+			squote = true
+		case c == '"':
+			dquote = true
+		default:
+			arg = append(arg, c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+var responseFileArgs = []string{
+	"-o",
+	"dir with spaces/a.out",
+	`q"uote`,
+	"it's",
+	`back\slash`,
+	`C:\dir\file.o`,
+	"tab\there",
+	"new\nline",
+	"",
+	"/tmp/héllo wörld/日本.o",
+	"-Wl,-rpath,$ORIGIN",
+}
+
+func TestResponseFile(t *testing.T) {
+	data := responseFile(responseFileArgs)
+	if got := buildargv(data); !reflect.DeepEqual(got, responseFileArgs) {
+		t.Errorf("response file %q is read as\n%q, want\n%q", data, got, responseFileArgs)
+	}
+	if !bytes.Contains(data, []byte("日本")) {
+		t.Errorf("response file %q does not keep non-ASCII characters", data)
+	}
+}
+
+// TestResponseFileCC links a C program whose source and output have
+// spaces and non-ASCII characters in their paths with a response file.
+func TestResponseFileCC(t *testing.T) {
+	testenv.MustHaveCGO(t)
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no cc")
+	}
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "dir with spaces", "héllo")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "it's \"main\".c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "wörld exe")
+	response := filepath.Join(dir, "response")
+	if err := os.WriteFile(response, responseFile([]string{"-o", exe, src}), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := testenv.Command(t, cc, "@"+response).CombinedOutput(); err != nil {
+		t.Fatalf("%s @%s: %v\n%s", cc, response, err, out)
+	}
+	if _, err := os.Stat(exe); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"-Wl,--build-id=0x12", "-Wl,--build-id=0x12"},
+		{"/usr/bin/gcc", "/usr/bin/gcc"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$ORIGIN", "'$ORIGIN'"},
+		{"héllo", "'héllo'"},
+	} {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+	c := &extldCmd{name: "gcc", args: []string{"-o", "a b"}, env: []string{"LC_ALL=C"}}
+	if got, want := c.String(), "LC_ALL=C gcc -o 'a b'"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+// TestExtldProbeCache runs a probe of a fake linker that counts its
+// runs, and checks that it is run again only once the linker changes.
+func TestExtldProbeCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake linker is a shell script")
+	}
+	defer func(tmpdir, cache string) {
+		*flagTmpdir, *flagExtldCache = tmpdir, cache
+		extldProbeCache = make(map[string]extldProbe)
+	}(*flagTmpdir, *flagExtldCache)
+
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	linker := filepath.Join(dir, "fake ld")
+	script := "#!/bin/sh\necho run >> '" + runs + "'\necho \"$@\"\n"
+	if err := os.WriteFile(linker, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	*flagExtldCache = filepath.Join(dir, "cache")
+	ctxt := &Link{}
+	probe := func(tmpdir string) string {
+		extldProbeCache = make(map[string]extldProbe)
+		*flagTmpdir = tmpdir
+		out, ok := ctxt.probeExtld(&extldCmd{name: linker, args: []string{"-o", filepath.Join(tmpdir, "a.out"), "-Wl,--version"}})
+		if !ok {
+			t.Fatalf("probe failed: %s", out)
+		}
+		return string(out)
+	}
+	nruns := func() int {
+		data, _ := os.ReadFile(runs)
+		return bytes.Count(data, []byte("run\n"))
+	}
+
+	out := probe("/tmp/go-link-1")
+	if want := "-o /tmp/go-link-1/a.out -Wl,--version\n"; out != want {
+		t.Errorf("probe output %q, want %q", out, want)
+	}
+	probe("/tmp/go-link-2")
+	if n := nruns(); n != 1 {
+		t.Errorf("linker run %d times for the same probe of two links, want once", n)
+	}
+
+	if err := os.WriteFile(linker, []byte(script+"exit 0\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	probe("/tmp/go-link-3")
+	if n := nruns(); n != 2 {
+		t.Errorf("linker run %d times once it changes, want twice", n)
+	}
+}
+
+func TestExtldVerbose(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nimport \"C\"\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "main")
+	ldflags := `-linkmode=external -extld-verbose "-extldflags='-Wl,-rpath,/dir with spaces/héllo'"`
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags="+ldflags, "-o", exe, src)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	// The last command is the link, with the flag quoted.
+	var last string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "extld: ") {
+			last = line
+		}
+	}
+	if !strings.Contains(last, " '-Wl,-rpath,/dir with spaces/héllo'") {
+		t.Errorf("last command %q does not have the quoted -extldflags:\n%s", last, out)
+	}
+	if !strings.Contains(string(out), "-Wl,--compress-debug-sections=zlib") {
+		t.Errorf("no probe of --compress-debug-sections:\n%s", out)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rpath, err := f.DynString(elf.DT_RUNPATH)
+	if err == nil && len(rpath) == 0 {
+		rpath, err = f.DynString(elf.DT_RPATH)
+	}
+	if err != nil || len(rpath) != 1 || rpath[0] != "/dir with spaces/héllo" {
+		t.Errorf("run path %q, %v, want the one of -extldflags", rpath, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// funcAlignMax is the largest alignment -funcalign takes, that of a
+// page.
+const funcAlignMax = 4096
+
+var (
+	// funcAlign is the alignment of -funcalign, or 0.
+	funcAlign int32
+
+	// funcAlignHot is set when -funcalign aligns only the functions of
+	// the -pgo profile.
+	funcAlignHot bool
+)
+
+// parseFuncAlign parses the [hot:]n argument of -funcalign.
+func parseFuncAlign(s string) (align int32, hot bool, err error) {
+	v, hot := strings.CutPrefix(s, "hot:")
+	n, err := strconv.ParseInt(v, 0, 32)
+	if err != nil || n <= 0 || n&(n-1) != 0 || n > funcAlignMax {
+		return 0, false, fmt.Errorf("%q is not of the form [hot:]n with n a power of two up to %d", s, funcAlignMax)
+	}
+	return int32(n), hot, nil
+}
+
+// alignText sets the alignment of the functions to that of -funcalign,
+// unless they need a larger one. Like the text ordering, it leaves out
+// the build ID and the functions of host objects, which stay with the
+// other functions of their sections.
+func (ctxt *Link) alignText() {
+	ldr := ctxt.loader
+	var hot map[string]bool
+	if funcAlignHot {
+		hot = make(map[string]bool)
+		if textOrderProfile != nil {
+			for _, e := range textOrderProfile.NamedEdgeMap.ByWeight {
+				hot[e.CallerName] = true
+				hot[e.CalleeName] = true
+			}
+		}
+	}
+	n := 0
+	for _, s := range movableText(ctxt) {
+		if hot != nil && !hot[ldr.SymName(s)] {
+			continue
+		}
+		if ldr.SymAlign(s) < funcAlign {
+			ldr.SetSymAlign(s, funcAlign)
+		}
+		n++
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("funcalign: aligned %d functions to %d bytes\n", n, funcAlign)
+	}
+}
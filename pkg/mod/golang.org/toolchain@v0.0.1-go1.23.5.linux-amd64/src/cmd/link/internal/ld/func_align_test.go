@@ -0,0 +1,121 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseFuncAlign(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		align int32
+		hot   bool
+		ok    bool
+	}{
+		{"64", 64, false, true},
+		{"hot:32", 32, true, true},
+		{"4096", 4096, false, true},
+		{"0", 0, false, false},
+		{"48", 0, false, false},
+		{"8192", 0, false, false},
+		{"hot:", 0, false, false},
+		{"cold:64", 0, false, false},
+	} {
+		align, hot, err := parseFuncAlign(tc.s)
+		if (err == nil) != tc.ok || align != tc.align || hot != tc.hot {
+			t.Errorf("%q: got %d, %v, %v", tc.s, align, hot, err)
+		}
+	}
+}
+
+// TestFuncAlignFlag links for linux/amd64 with -funcalign, for all the
+// functions and for those of a -pgo profile, and checks the addresses
+// of the functions.
+func TestFuncAlignFlag(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(textOrderSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	prof := filepath.Join(dir, "prof.pgo")
+	f, err := os.Create(prof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTextOrderProfile("main.fb", "main.fa", 100).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	link := func(ldflags string) map[string]uint64 {
+		exe := filepath.Join(dir, "a.out")
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags="+ldflags, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		if runtime.GOOS == "linux" && runtime.GOARCH == "amd64" {
+			// The padding and the function table must still let the
+			// program run.
+			out, err := testenv.Command(t, exe).CombinedOutput()
+			if err != nil || string(out) != "10\n" {
+				t.Errorf("%s: %v\n%s", ldflags, err, out)
+			}
+		}
+		ef, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ef.Close()
+		syms, err := ef.Symbols()
+		if err != nil {
+			t.Fatal(err)
+		}
+		funcs := make(map[string]uint64)
+		for _, s := range syms {
+			if elf.ST_TYPE(s.Info) == elf.STT_FUNC {
+				funcs[s.Name] = s.Value
+			}
+		}
+		return funcs
+	}
+
+	funcs := link("-funcalign=256")
+	for _, name := range []string{"main.main", "main.fa", "main.fb", "main.fc", "runtime.main", "runtime.mallocgc"} {
+		if addr := funcs[name]; addr == 0 || addr%256 != 0 {
+			t.Errorf("-funcalign=256: %s at %#x", name, addr)
+		}
+	}
+
+	funcs = link("-funcalign=hot:256 -pgo=" + prof)
+	for _, name := range []string{"main.fa", "main.fb"} {
+		if addr := funcs[name]; addr == 0 || addr%256 != 0 {
+			t.Errorf("-funcalign=hot:256: %s at %#x", name, addr)
+		}
+	}
+	unaligned := 0
+	for _, addr := range funcs {
+		if addr%256 != 0 {
+			unaligned++
+		}
+	}
+	if unaligned == 0 {
+		t.Errorf("-funcalign=hot:256 aligned all functions")
+	}
+}
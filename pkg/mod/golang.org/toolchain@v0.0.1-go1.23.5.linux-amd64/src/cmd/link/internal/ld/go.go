@@ -348,6 +348,9 @@ func adddynlib(ctxt *Link, lib string) {
 		return
 	}
 	seenlib[lib] = true
+	if ctxt.isStaticPIE() {
+		Exitf("-static-pie cannot link against dynamic library %s", lib)
+	}
 
 	if ctxt.IsELF {
 		dsu := ctxt.loader.MakeSymbolUpdater(ctxt.DynStr)
@@ -440,6 +443,13 @@ func (ctxt *Link) addexport() {
 		if !ctxt.loader.AttrReachable(s) {
 			panic("dynexp entry not reachable")
 		}
+		if ctxt.IsELF {
+			// Leave out the symbols the local patterns of
+			// -version-script hide.
+			if _, export := elfSymVersion(ctxt.loader.SymExtname(s)); !export {
+				continue
+			}
+		}
 
 		Adddynsym(ctxt.loader, &ctxt.Target, &ctxt.ArchSyms, s)
 	}
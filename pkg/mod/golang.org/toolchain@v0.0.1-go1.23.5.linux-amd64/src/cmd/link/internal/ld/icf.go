@@ -0,0 +1,282 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -icf, identical code folding. Instantiations of
+// generic functions for different shapes, and compiler-generated
+// wrappers, are often byte-for-byte the same code. Folding keeps the
+// first of each set of identical functions in ctxt.Textp and drops the
+// others from the text, making each an alias of the one kept: it gets
+// the same address, so references to it need no rewriting, and it stays
+// in the symbol table under its own name. Tracebacks and the pclntab
+// name the function kept.
+//
+// Two functions are identical if their code, relocations and the
+// metadata the runtime reads from the pclntab are, except for their
+// file and line tables. A relocation to another function that may fold
+// matches one to a function identical to it, so recursive and mutually
+// recursive functions fold as well; the sets are found by partition
+// refinement, splitting sets whose members refer to functions in
+// different sets until none splits.
+//
+// -icf=safe folds only functions whose address is not taken, which are
+// only called, so that no program can tell the folded functions apart.
+// -icf=all also folds functions whose address is taken, so that func
+// values and method tables of folded functions point at the same code.
+
+import (
+	"cmd/internal/notsha256"
+	"cmd/internal/objabi"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"internal/abi"
+)
+
+// checkICFMode checks the -icf mode.
+func checkICFMode(mode string) error {
+	switch mode {
+	case "none", "safe", "all":
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q; use none, safe or all", mode)
+}
+
+// icf folds identical functions for -icf.
+func (ctxt *Link) icf() {
+	ldr := ctxt.loader
+	var taken loader.Bitmap
+	if *flagICF == "safe" {
+		taken = icfAddressTaken(ldr)
+	}
+	var cands []loader.Sym
+	index := make(map[loader.Sym]int)
+	for _, s := range ctxt.Textp {
+		if icfCandidate(ldr, s) && (taken == nil || !taken.Has(s)) {
+			index[s] = len(cands)
+			cands = append(cands, s)
+		}
+	}
+
+	// Each refinement hashes the class of a function with the classes
+	// of the functions it refers to, which the first leaves out.
+	targets := make([][]int, len(cands))
+	class := make([]int, len(cands))
+	nclass := icfClasses(class, func(i int, h *icfHash) {
+		targets[i] = icfHashFunc(ldr, h, cands[i], index)
+	})
+	for {
+		next := make([]int, len(cands))
+		n := icfClasses(next, func(i int, h *icfHash) {
+			h.int(int64(class[i]))
+			for _, t := range targets[i] {
+				h.int(int64(class[t]))
+			}
+		})
+		class = next
+		if n == nclass {
+			break
+		}
+		nclass = n
+	}
+
+	// Classes are numbered in the order of their first functions,
+	// which are the ones kept.
+	reps := make([]loader.Sym, nclass)
+	ctxt.icfAliases = make(map[loader.Sym][]loader.Sym)
+	folded := make(map[loader.Sym]bool)
+	for i, s := range cands {
+		if rep := reps[class[i]]; rep != 0 {
+			ctxt.icfAliases[rep] = append(ctxt.icfAliases[rep], s)
+			folded[s] = true
+		} else {
+			reps[class[i]] = s
+		}
+	}
+	if len(folded) == 0 {
+		return
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("icf: folded %d of %d functions\n", len(folded), len(cands))
+	}
+
+	textp := ctxt.Textp[:0]
+	for _, s := range ctxt.Textp {
+		if !folded[s] {
+			textp = append(textp, s)
+		}
+	}
+	ctxt.Textp = textp
+	for _, lib := range ctxt.Library {
+		for _, unit := range lib.Units {
+			textp := unit.Textp[:0]
+			for _, s := range unit.Textp {
+				if !folded[loader.Sym(s)] {
+					textp = append(textp, s)
+				}
+			}
+			unit.Textp = textp
+		}
+	}
+}
+
+// icfCandidate reports whether the function s may fold. Functions
+// written in assembly, those the runtime treats specially, and those
+// of the runtime itself keep their own code, as do functions that
+// other objects may refer to by name.
+func icfCandidate(ldr *loader.Loader, s loader.Sym) bool {
+	if ldr.SymType(s) != sym.STEXT || ldr.IsExternal(s) || ldr.AttrSpecial(s) || ldr.AttrCgoExport(s) {
+		return false
+	}
+	if ldr.OuterSym(s) != 0 || ldr.SubSym(s) != 0 || isRuntimeDepPkg(ldr.SymPkg(s)) {
+		return false
+	}
+	fi := ldr.FuncInfo(s)
+	if !fi.Valid() || fi.FuncFlag()&(abi.FuncFlagTopFrame|abi.FuncFlagSPWrite|abi.FuncFlagAsm) != 0 {
+		return false
+	}
+	return fi.FuncID() == abi.FuncIDNormal || fi.FuncID() == abi.FuncIDWrapper
+}
+
+// icfAddressTaken returns the set of symbols that a reachable symbol
+// other than DWARF refers to other than by calling or jumping to it.
+func icfAddressTaken(ldr *loader.Loader) loader.Bitmap {
+	taken := loader.MakeBitmap(ldr.NSym())
+	for s := loader.Sym(1); s < loader.Sym(ldr.NSym()); s++ {
+		if !ldr.AttrReachable(s) || ldr.SymType(s).IsDWARF() {
+			continue
+		}
+		relocs := ldr.Relocs(s)
+		for ri := 0; ri < relocs.Count(); ri++ {
+			r := relocs.At(ri)
+			// Relocations of no size only mark a use of their target.
+			if r.Siz() == 0 || r.Type().IsDirectCallOrJump() || r.Sym() == 0 {
+				continue
+			}
+			taken.Set(r.Sym())
+		}
+	}
+	return taken
+}
+
+// icfHash is the hash of a function, or of its class and those of the
+// functions it refers to.
+type icfHash struct {
+	h   hash.Hash
+	buf []byte
+}
+
+func (h *icfHash) int(v int64) {
+	h.buf = binary.LittleEndian.AppendUint64(h.buf[:0], uint64(v))
+	h.h.Write(h.buf)
+}
+
+func (h *icfHash) bytes(b []byte) {
+	h.int(int64(len(b)))
+	h.h.Write(b)
+}
+
+// data hashes the contents of the symbol s, if any, into h.
+func (h *icfHash) data(ldr *loader.Loader, s loader.Sym) {
+	if s == 0 {
+		h.int(-1)
+		return
+	}
+	h.bytes(ldr.Data(s))
+}
+
+// icfClasses numbers the classes of the functions, each the functions
+// with the same hash as computed by hash, into class in the order of
+// their first members, and returns the number of classes.
+func icfClasses(class []int, hash func(i int, h *icfHash)) int {
+	ids := make(map[[notsha256.Size]byte]int)
+	h := icfHash{h: notsha256.New()}
+	for i := range class {
+		h.h.Reset()
+		hash(i, &h)
+		var sum [notsha256.Size]byte
+		h.h.Sum(sum[:0])
+		id, ok := ids[sum]
+		if !ok {
+			id = len(ids)
+			ids[sum] = id
+		}
+		class[i] = id
+	}
+	return len(ids)
+}
+
+// icfHashFunc hashes the function s into h, except for the functions it
+// refers to that may fold, which are in index, and returns those
+// functions' indexes in the order of its relocations.
+func icfHashFunc(ldr *loader.Loader, h *icfHash, s loader.Sym, index map[loader.Sym]int) []int {
+	h.int(ldr.SymSize(s))
+	h.int(int64(ldr.SymAlign(s)))
+	h.int(int64(ldr.SymLocalentry(s)))
+	h.bytes(ldr.Data(s))
+	var targets []int
+	relocs := ldr.Relocs(s)
+	h.int(int64(relocs.Count()))
+	for ri := 0; ri < relocs.Count(); ri++ {
+		r := relocs.At(ri)
+		icfHashReloc(h, r)
+		if t, ok := index[r.Sym()]; ok {
+			targets = append(targets, t)
+			h.int(-1)
+		} else {
+			h.int(int64(r.Sym()))
+		}
+	}
+
+	fi := ldr.FuncInfo(s)
+	fi.Preload()
+	h.int(int64(fi.Args()))
+	h.int(int64(fi.Locals()))
+	h.int(int64(fi.FuncID()))
+	h.int(int64(fi.FuncFlag()))
+	pcsp, _, _, pcinline, pcdata := ldr.PcdataAuxs(s, nil)
+	h.data(ldr, pcsp)
+	h.data(ldr, pcinline)
+	h.int(int64(len(pcdata)))
+	for _, p := range pcdata {
+		h.data(ldr, p)
+	}
+	h.int(int64(fi.NumInlTree()))
+	for k := 0; k < int(fi.NumInlTree()); k++ {
+		call := fi.InlTree(k)
+		h.int(int64(call.Parent))
+		h.int(int64(call.Func))
+		h.int(int64(call.ParentPC))
+	}
+	fd := ldr.Funcdata(s, nil)
+	h.int(int64(len(fd)))
+	for _, d := range fd {
+		h.data(ldr, d)
+		if d == 0 {
+			continue
+		}
+		relocs := ldr.Relocs(d)
+		h.int(int64(relocs.Count()))
+		for ri := 0; ri < relocs.Count(); ri++ {
+			r := relocs.At(ri)
+			icfHashReloc(h, r)
+			h.int(int64(r.Sym()))
+		}
+	}
+	return targets
+}
+
+// icfHashReloc hashes the relocation r into h, except for its target.
+func icfHashReloc(h *icfHash, r loader.Reloc) {
+	h.int(int64(r.Type()))
+	h.int(int64(r.Off()))
+	h.int(int64(r.Siz()))
+	h.int(r.Add())
+	if r.Weak() {
+		h.int(int64(objabi.R_WEAK))
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testICFSrc = `package main
+
+//go:noinline
+func add1(x int) int { return x*3 + 1 }
+
+//go:noinline
+func add2(x int) int { return x*3 + 1 }
+
+//go:noinline
+func add3(x int) int { return x*3 + 1 }
+
+//go:noinline
+func even(n int) bool {
+	if n == 0 {
+		return true
+	}
+	return odd(n - 1)
+}
+
+//go:noinline
+func odd(n int) bool {
+	if n == 0 {
+		return false
+	}
+	return even(n - 1)
+}
+
+//go:noinline
+func even2(n int) bool {
+	if n == 0 {
+		return true
+	}
+	return odd2(n - 1)
+}
+
+//go:noinline
+func odd2(n int) bool {
+	if n == 0 {
+		return false
+	}
+	return even2(n - 1)
+}
+
+var f = add3
+
+func main() { println(add1(1), add2(2), f(3), even(4), odd2(4)) }
+`
+
+// TestICFLink links a program with each -icf mode and checks which of
+// its identical functions share an address, and that it runs.
+func TestICFLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("skipping: runs an ELF program")
+	}
+	testenv.MustInternalLink(t, false)
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(testICFSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		mode string
+		same [][2]string // functions that fold together
+		diff [][2]string // functions that do not
+	}{
+		{"none", nil, [][2]string{{"add1", "add2"}, {"even", "even2"}}},
+		{"safe", [][2]string{{"add1", "add2"}, {"even", "even2"}, {"odd", "odd2"}}, [][2]string{{"add1", "add3"}, {"even", "odd"}}},
+		{"all", [][2]string{{"add1", "add2"}, {"add1", "add3"}, {"even", "even2"}}, [][2]string{{"even", "odd"}}},
+	} {
+		exe := filepath.Join(dir, tc.mode+".exe")
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -icf="+tc.mode, "-o", exe, src)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+
+		f, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		syms, err := f.Symbols()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := make(map[string]uint64)
+		for _, s := range syms {
+			if name, ok := strings.CutPrefix(s.Name, "main."); ok {
+				addr[name] = s.Value
+			}
+		}
+		for _, p := range tc.same {
+			if addr[p[0]] == 0 || addr[p[0]] != addr[p[1]] {
+				t.Errorf("-icf=%s: %s at %#x and %s at %#x, want the same address", tc.mode, p[0], addr[p[0]], p[1], addr[p[1]])
+			}
+		}
+		for _, p := range tc.diff {
+			if addr[p[0]] == addr[p[1]] {
+				t.Errorf("-icf=%s: %s and %s both at %#x", tc.mode, p[0], p[1], addr[p[0]])
+			}
+		}
+
+		out, err := testenv.Command(t, exe).CombinedOutput()
+		if want := "4 7 10 true false"; err != nil || strings.TrimSpace(string(out)) != want {
+			t.Errorf("-icf=%s: %s: %v\n%s, want %s", tc.mode, exe, err, out, want)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the experimental incremental mode of -incremental,
+// for edit-compile-link loops on large binaries. A full link keeps its
+// output, with the state it was laid out from, in a cache directory; a
+// later link of the same program that finds it patches the functions
+// that changed in a copy of that output instead of laying out and
+// writing the binary again.
+//
+// The state is keyed by the identity of the linker and the arguments of
+// the link, other than the paths of its input and output files, whose
+// contents are hashed instead. Once the packages are loaded, each symbol
+// they define is hashed twice: its shape (name, kind, size, alignment,
+// attributes, aux symbols, and relocations with the bytes they apply
+// to) and its contents with the bytes of its relocations masked. A link
+// can only be patched if the shape of every symbol is as it was, so that
+// the deadcode pass, the layout and the target of each relocation are
+// too, and if the only symbols whose contents changed are functions,
+// whose pcdata and DWARF, which are other symbols, did not. The bytes
+// of a function are then its new ones but for those of its relocations,
+// which are kept from the old output, as they have the same values. In
+// practice, that covers edits that change the constants of the code but
+// not its size or lines. Any other change links from scratch and
+// replaces the state.
+//
+// The mode is only supported for executables linked internally to ELF,
+// without host objects and without the options that write other files
+// or rewrite the output, such as -icf and -B gobuildid.
+
+import (
+	"bufio"
+	"bytes"
+	"cmd/internal/notsha256"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"internal/buildcfg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Offsets of the functions of an incrementalState that are not in the
+// output, or that cannot be patched in it.
+const (
+	incrementalDead      = -1
+	incrementalUnpatched = -2
+)
+
+// An incrementalState is the state of a link kept for the next one.
+type incrementalState struct {
+	Shape   [notsha256.Size]byte // hash of the shapes of the symbols
+	BuildID string               // -buildid of the link
+
+	// Hashes are the hashes of the contents of the symbols defined by
+	// the packages, and Offsets the offsets in the output of those
+	// that are functions, or incrementalDead or incrementalUnpatched.
+	// Both are indexed by symbol, minus 1.
+	Hashes  []uint64
+	Offsets []int64
+}
+
+var (
+	// incremental is the state of this link, if -incremental is given
+	// and the link supports it, and incrementalFile the file it is
+	// kept in.
+	incremental     *incrementalState
+	incrementalFile string
+)
+
+// incrementalUnsupported returns why the link cannot be incremental, or
+// "" if it can.
+func (ctxt *Link) incrementalUnsupported() string {
+	switch {
+	case !ctxt.IsELF:
+		return "the output is not ELF"
+	case ctxt.BuildMode != BuildModeExe && ctxt.BuildMode != BuildModePIE:
+		return fmt.Sprintf("of -buildmode=%s", ctxt.BuildMode)
+	case !ctxt.IsInternal():
+		return "the link is external"
+	case ctxt.linkShared:
+		return "of -linkshared"
+	case len(hostobj) > 0:
+		return "of the host objects"
+	case *flagICF != "none":
+		return "of -icf"
+	case *flagHostBuildid == "gobuildid":
+		return "of -B gobuildid"
+	case *flagSplitDwarf != "" || *flagLinkMap != "" || *flagJSONReport != "" || *flagM || *flagCref != "":
+		return "of an option that writes another file"
+	case len(postLinkPasses) > 0:
+		return "of the post-link passes"
+	case sizeBudgetsSet():
+		return "of the size budgets"
+	}
+	return ""
+}
+
+// incrementalRelink patches the output of the last link of the program
+// in the cache directory of -incremental, if it can, and reports
+// whether it did. Otherwise, it prepares the state that
+// writeIncrementalState keeps once the link is done.
+func (ctxt *Link) incrementalRelink() bool {
+	if why := ctxt.incrementalUnsupported(); why != "" {
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("incremental: not supported, as %s\n", why)
+		}
+		return false
+	}
+	ldr := ctxt.loader
+	incrementalFile = filepath.Join(*flagIncremental, incrementalKey()+".link")
+	cur := &incrementalState{BuildID: *flagBuildid}
+	cur.Shape, cur.Hashes = hashSymbols(ldr)
+	incremental = cur
+
+	old, out, err := readIncrementalFile(incrementalFile)
+	fullLink := func(why string) bool {
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("incremental: full link, as %s\n", why)
+		}
+		return false
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fullLink("there is no previous link")
+	case err != nil:
+		return fullLink(fmt.Sprintf("the previous link cannot be read: %v", err))
+	case old.Shape != cur.Shape || len(old.Hashes) != len(cur.Hashes):
+		return fullLink("symbols were added, removed or changed other than in their contents")
+	case len(old.BuildID) != len(cur.BuildID):
+		return fullLink("the build ID changed length")
+	}
+
+	var changed []loader.Sym
+	for i, h := range cur.Hashes {
+		if h == old.Hashes[i] {
+			continue
+		}
+		s := loader.Sym(i + 1)
+		switch {
+		case ldr.SymType(s) != sym.STEXT:
+			return fullLink(fmt.Sprintf("%s changed, which is not a function", ldr.SymName(s)))
+		case old.Offsets[i] == incrementalUnpatched:
+			return fullLink(fmt.Sprintf("function %s changed, which cannot be patched", ldr.SymName(s)))
+		case old.Offsets[i] != incrementalDead:
+			changed = append(changed, s)
+		}
+	}
+	for _, s := range changed {
+		data := ldr.Data(s)
+		off := old.Offsets[s-1]
+		if int64(len(data)) != ldr.SymSize(s) || off+int64(len(data)) > int64(len(out)) {
+			return fullLink(fmt.Sprintf("function %s cannot be patched", ldr.SymName(s)))
+		}
+		// Keep the bytes of the relocations, which have not changed.
+		p := out[off : off+int64(len(data))]
+		prev := append([]byte(nil), p...)
+		copy(p, data)
+		relocs := ldr.Relocs(s)
+		for ri := 0; ri < relocs.Count(); ri++ {
+			r := relocs.At(ri)
+			if start := int(r.Off()); start >= 0 && start < len(p) {
+				end := start + int(r.Siz())
+				if end > len(p) {
+					end = len(p)
+				}
+				copy(p[start:end], prev[start:end])
+			}
+		}
+	}
+	if old.BuildID != cur.BuildID {
+		replaceAll(out, []byte(old.BuildID), []byte(cur.BuildID))
+	}
+	cur.Offsets = old.Offsets
+
+	ctxt.Out.Close()
+	if err := os.WriteFile(*flagOutfile, out, outbufMode); err != nil {
+		Exitf("cannot write %s: %v", *flagOutfile, err)
+	}
+	if err := writeIncrementalFile(incrementalFile, cur, out); err != nil && ctxt.Debugvlog != 0 {
+		ctxt.Logf("incremental: not keeping the state: %v\n", err)
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("incremental: functions patched: %d\n", len(changed))
+	}
+	return true
+}
+
+// replaceAll replaces each occurrence of old in b with new, which has
+// the same length.
+func replaceAll(b, old, new []byte) {
+	if len(old) == 0 {
+		return
+	}
+	for i := 0; ; {
+		j := bytes.Index(b[i:], old)
+		if j < 0 {
+			return
+		}
+		copy(b[i+j:], new)
+		i += j + len(old)
+	}
+}
+
+// writeIncrementalState keeps the state of the link, once its output is
+// written, for the next one.
+func (ctxt *Link) writeIncrementalState() {
+	st := incremental
+	if st == nil {
+		return
+	}
+	out, err := os.ReadFile(*flagOutfile)
+	if err != nil {
+		Exitf("cannot read %s: %v", *flagOutfile, err)
+	}
+	ldr := ctxt.loader
+	st.Offsets = make([]int64, len(st.Hashes))
+	var buf []byte
+	for i := range st.Offsets {
+		s := loader.Sym(i + 1)
+		st.Offsets[i] = incrementalUnpatched
+		if ldr.SymType(s) != sym.STEXT {
+			continue
+		}
+		if !ldr.AttrReachable(s) {
+			st.Offsets[i] = incrementalDead
+			continue
+		}
+		sect := ldr.SymSect(s)
+		if sect == nil || sect.Seg == nil {
+			continue
+		}
+		off := int64(sect.Seg.Fileoff) + ldr.SymValue(s) - int64(sect.Seg.Vaddr)
+		end := off + ldr.SymSize(s)
+		if off < 0 || end > int64(sect.Seg.Fileoff+sect.Seg.Filelen) || end > int64(len(out)) {
+			continue
+		}
+		// A function can be patched if it was written as it is but
+		// for its relocations.
+		var h uint64
+		h, buf = maskedHash(out[off:end], ldr.Relocs(s), buf)
+		if h == st.Hashes[i] {
+			st.Offsets[i] = off
+		}
+	}
+	if err := writeIncrementalFile(incrementalFile, st, out); err != nil && ctxt.Debugvlog != 0 {
+		ctxt.Logf("incremental: not keeping the state: %v\n", err)
+	}
+}
+
+// incrementalKey returns the key of the state of the link: a hash of
+// the identity of the linker, of the target, and of the arguments of
+// the link but for the paths of its input and output files. The
+// contents of the other files the arguments name are hashed too.
+func incrementalKey() string {
+	h := notsha256.New()
+	exe, _ := os.Executable()
+	fmt.Fprintf(h, "%s\x00%s/%s\x00%s\x00", extldIdentity(exe), buildcfg.GOOS, buildcfg.GOARCH, buildcfg.Experiment.String())
+	name, value := buildcfg.GOGOARCH()
+	fmt.Fprintf(h, "%s=%s\x00", name, value)
+
+	var importcfg string
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			// The packages to link, which are hashed by their
+			// symbols.
+			break
+		}
+		name, value, ok := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		f := flag.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if b, isBool := f.Value.(interface{ IsBoolFlag() bool }); !ok && !(isBool && b.IsBoolFlag()) && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "importcfg":
+			importcfg = value
+			continue
+		case "o", "buildid", "tmpdir", "incremental":
+			continue
+		}
+		fmt.Fprintf(h, "-%s=%s\x00", name, value)
+		if fi, err := os.Stat(value); err == nil && fi.Mode().IsRegular() {
+			if data, err := os.ReadFile(value); err == nil {
+				h.Write(data)
+			}
+		}
+	}
+
+	// The import configuration names the files of the packages, but
+	// may give the module information too.
+	if importcfg != "" {
+		data, _ := os.ReadFile(importcfg)
+		for _, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "packagefile ") {
+				fmt.Fprintf(h, "%s\n", line)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// hashSymbols returns the hash of the shapes of the symbols the packages
+// define, and the hashes of their contents.
+func hashSymbols(ldr *loader.Loader) ([notsha256.Size]byte, []uint64) {
+	h := notsha256.New()
+	w := bufio.NewWriter(h)
+	hashes := make([]uint64, ldr.NDef()-1)
+	var buf []byte
+	var b [8]byte
+	num := func(x int64) {
+		binary.LittleEndian.PutUint64(b[:], uint64(x))
+		w.Write(b[:])
+	}
+	for s := loader.Sym(1); s < loader.Sym(ldr.NDef()); s++ {
+		data := ldr.Data(s)
+		fmt.Fprintf(w, "%s\x00%s\x00", ldr.SymName(s), ldr.SymPkg(s))
+		num(int64(ldr.SymVersion(s)))
+		num(int64(ldr.SymType(s)))
+		num(ldr.SymSize(s))
+		num(int64(len(data)))
+		num(int64(ldr.SymAlign(s)))
+		num(int64(ldr.SymAttr(s)))
+		for j := 0; j < ldr.NAux(s); j++ {
+			a := ldr.Aux(s, j)
+			num(int64(a.Type()))
+			num(int64(a.Sym()))
+		}
+		relocs := ldr.Relocs(s)
+		num(int64(relocs.Count()))
+		for j := 0; j < relocs.Count(); j++ {
+			r := relocs.At(j)
+			num(int64(r.Off()))
+			num(int64(r.Siz()))
+			num(int64(r.Type()))
+			num(r.Add())
+			num(int64(r.Sym()))
+			if r.Sym() >= loader.Sym(ldr.NDef()) {
+				fmt.Fprintf(w, "%s\x00", ldr.SymName(r.Sym()))
+			}
+			if start, end := int(r.Off()), int(r.Off())+int(r.Siz()); start >= 0 && end <= len(data) {
+				w.Write(data[start:end])
+			}
+		}
+		hashes[s-1], buf = maskedHash(data, relocs, buf)
+	}
+	w.Flush()
+	var shape [notsha256.Size]byte
+	h.Sum(shape[:0])
+	return shape, hashes
+}
+
+// maskedHash returns the hash of data, the contents of a symbol, with
+// the bytes of its relocations relocs zeroed, using buf as scratch
+// space, which it returns.
+func maskedHash(data []byte, relocs loader.Relocs, buf []byte) (uint64, []byte) {
+	buf = append(buf[:0], data...)
+	for j := 0; j < relocs.Count(); j++ {
+		r := relocs.At(j)
+		start := int(r.Off())
+		if start < 0 || start >= len(buf) {
+			continue
+		}
+		end := start + int(r.Siz())
+		if end > len(buf) {
+			end = len(buf)
+		}
+		for i := start; i < end; i++ {
+			buf[i] = 0
+		}
+	}
+	sum := notsha256.Sum256(buf)
+	return binary.LittleEndian.Uint64(sum[:]), buf
+}
+
+// The file of an incremental state is its length, its encoding by gob
+// and the output of its link, so that it is replaced in one rename.
+
+// readIncrementalFile reads the state and the output of the last link
+// from file.
+func readIncrementalFile(file string) (*incrementalState, []byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < 8 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	n := binary.LittleEndian.Uint64(data)
+	if n > uint64(len(data)-8) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	st := new(incrementalState)
+	if err := gob.NewDecoder(bytes.NewReader(data[8 : 8+n])).Decode(st); err != nil {
+		return nil, nil, err
+	}
+	if len(st.Offsets) != len(st.Hashes) {
+		return nil, nil, errors.New("malformed state")
+	}
+	return st, data[8+n:], nil
+}
+
+// writeIncrementalFile writes the state st and the output out of the
+// link to file, replacing it so that concurrent links read either
+// version.
+func writeIncrementalFile(file string, st *incrementalState, out []byte) error {
+	var b bytes.Buffer
+	b.Write(make([]byte, 8))
+	if err := gob.NewEncoder(&b).Encode(st); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(b.Bytes(), uint64(b.Len()-8))
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), "link")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b.Bytes())
+	if err == nil {
+		_, err = f.Write(out)
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
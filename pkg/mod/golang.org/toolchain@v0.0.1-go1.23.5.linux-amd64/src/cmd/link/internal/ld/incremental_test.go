@@ -0,0 +1,84 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestIncremental links a program, edits a constant of one of its
+// functions and links it again, which patches the output of the first
+// link, and checks that the output is that of a full link. It then adds
+// a function, which needs a full link.
+func TestIncremental(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	write := func(src string) {
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := func(out, want string) {
+		t.Helper()
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-v -incremental="+cache, "-o", out, "main.go")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+		log, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, log)
+		}
+		if !strings.Contains(string(log), "incremental: "+want) {
+			t.Errorf("link of %s did not report %q:\n%s", out, want, log)
+		}
+	}
+	run := func(exe, want string) {
+		t.Helper()
+		out, err := testenv.Command(t, filepath.Join(dir, exe)).CombinedOutput()
+		if err != nil || strings.TrimSpace(string(out)) != want {
+			t.Errorf("%s printed %q, %v, want %q", exe, out, err, want)
+		}
+	}
+
+	const src = "package main\n\n//go:noinline\nfunc f() int { return 1 }\n\nfunc main() { println(f()) }\n"
+	write(src)
+	build("v1", "full link, as there is no previous link")
+	run("v1", "1")
+
+	write(strings.Replace(src, "return 1", "return 2", 1))
+	build("v2", "functions patched: 1")
+	run("v2", "2")
+
+	if err := os.RemoveAll(cache); err != nil {
+		t.Fatal(err)
+	}
+	build("full", "full link")
+	patched, err := os.ReadFile(filepath.Join(dir, "v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := os.ReadFile(filepath.Join(dir, "full"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched, full) {
+		t.Errorf("the patched output differs from that of a full link")
+	}
+
+	write(src + "\n//go:noinline\nfunc g() int { return f() }\n")
+	build("v3", "full link, as symbols were added, removed or changed")
+	run("v3", "1")
+}
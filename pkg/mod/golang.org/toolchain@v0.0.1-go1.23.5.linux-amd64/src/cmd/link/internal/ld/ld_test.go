@@ -76,6 +76,39 @@ func TestUndefinedRelocErrors(t *testing.T) {
 	}
 }
 
+func TestUndefinedRelocErrorsAll(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	testenv.MustInternalLink(t, false)
+
+	t.Parallel()
+
+	out, err := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-undefs", "./testdata/issue10978").CombinedOutput()
+	if err == nil {
+		t.Fatal("expected build to fail")
+	}
+
+	// Every reference is listed, each with its source line.
+	for _, want := range []string{
+		"undefined symbols referenced by main",
+		"\tmain.main (function main is undeclared in the main package)\n",
+		"\tmain.undefined\n",
+		"\t\tmain.defined1+",
+		"\t\tmain.defined2+",
+		"issue10978/main.go:12\n",
+		"issue10978/main.go:13\n",
+		"issue10978/main.go:18\n",
+		"issue10978/main.go:19\n",
+		"2 undefined symbols, 5 references\n",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(string(out), "relocation target") {
+		t.Errorf("output has per-symbol errors:\n%s", out)
+	}
+}
+
 const carchiveSrcText = `
 package main
 
@@ -113,7 +146,9 @@ func TestArchiveBuildInvokeWithExec(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ldf := fmt.Sprintf("-ldflags=-v -tmpdir=%s", dir)
+	// The linker writes the archive itself unless it is given an
+	// archive program.
+	ldf := fmt.Sprintf("-ldflags=-v -tmpdir=%s -extar=ar", dir)
 	argv := []string{"build", "-buildmode=c-archive", "-o", arfile, ldf, srcfile}
 	out, err := testenv.Command(t, testenv.GoToolPath(t), argv...).CombinedOutput()
 	if err != nil {
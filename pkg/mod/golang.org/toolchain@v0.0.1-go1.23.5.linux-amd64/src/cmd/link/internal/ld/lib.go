@@ -187,6 +187,10 @@ type Arch struct {
 	// We leave some room for extra stuff like PLT stubs.
 	TrampLimit uint64
 
+	// TrampSize is the maximum size of a trampoline, of which the text
+	// layout reserves one for each direct call of a function.
+	TrampSize uint64
+
 	// Empty spaces between codeblocks will be padded with this value.
 	// For example an architecture might want to pad with a trap instruction to
 	// catch wayward programs. Architectures that do not define a padding value
@@ -262,6 +266,12 @@ type Arch struct {
 	// optional override for assignAddress
 	AssignAddress func(ldr *loader.Loader, sect *sym.Section, n int, s loader.Sym, va uint64, isTramp bool) (*sym.Section, int, uint64)
 
+	// Relax optionally shrinks the text of host objects for the
+	// addresses textaddress has assigned, before it lays down any
+	// trampolines, and reports whether it changed any. textaddress
+	// calls it again after each change, when linking internally.
+	Relax func(*Link, *loader.Loader) bool
+
 	// ELF specific information.
 	ELF ELFArch
 }
@@ -412,6 +422,12 @@ func libinit(ctxt *Link) {
 			*flagEntrySymbol = fmt.Sprintf("_rt0_%s_%s_lib", buildcfg.GOARCH, buildcfg.GOOS)
 		case BuildModeExe, BuildModePIE:
 			*flagEntrySymbol = fmt.Sprintf("_rt0_%s_%s", buildcfg.GOARCH, buildcfg.GOOS)
+			if *flagStaticPIE {
+				// The entry point that relocates the executable,
+				// when linking internally. An external linker
+				// enters at main.
+				*flagEntrySymbol += "_static_pie"
+			}
 		case BuildModeShared, BuildModePlugin:
 			// No *flagEntrySymbol for -buildmode=shared and plugin
 		default:
@@ -493,14 +509,15 @@ func (ctxt *Link) extld() []string {
 // findLibPathCmd uses cmd command to find gcc library libname.
 // It returns library full path if found, or "none" if not found.
 func (ctxt *Link) findLibPathCmd(cmd, libname string) string {
-	extld := ctxt.extld()
-	name, args := extld[0], extld[1:]
-	args = append(args, hostlinkArchArgs(ctxt.Arch)...)
-	args = append(args, cmd)
+	c := ctxt.extldCommand(hostlinkArchArgs(ctxt.Arch)...)
+	c.args = append(c.args, cmd)
 	if ctxt.Debugvlog != 0 {
-		ctxt.Logf("%s %v\n", extld, args)
+		ctxt.Logf("%s %v\n", c.name, c.args)
+	}
+	if *flagExtldVerbose {
+		ctxt.Logf("extld: %s\n", c)
 	}
-	out, err := exec.Command(name, args...).Output()
+	out, err := c.command().Output()
 	if err != nil {
 		if ctxt.Debugvlog != 0 {
 			ctxt.Logf("not using a %s file because compiler failed\n%v\n%s\n", libname, err, out)
@@ -640,8 +657,16 @@ func (ctxt *Link) loadlib() {
 			if ctxt.HeadType == objabi.Hwindows {
 				loadWindowsHostArchives(ctxt)
 			}
+			if ctxt.IsDarwin() {
+				// The system linker takes a symbol from the
+				// dynamic libraries before libgcc.
+				machoResolveStubs(ctxt)
+			}
 			if *flagLibGCC != "none" {
 				hostArchive(ctxt, *flagLibGCC)
+				if ctxt.IsDarwin() {
+					machoResolveStubs(ctxt)
+				}
 			}
 			// For glibc systems, the linker setup used by GCC
 			// looks like
@@ -1155,6 +1180,9 @@ type Hostobj struct {
 	file   string
 	off    int64
 	length int64
+
+	// symlo and symhi bound the symbols that loading it made.
+	symlo, symhi loader.Sym
 }
 
 var hostobj []Hostobj
@@ -1228,7 +1256,9 @@ func hostobjs(ctxt *Link) {
 			Errorf(nil, "%s: unrecognized object file format", h.pn)
 			continue
 		}
+		h.symlo = loader.Sym(ctxt.loader.NSym())
 		h.ld(ctxt, f, h.pkg, h.length, h.pn)
+		h.symhi = loader.Sym(ctxt.loader.NSym())
 		if *flagCaptureHostObjs != "" {
 			captureHostObj(h)
 		}
@@ -1327,6 +1357,11 @@ func (ctxt *Link) hostobjCopy() (paths []string) {
 			if err := w.Close(); err != nil {
 				Exitf("cannot close %s: %v", dst, err)
 			}
+			if len(dwarfPrefixMaps) > 0 {
+				if err := remapHostObjDwarf(dst); err != nil {
+					Exitf("-dwarf-prefix-map: host object %s: %v", h.pn, err)
+				}
+			}
 		}()
 	}
 	wg.Wait()
@@ -1366,11 +1401,13 @@ func (ctxt *Link) archive() {
 
 	exitIfErrors()
 
-	if *flagExtar == "" {
+	// The linker writes the archive itself, unless it is given an
+	// archive program or it is a big archive of AIX.
+	internal := *flagExtar == "" && ctxt.HeadType != objabi.Haix
+	if *flagExtar == "" && !internal {
 		const printProgName = "--print-prog-name=ar"
-		cc := ctxt.extld()
 		*flagExtar = "ar"
-		if linkerFlagSupported(ctxt.Arch, cc[0], "", printProgName) {
+		if ctxt.linkerFlagSupported("", printProgName) {
 			*flagExtar = ctxt.findExtLinkTool("ar")
 		}
 	}
@@ -1383,37 +1420,55 @@ func (ctxt *Link) archive() {
 		Exitf("error closing %v", *flagOutfile)
 	}
 
-	argv := []string{*flagExtar, "-q", "-c", "-s"}
-	if ctxt.HeadType == objabi.Haix {
-		argv = append(argv, "-X64")
-	}
 	godotopath := filepath.Join(*flagTmpdir, "go.o")
 	cleanTimeStamps([]string{godotopath})
 	hostObjCopyPaths := ctxt.hostobjCopy()
 	cleanTimeStamps(hostObjCopyPaths)
 
-	argv = append(argv, *flagOutfile)
-	argv = append(argv, godotopath)
-	argv = append(argv, hostObjCopyPaths...)
-
-	if ctxt.Debugvlog != 0 {
-		ctxt.Logf("archive: %s\n", strings.Join(argv, " "))
+	files := append([]string{godotopath}, hostObjCopyPaths...)
+	if len(flagRsrc) > 0 {
+		files = append(files, writeRsrcObject(ctxt))
 	}
 
-	// If supported, use syscall.Exec() to invoke the archive command,
-	// which should be the final remaining step needed for the link.
-	// This will reduce peak RSS for the link (and speed up linking of
-	// large applications), since when the archive command runs we
-	// won't be holding onto all of the linker's live memory.
-	if syscallExecSupported && !ownTmpDir {
-		runAtExitFuncs()
-		ctxt.execArchive(argv)
-		panic("should not get here")
-	}
+	// With -reproducible on darwin the archive is still to be
+	// normalized afterwards.
+	normalize := ctxt.IsDarwin() && (*flagReproducible || reproPasses != nil)
+	if internal {
+		ctxt.writeCArchive(files)
+	} else {
+		argv := []string{*flagExtar, "-q", "-c", "-s"}
+		if ctxt.HeadType == objabi.Haix {
+			argv = append(argv, "-X64")
+		}
+		argv = append(argv, *flagOutfile)
+		argv = append(argv, files...)
+
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("archive: %s\n", strings.Join(argv, " "))
+		}
+
+		// If supported, use syscall.Exec() to invoke the archive command,
+		// which should be the final remaining step needed for the link.
+		// This will reduce peak RSS for the link (and speed up linking of
+		// large applications), since when the archive command runs we
+		// won't be holding onto all of the linker's live memory.
+		//
+		// With -reproducible on darwin we need to get control back.
+		if syscallExecSupported && !ownTmpDir && !normalize {
+			runAtExitFuncs()
+			ctxt.execArchive(argv)
+			panic("should not get here")
+		}
 
-	// Otherwise invoke 'ar' in the usual way (fork + exec).
-	if out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput(); err != nil {
-		Exitf("running %s failed: %v\n%s", argv[0], err, out)
+		// Otherwise invoke 'ar' in the usual way (fork + exec).
+		if out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput(); err != nil {
+			Exitf("running %s failed: %v\n%s", argv[0], err, out)
+		}
+	}
+	if normalize {
+		if err := machoNormalizeArchive(*flagOutfile); err != nil {
+			Exitf("%s: normalizing archive failed: %v", os.Args[0], err)
+		}
 	}
 }
 
@@ -1450,6 +1505,10 @@ func (ctxt *Link) hostlink() {
 			// -headerpad is incompatible with -fembed-bitcode.
 			argv = append(argv, "-Wl,-headerpad,1144")
 		}
+		if machoPlatformFlag != nil {
+			argv = append(argv, machoPlatformFlag.hostlinkArgs()...)
+		}
+		argv = append(argv, machoStubHostlinkArgs()...)
 		if ctxt.DynlinkingGo() && buildcfg.GOOS != "ios" {
 			// -flat_namespace is deprecated on iOS.
 			// It is useful for supporting plugins. We don't support plugins on iOS.
@@ -1472,7 +1531,7 @@ func (ctxt *Link) hostlink() {
 		if ctxt.BuildMode != BuildModePIE {
 			argv = append(argv, "-Wl,-nopie")
 		}
-		if linkerFlagSupported(ctxt.Arch, argv[0], "", "-Wl,-z,nobtcfi") {
+		if ctxt.linkerFlagSupported("", "-Wl,-z,nobtcfi") {
 			// -Wl,-z,nobtcfi is only supported on OpenBSD 7.4+, remove guard
 			// when OpenBSD 7.5 is released and 7.3 is no longer supported.
 			argv = append(argv, "-Wl,-z,nobtcfi")
@@ -1498,8 +1557,8 @@ func (ctxt *Link) hostlink() {
 
 		argv = append(argv, fmt.Sprintf("-Wl,--major-os-version=%d", PeMinimumTargetMajorVersion))
 		argv = append(argv, fmt.Sprintf("-Wl,--minor-os-version=%d", PeMinimumTargetMinorVersion))
-		argv = append(argv, fmt.Sprintf("-Wl,--major-subsystem-version=%d", PeMinimumTargetMajorVersion))
-		argv = append(argv, fmt.Sprintf("-Wl,--minor-subsystem-version=%d", PeMinimumTargetMinorVersion))
+		argv = append(argv, fmt.Sprintf("-Wl,--major-subsystem-version=%d", peSubsystemMajor))
+		argv = append(argv, fmt.Sprintf("-Wl,--minor-subsystem-version=%d", peSubsystemMinor))
 	case objabi.Haix:
 		argv = append(argv, "-pthread")
 		// prevent ld to reorder .text functions to keep the same
@@ -1515,7 +1574,7 @@ func (ctxt *Link) hostlink() {
 	// PC relative relocations might be generated by Go. Only targets compiling ELF
 	// binaries might generate these relocations.
 	if ctxt.IsPPC64() && ctxt.IsElf() && buildcfg.GOPPC64 >= 10 {
-		if !linkerFlagSupported(ctxt.Arch, argv[0], "", "-mcpu=power10") {
+		if !ctxt.linkerFlagSupported("", "-mcpu=power10") {
 			Exitf("The external toolchain does not support -mcpu=power10. " +
 				" This is required to externally link GOPPC64 >= power10")
 		}
@@ -1549,7 +1608,7 @@ func (ctxt *Link) hostlink() {
 			heopt = heon
 		} else {
 			// Test to see whether "--disable-dynamicbase" works.
-			newer := linkerFlagSupported(ctxt.Arch, argv[0], "", "-Wl,"+dboff)
+			newer := ctxt.linkerFlagSupported("", "-Wl,"+dboff)
 			if newer {
 				// Newer compiler, which supports both on/off options.
 				dbopt = dboff
@@ -1601,7 +1660,11 @@ func (ctxt *Link) hostlink() {
 			if ctxt.UseRelro() {
 				argv = append(argv, "-Wl,-z,relro")
 			}
-			argv = append(argv, "-pie")
+			if *flagStaticPIE {
+				argv = append(argv, "-static-pie")
+			} else {
+				argv = append(argv, "-pie")
+			}
 		}
 	case BuildModeCShared:
 		if ctxt.HeadType == objabi.Hdarwin {
@@ -1637,6 +1700,29 @@ func (ctxt *Link) hostlink() {
 		}
 	}
 
+	switch {
+	case ctxt.IsELF && elfZRelro < 0:
+		argv = append(argv, "-Wl,-z,norelro")
+	case ctxt.IsELF && elfZRelro > 0 && ctxt.BuildMode == BuildModeExe:
+		argv = append(argv, "-Wl,-z,relro")
+	}
+	switch {
+	case ctxt.IsELF && elfZSeparateCode > 0:
+		argv = append(argv, "-Wl,-z,separate-code")
+	case ctxt.IsELF && elfZSeparateCode < 0:
+		argv = append(argv, "-Wl,-z,noseparate-code")
+	}
+	if ctxt.IsELF && elfZMaxPageSize != 0 {
+		argv = append(argv, fmt.Sprintf("-Wl,-z,max-page-size=%#x", elfZMaxPageSize))
+	}
+	if ctxt.IsELF {
+		for _, kw := range elfZKeywords {
+			if kw == "ibt" || kw == "shstk" || kw == "force-bti" || strings.HasPrefix(kw, "cet-report=") || strings.HasPrefix(kw, "bti-report=") {
+				argv = append(argv, "-Wl,-z,"+kw)
+			}
+		}
+	}
+
 	var altLinker string
 	if ctxt.IsELF && (ctxt.DynlinkingGo() || *flagBindNow) {
 		// For ELF targets, when producing dynamically linked Go code
@@ -1646,6 +1732,22 @@ func (ctxt *Link) hostlink() {
 		// times we cannot allow it to do so.
 		argv = append(argv, "-Wl,-z,now")
 	}
+	if ctxt.IsELF && *flagPackRelativeRelocs {
+		argv = append(argv, "-Wl,-z,pack-relative-relocs")
+	}
+	for _, name := range flagWrap {
+		argv = append(argv, "-Wl,--wrap="+name)
+	}
+	if ctxt.IsELF && *flagSoname != "" {
+		argv = append(argv, "-Wl,-soname="+*flagSoname)
+	}
+	if ctxt.IsELF && *flagVersionScript != "" {
+		argv = append(argv, "-Wl,--version-script="+*flagVersionScript)
+	}
+	if ctxt.IsWindows() {
+		// After the defaults above, so that they override them.
+		argv = append(argv, peDllChars.hostArgs...)
+	}
 
 	if ctxt.IsELF && ctxt.DynlinkingGo() {
 		// Do not let the host linker generate COPY relocations. These
@@ -1669,10 +1771,7 @@ func (ctxt *Link) hostlink() {
 			// If gold is not installed, gcc will silently switch
 			// back to ld.bfd. So we parse the version information
 			// and provide a useful error if gold is missing.
-			name, args := flagExtld[0], flagExtld[1:]
-			args = append(args, "-fuse-ld=gold", "-Wl,--version")
-			cmd := exec.Command(name, args...)
-			if out, err := cmd.CombinedOutput(); err == nil {
+			if out, ok := ctxt.probeExtld(ctxt.extldCommand("-fuse-ld=gold", "-Wl,--version")); ok {
 				if !bytes.Contains(out, []byte("GNU gold")) {
 					log.Fatalf("ARM64 external linker must be gold (issue #15696, 22040), but is not: %s", out)
 				}
@@ -1684,10 +1783,7 @@ func (ctxt *Link) hostlink() {
 		altLinker = "bfd"
 
 		// Provide a useful error if ld.bfd is missing.
-		name, args := flagExtld[0], flagExtld[1:]
-		args = append(args, "-fuse-ld=bfd", "-Wl,--version")
-		cmd := exec.Command(name, args...)
-		if out, err := cmd.CombinedOutput(); err == nil {
+		if out, ok := ctxt.probeExtld(ctxt.extldCommand("-fuse-ld=bfd", "-Wl,--version")); ok {
 			if !bytes.Contains(out, []byte("GNU ld")) {
 				log.Fatalf("ARM64 external linker must be ld.bfd (issue #35197), please install devel/binutils")
 			}
@@ -1715,7 +1811,14 @@ func (ctxt *Link) hostlink() {
 	argv = append(argv, outopt)
 
 	if rpath.val != "" {
-		argv = append(argv, fmt.Sprintf("-Wl,-rpath,%s", rpath.val))
+		if ctxt.IsDarwin() {
+			// ld64 takes a single directory per -rpath.
+			for _, dir := range strings.Split(rpath.val, ":") {
+				argv = append(argv, "-Wl,-rpath,"+dir)
+			}
+		} else {
+			argv = append(argv, fmt.Sprintf("-Wl,-rpath,%s", rpath.val))
+		}
 	}
 
 	if *flagInterpreter != "" {
@@ -1728,7 +1831,7 @@ func (ctxt *Link) hostlink() {
 
 	// Force global symbols to be exported for dlopen, etc.
 	if ctxt.IsELF {
-		if ctxt.DynlinkingGo() || ctxt.BuildMode == BuildModeCShared || !linkerFlagSupported(ctxt.Arch, argv[0], altLinker, "-Wl,--export-dynamic-symbol=main") {
+		if ctxt.DynlinkingGo() || ctxt.BuildMode == BuildModeCShared || !ctxt.linkerFlagSupported(altLinker, "-Wl,--export-dynamic-symbol=main") {
 			argv = append(argv, "-rdynamic")
 		} else {
 			var exports []string
@@ -1745,7 +1848,7 @@ func (ctxt *Link) hostlink() {
 	}
 
 	const unusedArguments = "-Qunused-arguments"
-	if linkerFlagSupported(ctxt.Arch, argv[0], altLinker, unusedArguments) {
+	if ctxt.linkerFlagSupported(altLinker, unusedArguments) {
 		argv = append(argv, unusedArguments)
 	}
 
@@ -1755,13 +1858,16 @@ func (ctxt *Link) hostlink() {
 		// linked binaries that are otherwise identical other than
 		// the date/time they were linked.
 		const noTimeStamp = "-Wl,--no-insert-timestamp"
-		if linkerFlagSupported(ctxt.Arch, argv[0], altLinker, noTimeStamp) {
+		if ctxt.linkerFlagSupported(altLinker, noTimeStamp) {
 			argv = append(argv, noTimeStamp)
 		}
 	}
 
-	const compressDWARF = "-Wl,--compress-debug-sections=zlib"
-	if ctxt.compressDWARF && linkerFlagSupported(ctxt.Arch, argv[0], altLinker, compressDWARF) {
+	compressDWARF := "-Wl,--compress-debug-sections=zlib"
+	if ctxt.compressZstd != 0 {
+		compressDWARF = "-Wl,--compress-debug-sections=zstd"
+	}
+	if ctxt.compressDWARF && ctxt.linkerFlagSupported(altLinker, compressDWARF) {
 		argv = append(argv, compressDWARF)
 	}
 
@@ -1772,20 +1878,21 @@ func (ctxt *Link) hostlink() {
 
 	argv = append(argv, godotopath)
 	argv = append(argv, hostObjCopyPaths...)
+	if len(flagRsrc) > 0 {
+		argv = append(argv, writeRsrcObject(ctxt))
+	}
+	argv = append(argv, ctxt.exportArgs(hostObjCopyPaths)...)
 	if ctxt.HeadType == objabi.Haix {
 		// We want to have C files after Go files to remove
 		// trampolines csects made by ld.
 		argv = append(argv, "-nostartfiles")
 		argv = append(argv, "/lib/crt0_64.o")
 
-		extld := ctxt.extld()
-		name, args := extld[0], extld[1:]
 		// Get starting files.
 		getPathFile := func(file string) string {
-			args := append(args, "-maix64", "--print-file-name="+file)
-			out, err := exec.Command(name, args...).CombinedOutput()
+			out, err := ctxt.runExtld(ctxt.extldCommand("-maix64", "--print-file-name="+file))
 			if err != nil {
-				log.Fatalf("running %s failed: %v\n%s", extld, err, out)
+				log.Fatalf("running %s failed: %v\n%s", ctxt.extld(), err, out)
 			}
 			return strings.Trim(string(out), "\n")
 		}
@@ -1845,14 +1952,17 @@ func (ctxt *Link) hostlink() {
 	// can override -rdynamic without using -static.
 	// Similarly for -Wl,--dynamic-linker.
 	checkStatic := func(arg string) {
-		if ctxt.IsELF && arg == "-static" {
+		if ctxt.IsELF && (arg == "-static" || arg == "-static-pie") {
 			for i := range argv {
 				if argv[i] == "-rdynamic" || strings.HasPrefix(argv[i], "-Wl,--dynamic-linker,") {
-					argv[i] = "-static"
+					argv[i] = arg
 				}
 			}
 		}
 	}
+	if *flagStaticPIE {
+		checkStatic("-static-pie")
+	}
 
 	for _, p := range ldflag {
 		argv = append(argv, p)
@@ -1869,7 +1979,7 @@ func (ctxt *Link) hostlink() {
 	if ctxt.BuildMode == BuildModeExe && !ctxt.linkShared && !(ctxt.IsDarwin() && ctxt.IsARM64()) {
 		// GCC uses -no-pie, clang uses -nopie.
 		for _, nopie := range []string{"-no-pie", "-nopie"} {
-			if linkerFlagSupported(ctxt.Arch, argv[0], altLinker, nopie) {
+			if ctxt.linkerFlagSupported(altLinker, nopie) {
 				argv = append(argv, nopie)
 				break
 			}
@@ -1883,13 +1993,10 @@ func (ctxt *Link) hostlink() {
 	if ctxt.HeadType == objabi.Hwindows {
 		// Determine which linker we're using. Add in the extldflags in
 		// case used has specified "-fuse-ld=...".
-		extld := ctxt.extld()
-		name, args := extld[0], extld[1:]
-		args = append(args, trimLinkerArgv(flagExtldflags)...)
-		args = append(args, "-Wl,--version")
-		cmd := exec.Command(name, args...)
+		c := ctxt.extldCommand(trimLinkerArgv(flagExtldflags)...)
+		c.args = append(c.args, "-Wl,--version")
 		usingLLD := false
-		if out, err := cmd.CombinedOutput(); err == nil {
+		if out, ok := ctxt.probeExtld(c); ok {
 			if bytes.Contains(out, []byte("LLD ")) {
 				usingLLD = true
 			}
@@ -1912,6 +2019,10 @@ func (ctxt *Link) hostlink() {
 		argv = append(argv, peimporteddlls()...)
 	}
 
+	if ctxt.IsDarwin() {
+		argv = machoDedupRpaths(argv)
+	}
+
 	argv = ctxt.passLongArgsInResponseFile(argv, altLinker)
 
 	if ctxt.Debugvlog != 0 {
@@ -1922,8 +2033,8 @@ func (ctxt *Link) hostlink() {
 		ctxt.Logf("\n")
 	}
 
-	cmd := exec.Command(argv[0], argv[1:]...)
-	out, err := cmd.CombinedOutput()
+	cmd := &extldCmd{name: argv[0], args: argv[1:]}
+	out, err := ctxt.runExtld(cmd)
 	if err != nil {
 		Exitf("running %s failed: %v\n%s\n%s", argv[0], err, cmd, out)
 	}
@@ -1978,7 +2089,9 @@ func (ctxt *Link) hostlink() {
 
 	// Helper for updating a Macho binary in some way (shared between
 	// dwarf combining and UUID update).
-	updateMachoOutFile := func(op string, updateFunc machoUpdateFunc) {
+	// parse is macho.NewFile, or machoParseFile for an update that
+	// only needs the load commands.
+	updateMachoOutFile := func(op string, parse func(io.ReaderAt) (*macho.File, error), updateFunc machoUpdateFunc) {
 		// For os.Rename to work reliably, must be in same directory as outfile.
 		rewrittenOutput := *flagOutfile + "~"
 		exef, err := os.Open(*flagOutfile)
@@ -1986,15 +2099,15 @@ func (ctxt *Link) hostlink() {
 			Exitf("%s: %s failed: %v", os.Args[0], op, err)
 		}
 		defer exef.Close()
-		exem, err := macho.NewFile(exef)
+		exem, err := parse(exef)
 		if err != nil {
 			Exitf("%s: parsing Mach-O header failed: %v", os.Args[0], err)
 		}
 		if err := updateFunc(ctxt, exef, exem, rewrittenOutput); err != nil {
+			os.Remove(rewrittenOutput)
 			Exitf("%s: %s failed: %v", os.Args[0], op, err)
 		}
-		os.Remove(*flagOutfile)
-		if err := os.Rename(rewrittenOutput, *flagOutfile); err != nil {
+		if err := machoReplaceOutput(rewrittenOutput, *flagOutfile); err != nil {
 			Exitf("%s: %v", os.Args[0], err)
 		}
 	}
@@ -2051,98 +2164,43 @@ func (ctxt *Link) hostlink() {
 		}
 		// Skip combining if `dsymutil` didn't generate a file. See #11994.
 		if _, err := os.Stat(dsym); err == nil {
-			updateMachoOutFile("combining dwarf",
+			updateMachoOutFile("combining dwarf", macho.NewFile,
 				func(ctxt *Link, exef *os.File, exem *macho.File, outexe string) error {
 					return machoCombineDwarf(ctxt, exef, exem, dsym, outexe)
 				})
 			uuidUpdated = true
 		}
 	}
-	if ctxt.IsDarwin() && !uuidUpdated && *flagBuildid != "" {
-		updateMachoOutFile("rewriting uuid",
+	if !uuidUpdated && machoShouldRewriteUuid(ctxt) {
+		updateMachoOutFile("rewriting uuid", machoParseFile,
 			func(ctxt *Link, exef *os.File, exem *macho.File, outexe string) error {
-				return machoRewriteUuid(ctxt, exef, exem, outexe)
+				return machoRewriteUuid(ctxt, exef, exem, outexe, nil)
 			})
 	}
-	if ctxt.NeedCodeSign() {
+	if ctxt.IsDarwin() {
+		if err := machoCanonicalizeFile(ctxt, *flagOutfile); err != nil {
+			Exitf("%s: post-link Mach-O update failed: %v", os.Args[0], err)
+		}
+	}
+	if ctxt.IsDarwin() && *flagAdHocSign {
+		// After every other change, which the signature must cover.
+		if err := machoAdHocSign(*flagOutfile, ctxt.IsExe() || ctxt.IsPIE()); err != nil {
+			Exitf("%s: ad hoc signing failed: %v", os.Args[0], err)
+		}
+	} else if ctxt.NeedCodeSign() {
 		err := machoCodeSign(ctxt, *flagOutfile)
 		if err != nil {
 			Exitf("%s: code signing failed: %v", os.Args[0], err)
 		}
 	}
-}
-
-// passLongArgsInResponseFile writes the arguments into a file if they
-// are very long.
-func (ctxt *Link) passLongArgsInResponseFile(argv []string, altLinker string) []string {
-	c := 0
-	for _, arg := range argv {
-		c += len(arg)
-	}
-
-	if c < sys.ExecArgLengthLimit {
-		return argv
-	}
-
-	// Only use response files if they are supported.
-	response := filepath.Join(*flagTmpdir, "response")
-	if err := os.WriteFile(response, nil, 0644); err != nil {
-		log.Fatalf("failed while testing response file: %v", err)
-	}
-	if !linkerFlagSupported(ctxt.Arch, argv[0], altLinker, "@"+response) {
-		if ctxt.Debugvlog != 0 {
-			ctxt.Logf("not using response file because linker does not support one")
+	if ctxt.IsDarwin() && *flagReproducibleMtime {
+		// Last, as any change to the file would update its times.
+		if err := machoSetOutputTimes(*flagOutfile); err != nil {
+			Exitf("%s: %v", os.Args[0], err)
 		}
-		return argv
-	}
-
-	var buf bytes.Buffer
-	for _, arg := range argv[1:] {
-		// The external linker response file supports quoted strings.
-		fmt.Fprintf(&buf, "%q\n", arg)
-	}
-	if err := os.WriteFile(response, buf.Bytes(), 0644); err != nil {
-		log.Fatalf("failed while writing response file: %v", err)
-	}
-	if ctxt.Debugvlog != 0 {
-		ctxt.Logf("response file %s contents:\n%s", response, buf.Bytes())
-	}
-	return []string{
-		argv[0],
-		"@" + response,
 	}
 }
 
-var createTrivialCOnce sync.Once
-
-func linkerFlagSupported(arch *sys.Arch, linker, altLinker, flag string) bool {
-	createTrivialCOnce.Do(func() {
-		src := filepath.Join(*flagTmpdir, "trivial.c")
-		if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0666); err != nil {
-			Errorf(nil, "WriteFile trivial.c failed: %v", err)
-		}
-	})
-
-	flags := hostlinkArchArgs(arch)
-
-	moreFlags := trimLinkerArgv(append(flagExtldflags, ldflag...))
-	flags = append(flags, moreFlags...)
-
-	if altLinker != "" {
-		flags = append(flags, "-fuse-ld="+altLinker)
-	}
-	trivialPath := filepath.Join(*flagTmpdir, "trivial.c")
-	outPath := filepath.Join(*flagTmpdir, "a.out")
-	flags = append(flags, "-o", outPath, flag, trivialPath)
-
-	cmd := exec.Command(linker, flags...)
-	cmd.Env = append([]string{"LC_ALL=C"}, os.Environ()...)
-	out, err := cmd.CombinedOutput()
-	// GCC says "unrecognized command line option ‘-no-pie’"
-	// clang says "unknown argument: '-no-pie'"
-	return err == nil && !bytes.Contains(out, []byte("unrecognized")) && !bytes.Contains(out, []byte("unknown"))
-}
-
 // trimLinkerArgv returns a new copy of argv that does not include flags
 // that are not relevant for testing whether some linker option works.
 func trimLinkerArgv(argv []string) []string {
@@ -2237,35 +2295,23 @@ func hostlinkArchArgs(arch *sys.Arch) []string {
 
 var wantHdr = objabi.HeaderString()
 
-// ldobj loads an input object. If it is a host object (an object
-// compiled by a non-Go compiler) it returns the Hostobj pointer. If
-// it is a Go object, it returns nil.
-func ldobj(ctxt *Link, f *bio.Reader, lib *sym.Library, length int64, pn string, file string) *Hostobj {
-	pkg := objabi.PathToPrefix(lib.Pkg)
-
-	eof := f.Offset() + length
-	start := f.Offset()
-	c1 := bgetc(f)
-	c2 := bgetc(f)
-	c3 := bgetc(f)
-	c4 := bgetc(f)
-	f.MustSeek(start, 0)
-
-	unit := &sym.CompilationUnit{Lib: lib}
-	lib.Units = append(lib.Units, unit)
-
+// hostObjLoader returns the function that loads a host object, an ELF,
+// Mach-O, PE or XCOFF file, that starts with the bytes c1 to c4, or nil
+// if it is not one.
+func hostObjLoader(c1, c2, c3, c4 int) func(*Link, *bio.Reader, string, int64, string) {
 	magic := uint32(c1)<<24 | uint32(c2)<<16 | uint32(c3)<<8 | uint32(c4)
 	if magic == 0x7f454c46 { // \x7F E L F
 		ldelf := func(ctxt *Link, f *bio.Reader, pkg string, length int64, pn string) {
-			textp, flags, err := loadelf.Load(ctxt.loader, ctxt.Arch, ctxt.IncVersion(), f, pkg, length, pn, ehdr.Flags)
+			textp, flags, props, err := loadelf.Load(ctxt.loader, ctxt.Arch, ctxt.IncVersion(), f, pkg, length, pn, ehdr.Flags)
 			if err != nil {
 				Errorf(nil, "%v", err)
 				return
 			}
 			ehdr.Flags = flags
+			addHostObjProperties(pn, props)
 			ctxt.Textp = append(ctxt.Textp, textp...)
 		}
-		return ldhostobj(ldelf, ctxt.HeadType, f, pkg, length, pn, file)
+		return ldelf
 	}
 
 	if magic&^1 == 0xfeedface || magic&^0x01000000 == 0xcefaedfe {
@@ -2277,7 +2323,7 @@ func ldobj(ctxt *Link, f *bio.Reader, lib *sym.Library, length int64, pn string,
 			}
 			ctxt.Textp = append(ctxt.Textp, textp...)
 		}
-		return ldhostobj(ldmacho, ctxt.HeadType, f, pkg, length, pn, file)
+		return ldmacho
 	}
 
 	switch c1<<8 | c2 {
@@ -2292,7 +2338,7 @@ func ldobj(ctxt *Link, f *bio.Reader, lib *sym.Library, length int64, pn string,
 				return
 			}
 			if len(ls.Resources) != 0 {
-				setpersrc(ctxt, ls.Resources)
+				setpersrc(ctxt, pn, ls.Resources)
 			}
 			if ls.PData != 0 {
 				sehp.pdata = append(sehp.pdata, ls.PData)
@@ -2302,7 +2348,7 @@ func ldobj(ctxt *Link, f *bio.Reader, lib *sym.Library, length int64, pn string,
 			}
 			ctxt.Textp = append(ctxt.Textp, ls.Textp...)
 		}
-		return ldhostobj(ldpe, ctxt.HeadType, f, pkg, length, pn, file)
+		return ldpe
 	}
 
 	if c1 == 0x01 && (c2 == 0xD7 || c2 == 0xF7) {
@@ -2314,7 +2360,35 @@ func ldobj(ctxt *Link, f *bio.Reader, lib *sym.Library, length int64, pn string,
 			}
 			ctxt.Textp = append(ctxt.Textp, textp...)
 		}
-		return ldhostobj(ldxcoff, ctxt.HeadType, f, pkg, length, pn, file)
+		return ldxcoff
+	}
+	return nil
+}
+
+// ldobj loads an input object. If it is a host object (an object
+// compiled by a non-Go compiler) it returns the Hostobj pointer. If
+// it is a Go object, it returns nil.
+func ldobj(ctxt *Link, f *bio.Reader, lib *sym.Library, length int64, pn string, file string) *Hostobj {
+	pkg := objabi.PathToPrefix(lib.Pkg)
+
+	eof := f.Offset() + length
+	start := f.Offset()
+	c1 := bgetc(f)
+	c2 := bgetc(f)
+	c3 := bgetc(f)
+	c4 := bgetc(f)
+	f.MustSeek(start, 0)
+
+	unit := &sym.CompilationUnit{Lib: lib}
+	lib.Units = append(lib.Units, unit)
+
+	if ld := hostObjLoader(c1, c2, c3, c4); ld != nil {
+		return ldhostobj(ld, ctxt.HeadType, f, pkg, length, pn, file)
+	}
+
+	if isBitcode(c1, c2, c3, c4) {
+		bitcodeObjs = append(bitcodeObjs, pn)
+		return ldhostobj(ldbitcode, ctxt.HeadType, f, pkg, length, pn, file)
 	}
 
 	if c1 != 'g' || c2 != 'o' || c3 != ' ' || c4 != 'o' {
@@ -2916,11 +2990,9 @@ func captureHostObj(h *Hostobj) {
 // passing the name of the tool we're interested in, such as "strip",
 // "ar", or "dsymutil", and returns the path passed back from the command.
 func (ctxt *Link) findExtLinkTool(toolname string) string {
-	var cc []string
-	cc = append(cc, ctxt.extld()...)
-	cc = append(cc, hostlinkArchArgs(ctxt.Arch)...)
-	cc = append(cc, "--print-prog-name", toolname)
-	out, err := exec.Command(cc[0], cc[1:]...).CombinedOutput()
+	c := ctxt.extldCommand(hostlinkArchArgs(ctxt.Arch)...)
+	c.args = append(c.args, "--print-prog-name", toolname)
+	out, err := ctxt.runExtld(c)
 	if err != nil {
 		Exitf("%s: finding %s failed: %v\n%s", os.Args[0], toolname, err, out)
 	}
@@ -64,6 +64,7 @@ type Link struct {
 	Loaded bool // set after all inputs have been loaded as symbols
 
 	compressDWARF bool
+	compressZstd  int // zstd level of -compressdwarf=zstd, or 0 for zlib
 
 	Libdir       []string
 	Library      []*sym.Library
@@ -77,6 +78,8 @@ type Link struct {
 
 	tramps []loader.Sym // trampolines
 
+	icfAliases map[loader.Sym][]loader.Sym // for -icf, the functions folded into each function
+
 	compUnits []*sym.CompilationUnit // DWARF compilation units
 	runtimeCU *sym.CompilationUnit   // One of the runtime CUs, the last one seen.
 
@@ -0,0 +1,267 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -json-report, which writes a JSON report that
+// attributes the size of the output to its sections, to the kinds of
+// symbol in it, to the Go packages the symbols come from and to the
+// host objects of an internal link. Unlike -linkmap, which lists every
+// symbol, the report holds totals, for size budgets to be checked
+// against.
+//
+// Each byte of a section is attributed to the symbol that holds it. A
+// symbol with sub-symbols, such as a carrier or the section symbol of a
+// host object, holds only the bytes its sub-symbols do not; the bytes
+// that no symbol holds are the padding of the section.
+
+import (
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A linkReport is the -json-report of a link.
+type linkReport struct {
+	Sections    []linkReportSection `json:"sections"`
+	Kinds       []linkReportSize    `json:"kinds"`
+	Packages    []linkReportPackage `json:"packages"`
+	HostObjects []linkReportSize    `json:"hostobjs"`
+}
+
+// A linkReportSection is a section of the output in the report.
+type linkReportSection struct {
+	Name    string `json:"name"`
+	Segment string `json:"segment"`
+	Size    uint64 `json:"size"`
+	NoBits  bool   `json:"nobits,omitempty"`
+	Symbols int    `json:"symbols"`
+	Padding uint64 `json:"padding"`
+}
+
+// A linkReportSize is the size of the symbols of a kind or host object.
+type linkReportSize struct {
+	Name    string `json:"name"`
+	Size    uint64 `json:"size"`
+	Symbols int    `json:"symbols"`
+}
+
+// A linkReportPackage is the size of the symbols of a package, in total
+// and by kind. The symbols the linker made have the package "".
+type linkReportPackage struct {
+	Name    string            `json:"name"`
+	Size    uint64            `json:"size"`
+	Symbols int               `json:"symbols"`
+	Kinds   map[string]uint64 `json:"kinds"`
+}
+
+// A linkReportSymbol is the part of a symbol that the report
+// attributes: the bytes it holds in the section with index sect.
+type linkReportSymbol struct {
+	sect    int
+	size    uint64
+	kind    string
+	pkg     string
+	hostobj string // the host object it was read from, if any
+}
+
+// linkReportKind returns the kind of the symbol s in the section sect
+// of the output for the report.
+func linkReportKind(ldr *loader.Loader, s loader.Sym, sect *sym.Section) string {
+	switch {
+	case sect.Seg == &Segdwarf:
+		return "dwarf"
+	case strings.HasSuffix(sect.Name, ".gopclntab"):
+		return "pclntab"
+	case ldr.SymType(s) == sym.STEXT:
+		return "text"
+	}
+	switch sect.Seg {
+	case &Segdata:
+		if sect.Vaddr >= Segdata.Vaddr+Segdata.Filelen {
+			return "bss"
+		}
+		return "data"
+	case &Segpdata, &Segxdata:
+		return "unwind"
+	}
+	return "rodata"
+}
+
+// linkReportSymbols returns the sections of the segments in order and
+// the part of each reachable symbol of the link that the report
+// attributes.
+func linkReportSymbols(ctxt *Link, order []*sym.Segment) ([]linkReportSection, []linkReportSymbol) {
+	ldr := ctxt.loader
+	var sections []linkReportSection
+	index := make(map[*sym.Section]int)
+	for _, seg := range order {
+		for _, sect := range seg.Sections {
+			index[sect] = len(sections)
+			sections = append(sections, linkReportSection{
+				Name:    sect.Name,
+				Segment: linkMapSegmentName(seg),
+				Size:    sect.Length,
+				NoBits:  sect.Vaddr >= seg.Vaddr+seg.Filelen,
+			})
+		}
+	}
+
+	var syms []linkReportSymbol
+	add := func(s loader.Sym) {
+		sect := ldr.SymSect(s)
+		i, ok := index[sect]
+		if !ok {
+			return
+		}
+		size := uint64(ldr.SymSize(s))
+		for sub := ldr.SubSym(s); sub != 0; sub = ldr.SubSym(sub) {
+			if n := uint64(ldr.SymSize(sub)); n < size {
+				size -= n
+			} else {
+				size = 0
+			}
+		}
+		r := linkReportSymbol{
+			sect: i,
+			size: size,
+			kind: linkReportKind(ldr, s, sect),
+			pkg:  ldr.SymPkg(s),
+		}
+		o := s
+		if outer := ldr.OuterSym(s); outer != 0 {
+			o = outer
+		}
+		for _, h := range hostobj {
+			if h.symlo <= o && o < h.symhi {
+				r.pkg, r.hostobj = h.pkg, h.pn
+				break
+			}
+		}
+		syms = append(syms, r)
+	}
+	for _, s := range ctxt.Textp {
+		if ldr.AttrReachable(s) {
+			add(s)
+		}
+	}
+	for _, s := range ctxt.datap {
+		if ldr.AttrReachable(s) {
+			add(s)
+		}
+	}
+	// The symbol that holds a compressed DWARF section is not marked
+	// reachable.
+	for i := range dwarfp {
+		for _, s := range dwarfp[i].syms {
+			add(s)
+		}
+	}
+	return sections, syms
+}
+
+// newLinkReport returns the report that attributes the sizes of sections
+// to the symbols syms.
+func newLinkReport(sections []linkReportSection, syms []linkReportSymbol) *linkReport {
+	rep := &linkReport{Sections: sections}
+	kinds := make(map[string]*linkReportSize)
+	pkgs := make(map[string]*linkReportPackage)
+	hostobjs := make(map[string]*linkReportSize)
+	held := make([]uint64, len(sections))
+	for _, s := range syms {
+		rep.Sections[s.sect].Symbols++
+		held[s.sect] += s.size
+
+		k := kinds[s.kind]
+		if k == nil {
+			k = &linkReportSize{Name: s.kind}
+			kinds[s.kind] = k
+		}
+		k.Size += s.size
+		k.Symbols++
+
+		p := pkgs[s.pkg]
+		if p == nil {
+			p = &linkReportPackage{Name: s.pkg, Kinds: make(map[string]uint64)}
+			pkgs[s.pkg] = p
+		}
+		p.Size += s.size
+		p.Symbols++
+		p.Kinds[s.kind] += s.size
+
+		if s.hostobj != "" {
+			h := hostobjs[s.hostobj]
+			if h == nil {
+				h = &linkReportSize{Name: s.hostobj}
+				hostobjs[s.hostobj] = h
+			}
+			h.Size += s.size
+			h.Symbols++
+		}
+	}
+	for i := range rep.Sections {
+		if held[i] < rep.Sections[i].Size {
+			rep.Sections[i].Padding = rep.Sections[i].Size - held[i]
+		}
+	}
+
+	rep.Kinds = []linkReportSize{}
+	for _, k := range kinds {
+		rep.Kinds = append(rep.Kinds, *k)
+	}
+	rep.Packages = []linkReportPackage{}
+	for _, p := range pkgs {
+		rep.Packages = append(rep.Packages, *p)
+	}
+	rep.HostObjects = []linkReportSize{}
+	for _, h := range hostobjs {
+		rep.HostObjects = append(rep.HostObjects, *h)
+	}
+	// Largest first.
+	sortSizes := func(sizes []linkReportSize) {
+		sort.Slice(sizes, func(i, j int) bool {
+			if sizes[i].Size != sizes[j].Size {
+				return sizes[i].Size > sizes[j].Size
+			}
+			return sizes[i].Name < sizes[j].Name
+		})
+	}
+	sortSizes(rep.Kinds)
+	sortSizes(rep.HostObjects)
+	sort.Slice(rep.Packages, func(i, j int) bool {
+		p, q := &rep.Packages[i], &rep.Packages[j]
+		if p.Size != q.Size {
+			return p.Size > q.Size
+		}
+		return p.Name < q.Name
+	})
+	return rep
+}
+
+// writeLinkReport writes rep to w.
+func writeLinkReport(w io.Writer, rep *linkReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(rep)
+}
+
+// jsonReport writes the -json-report file.
+func jsonReport(ctxt *Link, order []*sym.Segment) {
+	rep := newLinkReport(linkReportSymbols(ctxt, order))
+	f, err := os.Create(*flagJSONReport)
+	if err != nil {
+		Exitf("writing -json-report file: %v", err)
+	}
+	if err := writeLinkReport(f, rep); err != nil {
+		f.Close()
+		Exitf("writing -json-report file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		Exitf("writing -json-report file: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"encoding/json"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewLinkReport(t *testing.T) {
+	sections := []linkReportSection{
+		{Name: ".text", Segment: "text", Size: 0x40},
+		{Name: ".bss", Segment: "data", Size: 0x10, NoBits: true},
+	}
+	syms := []linkReportSymbol{
+		{sect: 0, size: 0x20, kind: "text", pkg: "main"},
+		{sect: 0, size: 0x10, kind: "text", pkg: "runtime/cgo", hostobj: "x.a(_x001.o)"},
+		{sect: 0, size: 0x8, kind: "text", pkg: "runtime/cgo", hostobj: "x.a(_x001.o)"},
+		{sect: 1, size: 0x10, kind: "bss", pkg: "main"},
+	}
+	got := newLinkReport(sections, syms)
+	want := &linkReport{
+		Sections: []linkReportSection{
+			{Name: ".text", Segment: "text", Size: 0x40, Symbols: 3, Padding: 0x8},
+			{Name: ".bss", Segment: "data", Size: 0x10, NoBits: true, Symbols: 1},
+		},
+		Kinds: []linkReportSize{
+			{Name: "text", Size: 0x38, Symbols: 3},
+			{Name: "bss", Size: 0x10, Symbols: 1},
+		},
+		Packages: []linkReportPackage{
+			{Name: "main", Size: 0x30, Symbols: 2, Kinds: map[string]uint64{"text": 0x20, "bss": 0x10}},
+			{Name: "runtime/cgo", Size: 0x18, Symbols: 2, Kinds: map[string]uint64{"text": 0x18}},
+		},
+		HostObjects: []linkReportSize{
+			{Name: "x.a(_x001.o)", Size: 0x18, Symbols: 2},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nvar x [64]int\n\nfunc main() { x[1] = 1; println(len(x)) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(dir, "report.json")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-json-report="+report, "-o", filepath.Join(dir, "a.exe"), src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep linkReport
+	if err := json.Unmarshal(data, &rep); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every byte of every section is attributed once.
+	var sections, kinds, pkgs uint64
+	for _, s := range rep.Sections {
+		sections += s.Size - s.Padding
+	}
+	for _, k := range rep.Kinds {
+		kinds += k.Size
+	}
+	for _, p := range rep.Packages {
+		pkgs += p.Size
+	}
+	if sections == 0 || kinds != sections || pkgs != sections {
+		t.Errorf("sections hold %d bytes, kinds %d and packages %d", sections, kinds, pkgs)
+	}
+
+	found := make(map[string]bool)
+	for _, k := range rep.Kinds {
+		found[k.Name] = true
+	}
+	for _, k := range []string{"text", "rodata", "pclntab", "bss"} {
+		if !found[k] {
+			t.Errorf("report has no %s", k)
+		}
+	}
+	for _, p := range rep.Packages {
+		if p.Name == "main" {
+			if p.Kinds["text"] == 0 || p.Kinds["bss"] < 64*8 {
+				t.Errorf("package main has kinds %v", p.Kinds)
+			}
+			return
+		}
+	}
+	t.Error("report has no package main")
+}
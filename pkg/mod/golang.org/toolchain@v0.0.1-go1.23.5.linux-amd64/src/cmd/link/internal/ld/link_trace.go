@@ -0,0 +1,129 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -trace, which records the wall time, allocations,
+// peak resident set size and counts of symbols and sections of each
+// phase of the link, the phases that -benchmark measures, and writes
+// them to a file: as JSON, whose fields stay the same from release to
+// release for link performance to be tracked, or as a runtime/trace
+// execution trace, in which each phase is a region, for go tool trace.
+
+import (
+	"cmd/link/internal/benchmark"
+	"encoding/json"
+	"fmt"
+	"internal/buildcfg"
+	"os"
+	"runtime/trace"
+)
+
+// linkTraceVersion is the version of the JSON of -trace, which only
+// changes when a field changes meaning or is removed.
+const linkTraceVersion = 1
+
+// A linkTrace is the JSON of -trace.
+type linkTrace struct {
+	Version   int               `json:"version"`
+	Go        string            `json:"go"`
+	GOOS      string            `json:"goos"`
+	GOARCH    string            `json:"goarch"`
+	BuildMode string            `json:"buildmode"`
+	LinkMode  string            `json:"linkmode"`
+	Output    string            `json:"output"`
+	WallNs    int64             `json:"wall_ns"`
+	PeakRSS   uint64            `json:"peak_rss_bytes,omitempty"`
+	Phases    []benchmark.Phase `json:"phases"`
+}
+
+// linkTraceFile is the -trace file of -traceformat=go, which the
+// execution trace is written to as the link runs.
+var linkTraceFile *os.File
+
+// checkTraceFormat checks the format of -traceformat.
+func checkTraceFormat(format string) error {
+	if format != "json" && format != "go" {
+		return fmt.Errorf("unknown format %q (want json or go)", format)
+	}
+	return nil
+}
+
+// startLinkTrace starts -trace. It returns the metrics bench of
+// -benchmark, or new ones if there are none, which count the symbols
+// and sections at the end of each phase.
+func startLinkTrace(ctxt *Link, bench *benchmark.Metrics) *benchmark.Metrics {
+	if err := checkTraceFormat(*flagTraceFormat); err != nil {
+		Exitf("-traceformat: %v", err)
+	}
+	if *flagTraceFormat == "go" {
+		f, err := os.Create(*flagTrace)
+		if err != nil {
+			Exitf("writing -trace file: %v", err)
+		}
+		if err := trace.Start(f); err != nil {
+			Exitf("writing -trace file: %v", err)
+		}
+		linkTraceFile = f
+	}
+	if bench == nil {
+		bench = benchmark.New(benchmark.NoGC, "")
+	}
+	bench.SetCounts(ctxt.linkTraceCounts)
+	return bench
+}
+
+// linkTraceCounts returns the counts of symbols and sections that
+// -trace records at the end of each phase.
+func (ctxt *Link) linkTraceCounts() map[string]int64 {
+	var syms, sections int
+	if ctxt.loader != nil {
+		syms = ctxt.loader.NSym()
+	}
+	for _, seg := range Segments {
+		sections += len(seg.Sections)
+	}
+	return map[string]int64{
+		"symbols":      int64(syms),
+		"text_symbols": int64(len(ctxt.Textp)),
+		"data_symbols": int64(len(ctxt.datap)),
+		"sections":     int64(sections),
+		"hostobjs":     int64(len(hostobj)),
+	}
+}
+
+// finishLinkTrace writes the -trace file of the phases of bench.
+func finishLinkTrace(ctxt *Link, bench *benchmark.Metrics) {
+	phases := bench.Phases()
+	if linkTraceFile != nil {
+		trace.Stop()
+		if err := linkTraceFile.Close(); err != nil {
+			Exitf("writing -trace file: %v", err)
+		}
+		return
+	}
+	t := linkTrace{
+		Version:   linkTraceVersion,
+		Go:        buildcfg.Version,
+		GOOS:      buildcfg.GOOS,
+		GOARCH:    buildcfg.GOARCH,
+		BuildMode: ctxt.BuildMode.String(),
+		LinkMode:  ctxt.LinkMode.String(),
+		Output:    *flagOutfile,
+		Phases:    phases,
+	}
+	for _, p := range phases {
+		t.WallNs += p.WallNs
+		if p.PeakRSS > t.PeakRSS {
+			t.PeakRSS = p.PeakRSS
+		}
+	}
+	data, err := json.MarshalIndent(t, "", "\t")
+	if err != nil {
+		Exitf("writing -trace file: %v", err)
+	}
+	if err := os.WriteFile(*flagTrace, append(data, '\n'), 0666); err != nil {
+		Exitf("writing -trace file: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"encoding/json"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLinkTrace links a program with -trace and checks the phases of
+// the JSON trace.
+func TestLinkTrace(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "trace.json")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-trace="+file, "-o", filepath.Join(dir, "x"), src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tr linkTrace
+	if err := json.Unmarshal(data, &tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Version != linkTraceVersion || tr.Go == "" || tr.WallNs <= 0 {
+		t.Errorf("version %d, go %q, wall time %d ns", tr.Version, tr.Go, tr.WallNs)
+	}
+	phases := make(map[string]int)
+	var sum int64
+	for i, p := range tr.Phases {
+		phases[p.Name] = i
+		sum += p.WallNs
+	}
+	if sum != tr.WallNs {
+		t.Errorf("wall time %d ns is not the sum %d ns of the phases", tr.WallNs, sum)
+	}
+	for _, name := range []string{"libinit", "loadlib", "deadcode", "dodata", "Asmb"} {
+		if _, ok := phases[name]; !ok {
+			t.Errorf("no phase %s", name)
+		}
+	}
+	if i, j := phases["loadlib"], phases["dodata"]; i >= j {
+		t.Errorf("phase loadlib is not before dodata")
+	}
+	last := tr.Phases[len(tr.Phases)-1]
+	for _, c := range []string{"symbols", "text_symbols", "data_symbols", "sections"} {
+		if last.Counts[c] <= 0 {
+			t.Errorf("phase %s: %s count %d", last.Name, c, last.Counts[c])
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -linkmap and -M, which write a map of the layout
+// of the output, like the one GNU ld -Map writes: each section, with
+// its address, size and file offset, and the symbols placed in it, with
+// their addresses, sizes and the package and object file they come
+// from. The map is taken after file offsets are assigned, so it shows
+// the addresses the output is written with.
+//
+// The text format has a line for each section, followed by an indented
+// line for each of its symbols in increasing order of address. The json
+// format is a single object with the same contents.
+
+import (
+	"bufio"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A linkMapSection is a section of the output in the link map.
+type linkMapSection struct {
+	Name    string `json:"name"`
+	Segment string `json:"segment"`
+	Addr    uint64 `json:"addr"`
+	Size    uint64 `json:"size"`
+	// Fileoff is the offset of the section in the output, if it has
+	// contents there, which a section such as .bss does not.
+	Fileoff uint64          `json:"fileoff"`
+	NoBits  bool            `json:"nobits,omitempty"`
+	Symbols []linkMapSymbol `json:"symbols"`
+}
+
+// A linkMapSymbol is a symbol placed in a section of the output.
+type linkMapSymbol struct {
+	Name string `json:"name"`
+	Addr uint64 `json:"addr"`
+	Size uint64 `json:"size"`
+	Type string `json:"type"`
+	// Pkg and File are the package of the symbol and the object file
+	// or archive it was read from, empty for symbols the linker made.
+	Pkg  string `json:"pkg,omitempty"`
+	File string `json:"file,omitempty"`
+}
+
+// checkLinkMapFormat returns an error if format is not one -linkmap can
+// write.
+func checkLinkMapFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+	return nil
+}
+
+// linkMapSegmentName returns the name of the segment seg for the link
+// map.
+func linkMapSegmentName(seg *sym.Segment) string {
+	switch seg {
+	case &Segtext:
+		return "text"
+	case &Segrodata:
+		return "rodata"
+	case &Segrelrodata:
+		return "relrodata"
+	case &Segdata:
+		return "data"
+	case &Segdwarf:
+		return "dwarf"
+	case &Segpdata:
+		return "pdata"
+	case &Segxdata:
+		return "xdata"
+	}
+	return ""
+}
+
+// linkMapSections returns the sections of the segments in order, with
+// the reachable symbols of the link placed in each.
+func linkMapSections(ctxt *Link, order []*sym.Segment) []linkMapSection {
+	ldr := ctxt.loader
+	var sections []linkMapSection
+	index := make(map[*sym.Section]int)
+	for _, seg := range order {
+		for _, sect := range seg.Sections {
+			index[sect] = len(sections)
+			s := linkMapSection{
+				Name:    sect.Name,
+				Segment: linkMapSegmentName(seg),
+				Addr:    sect.Vaddr,
+				Size:    sect.Length,
+				Symbols: []linkMapSymbol{},
+			}
+			if sect.Vaddr < seg.Vaddr+seg.Filelen {
+				s.Fileoff = seg.Fileoff + sect.Vaddr - seg.Vaddr
+			} else {
+				s.NoBits = true
+			}
+			sections = append(sections, s)
+		}
+	}
+
+	add := func(s loader.Sym) {
+		if !ldr.AttrReachable(s) {
+			return
+		}
+		i, ok := index[ldr.SymSect(s)]
+		if !ok {
+			return
+		}
+		m := linkMapSymbol{
+			Name: ldr.SymName(s),
+			Addr: uint64(ldr.SymValue(s)),
+			Size: uint64(ldr.SymSize(s)),
+			Type: ldr.SymType(s).String(),
+			Pkg:  ldr.SymPkg(s),
+		}
+		if unit := ldr.SymUnit(s); unit != nil && unit.Lib != nil {
+			m.File = unit.Lib.File
+		}
+		sections[i].Symbols = append(sections[i].Symbols, m)
+	}
+	for _, s := range ctxt.Textp {
+		add(s)
+	}
+	for _, s := range ctxt.datap {
+		add(s)
+	}
+	for i := range dwarfp {
+		for _, s := range dwarfp[i].syms {
+			add(s)
+		}
+	}
+	for _, sect := range sections {
+		syms := sect.Symbols
+		sort.SliceStable(syms, func(i, j int) bool {
+			if syms[i].Addr != syms[j].Addr {
+				return syms[i].Addr < syms[j].Addr
+			}
+			return syms[i].Name < syms[j].Name
+		})
+	}
+	return sections
+}
+
+// writeLinkMap writes the link map of sections to w in format, text or
+// json.
+func writeLinkMap(w io.Writer, format string, sections []linkMapSection) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(struct {
+			Sections []linkMapSection `json:"sections"`
+		}{sections})
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%-24s %-18s %-10s %s\n", "Section", "Address", "Size", "File offset")
+	for _, sect := range sections {
+		off := fmt.Sprintf("%#x", sect.Fileoff)
+		if sect.NoBits {
+			off = "nobits"
+		}
+		fmt.Fprintf(bw, "%-24s 0x%016x %#-10x %s\n", sect.Name, sect.Addr, sect.Size, off)
+		for _, s := range sect.Symbols {
+			fmt.Fprintf(bw, "  0x%016x %#-10x %s", s.Addr, s.Size, s.Name)
+			switch {
+			case s.File != "":
+				fmt.Fprintf(bw, "  %s (%s)", s.Pkg, s.File)
+			case s.Pkg != "":
+				fmt.Fprintf(bw, "  %s", s.Pkg)
+			}
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// linkMap writes the link map of the output for -linkmap and -M.
+func linkMap(ctxt *Link, order []*sym.Segment) {
+	sections := linkMapSections(ctxt, order)
+	if *flagM {
+		ctxt.Bso.Flush()
+		if err := writeLinkMap(os.Stdout, "text", sections); err != nil {
+			Exitf("writing link map: %v", err)
+		}
+	}
+	if *flagLinkMap == "" {
+		return
+	}
+	f, err := os.Create(*flagLinkMap)
+	if err != nil {
+		Exitf("writing -linkmap file: %v", err)
+	}
+	if err := writeLinkMap(f, *flagLinkMapFormat, sections); err != nil {
+		f.Close()
+		Exitf("writing -linkmap file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		Exitf("writing -linkmap file: %v", err)
+	}
+}
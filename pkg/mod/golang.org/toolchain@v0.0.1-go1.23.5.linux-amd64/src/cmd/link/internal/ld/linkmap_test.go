@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"encoding/json"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteLinkMap(t *testing.T) {
+	sections := []linkMapSection{
+		{Name: ".text", Segment: "text", Addr: 0x401000, Size: 0x30, Fileoff: 0x1000, Symbols: []linkMapSymbol{
+			{Name: "runtime.text", Addr: 0x401000, Type: "STEXT"},
+			{Name: "main.main", Addr: 0x401000, Size: 0x30, Type: "STEXT", Pkg: "main", File: "/tmp/main.a"},
+		}},
+		{Name: ".bss", Segment: "data", Addr: 0x500000, Size: 0x8, NoBits: true, Symbols: []linkMapSymbol{
+			{Name: "main.x", Addr: 0x500000, Size: 0x8, Type: "SBSS", Pkg: "main"},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeLinkMap(&buf, "text", sections); err != nil {
+		t.Fatal(err)
+	}
+	want := `Section                  Address            Size       File offset
+.text                    0x0000000000401000 0x30       0x1000
+  0x0000000000401000 0x0        runtime.text
+  0x0000000000401000 0x30       main.main  main (/tmp/main.a)
+.bss                     0x0000000000500000 0x8        nobits
+  0x0000000000500000 0x8        main.x  main
+`
+	if got := buf.String(); got != want {
+		t.Errorf("text map:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	if err := writeLinkMap(&buf, "json", sections); err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Sections []linkMapSection }
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Sections, sections) {
+		t.Errorf("json map decodes to %+v, want %+v", got.Sections, sections)
+	}
+
+	if err := checkLinkMapFormat("csv"); err == nil {
+		t.Error("checkLinkMapFormat accepts csv")
+	}
+}
+
+func TestLinkMap(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nvar x [64]int\n\nfunc main() { x[1] = 1; println(len(x)) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	mapFile := filepath.Join(dir, "map.json")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmap="+mapFile+" -linkmapformat=json", "-o", filepath.Join(dir, "a.exe"), src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	data, err := os.ReadFile(mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct{ Sections []linkMapSection }
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each symbol lies within its section, and main.main and main.x
+	// are where the kinds of their symbols put them.
+	found := make(map[string]linkMapSection)
+	for _, sect := range m.Sections {
+		for _, s := range sect.Symbols {
+			if s.Addr < sect.Addr || s.Addr+s.Size > sect.Addr+sect.Size {
+				t.Errorf("%s at %#x+%#x is outside %s at %#x+%#x", s.Name, s.Addr, s.Size, sect.Name, sect.Addr, sect.Size)
+			}
+			if s.Pkg == "main" {
+				found[s.Name] = sect
+			}
+		}
+	}
+	if sect, ok := found["main.main"]; !ok || sect.Segment != "text" {
+		t.Errorf("main.main in section %+v, want one of the text segment", sect.Name)
+	}
+	if sect, ok := found["main.x"]; !ok || !sect.NoBits {
+		t.Errorf("main.x in section %q, want a nobits one", sect.Name)
+	}
+}
@@ -121,6 +121,8 @@ const (
 
 	MH_OBJECT  = 0x1
 	MH_EXECUTE = 0x2
+	MH_CORE    = 0x4
+	MH_DSYM    = 0xa
 
 	MH_NOUNDEFS = 0x1
 	MH_DYLDLINK = 0x4
@@ -189,6 +191,9 @@ const (
 	S_NON_LAZY_SYMBOL_POINTERS = 0x6
 	S_SYMBOL_STUBS             = 0x8
 	S_MOD_INIT_FUNC_POINTERS   = 0x9
+	S_GB_ZEROFILL              = 0xc
+	S_THREAD_LOCAL_ZEROFILL    = 0x12
+	SECTION_TYPE               = 0xff
 	S_ATTR_PURE_INSTRUCTIONS   = 0x80000000
 	S_ATTR_DEBUG               = 0x02000000
 	S_ATTR_SOME_INSTRUCTIONS   = 0x00000400
@@ -253,6 +258,15 @@ const (
 	BIND_SUBOPCODE_THREADED_APPLY                            = 0x01
 )
 
+const (
+	EXPORT_SYMBOL_FLAGS_KIND_REGULAR      = 0x00
+	EXPORT_SYMBOL_FLAGS_KIND_THREAD_LOCAL = 0x01
+	EXPORT_SYMBOL_FLAGS_KIND_ABSOLUTE     = 0x02
+	EXPORT_SYMBOL_FLAGS_WEAK_DEFINITION   = 0x04
+	EXPORT_SYMBOL_FLAGS_REEXPORT          = 0x08
+	EXPORT_SYMBOL_FLAGS_STUB_AND_RESOLVER = 0x10
+)
+
 const machoHeaderSize64 = 8 * 4 // size of 64-bit Mach-O header
 
 // Mach-O file writing
@@ -460,17 +474,37 @@ func (ctxt *Link) domacho() {
 		return
 	}
 
-	// Copy platform load command.
-	for _, h := range hostobj {
-		load, err := hostobjMachoPlatform(&h)
-		if err != nil {
-			Exitf("%v", err)
+	if v := machoPlatformFlag; v != nil {
+		// -platform-version overrides what the host objects declare.
+		machoPlatform = v.platform
+		machoMinOS = v.minOS
+		if *flagMachoFixups == "chained" && machoPlatform == PLATFORM_MACOS && machoMinOS < machoChainedMinOS {
+			Exitf("-machofixups=chained needs macOS 12.0 or later, but -platform-version declares %s", machoVersionString(machoMinOS))
 		}
-		if load != nil {
-			machoPlatform = load.platform
-			ml := newMachoLoad(ctxt.Arch, load.cmd.type_, uint32(len(load.cmd.data)))
-			copy(ml.data, load.cmd.data)
-			break
+		if ctxt.LinkMode == LinkInternal {
+			ml := newMachoLoad(ctxt.Arch, LC_BUILD_VERSION, 4)
+			ml.data[0] = uint32(machoPlatform)
+			ml.data[1] = v.minOS
+			ml.data[2] = v.sdk
+			ml.data[3] = 0 // ntools
+		}
+	} else {
+		// Copy platform load command.
+		for _, h := range hostobj {
+			load, err := hostobjMachoPlatform(&h)
+			if err != nil {
+				Exitf("%v", err)
+			}
+			if load != nil {
+				machoPlatform = load.platform
+				ml := newMachoLoad(ctxt.Arch, load.cmd.type_, uint32(len(load.cmd.data)))
+				copy(ml.data, load.cmd.data)
+				machoMinOS = machoPlatformMinOS(ml)
+				if *flagMachoFixups == "chained" && machoPlatform == PLATFORM_MACOS && machoMinOS < machoChainedMinOS {
+					Exitf("-machofixups=chained needs macOS 12.0 or later, but %s declares %s", h.file, machoVersionString(machoMinOS))
+				}
+				break
+			}
 		}
 	}
 	if machoPlatform == 0 {
@@ -488,6 +522,10 @@ func (ctxt *Link) domacho() {
 				// In general this can be the most recent supported macOS version.
 				version = 11<<16 | 0<<8 | 0<<0 // 11.0.0
 			}
+			if *flagMachoFixups == "chained" && version < machoChainedMinOS {
+				version = machoChainedMinOS
+			}
+			machoMinOS = version
 			ml := newMachoLoad(ctxt.Arch, LC_BUILD_VERSION, 4)
 			ml.data[0] = uint32(machoPlatform)
 			ml.data[1] = version // OS version
@@ -572,6 +610,10 @@ func (ctxt *Link) domacho() {
 			}
 		}
 	}
+
+	if len(addedSections) > 0 {
+		addSectionSyms(ctxt)
+	}
 }
 
 func machoadddynlib(lib string, linkmode LinkMode) {
@@ -659,6 +701,11 @@ func machoshbits(ctxt *Link, mseg *MachoSeg, sect *sym.Section, segname string)
 		msect.segname = "__LLVM"
 	}
 
+	// The sections of -add-section are named segment,section.
+	if seg, name, ok := strings.Cut(sect.Name, ","); ok {
+		msect.segname, msect.name = seg, name
+	}
+
 	if segname == "__DWARF" {
 		msect.flag |= S_ATTR_DEBUG
 	}
@@ -794,8 +841,10 @@ func asmbMacho(ctxt *Link) {
 	var codesigOff int64
 	if !*FlagD {
 		// must match doMachoLink below
+		s0 := ldr.SymSize(ldr.Lookup(".machofixups", 0))
 		s1 := ldr.SymSize(ldr.Lookup(".machorebase", 0))
 		s2 := ldr.SymSize(ldr.Lookup(".machobind", 0))
+		se := ldr.SymSize(ldr.Lookup(".machoexports", 0))
 		s3 := ldr.SymSize(ldr.Lookup(".machosymtab", 0))
 		s4 := ldr.SymSize(ctxt.ArchSyms.LinkEditPLT)
 		s5 := ldr.SymSize(ctxt.ArchSyms.LinkEditGOT)
@@ -805,37 +854,48 @@ func asmbMacho(ctxt *Link) {
 		if ctxt.LinkMode != LinkExternal {
 			ms := newMachoSeg("__LINKEDIT", 0)
 			ms.vaddr = uint64(Rnd(int64(Segdata.Vaddr+Segdata.Length), *FlagRound))
-			ms.vsize = uint64(s1 + s2 + s3 + s4 + s5 + s6 + s7)
+			ms.vsize = uint64(s0 + s1 + s2 + se + s3 + s4 + s5 + s6 + s7)
 			ms.fileoffset = uint64(linkoff)
 			ms.filesize = ms.vsize
 			ms.prot1 = 1
 			ms.prot2 = 1
 
-			codesigOff = linkoff + s1 + s2 + s3 + s4 + s5 + s6
+			codesigOff = linkoff + s0 + s1 + s2 + se + s3 + s4 + s5 + s6
 		}
 
 		if ctxt.LinkMode != LinkExternal && ctxt.IsPIE() {
-			ml := newMachoLoad(ctxt.Arch, LC_DYLD_INFO_ONLY, 10)
-			ml.data[0] = uint32(linkoff)      // rebase off
-			ml.data[1] = uint32(s1)           // rebase size
-			ml.data[2] = uint32(linkoff + s1) // bind off
-			ml.data[3] = uint32(s2)           // bind size
-			ml.data[4] = 0                    // weak bind off
-			ml.data[5] = 0                    // weak bind size
-			ml.data[6] = 0                    // lazy bind off
-			ml.data[7] = 0                    // lazy bind size
-			ml.data[8] = 0                    // export
-			ml.data[9] = 0                    // export size
+			if machoUseChainedFixups(ctxt) {
+				ml := newMachoLoad(ctxt.Arch, LC_DYLD_CHAINED_FIXUPS, 2)
+				ml.data[0] = uint32(linkoff) // dataoff
+				ml.data[1] = uint32(s0)      // datasize
+				if se > 0 {
+					ml := newMachoLoad(ctxt.Arch, LC_DYLD_EXPORTS_TRIE, 2)
+					ml.data[0] = uint32(linkoff + s0 + s1 + s2) // dataoff
+					ml.data[1] = uint32(se)                     // datasize
+				}
+			} else {
+				ml := newMachoLoad(ctxt.Arch, LC_DYLD_INFO_ONLY, 10)
+				ml.data[0] = uint32(linkoff)           // rebase off
+				ml.data[1] = uint32(s1)                // rebase size
+				ml.data[2] = uint32(linkoff + s1)      // bind off
+				ml.data[3] = uint32(s2)                // bind size
+				ml.data[4] = 0                         // weak bind off
+				ml.data[5] = 0                         // weak bind size
+				ml.data[6] = 0                         // lazy bind off
+				ml.data[7] = 0                         // lazy bind size
+				ml.data[8] = uint32(linkoff + s1 + s2) // export off
+				ml.data[9] = uint32(se)                // export size
+			}
 		}
 
 		ml := newMachoLoad(ctxt.Arch, LC_SYMTAB, 4)
-		ml.data[0] = uint32(linkoff + s1 + s2)                /* symoff */
-		ml.data[1] = uint32(nsortsym)                         /* nsyms */
-		ml.data[2] = uint32(linkoff + s1 + s2 + s3 + s4 + s5) /* stroff */
-		ml.data[3] = uint32(s6)                               /* strsize */
+		ml.data[0] = uint32(linkoff + s0 + s1 + s2 + se)                /* symoff */
+		ml.data[1] = uint32(nsortsym)                                   /* nsyms */
+		ml.data[2] = uint32(linkoff + s0 + s1 + s2 + se + s3 + s4 + s5) /* stroff */
+		ml.data[3] = uint32(s6)                                         /* strsize */
 
 		if ctxt.LinkMode != LinkExternal {
-			machodysymtab(ctxt, linkoff+s1+s2)
+			machodysymtab(ctxt, linkoff+s0+s1+s2+se)
 
 			ml := newMachoLoad(ctxt.Arch, LC_LOAD_DYLINKER, 6)
 			ml.data[0] = 12 /* offset to string */
@@ -881,11 +941,10 @@ func asmbMacho(ctxt *Link) {
 	// is a hash of the file content, so it must be done at last.)
 	if ctxt.IsInternal() && ctxt.NeedCodeSign() {
 		cs := ldr.Lookup(".machocodesig", 0)
-		data := ctxt.Out.Data()
-		if int64(len(data)) != codesigOff {
+		if ctxt.Out.Size() != codesigOff {
 			panic("wrong size")
 		}
-		codesign.Sign(ldr.Data(cs), bytes.NewReader(data), "a.out", codesigOff, int64(mstext.fileoffset), int64(mstext.filesize), ctxt.IsExe() || ctxt.IsPIE())
+		codesign.Sign(ldr.Data(cs), io.NewSectionReader(ctxt.Out, 0, codesigOff), "a.out", codesigOff, int64(mstext.fileoffset), int64(mstext.filesize), ctxt.IsExe() || ctxt.IsPIE())
 		ctxt.Out.SeekSet(codesigOff)
 		ctxt.Out.Write(ldr.Data(cs))
 	}
@@ -947,6 +1006,9 @@ func collectmachosyms(ctxt *Link) {
 			continue
 		}
 		addsym(s)
+		for _, a := range ctxt.icfAliases[s] {
+			addsym(a)
+		}
 	}
 
 	shouldBeInSymbolTable := func(s loader.Sym) bool {
@@ -1121,6 +1183,14 @@ func machosymtab(ctxt *Link) {
 			}
 			symtab.AddUint16(ctxt.Arch, 0) // desc
 			symtab.AddUintXX(ctxt.Arch, uint64(ldr.SymAddr(s)), ctxt.Arch.PtrSize)
+
+			if (export || ldr.AttrCgoExportDynamic(s)) && ldr.SymSect(o) != nil {
+				machoexports = append(machoexports, machoExport{
+					name:  "_" + name,
+					flags: EXPORT_SYMBOL_FLAGS_KIND_REGULAR,
+					addr:  uint64(ldr.SymAddr(s)) - (Segtext.Vaddr - uint64(HEADR)),
+				})
+			}
 		}
 	}
 }
@@ -1169,14 +1239,16 @@ func doMachoLink(ctxt *Link) int64 {
 	ldr := ctxt.loader
 
 	// write data that will be linkedit section
+	s0 := ldr.Lookup(".machofixups", 0)
 	s1 := ldr.Lookup(".machorebase", 0)
 	s2 := ldr.Lookup(".machobind", 0)
+	se := ldr.Lookup(".machoexports", 0)
 	s3 := ldr.Lookup(".machosymtab", 0)
 	s4 := ctxt.ArchSyms.LinkEditPLT
 	s5 := ctxt.ArchSyms.LinkEditGOT
 	s6 := ldr.Lookup(".machosymstr", 0)
 
-	size := ldr.SymSize(s1) + ldr.SymSize(s2) + ldr.SymSize(s3) + ldr.SymSize(s4) + ldr.SymSize(s5) + ldr.SymSize(s6)
+	size := ldr.SymSize(s0) + ldr.SymSize(s1) + ldr.SymSize(s2) + ldr.SymSize(se) + ldr.SymSize(s3) + ldr.SymSize(s4) + ldr.SymSize(s5) + ldr.SymSize(s6)
 
 	// Force the linkedit section to end on a 16-byte
 	// boundary. This allows pure (non-cgo) Go binaries
@@ -1207,8 +1279,10 @@ func doMachoLink(ctxt *Link) int64 {
 		linkoff = Rnd(int64(uint64(HEADR)+Segtext.Length), *FlagRound) + Rnd(int64(Segrelrodata.Filelen), *FlagRound) + Rnd(int64(Segdata.Filelen), *FlagRound) + Rnd(int64(Segdwarf.Filelen), *FlagRound)
 		ctxt.Out.SeekSet(linkoff)
 
+		ctxt.Out.Write(ldr.Data(s0))
 		ctxt.Out.Write(ldr.Data(s1))
 		ctxt.Out.Write(ldr.Data(s2))
+		ctxt.Out.Write(ldr.Data(se))
 		ctxt.Out.Write(ldr.Data(s3))
 		ctxt.Out.Write(ldr.Data(s4))
 		ctxt.Out.Write(ldr.Data(s5))
@@ -1400,18 +1474,46 @@ func MachoAddBind(off int64, targ loader.Sym) {
 	machobind = append(machobind, machoBindRecord{off, targ})
 }
 
-// Generate data for the dynamic linker, used in LC_DYLD_INFO_ONLY load command.
+// machoDylibOrdinal returns the ordinal of the dylib that the dynamic
+// import s is bound from.
+func machoDylibOrdinal(ldr *loader.Loader, s loader.Sym) int {
+	slib := ldr.SymDynimplib(s)
+	for i, lib := range dylib {
+		if lib == slib {
+			return i + 1
+		}
+	}
+	return BIND_SPECIAL_DYLIB_FLAT_LOOKUP // don't know where it is from
+}
+
+// Generate data for the dynamic linker, used in LC_DYLD_INFO_ONLY load command,
+// or in LC_DYLD_CHAINED_FIXUPS with -machofixups (see machoDyldChainedFixups).
 // See mach-o/loader.h, struct dyld_info_command, for the encoding.
 // e.g. https://opensource.apple.com/source/xnu/xnu-6153.81.5/EXTERNAL_HEADERS/mach-o/loader.h
 func machoDyldInfo(ctxt *Link) {
 	ldr := ctxt.loader
+	fixups := ldr.CreateSymForUpdate(".machofixups", 0)
 	rebase := ldr.CreateSymForUpdate(".machorebase", 0)
 	bind := ldr.CreateSymForUpdate(".machobind", 0)
+	exports := ldr.CreateSymForUpdate(".machoexports", 0)
 
 	if !(ctxt.IsPIE() && ctxt.IsInternal()) {
 		return
 	}
 
+	// Export trie, see macho_exports.go.
+	if trie := machoExportTrie(machoexports); len(trie) > 0 {
+		exports.AddBytes(trie)
+		sz := Rnd(exports.Size(), 8)
+		exports.Grow(sz)
+		exports.SetSize(sz)
+	}
+
+	if machoUseChainedFixups(ctxt) {
+		machoDyldChainedFixups(ctxt, fixups)
+		return
+	}
+
 	segId := func(seg *sym.Segment) uint8 {
 		switch seg {
 		case &Segtext:
@@ -1427,16 +1529,6 @@ func machoDyldInfo(ctxt *Link) {
 		panic("unknown segment")
 	}
 
-	dylibId := func(s loader.Sym) int {
-		slib := ldr.SymDynimplib(s)
-		for i, lib := range dylib {
-			if lib == slib {
-				return i + 1
-			}
-		}
-		return BIND_SPECIAL_DYLIB_FLAT_LOOKUP // don't know where it is from
-	}
-
 	// Rebase table.
 	// TODO: use more compact encoding. The encoding is stateful, and
 	// we can use delta encoding.
@@ -1466,7 +1558,7 @@ func machoDyldInfo(ctxt *Link) {
 		bind.AddUint8(BIND_OPCODE_SET_SEGMENT_AND_OFFSET_ULEB | segId(seg))
 		bind.AddUleb(off)
 
-		d := dylibId(r.targ)
+		d := machoDylibOrdinal(ldr, r.targ)
 		if d > 0 && d < 128 {
 			bind.AddUint8(BIND_OPCODE_SET_DYLIB_ORDINAL_IMM | uint8(d)&0xf)
 		} else if d >= 128 {
@@ -1487,13 +1579,6 @@ func machoDyldInfo(ctxt *Link) {
 	sz = Rnd(bind.Size(), 16) // make it 16-byte aligned, see the comment in doMachoLink
 	bind.Grow(sz)
 	bind.SetSize(sz)
-
-	// TODO: export table.
-	// The symbols names are encoded as a trie. I'm really too lazy to do that
-	// for now.
-	// Without it, the symbols are not dynamically exported, so they cannot be
-	// e.g. dlsym'd. But internal linking is not the default in that case, so
-	// it is fine.
 }
 
 // machoCodeSigSym creates and returns a symbol for code signature.
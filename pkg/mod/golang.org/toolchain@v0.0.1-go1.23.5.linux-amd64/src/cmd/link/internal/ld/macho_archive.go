@@ -0,0 +1,135 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file extends -reproducible to -buildmode=c-archive on darwin,
+// whose output is a static archive made by ar rather than a Mach-O file
+// made by the external linker. The Mach-O objects in the archive get
+// the same deterministic LC_UUID as an executable would, and the member
+// timestamps are cleared.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	arMagic     = "!<arch>\n"
+	arHdrSize   = 60
+	arDateOff   = 16 // offset of the 12-byte ar_date field in a member header
+	arDateSize  = 12
+	arSizeOff   = 48 // offset of the 10-byte ar_size field
+	arSizeSize  = 10
+	arBSDPrefix = "#1/" // BSD long name, stored at the start of the member data
+)
+
+// machoNormalizeArchive normalizes the c-archive at path in place; see
+// machoNormalizeArchiveFile.
+func machoNormalizeArchive(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	setUuid := machoHaveOutputUuid() && machoPassSelected("uuid")
+	return machoNormalizeArchiveFile(f, fi.Size(), setUuid, machoPassSelected("timestamps"))
+}
+
+// machoNormalizeArchiveFile walks the size-byte ar archive f. With
+// setUuid, it sets the LC_UUID of each Mach-O member that has one to the
+// UUID machoOutputUuid chooses for it. With zeroTimes, it sets the date
+// of each member to 0. Both leave the size and position of every member
+// unchanged, so the archive's symbol table stays valid.
+func machoNormalizeArchiveFile(f machoReadWriterAt, size int64, setUuid, zeroTimes bool) error {
+	var magic [len(arMagic)]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil || string(magic[:]) != arMagic {
+		return fmt.Errorf("not an ar archive")
+	}
+	zeroDate := []byte("0" + strings.Repeat(" ", arDateSize-1))
+	for off := int64(len(arMagic)); off < size; {
+		var hdr [arHdrSize]byte
+		if _, err := f.ReadAt(hdr[:], off); err != nil {
+			return fmt.Errorf("reading archive member header at %#x: %v", off, err)
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return fmt.Errorf("bad archive member header at %#x", off)
+		}
+		n, err := strconv.ParseInt(strings.TrimRight(string(hdr[arSizeOff:arSizeOff+arSizeSize]), " "), 10, 64)
+		if err != nil || n < 0 || off+arHdrSize+n > size {
+			return fmt.Errorf("bad size in archive member header at %#x", off)
+		}
+		name := strings.TrimRight(string(hdr[:16]), " ")
+		data, dataLen := off+arHdrSize, n
+		if strings.HasPrefix(name, arBSDPrefix) {
+			nameLen, err := strconv.ParseInt(name[len(arBSDPrefix):], 10, 64)
+			if err != nil || nameLen < 0 || nameLen > n {
+				return fmt.Errorf("bad name in archive member header at %#x", off)
+			}
+			nb := make([]byte, nameLen)
+			if _, err := f.ReadAt(nb, data); err != nil {
+				return err
+			}
+			name = string(bytes.TrimRight(nb, "\x00"))
+			data, dataLen = data+nameLen, n-nameLen
+		}
+
+		if zeroTimes && !bytes.Equal(hdr[arDateOff:arDateOff+arDateSize], zeroDate) {
+			if _, err := f.WriteAt(zeroDate, off+arDateOff); err != nil {
+				return err
+			}
+		}
+		if setUuid && machoIsObject(f, data, dataLen) {
+			if err := machoSetMemberUuid(&machoSlice{f: f, off: data, size: dataLen}); err != nil {
+				return fmt.Errorf("archive member %s: %v", name, err)
+			}
+		}
+		off += arHdrSize + n
+		off += off & 1 // members are 2-byte aligned
+	}
+	return nil
+}
+
+// machoIsObject reports whether the n bytes at off in f start with a
+// thin Mach-O header.
+func machoIsObject(f io.ReaderAt, off, n int64) bool {
+	var b [4]byte
+	if n < 4 {
+		return false
+	}
+	if _, err := f.ReadAt(b[:], off); err != nil {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(b[:]) {
+	case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe:
+		return true
+	}
+	return false
+}
+
+// machoSetMemberUuid sets the LC_UUID of the Mach-O object f, if it has
+// one.
+func machoSetMemberUuid(f machoReadWriterAt) error {
+	rw, err := newMachoRewriter(f)
+	if err != nil {
+		return err
+	}
+	if _, ok := rw.Uuid(); !ok {
+		return nil
+	}
+	uuid, err := machoOutputUuid(rw.f, rw.File())
+	if err != nil {
+		return err
+	}
+	return rw.UpdateUuid(uuid)
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+	"testing"
+)
+
+// testArMember is a member of a synthetic ar archive.
+type testArMember struct {
+	name string
+	date int
+	data []byte
+}
+
+// testArchive returns an ar archive of members, with names longer than
+// 15 bytes or containing spaces stored in the BSD style, as the darwin
+// ar does.
+func testArchive(members ...testArMember) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(arMagic)
+	for _, m := range members {
+		name, data := m.name, m.data
+		if len(name) > 15 || bytes.ContainsRune([]byte(name), ' ') {
+			long := []byte(name)
+			for len(long)%8 != 0 {
+				long = append(long, 0)
+			}
+			name = fmt.Sprintf("%s%d", arBSDPrefix, len(long))
+			data = append(long, data...)
+		}
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, m.date, 501, 20, 0644, len(data))
+		buf.Write(data)
+		if buf.Len()%2 != 0 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestMachoNormalizeArchive(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	obj := newTestMachO(testUuid)
+	obj.filetype = macho.TypeObj
+	obj.size = 0x601 // odd, so the next member is padded
+	noUuid := newTestMachO(testUuid)
+	noUuid.filetype = macho.TypeObj
+	noUuid.cmds = noUuid.cmds[:3]
+	members := []testArMember{
+		{"__.SYMDEF SORTED", 1700000000, []byte("symbol table")},
+		{"go.o", 1700000000, obj.bytes()},
+		{"000000.o", 0, noUuid.bytes()},
+		{"notes.txt", 42, []byte("\xcf\xfa\xed\xfe is Mach-O magic, but not followed by a Mach-O header")},
+	}
+	img := testArchive(members...)
+	orig := append([]byte(nil), img...)
+
+	// Without either normalization, nothing changes.
+	if err := machoNormalizeArchiveFile(testMachOBuf(img), int64(len(img)), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img, orig) {
+		t.Fatal("archive changed with nothing to do")
+	}
+
+	err := machoNormalizeArchiveFile(testMachOBuf(img), int64(len(img)), true, true)
+	if err == nil {
+		t.Fatal("normalizing a member with Mach-O magic but no Mach-O header succeeded")
+	}
+	members = members[:3]
+	img = testArchive(members...)
+	if err := machoNormalizeArchiveFile(testMachOBuf(img), int64(len(img)), true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The result is the archive of the normalized members.
+	for i := range members {
+		members[i].date = 0
+	}
+	obj.cmds[3] = obj.uuid([16]byte(uuidFromGoBuildId(*flagBuildid)))
+	members[1].data = obj.bytes()
+	if want := testArchive(members...); !bytes.Equal(img, want) {
+		t.Errorf("normalized archive is not the archive of the normalized members")
+	}
+
+	// Normalizing again changes nothing.
+	again := append([]byte(nil), img...)
+	if err := machoNormalizeArchiveFile(testMachOBuf(img), int64(len(img)), true, true); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img, again) {
+		t.Error("second normalization changed the archive")
+	}
+
+	for _, bad := range [][]byte{
+		[]byte("!<arch>"),
+		orig[:len(orig)-1],
+		append([]byte(arMagic), bytes.Repeat([]byte{' '}, arHdrSize)...),
+	} {
+		if err := machoNormalizeArchiveFile(testMachOBuf(bad), int64(len(bad)), true, true); err == nil {
+			t.Errorf("normalizing a bad archive of %d bytes succeeded", len(bad))
+		}
+	}
+}
@@ -0,0 +1,1067 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the passes run on a Mach-O file produced by the
+// external linker once it is in its final location and its LC_UUID has
+// been updated (see macho_update_uuid.go). They look for, and where
+// asked to, fix up sources of nondeterminism, and add optional
+// metadata.
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// machoLoadCmd is one load command of a Mach-O file.
+type machoLoadCmd struct {
+	Cmd    macho.LoadCmd
+	Offset int64  // file offset of the command
+	Data   []byte // the whole command, including the cmd and cmdsize fields
+}
+
+// errLoadCmdOverrun is the error for a load command that does not fit in
+// the load command region.
+var errLoadCmdOverrun = errors.New("extends past the end of the load commands")
+
+// errLoadCmdCount is the error for a header claiming more load
+// commands than is plausible.
+var errLoadCmdCount = errors.New("implausible number of load commands")
+
+// errLoadCmdSize is the error for a load command whose cmdsize is too
+// small to hold its cmd and cmdsize fields.
+var errLoadCmdSize = errors.New("bad size")
+
+// errLoadCmdShort is the error for a load command too short for the
+// fields of its type.
+var errLoadCmdShort = errors.New("too short")
+
+// errBadMagic is the error for a file that does not start with the
+// magic number of a thin Mach-O file.
+var errBadMagic = errors.New("not a Mach-O file")
+
+// machoMaxLoadCmds bounds the number of load commands of a Mach-O file.
+// Real files have at most a few hundred, one per segment and dylib.
+const machoMaxLoadCmds = 1 << 16
+
+// machoCheckHeader returns an error if the header described by exem
+// does not have the magic number of a thin Mach-O file, or claims more
+// load commands than fit, at 8 bytes each at least, in its Cmdsz bytes
+// of load commands, or more than machoMaxLoadCmds, so that a corrupt or
+// crafted header is rejected before the commands are walked.
+func machoCheckHeader(exem *macho.File) error {
+	if exem.Magic != macho.Magic32 && exem.Magic != macho.Magic64 {
+		return fmt.Errorf("%w: magic %#x", errBadMagic, exem.Magic)
+	}
+	if uint64(exem.Ncmd)*8 > uint64(exem.Cmdsz) {
+		return fmt.Errorf("%w: %d in %d bytes", errLoadCmdCount, exem.Ncmd, exem.Cmdsz)
+	}
+	if exem.Ncmd > machoMaxLoadCmds {
+		return fmt.Errorf("%w: %d, more than %d", errLoadCmdCount, exem.Ncmd, machoMaxLoadCmds)
+	}
+	return nil
+}
+
+// machoReadLoadCmds reads the load commands of f, whose header is
+// described by exem.
+func machoReadLoadCmds(f io.ReaderAt, exem *macho.File) ([]machoLoadCmd, error) {
+	if err := machoCheckHeader(exem); err != nil {
+		return nil, err
+	}
+	cmdOffset := machoHeaderSize(exem)
+	region := make([]byte, exem.Cmdsz)
+	if _, err := f.ReadAt(region, cmdOffset); err != nil {
+		return nil, fmt.Errorf("reading load commands: %w", err)
+	}
+	cmds := make([]machoLoadCmd, 0, exem.Ncmd)
+	for i, off := uint32(0), uint32(0); i < exem.Ncmd; i++ {
+		if len(region[off:]) < 8 {
+			return nil, machoLoadCmdError(i, 0, errLoadCmdOverrun)
+		}
+		cmd := macho.LoadCmd(exem.ByteOrder.Uint32(region[off:]))
+		size := exem.ByteOrder.Uint32(region[off+4:])
+		if size < 8 {
+			return nil, machoLoadCmdError(i, cmd, fmt.Errorf("%w %d", errLoadCmdSize, size))
+		}
+		if size > uint32(len(region[off:])) {
+			return nil, machoLoadCmdError(i, cmd, errLoadCmdOverrun)
+		}
+		cmds = append(cmds, machoLoadCmd{
+			Cmd:    cmd,
+			Offset: cmdOffset + int64(off),
+			Data:   region[off : off+size],
+		})
+		off += size
+	}
+	return cmds, nil
+}
+
+// machoLoadCmdNames are the names of the load commands the linker
+// knows, for error messages.
+var machoLoadCmdNames = map[macho.LoadCmd]string{
+	LC_SEGMENT:                  "LC_SEGMENT",
+	LC_SYMTAB:                   "LC_SYMTAB",
+	LC_SYMSEG:                   "LC_SYMSEG",
+	LC_THREAD:                   "LC_THREAD",
+	LC_UNIXTHREAD:               "LC_UNIXTHREAD",
+	LC_LOADFVMLIB:               "LC_LOADFVMLIB",
+	LC_IDFVMLIB:                 "LC_IDFVMLIB",
+	LC_IDENT:                    "LC_IDENT",
+	LC_FVMFILE:                  "LC_FVMFILE",
+	LC_PREPAGE:                  "LC_PREPAGE",
+	LC_DYSYMTAB:                 "LC_DYSYMTAB",
+	LC_LOAD_DYLIB:               "LC_LOAD_DYLIB",
+	LC_ID_DYLIB:                 "LC_ID_DYLIB",
+	LC_LOAD_DYLINKER:            "LC_LOAD_DYLINKER",
+	LC_ID_DYLINKER:              "LC_ID_DYLINKER",
+	LC_PREBOUND_DYLIB:           "LC_PREBOUND_DYLIB",
+	LC_ROUTINES:                 "LC_ROUTINES",
+	LC_SUB_FRAMEWORK:            "LC_SUB_FRAMEWORK",
+	LC_SUB_UMBRELLA:             "LC_SUB_UMBRELLA",
+	LC_SUB_CLIENT:               "LC_SUB_CLIENT",
+	LC_SUB_LIBRARY:              "LC_SUB_LIBRARY",
+	LC_TWOLEVEL_HINTS:           "LC_TWOLEVEL_HINTS",
+	LC_PREBIND_CKSUM:            "LC_PREBIND_CKSUM",
+	LC_LOAD_WEAK_DYLIB:          "LC_LOAD_WEAK_DYLIB",
+	LC_SEGMENT_64:               "LC_SEGMENT_64",
+	LC_ROUTINES_64:              "LC_ROUTINES_64",
+	LC_UUID:                     "LC_UUID",
+	LC_RPATH:                    "LC_RPATH",
+	LC_CODE_SIGNATURE:           "LC_CODE_SIGNATURE",
+	LC_SEGMENT_SPLIT_INFO:       "LC_SEGMENT_SPLIT_INFO",
+	LC_REEXPORT_DYLIB:           "LC_REEXPORT_DYLIB",
+	LC_LAZY_LOAD_DYLIB:          "LC_LAZY_LOAD_DYLIB",
+	LC_ENCRYPTION_INFO:          "LC_ENCRYPTION_INFO",
+	LC_DYLD_INFO:                "LC_DYLD_INFO",
+	LC_DYLD_INFO_ONLY:           "LC_DYLD_INFO_ONLY",
+	LC_LOAD_UPWARD_DYLIB:        "LC_LOAD_UPWARD_DYLIB",
+	LC_VERSION_MIN_MACOSX:       "LC_VERSION_MIN_MACOSX",
+	LC_VERSION_MIN_IPHONEOS:     "LC_VERSION_MIN_IPHONEOS",
+	LC_FUNCTION_STARTS:          "LC_FUNCTION_STARTS",
+	LC_DYLD_ENVIRONMENT:         "LC_DYLD_ENVIRONMENT",
+	LC_MAIN:                     "LC_MAIN",
+	LC_DATA_IN_CODE:             "LC_DATA_IN_CODE",
+	LC_SOURCE_VERSION:           "LC_SOURCE_VERSION",
+	LC_DYLIB_CODE_SIGN_DRS:      "LC_DYLIB_CODE_SIGN_DRS",
+	LC_ENCRYPTION_INFO_64:       "LC_ENCRYPTION_INFO_64",
+	LC_LINKER_OPTION:            "LC_LINKER_OPTION",
+	LC_LINKER_OPTIMIZATION_HINT: "LC_LINKER_OPTIMIZATION_HINT",
+	LC_VERSION_MIN_TVOS:         "LC_VERSION_MIN_TVOS",
+	LC_VERSION_MIN_WATCHOS:      "LC_VERSION_MIN_WATCHOS",
+	LC_VERSION_NOTE:             "LC_VERSION_NOTE",
+	LC_BUILD_VERSION:            "LC_BUILD_VERSION",
+	LC_DYLD_EXPORTS_TRIE:        "LC_DYLD_EXPORTS_TRIE",
+	LC_DYLD_CHAINED_FIXUPS:      "LC_DYLD_CHAINED_FIXUPS",
+}
+
+// machoLoadCmdName returns the name of the load command cmd, or its
+// value in hex if it has none.
+func machoLoadCmdName(cmd macho.LoadCmd) string {
+	if name, ok := machoLoadCmdNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#x", uint32(cmd))
+}
+
+// machoLoadCmdError returns err with the index i of the load command
+// it concerns and the type cmd of that command, or just the index when
+// the type could not be read.
+func machoLoadCmdError(i uint32, cmd macho.LoadCmd, err error) error {
+	if cmd == 0 {
+		return fmt.Errorf("load command %d: %w", i, err)
+	}
+	return fmt.Errorf("load command %d (cmd=%s): %w", i, machoLoadCmdName(cmd), err)
+}
+
+// machoIsDylibLoad reports whether cmd loads a dylib. The order of these
+// commands defines the library ordinals that two-level namespace
+// bindings refer to.
+func machoIsDylibLoad(cmd macho.LoadCmd) bool {
+	switch cmd {
+	case LC_LOAD_DYLIB, LC_LOAD_WEAK_DYLIB, LC_REEXPORT_DYLIB, LC_LAZY_LOAD_DYLIB, LC_LOAD_UPWARD_DYLIB:
+		return true
+	}
+	return false
+}
+
+// machoDylibName returns the install name recorded in a dylib command.
+func machoDylibName(order binary.ByteOrder, data []byte) (string, error) {
+	if len(data) < 24 {
+		return "", fmt.Errorf("dylib command too short (%d bytes)", len(data))
+	}
+	off := order.Uint32(data[8:])
+	if off < 24 || off >= uint32(len(data)) {
+		return "", fmt.Errorf("dylib name offset %d out of range", off)
+	}
+	name := data[off:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name), nil
+}
+
+// machoDylibOrder returns the install names of the dylibs loaded by
+// cmds, in load order, and whether that order differs from the
+// canonical (sorted) one.
+//
+// The external linker orders these commands following its inputs, so
+// their order can vary with the environment even when the set is the
+// same. We only ever report this: reordering the commands would
+// renumber library ordinals, which two-level namespace bindings use,
+// and would change the search order flat namespace lookups use.
+func machoDylibOrder(order binary.ByteOrder, cmds []machoLoadCmd) (names []string, canonical bool, err error) {
+	for _, c := range cmds {
+		if !machoIsDylibLoad(c.Cmd) {
+			continue
+		}
+		name, err := machoDylibName(order, c.Data)
+		if err != nil {
+			return nil, false, err
+		}
+		names = append(names, name)
+	}
+	return names, sort.StringsAreSorted(names), nil
+}
+
+// machoWarnings collects the warnings of the post-link Mach-O passes,
+// so that the caller, rather than the passes, decides where they go:
+// the link writes them to the -v output, and tests look at them. A nil
+// *machoWarnings discards them.
+type machoWarnings struct {
+	list []string
+}
+
+// Add adds a warning, formatted as by fmt.Sprintf.
+func (w *machoWarnings) Add(format string, args ...interface{}) {
+	if w != nil {
+		w.list = append(w.list, fmt.Sprintf(format, args...))
+	}
+}
+
+// List returns the warnings added so far, in order.
+func (w *machoWarnings) List() []string {
+	if w == nil {
+		return nil
+	}
+	return w.list
+}
+
+// machoWarnDylibOrder adds a warning to w when the dylibs loaded by f
+// are not in canonical order.
+func machoWarnDylibOrder(w *machoWarnings, f io.ReaderAt, exem *macho.File) error {
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return err
+	}
+	names, canonical, err := machoDylibOrder(exem.ByteOrder, cmds)
+	if err != nil {
+		// This is only a diagnostic; don't fail the link over it.
+		w.Add("cannot inspect dylib load order: %v", err)
+		return nil
+	}
+	if !canonical {
+		w.Add("note: dylib load order is not canonical and may vary between host links: %q", names)
+	}
+	return nil
+}
+
+// A machoPatch is one change a post-link pass makes to a Mach-O file:
+// the bytes Old at Offset become New.
+type machoPatch struct {
+	Offset   int64
+	Old, New []byte
+}
+
+// machoEdits are the changes a pass would make to a Mach-O file.
+type machoEdits struct {
+	Patches  []machoPatch
+	Truncate int64 // new size of the file, or 0 to keep it
+	// Skipped says why the pass found nothing to act on, such as
+	// "no LC_BUILD_VERSION command", or is "" if it had a target, even
+	// one already as the pass would leave it.
+	Skipped string
+}
+
+// A machoPassResult records whether a pass run on the output applied
+// or was skipped, for -reproreport and -reprostrict.
+type machoPassResult struct {
+	Pass    string
+	Skipped string // as in machoEdits
+}
+
+// reproPassResults holds the result of each post-link pass run on the
+// output, in the order they ran.
+var reproPassResults []machoPassResult
+
+// patch adds the change of the bytes at off in f to b, unless they are
+// b already.
+func (e *machoEdits) patch(f io.ReaderAt, off int64, b []byte) error {
+	old := make([]byte, len(b))
+	if _, err := f.ReadAt(old, off); err != nil {
+		return err
+	}
+	if !bytes.Equal(old, b) {
+		e.Patches = append(e.Patches, machoPatch{Offset: off, Old: old, New: b})
+	}
+	return nil
+}
+
+// Empty reports whether e changes nothing.
+func (e *machoEdits) Empty() bool {
+	return len(e.Patches) == 0 && e.Truncate == 0
+}
+
+// A machoPass is one post-link pass over a Mach-O file. plan returns
+// the changes the pass would make to the file behind rw, none if it is
+// already as the pass would leave it.
+type machoPass struct {
+	name string
+	plan func(rw *machoRewriter) (*machoEdits, error)
+}
+
+// machoPassNames are the names of the post-link passes, in the order
+// they run.
+var machoPassNames = []string{"loadorder", "uuid", "buildversion", "sourceversion", "main", "header", "timestamps", "linkedit", "label"}
+
+// reproPasses is the set of passes that -repropasses selects, or nil to
+// run every pass the other flags ask for.
+var reproPasses map[string]bool
+
+// machoParseReproPasses parses the comma-separated list of pass names
+// given to -repropasses.
+func machoParseReproPasses(s string) (map[string]bool, error) {
+	passes := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		known := false
+		for _, n := range machoPassNames {
+			known = known || n == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown pass %q (want one of %s)", name, strings.Join(machoPassNames, ", "))
+		}
+		passes[name] = true
+	}
+	return passes, nil
+}
+
+// machoPassSelected reports whether -repropasses allows the pass name
+// to run.
+func machoPassSelected(name string) bool {
+	return reproPasses == nil || reproPasses[name]
+}
+
+// machoLinkSetsUuid reports whether the link itself sets the LC_UUID of
+// the external linker's output, rather than keeping the external
+// linker's UUID (-repropasses without uuid) or leaving the rewrite to a
+// later build step (-uuidmanifest).
+func machoLinkSetsUuid() bool {
+	return machoPassSelected("uuid") && *flagUuidManifest == ""
+}
+
+// machoCheckReproBuildId returns an error if -reproducible or
+// -repropasses is given for an external link whose LC_UUID is to be
+// derived from the Go build ID, but there is no build ID. The link
+// would then leave the external linker's UUID in the output, or write
+// an all-zero one when combining DWARF, and outputs that should be
+// distinguishable by their UUIDs would not be.
+func machoCheckReproBuildId(ctxt *Link) error {
+	if !ctxt.IsDarwin() || !ctxt.IsExternal() || (!*flagReproducible && reproPasses == nil) {
+		return nil
+	}
+	if !machoLinkSetsUuid() || *flagBuildid != "" || forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64 {
+		return nil
+	}
+	return fmt.Errorf("no Go build ID to derive the Mach-O UUID from: pass -buildid, or set the UUID another way, for example with -uuidfromcode")
+}
+
+// machoPasses returns the post-link passes the command line asks for,
+// in the order they run. -repropasses implies -reproducible, and
+// restricts the list to the passes it names.
+func machoPasses() ([]machoPass, error) {
+	var passes []machoPass
+	uuidPass := machoPass{"uuid", func(rw *machoRewriter) (*machoEdits, error) {
+		uuid, err := machoOutputUuid(rw.f, rw.File())
+		if err != nil {
+			return nil, err
+		}
+		if *flagUuidInsert {
+			return machoPlanInsertUuid(rw, uuid)
+		}
+		return machoPlanUuid(rw, uuid)
+	}}
+	setUuid := machoHaveOutputUuid() && *flagUuidManifest == ""
+	if setUuid && !*flagUuidLd64 {
+		passes = append(passes, uuidPass)
+	}
+	if *flagReproducible || reproPasses != nil {
+		ts, err := machoReproTimestamp()
+		if err != nil {
+			return nil, err
+		}
+		passes = append(passes,
+			machoPass{"buildversion", machoPlanBuildVersion},
+			machoPass{"sourceversion", machoPlanSourceVersion},
+			machoPass{"main", machoPlanMain},
+			machoPass{"header", machoPlanHeader},
+			machoPass{"timestamps", func(rw *machoRewriter) (*machoEdits, error) {
+				return machoPlanTimestamps(rw, ts)
+			}},
+			machoPass{"linkedit", machoPlanLinkeditPadding})
+	}
+	if *flagUuidLabel != "" {
+		label := *flagUuidLabel
+		passes = append(passes, machoPass{"label", func(rw *machoRewriter) (*machoEdits, error) {
+			return machoPlanLabelNote(rw, label)
+		}})
+	}
+	if *flagReproOrder {
+		// -uuidinsert and -uuidlabel append their commands to the end
+		// of the load commands, so sorting before them would leave
+		// those for the next canonicalization of the output to move.
+		passes = append(passes, machoPass{"loadorder", machoPlanLoadCmdOrder})
+	}
+	if setUuid && *flagUuidLd64 {
+		// The UUID is a hash of the rest of the file, so it has to be
+		// computed once every other pass is done with it.
+		passes = append(passes, uuidPass)
+	}
+	selected := passes[:0]
+	for _, p := range passes {
+		if machoPassSelected(p.name) {
+			selected = append(selected, p)
+		}
+	}
+	return selected, nil
+}
+
+// machoCanonicalizeFile runs the post-link passes the command line asks
+// for on the Mach-O file at path, in place. The passes only apply to
+// the output of the external linker, so this does nothing when linking
+// internally.
+func machoCanonicalizeFile(ctxt *Link, path string) error {
+	if !ctxt.IsDarwin() || !ctxt.IsExternal() {
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("not running post-link Mach-O passes: not linking externally\n")
+		}
+		return nil
+	}
+	passes, err := machoPasses()
+	if err != nil {
+		return err
+	}
+	if len(passes) == 0 && ctxt.Debugvlog == 0 {
+		return nil // nothing to do
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rw, err := newMachoRewriter(f)
+	if err != nil {
+		return err
+	}
+	var w *machoWarnings
+	if ctxt.Debugvlog != 0 {
+		w = new(machoWarnings)
+	}
+	err = machoCanonicalize(ctxt, rw, passes, w)
+	for _, msg := range w.List() {
+		ctxt.Logf("%s\n", msg)
+	}
+	return err
+}
+
+// machoCanonicalize runs passes on the file behind rw. It first checks
+// whether any pass has work to do, so that processing a file that is
+// already canonical (for example, one this function wrote) reads it
+// but writes nothing.
+//
+// With -reprostrict it fails, before changing anything, if a pass finds
+// nothing to act on, since the output is then not canonicalized as
+// completely as asked; otherwise such a pass is a warning. The warnings
+// are added to w, which may be nil to skip the checks that only produce
+// warnings.
+func machoCanonicalize(ctxt *Link, rw *machoRewriter, passes []machoPass, w *machoWarnings) error {
+	pending := false
+	reproPassResults = nil
+	for _, p := range passes {
+		e, err := p.plan(rw)
+		if err != nil {
+			return fmt.Errorf("%s pass: %v", p.name, err)
+		}
+		pending = pending || !e.Empty()
+		reproPassResults = append(reproPassResults, machoPassResult{p.name, e.Skipped})
+	}
+	for _, r := range reproPassResults {
+		if r.Skipped == "" {
+			continue
+		}
+		if *flagReproStrict {
+			return fmt.Errorf("%s pass skipped: %s (-reprostrict)", r.Pass, r.Skipped)
+		}
+		w.Add("%s pass skipped: %s", r.Pass, r.Skipped)
+	}
+	if !pending && len(passes) > 0 && ctxt.Debugvlog != 0 {
+		ctxt.Logf("Mach-O output is already canonical\n")
+	}
+	for _, p := range passes {
+		if !pending {
+			break
+		}
+		// Plan again: an earlier pass may have moved things.
+		e, err := p.plan(rw)
+		if err == nil {
+			err = rw.Apply(e)
+		}
+		if err != nil {
+			return fmt.Errorf("%s pass: %v", p.name, err)
+		}
+		if *flagReproReport != "" {
+			reproPatches = append(reproPatches, e.Patches...)
+		}
+	}
+	if w != nil {
+		if err := machoWarnUuidSignedPage(ctxt, w, rw.f, rw.File()); err != nil {
+			return err
+		}
+		if err := machoWarnDylibOrder(w, rw.f, rw.File()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// machoReproReadiness returns a description of each field of the
+// Mach-O file f, whose header is described by exem, that can differ
+// between external links of the same program: those the -reproducible
+// passes clear, and the code signature, whose hashes change when they
+// do. It only reads f, so it can be run beforehand to see what the
+// passes will have to fix. The LC_UUID, which the link sets whether or
+// not -reproducible is given, is not reported.
+func machoReproReadiness(exem *macho.File, f *os.File) ([]string, error) {
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := machoReproTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	order := exem.ByteOrder
+	var hazards []string
+	if exem.Magic == macho.Magic64 {
+		var reserved [4]byte
+		if _, err := f.ReadAt(reserved[:], machoHeaderSize(exem)-4); err != nil {
+			return nil, err
+		}
+		if v := order.Uint32(reserved[:]); v != 0 {
+			hazards = append(hazards, fmt.Sprintf("Mach-O header has reserved field %#x", v))
+		}
+	}
+	for _, c := range cmds {
+		switch {
+		case c.Cmd == LC_SOURCE_VERSION:
+			if len(c.Data) < 16 {
+				return nil, fmt.Errorf("LC_SOURCE_VERSION at %#x too short (%d bytes)", c.Offset, len(c.Data))
+			}
+			// A.B.C.D.E packed as a24.b10.c10.d10.e10.
+			if v := order.Uint64(c.Data[8:]); v != 0 {
+				hazards = append(hazards, fmt.Sprintf("LC_SOURCE_VERSION records source version %d.%d.%d.%d.%d",
+					v>>40, v>>30&0x3ff, v>>20&0x3ff, v>>10&0x3ff, v&0x3ff))
+			}
+		case c.Cmd == LC_BUILD_VERSION:
+			if len(c.Data) < 24 {
+				return nil, fmt.Errorf("LC_BUILD_VERSION at %#x too short (%d bytes)", c.Offset, len(c.Data))
+			}
+			ntools := order.Uint32(c.Data[20:])
+			if uint64(ntools) > uint64(len(c.Data)-24)/8 {
+				return nil, fmt.Errorf("LC_BUILD_VERSION at %#x has %d tools, too many for %d bytes", c.Offset, ntools, len(c.Data))
+			}
+			for i := 0; i < int(ntools); i++ {
+				tool := c.Data[24+8*i:]
+				if v := order.Uint32(tool[4:]); v != 0 {
+					hazards = append(hazards, fmt.Sprintf("LC_BUILD_VERSION records version %#x of tool %d", v, order.Uint32(tool)))
+				}
+			}
+		case c.Cmd == LC_MAIN:
+			if len(c.Data) < 24 {
+				return nil, fmt.Errorf("LC_MAIN at %#x too short (%d bytes)", c.Offset, len(c.Data))
+			}
+			if pad := c.Data[24:]; bytes.Count(pad, []byte{0}) != len(pad) {
+				hazards = append(hazards, fmt.Sprintf("LC_MAIN has %d bytes of padding that are not zero", len(c.Data)-24))
+			}
+		case machoIsDylibLoad(c.Cmd) || c.Cmd == LC_ID_DYLIB:
+			name, err := machoDylibName(order, c.Data)
+			if err != nil {
+				return nil, err
+			}
+			if v := order.Uint32(c.Data[12:]); v != ts {
+				hazards = append(hazards, fmt.Sprintf("dylib command for %s records timestamp %d", name, v))
+			}
+		case c.Cmd == LC_CODE_SIGNATURE:
+			hazards = append(hazards, "LC_CODE_SIGNATURE present: its hashes change with any other field")
+		}
+	}
+	e, err := machoPlanLinkeditPadding(&machoRewriter{f: f, exem: exem})
+	if err != nil {
+		return nil, err
+	}
+	if e.Truncate != 0 {
+		seg := exem.Segment("__LINKEDIT")
+		hazards = append(hazards, fmt.Sprintf("__LINKEDIT ends with %d bytes of zero padding", int64(seg.Offset+seg.Filesz)-e.Truncate))
+	}
+	return hazards, nil
+}
+
+// machoPlanUuid returns the change that sets the LC_UUID payload of the
+// file behind rw to uuid, if it has an LC_UUID command.
+func machoPlanUuid(rw *machoRewriter, uuid []byte) (*machoEdits, error) {
+	cmds, err := machoReadLoadCmds(rw.f, rw.File())
+	if err != nil {
+		return nil, err
+	}
+	e := new(machoEdits)
+	for _, c := range cmds {
+		if c.Cmd == LC_UUID {
+			if len(c.Data) < 24 {
+				return nil, fmt.Errorf("LC_UUID at %#x %w (%d bytes)", c.Offset, errLoadCmdShort, len(c.Data))
+			}
+			if err := machoCheckNotEncrypted(rw.File().ByteOrder, cmds, c.Offset+8, 16); err != nil {
+				return nil, err
+			}
+			return e, e.patch(rw.f, c.Offset+8, uuid[:16])
+		}
+	}
+	e.Skipped = "no LC_UUID command"
+	return e, nil
+}
+
+// machoPlanInsertUuid is machoPlanUuid for -uuidinsert: if the file
+// behind rw has no LC_UUID command, as when ld64 is passed -no_uuid, it
+// returns the changes that append one with payload uuid to the load
+// commands, in the header padding. Making room by moving the section
+// data that follows the load commands is not attempted, so a file with
+// too little padding, down to none at all, is an error that leaves it
+// unchanged.
+func machoPlanInsertUuid(rw *machoRewriter, uuid []byte) (*machoEdits, error) {
+	exem := rw.File()
+	if _, ok := machoFileUuid(exem); ok {
+		return machoPlanUuid(rw, uuid)
+	}
+	slack, err := machoHeaderSlack(exem)
+	if err != nil {
+		return nil, err
+	}
+	cmd := uuidCmd{Cmd: LC_UUID, Len: uint32(unsafe.Sizeof(uuidCmd{}))}
+	if int64(cmd.Len) > slack {
+		return nil, fmt.Errorf("no room to add LC_UUID: need %d bytes of header padding, have %d (relink with a larger ld -headerpad)", cmd.Len, slack)
+	}
+	copy(cmd.Uuid[:], uuid)
+
+	cmdEnd := machoHeaderSize(exem) + int64(exem.Cmdsz)
+	cmds, err := machoReadLoadCmds(rw.f, exem)
+	if err != nil {
+		return nil, err
+	}
+	if err := machoCheckNotEncrypted(exem.ByteOrder, cmds, cmdEnd, int64(cmd.Len)); err != nil {
+		return nil, err
+	}
+	e := new(machoEdits)
+	var buf bytes.Buffer
+	binary.Write(&buf, exem.ByteOrder, &cmd)
+	if err := e.patch(rw.f, cmdEnd, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	var hdr bytes.Buffer
+	binary.Write(&hdr, exem.ByteOrder, []uint32{exem.Ncmd + 1, exem.Cmdsz + cmd.Len})
+	if err := e.patch(rw.f, int64(unsafe.Offsetof(exem.FileHeader.Ncmd)), hdr.Bytes()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// machoCheckNotEncrypted returns an error if the n bytes at file offset
+// off overlap the range that an LC_ENCRYPTION_INFO or
+// LC_ENCRYPTION_INFO_64 command in cmds marks as encrypted. The load
+// commands are never encrypted in a well-formed file, but writing to an
+// encrypted range would corrupt it.
+func machoCheckNotEncrypted(order binary.ByteOrder, cmds []machoLoadCmd, off, n int64) error {
+	for _, c := range cmds {
+		if c.Cmd != LC_ENCRYPTION_INFO && c.Cmd != LC_ENCRYPTION_INFO_64 {
+			continue
+		}
+		// struct encryption_info_command { cmd, cmdsize, cryptoff, cryptsize, cryptid }
+		if len(c.Data) < 20 {
+			return fmt.Errorf("encryption info at %#x too short (%d bytes)", c.Offset, len(c.Data))
+		}
+		cryptoff := int64(order.Uint32(c.Data[8:]))
+		cryptsize := int64(order.Uint32(c.Data[12:]))
+		if off < cryptoff+cryptsize && cryptoff < off+n {
+			return fmt.Errorf("%d bytes at %#x overlap the encrypted range [%#x, %#x)", n, off, cryptoff, cryptoff+cryptsize)
+		}
+	}
+	return nil
+}
+
+// machoPlanBuildVersion returns the changes that clear the versions of
+// the tools (the compiler and ld64 itself) recorded in the
+// LC_BUILD_VERSION commands of the file behind rw, which depend on the
+// host toolchain rather than on the program.
+func machoPlanBuildVersion(rw *machoRewriter) (*machoEdits, error) {
+	exem := rw.File()
+	cmds, err := machoReadLoadCmds(rw.f, exem)
+	if err != nil {
+		return nil, err
+	}
+	e := new(machoEdits)
+	found := false
+	for _, c := range cmds {
+		if c.Cmd != LC_BUILD_VERSION {
+			continue
+		}
+		// struct build_version_command { cmd, cmdsize, platform, minos, sdk, ntools }
+		// followed by ntools struct build_tool_version { tool, version }.
+		if len(c.Data) < 24 {
+			return nil, fmt.Errorf("LC_BUILD_VERSION at %#x too short (%d bytes)", c.Offset, len(c.Data))
+		}
+		ntools := exem.ByteOrder.Uint32(c.Data[20:])
+		if uint64(ntools) > uint64(len(c.Data)-24)/8 {
+			return nil, fmt.Errorf("LC_BUILD_VERSION at %#x has %d tools, too many for %d bytes", c.Offset, ntools, len(c.Data))
+		}
+		for i := int64(0); i < int64(ntools); i++ {
+			if err := e.patch(rw.f, c.Offset+24+8*i+4, make([]byte, 4)); err != nil {
+				return nil, err
+			}
+		}
+		found = true
+	}
+	if !found {
+		e.Skipped = "no LC_BUILD_VERSION command"
+	}
+	return e, nil
+}
+
+// machoPlanSourceVersion returns the change that clears the version
+// recorded in the LC_SOURCE_VERSION command of the file behind rw,
+// which ld64 takes from its -source_version option.
+func machoPlanSourceVersion(rw *machoRewriter) (*machoEdits, error) {
+	cmds, err := machoReadLoadCmds(rw.f, rw.File())
+	if err != nil {
+		return nil, err
+	}
+	e := new(machoEdits)
+	found := false
+	for _, c := range cmds {
+		if c.Cmd != LC_SOURCE_VERSION {
+			continue
+		}
+		// struct source_version_command { cmd, cmdsize; uint64 version }
+		if len(c.Data) < 16 {
+			return nil, fmt.Errorf("LC_SOURCE_VERSION at %#x too short (%d bytes)", c.Offset, len(c.Data))
+		}
+		if err := e.patch(rw.f, c.Offset+8, make([]byte, 8)); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		e.Skipped = "no LC_SOURCE_VERSION command"
+	}
+	return e, nil
+}
+
+// machoPlanMain returns the change that gives the LC_MAIN command of
+// the file behind rw its canonical encoding. The entry point offset and
+// the stack size are kept, since every value of either means something
+// different to the kernel, but the bytes past them, which a linker that
+// pads the command to a larger cmdsize may leave uninitialized, are
+// cleared. An entry point outside the file data of __TEXT is an error
+// rather than something to normalize.
+func machoPlanMain(rw *machoRewriter) (*machoEdits, error) {
+	exem := rw.File()
+	cmds, err := machoReadLoadCmds(rw.f, exem)
+	if err != nil {
+		return nil, err
+	}
+	e := new(machoEdits)
+	found := false
+	for _, c := range cmds {
+		if c.Cmd != LC_MAIN {
+			continue
+		}
+		// struct entry_point_command { cmd, cmdsize; uint64 entryoff, stacksize }
+		if len(c.Data) < 24 {
+			return nil, fmt.Errorf("LC_MAIN at %#x too short (%d bytes)", c.Offset, len(c.Data))
+		}
+		// entryoff is relative to the start of __TEXT in the file.
+		entryoff := exem.ByteOrder.Uint64(c.Data[8:])
+		if text := exem.Segment("__TEXT"); text == nil || entryoff >= text.Filesz {
+			return nil, fmt.Errorf("LC_MAIN at %#x has entry point offset %#x outside __TEXT", c.Offset, entryoff)
+		}
+		if err := e.patch(rw.f, c.Offset+24, make([]byte, len(c.Data)-24)); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		e.Skipped = "no LC_MAIN command"
+	}
+	return e, nil
+}
+
+// machoPlanHeader returns the change that clears the reserved field of
+// the 64-bit Mach-O header of the file behind rw, the one word of the
+// header no loader reads and so nothing checks a linker initializes.
+// The flags are left alone: every MH_ bit set by the external linker
+// changes how dyld or the kernel treats the file, and bits no MH_
+// constant names yet may be given a meaning by a later ld64. A 32-bit
+// header has no reserved field, so there is nothing to change.
+func machoPlanHeader(rw *machoRewriter) (*machoEdits, error) {
+	exem := rw.File()
+	e := new(machoEdits)
+	if exem.Magic != macho.Magic64 {
+		e.Skipped = "32-bit header has no reserved field"
+		return e, nil
+	}
+	// struct mach_header_64 { magic, cputype, cpusubtype, filetype, ncmds, sizeofcmds, flags, reserved }
+	reserved := machoHeaderSize(exem) - 4
+	return e, e.patch(rw.f, reserved, make([]byte, 4))
+}
+
+// machoCanonicalTimestamp is the timestamp -reproducible writes when
+// SOURCE_DATE_EPOCH is not set. It is the value ld64 itself records
+// for the dylibs a binary loads.
+const machoCanonicalTimestamp = 2
+
+// sourceDateEpoch returns the value of $SOURCE_DATE_EPOCH, following
+// https://reproducible-builds.org/specs/source-date-epoch/, and
+// whether it is set. Mach-O timestamps are 32 bits wide, so larger
+// values are rejected.
+func sourceDateEpoch() (uint32, bool, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return 0, false, nil
+	}
+	ts, err := strconv.ParseUint(epoch, 10, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: must be a decimal number of seconds no larger than %d", epoch, uint32(1<<32-1))
+	}
+	return uint32(ts), true, nil
+}
+
+// machoReproTimestamp returns the timestamp -reproducible writes into
+// Mach-O timestamp fields: $SOURCE_DATE_EPOCH if it is set, and
+// machoCanonicalTimestamp otherwise.
+func machoReproTimestamp() (uint32, error) {
+	ts, ok, err := sourceDateEpoch()
+	if !ok && err == nil {
+		ts = machoCanonicalTimestamp
+	}
+	return ts, err
+}
+
+// machoSetOutputTimes sets the access and modification times of the
+// file at path, as -reproduciblemtime asks: to $SOURCE_DATE_EPOCH if it
+// is set, and to the Unix epoch otherwise.
+func machoSetOutputTimes(path string) error {
+	ts, _, err := sourceDateEpoch()
+	if err != nil {
+		return err
+	}
+	t := time.Unix(int64(ts), 0)
+	return os.Chtimes(path, t, t)
+}
+
+// machoNormalizeTimestamps sets the timestamp of every dylib command
+// in the file behind rw, the only timestamps in the load commands, to
+// ts. dyld does not look at them.
+func machoNormalizeTimestamps(rw *machoRewriter, ts uint32) error {
+	e, err := machoPlanTimestamps(rw, ts)
+	if err != nil {
+		return err
+	}
+	return rw.Apply(e)
+}
+
+// machoPlanTimestamps returns the changes machoNormalizeTimestamps
+// makes.
+func machoPlanTimestamps(rw *machoRewriter, ts uint32) (*machoEdits, error) {
+	exem := rw.File()
+	cmds, err := machoReadLoadCmds(rw.f, exem)
+	if err != nil {
+		return nil, err
+	}
+	e := new(machoEdits)
+	found := false
+	for _, c := range cmds {
+		if !machoIsDylibLoad(c.Cmd) && c.Cmd != LC_ID_DYLIB {
+			continue
+		}
+		if len(c.Data) < 24 {
+			return nil, fmt.Errorf("dylib command at %#x too short (%d bytes)", c.Offset, len(c.Data))
+		}
+		// struct dylib_command { cmd, cmdsize; struct dylib { name, timestamp, ... } }
+		b := make([]byte, 4)
+		exem.ByteOrder.PutUint32(b, ts)
+		if err := e.patch(rw.f, c.Offset+12, b); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		e.Skipped = "no dylib commands"
+	}
+	return e, nil
+}
+
+// machoHeaderSlack returns the number of unused bytes between the end
+// of the load commands of exem and the first section or segment data
+// in the file, which is where new load commands can be placed without
+// moving any data. Use ld's -headerpad option to make room.
+//
+// Unusual linker configurations can produce more than one executable
+// segment, or put another segment's data ahead of __text, so this
+// considers every section of every segment rather than assuming that
+// __text comes first.
+func machoHeaderSlack(exem *macho.File) (int64, error) {
+	cmdOffset := machoHeaderSize(exem)
+	cmdEnd := cmdOffset + int64(exem.Cmdsz)
+	first := int64(-1)
+	use := func(off int64) {
+		if off > 0 && (first < 0 || off < first) {
+			first = off
+		}
+	}
+	for _, l := range exem.Loads {
+		// The segment that maps the header starts at offset 0, and
+		// use skips it; any other segment's data is off limits even
+		// when it has no sections.
+		if seg, ok := l.(*macho.Segment); ok && seg.Filesz > 0 {
+			use(int64(seg.Offset))
+		}
+	}
+	for _, sect := range exem.Sections {
+		switch sect.Flags & SECTION_TYPE {
+		case S_ZEROFILL, S_GB_ZEROFILL, S_THREAD_LOCAL_ZEROFILL:
+			continue // no file data
+		}
+		use(int64(sect.Offset))
+	}
+	if first < 0 {
+		return 0, fmt.Errorf("no section data in file")
+	}
+	if first < cmdEnd {
+		return 0, fmt.Errorf("load commands end at %#x, past the start of section data at %#x", cmdEnd, first)
+	}
+	return first - cmdEnd, nil
+}
+
+// machoLabelNoteOwner is the data owner of the LC_NOTE command added by
+// -uuidlabel.
+const machoLabelNoteOwner = "go uuid label"
+
+// machoNoteCmd is an LC_NOTE command. LC_VERSION_NOTE is the value
+// Apple's headers call LC_NOTE.
+type machoNoteCmd struct {
+	Cmd       macho.LoadCmd
+	Len       uint32
+	DataOwner [16]byte
+	Offset    uint64
+	Size      uint64
+}
+
+// machoAddLabelNote adds an LC_NOTE command to the file behind rw whose
+// data is label, a human-readable description of the binary such as
+// its module path and version, to go with the LC_UUID. The command is
+// appended to the load commands and the label placed right after it,
+// both in the header padding, so no other data moves.
+func machoAddLabelNote(rw *machoRewriter, label string) error {
+	e, err := machoPlanLabelNote(rw, label)
+	if err != nil {
+		return err
+	}
+	return rw.Apply(e)
+}
+
+// machoPlanLabelNote returns the changes machoAddLabelNote makes. There
+// are none if the file already carries label.
+func machoPlanLabelNote(rw *machoRewriter, label string) (*machoEdits, error) {
+	exem := rw.File()
+	e := new(machoEdits)
+	switch old, ok, err := machoReadLabelNote(rw.f, exem); {
+	case err != nil:
+		return nil, err
+	case ok && old == label:
+		return e, nil
+	case ok:
+		return nil, fmt.Errorf("file already has label %q", old)
+	}
+
+	slack, err := machoHeaderSlack(exem)
+	if err != nil {
+		return nil, err
+	}
+	note := machoNoteCmd{Cmd: LC_VERSION_NOTE, Len: uint32(unsafe.Sizeof(machoNoteCmd{}))}
+	if need := int64(note.Len) + int64(len(label)); need > slack {
+		return nil, fmt.Errorf("no room for a %d-byte label note: need %d bytes of header padding, have %d", len(label), need, slack)
+	}
+
+	cmdOffset := machoHeaderSize(exem)
+	noteOffset := cmdOffset + int64(exem.Cmdsz)
+	copy(note.DataOwner[:], machoLabelNoteOwner)
+	note.Offset = uint64(noteOffset) + uint64(note.Len)
+	note.Size = uint64(len(label))
+
+	var buf bytes.Buffer
+	binary.Write(&buf, exem.ByteOrder, &note)
+	buf.WriteString(label)
+	if err := e.patch(rw.f, noteOffset, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	var hdr bytes.Buffer
+	binary.Write(&hdr, exem.ByteOrder, []uint32{exem.Ncmd + 1, exem.Cmdsz + note.Len})
+	if err := e.patch(rw.f, int64(unsafe.Offsetof(exem.FileHeader.Ncmd)), hdr.Bytes()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// machoReadLabelNote returns the label recorded by machoAddLabelNote in
+// f, and whether there is one.
+func machoReadLabelNote(f io.ReaderAt, exem *macho.File) (string, bool, error) {
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return "", false, err
+	}
+	for _, c := range cmds {
+		if c.Cmd != LC_VERSION_NOTE || len(c.Data) < int(unsafe.Sizeof(machoNoteCmd{})) {
+			continue
+		}
+		var note machoNoteCmd
+		if err := binary.Read(bytes.NewReader(c.Data), exem.ByteOrder, &note); err != nil {
+			return "", false, err
+		}
+		if string(bytes.TrimRight(note.DataOwner[:], "\x00")) != machoLabelNoteOwner {
+			continue
+		}
+		label := make([]byte, note.Size)
+		if _, err := f.ReadAt(label, int64(note.Offset)); err != nil {
+			return "", false, err
+		}
+		return string(label), true, nil
+	}
+	return "", false, nil
+}
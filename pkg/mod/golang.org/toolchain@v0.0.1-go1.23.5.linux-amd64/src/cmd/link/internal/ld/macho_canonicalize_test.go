@@ -0,0 +1,945 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bufio"
+	"bytes"
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	"debug/macho"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dylib returns a dylib command of type cmd loading name.
+func (m *testMachO) dylib(cmd macho.LoadCmd, name string) []byte {
+	payload := make([]byte, 16, 16+len(name)+1)
+	m.order.PutUint32(payload[0:], 24) // name offset
+	m.order.PutUint32(payload[4:], 2)  // timestamp
+	m.order.PutUint32(payload[8:], 0x10000)
+	m.order.PutUint32(payload[12:], 0x10000)
+	payload = append(payload, name...)
+	payload = append(payload, 0)
+	return m.raw(cmd, payload)
+}
+
+func TestMachoReadLoadCmds(t *testing.T) {
+	m := newTestMachO(testUuid)
+	img := m.bytes()
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), parseTestMachO(t, img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != len(m.cmds) {
+		t.Fatalf("got %d commands, want %d", len(cmds), len(m.cmds))
+	}
+	off := int64(machoHeaderSize64)
+	for i, c := range cmds {
+		if c.Offset != off {
+			t.Errorf("command %d at %#x, want %#x", i, c.Offset, off)
+		}
+		if !reflect.DeepEqual(c.Data, m.cmds[i]) {
+			t.Errorf("command %d data mismatch", i)
+		}
+		off += int64(len(c.Data))
+	}
+	if cmds[3].Cmd != LC_UUID {
+		t.Errorf("command 3 is %v, want LC_UUID", cmds[3].Cmd)
+	}
+}
+
+func TestMachoDylibOrder(t *testing.T) {
+	m := newTestMachO(testUuid)
+	sorted := []string{
+		"/System/Library/Frameworks/CoreFoundation.framework/Versions/A/CoreFoundation",
+		"/System/Library/Frameworks/Security.framework/Versions/A/Security",
+		"/usr/lib/libSystem.B.dylib",
+		"/usr/lib/libresolv.9.dylib",
+	}
+	shuffled := []string{sorted[2], sorted[0], sorted[3], sorted[1]}
+	for _, tc := range []struct {
+		names     []string
+		canonical bool
+	}{
+		{sorted, true},
+		{shuffled, false},
+	} {
+		m.cmds = m.cmds[:4]
+		for i, name := range tc.names {
+			cmd := macho.LoadCmd(LC_LOAD_DYLIB)
+			if i == 1 {
+				cmd = LC_LOAD_WEAK_DYLIB
+			}
+			m.cmds = append(m.cmds, m.dylib(cmd, name))
+		}
+		img := m.bytes()
+		exem := parseTestMachO(t, img)
+		cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, canonical, err := machoDylibOrder(exem.ByteOrder, cmds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, tc.names) {
+			t.Errorf("got dylibs %q, want %q", names, tc.names)
+		}
+		if canonical != tc.canonical {
+			t.Errorf("dylibs %q: canonical = %v, want %v", names, canonical, tc.canonical)
+		}
+	}
+}
+
+func TestMachoLabelNote(t *testing.T) {
+	const label = "example.com/cmd/hello v1.2.3"
+	img := newTestMachO(testUuid).bytes()
+	exem := parseTestMachO(t, img)
+	if _, ok, err := machoReadLabelNote(testMachOBuf(img), exem); err != nil || ok {
+		t.Fatalf("machoReadLabelNote before adding = %v, %v; want no label", ok, err)
+	}
+
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machoAddLabelNote(rw, label); err != nil {
+		t.Fatal(err)
+	}
+	exem = parseTestMachO(t, img)
+	if exem.Ncmd != 5 {
+		t.Errorf("got %d load commands, want 5", exem.Ncmd)
+	}
+	got, ok, err := machoReadLabelNote(testMachOBuf(img), exem)
+	if err != nil || !ok || got != label {
+		t.Errorf("machoReadLabelNote = %q, %v, %v; want %q", got, ok, err, label)
+	}
+	if u := testReadUuid(t, exem); !reflect.DeepEqual(u, testUuid[:]) {
+		t.Errorf("UUID changed to %x", u)
+	}
+
+	// A label that does not fit in the header padding is rejected and
+	// leaves the file alone.
+	slack, err := machoHeaderSlack(exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := append([]byte(nil), img...)
+	long := string(make([]byte, slack))
+	if err := machoAddLabelNote(rw, long); err == nil {
+		t.Errorf("adding a %d-byte label succeeded with %d bytes of padding", len(long), slack)
+	}
+	if !reflect.DeepEqual(img, before) {
+		t.Errorf("failed machoAddLabelNote modified the file")
+	}
+}
+
+func TestMachoPlanInsertUuid(t *testing.T) {
+	want := [16]byte{0xba, 0x5e}
+	m := newTestMachO(testUuid)
+	segs := m.cmds[:3]
+	cmdEnd := int64(machoHeaderSize64)
+	for _, c := range segs {
+		cmdEnd += int64(len(c))
+	}
+	insert := func(slack int64) ([]byte, []byte, error) {
+		t.Helper()
+		m.cmds = segs[:len(segs):len(segs)]
+		if n := 0x400 - cmdEnd - slack; n > 0 {
+			m.cmds = append(m.cmds, m.filler(int(n)))
+		}
+		img := m.bytes()
+		exem := parseTestMachO(t, img)
+		if got, err := machoHeaderSlack(exem); err != nil || got != slack {
+			t.Fatalf("machoHeaderSlack = %d, %v, want %d", got, err, slack)
+		}
+		orig := append([]byte(nil), img...)
+		rw, err := newMachoRewriter(testMachOBuf(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := machoPlanInsertUuid(rw, want[:])
+		if err == nil {
+			err = rw.Apply(e)
+		}
+		return orig, img, err
+	}
+
+	// With room for it, the command is appended to the load commands.
+	_, img, err := insert(0x400 - cmdEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exem := parseTestMachO(t, img)
+	if exem.Ncmd != 4 || !bytes.Equal(testReadUuid(t, exem), want[:]) {
+		t.Errorf("after insertion: %d load commands, UUID %x; want 4 and %x", exem.Ncmd, testReadUuid(t, exem), want)
+	}
+	m.cmds = append(segs[:len(segs):len(segs)], m.uuid(want))
+	if !bytes.Equal(img, m.bytes()) {
+		t.Error("inserted LC_UUID is not the one the external linker would write")
+	}
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := machoPlanInsertUuid(rw, want[:]); err != nil || !e.Empty() {
+		t.Errorf("file with the LC_UUID still has changes to make: %v, %v", e, err)
+	}
+
+	// Too little padding, or none at all, is refused and leaves the
+	// file alone.
+	for _, slack := range []int64{16, 0} {
+		orig, img, err := insert(slack)
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("no room to add LC_UUID: need 24 bytes of header padding, have %d", slack)) {
+			t.Errorf("inserting with %d bytes of padding: %v, want a no room error", slack, err)
+		}
+		if !bytes.Equal(img, orig) {
+			t.Errorf("refused insertion with %d bytes of padding modified the file", slack)
+		}
+	}
+}
+
+func TestMachoHeaderSlackMultipleCodeSegments(t *testing.T) {
+	// A second executable segment whose code comes before __text in
+	// the file, and a zerofill section whose (meaningless) offset is
+	// even earlier.
+	m := newTestMachO(testUuid)
+	m.size = 0x3000
+	m.cmds = [][]byte{
+		m.segment("__TEXT", 0x100000000, 0x2000, 0, 0x2000,
+			testSect{name: "__text", seg: "__TEXT", addr: 0x100001800, size: 0x800, offset: 0x1800}),
+		m.segment("__TEXT_EXEC", 0x100002000, 0x1000, 0x1000, 0x800,
+			testSect{name: "__stubs", seg: "__TEXT_EXEC", addr: 0x100002000, size: 0x800, offset: 0x1000, flags: S_SYMBOL_STUBS | S_ATTR_PURE_INSTRUCTIONS}),
+		m.segment("__DATA", 0x100003000, 0x1000, 0, 0,
+			testSect{name: "__bss", seg: "__DATA", addr: 0x100003000, size: 0x1000, offset: 0x100, flags: S_ZEROFILL}),
+		m.uuid(testUuid),
+	}
+	cmdEnd := int64(machoHeaderSize64)
+	for _, c := range m.cmds {
+		cmdEnd += int64(len(c))
+	}
+	exem := parseTestMachO(t, m.bytes())
+	slack, err := machoHeaderSlack(exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 0x1000 - cmdEnd; slack != want {
+		t.Errorf("slack = %#x, want %#x", slack, want)
+	}
+
+	// A segment without sections counts too.
+	m.cmds = append(m.cmds, m.segment("__LINKEDIT", 0x100004000, 0x1000, uint64(cmdEnd)+0x100, 0x10))
+	slack, err = machoHeaderSlack(parseTestMachO(t, m.bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(0x100 - len(m.cmds[len(m.cmds)-1])); slack != want {
+		t.Errorf("with __LINKEDIT: slack = %#x, want %#x", slack, want)
+	}
+}
+
+func TestMachoReproTimestamp(t *testing.T) {
+	for _, tc := range []struct {
+		epoch string
+		want  uint32
+		ok    bool
+	}{
+		{"", machoCanonicalTimestamp, true},
+		{"1700000000", 1700000000, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"4294967296", 0, false},
+		{"yesterday", 0, false},
+	} {
+		t.Setenv("SOURCE_DATE_EPOCH", tc.epoch)
+		got, err := machoReproTimestamp()
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("SOURCE_DATE_EPOCH=%q: got %d, %v; want %d, ok=%v", tc.epoch, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestMachoNormalizeTimestamps(t *testing.T) {
+	for _, epoch := range []string{"", "1700000000"} {
+		t.Setenv("SOURCE_DATE_EPOCH", epoch)
+		want, err := machoReproTimestamp()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := newTestMachO(testUuid)
+		m.cmds = append(m.cmds,
+			m.dylib(LC_ID_DYLIB, "libgo.dylib"),
+			m.dylib(LC_LOAD_DYLIB, "/usr/lib/libSystem.B.dylib"),
+			m.dylib(LC_LOAD_WEAK_DYLIB, "/usr/lib/libresolv.9.dylib"))
+		for i, c := range m.cmds[4:] {
+			m.order.PutUint32(c[12:], 0x65000000+uint32(i)) // build times
+		}
+		img := m.bytes()
+		rw, err := newMachoRewriter(testMachOBuf(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := machoNormalizeTimestamps(rw, want); err != nil {
+			t.Fatal(err)
+		}
+		exem := parseTestMachO(t, img)
+		cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cmds[4:] {
+			if got := exem.ByteOrder.Uint32(c.Data[12:]); got != want {
+				t.Errorf("SOURCE_DATE_EPOCH=%q: %v timestamp = %d, want %d", epoch, c.Cmd, got, want)
+			}
+		}
+		if u := testReadUuid(t, exem); !reflect.DeepEqual(u, testUuid[:]) {
+			t.Errorf("UUID changed to %x", u)
+		}
+	}
+}
+
+func TestMachoSetOutputTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exe")
+	if err := os.WriteFile(path, newTestMachO(testUuid).bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		epoch string
+		want  time.Time
+	}{
+		{"", time.Unix(0, 0)},
+		{"1700000000", time.Unix(1700000000, 0)},
+	} {
+		t.Setenv("SOURCE_DATE_EPOCH", tc.epoch)
+		if err := machoSetOutputTimes(path); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(tc.want) {
+			t.Errorf("SOURCE_DATE_EPOCH=%q: mtime = %v, want %v", tc.epoch, fi.ModTime(), tc.want)
+		}
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "soon")
+	if err := machoSetOutputTimes(path); err == nil {
+		t.Errorf("machoSetOutputTimes succeeded with an invalid SOURCE_DATE_EPOCH")
+	}
+}
+
+// countingWriterAt counts the writes made to a Mach-O file.
+type countingWriterAt struct {
+	machoReadWriterAt
+	writes int
+}
+
+func (w *countingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.writes++
+	return w.machoReadWriterAt.WriteAt(p, off)
+}
+
+// testReproMachO returns an image with the fields -reproducible
+// normalizes: ld64's version in LC_BUILD_VERSION, an LC_SOURCE_VERSION
+// and a dylib timestamp.
+func testReproMachO() (*testMachO, []byte) {
+	m := newTestMachO(testUuid)
+	buildVersion := make([]byte, 16+8)
+	m.order.PutUint32(buildVersion[0:], uint32(PLATFORM_MACOS))
+	m.order.PutUint32(buildVersion[4:], 0xb0000)  // minos 11.0
+	m.order.PutUint32(buildVersion[8:], 0xe0000)  // sdk 14.0
+	m.order.PutUint32(buildVersion[12:], 1)       // ntools
+	m.order.PutUint32(buildVersion[16:], 3)       // TOOL_LD
+	m.order.PutUint32(buildVersion[20:], 0x3f80a) // ld64 1015.7
+	sourceVersion := make([]byte, 8)
+	m.order.PutUint64(sourceVersion, 1<<40)
+	m.cmds = append(m.cmds,
+		m.raw(LC_BUILD_VERSION, buildVersion),
+		m.raw(LC_SOURCE_VERSION, sourceVersion),
+		m.dylib(LC_LOAD_DYLIB, "/usr/lib/libSystem.B.dylib"))
+	m.order.PutUint32(m.cmds[len(m.cmds)-1][12:], 0x65000000)
+	return m, m.bytes()
+}
+
+func TestMachoCanonicalizeTwice(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old bool) { *flagReproducible = old }(*flagReproducible)
+	defer func(old string) { *flagUuidLabel = old }(*flagUuidLabel)
+	*flagBuildid = "test/buildid"
+	*flagReproducible = true
+	*flagUuidLabel = "example.com/hello v1.0.0"
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	m, img := testReproMachO()
+	passes, err := machoPasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	ctxt := &Link{Bso: bufio.NewWriter(&log), Debugvlog: 1}
+	var first []byte
+	for run := 1; run <= 2; run++ {
+		log.Reset()
+		f := &countingWriterAt{machoReadWriterAt: testMachOBuf(img)}
+		rw, err := newMachoRewriter(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := machoCanonicalize(ctxt, rw, passes, nil); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		already := strings.Contains(log.String(), "already canonical")
+		switch run {
+		case 1:
+			if f.writes == 0 || already {
+				t.Fatalf("first run: %d writes, already canonical = %v", f.writes, already)
+			}
+			first = append([]byte(nil), img...)
+		case 2:
+			if f.writes != 0 {
+				t.Errorf("second run made %d writes", f.writes)
+			}
+			if !already {
+				t.Errorf("second run did not report an already canonical file; log:\n%s", log.String())
+			}
+			if !bytes.Equal(img, first) {
+				t.Errorf("second run changed the file")
+			}
+		}
+	}
+
+	exem := parseTestMachO(t, img)
+	if got := testReadUuid(t, exem); !bytes.Equal(got, uuidFromGoBuildId(*flagBuildid)) {
+		t.Errorf("UUID = %x, want one derived from the build ID", got)
+	}
+	if label, ok, err := machoReadLabelNote(testMachOBuf(img), exem); err != nil || label != *flagUuidLabel {
+		t.Errorf("label = %q, %v, %v", label, ok, err)
+	}
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cmds {
+		switch c.Cmd {
+		case LC_BUILD_VERSION:
+			if v := m.order.Uint32(c.Data[28:]); v != 0 {
+				t.Errorf("ld version = %#x, want 0", v)
+			}
+			if sdk := m.order.Uint32(c.Data[16:]); sdk != 0xe0000 {
+				t.Errorf("sdk = %#x, want it kept", sdk)
+			}
+		case LC_SOURCE_VERSION:
+			if v := m.order.Uint64(c.Data[8:]); v != 0 {
+				t.Errorf("source version = %#x, want 0", v)
+			}
+		case LC_LOAD_DYLIB:
+			if ts := m.order.Uint32(c.Data[12:]); ts != machoCanonicalTimestamp {
+				t.Errorf("dylib timestamp = %d, want %d", ts, machoCanonicalTimestamp)
+			}
+		}
+	}
+}
+
+// TestMachoCanonicalizeIdempotent checks that canonicalizing the output
+// of a canonicalization with every pass enabled changes nothing: the
+// passes converge in one run rather than moving or rewriting what an
+// earlier run left.
+func TestMachoCanonicalizeIdempotent(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old bool) { *flagReproducible = old }(*flagReproducible)
+	defer func(old bool) { *flagReproOrder = old }(*flagReproOrder)
+	defer func(old bool) { *flagUuidInsert = old }(*flagUuidInsert)
+	defer func(old bool) { *flagUuidLd64 = old }(*flagUuidLd64)
+	defer func(old string) { *flagUuidLabel = old }(*flagUuidLabel)
+	*flagBuildid = "test/buildid"
+	*flagReproducible = true
+	*flagReproOrder = true
+	*flagUuidLabel = "example.com/hello v1.0.0"
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	// Every field a pass changes is off canonical, the commands are out
+	// of order, there is no LC_UUID for -uuidinsert, and __LINKEDIT
+	// ends with padding.
+	m := newTestMachO(testUuid)
+	segs := m.cmds[:3]
+	buildVersion := make([]byte, 16+8)
+	m.order.PutUint32(buildVersion[0:], uint32(PLATFORM_MACOS))
+	m.order.PutUint32(buildVersion[12:], 1)       // ntools
+	m.order.PutUint32(buildVersion[16:], 3)       // TOOL_LD
+	m.order.PutUint32(buildVersion[20:], 0x3f80a) // ld64 1015.7
+	sourceVersion := make([]byte, 8)
+	m.order.PutUint64(sourceVersion, 1<<40)
+	main := make([]byte, 24)
+	m.order.PutUint64(main, 0x400) // entryoff
+	main[20] = 0xcc                // padding
+	lib := m.dylib(LC_LOAD_DYLIB, "/usr/lib/libSystem.B.dylib")
+	m.order.PutUint32(lib[12:], 0x65000000)
+	m.cmds = append(append([][]byte(nil), segs...),
+		lib,
+		m.raw(LC_MAIN, main),
+		m.raw(LC_SOURCE_VERSION, sourceVersion),
+		m.symtab(0x500, 2, 0x520, 0x20),
+		m.raw(LC_BUILD_VERSION, buildVersion))
+	orig := m.bytes()
+	clear(orig[0x500:])
+	m.order.PutUint32(orig[28:], 0xdead) // header reserved field
+
+	ctxt := &Link{
+		Target: Target{HeadType: objabi.Hdarwin, LinkMode: LinkExternal},
+		Bso:    bufio.NewWriter(new(bytes.Buffer)),
+	}
+	for _, ld64 := range []bool{false, true} {
+		// -uuidinsert cannot be combined with -uuidld64, so the ld64
+		// input has an LC_UUID, out of place.
+		*flagUuidLd64 = ld64
+		*flagUuidInsert = !ld64
+		orig := orig
+		if ld64 {
+			m.cmds = append([][]byte{m.cmds[0], m.uuid(testUuid)}, m.cmds[1:]...)
+			orig = m.bytes()
+			clear(orig[0x500:])
+			m.order.PutUint32(orig[28:], 0xdead)
+		}
+		path := filepath.Join(t.TempDir(), "exe")
+		if err := os.WriteFile(path, orig, 0755); err != nil {
+			t.Fatal(err)
+		}
+		var runs [][]byte
+		for run := 1; run <= 3; run++ {
+			if err := machoCanonicalizeFile(ctxt, path); err != nil {
+				t.Fatalf("ld64=%v: run %d: %v", ld64, run, err)
+			}
+			img, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runs = append(runs, img)
+		}
+		if bytes.Equal(runs[0], orig) {
+			t.Fatalf("ld64=%v: first run changed nothing", ld64)
+		}
+		for i := 1; i < len(runs); i++ {
+			if !bytes.Equal(runs[i], runs[0]) {
+				t.Errorf("ld64=%v: run %d differs from run 1 at offset %#x", ld64, i+1, testFirstDiff(runs[0], runs[i]))
+			}
+		}
+	}
+}
+
+// testFirstDiff returns the offset of the first byte that differs
+// between a and b, or -1 if there is none.
+func testFirstDiff(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		return min(len(a), len(b))
+	}
+	return -1
+}
+
+func TestMachoReproPasses(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old bool) { *flagReproducible = old }(*flagReproducible)
+	defer func(old string) { *flagUuidLabel = old }(*flagUuidLabel)
+	defer func(old map[string]bool) { reproPasses = old }(reproPasses)
+	*flagBuildid = "test/buildid"
+	*flagReproducible = false
+	*flagUuidLabel = "example.com/hello v1.0.0"
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	for _, bad := range []string{"", "uuid,", "uuid,bogus"} {
+		if _, err := machoParseReproPasses(bad); err == nil {
+			t.Errorf("machoParseReproPasses(%q) succeeded", bad)
+		}
+	}
+	var err error
+	reproPasses, err = machoParseReproPasses("sourceversion,buildversion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passes, err := machoPasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range passes {
+		names = append(names, p.name)
+	}
+	if want := []string{"buildversion", "sourceversion"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("passes = %v, want %v", names, want)
+	}
+	ctxt := &Link{
+		Target: Target{HeadType: objabi.Hdarwin, LinkMode: LinkExternal},
+		Bso:    bufio.NewWriter(new(bytes.Buffer)),
+	}
+	if machoShouldRewriteUuid(ctxt) {
+		t.Errorf("machoShouldRewriteUuid = true without the uuid pass")
+	}
+
+	m, img := testReproMachO()
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machoCanonicalize(ctxt, rw, passes, nil); err != nil {
+		t.Fatal(err)
+	}
+	exem := parseTestMachO(t, img)
+	if got := testReadUuid(t, exem); !bytes.Equal(got, testUuid[:]) {
+		t.Errorf("UUID = %x, want the original %x", got, testUuid)
+	}
+	if _, ok, err := machoReadLabelNote(testMachOBuf(img), exem); ok || err != nil {
+		t.Errorf("label pass ran: %v, %v", ok, err)
+	}
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cmds {
+		switch c.Cmd {
+		case LC_BUILD_VERSION:
+			if v := m.order.Uint32(c.Data[28:]); v != 0 {
+				t.Errorf("ld version = %#x, want 0", v)
+			}
+		case LC_SOURCE_VERSION:
+			if v := m.order.Uint64(c.Data[8:]); v != 0 {
+				t.Errorf("source version = %#x, want 0", v)
+			}
+		case LC_LOAD_DYLIB:
+			if ts := m.order.Uint32(c.Data[12:]); ts != 0x65000000 {
+				t.Errorf("dylib timestamp = %#x, want it kept", ts)
+			}
+		}
+	}
+}
+
+func TestMachoReproStrict(t *testing.T) {
+	defer func(old bool) { *flagReproStrict = old }(*flagReproStrict)
+	defer func(old map[string]bool) { reproPasses = old }(reproPasses)
+	defer func(old []machoPassResult) { reproPassResults = old }(reproPassResults)
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	// buildversion is asked for, but the file has no LC_BUILD_VERSION.
+	var err error
+	reproPasses, err = machoParseReproPasses("sourceversion,buildversion,header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passes, err := machoPasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestMachO(testUuid)
+	source := make([]byte, 8)
+	m.order.PutUint64(source, 1<<40)
+	m.cmds = append(m.cmds, m.raw(LC_SOURCE_VERSION, source))
+	orig := m.bytes()
+	want := []machoPassResult{
+		{"buildversion", "no LC_BUILD_VERSION command"},
+		{"sourceversion", ""},
+		{"header", ""},
+	}
+	for _, strict := range []bool{false, true} {
+		*flagReproStrict = strict
+		img := append([]byte(nil), orig...)
+		rw, err := newMachoRewriter(testMachOBuf(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = machoCanonicalize(&Link{}, rw, passes, nil)
+		if !reflect.DeepEqual(reproPassResults, want) {
+			t.Errorf("strict=%v: results %+v, want %+v", strict, reproPassResults, want)
+		}
+		if !strict {
+			if err != nil {
+				t.Errorf("skipped pass failed the link without -reprostrict: %v", err)
+			}
+			if bytes.Equal(img, orig) {
+				t.Errorf("the passes that applied did not change the file")
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "buildversion pass skipped") {
+			t.Errorf("-reprostrict with a skipped pass: err = %v", err)
+		}
+		if !bytes.Equal(img, orig) {
+			t.Errorf("-reprostrict changed the file before failing")
+		}
+	}
+}
+
+func TestMachoWarnings(t *testing.T) {
+	defer func(old map[string]bool) { reproPasses = old }(reproPasses)
+	defer func(old []machoPassResult) { reproPassResults = old }(reproPassResults)
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	var err error
+	reproPasses, err = machoParseReproPasses("buildversion,timestamps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passes, err := machoPasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An ad hoc signed file with no LC_BUILD_VERSION for the
+	// buildversion pass and its dylibs out of order.
+	m := newTestMachO(testUuid)
+	m.size = 0x1000
+	m.cmds = append(m.cmds,
+		m.dylib(LC_LOAD_DYLIB, "/usr/lib/libSystem.B.dylib"),
+		m.dylib(LC_LOAD_DYLIB, "/System/Library/Frameworks/Security.framework/Versions/A/Security"))
+	img := m.signed()
+	uuidOff := int64(machoHeaderSize64)
+	for _, c := range m.cmds[:3] {
+		uuidOff += int64(len(c))
+	}
+	want := []string{
+		"buildversion pass skipped: no LC_BUILD_VERSION command",
+		fmt.Sprintf("LC_UUID at offset %#x is in code signature page 0 (page size 4096); its hash was updated", uuidOff+8),
+		`note: dylib load order is not canonical and may vary between host links: ["/usr/lib/libSystem.B.dylib" "/System/Library/Frameworks/Security.framework/Versions/A/Security"]`,
+	}
+
+	var log bytes.Buffer
+	ctxt := &Link{
+		Target: Target{Arch: sys.ArchAMD64, HeadType: objabi.Hdarwin, LinkMode: LinkExternal},
+		Bso:    bufio.NewWriter(&log),
+	}
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w machoWarnings
+	if err := machoCanonicalize(ctxt, rw, passes, &w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(w.List(), want) {
+		t.Errorf("warnings:\n%q\nwant:\n%q", w.List(), want)
+	}
+	ctxt.Bso.Flush()
+	if log.Len() != 0 {
+		t.Errorf("warnings went to the linker log:\n%s", log.String())
+	}
+
+	// Without a collector the passes still run.
+	rw, err = newMachoRewriter(testMachOBuf(m.signed()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machoCanonicalize(ctxt, rw, passes, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMachoCheckReproBuildId(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old bool) { *flagReproducible = old }(*flagReproducible)
+	defer func(old map[string]bool) { reproPasses = old }(reproPasses)
+	defer func(old [][2]string) { uuidCodeSections = old }(uuidCodeSections)
+
+	external := &Link{Target: Target{HeadType: objabi.Hdarwin, LinkMode: LinkExternal}}
+	internal := &Link{Target: Target{HeadType: objabi.Hdarwin, LinkMode: LinkInternal}}
+	for _, tc := range []struct {
+		name         string
+		ctxt         *Link
+		reproducible bool
+		passes       map[string]bool
+		buildID      string
+		codeSections [][2]string
+		wantErr      bool
+	}{
+		{name: "reproducible without build ID", ctxt: external, reproducible: true, wantErr: true},
+		{name: "repropasses without build ID", ctxt: external, passes: map[string]bool{"uuid": true}, wantErr: true},
+		{name: "reproducible with build ID", ctxt: external, reproducible: true, buildID: "test/buildid"},
+		{name: "not reproducible", ctxt: external},
+		{name: "internal linking", ctxt: internal, reproducible: true},
+		{name: "UUID left to a later step", ctxt: external, passes: map[string]bool{"timestamps": true}},
+		{name: "UUID from code", ctxt: external, reproducible: true, codeSections: [][2]string{{"__TEXT", "__text"}}},
+	} {
+		*flagReproducible = tc.reproducible
+		reproPasses = tc.passes
+		*flagBuildid = tc.buildID
+		uuidCodeSections = tc.codeSections
+		if err := machoCheckReproBuildId(tc.ctxt); (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestMachoPlanMain(t *testing.T) {
+	m := newTestMachO(testUuid)
+	segs := m.cmds
+	lcMain := func(entryoff, stacksize uint64, pad []byte) []byte {
+		b := make([]byte, 16, 16+len(pad))
+		m.order.PutUint64(b[0:], entryoff)
+		m.order.PutUint64(b[8:], stacksize)
+		return m.raw(LC_MAIN, append(b, pad...))
+	}
+	plan := func(cmd []byte) ([]byte, error) {
+		m.cmds = append(segs[:len(segs):len(segs)], cmd)
+		img := m.bytes()
+		rw, err := newMachoRewriter(testMachOBuf(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := machoPlanMain(rw)
+		if err == nil {
+			err = rw.Apply(e)
+		}
+		if err == nil {
+			if e, err := machoPlanMain(rw); err != nil || !e.Empty() {
+				t.Errorf("canonical LC_MAIN still has changes to make: %v, %v", e, err)
+			}
+		}
+		return img, err
+	}
+
+	// Padding is cleared and nothing else changes.
+	got, err := plan(lcMain(0x400, 0x100000, []byte("garbage!")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := plan(lcMain(0x400, 0x100000, make([]byte, 8)))
+	if !bytes.Equal(got, want) {
+		t.Error("LC_MAIN with garbage padding is not in canonical form")
+	}
+	// A command without padding is already canonical.
+	if got, err := plan(lcMain(0x400, 0, nil)); err != nil || !bytes.Equal(got, m.bytes()) {
+		t.Errorf("LC_MAIN without padding changed: %v", err)
+	}
+	if _, err := plan(lcMain(0x500, 0, nil)); err == nil {
+		t.Error("LC_MAIN with an entry point past __TEXT accepted")
+	}
+	if _, err := plan(m.raw(LC_MAIN, make([]byte, 8))); err == nil {
+		t.Error("truncated LC_MAIN accepted")
+	}
+}
+
+func TestMachoPlanHeader(t *testing.T) {
+	m := newTestMachO(testUuid)
+	// Flags ld64 sets, and one no MH_ constant names.
+	m.flags = macho.FlagNoUndefs | macho.FlagDyldLink | macho.FlagTwoLevel | macho.FlagPIE | 0x40000000
+	want := m.bytes()
+	img := m.bytes()
+	m.order.PutUint32(img[machoHeaderSize64-4:], 0xdeadbeef)
+
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := machoPlanHeader(rw)
+	if err == nil {
+		err = rw.Apply(e)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img, want) {
+		t.Errorf("header after the pass:\n%x\nwant:\n%x", img[:machoHeaderSize64], want[:machoHeaderSize64])
+	}
+	if flags := parseTestMachO(t, img).Flags; flags != m.flags {
+		t.Errorf("flags %#x, want %#x", flags, m.flags)
+	}
+	if e, err := machoPlanHeader(rw); err != nil || !e.Empty() {
+		t.Errorf("canonical header still has changes to make: %v, %v", e, err)
+	}
+
+	// A 32-bit header has no reserved field; the word after it is the
+	// first load command.
+	m.is32 = true
+	img = m.bytes()
+	rw, err = newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := machoPlanHeader(rw); err != nil || !e.Empty() {
+		t.Errorf("32-bit header has changes to make: %v, %v", e, err)
+	}
+}
+
+func TestMachoReproReadiness(t *testing.T) {
+	defer func(old bool) { *flagReproducible = old }(*flagReproducible)
+	*flagReproducible = true
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	readiness := func(path string) []string {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		exem, err := macho.NewFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hazards, err := machoReproReadiness(exem, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hazards
+	}
+
+	// __LINKEDIT is [0x500, 0x600), with 0xc0 bytes of padding after
+	// the symbol and string tables.
+	m, _ := testReproMachO()
+	m.cmds = append(m.cmds, m.symtab(0x500, 2, 0x520, 0x20))
+	img := m.bytes()
+	clear(img[0x500:])
+	path := filepath.Join(t.TempDir(), "exe")
+	if err := os.WriteFile(path, img, 0666); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"LC_BUILD_VERSION records version 0x3f80a of tool 3",
+		"LC_SOURCE_VERSION records source version 1.0.0.0.0",
+		"dylib command for /usr/lib/libSystem.B.dylib records timestamp 1694498816",
+		"__LINKEDIT ends with 192 bytes of zero padding",
+	}
+	if got := readiness(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("hazards:\n\t%s\nwant:\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, img) {
+		t.Error("checking the file modified it")
+	}
+
+	// The passes fix every hazard reported.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw, err := newMachoRewriter(f)
+	if err == nil {
+		var passes []machoPass
+		if passes, err = machoPasses(); err == nil {
+			err = machoCanonicalize(&Link{}, rw, passes, nil)
+		}
+	}
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readiness(path); len(got) != 0 {
+		t.Errorf("hazards after canonicalization: %q", got)
+	}
+
+	signed := newTestMachO(testUuid).signed()
+	if err := os.WriteFile(path, signed, 0666); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"LC_CODE_SIGNATURE present: its hashes change with any other field"}
+	if got := readiness(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("hazards of a signed file: %q, want %q", got, want)
+	}
+}
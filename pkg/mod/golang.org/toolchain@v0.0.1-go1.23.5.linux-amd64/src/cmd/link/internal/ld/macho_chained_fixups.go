@@ -0,0 +1,335 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file encodes the dynamic relocations of an internally linked
+// Mach-O PIE as chained fixups (LC_DYLD_CHAINED_FIXUPS), the format
+// dyld prefers since macOS 12, instead of the rebase and bind opcodes of
+// LC_DYLD_INFO_ONLY (see machoDyldInfo). Each pointer to fix up holds,
+// in place of its value, either its rebase target or the index of the
+// import it binds to, and the offset of the next pointer to fix up in
+// its page; the load command's data gives the first pointer of each
+// page and the imports. See mach-o/fixup-chains.h for the encoding.
+
+import (
+	"bytes"
+	"cmd/internal/sys"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Constants from mach-o/fixup-chains.h.
+const (
+	DYLD_CHAINED_PTR_64          = 2 // pointer format whose rebase target is a vmaddr
+	DYLD_CHAINED_IMPORT          = 1 // import format of 32-bit entries
+	DYLD_CHAINED_PTR_START_NONE  = 0xFFFF
+	machoChainedFixupsHeaderSize = 28 // struct dyld_chained_fixups_header
+	machoChainedStartsSegSize    = 22 // struct dyld_chained_starts_in_segment without page_start
+)
+
+// machoChainedMinOS is the minimum macOS version, in LC_BUILD_VERSION
+// form, whose dyld reads LC_DYLD_CHAINED_FIXUPS.
+const machoChainedMinOS = 12 << 16
+
+// machoMinOS is the minimum OS version the internal Mach-O link
+// declares in its platform load command, set by domacho.
+var machoMinOS uint32
+
+// machoPlatformMinOS returns the minimum OS version that the platform
+// load command l declares.
+func machoPlatformMinOS(l *MachoLoad) uint32 {
+	switch {
+	case l.type_ == LC_BUILD_VERSION && len(l.data) >= 2:
+		// struct build_version_command { cmd, cmdsize, platform, minos, ... }
+		return l.data[1]
+	case len(l.data) >= 1:
+		// struct version_min_command { cmd, cmdsize, version, sdk }
+		return l.data[0]
+	}
+	return 0
+}
+
+// machoUseChainedFixups reports whether the internal Mach-O link
+// encodes its dynamic relocations as chained fixups, as -machofixups
+// asks: always for chained, never for opcodes, and for auto when the
+// declared minimum macOS version has a dyld that reads them.
+func machoUseChainedFixups(ctxt *Link) bool {
+	if !ctxt.IsDarwin() || !ctxt.IsInternal() || !ctxt.IsPIE() {
+		return false
+	}
+	switch *flagMachoFixups {
+	case "chained":
+		return true
+	case "auto":
+		return machoPlatform == PLATFORM_MACOS && machoMinOS >= machoChainedMinOS
+	}
+	return false
+}
+
+// A machoChainedSeg is a segment of the image, in load command order.
+type machoChainedSeg struct {
+	Vmoff  uint64 // vmaddr of the segment minus that of the Mach-O header
+	Vmsize uint64
+}
+
+// A machoChainedImport is a symbol that a bind fixup binds to.
+type machoChainedImport struct {
+	Ordinal int // dylib ordinal, or a BIND_SPECIAL_DYLIB_* value
+	Name    string
+}
+
+// A machoChainedFixup is a pointer to fix up.
+type machoChainedFixup struct {
+	Seg    int    // index of the segment holding the pointer
+	Off    uint64 // offset of the pointer in the segment
+	Bind   bool
+	Target uint64 // vmaddr the pointer points to, for a rebase
+	Import int    // index in the imports, for a bind
+}
+
+// machoChainedFixups returns the data of the LC_DYLD_CHAINED_FIXUPS
+// command for an image with the segments segs, whose pointers fixups
+// are to be fixed up as DYLD_CHAINED_PTR_64 chains in pages of
+// pageSize bytes, binding to imports. It also returns, for each fixup,
+// the value to write in place of the pointer.
+func machoChainedFixups(order binary.ByteOrder, pageSize uint64, segs []machoChainedSeg, imports []machoChainedImport, fixups []machoChainedFixup) ([]byte, []uint64, error) {
+	if len(imports) >= 1<<24 {
+		return nil, nil, fmt.Errorf("%d imports, more than a chained bind can refer to", len(imports))
+	}
+	// The chains are walked in increasing order of address.
+	idx := make([]int, len(fixups))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		a, b := fixups[idx[i]], fixups[idx[j]]
+		if a.Seg != b.Seg {
+			return a.Seg < b.Seg
+		}
+		return a.Off < b.Off
+	})
+
+	values := make([]uint64, len(fixups))
+	pageStarts := make([][]uint16, len(segs))
+	for k, i := range idx {
+		f := fixups[i]
+		if f.Seg < 0 || f.Seg >= len(segs) || f.Off+8 > segs[f.Seg].Vmsize {
+			return nil, nil, fmt.Errorf("fixup at %#x in segment %d is outside it", f.Off, f.Seg)
+		}
+		if f.Off%4 != 0 {
+			return nil, nil, fmt.Errorf("fixup at %#x in segment %d is not 4-byte aligned", f.Off, f.Seg)
+		}
+		if k > 0 && fixups[idx[k-1]].Seg == f.Seg && fixups[idx[k-1]].Off+8 > f.Off {
+			return nil, nil, fmt.Errorf("fixups at %#x and %#x in segment %d overlap", fixups[idx[k-1]].Off, f.Off, f.Seg)
+		}
+		if pageStarts[f.Seg] == nil {
+			n := (segs[f.Seg].Vmsize + pageSize - 1) / pageSize
+			if n >= DYLD_CHAINED_PTR_START_NONE {
+				return nil, nil, fmt.Errorf("segment %d has %d pages, too many for its chain starts", f.Seg, n)
+			}
+			pageStarts[f.Seg] = make([]uint16, n)
+			for p := range pageStarts[f.Seg] {
+				pageStarts[f.Seg][p] = DYLD_CHAINED_PTR_START_NONE
+			}
+		}
+		page := f.Off / pageSize
+		if pageStarts[f.Seg][page] == DYLD_CHAINED_PTR_START_NONE {
+			pageStarts[f.Seg][page] = uint16(f.Off % pageSize)
+		}
+
+		// The next field counts 4-byte strides to the next fixup in
+		// the page, 0 ending the chain.
+		var next uint64
+		if k+1 < len(idx) {
+			if n := fixups[idx[k+1]]; n.Seg == f.Seg && n.Off/pageSize == page {
+				next = (n.Off - f.Off) / 4
+			}
+		}
+		var v uint64
+		if f.Bind {
+			if f.Import < 0 || f.Import >= len(imports) {
+				return nil, nil, fmt.Errorf("fixup at %#x in segment %d binds to import %d of %d", f.Off, f.Seg, f.Import, len(imports))
+			}
+			// struct dyld_chained_ptr_64_bind { ordinal:24, addend:8,
+			// reserved:19, next:12, bind:1 }
+			v = uint64(f.Import) | next<<51 | 1<<63
+		} else {
+			// struct dyld_chained_ptr_64_rebase { target:36, high8:8,
+			// reserved:7, next:12, bind:1 }
+			high8, target := f.Target>>56, f.Target&(1<<56-1)
+			if target >= 1<<36 {
+				return nil, nil, fmt.Errorf("rebase target %#x of fixup at %#x in segment %d does not fit in 36 bits", f.Target, f.Off, f.Seg)
+			}
+			v = target | high8<<36 | next<<51
+		}
+		values[i] = v
+	}
+
+	var buf bytes.Buffer
+	w := func(v interface{}) { binary.Write(&buf, order, v) }
+	pad := func(align int) {
+		for buf.Len()%align != 0 {
+			buf.WriteByte(0)
+		}
+	}
+
+	// struct dyld_chained_starts_in_image { seg_count,
+	// seg_info_offset[seg_count] }, at an 8-byte boundary after the
+	// header as ld64 places it, then a dyld_chained_starts_in_segment
+	// for each segment with fixups.
+	buf.Write(make([]byte, machoChainedFixupsHeaderSize))
+	pad(8)
+	startsOffset := buf.Len()
+	w(uint32(len(segs)))
+	segInfo := buf.Len()
+	buf.Write(make([]byte, 4*len(segs)))
+	for s, starts := range pageStarts {
+		if starts == nil {
+			continue
+		}
+		pad(8)
+		order.PutUint32(buf.Bytes()[segInfo+4*s:], uint32(buf.Len()-startsOffset))
+		w(uint32(machoChainedStartsSegSize + 2*len(starts))) // size
+		w(uint16(pageSize))
+		w(uint16(DYLD_CHAINED_PTR_64))
+		w(segs[s].Vmoff) // segment_offset
+		w(uint32(0))     // max_valid_pointer, for 32-bit formats only
+		w(uint16(len(starts)))
+		w(starts)
+	}
+
+	// The imports, each a struct dyld_chained_import { lib_ordinal:8,
+	// weak_import:1, name_offset:23 }, and their names.
+	pad(4)
+	importsOffset := buf.Len()
+	var names strings.Builder
+	for _, imp := range imports {
+		if imp.Ordinal > 0xf0 || imp.Ordinal < BIND_SPECIAL_DYLIB_WEAK_LOOKUP {
+			return nil, nil, fmt.Errorf("import %s has dylib ordinal %d, out of range for a chained import", imp.Name, imp.Ordinal)
+		}
+		if names.Len() >= 1<<23 {
+			return nil, nil, fmt.Errorf("import names take more than %d bytes", 1<<23)
+		}
+		w(uint32(uint8(imp.Ordinal)) | uint32(names.Len())<<9)
+		names.WriteString(imp.Name)
+		names.WriteByte(0)
+	}
+	symbolsOffset := buf.Len()
+	buf.WriteString(names.String())
+	pad(8)
+
+	data := buf.Bytes()
+	for i, v := range []uint32{
+		0, // fixups_version
+		uint32(startsOffset),
+		uint32(importsOffset),
+		uint32(symbolsOffset),
+		uint32(len(imports)),
+		DYLD_CHAINED_IMPORT,
+		0, // symbols_format: uncompressed
+	} {
+		order.PutUint32(data[4*i:], v)
+	}
+	return data, values, nil
+}
+
+// machoDyldChainedFixups fills s with the LC_DYLD_CHAINED_FIXUPS data
+// for the rebase and bind records of the link, and writes the chains
+// over the pointers they fix up, which asmb has already written to the
+// output.
+func machoDyldChainedFixups(ctxt *Link, s *loader.SymbolBuilder) {
+	ldr := ctxt.loader
+
+	// The segments are those of the load commands asmbMacho writes.
+	// Each is given with the file offset of its start, for finding the
+	// pointers to fix up in the output.
+	textVaddr := Segtext.Vaddr - uint64(HEADR)
+	segs := []machoChainedSeg{{}} // __PAGEZERO
+	type segFile struct {
+		index          int
+		vaddr, fileoff uint64
+		filelen        uint64
+	}
+	segIndex := make(map[*sym.Segment]segFile)
+	textLen := uint64(HEADR) + Segtext.Length
+	segIndex[&Segtext] = segFile{len(segs), textVaddr, 0, textLen}
+	segs = append(segs, machoChainedSeg{0, uint64(Rnd(int64(textLen), *FlagRound))})
+	for _, seg := range []*sym.Segment{&Segrelrodata, &Segdata} {
+		if seg == &Segrelrodata && seg.Length == 0 {
+			continue
+		}
+		segIndex[seg] = segFile{len(segs), seg.Vaddr, seg.Fileoff, seg.Filelen}
+		segs = append(segs, machoChainedSeg{seg.Vaddr - textVaddr, seg.Length})
+	}
+	if !*FlagW {
+		segs = append(segs, machoChainedSeg{Segdwarf.Vaddr - textVaddr, 0})
+	}
+	segs = append(segs, machoChainedSeg{}) // __LINKEDIT
+
+	slot := func(seg *sym.Segment, addr uint64) (index int, off, fileoff uint64) {
+		f, ok := segIndex[seg]
+		if !ok {
+			Exitf("chained fixup at %#x in segment %s, which has no chain starts", addr, seg.Sections[0].Name)
+		}
+		off = addr - f.vaddr
+		if off+8 > f.filelen {
+			Exitf("chained fixup at %#x is not in the file data of its segment", addr)
+		}
+		return f.index, off, f.fileoff + off
+	}
+
+	var fixups []machoChainedFixup
+	var fileoffs []uint64
+	for _, r := range machorebase {
+		seg := ldr.SymSect(r.sym).Seg
+		addr := uint64(ldr.SymValue(r.sym) + r.off)
+		i, off, fileoff := slot(seg, addr)
+		var b [8]byte
+		if _, err := ctxt.Out.ReadAt(b[:], int64(fileoff)); err != nil {
+			Exitf("chained fixups: %v", err)
+		}
+		target := ctxt.Arch.ByteOrder.Uint64(b[:])
+		fixups = append(fixups, machoChainedFixup{Seg: i, Off: off, Target: target})
+		fileoffs = append(fileoffs, fileoff)
+	}
+
+	var imports []machoChainedImport
+	importIndex := make(map[machoChainedImport]int)
+	got := ctxt.GOT
+	for _, r := range machobind {
+		seg := ldr.SymSect(got).Seg
+		i, off, fileoff := slot(seg, uint64(ldr.SymValue(got)+r.off))
+		imp := machoChainedImport{machoDylibOrdinal(ldr, r.targ), "_" + ldr.SymExtname(r.targ)}
+		n, ok := importIndex[imp]
+		if !ok {
+			n = len(imports)
+			importIndex[imp] = n
+			imports = append(imports, imp)
+		}
+		fixups = append(fixups, machoChainedFixup{Seg: i, Off: off, Bind: true, Import: n})
+		fileoffs = append(fileoffs, fileoff)
+	}
+
+	pageSize := uint64(0x1000)
+	if ctxt.Arch.Family == sys.ARM64 {
+		pageSize = 0x4000
+	}
+	data, values, err := machoChainedFixups(ctxt.Arch.ByteOrder, pageSize, segs, imports, fixups)
+	if err != nil {
+		Exitf("chained fixups: %v", err)
+	}
+	var b [8]byte
+	for i, v := range values {
+		ctxt.Arch.ByteOrder.PutUint64(b[:], v)
+		if _, err := ctxt.Out.WriteAt(b[:], int64(fileoffs[i])); err != nil {
+			Exitf("chained fixups: %v", err)
+		}
+	}
+	s.AddBytes(data)
+}
@@ -0,0 +1,256 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A testChainedPtr is a pointer found by walking the chains of an
+// LC_DYLD_CHAINED_FIXUPS payload.
+type testChainedPtr struct {
+	Seg    int
+	Off    uint64
+	Bind   bool
+	Target uint64 // for a rebase
+	Import string // for a bind, "ordinal:name"
+}
+
+// testWalkChains decodes the LC_DYLD_CHAINED_FIXUPS data blob, as dyld
+// would, and walks its chains through the pointers that value returns
+// for each segment index and offset.
+func testWalkChains(t *testing.T, order binary.ByteOrder, blob []byte, value func(seg int, off uint64) uint64) []testChainedPtr {
+	t.Helper()
+	if len(blob) < machoChainedFixupsHeaderSize {
+		t.Fatalf("chained fixups data is %d bytes", len(blob))
+	}
+	hdr := make([]uint32, 7)
+	for i := range hdr {
+		hdr[i] = order.Uint32(blob[4*i:])
+	}
+	startsOff, importsOff, symbolsOff, nimports := hdr[1], hdr[2], hdr[3], hdr[4]
+	if hdr[0] != 0 || hdr[5] != DYLD_CHAINED_IMPORT || hdr[6] != 0 {
+		t.Fatalf("header %#x", hdr)
+	}
+	var imports []string
+	for i := uint32(0); i < nimports; i++ {
+		v := order.Uint32(blob[importsOff+4*i:])
+		name := blob[symbolsOff+v>>9:]
+		name = name[:strings.IndexByte(string(name), 0)]
+		imports = append(imports, fmt.Sprintf("%d:%s", int8(v), name))
+	}
+
+	var ptrs []testChainedPtr
+	nsegs := order.Uint32(blob[startsOff:])
+	for s := uint32(0); s < nsegs; s++ {
+		info := order.Uint32(blob[startsOff+4+4*s:])
+		if info == 0 {
+			continue
+		}
+		seg := blob[startsOff+info:]
+		pageSize := uint64(order.Uint16(seg[4:]))
+		if format := order.Uint16(seg[6:]); format != DYLD_CHAINED_PTR_64 {
+			t.Fatalf("segment %d has pointer format %d", s, format)
+		}
+		npages := order.Uint16(seg[20:])
+		if size := order.Uint32(seg); size != uint32(machoChainedStartsSegSize)+2*uint32(npages) {
+			t.Errorf("segment %d starts are %d bytes for %d pages", s, size, npages)
+		}
+		for p := uint64(0); p < uint64(npages); p++ {
+			start := order.Uint16(seg[22+2*p:])
+			if start == DYLD_CHAINED_PTR_START_NONE {
+				continue
+			}
+			for off := p*pageSize + uint64(start); ; {
+				v := value(int(s), off)
+				ptr := testChainedPtr{Seg: int(s), Off: off, Bind: v>>63 != 0}
+				if ptr.Bind {
+					ptr.Import = imports[v&(1<<24-1)]
+				} else {
+					ptr.Target = v&(1<<36-1) | (v>>36&0xff)<<56
+				}
+				ptrs = append(ptrs, ptr)
+				next := v >> 51 & 0xfff
+				if next == 0 {
+					break
+				}
+				off += 4 * next
+				if off/pageSize != p {
+					t.Fatalf("chain in page %d of segment %d runs into the next page", p, s)
+				}
+			}
+		}
+	}
+	return ptrs
+}
+
+func TestMachoChainedFixups(t *testing.T) {
+	order := binary.LittleEndian
+	segs := []machoChainedSeg{
+		{},               // __PAGEZERO
+		{0, 0x4000},      // __TEXT
+		{0x4000, 0x1000}, // __DATA_CONST
+		{0x5000, 0x3000}, // __DATA, three pages
+		{0x8000, 0},      // __LINKEDIT
+	}
+	imports := []machoChainedImport{
+		{1, "_write"},
+		{BIND_SPECIAL_DYLIB_FLAT_LOOKUP, "_environ"},
+	}
+	// Out of order, the chains follow increasing offsets, and each page
+	// has its own.
+	fixups := []machoChainedFixup{
+		{Seg: 3, Off: 0x1010, Target: 0x100003000},
+		{Seg: 3, Off: 0x8, Bind: true, Import: 1},
+		{Seg: 3, Off: 0x0, Target: 0x100001000},
+		{Seg: 2, Off: 0x20, Bind: true, Import: 0},
+		{Seg: 3, Off: 0x40, Bind: true, Import: 0},
+		{Seg: 3, Off: 0x2ff8, Target: 0xab00000100000000},
+	}
+	blob, values, err := machoChainedFixups(order, 0x1000, segs, imports, fixups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blob)%8 != 0 {
+		t.Errorf("data is %d bytes, not a multiple of 8", len(blob))
+	}
+	mem := make(map[[2]uint64]uint64)
+	for i, f := range fixups {
+		mem[[2]uint64{uint64(f.Seg), f.Off}] = values[i]
+	}
+	got := testWalkChains(t, order, blob, func(seg int, off uint64) uint64 {
+		v, ok := mem[[2]uint64{uint64(seg), off}]
+		if !ok {
+			t.Fatalf("chain reaches %#x in segment %d, which is no fixup", off, seg)
+		}
+		return v
+	})
+	want := []testChainedPtr{
+		{Seg: 2, Off: 0x20, Bind: true, Import: "1:_write"},
+		{Seg: 3, Off: 0x0, Target: 0x100001000},
+		{Seg: 3, Off: 0x8, Bind: true, Import: "-2:_environ"},
+		{Seg: 3, Off: 0x40, Bind: true, Import: "1:_write"},
+		{Seg: 3, Off: 0x1010, Target: 0x100003000},
+		{Seg: 3, Off: 0x2ff8, Target: 0xab00000100000000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked chains:\n%+v\nwant\n%+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		fixup machoChainedFixup
+		want  string
+	}{
+		{"misaligned", machoChainedFixup{Seg: 3, Off: 0x2}, "not 4-byte aligned"},
+		{"outside", machoChainedFixup{Seg: 2, Off: 0xffc}, "outside"},
+		{"overlap", machoChainedFixup{Seg: 3, Off: 0x1014}, "overlap"},
+		{"import", machoChainedFixup{Seg: 3, Off: 0x100, Bind: true, Import: 2}, "import 2 of 2"},
+		{"target", machoChainedFixup{Seg: 3, Off: 0x100, Target: 1 << 40}, "36 bits"},
+	} {
+		_, _, err := machoChainedFixups(order, 0x1000, segs, imports, append(fixups[:len(fixups):len(fixups)], tc.fixup))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want one mentioning %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// TestMachoChainedFixupsLink links a PIE for darwin/arm64 internally
+// with -machofixups=chained and checks that its chains reach every
+// pointer the opcodes of the same link without the flag would fix up.
+func TestMachoChainedFixupsLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for darwin")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nvar p = &x\nvar x int\n\nfunc main() { println(p) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	link := func(fixups string) *macho.File {
+		t.Helper()
+		exe := filepath.Join(dir, fixups)
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode=pie", "-ldflags=-linkmode=internal -machofixups="+fixups, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		f, err := macho.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	chained, opcodes := link("chained"), link("opcodes")
+
+	var blob []byte
+	for _, l := range chained.Loads {
+		raw := l.Raw()
+		switch chained.ByteOrder.Uint32(raw) {
+		case LC_DYLD_INFO_ONLY:
+			t.Error("chained link has LC_DYLD_INFO_ONLY")
+		case LC_DYLD_CHAINED_FIXUPS:
+			off, size := chained.ByteOrder.Uint32(raw[8:]), chained.ByteOrder.Uint32(raw[12:])
+			seg := chained.Segment("__LINKEDIT")
+			data, err := seg.Data()
+			if err != nil {
+				t.Fatal(err)
+			}
+			blob = data[uint64(off)-seg.Offset:][:size]
+		case LC_BUILD_VERSION:
+			if minos := chained.ByteOrder.Uint32(raw[12:]); minos != machoChainedMinOS {
+				t.Errorf("minimum macOS version %s, want 12.0", machoVersionString(minos))
+			}
+		}
+	}
+	if blob == nil {
+		t.Fatal("chained link has no LC_DYLD_CHAINED_FIXUPS")
+	}
+
+	// The segments are in the same order, with the same contents
+	// but for the pointers to fix up.
+	var segs []*macho.Segment
+	for _, l := range chained.Loads {
+		if s, ok := l.(*macho.Segment); ok {
+			segs = append(segs, s)
+		}
+	}
+	ptrs := testWalkChains(t, chained.ByteOrder, blob, func(seg int, off uint64) uint64 {
+		data, err := segs[seg].Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chained.ByteOrder.Uint64(data[off:])
+	})
+	rebases := 0
+	for _, p := range ptrs {
+		if p.Bind {
+			continue
+		}
+		rebases++
+		want, err := opcodes.Segment(segs[p.Seg].Name).Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := opcodes.ByteOrder.Uint64(want[p.Off:]); v != p.Target {
+			t.Errorf("rebase at %#x in %s targets %#x, but the opcodes link holds %#x", p.Off, segs[p.Seg].Name, p.Target, v)
+		}
+	}
+	if rebases == 0 {
+		t.Error("no rebases in the chains of a PIE")
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains helpers for inspecting and repairing the embedded
+// code signature of a Mach-O file written by the external linker, which
+// post-link rewrites such as the LC_UUID update (see
+// macho_update_uuid.go) would otherwise invalidate, and for -adhocsign
+// and -machosigner, replacing it with a fresh signature.
+
+import (
+	"bytes"
+	"cmd/internal/codesign"
+	"cmd/internal/notsha256"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// machoCodeDirectory describes the code directory of an embedded code
+// signature. All offsets are file offsets.
+type machoCodeDirectory struct {
+	Offset     int64  // offset of the CodeDirectory blob
+	HashOffset int64  // offset of the hash of code page 0
+	NCodeSlots uint32 // number of code page hashes
+	CodeLimit  int64  // end of the signed range
+	HashSize   int
+	HashType   uint8
+	PageSize   int64 // 0 means a single page covering the whole range
+	Flags      uint32
+}
+
+// csAdhoc is the code directory flag of an ad hoc signature, one with
+// no CMS signature over the code directory. Those are the only ones
+// that can be repaired after an edit.
+const csAdhoc = 0x2
+
+// machoReadCodeDirectory returns the code directory of the signature
+// that the LC_CODE_SIGNATURE command in cmds points to, or nil if there
+// is no such command. Signature data is always big-endian.
+func machoReadCodeDirectory(f io.ReaderAt, order binary.ByteOrder, cmds []machoLoadCmd) (*machoCodeDirectory, error) {
+	var sigOff, sigSize int64
+	for _, c := range cmds {
+		if c.Cmd == LC_CODE_SIGNATURE && len(c.Data) >= 16 {
+			sigOff = int64(order.Uint32(c.Data[8:]))
+			sigSize = int64(order.Uint32(c.Data[12:]))
+			break
+		}
+	}
+	if sigSize == 0 {
+		return nil, nil
+	}
+
+	var sb [12]byte
+	if _, err := f.ReadAt(sb[:], sigOff); err != nil {
+		return nil, fmt.Errorf("reading code signature: %v", err)
+	}
+	if magic := binary.BigEndian.Uint32(sb[:]); magic != codesign.CSMAGIC_EMBEDDED_SIGNATURE {
+		return nil, fmt.Errorf("code signature has bad magic %#x", magic)
+	}
+	count := binary.BigEndian.Uint32(sb[8:])
+	if int64(count) > (sigSize-12)/8 {
+		return nil, fmt.Errorf("code signature index has %d entries, too many for %d bytes", count, sigSize)
+	}
+	index := make([]byte, 8*count)
+	if _, err := f.ReadAt(index, sigOff+12); err != nil {
+		return nil, fmt.Errorf("reading code signature: %v", err)
+	}
+	for i := uint32(0); i < count; i++ {
+		typ := binary.BigEndian.Uint32(index[8*i:])
+		off := int64(binary.BigEndian.Uint32(index[8*i+4:]))
+		if typ != codesign.CSSLOT_CODEDIRECTORY {
+			continue
+		}
+		// The fixed part of the code directory, up to and including
+		// the page size (see cmd/internal/codesign.CodeDirectory).
+		var cd [40]byte
+		if off+int64(len(cd)) > sigSize {
+			return nil, fmt.Errorf("code directory at %#x extends past the code signature", off)
+		}
+		if _, err := f.ReadAt(cd[:], sigOff+off); err != nil {
+			return nil, fmt.Errorf("reading code directory: %v", err)
+		}
+		if magic := binary.BigEndian.Uint32(cd[:]); magic != codesign.CSMAGIC_CODEDIRECTORY {
+			return nil, fmt.Errorf("code directory has bad magic %#x", magic)
+		}
+		dir := &machoCodeDirectory{
+			Offset:     sigOff + off,
+			HashOffset: sigOff + off + int64(binary.BigEndian.Uint32(cd[16:])),
+			NCodeSlots: binary.BigEndian.Uint32(cd[28:]),
+			CodeLimit:  int64(binary.BigEndian.Uint32(cd[32:])),
+			HashSize:   int(cd[36]),
+			HashType:   cd[37],
+			Flags:      binary.BigEndian.Uint32(cd[12:]),
+		}
+		if shift := cd[39]; shift != 0 {
+			if shift >= 32 {
+				return nil, fmt.Errorf("code directory has bad page size 1<<%d", shift)
+			}
+			dir.PageSize = 1 << shift
+			// The page size is whatever the signer chose, not
+			// necessarily 4096 bytes, and every page index and hash
+			// follows from it: check that it agrees with the number
+			// of hashes before trusting either.
+			if pages := (dir.CodeLimit + dir.PageSize - 1) / dir.PageSize; pages != int64(dir.NCodeSlots) {
+				return nil, fmt.Errorf("code directory has %d code page hashes for %d bytes in pages of %d", dir.NCodeSlots, dir.CodeLimit, dir.PageSize)
+			}
+		}
+		return dir, nil
+	}
+	return nil, fmt.Errorf("code signature has no code directory")
+}
+
+// Page returns the index of the code page that holds the file offset
+// off, or -1 if the signature does not cover off.
+func (cd *machoCodeDirectory) Page(off int64) int {
+	if off < 0 || off >= cd.CodeLimit {
+		return -1
+	}
+	if cd.PageSize == 0 {
+		return 0
+	}
+	return int(off / cd.PageSize)
+}
+
+// AdHoc reports whether cd belongs to an ad hoc signature.
+func (cd *machoCodeDirectory) AdHoc() bool {
+	return cd.Flags&csAdhoc != 0
+}
+
+// PageHash computes the hash of code page page of f.
+func (cd *machoCodeDirectory) PageHash(f io.ReaderAt, page int) ([]byte, error) {
+	if cd.HashType != codesign.CS_HASHTYPE_SHA256 || cd.HashSize != notsha256.Size {
+		return nil, fmt.Errorf("unsupported code signature hash type %d (size %d)", cd.HashType, cd.HashSize)
+	}
+	if page < 0 || uint32(page) >= cd.NCodeSlots {
+		return nil, fmt.Errorf("code page %d out of range [0, %d)", page, cd.NCodeSlots)
+	}
+	start, end := int64(0), cd.CodeLimit
+	if cd.PageSize != 0 {
+		start = int64(page) * cd.PageSize
+		end = start + cd.PageSize
+		if end > cd.CodeLimit {
+			end = cd.CodeLimit
+		}
+	}
+	data := make([]byte, end-start)
+	if _, err := f.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	h := notsha256.Sum256(data)
+	for i := range h {
+		h[i] ^= 0xFF // convert notsha256 to sha256, as cmd/internal/codesign does
+	}
+	return h[:], nil
+}
+
+// machoRepairSignature updates the hashes that cd, an ad hoc code
+// directory in f, records for the pages holding the n bytes at off,
+// after those bytes were changed, and returns the pages it updated.
+// A range straddling a page boundary updates every page it touches.
+// Changes outside the signed range need no repair.
+func machoRepairSignature(f machoReadWriterAt, cd *machoCodeDirectory, off, n int64) ([]int, error) {
+	if !cd.AdHoc() {
+		return nil, fmt.Errorf("cannot update a code signature that is not ad hoc")
+	}
+	first := cd.Page(off)
+	if first < 0 || n <= 0 {
+		return nil, nil
+	}
+	end := off + n
+	if end > cd.CodeLimit {
+		end = cd.CodeLimit
+	}
+	last := cd.Page(end - 1)
+	var pages []int
+	for page := first; page <= last; page++ {
+		h, err := cd.PageHash(f, page)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.WriteAt(h, cd.HashOffset+int64(page*cd.HashSize)); err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// machoUuidSignedPage returns the code signature page that holds the
+// LC_UUID payload of f, or -1 if the payload is not covered by a
+// signature. off is the file offset of the payload.
+func machoUuidSignedPage(f io.ReaderAt, order binary.ByteOrder, cmds []machoLoadCmd) (page int, off int64, cd *machoCodeDirectory, err error) {
+	var uuid *machoLoadCmd
+	for i := range cmds {
+		if cmds[i].Cmd == LC_UUID {
+			uuid = &cmds[i]
+			break
+		}
+	}
+	if uuid == nil {
+		return -1, 0, nil, nil
+	}
+	off = uuid.Offset + 8
+	cd, err = machoReadCodeDirectory(f, order, cmds)
+	if err != nil || cd == nil {
+		return -1, off, nil, err
+	}
+	return cd.Page(off), off, cd, nil
+}
+
+// machoWarnUuidSignedPage adds a warning to w saying which code
+// signature page of f the LC_UUID rewrite modified, if any, and how the
+// signature is kept valid.
+func machoWarnUuidSignedPage(ctxt *Link, w *machoWarnings, f io.ReaderAt, exem *macho.File) error {
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return err
+	}
+	page, off, cd, err := machoUuidSignedPage(f, exem.ByteOrder, cmds)
+	if err != nil {
+		// This is only a diagnostic; don't fail the link over it.
+		w.Add("cannot inspect code signature: %v", err)
+		return nil
+	}
+	if page < 0 {
+		return nil
+	}
+	fix := "the binary must be re-signed"
+	switch {
+	case ctxt.NeedCodeSign():
+		fix = "the signature will be regenerated"
+	case cd.AdHoc():
+		fix = "its hash was updated"
+	}
+	w.Add("LC_UUID at offset %#x is in code signature page %d (page size %d); %s", off, page, cd.PageSize, fix)
+	return nil
+}
+
+// machoAdHocSign gives the 64-bit Mach-O file at path a fresh ad hoc
+// code signature over its final contents, for -adhocsign, so that a
+// binary whose LC_UUID was rewritten is validly signed without running
+// codesign. isMain says whether the file is an executable.
+func machoAdHocSign(path string, isMain bool) error {
+	return machoSign(path, isMain, nil)
+}
+
+// machoSign gives the 64-bit Mach-O file at path a fresh code signature
+// over its final contents: an ad hoc one if signer is nil, and one
+// signed by signer otherwise. isMain says whether the file is an
+// executable. An existing signature, which must end the file, is
+// replaced. A file with none gets one appended to __LINKEDIT, which
+// must end the file, aligned to 16 bytes, and an LC_CODE_SIGNATURE
+// command in the header padding; as for -uuidinsert, too little padding
+// is an error that leaves the file unchanged.
+func machoSign(path string, isMain bool, signer *machoSigner) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	exem, err := machoParseFile(f)
+	if err != nil {
+		return err
+	}
+	if exem.Magic != macho.Magic64 {
+		return fmt.Errorf("ad hoc signing needs a 64-bit Mach-O file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return err
+	}
+	order := exem.ByteOrder
+	var sigCmd, linkeditCmd *machoLoadCmd
+	for i := range cmds {
+		switch c := &cmds[i]; c.Cmd {
+		case LC_CODE_SIGNATURE:
+			sigCmd = c
+		case LC_SEGMENT_64:
+			if len(c.Data) < 24 {
+				continue
+			}
+			if name, _, _ := bytes.Cut(c.Data[8:24], []byte{0}); string(name) == "__LINKEDIT" {
+				linkeditCmd = c
+			}
+		}
+	}
+	linkedit, text := exem.Segment("__LINKEDIT"), exem.Segment("__TEXT")
+	if linkedit == nil || linkeditCmd == nil || text == nil {
+		return fmt.Errorf("no __TEXT and __LINKEDIT segments to sign")
+	}
+
+	// The changes to the load commands are collected first, so that an
+	// error leaves the file unchanged.
+	e := new(machoEdits)
+	var sigOff, sigCmdOff int64
+	if sigCmd != nil {
+		// struct linkedit_data_command { cmd, cmdsize, dataoff, datasize }
+		if len(sigCmd.Data) < 16 {
+			return fmt.Errorf("LC_CODE_SIGNATURE at %#x %w (%d bytes)", sigCmd.Offset, errLoadCmdShort, len(sigCmd.Data))
+		}
+		sigOff = int64(order.Uint32(sigCmd.Data[8:]))
+		if sigOff+int64(order.Uint32(sigCmd.Data[12:])) != size {
+			return fmt.Errorf("unexpected content after code signature")
+		}
+		sigCmdOff = sigCmd.Offset
+	} else {
+		if int64(linkedit.Offset+linkedit.Filesz) != size {
+			return fmt.Errorf("__LINKEDIT does not end the file")
+		}
+		slack, err := machoHeaderSlack(exem)
+		if err != nil {
+			return err
+		}
+		const cmdLen = 16
+		if slack < cmdLen {
+			return fmt.Errorf("no room to add LC_CODE_SIGNATURE: need %d bytes of header padding, have %d (relink with a larger ld -headerpad)", cmdLen, slack)
+		}
+		sigOff = Rnd(size, 16)
+		sigCmdOff = machoHeaderSize(exem) + int64(exem.Cmdsz)
+		var cmd bytes.Buffer
+		binary.Write(&cmd, order, []uint32{LC_CODE_SIGNATURE, cmdLen})
+		if err := e.patch(f, sigCmdOff, cmd.Bytes()); err != nil {
+			return err
+		}
+		var hdr bytes.Buffer
+		binary.Write(&hdr, order, []uint32{exem.Ncmd + 1, exem.Cmdsz + cmdLen})
+		if err := e.patch(f, int64(unsafe.Offsetof(exem.FileHeader.Ncmd)), hdr.Bytes()); err != nil {
+			return err
+		}
+	}
+	sz := codesign.Size(sigOff, "a.out")
+	if signer != nil {
+		sz = signer.size(sigOff)
+	}
+	var lc bytes.Buffer
+	binary.Write(&lc, order, []uint32{uint32(sigOff), uint32(sz)})
+	if err := e.patch(f, sigCmdOff+8, lc.Bytes()); err != nil {
+		return err
+	}
+	// vmsize, fileoff, filesize of struct segment_command_64.
+	filesz := uint64(sigOff+sz) - linkedit.Offset
+	memsz := uint64(Rnd(int64(filesz), machoPageSize(exem.Cpu)))
+	if memsz < linkedit.Memsz {
+		memsz = linkedit.Memsz
+	}
+	var seg bytes.Buffer
+	binary.Write(&seg, order, []uint64{memsz, linkedit.Offset, filesz})
+	if err := e.patch(f, linkeditCmd.Offset+32, seg.Bytes()); err != nil {
+		return err
+	}
+
+	for _, p := range e.Patches {
+		if _, err := f.WriteAt(p.New, p.Offset); err != nil {
+			return err
+		}
+	}
+	// Drop the old signature, or pad __LINKEDIT to the new one.
+	if err := f.Truncate(sigOff); err != nil {
+		return err
+	}
+	cs := make([]byte, sz)
+	if signer != nil {
+		// The go command can only update the build ID of a file signed
+		// ad hoc, so it is done here, with the file the size it is once
+		// signed.
+		if err := f.Truncate(sigOff + sz); err != nil {
+			return err
+		}
+		if err := machoRewriteBuildID(f, sigOff+sz, *flagBuildid); err != nil {
+			return err
+		}
+		if err := signer.signature(cs, f, sigOff, int64(text.Offset), int64(text.Filesz), isMain); err != nil {
+			return err
+		}
+	} else {
+		codesign.Sign(cs, io.NewSectionReader(f, 0, sigOff), "a.out", sigOff, int64(text.Offset), int64(text.Filesz), isMain)
+	}
+	_, err = f.WriteAt(cs, sigOff)
+	return err
+}
@@ -0,0 +1,412 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"cmd/internal/codesign"
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// signed appends an LC_CODE_SIGNATURE command to m and returns its
+// image with an ad-hoc signature covering everything before it.
+func (m *testMachO) signed() []byte {
+	codeSize := int64(m.size)
+	sigSize := codesign.Size(codeSize, "a.out")
+	payload := make([]byte, 8)
+	m.order.PutUint32(payload[0:], uint32(codeSize))
+	m.order.PutUint32(payload[4:], uint32(sigSize))
+	m.cmds = append(m.cmds, m.raw(LC_CODE_SIGNATURE, payload))
+	img := m.bytes()
+	img = append(img, make([]byte, sigSize)...)
+	codesign.Sign(img[codeSize:], bytes.NewReader(img[:codeSize]), "a.out", codeSize, 0, 0x500, true)
+	return img
+}
+
+// filler returns a load command of n bytes that no pass cares about.
+func (m *testMachO) filler(n int) []byte {
+	return m.raw(LC_IDENT, make([]byte, n-8))
+}
+
+func TestMachoReadCodeDirectory(t *testing.T) {
+	m := newTestMachO(testUuid)
+	m.size = 0x2800
+	img := m.signed()
+	exem := parseTestMachO(t, img)
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd == nil {
+		t.Fatal("no code directory found")
+	}
+	if cd.PageSize != 4096 || cd.CodeLimit != 0x2800 || cd.NCodeSlots != 3 || cd.HashSize != 32 {
+		t.Errorf("got code directory %+v", cd)
+	}
+
+	unsigned := newTestMachO(testUuid).bytes()
+	exem = parseTestMachO(t, unsigned)
+	cmds, err = machoReadLoadCmds(testMachOBuf(unsigned), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd, err := machoReadCodeDirectory(testMachOBuf(unsigned), exem.ByteOrder, cmds); cd != nil || err != nil {
+		t.Errorf("unsigned binary: got %+v, %v; want nil, nil", cd, err)
+	}
+}
+
+func TestMachoUuidSignedPage(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		filler int // size of a load command placed before LC_UUID
+		page   int
+	}{
+		{"page 0", 0, 0},
+		{"page 1", 4096, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestMachO(testUuid)
+			m.size = 0x3000
+			if tc.filler != 0 {
+				uuid := m.cmds[3]
+				m.cmds = append(m.cmds[:3], m.filler(tc.filler), uuid)
+			}
+			img := m.signed()
+			exem := parseTestMachO(t, img)
+			cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, off, cd, err := machoUuidSignedPage(testMachOBuf(img), exem.ByteOrder, cmds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(img[off:off+16], testUuid[:]) {
+				t.Fatalf("reported UUID offset %#x does not hold the UUID", off)
+			}
+			if page != tc.page || page != int(off/cd.PageSize) {
+				t.Errorf("UUID at %#x reported in page %d, want %d", off, page, tc.page)
+			}
+		})
+	}
+}
+
+// testBadPages returns the code pages of img whose recorded hash does
+// not match their contents.
+func testBadPages(t *testing.T, img []byte) []int {
+	t.Helper()
+	exem := parseTestMachO(t, img)
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds)
+	if err != nil || cd == nil {
+		t.Fatalf("reading code directory: %v, %v", cd, err)
+	}
+	var bad []int
+	for page := 0; page < int(cd.NCodeSlots); page++ {
+		h, err := cd.PageHash(testMachOBuf(img), page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off := cd.HashOffset + int64(page*cd.HashSize)
+		if !bytes.Equal(h, img[off:off+int64(cd.HashSize)]) {
+			bad = append(bad, page)
+		}
+	}
+	return bad
+}
+
+// testResign rewrites the ad hoc signature of img, as signed returns
+// it, to use code pages of 1<<shift bytes, hashing the pages directly
+// rather than through machoCodeDirectory.
+func testResign(t *testing.T, img []byte, shift uint8) {
+	t.Helper()
+	exem := parseTestMachO(t, img)
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(1) << shift
+	n := (cd.CodeLimit + size - 1) / size
+	if n > int64(cd.NCodeSlots) {
+		t.Fatalf("no room for %d page hashes", n)
+	}
+	binary.BigEndian.PutUint32(img[cd.Offset+28:], uint32(n))
+	img[cd.Offset+39] = shift
+	for page := int64(0); page < n; page++ {
+		end := (page + 1) * size
+		if end > cd.CodeLimit {
+			end = cd.CodeLimit
+		}
+		h := sha256.Sum256(img[page*size : end])
+		copy(img[cd.HashOffset+page*int64(cd.HashSize):], h[:])
+	}
+}
+
+func TestMachoRepairSignaturePageSize(t *testing.T) {
+	m := newTestMachO(testUuid)
+	m.size = 0x3000
+	img := m.signed()
+	testResign(t, img, 13) // 8192-byte pages, 2 of them
+	exem := parseTestMachO(t, img)
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd.PageSize != 0x2000 || cd.NCodeSlots != 2 {
+		t.Fatalf("code directory has %d pages of %d bytes, want 2 of 8192", cd.NCodeSlots, cd.PageSize)
+	}
+
+	// A change straddling 0x2000 touches pages 0 and 1 of 8192 bytes,
+	// where 4096-byte pages would have made them 1 and 2.
+	want := append([]byte(nil), img...)
+	copy(want[0x1ff8:], "straddling bytes")
+	testResign(t, want, 13)
+	copy(img[0x1ff8:], "straddling bytes")
+	pages, err := machoRepairSignature(testMachOBuf(img), cd, 0x1ff8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pages, []int{0, 1}) {
+		t.Errorf("repaired pages %v, want [0 1]", pages)
+	}
+	if !bytes.Equal(img, want) {
+		t.Error("repaired signature differs from one made with 8192-byte pages")
+	}
+
+	// A page count that does not match the page size is rejected.
+	img[cd.Offset+39] = 12
+	if _, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds); err == nil {
+		t.Error("code directory with 2 hashes for 3 pages of 4096 bytes accepted")
+	}
+}
+
+func TestMachoRepairSignature(t *testing.T) {
+	// Commands before LC_UUID: __PAGEZERO, __TEXT and __LINKEDIT.
+	base := newTestMachO(testUuid)
+	before := len(base.cmds[0]) + len(base.cmds[1]) + len(base.cmds[2])
+	for _, tc := range []struct {
+		name   string
+		filler int // size of a load command placed before LC_UUID
+		pages  []int
+	}{
+		{"page 0", 0, []int{0}},
+		// The payload starts 8 bytes before the end of page 0.
+		{"straddling", 0x1000 - 8 - 8 - machoHeaderSize64 - before, []int{0, 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestMachO(testUuid)
+			m.size = 0x3000
+			if tc.filler != 0 {
+				uuid := m.cmds[3]
+				m.cmds = append(m.cmds[:3], m.filler(tc.filler), uuid)
+			}
+			img := m.signed()
+			if bad := testBadPages(t, img); len(bad) != 0 {
+				t.Fatalf("fixture has bad pages %v", bad)
+			}
+			exem := parseTestMachO(t, img)
+			cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cd, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, off, _, err := machoUuidSignedPage(testMachOBuf(img), exem.ByteOrder, cmds)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Changing the UUID without a repair breaks the pages
+			// holding it, and only those.
+			broken := append([]byte(nil), img...)
+			copy(broken[off:], uuidFromGoBuildId("test/buildid"))
+			if bad := testBadPages(t, broken); !reflect.DeepEqual(bad, tc.pages) {
+				t.Fatalf("unrepaired UUID change breaks pages %v, want %v", bad, tc.pages)
+			}
+			pages, err := machoRepairSignature(testMachOBuf(broken), cd, off, 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pages, tc.pages) {
+				t.Errorf("repaired pages %v, want %v", pages, tc.pages)
+			}
+			if bad := testBadPages(t, broken); len(bad) != 0 {
+				t.Errorf("after repair, bad pages %v", bad)
+			}
+
+			// machoRewriter does the same on its own.
+			rw, err := newMachoRewriter(testMachOBuf(img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rw.UpdateUuid(uuidFromGoBuildId("test/buildid")); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(img, broken) {
+				t.Errorf("machoRewriter.UpdateUuid result differs from a manual repair")
+			}
+		})
+	}
+}
+
+func TestMachoAdHocSign(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	dir := t.TempDir()
+	sign := func(name string, img []byte) ([]byte, error) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, img, 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := machoAdHocSign(path, true)
+		out, rerr := os.ReadFile(path)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		return out, err
+	}
+	check := func(name string, img []byte) {
+		t.Helper()
+		exem := parseTestMachO(t, img)
+		cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cd, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds)
+		if err != nil || cd == nil {
+			t.Fatalf("%s: code directory %v, %v", name, cd, err)
+		}
+		if !cd.AdHoc() {
+			t.Errorf("%s: signature is not ad hoc", name)
+		}
+		if bad := testBadPages(t, img); bad != nil {
+			t.Errorf("%s: pages %v do not match their hashes", name, bad)
+		}
+		seg := exem.Segment("__LINKEDIT")
+		if end := int64(seg.Offset + seg.Filesz); end != int64(len(img)) || end-cd.CodeLimit != codesign.Size(cd.CodeLimit, "a.out") {
+			t.Errorf("%s: __LINKEDIT ends at %#x, file at %#x, signed range at %#x", name, end, len(img), cd.CodeLimit)
+		}
+		if seg.Memsz < seg.Filesz {
+			t.Errorf("%s: __LINKEDIT vmsize %#x smaller than filesize %#x", name, seg.Memsz, seg.Filesz)
+		}
+		if got := testReadUuid(t, exem); !bytes.Equal(got, uuidFromGoBuildId(*flagBuildid)) {
+			t.Errorf("%s: UUID %x, want the rewritten one", name, got)
+		}
+	}
+
+	// An unsigned binary gets a signature after its UUID rewrite.
+	m := newTestMachO(testUuid)
+	unsigned := testRewriteUuid(t, m.bytes())
+	img, err := sign("unsigned", unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("unsigned", img)
+	if exem := parseTestMachO(t, img); exem.Ncmd != uint32(len(m.cmds)+1) {
+		t.Errorf("unsigned: %d load commands after signing, want %d", exem.Ncmd, len(m.cmds)+1)
+	}
+	if !bytes.Equal(img[0x400:0x600], unsigned[0x400:0x600]) {
+		t.Errorf("unsigned: signing changed the section data")
+	}
+
+	// A signature left stale by a later change is replaced.
+	m = newTestMachO(testUuid)
+	m.size = 0x1000
+	stale := testRewriteUuid(t, m.signed())
+	stale[0x410] ^= 0xff
+	if testBadPages(t, stale) == nil {
+		t.Fatal("changing __text did not invalidate the signature")
+	}
+	img, err = sign("stale", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("stale", img)
+	if len(img) != len(stale) {
+		t.Errorf("stale: file is %d bytes after signing, want %d", len(img), len(stale))
+	}
+
+	// With no header padding for LC_CODE_SIGNATURE, the file is left
+	// as it was.
+	m = newTestMachO(testUuid)
+	cmdEnd := int64(machoHeaderSize64)
+	for _, c := range m.cmds {
+		cmdEnd += int64(len(c))
+	}
+	m.cmds = append(m.cmds, m.filler(int(0x400-cmdEnd)))
+	full := m.bytes()
+	img, err = sign("full", full)
+	if err == nil || !strings.Contains(err.Error(), "no room") {
+		t.Errorf("signing with no header padding: err = %v", err)
+	}
+	if !bytes.Equal(img, full) {
+		t.Errorf("failed signing changed the file")
+	}
+}
+
+func TestMachoCodeSign(t *testing.T) {
+	// The signature machoCodeSign writes on darwin/arm64 after the
+	// post-link passes covers whatever they changed, even an edit
+	// that was not repaired as it was made.
+	m := newTestMachO(testUuid)
+	m.size = 0x2800
+	img := m.signed()
+	exem := parseTestMachO(t, img)
+	off, n, _, err := machoUuidLocation(exem, testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(img[off:off+int64(n)], uuidFromGoBuildId("test/buildid"))
+	img[0x2000] ^= 0xff
+	if bad := testBadPages(t, img); !reflect.DeepEqual(bad, []int{0, 2}) {
+		t.Fatalf("stale pages %v before signing, want [0 2]", bad)
+	}
+
+	path := filepath.Join(t.TempDir(), "exe")
+	if err := os.WriteFile(path, img, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := &Link{Target: Target{Arch: sys.ArchARM64, HeadType: objabi.Hdarwin, LinkMode: LinkExternal}}
+	if err := machoCodeSign(ctxt, path); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != len(img) || !bytes.Equal(signed[:m.size], img[:m.size]) {
+		t.Errorf("signing changed the file outside its signature")
+	}
+	if bad := testBadPages(t, signed); bad != nil {
+		t.Errorf("pages %v do not match their hashes after signing", bad)
+	}
+}
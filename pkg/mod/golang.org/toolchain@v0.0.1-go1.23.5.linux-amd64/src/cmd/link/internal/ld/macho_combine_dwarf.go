@@ -50,9 +50,18 @@ type uuidCmd struct {
 	Uuid [16]byte
 }
 
+// machoReadWriterAt is the subset of *os.File needed to walk and patch
+// the load commands of a Mach-O file. Using it rather than *os.File lets
+// the same code operate on in-memory images.
+type machoReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
 type loadCmdReader struct {
 	offset, next int64
-	f            *os.File
+	cmdLen       int64 // size of the current command, 0 before Next
+	f            machoReadWriterAt
 	order        binary.ByteOrder
 }
 
@@ -60,28 +69,65 @@ func (r *loadCmdReader) Next() (loadCmd, error) {
 	var cmd loadCmd
 
 	r.offset = r.next
-	if _, err := r.f.Seek(r.offset, 0); err != nil {
+	r.cmdLen = 0
+	sr := io.NewSectionReader(r.f, r.offset, int64(binary.Size(cmd)))
+	if err := binary.Read(sr, r.order, &cmd); err != nil {
 		return cmd, err
 	}
-	if err := binary.Read(r.f, r.order, &cmd); err != nil {
-		return cmd, err
+	// A command must at least hold its cmd and cmdsize fields;
+	// anything shorter would leave the reader stuck on it.
+	if cmd.Len < uint32(unsafe.Sizeof(loadCmd{})) {
+		return cmd, fmt.Errorf("bad size %d at offset %#x", cmd.Len, r.offset)
 	}
+	r.cmdLen = int64(cmd.Len)
 	r.next = r.offset + int64(cmd.Len)
 	return cmd, nil
 }
 
+// Offset returns the file offset of the current load command.
+func (r loadCmdReader) Offset() int64 {
+	return r.offset
+}
+
+// PayloadOffset returns the file offset of the current load command's
+// payload, that is, of the bytes following its cmd and cmdsize fields.
+func (r loadCmdReader) PayloadOffset() int64 {
+	return r.offset + int64(unsafe.Sizeof(loadCmd{}))
+}
+
+// check returns an error unless the n bytes at offset within the
+// current load command lie inside it, so that a pass cannot read or
+// write the commands around it by mistake.
+func (r loadCmdReader) check(offset int64, n int) error {
+	if r.cmdLen == 0 {
+		return fmt.Errorf("load command access before Next")
+	}
+	if offset < 0 || n < 0 || offset+int64(n) > r.cmdLen {
+		return fmt.Errorf("%d bytes at offset %d are outside the %d-byte load command at %#x", n, offset, r.cmdLen, r.offset)
+	}
+	return nil
+}
+
+// ReadAt reads data from offset bytes into the current load command.
 func (r loadCmdReader) ReadAt(offset int64, data interface{}) error {
-	if _, err := r.f.Seek(r.offset+offset, 0); err != nil {
+	if err := r.check(offset, binary.Size(data)); err != nil {
 		return err
 	}
-	return binary.Read(r.f, r.order, data)
+	sr := io.NewSectionReader(r.f, r.offset+offset, int64(binary.Size(data)))
+	return binary.Read(sr, r.order, data)
 }
 
+// WriteAt writes data at offset bytes into the current load command.
 func (r loadCmdReader) WriteAt(offset int64, data interface{}) error {
-	if _, err := r.f.Seek(r.offset+offset, 0); err != nil {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, r.order, data); err != nil {
+		return err
+	}
+	if err := r.check(offset, buf.Len()); err != nil {
 		return err
 	}
-	return binary.Write(r.f, r.order, data)
+	_, err := r.f.WriteAt(buf.Bytes(), r.offset+offset)
+	return err
 }
 
 // machoCombineDwarf merges dwarf info generated by dsymutil into a macho executable.
@@ -99,7 +145,7 @@ func machoCombineDwarf(ctxt *Link, exef *os.File, exem *macho.File, dsym, outexe
 		return err
 	}
 	defer dwarff.Close()
-	outf, err := os.OpenFile(outexe, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	outf, err := machoCreateOutput(outexe, 0755)
 	if err != nil {
 		return err
 	}
@@ -177,19 +223,13 @@ func machoCombineDwarf(ctxt *Link, exef *os.File, exem *macho.File, dsym, outexe
 	}
 
 	// Now we need to update the headers.
-	textsect := exem.Section("__text")
-	if textsect == nil {
-		return fmt.Errorf("missing __text section")
-	}
-
-	cmdOffset := unsafe.Sizeof(exem.FileHeader)
-	if is64bit := exem.Magic == macho.Magic64; is64bit {
-		// mach_header_64 has one extra uint32.
-		cmdOffset += unsafe.Sizeof(exem.Magic)
-	}
+	cmdOffset := machoHeaderSize(exem)
 	dwarfCmdOffset := uint32(cmdOffset) + exem.FileHeader.Cmdsz
-	availablePadding := textsect.Offset - dwarfCmdOffset
-	if availablePadding < realdwarf.Len {
+	availablePadding, err := machoHeaderSlack(exem)
+	if err != nil {
+		return err
+	}
+	if availablePadding < int64(realdwarf.Len) {
 		return fmt.Errorf("no room to add dwarf info. Need at least %d padding bytes, found %d", realdwarf.Len, availablePadding)
 	}
 	// First, copy the dwarf load command into the header. It will be
@@ -210,56 +250,80 @@ func machoCombineDwarf(ctxt *Link, exef *os.File, exem *macho.File, dsym, outexe
 		return err
 	}
 
-	reader := loadCmdReader{next: int64(cmdOffset), f: outf, order: exem.ByteOrder}
+	uuid, err := machoOutputUuid(exef, exem)
+	if err != nil {
+		return err
+	}
+	reader := loadCmdReader{next: cmdOffset, f: outf, order: exem.ByteOrder}
 	for i := uint32(0); i < exem.Ncmd; i++ {
 		cmd, err := reader.Next()
 		if err != nil {
-			return err
+			return machoLoadCmdError(i, cmd.Cmd, err)
 		}
 		linkoffset := uint64(linkstart) - linkseg.Offset
 		switch cmd.Cmd {
-		case macho.LoadCmdSegment64:
-			err = machoUpdateSegment(reader, linkseg, linkoffset)
-		case macho.LoadCmdSegment:
-			panic("unexpected 32-bit segment")
-		case LC_DYLD_INFO, LC_DYLD_INFO_ONLY:
-			err = machoUpdateLoadCommand(reader, linkseg, linkoffset, &dyldInfoCmd{}, "RebaseOff", "BindOff", "WeakBindOff", "LazyBindOff", "ExportOff")
-		case macho.LoadCmdSymtab:
-			err = machoUpdateLoadCommand(reader, linkseg, linkoffset, &macho.SymtabCmd{}, "Symoff", "Stroff")
-		case macho.LoadCmdDysymtab:
-			err = machoUpdateLoadCommand(reader, linkseg, linkoffset, &macho.DysymtabCmd{}, "Tocoffset", "Modtaboff", "Extrefsymoff", "Indirectsymoff", "Extreloff", "Locreloff")
-		case LC_CODE_SIGNATURE, LC_SEGMENT_SPLIT_INFO, LC_FUNCTION_STARTS, LC_DATA_IN_CODE, LC_DYLIB_CODE_SIGN_DRS,
-			LC_DYLD_EXPORTS_TRIE, LC_DYLD_CHAINED_FIXUPS:
-			err = machoUpdateLoadCommand(reader, linkseg, linkoffset, &linkEditDataCmd{}, "DataOff")
-		case LC_ENCRYPTION_INFO, LC_ENCRYPTION_INFO_64:
-			err = machoUpdateLoadCommand(reader, linkseg, linkoffset, &encryptionInfoCmd{}, "CryptOff")
 		case LC_UUID:
 			var u uuidCmd
 			err = reader.ReadAt(0, &u)
-			if err == nil {
-				copy(u.Uuid[:], uuidFromGoBuildId(*flagBuildid))
+			if err == nil && machoLinkSetsUuid() {
+				if machoRecordingPatches() {
+					// Relative to the combined file with the
+					// external linker's UUID.
+					uuidPatches = append(uuidPatches, machoPatch{
+						Offset: reader.PayloadOffset(),
+						Old:    append([]byte(nil), u.Uuid[:]...),
+						New:    append([]byte(nil), uuid[:16]...),
+					})
+				}
+				copy(u.Uuid[:], uuid)
 				err = reader.WriteAt(0, &u)
 			}
-		case macho.LoadCmdDylib, macho.LoadCmdThread, macho.LoadCmdUnixThread,
-			LC_PREBOUND_DYLIB, LC_VERSION_MIN_MACOSX, LC_VERSION_MIN_IPHONEOS, LC_SOURCE_VERSION,
-			LC_MAIN, LC_LOAD_DYLINKER, LC_LOAD_WEAK_DYLIB, LC_REEXPORT_DYLIB, LC_RPATH, LC_ID_DYLIB,
-			LC_SYMSEG, LC_LOADFVMLIB, LC_IDFVMLIB, LC_IDENT, LC_FVMFILE, LC_PREPAGE, LC_ID_DYLINKER,
-			LC_ROUTINES, LC_SUB_FRAMEWORK, LC_SUB_UMBRELLA, LC_SUB_CLIENT, LC_SUB_LIBRARY, LC_TWOLEVEL_HINTS,
-			LC_PREBIND_CKSUM, LC_ROUTINES_64, LC_LAZY_LOAD_DYLIB, LC_LOAD_UPWARD_DYLIB, LC_DYLD_ENVIRONMENT,
-			LC_LINKER_OPTION, LC_LINKER_OPTIMIZATION_HINT, LC_VERSION_MIN_TVOS, LC_VERSION_MIN_WATCHOS,
-			LC_VERSION_NOTE, LC_BUILD_VERSION:
-			// Nothing to update
 		default:
-			err = fmt.Errorf("unknown load command 0x%x (%s)", int(cmd.Cmd), cmd.Cmd)
+			err = machoUpdateLinkeditCmd(reader, cmd.Cmd, linkseg, linkoffset)
 		}
 		if err != nil {
-			return err
+			return machoLoadCmdError(i, cmd.Cmd, err)
 		}
 	}
 	// Do the final update of the DWARF segment's load command.
 	return machoUpdateDwarfHeader(&reader, compressedSects, dwarfsize, dwarfstart, realdwarf)
 }
 
+// machoUpdateLinkeditCmd updates the load command cmd at reader for
+// __LINKEDIT, which was at linkseg, moving by linkoffset bytes in the
+// file, which may wrap around to move it down.
+func machoUpdateLinkeditCmd(reader loadCmdReader, cmd macho.LoadCmd, linkseg *macho.Segment, linkoffset uint64) error {
+	switch cmd {
+	case macho.LoadCmdSegment64:
+		return machoUpdateSegment(reader, linkseg, linkoffset)
+	case macho.LoadCmdSegment:
+		panic("unexpected 32-bit segment")
+	case LC_DYLD_INFO, LC_DYLD_INFO_ONLY:
+		return machoUpdateLoadCommand(reader, linkseg, linkoffset, &dyldInfoCmd{}, "RebaseOff", "BindOff", "WeakBindOff", "LazyBindOff", "ExportOff")
+	case macho.LoadCmdSymtab:
+		return machoUpdateLoadCommand(reader, linkseg, linkoffset, &macho.SymtabCmd{}, "Symoff", "Stroff")
+	case macho.LoadCmdDysymtab:
+		return machoUpdateLoadCommand(reader, linkseg, linkoffset, &macho.DysymtabCmd{}, "Tocoffset", "Modtaboff", "Extrefsymoff", "Indirectsymoff", "Extreloff", "Locreloff")
+	case LC_CODE_SIGNATURE, LC_SEGMENT_SPLIT_INFO, LC_FUNCTION_STARTS, LC_DATA_IN_CODE, LC_DYLIB_CODE_SIGN_DRS,
+		LC_DYLD_EXPORTS_TRIE, LC_DYLD_CHAINED_FIXUPS:
+		return machoUpdateLoadCommand(reader, linkseg, linkoffset, &linkEditDataCmd{}, "DataOff")
+	case LC_ENCRYPTION_INFO, LC_ENCRYPTION_INFO_64:
+		return machoUpdateLoadCommand(reader, linkseg, linkoffset, &encryptionInfoCmd{}, "CryptOff")
+	case macho.LoadCmdDylib, macho.LoadCmdThread, macho.LoadCmdUnixThread,
+		LC_PREBOUND_DYLIB, LC_VERSION_MIN_MACOSX, LC_VERSION_MIN_IPHONEOS, LC_SOURCE_VERSION,
+		LC_MAIN, LC_LOAD_DYLINKER, LC_LOAD_WEAK_DYLIB, LC_REEXPORT_DYLIB, LC_RPATH, LC_ID_DYLIB,
+		LC_SYMSEG, LC_LOADFVMLIB, LC_IDFVMLIB, LC_IDENT, LC_FVMFILE, LC_PREPAGE, LC_ID_DYLINKER,
+		LC_ROUTINES, LC_SUB_FRAMEWORK, LC_SUB_UMBRELLA, LC_SUB_CLIENT, LC_SUB_LIBRARY, LC_TWOLEVEL_HINTS,
+		LC_PREBIND_CKSUM, LC_ROUTINES_64, LC_LAZY_LOAD_DYLIB, LC_LOAD_UPWARD_DYLIB, LC_DYLD_ENVIRONMENT,
+		LC_LINKER_OPTION, LC_LINKER_OPTIMIZATION_HINT, LC_VERSION_MIN_TVOS, LC_VERSION_MIN_WATCHOS,
+		LC_VERSION_NOTE, LC_BUILD_VERSION, LC_UUID:
+		// Nothing to update
+	default:
+		return fmt.Errorf("unknown load command")
+	}
+	return nil
+}
+
 // machoCompressSections tries to compress the DWARF segments in dwarfm,
 // returning the updated sections and segment contents, nils if the sections
 // weren't compressed, or an error if there was a problem reading dwarfm.
@@ -391,7 +455,7 @@ func machoUpdateSections(r loadCmdReader, seg *macho.Segment64, deltaOffset uint
 func machoUpdateDwarfHeader(r *loadCmdReader, compressedSects []*macho.Section, dwarfsize uint64, dwarfstart int64, realdwarf *macho.Segment) error {
 	cmd, err := r.Next()
 	if err != nil {
-		return err
+		return fmt.Errorf("__DWARF segment command: %w", err)
 	}
 	if cmd.Cmd != macho.LoadCmdSegment64 {
 		panic("not a Segment64")
@@ -0,0 +1,165 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file builds the export trie of Mach-O files the linker writes
+// itself, which dyld looks up the dynamically exported symbols in, for
+// dlsym and for binding other images against the output. The trie goes
+// in the export area of LC_DYLD_INFO_ONLY, or in LC_DYLD_EXPORTS_TRIE
+// with chained fixups.
+//
+// Each node of the trie is
+//
+//	uleb128 terminal size
+//	terminal info, if the size is not zero:
+//		uleb128 flags
+//		uleb128 address, relative to the Mach-O header
+//	byte    number of edges
+//	for each edge:
+//		string  edge label, NUL-terminated
+//		uleb128 offset of the child node from the start of the trie
+//
+// Edges are labelled with the longest substring their children share,
+// so that a node has a child per distinct byte that follows its prefix
+// and a name that shares no prefix with any other costs a single edge.
+// See mach-o/loader.h and dyld's MachOTrie.hpp.
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// A machoExport is a symbol to put in the export trie.
+type machoExport struct {
+	name  string // with the leading underscore
+	flags uint64 // EXPORT_SYMBOL_FLAGS_*
+	addr  uint64 // relative to the Mach-O header
+}
+
+// machoexports are the symbols machosymtab finds that are to be
+// dynamically exported.
+var machoexports []machoExport
+
+type machoTrieNode struct {
+	terminal bool
+	exp      machoExport
+	edges    []machoTrieEdge
+	off      int // offset of the node in the trie
+}
+
+type machoTrieEdge struct {
+	label string
+	child *machoTrieNode
+}
+
+// machoExportTrie returns the export trie of exps. A name that appears
+// more than once is exported at its first entry.
+func machoExportTrie(exps []machoExport) []byte {
+	if len(exps) == 0 {
+		return nil
+	}
+	exps = append([]machoExport(nil), exps...)
+	sort.SliceStable(exps, func(i, j int) bool { return exps[i].name < exps[j].name })
+	n := 0
+	for _, e := range exps {
+		if n > 0 && exps[n-1].name == e.name {
+			continue
+		}
+		exps[n] = e
+		n++
+	}
+	exps = exps[:n]
+
+	var nodes []*machoTrieNode // in the order they are written
+	var build func(exps []machoExport, prefix int) *machoTrieNode
+	build = func(exps []machoExport, prefix int) *machoTrieNode {
+		node := new(machoTrieNode)
+		nodes = append(nodes, node)
+		if len(exps[0].name) == prefix {
+			node.terminal = true
+			node.exp = exps[0]
+			exps = exps[1:]
+		}
+		for len(exps) > 0 {
+			// The names that continue with the same byte, which are
+			// adjacent since exps is sorted, hang off the same edge.
+			c := exps[0].name[prefix]
+			j := 1
+			for j < len(exps) && exps[j].name[prefix] == c {
+				j++
+			}
+			group := exps[:j]
+			exps = exps[j:]
+			first, last := group[0].name[prefix:], group[len(group)-1].name[prefix:]
+			l := 1
+			for l < len(first) && l < len(last) && first[l] == last[l] {
+				l++
+			}
+			node.edges = append(node.edges, machoTrieEdge{first[:l], nil})
+			node.edges[len(node.edges)-1].child = build(group, prefix+l)
+		}
+		return node
+	}
+	build(exps, 0)
+
+	// The offsets of the children are ULEB128s, whose sizes depend on
+	// the offsets themselves. Starting from all zeros, offsets only
+	// grow until they settle.
+	for changed := true; changed; {
+		changed = false
+		off := 0
+		for _, node := range nodes {
+			if node.off != off {
+				node.off = off
+				changed = true
+			}
+			off += node.size()
+		}
+	}
+
+	var b []byte
+	for _, node := range nodes {
+		if node.terminal {
+			info := binary.AppendUvarint(nil, node.exp.flags)
+			info = binary.AppendUvarint(info, node.exp.addr)
+			b = binary.AppendUvarint(b, uint64(len(info)))
+			b = append(b, info...)
+		} else {
+			b = append(b, 0)
+		}
+		b = append(b, byte(len(node.edges)))
+		for _, e := range node.edges {
+			b = append(b, e.label...)
+			b = append(b, 0)
+			b = binary.AppendUvarint(b, uint64(e.child.off))
+		}
+	}
+	return b
+}
+
+// size returns the size of the encoding of node, given the offsets of
+// its children.
+func (node *machoTrieNode) size() int {
+	n := 1
+	if node.terminal {
+		info := ulebSize(node.exp.flags) + ulebSize(node.exp.addr)
+		n = ulebSize(uint64(info)) + info
+	}
+	n++ // number of edges
+	for _, e := range node.edges {
+		n += len(e.label) + 1 + ulebSize(uint64(e.child.off))
+	}
+	return n
+}
+
+// ulebSize returns the size of the ULEB128 encoding of v.
+func ulebSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
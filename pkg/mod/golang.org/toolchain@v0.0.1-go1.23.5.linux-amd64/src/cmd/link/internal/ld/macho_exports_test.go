@@ -0,0 +1,120 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// walkTestExportTrie decodes the export trie b, as dyld walks it, and
+// calls f with each name it exports. It also returns the number of
+// nodes in the trie.
+func walkTestExportTrie(t *testing.T, b []byte, f func(machoExport)) int {
+	t.Helper()
+	uleb := func(off *int) uint64 {
+		v, n := binary.Uvarint(b[*off:])
+		if n <= 0 {
+			t.Fatalf("bad ULEB128 at %#x", *off)
+		}
+		*off += n
+		return v
+	}
+	nodes := 0
+	visited := make(map[int]bool)
+	var walk func(off int, prefix string)
+	walk = func(off int, prefix string) {
+		if off >= len(b) || visited[off] {
+			t.Fatalf("bad or shared node offset %#x", off)
+		}
+		visited[off] = true
+		nodes++
+		if size := int(uleb(&off)); size > 0 {
+			end := off + size
+			e := machoExport{name: prefix}
+			e.flags = uleb(&off)
+			e.addr = uleb(&off)
+			if off != end {
+				t.Fatalf("terminal info of %q is %d bytes, want %d", prefix, size-(end-off), size)
+			}
+			f(e)
+		}
+		n := int(b[off])
+		off++
+		for i := 0; i < n; i++ {
+			j := off
+			for b[j] != 0 {
+				j++
+			}
+			label := string(b[off:j])
+			if label == "" {
+				t.Fatalf("empty edge label after %q", prefix)
+			}
+			off = j + 1
+			child := int(uleb(&off))
+			walk(child, prefix+label)
+		}
+	}
+	walk(0, "")
+	return nodes
+}
+
+func TestMachoExportTrie(t *testing.T) {
+	if b := machoExportTrie(nil); b != nil {
+		t.Errorf("trie with no exports is % x, want none", b)
+	}
+
+	exps := []machoExport{
+		{name: "_main.F", addr: 0x1000},
+		{name: "_main.Foo", addr: 0x1010},
+		{name: "_main.Foobar", addr: 0x1020},
+		{name: "_main.G", addr: 0x2000},
+		{name: "_x_cgo_init", addr: 0x3000},
+		{name: "_x", flags: EXPORT_SYMBOL_FLAGS_KIND_ABSOLUTE, addr: 0x42},
+		{name: "_main.G", addr: 0x9999}, // duplicate, dropped
+	}
+	// Enough names for child offsets to need more than one ULEB128
+	// byte, and for the sizes to take more than a round to settle.
+	for i := 0; i < 300; i++ {
+		exps = append(exps, machoExport{name: fmt.Sprintf("_sym%d", i), addr: uint64(0x10000 + 16*i)})
+	}
+	b := machoExportTrie(exps)
+
+	want := make(map[string]machoExport)
+	for _, e := range exps {
+		if _, ok := want[e.name]; !ok {
+			want[e.name] = e
+		}
+	}
+	got := make(map[string]machoExport)
+	nodes := walkTestExportTrie(t, b, func(e machoExport) {
+		if _, ok := got[e.name]; ok {
+			t.Errorf("%q exported twice", e.name)
+		}
+		got[e.name] = e
+	})
+	for name, e := range want {
+		if g, ok := got[name]; !ok {
+			t.Errorf("%q not exported", name)
+		} else if g != e {
+			t.Errorf("%q exported as %+v, want %+v", name, g, e)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("trie exports %d names, want %d", len(got), len(want))
+	}
+
+	// Names share nodes for their common prefixes: besides the root,
+	// the trie has a node per name, and one per prefix where names
+	// part without one ending there, here "_" and "_main.".
+	small := machoExportTrie(exps[:6])
+	if n := walkTestExportTrie(t, small, func(machoExport) {}); n != 1+6+2 {
+		t.Errorf("trie of 6 names has %d nodes, want %d", n, 1+6+2)
+	}
+	if nodes >= len(exps)*2 {
+		t.Errorf("trie of %d names has %d nodes", len(want), nodes)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -machofat, which combines the Mach-O output with
+// the outputs of links of the same program for other architectures into
+// a universal (fat) binary, as lipo -create would. The link is for one
+// architecture, so the others are linked first and named on the command
+// line of the last. The slices are laid out in an order and at offsets
+// that depend only on their contents, not on the order they are given
+// in, so the fat binary is as reproducible as its slices.
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"unsafe"
+)
+
+// machoFatAlign returns the log2 alignment of a cpu slice in a fat
+// file: the 16 KiB page size for ARM, whose kernels map slices in
+// pages of that size, and 4 KiB otherwise, as lipo uses.
+func machoFatAlign(cpu macho.Cpu) uint32 {
+	switch cpu {
+	case macho.CpuArm, macho.CpuArm64:
+		return 14
+	}
+	return 12
+}
+
+// A machoFatInput is a thin Mach-O file to be a slice of a fat one.
+type machoFatInput struct {
+	Name string // for error messages
+	Data []byte
+	Exem *macho.File // header and load commands of Data
+}
+
+// machoReadFatInput reads the thin Mach-O file at path for machoFat.
+func machoReadFatInput(path string) (machoFatInput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return machoFatInput{}, err
+	}
+	if len(data) >= 4 && binary.BigEndian.Uint32(data) == macho.MagicFat {
+		return machoFatInput{}, fmt.Errorf("%s is already a universal binary", path)
+	}
+	exem, err := machoParseRaw(bytes.NewReader(data))
+	if err != nil {
+		return machoFatInput{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return machoFatInput{path, data, exem}, nil
+}
+
+// machoFat returns the fat Mach-O file whose slices are the thin files
+// inputs. The slices must all be of the same file type and for
+// different cpus, and no two may have the same LC_UUID: tools that find
+// the symbols of a slice by its UUID could not tell them apart. Each
+// slice is aligned as machoFatAlign says, and they are sorted by
+// alignment and then cpu type, as lipo sorts them, which keeps the
+// padding between them small.
+func machoFat(inputs []machoFatInput) ([]byte, error) {
+	if len(inputs) < 2 {
+		return nil, fmt.Errorf("a universal binary needs at least two architectures, have %d", len(inputs))
+	}
+	sorted := append([]machoFatInput(nil), inputs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Exem, sorted[j].Exem
+		if ai, bi := machoFatAlign(a.Cpu), machoFatAlign(b.Cpu); ai != bi {
+			return ai < bi
+		}
+		if a.Cpu != b.Cpu {
+			return a.Cpu < b.Cpu
+		}
+		return a.SubCpu < b.SubCpu
+	})
+	first := sorted[0]
+	uuids := make(map[[16]byte]string)
+	for i, in := range sorted {
+		if in.Exem.Type != first.Exem.Type {
+			return nil, fmt.Errorf("%s has file type %v, but %s has %v", in.Name, in.Exem.Type, first.Name, first.Exem.Type)
+		}
+		if i > 0 {
+			prev := sorted[i-1]
+			// The high byte of the subtype holds capability bits,
+			// such as the 64-bit library flag, not the cpu variant.
+			if in.Exem.Cpu == prev.Exem.Cpu && in.Exem.SubCpu&^0xff000000 == prev.Exem.SubCpu&^0xff000000 {
+				return nil, fmt.Errorf("%s and %s are both for %s", prev.Name, in.Name, machoArchName(in.Exem.Cpu))
+			}
+		}
+		if u, ok := machoFileUuid(in.Exem); ok {
+			if other, dup := uuids[u]; dup {
+				return nil, fmt.Errorf("%s and %s have the same UUID %s; give each architecture its own, for example with -uuidpercpu", other, in.Name, machoUuidString(u))
+			}
+			uuids[u] = in.Name
+		}
+	}
+
+	var hdr, body bytes.Buffer
+	binary.Write(&hdr, binary.BigEndian, [2]uint32{macho.MagicFat, uint32(len(sorted))})
+	off := int64(hdr.Len()) + int64(len(sorted))*int64(unsafe.Sizeof(macho.FatArchHeader{}))
+	start := off
+	for _, in := range sorted {
+		align := machoFatAlign(in.Exem.Cpu)
+		off = Rnd(off, 1<<align)
+		end := off + int64(len(in.Data))
+		if end > 1<<32-1 {
+			// The fat_arch offsets and sizes are 32 bits; lipo's
+			// fat_arch_64 variant is not supported.
+			return nil, fmt.Errorf("%s ends at %#x, past the 4 GiB a universal binary can hold", in.Name, end)
+		}
+		binary.Write(&hdr, binary.BigEndian, macho.FatArchHeader{
+			Cpu:    in.Exem.Cpu,
+			SubCpu: in.Exem.SubCpu,
+			Offset: uint32(off),
+			Size:   uint32(len(in.Data)),
+			Align:  align,
+		})
+		body.Write(make([]byte, off-start-int64(body.Len())))
+		body.Write(in.Data)
+		off = end
+	}
+	return append(hdr.Bytes(), body.Bytes()...), nil
+}
+
+// machoMergeFat replaces the thin Mach-O file out with a fat one whose
+// slices are out and the thin files others, for -machofat.
+func machoMergeFat(out string, others []string) error {
+	var inputs []machoFatInput
+	for _, path := range append([]string{out}, others...) {
+		in, err := machoReadFatInput(path)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, in)
+	}
+	fat, err := machoFat(inputs)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		return err
+	}
+	tmp := out + "~"
+	if err := os.WriteFile(tmp, fat, fi.Mode().Perm()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return machoReplaceOutput(tmp, out)
+}
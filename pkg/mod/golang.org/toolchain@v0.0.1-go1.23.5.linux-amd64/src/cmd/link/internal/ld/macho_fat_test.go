@@ -0,0 +1,101 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/macho"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMachoMergeFat(t *testing.T) {
+	amd := newTestMachO(testUuid)
+	arm := newTestMachO([16]byte{1})
+	arm.cpu, arm.subcpu = macho.CpuArm64, 0
+	dir := t.TempDir()
+	write := func(name string, img []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, img, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// The result is the same whichever slice is the link's output.
+	var fats [][]byte
+	for _, pair := range [][2]*testMachO{{amd, arm}, {arm, amd}} {
+		out := write("out", pair[0].bytes())
+		if err := machoMergeFat(out, []string{write("other", pair[1].bytes())}); err != nil {
+			t.Fatal(err)
+		}
+		fat, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fats = append(fats, fat)
+	}
+	if !bytes.Equal(fats[0], fats[1]) {
+		t.Error("universal binary depends on which slice is the output")
+	}
+
+	// x86_64 comes first, each slice is aligned for its cpu, and each
+	// is the thin file byte for byte.
+	ff, err := macho.NewFatFile(bytes.NewReader(fats[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []macho.FatArchHeader
+	for _, a := range ff.Arches {
+		got = append(got, a.FatArchHeader)
+	}
+	want := []macho.FatArchHeader{
+		{Cpu: macho.CpuAmd64, SubCpu: 3, Offset: 0x1000, Size: 0x600, Align: 12},
+		{Cpu: macho.CpuArm64, SubCpu: 0, Offset: 0x4000, Size: 0x600, Align: 14},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("arch headers %+v, want %+v", got, want)
+	}
+	if !bytes.Equal(fats[0][0x1000:0x1600], amd.bytes()) || !bytes.Equal(fats[0][0x4000:], arm.bytes()) {
+		t.Error("slices are not the thin files")
+	}
+	uuids, err := machoReadUuids(testMachOBuf(fats[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uuids) != 2 || uuids[0].Uuid != testUuid || uuids[1].Uuid != [16]byte{1} {
+		t.Errorf("UUIDs %+v, want the slices' own", uuids)
+	}
+
+	armSameUuid := newTestMachO(testUuid)
+	armSameUuid.cpu, armSameUuid.subcpu = macho.CpuArm64, 0
+	dylib := newTestMachO([16]byte{2})
+	dylib.cpu, dylib.subcpu = macho.CpuArm64, 0
+	dylib.filetype = macho.TypeDylib
+	for _, tc := range []struct {
+		name  string
+		other []byte
+		want  string
+	}{
+		{"same cpu", newTestMachO([16]byte{2}).bytes(), "both for x86_64"},
+		{"same uuid", armSameUuid.bytes(), "same UUID"},
+		{"file type", dylib.bytes(), "file type"},
+		{"fat", fats[0], "already a universal binary"},
+		{"not macho", []byte("#!/bin/sh\n"), "not a Mach-O file"},
+	} {
+		out := write("out", amd.bytes())
+		err := machoMergeFat(out, []string{write("other", tc.other)})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want one mentioning %q", tc.name, err, tc.want)
+		}
+		if img, _ := os.ReadFile(out); !bytes.Equal(img, amd.bytes()) {
+			t.Errorf("%s: output changed by a failed merge", tc.name)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains a -reproducible pass that trims zero padding the
+// external linker leaves at the end of __LINKEDIT, whose amount can
+// vary between links (see macho_canonicalize.go).
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// machoLinkeditEnd returns the end of the last range of file data that
+// cmds refer to from outside the segments and sections: symbol and
+// string tables, dyld information, code signatures and the like. All
+// of it normally lives in __LINKEDIT. is64 selects the size of symbol
+// table entries.
+func machoLinkeditEnd(order binary.ByteOrder, is64 bool, cmds []machoLoadCmd) (int64, error) {
+	var end int64
+	extend := func(e int64) {
+		if e > end {
+			end = e
+		}
+	}
+	// use records that c refers to the elem*n bytes at the offset
+	// stored at offAt in c, where n is stored at countAt.
+	use := func(c machoLoadCmd, offAt, countAt int, elem int64) error {
+		if len(c.Data) < countAt+4 {
+			return fmt.Errorf("load command %#x at %#x too short (%d bytes)", uint32(c.Cmd), c.Offset, len(c.Data))
+		}
+		off := int64(order.Uint32(c.Data[offAt:]))
+		if n := int64(order.Uint32(c.Data[countAt:])); n > 0 {
+			extend(off + n*elem)
+		}
+		return nil
+	}
+	nlist := int64(12)
+	modtab := int64(52)
+	if is64 {
+		nlist = 16
+		modtab = 56
+	}
+	for _, c := range cmds {
+		var err error
+		switch c.Cmd {
+		case LC_SYMTAB:
+			if err = use(c, 8, 12, nlist); err == nil {
+				err = use(c, 16, 20, 1)
+			}
+		case LC_DYSYMTAB:
+			// struct dysymtab_command: table offsets and counts.
+			for _, f := range []struct {
+				at   int
+				elem int64
+			}{
+				{32, 8},      // table of contents
+				{40, modtab}, // module table
+				{48, 4},      // referenced symbols
+				{56, 4},      // indirect symbols
+				{64, 8},      // external relocations
+				{72, 8},      // local relocations
+			} {
+				if err = use(c, f.at, f.at+4, f.elem); err != nil {
+					break
+				}
+			}
+		case LC_DYLD_INFO, LC_DYLD_INFO_ONLY:
+			// rebase, bind, weak bind, lazy bind and export info.
+			for at := 8; at <= 40 && err == nil; at += 8 {
+				err = use(c, at, at+4, 1)
+			}
+		case LC_CODE_SIGNATURE, LC_SEGMENT_SPLIT_INFO, LC_FUNCTION_STARTS, LC_DATA_IN_CODE,
+			LC_DYLIB_CODE_SIGN_DRS, LC_LINKER_OPTIMIZATION_HINT, LC_DYLD_EXPORTS_TRIE, LC_DYLD_CHAINED_FIXUPS:
+			// struct linkedit_data_command
+			err = use(c, 8, 12, 1)
+		case LC_VERSION_NOTE:
+			if len(c.Data) < 40 {
+				return 0, fmt.Errorf("LC_NOTE at %#x too short (%d bytes)", c.Offset, len(c.Data))
+			}
+			extend(int64(order.Uint64(c.Data[24:]) + order.Uint64(c.Data[32:])))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return end, nil
+}
+
+// machoStripLinkeditPadding removes the zero bytes at the end of the
+// __LINKEDIT segment of the file behind rw that no load command refers
+// to, shrinking the segment and truncating the file to match. It does
+// nothing if __LINKEDIT is not at the end of the file or if the bytes
+// past its last known content are not all zero, since then they are
+// not padding.
+//
+// Like any change to the header, this invalidates an existing code
+// signature.
+func machoStripLinkeditPadding(rw *machoRewriter) error {
+	e, err := machoPlanLinkeditPadding(rw)
+	if err != nil {
+		return err
+	}
+	return rw.Apply(e)
+}
+
+// machoPlanLinkeditPadding returns the changes machoStripLinkeditPadding
+// makes.
+func machoPlanLinkeditPadding(rw *machoRewriter) (*machoEdits, error) {
+	exem := rw.File()
+	e := new(machoEdits)
+	seg := exem.Segment("__LINKEDIT")
+	if seg == nil {
+		return e, nil
+	}
+	cmds, err := machoReadLoadCmds(rw.f, exem)
+	if err != nil {
+		return nil, err
+	}
+	is64 := exem.Magic == macho.Magic64
+	end, err := machoLinkeditEnd(exem.ByteOrder, is64, cmds)
+	if err != nil {
+		return nil, err
+	}
+	segStart := int64(seg.Offset)
+	segEnd := segStart + int64(seg.Filesz)
+	if end < segStart || end >= segEnd {
+		return e, nil
+	}
+	var b [1]byte
+	if _, err := rw.f.ReadAt(b[:], segEnd); err != io.EOF {
+		return e, err // __LINKEDIT is not at the end of the file
+	}
+	pad := make([]byte, segEnd-end)
+	if _, err := rw.f.ReadAt(pad, end); err != nil {
+		return nil, err
+	}
+	if len(bytes.Trim(pad, "\x00")) != 0 {
+		return e, nil
+	}
+
+	var segCmd *machoLoadCmd
+	for i := range cmds {
+		if (cmds[i].Cmd == LC_SEGMENT || cmds[i].Cmd == LC_SEGMENT_64) && len(cmds[i].Data) >= 24 {
+			if name, _, _ := bytes.Cut(cmds[i].Data[8:24], []byte{0}); string(name) == "__LINKEDIT" {
+				segCmd = &cmds[i]
+				break
+			}
+		}
+	}
+	if segCmd == nil {
+		return nil, fmt.Errorf("cannot find __LINKEDIT load command")
+	}
+	filesz := uint64(end - segStart)
+	memsz := uint64(Rnd(int64(filesz), machoPageSize(exem.Cpu)))
+	if memsz > seg.Memsz {
+		memsz = seg.Memsz
+	}
+	var buf bytes.Buffer
+	vmsizeAt := int64(32)
+	if is64 {
+		// vmsize, fileoff, filesize of struct segment_command_64.
+		binary.Write(&buf, exem.ByteOrder, []uint64{memsz, seg.Offset, filesz})
+	} else {
+		binary.Write(&buf, exem.ByteOrder, []uint32{uint32(memsz), uint32(seg.Offset), uint32(filesz)})
+		vmsizeAt = 28
+	}
+	if err := e.patch(rw.f, segCmd.Offset+vmsizeAt, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	e.Truncate = end
+	return e, nil
+}
+
+// machoPageSize returns the page size that Mach-O segments for cpu are
+// aligned to.
+func machoPageSize(cpu macho.Cpu) int64 {
+	if cpu == macho.CpuArm64 {
+		return 0x4000
+	}
+	return 0x1000
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// symtab returns an LC_SYMTAB command for nsyms symbols at symoff and a
+// string table of strsize bytes at stroff.
+func (m *testMachO) symtab(symoff, nsyms, stroff, strsize uint32) []byte {
+	payload := make([]byte, 16)
+	m.order.PutUint32(payload[0:], symoff)
+	m.order.PutUint32(payload[4:], nsyms)
+	m.order.PutUint32(payload[8:], stroff)
+	m.order.PutUint32(payload[12:], strsize)
+	return m.raw(LC_SYMTAB, payload)
+}
+
+func TestMachoStripLinkeditPadding(t *testing.T) {
+	// __LINKEDIT is [0x500, 0x600): two symbols and a 0x20-byte string
+	// table, followed by 0xc0 bytes of zero padding.
+	m := newTestMachO(testUuid)
+	m.cmds = append(m.cmds, m.symtab(0x500, 2, 0x520, 0x20))
+	padded := m.bytes()
+	clear(padded[0x500:])
+
+	for _, tc := range []struct {
+		name     string
+		tail     byte // last byte of __LINKEDIT
+		wantSize int64
+	}{
+		{"padding", 0, 0x540},
+		{"data", 1, 0x600},
+	} {
+		img := append([]byte(nil), padded...)
+		img[len(img)-1] = tc.tail
+		path := filepath.Join(t.TempDir(), "exe")
+		if err := os.WriteFile(path, img, 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw, err := newMachoRewriter(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = machoStripLinkeditPadding(rw)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		out, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(out)) != tc.wantSize {
+			t.Errorf("%s: file is %#x bytes, want %#x", tc.name, len(out), tc.wantSize)
+		}
+		if !bytes.Equal(out[0x500:min(len(out), 0x540)], img[0x500:0x540]) {
+			t.Errorf("%s: symbol table data changed", tc.name)
+		}
+		seg := parseTestMachO(t, out).Segment("__LINKEDIT")
+		if want := uint64(tc.wantSize - 0x500); seg.Filesz != want {
+			t.Errorf("%s: __LINKEDIT filesize = %#x, want %#x", tc.name, seg.Filesz, want)
+		}
+		if seg.Memsz != 0x1000 {
+			t.Errorf("%s: __LINKEDIT vmsize = %#x, want 0x1000", tc.name, seg.Memsz)
+		}
+	}
+}
+
+func TestMachoLinkeditEndSignature(t *testing.T) {
+	// The code signature is real data even where it is zero.
+	m := newTestMachO(testUuid)
+	m.cmds = append(m.cmds, m.symtab(0x500, 1, 0x510, 0x10))
+	img := m.signed()
+	clear(img[0x500:0x520])
+	exem := parseTestMachO(t, img)
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := machoLinkeditEnd(exem.ByteOrder, true, cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end != int64(len(img)) {
+		t.Errorf("end of __LINKEDIT data = %#x, want end of signature %#x", end, len(img))
+	}
+}
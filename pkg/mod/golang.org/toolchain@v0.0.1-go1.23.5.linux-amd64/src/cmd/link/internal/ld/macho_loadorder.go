@@ -0,0 +1,109 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the -reproorder pass, which sorts the load
+// commands of the external linker's output into a canonical order, for
+// linkers that emit the same commands in an order that varies between
+// runs (see macho_canonicalize.go).
+//
+// Only commands whose position carries no meaning are sorted. The
+// others keep their order relative to each other: segments, whose
+// index sections and dyld information refer to; dylib loads, whose
+// order defines library ordinals; and LC_RPATH and LC_DYLD_ENVIRONMENT,
+// which dyld processes in order.
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+	"sort"
+)
+
+// Positions of load commands in the canonical order, in the order they
+// are placed.
+const (
+	machoOrderSegment = iota
+	machoOrderSorted
+	machoOrderDylib
+	machoOrderRpath
+	machoOrderDyldEnv
+	machoOrderSignature
+)
+
+// machoLoadCmdPosition returns where cmd goes in the canonical order of
+// the load commands, and false if the pass does not know whether its
+// position matters.
+func machoLoadCmdPosition(cmd macho.LoadCmd) (int, bool) {
+	switch cmd {
+	case LC_SEGMENT, LC_SEGMENT_64:
+		return machoOrderSegment, true
+	case LC_LOAD_DYLIB, LC_LOAD_WEAK_DYLIB, LC_REEXPORT_DYLIB, LC_LAZY_LOAD_DYLIB, LC_LOAD_UPWARD_DYLIB:
+		return machoOrderDylib, true
+	case LC_RPATH:
+		return machoOrderRpath, true
+	case LC_DYLD_ENVIRONMENT:
+		return machoOrderDyldEnv, true
+	case LC_CODE_SIGNATURE:
+		// ld64 and codesign put it last.
+		return machoOrderSignature, true
+	case LC_SYMTAB, LC_DYSYMTAB, LC_UNIXTHREAD, LC_ID_DYLIB, LC_LOAD_DYLINKER, LC_UUID,
+		LC_SEGMENT_SPLIT_INFO, LC_ENCRYPTION_INFO, LC_ENCRYPTION_INFO_64, LC_DYLD_INFO, LC_DYLD_INFO_ONLY,
+		LC_VERSION_MIN_MACOSX, LC_VERSION_MIN_IPHONEOS, LC_FUNCTION_STARTS, LC_MAIN, LC_DATA_IN_CODE,
+		LC_SOURCE_VERSION, LC_DYLIB_CODE_SIGN_DRS, LC_LINKER_OPTIMIZATION_HINT, LC_VERSION_NOTE,
+		LC_BUILD_VERSION, LC_DYLD_EXPORTS_TRIE, LC_DYLD_CHAINED_FIXUPS:
+		return machoOrderSorted, true
+	}
+	return 0, false
+}
+
+// machoPlanLoadCmdOrder returns the change that rewrites the load
+// command region of the file behind rw with its commands in canonical
+// order: segments, then the commands whose position does not matter,
+// sorted by type and contents, then dylib loads, LC_RPATH and
+// LC_DYLD_ENVIRONMENT commands, then LC_CODE_SIGNATURE. Commands within
+// each group other than the sorted one keep the order they had. A file
+// with a command the pass does not know is left alone, with an error,
+// rather than risk moving it.
+func machoPlanLoadCmdOrder(rw *machoRewriter) (*machoEdits, error) {
+	exem := rw.File()
+	cmds, err := machoReadLoadCmds(rw.f, exem)
+	if err != nil {
+		return nil, err
+	}
+	pos := make([]int, len(cmds))
+	for i, c := range cmds {
+		p, ok := machoLoadCmdPosition(c.Cmd)
+		if !ok {
+			return nil, fmt.Errorf("load command %#x at %#x may not be moved", uint32(c.Cmd), c.Offset)
+		}
+		pos[i] = p
+	}
+	idx := make([]int, len(cmds))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := idx[i], idx[j]
+		if pos[a] != pos[b] {
+			return pos[a] < pos[b]
+		}
+		if pos[a] != machoOrderSorted {
+			return false // keep the given order
+		}
+		if cmds[a].Cmd != cmds[b].Cmd {
+			return cmds[a].Cmd < cmds[b].Cmd
+		}
+		return bytes.Compare(cmds[a].Data, cmds[b].Data) < 0
+	})
+
+	var region []byte
+	for _, i := range idx {
+		region = append(region, cmds[i].Data...)
+	}
+	cmdOffset := machoHeaderSize(exem)
+	e := new(machoEdits)
+	return e, e.patch(rw.f, cmdOffset, region)
+}
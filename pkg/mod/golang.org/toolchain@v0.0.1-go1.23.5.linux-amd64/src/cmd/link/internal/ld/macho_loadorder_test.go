@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/macho"
+	"testing"
+)
+
+func TestMachoPlanLoadCmdOrder(t *testing.T) {
+	m := newTestMachO(testUuid)
+	segs, uuid := m.cmds[:3], m.cmds[3]
+	buildVersion := m.raw(LC_BUILD_VERSION, make([]byte, 16))
+	sourceVersion := m.raw(LC_SOURCE_VERSION, make([]byte, 8))
+	main := m.raw(LC_MAIN, make([]byte, 16))
+	funcStarts := m.raw(LC_FUNCTION_STARTS, make([]byte, 8))
+	libA := m.dylib(LC_LOAD_DYLIB, "/usr/lib/libSystem.B.dylib")
+	libB := m.dylib(LC_LOAD_WEAK_DYLIB, "/usr/lib/libresolv.9.dylib")
+	rpath := m.raw(LC_RPATH, append([]byte{12, 0, 0, 0}, "@loader_path\x00"...))
+
+	canonicalize := func(cmds ...[]byte) []byte {
+		t.Helper()
+		m.cmds = append(append([][]byte(nil), segs...), cmds...)
+		img := m.bytes()
+		rw, err := newMachoRewriter(testMachOBuf(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := machoPlanLoadCmdOrder(rw)
+		if err == nil {
+			err = rw.Apply(e)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, err := machoPlanLoadCmdOrder(rw); err != nil || !e.Empty() {
+			t.Errorf("canonical file still has changes to make: %v, %v", e, err)
+		}
+		return img
+	}
+
+	// The same commands in two orders, with the dylibs in the same
+	// order relative to each other.
+	a := canonicalize(uuid, buildVersion, libA, main, rpath, libB, sourceVersion, funcStarts)
+	b := canonicalize(sourceVersion, libA, libB, funcStarts, rpath, main, uuid, buildVersion)
+	if !bytes.Equal(a, b) {
+		t.Error("inputs differing only in command order have different canonical forms")
+	}
+	m.cmds = append(append([][]byte(nil), segs...), uuid, funcStarts, sourceVersion, buildVersion, main, libA, libB, rpath)
+	if want := m.bytes(); !bytes.Equal(a, want) {
+		t.Error("canonical form does not have the commands in the expected order")
+	}
+	if exem := parseTestMachO(t, a); !bytes.Equal(testReadUuid(t, exem), testUuid[:]) {
+		t.Errorf("canonical form has the wrong UUID")
+	}
+
+	// Dylib loads are not reordered: that would renumber the library
+	// ordinals.
+	c := canonicalize(uuid, libB, libA)
+	m.cmds = append(append([][]byte(nil), segs...), uuid, libB, libA)
+	if want := m.bytes(); !bytes.Equal(c, want) {
+		t.Error("dylib loads were reordered")
+	}
+
+	// A command the pass knows nothing about is not moved.
+	m.cmds = append(append([][]byte(nil), segs...), m.filler(16), uuid)
+	rw, err := newMachoRewriter(testMachOBuf(m.bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := machoPlanLoadCmdOrder(rw); err == nil {
+		t.Error("reordering a file with an unknown load command succeeded")
+	}
+}
+
+func TestMachoLoadCmdPosition(t *testing.T) {
+	// The commands ld64 emits, and those the other passes add, can be
+	// placed; the others are left where they are.
+	for _, cmd := range []macho.LoadCmd{LC_UUID, LC_BUILD_VERSION, LC_SOURCE_VERSION, LC_ID_DYLIB, LC_VERSION_NOTE, LC_CODE_SIGNATURE} {
+		if _, ok := machoLoadCmdPosition(cmd); !ok {
+			t.Errorf("position of load command %#x unknown", uint32(cmd))
+		}
+	}
+	for _, cmd := range []macho.LoadCmd{LC_IDENT, LC_THREAD, 0x12345} {
+		if _, ok := machoLoadCmdPosition(cmd); ok {
+			t.Errorf("load command %#x may be moved", uint32(cmd))
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the -uuidpatch record of the bytes that the
+// LC_UUID rewrite (see macho_update_uuid.go) changed in the external
+// linker's output. A third party can check the record against the
+// final binary, re-apply it to the external linker's output, or revert
+// it, without trusting the Go linker. The linker only writes records;
+// the reader in the tests is the reference for the format.
+// -uuidmanifest writes a record in the same format for a rewrite the
+// linker leaves for a later build step to make; with -uuidmanifestmin
+// that record has just the LC_UUID bytes.
+//
+// The record is little-endian:
+//
+//	magic   [8]byte = "GOUUIDP\x01"
+//	count   uint32
+//	count patches, each
+//		offset  uint64  file offset of the change
+//		size    uint32
+//		old     [size]byte
+//		new     [size]byte
+//
+// Patches do not overlap.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const machoPatchRecordMagic = "GOUUIDP\x01"
+
+// uuidPatches holds the changes the LC_UUID rewrite made to the output,
+// for -uuidpatch and -reproreport.
+var uuidPatches []machoPatch
+
+// A machoPatchRecorder is a machoReadWriterAt that records every write
+// made through it as a machoPatch.
+type machoPatchRecorder struct {
+	f       machoReadWriterAt
+	patches []machoPatch
+}
+
+func (r *machoPatchRecorder) ReadAt(p []byte, off int64) (int, error) {
+	return r.f.ReadAt(p, off)
+}
+
+func (r *machoPatchRecorder) WriteAt(p []byte, off int64) (int, error) {
+	old := make([]byte, len(p))
+	if _, err := r.f.ReadAt(old, off); err != nil {
+		return 0, err
+	}
+	n, err := r.f.WriteAt(p, off)
+	if err != nil || bytes.Equal(old, p) {
+		return n, err
+	}
+	for i := range r.patches {
+		// A rewrite of bytes written before, such as a page hash of
+		// the code signature updated twice, replaces the first write.
+		if q := &r.patches[i]; q.Offset == off && len(q.New) == len(p) {
+			q.New = append(q.New[:0], p...)
+			return n, nil
+		}
+	}
+	r.patches = append(r.patches, machoPatch{Offset: off, Old: old, New: append([]byte(nil), p...)})
+	return n, nil
+}
+
+// A machoPatchOverlay is a machoReadWriterAt that keeps the writes made
+// through it as patches over f, which it never modifies. Reads see
+// those patches.
+type machoPatchOverlay struct {
+	f       io.ReaderAt
+	patches []machoPatch
+}
+
+func (o *machoPatchOverlay) ReadAt(p []byte, off int64) (int, error) {
+	n, err := o.f.ReadAt(p, off)
+	for _, q := range o.patches {
+		lo, hi := q.Offset, q.Offset+int64(len(q.New))
+		if lo < off {
+			lo = off
+		}
+		if end := off + int64(n); hi > end {
+			hi = end
+		}
+		if lo < hi {
+			copy(p[lo-off:hi-off], q.New[lo-q.Offset:])
+		}
+	}
+	return n, err
+}
+
+func (o *machoPatchOverlay) WriteAt(p []byte, off int64) (int, error) {
+	old := make([]byte, len(p))
+	if _, err := o.ReadAt(old, off); err != nil {
+		return 0, err
+	}
+	if bytes.Equal(old, p) {
+		return len(p), nil
+	}
+	end := off + int64(len(p))
+	for i := range o.patches {
+		q := &o.patches[i]
+		if q.Offset == off && len(q.New) == len(p) {
+			q.New = append(q.New[:0], p...)
+			return len(p), nil
+		}
+		if off < q.Offset+int64(len(q.New)) && q.Offset < end {
+			return 0, fmt.Errorf("write of %d bytes at %#x partly overlaps an earlier one", len(p), off)
+		}
+	}
+	o.patches = append(o.patches, machoPatch{Offset: off, Old: old, New: append([]byte(nil), p...)})
+	return len(p), nil
+}
+
+// machoUuidManifest returns the changes that setting the LC_UUID of the
+// Mach-O file f would make, including the repair of an ad hoc code
+// signature, without making them.
+func machoUuidManifest(f io.ReaderAt) ([]machoPatch, error) {
+	o := &machoPatchOverlay{f: f}
+	rw, err := newMachoRewriter(o)
+	if err != nil {
+		return nil, err
+	}
+	uuid, err := machoOutputUuid(rw.f, rw.File())
+	if err != nil {
+		return nil, err
+	}
+	if err := rw.UpdateUuid(uuid); err != nil {
+		return nil, err
+	}
+	return o.patches, nil
+}
+
+// machoUuidMinimalManifest is machoUuidManifest for -uuidmanifestmin:
+// it returns only the change to the LC_UUID payload, the 16 bytes at
+// their offset, leaving the code signature that the change invalidates
+// to the step that applies it, which is expected to sign the file.
+func machoUuidMinimalManifest(f io.ReaderAt) ([]machoPatch, error) {
+	rw, err := newMachoRewriter(&machoPatchOverlay{f: f})
+	if err != nil {
+		return nil, err
+	}
+	uuid, err := machoOutputUuid(rw.f, rw.File())
+	if err != nil {
+		return nil, err
+	}
+	e, err := machoPlanUuid(rw, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return e.Patches, nil
+}
+
+// machoWriteUuidManifest writes the -uuidmanifest record of the LC_UUID
+// rewrite of the Mach-O file exe to path. exe is only read, so it can
+// be read-only to the linker.
+func machoWriteUuidManifest(path, exe string) error {
+	f, err := os.Open(exe)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	manifest := machoUuidManifest
+	if *flagUuidManifestMin {
+		manifest = machoUuidMinimalManifest
+	}
+	patches, err := manifest(f)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := machoWritePatchRecord(&buf, patches); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0666)
+}
+
+// machoWritePatchRecord writes patches to w in the format described at
+// the top of this file.
+func machoWritePatchRecord(w io.Writer, patches []machoPatch) error {
+	var buf bytes.Buffer
+	buf.WriteString(machoPatchRecordMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(patches)))
+	for _, p := range patches {
+		if len(p.Old) != len(p.New) {
+			return fmt.Errorf("patch at %#x changes the size of %d bytes to %d", p.Offset, len(p.Old), len(p.New))
+		}
+		binary.Write(&buf, binary.LittleEndian, uint64(p.Offset))
+		binary.Write(&buf, binary.LittleEndian, uint32(len(p.New)))
+		buf.Write(p.Old)
+		buf.Write(p.New)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// machoWritePatchFile writes the -uuidpatch record of uuidPatches to
+// path.
+func machoWritePatchFile(path string) error {
+	var buf bytes.Buffer
+	if err := machoWritePatchRecord(&buf, uuidPatches); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0666)
+}
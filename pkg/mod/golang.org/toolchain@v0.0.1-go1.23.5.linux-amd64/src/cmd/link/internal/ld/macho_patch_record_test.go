@@ -0,0 +1,271 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMachoPatchRecord(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old string) { *flagUuidPatch = old }(*flagUuidPatch)
+	defer func(old []machoPatch) { uuidPatches = old }(uuidPatches)
+	*flagBuildid = "test/buildid"
+	*flagUuidPatch = "record"
+	uuidPatches = nil
+
+	// LC_UUID is in the first signed page, so the rewrite changes its
+	// hash too.
+	img := newTestMachO(testUuid).signed()
+	outImg := testRewriteUuid(t, img)
+	if len(uuidPatches) != 2 {
+		t.Fatalf("got %d patches, want 2 (UUID and page hash): %v", len(uuidPatches), uuidPatches)
+	}
+	if p := uuidPatches[0]; !bytes.Equal(p.Old, testUuid[:]) || !bytes.Equal(p.New, uuidFromGoBuildId(*flagBuildid)) {
+		t.Errorf("first patch changes %x to %x, want the UUID change", p.Old, p.New)
+	}
+
+	var buf bytes.Buffer
+	if err := machoWritePatchRecord(&buf, uuidPatches); err != nil {
+		t.Fatal(err)
+	}
+	patches, err := machoReadPatchRecord(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Applying the record to the external linker's output reproduces
+	// the rewritten file, and reverting it gives back the original.
+	f := append(testMachOBuf(nil), img...)
+	if err := machoApplyPatches(f, patches); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f, outImg) {
+		t.Error("applying the patch record does not reproduce the rewrite")
+	}
+	if err := machoApplyPatches(f, machoRevertPatches(patches)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f, img) {
+		t.Error("reverting the patch record does not restore the original")
+	}
+
+	// A file that does not hold the old bytes is left alone.
+	if err := machoApplyPatches(f, patches[1:]); err != nil {
+		t.Fatal(err)
+	}
+	applied := append(testMachOBuf(nil), f...)
+	if err := machoApplyPatches(f, patches); err == nil {
+		t.Error("applying the record twice succeeded")
+	}
+	if !bytes.Equal(f, applied) {
+		t.Error("failed application changed the file")
+	}
+
+	for _, bad := range [][]byte{
+		nil,
+		[]byte("GOUUIDP\x02\x00\x00\x00\x00"),
+		buf.Bytes()[:buf.Len()-1],
+	} {
+		if _, err := machoReadPatchRecord(bytes.NewReader(bad)); err == nil {
+			t.Errorf("reading bad record %q succeeded", bad)
+		}
+	}
+}
+
+func TestMachoUuidManifest(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	img := newTestMachO(testUuid).signed()
+	orig := append([]byte(nil), img...)
+	integrated := testRewriteUuid(t, img)
+
+	patches, err := machoUuidManifest(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img, orig) {
+		t.Fatal("computing the manifest modified the file")
+	}
+	if len(patches) != 2 {
+		t.Errorf("got %d patches, want 2 (UUID and page hash)", len(patches))
+	}
+
+	// The build system applies the manifest in a separate step.
+	var buf bytes.Buffer
+	if err := machoWritePatchRecord(&buf, patches); err != nil {
+		t.Fatal(err)
+	}
+	patches, err = machoReadPatchRecord(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machoApplyPatches(testMachOBuf(img), patches); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img, integrated) {
+		t.Error("applying the manifest does not give the result of the integrated rewrite")
+	}
+
+	// Once applied, there is nothing left to do.
+	if patches, err := machoUuidManifest(testMachOBuf(img)); err != nil || len(patches) != 0 {
+		t.Errorf("manifest of the rewritten file: %v, %v, want no patches", patches, err)
+	}
+}
+
+func TestMachoUuidMinimalManifest(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old bool) { *flagUuidManifestMin = old }(*flagUuidManifestMin)
+	*flagBuildid = "test/buildid"
+	*flagUuidManifestMin = true
+
+	m := newTestMachO(testUuid)
+	uuidOff := int64(machoHeaderSize64 + 8)
+	for _, c := range m.cmds[:3] {
+		uuidOff += int64(len(c))
+	}
+	img := m.signed()
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "exe")
+	if err := os.WriteFile(exe, img, 0444); err != nil {
+		t.Fatal(err)
+	}
+	record := filepath.Join(dir, "exe.manifest")
+	if err := machoWriteUuidManifest(record, exe); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(exe); !bytes.Equal(got, img) {
+		t.Fatal("writing the manifest modified the file")
+	}
+
+	f, err := os.Open(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := machoReadPatchRecord(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := uuidFromGoBuildId(*flagBuildid)
+	if len(patches) != 1 || patches[0].Offset != uuidOff || !bytes.Equal(patches[0].Old, testUuid[:]) || !bytes.Equal(patches[0].New, want) {
+		t.Fatalf("patches = %+v, want one changing the 16 bytes at %#x from %x to %x", patches, uuidOff, testUuid, want)
+	}
+
+	// The privileged step applies the patch to its own copy, and only
+	// the UUID changes.
+	out := append(testMachOBuf(nil), img...)
+	if err := machoApplyPatches(out, patches); err != nil {
+		t.Fatal(err)
+	}
+	if u := testReadUuid(t, parseTestMachO(t, out)); !bytes.Equal(u, want) {
+		t.Errorf("UUID after applying the patch is %x, want %x", u, want)
+	}
+	n := 0
+	for i := range img {
+		if img[i] != out[i] {
+			n++
+		}
+	}
+	if n > 16 {
+		t.Errorf("applying the patch changed %d bytes, want at most 16", n)
+	}
+}
+
+func TestMachoPatchOverlay(t *testing.T) {
+	base := []byte("0123456789")
+	o := &machoPatchOverlay{f: bytes.NewReader(base)}
+	o.WriteAt([]byte("ab"), 2)
+	o.WriteAt([]byte("xy"), 2)
+	o.WriteAt([]byte("7"), 7)
+	got := make([]byte, len(base))
+	if _, err := o.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "01xy456789" {
+		t.Errorf("read %q through the overlay, want %q", got, "01xy456789")
+	}
+	if string(base) != "0123456789" {
+		t.Errorf("overlay modified the file: %q", base)
+	}
+	if len(o.patches) != 1 || string(o.patches[0].Old) != "23" {
+		t.Errorf("patches = %q, want one changing 23 to xy", o.patches)
+	}
+	if _, err := o.WriteAt([]byte("abc"), 3); err == nil {
+		t.Error("partly overlapping write succeeded")
+	}
+}
+
+// machoReadPatchRecord reads a record written by machoWritePatchRecord.
+func machoReadPatchRecord(r io.Reader) ([]machoPatch, error) {
+	var hdr [len(machoPatchRecordMagic) + 4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading patch record: %v", err)
+	}
+	if string(hdr[:len(machoPatchRecordMagic)]) != machoPatchRecordMagic {
+		return nil, fmt.Errorf("not a patch record")
+	}
+	count := binary.LittleEndian.Uint32(hdr[len(machoPatchRecordMagic):])
+	var patches []machoPatch
+	for i := uint32(0); i < count; i++ {
+		var ph [12]byte
+		if _, err := io.ReadFull(r, ph[:]); err != nil {
+			return nil, fmt.Errorf("reading patch %d: %v", i, err)
+		}
+		off := binary.LittleEndian.Uint64(ph[:])
+		size := binary.LittleEndian.Uint32(ph[8:])
+		if off > 1<<62 {
+			return nil, fmt.Errorf("patch %d has bad offset %#x", i, off)
+		}
+		// Read through a LimitReader so that a corrupt size fails at
+		// the end of the record instead of allocating that much.
+		data, err := io.ReadAll(io.LimitReader(r, 2*int64(size)))
+		if err != nil {
+			return nil, fmt.Errorf("reading patch %d: %v", i, err)
+		}
+		if len(data) != 2*int(size) {
+			return nil, fmt.Errorf("reading patch %d: %v", i, io.ErrUnexpectedEOF)
+		}
+		patches = append(patches, machoPatch{Offset: int64(off), Old: data[:size], New: data[size:]})
+	}
+	return patches, nil
+}
+
+// machoApplyPatches makes the changes patches to f. It first checks
+// that f holds the old bytes of every patch, and changes nothing if
+// one does not match.
+func machoApplyPatches(f machoReadWriterAt, patches []machoPatch) error {
+	for _, p := range patches {
+		b := make([]byte, len(p.Old))
+		if _, err := f.ReadAt(b, p.Offset); err != nil {
+			return fmt.Errorf("patch at %#x: %v", p.Offset, err)
+		}
+		if !bytes.Equal(b, p.Old) {
+			return fmt.Errorf("patch at %#x: file has %x, want %x", p.Offset, b, p.Old)
+		}
+	}
+	for _, p := range patches {
+		if _, err := f.WriteAt(p.New, p.Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// machoRevertPatches returns the patches that undo patches.
+func machoRevertPatches(patches []machoPatch) []machoPatch {
+	rev := make([]machoPatch, len(patches))
+	for i, p := range patches {
+		rev[len(patches)-1-i] = machoPatch{Offset: p.Offset, Old: p.New, New: p.Old}
+	}
+	return rev
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PLATFORM_IOSSIMULATOR is the LC_BUILD_VERSION platform of the iOS
+// simulator.
+const PLATFORM_IOSSIMULATOR MachoPlatform = 7
+
+// machoPlatformNames are the platforms -platform-version takes, with
+// the GOOS that runs on each and the names ld64 and .tbd files give it.
+var machoPlatformNames = []struct {
+	name     string
+	platform MachoPlatform
+	goos     string
+	ld64     string
+	tbd      string
+}{
+	{"macos", PLATFORM_MACOS, "darwin", "macos", "macos"},
+	{"maccatalyst", PLATFORM_MACCATALYST, "darwin", "mac-catalyst", "maccatalyst"},
+	{"ios", PLATFORM_IOS, "ios", "ios", "ios"},
+	{"iossimulator", PLATFORM_IOSSIMULATOR, "ios", "ios-simulator", "ios-simulator"},
+}
+
+// A machoPlatformVersion is the platform and versions that
+// -platform-version sets.
+type machoPlatformVersion struct {
+	name     string // as given to -platform-version
+	ld64     string // the name ld64 gives the platform
+	platform MachoPlatform
+	minOS    uint32 // in LC_BUILD_VERSION form
+	sdk      uint32 // in LC_BUILD_VERSION form
+}
+
+// machoPlatformFlag is the -platform-version setting, if any.
+var machoPlatformFlag *machoPlatformVersion
+
+// machoParsePlatformVersion parses a -platform-version setting,
+// platform,minos[,sdk], for GOOS goos. The SDK version defaults to the
+// minimum OS version.
+func machoParsePlatformVersion(s, goos string) (*machoPlatformVersion, error) {
+	f := strings.Split(s, ",")
+	if len(f) != 2 && len(f) != 3 {
+		return nil, fmt.Errorf("%q is not platform,minos[,sdk]", s)
+	}
+	v := &machoPlatformVersion{name: f[0]}
+	var names []string
+	for _, p := range machoPlatformNames {
+		if p.goos != goos {
+			continue
+		}
+		names = append(names, p.name)
+		if p.name == f[0] {
+			v.platform, v.ld64 = p.platform, p.ld64
+		}
+	}
+	if v.platform == 0 {
+		return nil, fmt.Errorf("unknown platform %q for GOOS=%s; use %s", f[0], goos, strings.Join(names, " or "))
+	}
+	var err error
+	if v.minOS, err = machoParseVersion(f[1]); err != nil {
+		return nil, err
+	}
+	v.sdk = v.minOS
+	if len(f) == 3 {
+		if v.sdk, err = machoParseVersion(f[2]); err != nil {
+			return nil, err
+		}
+		if v.sdk < v.minOS {
+			return nil, fmt.Errorf("SDK version %s is older than minimum OS version %s", f[2], f[1])
+		}
+	}
+	return v, nil
+}
+
+// machoParseVersion parses a version x[.y[.z]] into the form, packed as
+// xxxx.yy.zz in nibbles, that LC_BUILD_VERSION records. It is the
+// inverse of machoVersionString.
+func machoParseVersion(s string) (uint32, error) {
+	f := strings.Split(s, ".")
+	limits := [3]uint64{0xffff, 0xff, 0xff}
+	if len(f) > len(limits) {
+		return 0, fmt.Errorf("version %q has more than 3 components", s)
+	}
+	var n [3]uint64
+	for i, c := range f {
+		var err error
+		n[i], err = strconv.ParseUint(c, 10, 32)
+		if err != nil || n[i] > limits[i] {
+			return 0, fmt.Errorf("malformed version %q", s)
+		}
+	}
+	return uint32(n[0]<<16 | n[1]<<8 | n[2]), nil
+}
+
+// hostlinkArgs returns the external linker arguments that set the
+// platform and versions.
+func (v *machoPlatformVersion) hostlinkArgs() []string {
+	return []string{fmt.Sprintf("-Wl,-platform_version,%s,%s,%s", v.ld64, machoVersionString(v.minOS), machoVersionString(v.sdk))}
+}
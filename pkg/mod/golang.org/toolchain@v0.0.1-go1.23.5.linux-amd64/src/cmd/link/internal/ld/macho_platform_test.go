@@ -0,0 +1,108 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/macho"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMachoParsePlatformVersion(t *testing.T) {
+	for _, tc := range []struct {
+		s, goos string
+		want    *machoPlatformVersion
+	}{
+		{"macos,13", "darwin", &machoPlatformVersion{"macos", "macos", PLATFORM_MACOS, 13 << 16, 13 << 16}},
+		{"macos,12.3,14.2.1", "darwin", &machoPlatformVersion{"macos", "macos", PLATFORM_MACOS, 12<<16 | 3<<8, 14<<16 | 2<<8 | 1}},
+		{"maccatalyst,14.0", "darwin", &machoPlatformVersion{"maccatalyst", "mac-catalyst", PLATFORM_MACCATALYST, 14 << 16, 14 << 16}},
+		{"ios,15.0,17.0", "ios", &machoPlatformVersion{"ios", "ios", PLATFORM_IOS, 15 << 16, 17 << 16}},
+		{"iossimulator,15.0", "ios", &machoPlatformVersion{"iossimulator", "ios-simulator", PLATFORM_IOSSIMULATOR, 15 << 16, 15 << 16}},
+		{"ios,15.0", "darwin", nil},
+		{"macos,15.0", "ios", nil},
+		{"macos", "darwin", nil},
+		{"macos,1,2,3", "darwin", nil},
+		{"macos,13.0,12.0", "darwin", nil},
+		{"macos,13.256", "darwin", nil},
+		{"macos,65536", "darwin", nil},
+		{"macos,13.0.0.0", "darwin", nil},
+		{"macos,13.x", "darwin", nil},
+		{"macos,", "darwin", nil},
+	} {
+		got, err := machoParsePlatformVersion(tc.s, tc.goos)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s for %s: got %+v, want error", tc.s, tc.goos, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s for %s: got %+v, %v, want %+v", tc.s, tc.goos, got, err, tc.want)
+		}
+	}
+
+	for _, s := range []string{"0.0.0", "11.0.0", "13.2.1", "65535.255.255"} {
+		v, err := machoParseVersion(s)
+		if err != nil || machoVersionString(v) != s {
+			t.Errorf("%s: parses to %#x (%s), %v", s, v, machoVersionString(v), err)
+		}
+	}
+}
+
+// TestMachoPlatformVersionLink links for darwin/arm64 internally with
+// -platform-version and checks the LC_BUILD_VERSION of the output.
+func TestMachoPlatformVersionLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for darwin")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		flag       string
+		platform   MachoPlatform
+		minos, sdk uint32
+	}{
+		{"", PLATFORM_MACOS, 11 << 16, 11 << 16},
+		{"-platform-version=macos,13.1,14.2", PLATFORM_MACOS, 13<<16 | 1<<8, 14<<16 | 2<<8},
+		{"-platform-version=maccatalyst,14.0", PLATFORM_MACCATALYST, 14 << 16, 14 << 16},
+	} {
+		exe := filepath.Join(dir, "a.exe")
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal "+tc.flag, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		f, err := macho.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, l := range f.Loads {
+			raw := l.Raw()
+			if f.ByteOrder.Uint32(raw) != LC_BUILD_VERSION {
+				continue
+			}
+			n++
+			platform := MachoPlatform(f.ByteOrder.Uint32(raw[8:]))
+			minos, sdk := f.ByteOrder.Uint32(raw[12:]), f.ByteOrder.Uint32(raw[16:])
+			if platform != tc.platform || minos != tc.minos || sdk != tc.sdk {
+				t.Errorf("%q: LC_BUILD_VERSION has platform %d, minos %s, sdk %s, want %d, %s, %s", tc.flag, platform, machoVersionString(minos), machoVersionString(sdk), tc.platform, machoVersionString(tc.minos), machoVersionString(tc.sdk))
+			}
+		}
+		f.Close()
+		if n != 1 {
+			t.Errorf("%q: output has %d LC_BUILD_VERSION commands, want 1", tc.flag, n)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -reprobuildinfo, which writes a record of how the
+// Mach-O output was made in the style of the .buildinfo files of the
+// reproducible-builds.org ecosystem: deb822 paragraphs of "Field:
+// value" lines, with continuation lines indented by a space. The
+// standard fields give the binary, its checksum, the toolchain and the
+// environment; the fields starting with X-Go- give the LC_UUID
+// derivation, as -uuidinfo records it, and the post-link passes run on
+// the output, so that rebuild-verification tools can check a Go binary
+// without knowing the linker.
+
+import (
+	"bytes"
+	"cmd/internal/notsha256"
+	"encoding/hex"
+	"fmt"
+	"internal/buildcfg"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// machoReproBuildinfoSuffix is appended to the output path to name the
+// file -reprobuildinfo writes.
+const machoReproBuildinfoSuffix = ".buildinfo"
+
+// machoFileSha256 returns the SHA-256 hash, in hex, and the size of the
+// file at path.
+func machoFileSha256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := notsha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := h.Sum(nil)
+	for i := range sum {
+		sum[i] ^= 0xFF // convert notsha256 to sha256, as cmd/internal/codesign does
+	}
+	return hex.EncodeToString(sum), n, nil
+}
+
+// machoReproBuildinfo returns the -reprobuildinfo record for the Mach-O
+// file exe, whose post-link passes had the results passes.
+func machoReproBuildinfo(exe string, passes []machoPassResult) ([]byte, error) {
+	info, err := machoUuidInfoFor(exe)
+	if err != nil {
+		return nil, err
+	}
+	sum, size, err := machoFileSha256(exe)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(exe)
+	var arches []string
+	for _, a := range info.Arches {
+		arches = append(arches, a.Cpu)
+	}
+
+	var buf bytes.Buffer
+	field := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\n", name, value)
+	}
+	list := func(name string, lines []string) {
+		fmt.Fprintf(&buf, "%s:\n", name)
+		for _, l := range lines {
+			fmt.Fprintf(&buf, " %s\n", l)
+		}
+	}
+	field("Format", "1.0")
+	field("Binary", name)
+	field("Architecture", strings.Join(arches, " "))
+	list("Checksums-Sha256", []string{fmt.Sprintf("%s %d %s", sum, size, name)})
+	list("Installed-Build-Depends", []string{fmt.Sprintf("go (= %s)", buildcfg.Version)})
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		list("Environment", []string{"SOURCE_DATE_EPOCH=" + strconv.Quote(epoch)})
+	}
+	field("X-Go-Build-ID", info.BuildID)
+	field("X-Go-Uuid-Algorithm", info.Algorithm)
+	if info.Version != 0 {
+		field("X-Go-Uuid-Version", strconv.Itoa(info.Version))
+	}
+	var salts []string
+	if info.PgoHash != "" {
+		salts = append(salts, "pgo "+info.PgoHash)
+	}
+	if info.ImageDigest != "" {
+		salts = append(salts, "image "+info.ImageDigest)
+	}
+	if info.PerCpu {
+		salts = append(salts, "cpu")
+	}
+	if salts != nil {
+		list("X-Go-Uuid-Salts", salts)
+	}
+	var uuids []string
+	for _, a := range info.Arches {
+		l := a.Cpu + " " + a.Uuid
+		if a.Input != "" {
+			// The salted input has NULs, so it is quoted.
+			l += " " + strconv.Quote(a.Input)
+		}
+		uuids = append(uuids, l)
+	}
+	list("X-Go-Uuids", uuids)
+	if passes != nil {
+		var lines []string
+		for _, p := range passes {
+			lines = append(lines, p.Pass+" "+machoPassStatus(p))
+		}
+		list("X-Go-Post-Link-Passes", lines)
+	}
+	return buf.Bytes(), nil
+}
+
+// machoWriteReproBuildinfo writes the -reprobuildinfo record for the
+// Mach-O file exe, whose post-link passes had the results passes, to
+// exe+machoReproBuildinfoSuffix.
+func machoWriteReproBuildinfo(exe string, passes []machoPassResult) error {
+	data, err := machoReproBuildinfo(exe, passes)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(exe+machoReproBuildinfoSuffix, data, 0666)
+}
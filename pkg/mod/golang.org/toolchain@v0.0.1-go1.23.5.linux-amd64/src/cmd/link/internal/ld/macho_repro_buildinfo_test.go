@@ -0,0 +1,146 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"crypto/sha256"
+	"debug/macho"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseTestBuildinfo returns the fields of the deb822 paragraph data,
+// with the continuation lines of each as a list.
+func parseTestBuildinfo(t *testing.T, data string) (map[string]string, map[string][]string) {
+	t.Helper()
+	fields := make(map[string]string)
+	lists := make(map[string][]string)
+	var last string
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if strings.HasPrefix(line, " ") {
+			if last == "" {
+				t.Fatalf("continuation line %q before any field", line)
+			}
+			lists[last] = append(lists[last], line[1:])
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			t.Fatalf("line %q is not a field", line)
+		}
+		if _, dup := fields[name]; dup {
+			t.Fatalf("field %s repeated", name)
+		}
+		fields[name] = strings.TrimPrefix(value, " ")
+		last = name
+	}
+	return fields, lists
+}
+
+func TestMachoReproBuildinfo(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old int) { uuidVersion = old }(uuidVersion)
+	defer func(old bool) { *flagUuidPerCpu = old }(*flagUuidPerCpu)
+	defer func(old string) { uuidPgoHash = old }(uuidPgoHash)
+	defer func(old string) { uuidImageDigest = old }(uuidImageDigest)
+	*flagBuildid = "abcdefghijklmnopqrst/uvwxyzABCDEFGHIJKL"
+	uuidVersion = 3
+	*flagUuidPerCpu = true
+	uuidPgoHash = "0123abcd"
+	uuidImageDigest = ""
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	amd := newTestMachO(testUuid)
+	arm := newTestMachO(testUuid)
+	arm.cpu, arm.subcpu = macho.CpuArm64, 0
+	f := testMachOBuf(testFatMachO(12, amd, arm))
+	err := machoRewriteFatUuids(f, func(r io.ReaderAt, exem *macho.File) ([]byte, error) {
+		return machoOutputUuid(r, exem)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(t.TempDir(), "hello")
+	if err := os.WriteFile(exe, f, 0755); err != nil {
+		t.Fatal(err)
+	}
+	passes := []machoPassResult{{"uuid", ""}, {"buildversion", "no LC_BUILD_VERSION command"}}
+	if err := machoWriteReproBuildinfo(exe, passes); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(exe + ".buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, lists := parseTestBuildinfo(t, string(data))
+
+	sum := sha256.Sum256(f)
+	for name, want := range map[string]string{
+		"Format":              "1.0",
+		"Binary":              "hello",
+		"Architecture":        "x86_64 arm64",
+		"X-Go-Build-ID":       *flagBuildid,
+		"X-Go-Uuid-Algorithm": "buildid",
+		"X-Go-Uuid-Version":   "3",
+	} {
+		if fields[name] != want {
+			t.Errorf("%s: %q, want %q\n%s", name, fields[name], want, data)
+		}
+	}
+	for name, want := range map[string][]string{
+		"Checksums-Sha256":      {fmt.Sprintf("%s %d hello", hex.EncodeToString(sum[:]), len(f))},
+		"Environment":           {`SOURCE_DATE_EPOCH="1700000000"`},
+		"X-Go-Uuid-Salts":       {"pgo 0123abcd", "cpu"},
+		"X-Go-Post-Link-Passes": {"uuid applied", "buildversion skipped: no LC_BUILD_VERSION command"},
+	} {
+		if !reflect.DeepEqual(lists[name], want) {
+			t.Errorf("%s: %q, want %q", name, lists[name], want)
+		}
+	}
+
+	// Each architecture's UUID is the one in the binary, and follows
+	// from the input recorded with it.
+	uuids, err := machoReadUuids(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lists["X-Go-Uuids"]
+	if len(got) != len(uuids) {
+		t.Fatalf("X-Go-Uuids: %q, want %d architectures", got, len(uuids))
+	}
+	for i, u := range uuids {
+		parts := strings.SplitN(got[i], " ", 3)
+		if len(parts) != 3 || parts[0] != machoArchName(u.Cpu) || parts[1] != machoUuidString(u.Uuid) {
+			t.Errorf("X-Go-Uuids line %q, want %s %s and the input", got[i], machoArchName(u.Cpu), machoUuidString(u.Uuid))
+			continue
+		}
+		input, err := strconv.Unquote(parts[2])
+		if err != nil {
+			t.Fatalf("input %s: %v", parts[2], err)
+		}
+		if !strings.HasPrefix(input, *flagBuildid) {
+			t.Errorf("input %q does not start with the build ID", input)
+		}
+		if want := machoUuidString([16]byte(uuidFromGoBuildIdVersion(input, 3))); want != parts[1] {
+			t.Errorf("%s: input %q gives UUID %s, not %s", parts[0], input, want, parts[1])
+		}
+	}
+
+	// A link that ran no passes records none.
+	data, err = machoReproBuildinfo(exe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, lists := parseTestBuildinfo(t, string(data)); lists["X-Go-Post-Link-Passes"] != nil {
+		t.Errorf("passes recorded for a link that ran none:\n%s", data)
+	}
+}
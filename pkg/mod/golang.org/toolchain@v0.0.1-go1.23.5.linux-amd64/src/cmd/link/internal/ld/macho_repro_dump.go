@@ -0,0 +1,122 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains machoDumpReproCommands, which lists the load
+// commands the LC_UUID rewrite and the -reproducible passes look at,
+// raw and decoded, for attaching to reports of builds that are not
+// reproducible.
+
+import (
+	"debug/macho"
+	"fmt"
+	"io"
+)
+
+// A machoReproCommand is a load command that bears on whether a Mach-O
+// file is reproducible.
+type machoReproCommand struct {
+	Cmd     macho.LoadCmd
+	Name    string // for example "LC_UUID"
+	Offset  int64  // file offset of the command
+	Payload []byte // the command after its cmd and cmdsize fields
+	Fields  []machoReproField
+}
+
+// A machoReproField is one decoded field of a machoReproCommand.
+type machoReproField struct {
+	Name, Value string
+}
+
+// machoVersionString formats a version packed as xxxx.yy.zz in
+// nibbles, as LC_BUILD_VERSION and LC_VERSION_MIN_* record them.
+func machoVersionString(v uint32) string {
+	return fmt.Sprintf("%d.%d.%d", v>>16, v>>8&0xff, v&0xff)
+}
+
+// machoDumpReproCommands returns the LC_UUID, LC_BUILD_VERSION,
+// LC_SOURCE_VERSION, LC_VERSION_MIN_* and LC_CODE_SIGNATURE commands
+// of the Mach-O file f, whose header is described by exem, in file
+// order, with their fields decoded. For LC_CODE_SIGNATURE the fields
+// include those of the code directory it points to.
+func machoDumpReproCommands(exem *macho.File, f io.ReaderAt) ([]machoReproCommand, error) {
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return nil, err
+	}
+	order := exem.ByteOrder
+	var dump []machoReproCommand
+	for _, c := range cmds {
+		rc := machoReproCommand{Cmd: c.Cmd, Name: machoLoadCmdName(c.Cmd), Offset: c.Offset, Payload: c.Data[8:]}
+		field := func(name, format string, args ...interface{}) {
+			rc.Fields = append(rc.Fields, machoReproField{name, fmt.Sprintf(format, args...)})
+		}
+		short := func(n int) error {
+			return fmt.Errorf("%s at %#x too short (%d bytes, want %d)", rc.Name, c.Offset, len(c.Data), n)
+		}
+		switch c.Cmd {
+		case LC_UUID:
+			if len(c.Data) < 24 {
+				return nil, short(24)
+			}
+			field("uuid", "%s", machoUuidString([16]byte(c.Data[8:24])))
+		case LC_BUILD_VERSION:
+			// struct build_version_command { cmd, cmdsize, platform, minos, sdk, ntools }
+			// followed by ntools struct build_tool_version { tool, version }.
+			if len(c.Data) < 24 {
+				return nil, short(24)
+			}
+			ntools := order.Uint32(c.Data[20:])
+			if uint64(ntools) > uint64(len(c.Data)-24)/8 {
+				return nil, fmt.Errorf("LC_BUILD_VERSION at %#x has %d tools, too many for %d bytes", c.Offset, ntools, len(c.Data))
+			}
+			field("platform", "%d", order.Uint32(c.Data[8:]))
+			field("minos", "%s", machoVersionString(order.Uint32(c.Data[12:])))
+			field("sdk", "%s", machoVersionString(order.Uint32(c.Data[16:])))
+			field("ntools", "%d", ntools)
+			for i := 0; i < int(ntools); i++ {
+				tool := c.Data[24+8*i:]
+				field(fmt.Sprintf("tool %d", order.Uint32(tool)), "%#x", order.Uint32(tool[4:]))
+			}
+		case LC_SOURCE_VERSION:
+			// struct source_version_command { cmd, cmdsize; uint64 version }
+			if len(c.Data) < 16 {
+				return nil, short(16)
+			}
+			// A.B.C.D.E packed as a24.b10.c10.d10.e10.
+			v := order.Uint64(c.Data[8:])
+			field("version", "%d.%d.%d.%d.%d", v>>40, v>>30&0x3ff, v>>20&0x3ff, v>>10&0x3ff, v&0x3ff)
+		case LC_VERSION_MIN_MACOSX, LC_VERSION_MIN_IPHONEOS, LC_VERSION_MIN_TVOS, LC_VERSION_MIN_WATCHOS:
+			// struct version_min_command { cmd, cmdsize, version, sdk }
+			if len(c.Data) < 16 {
+				return nil, short(16)
+			}
+			field("version", "%s", machoVersionString(order.Uint32(c.Data[8:])))
+			field("sdk", "%s", machoVersionString(order.Uint32(c.Data[12:])))
+		case LC_CODE_SIGNATURE:
+			// struct linkedit_data_command { cmd, cmdsize, dataoff, datasize }
+			if len(c.Data) < 16 {
+				return nil, short(16)
+			}
+			field("dataoff", "%#x", order.Uint32(c.Data[8:]))
+			field("datasize", "%d", order.Uint32(c.Data[12:]))
+			cd, err := machoReadCodeDirectory(f, order, cmds)
+			if err != nil {
+				return nil, err
+			}
+			if cd != nil {
+				field("adhoc", "%v", cd.AdHoc())
+				field("codelimit", "%#x", cd.CodeLimit)
+				field("pagesize", "%d", cd.PageSize)
+				field("ncodeslots", "%d", cd.NCodeSlots)
+				field("hashtype", "%d", cd.HashType)
+			}
+		default:
+			continue
+		}
+		dump = append(dump, rc)
+	}
+	return dump, nil
+}
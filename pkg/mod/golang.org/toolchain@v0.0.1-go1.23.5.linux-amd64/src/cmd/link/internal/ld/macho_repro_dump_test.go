@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"cmd/internal/codesign"
+	"debug/macho"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMachoDumpReproCommands(t *testing.T) {
+	m, _ := testReproMachO()
+	m.size = 0x1000
+	versionMin := make([]byte, 8)
+	m.order.PutUint32(versionMin[0:], 0xa0d00) // 10.13.0
+	m.order.PutUint32(versionMin[4:], 0xa0e01) // 10.14.1
+	m.cmds = append(m.cmds, m.raw(LC_VERSION_MIN_MACOSX, versionMin))
+	img := m.signed()
+	exem := parseTestMachO(t, img)
+
+	dump, err := machoDumpReproCommands(exem, testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type field = machoReproField
+	want := []struct {
+		cmd    macho.LoadCmd
+		fields []field
+	}{
+		{LC_UUID, []field{{"uuid", machoUuidString(testUuid)}}},
+		{LC_BUILD_VERSION, []field{{"platform", "1"}, {"minos", "11.0.0"}, {"sdk", "14.0.0"}, {"ntools", "1"}, {"tool 3", "0x3f80a"}}},
+		{LC_SOURCE_VERSION, []field{{"version", "1.0.0.0.0"}}},
+		{LC_VERSION_MIN_MACOSX, []field{{"version", "10.13.0"}, {"sdk", "10.14.1"}}},
+		{LC_CODE_SIGNATURE, []field{
+			{"dataoff", "0x1000"}, {"datasize", strconv.FormatInt(codesign.Size(0x1000, "a.out"), 10)},
+			{"adhoc", "true"}, {"codelimit", "0x1000"}, {"pagesize", "4096"}, {"ncodeslots", "1"}, {"hashtype", "2"},
+		}},
+	}
+	if len(dump) != len(want) {
+		t.Fatalf("got %d commands, want %d: %+v", len(dump), len(want), dump)
+	}
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range want {
+		d := dump[i]
+		if d.Cmd != w.cmd || d.Name != machoLoadCmdName(w.cmd) || !reflect.DeepEqual(d.Fields, w.fields) {
+			t.Errorf("command %d: %s %+v, want %s %+v", i, d.Name, d.Fields, machoLoadCmdName(w.cmd), w.fields)
+		}
+		// The raw payload is the command as the index reads it.
+		for _, c := range cmds {
+			if c.Offset == d.Offset && !bytes.Equal(d.Payload, c.Data[8:]) {
+				t.Errorf("%s payload %x, want %x", d.Name, d.Payload, c.Data[8:])
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -reproreport, which appends a row for each change
+// the LC_UUID rewrite and the -reproducible passes made to the Mach-O
+// output to a report file. Every link of a multi-binary build can be
+// given the same report, which then lists, in one place, every change
+// made to the external linker's outputs to make the build
+// reproducible.
+//
+// Each row has the output path, the load command the change falls in
+// (empty if none, as for a change to segment contents), the file
+// offset of the change and its old and new bytes in hex. After them
+// comes a row for each pass run on the output, with just the path, the
+// pass name and its status: "applied", or "skipped: " and the reason
+// the pass found nothing to act on, so that a gap in the
+// canonicalization shows in the report. The csv format has a header
+// row, written by the link that creates the report; the json format has
+// one object per line. Links sharing a report do not lock it: each
+// appends its rows with a single write.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// reproPatches holds the changes the -reproducible passes made to the
+// output, for -reproreport.
+var reproPatches []machoPatch
+
+// machoRecordingPatches reports whether the changes the LC_UUID rewrite
+// makes to the output are to be recorded in uuidPatches.
+func machoRecordingPatches() bool {
+	return *flagUuidPatch != "" || *flagReproReport != ""
+}
+
+// machoCheckReproReportFormat returns an error if format is not one
+// -reproreport can write.
+func machoCheckReproReportFormat(format string) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown format %q (want csv or json)", format)
+	}
+	return nil
+}
+
+// A machoReportRow is one -reproreport row: a change made to the
+// Mach-O file Path.
+type machoReportRow struct {
+	Path    string `json:"path"`
+	Command string `json:"command"`
+	Offset  int64  `json:"offset"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	// Pass and Status are only set in the row for a pass.
+	Pass   string `json:"pass,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// machoPassStatus returns the -reproreport status of the pass result r.
+func machoPassStatus(r machoPassResult) string {
+	if r.Skipped != "" {
+		return "skipped: " + r.Skipped
+	}
+	return "applied"
+}
+
+// machoPatchCommand returns the name of the load command in cmds that
+// holds the byte at file offset off: the command itself, or the code
+// signature LC_CODE_SIGNATURE points to. It returns "" if there is
+// none.
+func machoPatchCommand(order binary.ByteOrder, cmds []machoLoadCmd, off int64) string {
+	for _, c := range cmds {
+		if c.Offset <= off && off < c.Offset+int64(len(c.Data)) {
+			return machoLoadCmdName(c.Cmd)
+		}
+		// struct linkedit_data_command { cmd, cmdsize, dataoff, datasize }
+		if c.Cmd == LC_CODE_SIGNATURE && len(c.Data) >= 16 {
+			dataoff := int64(order.Uint32(c.Data[8:]))
+			datasize := int64(order.Uint32(c.Data[12:]))
+			if dataoff <= off && off < dataoff+datasize {
+				return machoLoadCmdName(c.Cmd)
+			}
+		}
+	}
+	return ""
+}
+
+// machoReproReportRows returns the -reproreport rows for patches, the
+// changes made to the Mach-O file exe, and results, the passes run on
+// it.
+func machoReproReportRows(exe string, patches []machoPatch, results []machoPassResult) ([]machoReportRow, error) {
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	exem, err := machoParseFile(f)
+	if err != nil {
+		return nil, err
+	}
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return nil, err
+	}
+	var rows []machoReportRow
+	for _, p := range patches {
+		rows = append(rows, machoReportRow{
+			Path:    exe,
+			Command: machoPatchCommand(exem.ByteOrder, cmds, p.Offset),
+			Offset:  p.Offset,
+			Old:     hex.EncodeToString(p.Old),
+			New:     hex.EncodeToString(p.New),
+		})
+	}
+	for _, r := range results {
+		rows = append(rows, machoReportRow{Path: exe, Pass: r.Pass, Status: machoPassStatus(r)})
+	}
+	return rows, nil
+}
+
+// machoWriteReportRows writes rows to w in format, with the csv header
+// row first if header is set.
+func machoWriteReportRows(w io.Writer, format string, rows []machoReportRow, header bool) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, r := range rows {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write([]string{"path", "command", "offset", "old", "new", "pass", "status"})
+	}
+	for _, r := range rows {
+		off := fmt.Sprintf("%#x", r.Offset)
+		if r.Pass != "" {
+			off = ""
+		}
+		cw.Write([]string{r.Path, r.Command, off, r.Old, r.New, r.Pass, r.Status})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// machoAppendReproReport appends the -reproreport rows for patches, the
+// changes made to the Mach-O file exe, and results, the passes run on
+// it, to the report at path in format.
+func machoAppendReproReport(path, format, exe string, patches []machoPatch, results []machoPassResult) error {
+	rows, err := machoReproReportRows(exe, patches, results)
+	if err != nil {
+		return err
+	}
+	// The link that creates the report writes the header row. Create
+	// it with its first rows in place, by linking a complete temporary
+	// file to path, so that a link appending to it at the same time
+	// cannot get in ahead of the header.
+	var buf bytes.Buffer
+	if err := machoWriteReportRows(&buf, format, rows, true); err != nil {
+		return err
+	}
+	tmp := path + ".tmp" + strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	err = os.Link(tmp, path)
+	os.Remove(tmp)
+	if !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	buf.Reset()
+	if err := machoWriteReportRows(&buf, format, rows, false); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMachoReproReport(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old string) { *flagReproReport = old }(*flagReproReport)
+	defer func(old []machoPatch) { uuidPatches = old }(uuidPatches)
+
+	m := newTestMachO(testUuid)
+	uuidOff := int64(machoHeaderSize64 + 8)
+	for _, c := range m.cmds[:3] {
+		uuidOff += int64(len(c))
+	}
+	results := []machoPassResult{{"uuid", ""}, {"buildversion", "no LC_BUILD_VERSION command"}}
+	dir := t.TempDir()
+	for _, format := range []string{"csv", "json"} {
+		report := filepath.Join(dir, "report."+format)
+		*flagReproReport = report
+
+		// A batch build of three binaries sharing the report.
+		var want []machoReportRow
+		for i := 0; i < 3; i++ {
+			*flagBuildid = fmt.Sprintf("test/buildid%d", i)
+			uuidPatches = nil
+			exe := filepath.Join(dir, fmt.Sprintf("bin%d.%s", i, format))
+			if err := os.WriteFile(exe, testRewriteUuid(t, m.bytes()), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := machoAppendReproReport(report, format, exe, uuidPatches, results); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, machoReportRow{
+				Path:    exe,
+				Command: "LC_UUID",
+				Offset:  uuidOff,
+				Old:     hex.EncodeToString(testUuid[:]),
+				New:     hex.EncodeToString(uuidFromGoBuildId(*flagBuildid)),
+			})
+			want = append(want,
+				machoReportRow{Path: exe, Pass: "uuid", Status: "applied"},
+				machoReportRow{Path: exe, Pass: "buildversion", Status: "skipped: no LC_BUILD_VERSION command"})
+		}
+
+		f, err := os.Open(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []machoReportRow
+		switch format {
+		case "csv":
+			records, err := csv.NewReader(f).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) == 0 || !reflect.DeepEqual(records[0], []string{"path", "command", "offset", "old", "new", "pass", "status"}) {
+				t.Fatalf("csv report does not start with the header row: %q", records)
+			}
+			for _, r := range records[1:] {
+				var off int64
+				fmt.Sscanf(r[2], "%v", &off)
+				got = append(got, machoReportRow{r[0], r[1], off, r[3], r[4], r[5], r[6]})
+			}
+		case "json":
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				var r machoReportRow
+				if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, r)
+			}
+		}
+		f.Close()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s report:\n%+v\nwant a change row and two pass rows per rewritten binary:\n%+v", format, got, want)
+		}
+		if tmps, _ := filepath.Glob(report + ".tmp*"); len(tmps) != 0 {
+			t.Errorf("temporary files left behind: %q", tmps)
+		}
+	}
+}
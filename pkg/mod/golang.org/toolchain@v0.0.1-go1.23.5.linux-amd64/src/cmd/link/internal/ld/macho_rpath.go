@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"path"
+	"strings"
+)
+
+// machoCleanRpath returns the canonical form of the run path p, for
+// comparing run paths: p with repeated and trailing slashes and . and
+// .. elements removed as path.Clean does. An @executable_path,
+// @loader_path or @rpath prefix stands for a directory that is not
+// known until run time, so .. elements that follow it are kept.
+func machoCleanRpath(p string) string {
+	if p == "" {
+		return p
+	}
+	if strings.HasPrefix(p, "@") {
+		prefix, rest, _ := strings.Cut(p, "/")
+		if rest = path.Clean(rest); rest == "." {
+			return prefix
+		}
+		return prefix + "/" + rest
+	}
+	return path.Clean(p)
+}
+
+// machoDedupRpaths returns argv, the arguments to the external linker,
+// without the run paths that an earlier argument already adds. ld64
+// warns about each duplicate -rpath, and newer versions of dyld refuse
+// to load an image with duplicate LC_RPATH commands, but cgo flags of
+// separate packages commonly repeat the same run path, or spell it
+// differently, as in "lib" and "lib/". The forms recognized are
+//
+//	-Wl,-rpath,DIR, in any position of the -Wl list
+//	-Wl,-rpath -Wl,DIR
+//	-Xlinker -rpath -Xlinker DIR
+//	-rpath DIR
+//
+// The first of the duplicates is kept as it is, since dyld searches
+// the run paths in order.
+func machoDedupRpaths(argv []string) []string {
+	seen := make(map[string]bool)
+	dup := func(dir string) bool {
+		dir = machoCleanRpath(dir)
+		if seen[dir] {
+			return true
+		}
+		seen[dir] = true
+		return false
+	}
+	out := make([]string, 0, len(argv))
+	for i := 0; i < len(argv); i++ {
+		a := argv[i]
+		switch {
+		case a == "-rpath" && i+1 < len(argv):
+			if !dup(argv[i+1]) {
+				out = append(out, argv[i:i+2]...)
+			}
+			i++
+		case a == "-Xlinker" && i+3 < len(argv) && argv[i+1] == "-rpath" && argv[i+2] == "-Xlinker":
+			if !dup(argv[i+3]) {
+				out = append(out, argv[i:i+4]...)
+			}
+			i += 3
+		case a == "-Xlinker" && i+1 < len(argv):
+			// Not a run path, but keep its argument from being
+			// taken for an option.
+			out = append(out, argv[i:i+2]...)
+			i++
+		case a == "-Wl,-rpath" && i+1 < len(argv) && strings.HasPrefix(argv[i+1], "-Wl,") && !strings.Contains(argv[i+1][len("-Wl,"):], ","):
+			if !dup(argv[i+1][len("-Wl,"):]) {
+				out = append(out, argv[i:i+2]...)
+			}
+			i++
+		case strings.HasPrefix(a, "-Wl,") && strings.Contains(a, ",-rpath,"):
+			items := strings.Split(a[len("-Wl,"):], ",")
+			var keep []string
+			for j := 0; j < len(items); j++ {
+				if items[j] == "-rpath" && j+1 < len(items) {
+					if !dup(items[j+1]) {
+						keep = append(keep, items[j:j+2]...)
+					}
+					j++
+					continue
+				}
+				keep = append(keep, items[j])
+			}
+			if len(keep) > 0 {
+				out = append(out, "-Wl,"+strings.Join(keep, ","))
+			}
+		default:
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMachoCleanRpath(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"/usr/local/lib", "/usr/local/lib"},
+		{"/usr/local/lib/", "/usr/local/lib"},
+		{"/usr//local/./lib", "/usr/local/lib"},
+		{"lib/../lib", "lib"},
+		{"@loader_path", "@loader_path"},
+		{"@loader_path/", "@loader_path"},
+		{"@loader_path/.", "@loader_path"},
+		{"@loader_path/../lib/", "@loader_path/../lib"},
+		{"@executable_path/../Frameworks", "@executable_path/../Frameworks"},
+	} {
+		if got := machoCleanRpath(tc.in); got != tc.want {
+			t.Errorf("machoCleanRpath(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMachoDedupRpaths(t *testing.T) {
+	argv := []string{
+		"clang", "-o", "a.out",
+		"-Wl,-rpath,/opt/lib",
+		"-Wl,-rpath,/opt/lib/",
+		"-Wl,-rpath,@loader_path/../lib,-rpath,/opt/lib,-dead_strip",
+		"-Wl,-rpath", "-Wl,/opt/other",
+		"-Xlinker", "-rpath", "-Xlinker", "/opt/other/",
+		"-Xlinker", "-rpath", "-Xlinker", "/opt/third",
+		"-rpath", "@loader_path/../lib/",
+		"-Xlinker", "-rpath", // a -rpath the next -Xlinker completes
+		"-Xlinker", "/opt/fourth",
+		"-lfoo",
+	}
+	want := []string{
+		"clang", "-o", "a.out",
+		"-Wl,-rpath,/opt/lib",
+		"-Wl,-rpath,@loader_path/../lib,-dead_strip",
+		"-Wl,-rpath", "-Wl,/opt/other",
+		"-Xlinker", "-rpath", "-Xlinker", "/opt/third",
+		"-Xlinker", "-rpath",
+		"-Xlinker", "/opt/fourth",
+		"-lfoo",
+	}
+	if got := machoDedupRpaths(argv); !slices.Equal(got, want) {
+		t.Errorf("machoDedupRpaths:\ngot  %q\nwant %q", got, want)
+	}
+
+	// A -Wl list that only has duplicates goes away.
+	argv = []string{"clang", "-Wl,-rpath,/a", "-Wl,-rpath,/a,-rpath,/a/"}
+	want = []string{"clang", "-Wl,-rpath,/a"}
+	if got := machoDedupRpaths(argv); !slices.Equal(got, want) {
+		t.Errorf("machoDedupRpaths:\ngot  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains machoSemanticDiff, which compares two Mach-O
+// binaries byte for byte, except for the fields known to differ between
+// links of the same program, for finding sources of nondeterminism
+// other than the ones the -reproducible passes already clear.
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A machoVolatileRange is a range of bytes of a Mach-O file that may
+// differ between links of the same program.
+type machoVolatileRange struct {
+	Offset, Size int64
+	Field        string // for example "LC_UUID payload"
+}
+
+// machoVolatileRanges returns the ranges of the Mach-O file f, whose
+// header is described by exem, that may differ between links of the
+// same program, in increasing order of offset: the reserved field of
+// the 64-bit header, the LC_UUID payload, the tool versions of
+// LC_BUILD_VERSION, the LC_SOURCE_VERSION version, the dylib
+// timestamps, and the code signature, whose hashes change with any of
+// them.
+func machoVolatileRanges(f io.ReaderAt, exem *macho.File) ([]machoVolatileRange, error) {
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []machoVolatileRange
+	add := func(off, size int64, field string) {
+		ranges = append(ranges, machoVolatileRange{off, size, field})
+	}
+	if exem.Magic == macho.Magic64 {
+		add(28, 4, "header reserved field")
+	}
+	order := exem.ByteOrder
+	for _, c := range cmds {
+		name := machoLoadCmdName(c.Cmd)
+		switch {
+		case c.Cmd == LC_UUID && len(c.Data) >= 24:
+			add(c.Offset+8, 16, "LC_UUID payload")
+		case c.Cmd == LC_BUILD_VERSION && len(c.Data) >= 24:
+			// struct build_version_command { cmd, cmdsize, platform, minos, sdk, ntools }
+			// followed by ntools struct build_tool_version { tool, version }.
+			ntools := order.Uint32(c.Data[20:])
+			if uint64(ntools) > uint64(len(c.Data)-24)/8 {
+				return nil, fmt.Errorf("LC_BUILD_VERSION at %#x has %d tools, too many for %d bytes", c.Offset, ntools, len(c.Data))
+			}
+			for i := int64(0); i < int64(ntools); i++ {
+				add(c.Offset+24+8*i+4, 4, "LC_BUILD_VERSION tool version")
+			}
+		case c.Cmd == LC_SOURCE_VERSION && len(c.Data) >= 16:
+			add(c.Offset+8, 8, "LC_SOURCE_VERSION version")
+		case (machoIsDylibLoad(c.Cmd) || c.Cmd == LC_ID_DYLIB) && len(c.Data) >= 16:
+			// struct dylib_command { cmd, cmdsize; struct dylib { name, timestamp, ... } }
+			add(c.Offset+12, 4, name+" timestamp")
+		case c.Cmd == LC_CODE_SIGNATURE && len(c.Data) >= 16:
+			// struct linkedit_data_command { cmd, cmdsize, dataoff, datasize }
+			add(int64(order.Uint32(c.Data[8:])), int64(order.Uint32(c.Data[12:])), "code signature")
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Offset < ranges[j].Offset })
+	return ranges, nil
+}
+
+// A machoDiff is a run of bytes in which two Mach-O files differ
+// outside their volatile ranges.
+type machoDiff struct {
+	Offset, Size int64
+	// Command is the name of the load command of the first file the
+	// run starts in, as machoPatchCommand gives it, or "" if none,
+	// as for a difference in segment contents or in the file size.
+	Command string
+}
+
+// machoFileMask returns, for each byte of the Mach-O file data, thin
+// or fat, whether it is in a volatile range, and a function giving the
+// load command that holds a file offset.
+func machoFileMask(data []byte) ([]bool, func(int64) string, error) {
+	mask := make([]bool, len(data))
+	r := bytes.NewReader(data)
+	slices, err := machoFatSlices(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	type sliceCmds struct {
+		machoFatSlice
+		size int64
+		cmds []machoLoadCmd
+		exem *macho.File
+	}
+	var parsed []sliceCmds
+	for _, s := range slices {
+		exem, err := machoParseFile(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		ranges, err := machoVolatileRanges(s, exem)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range ranges {
+			start, end := s.Offset+v.Offset, s.Offset+v.Offset+v.Size
+			if start < 0 || end > int64(len(data)) || start > end {
+				return nil, nil, fmt.Errorf("%s at %#x+%#x outside the file", v.Field, v.Offset, v.Size)
+			}
+			for i := start; i < end; i++ {
+				mask[i] = true
+			}
+		}
+		cmds, err := machoReadLoadCmds(s, exem)
+		if err != nil {
+			return nil, nil, err
+		}
+		size := int64(len(data)) - s.Offset
+		if sr, ok := s.ReaderAt.(*io.SectionReader); ok {
+			size = sr.Size()
+		}
+		parsed = append(parsed, sliceCmds{s, size, cmds, exem})
+	}
+	command := func(off int64) string {
+		for _, p := range parsed {
+			if p.Offset <= off && off < p.Offset+p.size {
+				return machoPatchCommand(p.exem.ByteOrder, p.cmds, off-p.Offset)
+			}
+		}
+		return ""
+	}
+	return mask, command, nil
+}
+
+// machoSemanticDiff compares the Mach-O files a and b, thin or fat, and
+// returns the runs of bytes in which they differ, leaving out those
+// volatile in either file (see machoVolatileRanges). If the files
+// differ in size, the bytes past the end of the shorter one are the
+// last run. Two links of the same program that give no runs differ
+// only in fields expected to differ.
+func machoSemanticDiff(a, b string) ([]machoDiff, error) {
+	da, err := os.ReadFile(a)
+	if err != nil {
+		return nil, err
+	}
+	db, err := os.ReadFile(b)
+	if err != nil {
+		return nil, err
+	}
+	maskA, command, err := machoFileMask(da)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", a, err)
+	}
+	maskB, _, err := machoFileMask(db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b, err)
+	}
+	n := len(da)
+	if len(db) < n {
+		n = len(db)
+	}
+	var diffs []machoDiff
+	for i := 0; i < n; i++ {
+		if da[i] == db[i] || maskA[i] || maskB[i] {
+			continue
+		}
+		start := i
+		for i+1 < n && da[i+1] != db[i+1] && !maskA[i+1] && !maskB[i+1] {
+			i++
+		}
+		diffs = append(diffs, machoDiff{int64(start), int64(i + 1 - start), command(int64(start))})
+	}
+	if len(da) != len(db) {
+		size := len(da) - len(db)
+		if size < 0 {
+			size = -size
+		}
+		diffs = append(diffs, machoDiff{int64(n), int64(size), ""})
+	}
+	return diffs, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMachoSemanticDiff(t *testing.T) {
+	// Two links of the same program, one by a newer ld64 with another
+	// source version and dylib timestamp, and with another UUID.
+	m, _ := testReproMachO()
+	m.size = 0x1000
+	a := m.signed()
+	m, _ = testReproMachO()
+	m.size = 0x1000
+	m.cmds[3] = m.uuid([16]byte{9})
+	m.order.PutUint32(m.cmds[4][28:], 0x40a0100)  // LC_BUILD_VERSION ld version
+	m.order.PutUint64(m.cmds[5][8:], 2<<40)       // LC_SOURCE_VERSION
+	m.order.PutUint32(m.cmds[6][12:], 0x66000000) // LC_LOAD_DYLIB timestamp
+	b := m.signed()
+	m.order.PutUint32(b[28:], 1) // header reserved field
+
+	dir := t.TempDir()
+	pa, pb := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	write := func(path string, img []byte) {
+		t.Helper()
+		if err := os.WriteFile(path, img, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(pa, a)
+	write(pb, b)
+	diffs, err := machoSemanticDiff(pa, pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs != nil {
+		t.Errorf("binaries differing only in volatile fields: %+v", diffs)
+	}
+
+	// A difference in __text and one in a load command the passes
+	// do not know are reported, and nothing else.
+	b[0x405] ^= 0xff
+	exem := parseTestMachO(t, b)
+	cmds, err := machoReadLoadCmds(testMachOBuf(b), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minos := cmds[4].Offset + 12 // LC_BUILD_VERSION minos
+	b[minos+1]++
+	write(pb, b)
+	diffs, err = machoSemanticDiff(pa, pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []machoDiff{
+		{Offset: minos + 1, Size: 1, Command: "LC_BUILD_VERSION"},
+		{Offset: 0x405, Size: 1},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("diffs = %+v, want %+v", diffs, want)
+	}
+
+	// Bytes past the end of the shorter file are a difference.
+	write(pb, append(a, 0))
+	diffs, err = machoSemanticDiff(pa, pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []machoDiff{{Offset: int64(len(a)), Size: 1}}; !reflect.DeepEqual(diffs, want) {
+		t.Errorf("diffs for an extra byte = %+v, want %+v", diffs, want)
+	}
+}
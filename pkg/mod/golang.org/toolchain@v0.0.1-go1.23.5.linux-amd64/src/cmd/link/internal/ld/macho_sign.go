@@ -0,0 +1,289 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -machosigner, which gives the Mach-O output a code
+// signature made with a signing identity rather than an ad hoc one, so
+// that go build can write a binary ready for notarization.
+//
+// The linker writes the signature, as codesign would: a code directory
+// with the hashes of the code pages, flagged for the hardened runtime,
+// an empty set of internal requirements, and a CMS signature over the
+// code directory. Only the CMS signature takes the private key, so
+// making it is left to a signer: the -machosigner command, which is run
+// with the path of a file holding the code directory as its last
+// argument and prints the DER-encoded CMS SignedData to its standard
+// output.
+
+import (
+	"bytes"
+	"cmd/internal/codesign"
+	"cmd/internal/notsha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A machoSigner makes the code signature of a Mach-O file with a
+// signing identity.
+type machoSigner struct {
+	ident string // the identifier of the code directory
+	team  string // the team ID of the code directory, or ""
+
+	// sign returns the CMS signature of the code directory cd.
+	sign func(cd []byte) ([]byte, error)
+}
+
+const (
+	csmagicBlobWrapper = 0xfade0b01 // CMS signature blob
+	csslotRequirements = 2
+	csslotSignature    = 0x10000
+	csRuntime          = 0x10000 // code directory flag: hardened runtime
+
+	// machoCodeDirSize is the size of the fixed part of a code
+	// directory of version 0x20400.
+	machoCodeDirSize = 88
+
+	// The code directory hashes code pages of machoSignPageSize
+	// bytes.
+	machoSignPageBits = 12
+	machoSignPageSize = 1 << machoSignPageBits
+
+	// machoCMSReserve is the room the signature leaves for the CMS
+	// signature, which must be sized before it is made. It holds a
+	// signature with a certificate chain of a few certificates and a
+	// timestamp.
+	machoCMSReserve = 16 << 10
+)
+
+// machoEmptyRequirements is an empty requirements set blob.
+var machoEmptyRequirements = []byte{0xfa, 0xde, 0x0c, 0x01, 0, 0, 0, 12, 0, 0, 0, 0}
+
+// cdSize returns the size of the code directory for codeSize bytes of
+// code.
+func (s *machoSigner) cdSize(codeSize int64) int64 {
+	n := int64(machoCodeDirSize + len(s.ident) + 1)
+	if s.team != "" {
+		n += int64(len(s.team) + 1)
+	}
+	// The hashes of the special slots, up to that of the requirements,
+	// and of the code pages.
+	nhashes := (codeSize + machoSignPageSize - 1) / machoSignPageSize
+	return n + (csslotRequirements+nhashes)*notsha256.Size
+}
+
+// size returns the size of the code signature for codeSize bytes of
+// code: a superblob with three blobs, with the room of machoCMSReserve
+// for the CMS signature.
+func (s *machoSigner) size(codeSize int64) int64 {
+	return 12 + 3*8 + s.cdSize(codeSize) + int64(len(machoEmptyRequirements)) + 8 + machoCMSReserve
+}
+
+// machoSignHash returns the SHA-256 hash of b.
+func machoSignHash(b []byte) []byte {
+	h := notsha256.Sum256(b)
+	for i := range h {
+		h[i] ^= 0xFF // convert notsha256 to sha256, as cmd/internal/codesign does
+	}
+	return h[:]
+}
+
+// codeDirectory returns the code directory of the codeSize bytes of
+// code in data, whose text segment is the textSize bytes at textOff.
+func (s *machoSigner) codeDirectory(data io.ReaderAt, codeSize, textOff, textSize int64, isMain bool) ([]byte, error) {
+	be := binary.BigEndian
+	identOff := uint32(machoCodeDirSize)
+	teamOff := uint32(0)
+	hashOff := identOff + uint32(len(s.ident)+1)
+	if s.team != "" {
+		teamOff = hashOff
+		hashOff += uint32(len(s.team) + 1)
+	}
+	hashOff += csslotRequirements * notsha256.Size
+	nhashes := (codeSize + machoSignPageSize - 1) / machoSignPageSize
+	execSegFlags := uint64(0)
+	if isMain {
+		execSegFlags = codesign.CS_EXECSEG_MAIN_BINARY
+	}
+
+	cd := make([]byte, 0, s.cdSize(codeSize))
+	cd = be.AppendUint32(cd, codesign.CSMAGIC_CODEDIRECTORY)
+	cd = be.AppendUint32(cd, uint32(s.cdSize(codeSize)))
+	cd = be.AppendUint32(cd, 0x20400) // version
+	cd = be.AppendUint32(cd, csRuntime)
+	cd = be.AppendUint32(cd, hashOff)
+	cd = be.AppendUint32(cd, identOff)
+	cd = be.AppendUint32(cd, csslotRequirements) // nSpecialSlots, up to that of the requirements
+	cd = be.AppendUint32(cd, uint32(nhashes))
+	cd = be.AppendUint32(cd, uint32(codeSize))
+	// hashSize, hashType, a pad byte and the log2 of the page size.
+	cd = append(cd, notsha256.Size, codesign.CS_HASHTYPE_SHA256, 0, machoSignPageBits)
+	cd = be.AppendUint32(cd, 0) // pad
+	cd = be.AppendUint32(cd, 0) // scatterOffset
+	cd = be.AppendUint32(cd, teamOff)
+	cd = be.AppendUint32(cd, 0) // pad
+	cd = be.AppendUint64(cd, 0) // codeLimit64
+	cd = be.AppendUint64(cd, uint64(textOff))
+	cd = be.AppendUint64(cd, uint64(textSize))
+	cd = be.AppendUint64(cd, execSegFlags)
+	cd = append(append(cd, s.ident...), 0)
+	if s.team != "" {
+		cd = append(append(cd, s.team...), 0)
+	}
+	// The special slots, in reverse order before the code slots: the
+	// requirements, then the Info.plist, which there is none of.
+	cd = append(cd, machoSignHash(machoEmptyRequirements)...)
+	cd = append(cd, make([]byte, notsha256.Size)...)
+
+	page := make([]byte, machoSignPageSize)
+	for off := int64(0); off < codeSize; off += machoSignPageSize {
+		n := codeSize - off
+		if n > machoSignPageSize {
+			n = machoSignPageSize
+		}
+		if _, err := data.ReadAt(page[:n], off); err != nil {
+			return nil, err
+		}
+		cd = append(cd, machoSignHash(page[:n])...)
+	}
+	return cd, nil
+}
+
+// signature writes to out, of the length s.size(codeSize), the code
+// signature of the codeSize bytes of code in data, whose text segment is
+// the textSize bytes at textOff. isMain says whether data is an
+// executable.
+func (s *machoSigner) signature(out []byte, data io.ReaderAt, codeSize, textOff, textSize int64, isMain bool) error {
+	cd, err := s.codeDirectory(data, codeSize, textOff, textSize, isMain)
+	if err != nil {
+		return err
+	}
+	cms, err := s.sign(cd)
+	if err != nil {
+		return err
+	}
+	if len(cms) == 0 {
+		return fmt.Errorf("the signer made an empty signature")
+	}
+	if len(cms) > machoCMSReserve {
+		return fmt.Errorf("the signature of %d bytes is larger than the %d bytes reserved for it", len(cms), machoCMSReserve)
+	}
+
+	be := binary.BigEndian
+	cdOff := uint32(12 + 3*8)
+	reqOff := cdOff + uint32(len(cd))
+	cmsOff := reqOff + uint32(len(machoEmptyRequirements))
+	b := make([]byte, 0, len(out))
+	b = be.AppendUint32(b, codesign.CSMAGIC_EMBEDDED_SIGNATURE)
+	b = be.AppendUint32(b, cmsOff+8+uint32(len(cms)))
+	b = be.AppendUint32(b, 3)
+	b = be.AppendUint32(b, codesign.CSSLOT_CODEDIRECTORY)
+	b = be.AppendUint32(b, cdOff)
+	b = be.AppendUint32(b, csslotRequirements)
+	b = be.AppendUint32(b, reqOff)
+	b = be.AppendUint32(b, csslotSignature)
+	b = be.AppendUint32(b, cmsOff)
+	b = append(b, cd...)
+	b = append(b, machoEmptyRequirements...)
+	b = be.AppendUint32(b, csmagicBlobWrapper)
+	b = be.AppendUint32(b, 8+uint32(len(cms)))
+	b = append(b, cms...)
+	copy(out, b)
+	return nil
+}
+
+// machoRewriteBuildID does to the Mach-O file f of size bytes the
+// update of the Go build ID id that the go command makes after the
+// link, if id is of the form it gives the linker: it replaces the
+// content ID, the last part of id, with the hash of the contents of f,
+// with id zeroed and the code signature left out. The go command, not
+// finding id, then leaves f as it is, rather than invalidating a
+// signature it cannot make. The code signature of f must still be
+// zeros, which hash as the go command's leaving it out does.
+func machoRewriteBuildID(f *os.File, size int64, id string) error {
+	// The content ID is the first 120 bits of the SHA-256 hash, in
+	// unpadded URL-safe base64, as the go command writes it.
+	const contentIDLen = 20
+	i := strings.LastIndex(id, "/")
+	if i < 0 || len(id)-i-1 != contentIDLen {
+		return nil
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return err
+	}
+	var matches []int64
+	for off := 0; ; {
+		j := bytes.Index(data[off:], []byte(id))
+		if j < 0 {
+			break
+		}
+		off += j
+		matches = append(matches, int64(off))
+		copy(data[off:off+len(id)], make([]byte, len(id)))
+		off += len(id)
+	}
+	hash := machoSignHash(data)
+	newID := id[:i+1] + base64.RawURLEncoding.EncodeToString(hash[:15])
+	for _, off := range matches {
+		if _, err := f.WriteAt([]byte(newID), off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// machoSignCommand returns the sign function of the -machosigner
+// command.
+func machoSignCommand(ctxt *Link) func(cd []byte) ([]byte, error) {
+	return func(cd []byte) ([]byte, error) {
+		f, err := os.CreateTemp(*flagTmpdir, "go-link-cd-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write(cd); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+
+		argv := append(flagMachoSigner[1:len(flagMachoSigner):len(flagMachoSigner)], f.Name())
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("Mach-O signer: %s %q\n", flagMachoSigner[0], argv)
+		}
+		cmd := exec.Command(flagMachoSigner[0], argv...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("running %s failed: %v\n%s", flagMachoSigner[0], err, stderr.Bytes())
+		}
+		if stderr.Len() > 0 {
+			ctxt.Logf("%s", stderr.Bytes())
+		}
+		return stdout.Bytes(), nil
+	}
+}
+
+// machoSignPass is the post-link pass of -machosigner, which signs the
+// output at path once every other pass is done with it.
+func machoSignPass(ctxt *Link, path string) error {
+	s := &machoSigner{ident: *flagMachoSignID, team: *flagMachoSignTeam, sign: machoSignCommand(ctxt)}
+	if err := machoSign(path, ctxt.IsExe() || ctxt.IsPIE(), s); err != nil {
+		return err
+	}
+	if *flagReproducibleMtime {
+		// Signing changed the times -reproduciblemtime set.
+		return machoSetOutputTimes(path)
+	}
+	return nil
+}
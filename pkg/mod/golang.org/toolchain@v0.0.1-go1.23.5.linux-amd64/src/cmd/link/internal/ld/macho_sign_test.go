@@ -0,0 +1,231 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"cmd/internal/buildid"
+	"cmd/internal/codesign"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSignature is the code signature of a Mach-O file signed with an
+// identity, as testReadSignature takes it apart.
+type testSignature struct {
+	cd, requirements, cms []byte
+	ident, team           string
+}
+
+// testReadSignature returns the blobs of the code signature of img.
+func testReadSignature(t *testing.T, img []byte) *testSignature {
+	t.Helper()
+	exem := parseTestMachO(t, img)
+	cs, ok := codesign.FindCodeSigCmd(exem)
+	if !ok {
+		t.Fatal("no LC_CODE_SIGNATURE")
+	}
+	be := binary.BigEndian
+	sb := img[cs.Dataoff : cs.Dataoff+cs.Datasize]
+	if be.Uint32(sb) != codesign.CSMAGIC_EMBEDDED_SIGNATURE || be.Uint32(sb[8:]) != 3 {
+		t.Fatalf("signature has magic %#x and %d blobs", be.Uint32(sb), be.Uint32(sb[8:]))
+	}
+	blob := func(i int, slot, magic uint32) []byte {
+		if typ := be.Uint32(sb[12+8*i:]); typ != slot {
+			t.Fatalf("blob %d has slot %#x, want %#x", i, typ, slot)
+		}
+		b := sb[be.Uint32(sb[16+8*i:]):]
+		if be.Uint32(b) != magic {
+			t.Fatalf("blob %d has magic %#x, want %#x", i, be.Uint32(b), magic)
+		}
+		return b[:be.Uint32(b[4:])]
+	}
+	s := &testSignature{
+		cd:           blob(0, codesign.CSSLOT_CODEDIRECTORY, codesign.CSMAGIC_CODEDIRECTORY),
+		requirements: blob(1, csslotRequirements, codesign.CSMAGIC_REQUIREMENTS),
+		cms:          blob(2, csslotSignature, csmagicBlobWrapper)[8:],
+	}
+	str := func(off uint32) string {
+		b := s.cd[off:]
+		return string(b[:bytes.IndexByte(b, 0)])
+	}
+	s.ident = str(be.Uint32(s.cd[20:]))
+	if off := be.Uint32(s.cd[48:]); off != 0 {
+		s.team = str(off)
+	}
+	return s
+}
+
+func TestMachoSign(t *testing.T) {
+	dir := t.TempDir()
+	var signed []byte // the code directory the signer was given
+	signer := &machoSigner{
+		ident: "com.example.hello",
+		team:  "TEAM123456",
+		sign: func(cd []byte) ([]byte, error) {
+			signed = append([]byte(nil), cd...)
+			h := sha256.Sum256(cd)
+			return append([]byte("cms "), h[:]...), nil
+		},
+	}
+	sign := func(name string, img []byte) ([]byte, error) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, img, 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := machoSign(path, true, signer)
+		out, rerr := os.ReadFile(path)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		return out, err
+	}
+
+	m := newTestMachO(testUuid)
+	m.size = 0x2800
+	for name, img := range map[string][]byte{"unsigned": newTestMachO(testUuid).bytes(), "ad hoc": m.signed()} {
+		img, err := sign(strings.ReplaceAll(name, " ", ""), img)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		exem := parseTestMachO(t, img)
+		cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cd, err := machoReadCodeDirectory(testMachOBuf(img), exem.ByteOrder, cmds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cd.AdHoc() || cd.Flags&csRuntime == 0 {
+			t.Errorf("%s: code directory flags %#x, want the hardened runtime and not ad hoc", name, cd.Flags)
+		}
+		if bad := testBadPages(t, img); bad != nil {
+			t.Errorf("%s: pages %v do not match their hashes", name, bad)
+		}
+		if seg := exem.Segment("__LINKEDIT"); seg.Offset+seg.Filesz != uint64(len(img)) || int64(len(img))-cd.CodeLimit != signer.size(cd.CodeLimit) {
+			t.Errorf("%s: __LINKEDIT ends at %#x, file at %#x, signed range at %#x", name, seg.Offset+seg.Filesz, len(img), cd.CodeLimit)
+		}
+
+		s := testReadSignature(t, img)
+		if !bytes.Equal(s.cd, signed) {
+			t.Errorf("%s: the code directory differs from the one signed", name)
+		}
+		h := sha256.Sum256(signed)
+		if want := append([]byte("cms "), h[:]...); !bytes.Equal(s.cms, want) {
+			t.Errorf("%s: CMS blob %q, want %q", name, s.cms, want)
+		}
+		if s.ident != signer.ident || s.team != signer.team {
+			t.Errorf("%s: identifier %q and team %q", name, s.ident, s.team)
+		}
+		// The hash of the requirements is in special slot 2, before
+		// those of the code pages.
+		req := sha256.Sum256(s.requirements)
+		if off := cd.HashOffset - 2*int64(cd.HashSize); !bytes.Equal(img[off:off+int64(cd.HashSize)], req[:]) {
+			t.Errorf("%s: special slot 2 does not hold the hash of the requirements", name)
+		}
+	}
+
+	// A signer error or an oversized signature fails the signing.
+	for _, tc := range []struct {
+		cms  []byte
+		err  error
+		want string
+	}{
+		{nil, fmt.Errorf("no key"), "no key"},
+		{nil, nil, "empty signature"},
+		{make([]byte, machoCMSReserve+1), nil, "larger than"},
+	} {
+		signer.sign = func([]byte) ([]byte, error) { return tc.cms, tc.err }
+		if _, err := sign("failed", m.signed()); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got %v, want an error with %q", err, tc.want)
+		}
+	}
+}
+
+const machoSignerSrc = `package main
+
+import (
+	"crypto/sha256"
+	"os"
+)
+
+// The signer prints a fake CMS signature: the hash of the code
+// directory.
+func main() {
+	cd, err := os.ReadFile(os.Args[len(os.Args)-1])
+	if err != nil {
+		panic(err)
+	}
+	h := sha256.Sum256(cd)
+	os.Stdout.Write(append([]byte("cms "), h[:]...))
+}
+`
+
+// TestMachoSigner links for darwin/arm64 with a -machosigner command and
+// checks the signature of the output.
+func TestMachoSigner(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for darwin/arm64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	toolSrc := filepath.Join(dir, "signer.go")
+	if err := os.WriteFile(toolSrc, []byte(machoSignerSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	tool := filepath.Join(dir, "signer.exe")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-o", tool, toolSrc)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.out")
+	cmd = testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-machosigner="+tool+" -machosignid=com.example.hello", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	img, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad := testBadPages(t, img); bad != nil {
+		t.Errorf("pages %v do not match their hashes", bad)
+	}
+	// The linker, not the go command, gave the build ID its content ID.
+	id, err := buildid.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hash, err := buildid.FindAndHash(bytes.NewReader(img), id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := buildid.HashToString(hash); !strings.HasSuffix(id, "/"+want) {
+		t.Errorf("build ID %q, want the content ID %s", id, want)
+	}
+	s := testReadSignature(t, img)
+	h := sha256.Sum256(s.cd)
+	if want := append([]byte("cms "), h[:]...); !bytes.Equal(s.cms, want) {
+		t.Errorf("CMS blob %q, want %q", s.cms, want)
+	}
+	if s.ident != "com.example.hello" || s.team != "" {
+		t.Errorf("identifier %q and team %q", s.ident, s.team)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+// machoDsymPlist is the Info.plist of a dSYM bundle, with the name of
+// the file it is for to fill in.
+const machoDsymPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleDevelopmentRegion</key>
+	<string>English</string>
+	<key>CFBundleIdentifier</key>
+	<string>com.apple.xcode.dsym.%s</string>
+	<key>CFBundleInfoDictionaryVersion</key>
+	<string>6.0</string>
+	<key>CFBundlePackageType</key>
+	<string>dSYM</string>
+	<key>CFBundleSignature</key>
+	<string>????</string>
+	<key>CFBundleShortVersionString</key>
+	<string>1.0</string>
+	<key>CFBundleVersion</key>
+	<string>1</string>
+</dict>
+</plist>
+`
+
+// machoSplitDwarf moves the __DWARF segment of the Mach-O file at path
+// into the dSYM bundle dsymPath, for -split-dwarf. isMain says whether
+// the file is an executable, for its new code signature if it has one.
+func machoSplitDwarf(path, dsymPath string, isMain bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	stripped, dsym, signed, err := machoStripDwarf(data)
+	if err != nil {
+		return err
+	}
+
+	// The DWARF file is named for the bundle, which is named for the
+	// output, as dsymutil does when given a name for the bundle.
+	base := strings.TrimSuffix(filepath.Base(dsymPath), ".dSYM")
+	contents := filepath.Join(dsymPath, "Contents")
+	dwarfDir := filepath.Join(contents, "Resources", "DWARF")
+	if err := os.MkdirAll(dwarfDir, 0777); err != nil {
+		return err
+	}
+	esc := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	plist := fmt.Sprintf(machoDsymPlist, esc.Replace(base))
+	if err := os.WriteFile(filepath.Join(contents, "Info.plist"), []byte(plist), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dwarfDir, base), dsym, 0644); err != nil {
+		return err
+	}
+	// Rewriting the output in place keeps its mode.
+	if err := os.WriteFile(path, stripped, 0); err != nil {
+		return err
+	}
+	if signed {
+		return machoAdHocSign(path, isMain)
+	}
+	return nil
+}
+
+// machoStripDwarf returns the 64-bit Mach-O file data without its
+// __DWARF segment, and the MH_DSYM file for a dSYM bundle that keeps it,
+// a copy of data. __DWARF must be followed only by __LINKEDIT, which
+// moves down in its place. It also reports whether the file has a code
+// signature, which the move leaves stale.
+func machoStripDwarf(data []byte) (stripped, dsym []byte, signed bool, err error) {
+	exem, err := machoParseFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if exem.Magic != macho.Magic64 {
+		return nil, nil, false, fmt.Errorf("splitting DWARF needs a 64-bit Mach-O file")
+	}
+	dwarf, linkseg := exem.Segment("__DWARF"), exem.Segment("__LINKEDIT")
+	if dwarf == nil || linkseg == nil {
+		return nil, nil, false, fmt.Errorf("no __DWARF and __LINKEDIT segments")
+	}
+	if linkseg.Offset < dwarf.Offset+dwarf.Filesz || linkseg.Offset+linkseg.Filesz != uint64(len(data)) {
+		return nil, nil, false, fmt.Errorf("__LINKEDIT does not follow __DWARF at the end of the file")
+	}
+	for _, l := range exem.Loads {
+		if s, ok := l.(*macho.Segment); ok && s != dwarf && s != linkseg && s.Filesz != 0 && s.Offset+s.Filesz > dwarf.Offset {
+			return nil, nil, false, fmt.Errorf("segment %s is after __DWARF", s.Name)
+		}
+	}
+	cmds, err := machoReadLoadCmds(bytes.NewReader(data), exem)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	order := exem.ByteOrder
+	dsym = append([]byte(nil), data...)
+	order.PutUint32(dsym[unsafe.Offsetof(exem.FileHeader.Type):], MH_DSYM)
+
+	delta := linkseg.Offset - dwarf.Offset
+	stripped = make([]byte, 0, uint64(len(data))-delta)
+	stripped = append(stripped, data[:dwarf.Offset]...)
+	stripped = append(stripped, data[linkseg.Offset:]...)
+
+	// Drop the command of __DWARF, and move __LINKEDIT down in the rest.
+	var rest []byte
+	var dwarfLen uint32
+	for _, c := range cmds {
+		if c.Cmd == LC_SEGMENT_64 && len(c.Data) >= 24 {
+			if name, _, _ := bytes.Cut(c.Data[8:24], []byte{0}); string(name) == "__DWARF" {
+				dwarfLen = uint32(len(c.Data))
+				continue
+			}
+		}
+		if c.Cmd == LC_CODE_SIGNATURE {
+			signed = true
+		}
+		rest = append(rest, c.Data...)
+	}
+	cmdOffset := machoHeaderSize(exem)
+	region := stripped[cmdOffset : cmdOffset+int64(exem.Cmdsz)]
+	copy(region, rest)
+	copy(region[len(rest):], make([]byte, len(region)-len(rest)))
+	order.PutUint32(stripped[unsafe.Offsetof(exem.FileHeader.Ncmd):], exem.Ncmd-1)
+	order.PutUint32(stripped[unsafe.Offsetof(exem.FileHeader.Cmdsz):], exem.Cmdsz-dwarfLen)
+
+	reader := loadCmdReader{next: cmdOffset, f: machoBytes(stripped), order: order}
+	for i := uint32(0); i < exem.Ncmd-1; i++ {
+		cmd, err := reader.Next()
+		if err == nil {
+			err = machoUpdateLinkeditCmd(reader, cmd.Cmd, linkseg, -delta)
+		}
+		if err != nil {
+			return nil, nil, false, machoLoadCmdError(i, cmd.Cmd, err)
+		}
+	}
+	return stripped, dsym, signed, nil
+}
+
+// machoBytes is a Mach-O file held in memory, which cannot grow.
+type machoBytes []byte
+
+func (b machoBytes) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b machoBytes) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(b)) {
+		return 0, fmt.Errorf("write of %d bytes at %#x outside the %#x-byte file", len(p), off, len(b))
+	}
+	return copy(b[off:], p), nil
+}
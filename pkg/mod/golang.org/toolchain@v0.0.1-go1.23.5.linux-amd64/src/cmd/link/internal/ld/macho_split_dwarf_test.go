@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/macho"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMachoSplitDwarfLink links a program for darwin/arm64, which is
+// signed, with -split-dwarf and checks the dSYM bundle and that the
+// stripped output is validly signed.
+func TestMachoSplitDwarfLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for darwin")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	dsym := filepath.Join(dir, "prog.dSYM")
+	exe := testSplitDwarfBuild(t, dir, dsym, "GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=0")
+
+	img, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := parseTestMachO(t, img)
+	if f.Segment("__DWARF") != nil {
+		t.Error("output has a __DWARF segment")
+	}
+	if f.Symtab == nil || len(f.Symtab.Syms) == 0 {
+		t.Error("output has no symbols")
+	}
+	if bad := testBadPages(t, img); len(bad) != 0 {
+		t.Errorf("pages %v of the output do not match its signature", bad)
+	}
+
+	if _, err := os.Stat(filepath.Join(dsym, "Contents", "Info.plist")); err != nil {
+		t.Error(err)
+	}
+	df, err := macho.Open(filepath.Join(dsym, "Contents", "Resources", "DWARF", "prog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+	if df.Type != MH_DSYM {
+		t.Errorf("dSYM file has type %v, want MH_DSYM", df.Type)
+	}
+	if _, err := df.DWARF(); err != nil {
+		t.Errorf("dSYM DWARF: %v", err)
+	}
+	got, ok := machoFileUuid(f)
+	want, dok := machoFileUuid(df)
+	if ok != dok || got != want {
+		t.Errorf("output UUID %x, dSYM UUID %x", got, want)
+	}
+}
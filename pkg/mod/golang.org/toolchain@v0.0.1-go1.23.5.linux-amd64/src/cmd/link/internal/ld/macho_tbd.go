@@ -0,0 +1,282 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the resolution, when linking internally for
+// darwin, of the symbols that host objects take from dynamic libraries,
+// using the text-based stubs (.tbd files) of an SDK in place of the
+// libraries. Otherwise each such symbol must be imported by a
+// cgo_import_dynamic directive, which the go command can only write
+// with a C toolchain that links for the target, and a cgo program that
+// does without them must be linked externally.
+//
+// The stubs read are those -machostub gives, those that the -l and
+// -framework options of the cgo LDFLAGS and -extldflags name, looked for
+// in their -L and -F directories and then in the SDK, and that of
+// libSystem in the SDK, which the system linker always links with. The
+// SDK is the one -machosdk gives or, on macOS, the one xcrun finds.
+// The libraries a stub re-exports are read from the SDK, unless the stub
+// inlines them, and their exports bound to the library re-exporting
+// them, as the system linker does.
+
+import (
+	"cmd/internal/sys"
+	"cmd/link/internal/loadtbd"
+	"cmd/link/internal/sym"
+	"fmt"
+	"internal/buildcfg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var flagMachoStubs []string // the files given by -machostub
+
+// machoStubExports are the libraries that the symbols the stubs export
+// are bound to, by the names of the symbols without their leading
+// underscore, once machoResolveStubs has read them.
+var machoStubExports map[string]string
+
+// machoStubFiles returns the .tbd files that the -l and -framework
+// options of args name, after those of -machostub, and that of libSystem
+// in sdk, in the order they are searched for a symbol. An -l or
+// -framework option of a library with no stub, such as a static one,
+// is skipped.
+func machoStubFiles(sdk string, args []string) []string {
+	var libDirs, frameworkDirs, names []string
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "-framework" && i+1 < len(args):
+			i++
+			names = append(names, filepath.Join(args[i]+".framework", args[i]+".tbd"))
+		case strings.HasPrefix(a, "-L") && len(a) > 2:
+			libDirs = append(libDirs, a[2:])
+		case strings.HasPrefix(a, "-F") && len(a) > 2:
+			frameworkDirs = append(frameworkDirs, a[2:])
+		case strings.HasPrefix(a, "-l") && len(a) > 2:
+			names = append(names, "lib"+a[2:]+".tbd")
+		}
+	}
+	if sdk != "" {
+		libDirs = append(libDirs, filepath.Join(sdk, "usr", "lib"))
+		frameworkDirs = append(frameworkDirs, filepath.Join(sdk, "System", "Library", "Frameworks"))
+	}
+
+	files := append([]string(nil), flagMachoStubs...)
+	for _, name := range names {
+		dirs := libDirs
+		if strings.Contains(name, ".framework") {
+			dirs = frameworkDirs
+		}
+		for _, dir := range dirs {
+			if file := filepath.Join(dir, name); fileExists(file) {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	if file := filepath.Join(sdk, "usr", "lib", "libSystem.tbd"); sdk != "" && fileExists(file) {
+		files = append(files, file)
+	}
+	return files
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// A machoStubSet is the exports of a set of .tbd files for a target.
+type machoStubSet struct {
+	sdk, arch, platform string
+	libs                map[string]*loadtbd.Library // by install name
+	added               map[string]bool             // the install names of the libraries added
+	exports             map[string]string
+}
+
+// readMachoStubs returns the library each symbol that the .tbd files
+// export is bound to, by its name without the leading underscore. A
+// symbol two of them export is bound to the library of the first.
+func readMachoStubs(files []string, sdk, arch, platform string) (map[string]string, error) {
+	s := &machoStubSet{
+		sdk:      sdk,
+		arch:     arch,
+		platform: platform,
+		libs:     make(map[string]*loadtbd.Library),
+		added:    make(map[string]bool),
+		exports:  make(map[string]string),
+	}
+	for _, file := range files {
+		libs, err := s.read(file)
+		if err != nil {
+			return nil, err
+		}
+		// The libraries the file inlines are bound to the first
+		// library if it re-exports them, and to themselves if not.
+		for _, lib := range libs {
+			if err := s.add(lib, lib.InstallName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s.exports, nil
+}
+
+// read reads the .tbd file.
+func (s *machoStubSet) read(file string) ([]*loadtbd.Library, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	libs, err := loadtbd.Parse(data, s.arch, s.platform)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for _, lib := range libs {
+		if s.libs[lib.InstallName] == nil {
+			s.libs[lib.InstallName] = lib
+		}
+	}
+	return libs, nil
+}
+
+// add binds the exports of lib, and of the libraries it re-exports, to
+// the library installName.
+func (s *machoStubSet) add(lib *loadtbd.Library, installName string) error {
+	if s.added[lib.InstallName] {
+		return nil
+	}
+	s.added[lib.InstallName] = true
+	for _, name := range lib.Symbols {
+		// As loadmacho names the symbols of host objects.
+		name = strings.TrimPrefix(name, "_")
+		if _, ok := s.exports[name]; !ok {
+			s.exports[name] = installName
+		}
+	}
+	for _, r := range lib.Reexports {
+		if s.libs[r] == nil && s.sdk != "" {
+			if file := filepath.Join(s.sdk, strings.TrimSuffix(r, ".dylib")+".tbd"); fileExists(file) {
+				if _, err := s.read(file); err != nil {
+					return err
+				}
+			}
+		}
+		if rlib := s.libs[r]; rlib != nil {
+			if err := s.add(rlib, installName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// machoStubTarget returns the architecture and platform of the output,
+// as .tbd files name them. It is called before domacho sets
+// machoPlatform, which it finds the same way.
+func machoStubTarget(ctxt *Link) (arch, platform string) {
+	switch ctxt.Arch.Family {
+	case sys.AMD64:
+		arch = "x86_64"
+	case sys.ARM64:
+		arch = "arm64"
+	default:
+		Exitf("no .tbd architecture for %s", ctxt.Arch.Name)
+	}
+	p := PLATFORM_MACOS
+	if buildcfg.GOOS == "ios" {
+		p = PLATFORM_IOS
+	}
+	if *flagPlatformVersion != "" {
+		// An invalid setting is reported once the libraries are loaded.
+		if v, err := machoParsePlatformVersion(*flagPlatformVersion, buildcfg.GOOS); err == nil {
+			p = v.platform
+		}
+	} else {
+		for _, h := range hostobj {
+			if load, err := hostobjMachoPlatform(&h); err == nil && load != nil {
+				p = load.platform
+				break
+			}
+		}
+	}
+	for _, n := range machoPlatformNames {
+		if n.platform == p {
+			return arch, n.tbd
+		}
+	}
+	Exitf("no .tbd platform for Mach-O platform %d", p)
+	return "", ""
+}
+
+// machoStubSDK returns the SDK of the stubs, or "" if there is none.
+func machoStubSDK(ctxt *Link, platform string) string {
+	if *flagMachoSDK != "" || runtime.GOOS != "darwin" || platform != "macos" {
+		return *flagMachoSDK
+	}
+	out, err := exec.Command("xcrun", "--sdk", "macosx", "--show-sdk-path").Output()
+	if err != nil {
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("not using the stubs of an SDK because xcrun failed: %v\n", err)
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// machoResolveStubs makes each symbol that the loaded code refers to,
+// that nothing defines and that a stub exports, a dynamic import of the
+// library that the stub binds it to.
+func machoResolveStubs(ctxt *Link) {
+	ldr := ctxt.loader
+	undefs, _ := ldr.UndefinedRelocTargets(-1)
+	if len(undefs) == 0 {
+		return
+	}
+	if machoStubExports == nil {
+		arch, platform := machoStubTarget(ctxt)
+		sdk := machoStubSDK(ctxt, platform)
+		files := machoStubFiles(sdk, append(append([]string(nil), ldflag...), flagExtldflags...))
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("Mach-O stubs for %s-%s: %q\n", arch, platform, files)
+		}
+		exports, err := readMachoStubs(files, sdk, arch, platform)
+		if err != nil {
+			Exitf("reading .tbd stubs: %v", err)
+		}
+		machoStubExports = exports
+	}
+	for _, s := range undefs {
+		if ldr.SymType(s) != sym.SXREF {
+			continue // resolved by an earlier reference
+		}
+		name := ldr.SymName(s)
+		lib, ok := machoStubExports[name]
+		if !ok {
+			continue
+		}
+		if ctxt.Debugvlog > 1 {
+			ctxt.Logf("%s: dynamic import from %s\n", name, lib)
+		}
+		ldr.SetSymDynimplib(s, lib)
+		ldr.SetSymExtname(s, name)
+		su := ldr.MakeSymbolUpdater(s)
+		su.SetType(sym.SDYNIMPORT)
+		machoadddynlib(lib, ctxt.LinkMode)
+		havedynamic = 1
+	}
+}
+
+// machoStubHostlinkArgs returns the arguments that give the SDK and the
+// stubs of -machosdk and -machostub to the external linker.
+func machoStubHostlinkArgs() []string {
+	var argv []string
+	if *flagMachoSDK != "" {
+		argv = append(argv, "-isysroot", *flagMachoSDK)
+	}
+	return append(argv, flagMachoStubs...)
+}
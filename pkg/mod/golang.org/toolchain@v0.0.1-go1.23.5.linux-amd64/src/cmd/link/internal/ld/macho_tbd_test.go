@@ -0,0 +1,226 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTBD returns a .tbd document for arm64-macos of the library
+// installName, which exports syms and re-exports reexports.
+func testTBD(installName string, reexports, syms []string) string {
+	s := fmt.Sprintf("--- !tapi-tbd\ntbd-version: 4\ntargets: [ arm64-macos ]\ninstall-name: '%s'\n", installName)
+	if len(reexports) > 0 {
+		s += fmt.Sprintf("reexported-libraries:\n  - targets: [ arm64-macos ]\n    libraries: [ %s ]\n", strings.Join(reexports, ", "))
+	}
+	return s + fmt.Sprintf("exports:\n  - targets: [ arm64-macos ]\n    symbols: [ %s ]\n", strings.Join(syms, ", "))
+}
+
+func TestMachoStubs(t *testing.T) {
+	defer func(s []string) { flagMachoStubs = s }(flagMachoStubs)
+
+	dir := t.TempDir()
+	sdk := filepath.Join(dir, "sdk")
+	cf := "/System/Library/Frameworks/CoreFoundation.framework/Versions/A/CoreFoundation"
+	files := map[string]string{
+		// libSystem inlines libsystem_c and re-exports the
+		// libsystem_kernel of a stub of its own.
+		"sdk/usr/lib/libSystem.tbd": testTBD("/usr/lib/libSystem.B.dylib",
+			[]string{"/usr/lib/system/libsystem_c.dylib", "/usr/lib/system/libsystem_kernel.dylib"}, []string{"_mach_init_routine"}) +
+			testTBD("/usr/lib/system/libsystem_c.dylib", nil, []string{"_puts", "_printf"}),
+		"sdk/usr/lib/system/libsystem_kernel.tbd": testTBD("/usr/lib/system/libsystem_kernel.dylib", nil, []string{"_write"}),
+		"sdk/usr/lib/libz.tbd":                    testTBD("/usr/lib/libz.1.dylib", nil, []string{"_inflate"}),
+		"sdk/System/Library/Frameworks/Foundation.framework/Foundation.tbd": testTBD(
+			"/System/Library/Frameworks/Foundation.framework/Versions/C/Foundation", []string{cf}, []string{"_NSLog"}),
+		"sdk/System/Library/Frameworks/CoreFoundation.framework/Versions/A/CoreFoundation.tbd": testTBD(cf, nil, []string{"_CFRelease"}),
+		// A libz of -L, which takes precedence over that of the SDK.
+		"lib/libz.tbd": testTBD("@rpath/libz.dylib", nil, []string{"_deflate"}),
+		// The stub of -machostub comes first.
+		"puts.tbd": testTBD("/opt/lib/libputs.dylib", nil, []string{"_puts"}),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flagMachoStubs = []string{filepath.Join(dir, "puts.tbd")}
+	got := machoStubFiles(sdk, []string{"-L" + filepath.Join(dir, "lib"), "-lz", "-lmissing", "-framework", "Foundation", "-O2"})
+	want := []string{
+		filepath.Join(dir, "puts.tbd"),
+		filepath.Join(dir, "lib/libz.tbd"),
+		filepath.Join(sdk, "System/Library/Frameworks/Foundation.framework/Foundation.tbd"),
+		filepath.Join(sdk, "usr/lib/libSystem.tbd"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("stub files %q, want %q", got, want)
+	}
+
+	exports, err := readMachoStubs(got, sdk, "arm64", "macos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantExports := map[string]string{
+		"puts":              "/opt/lib/libputs.dylib",
+		"deflate":           "@rpath/libz.dylib",
+		"NSLog":             "/System/Library/Frameworks/Foundation.framework/Versions/C/Foundation",
+		"CFRelease":         "/System/Library/Frameworks/Foundation.framework/Versions/C/Foundation",
+		"mach_init_routine": "/usr/lib/libSystem.B.dylib",
+		"printf":            "/usr/lib/libSystem.B.dylib",
+		"write":             "/usr/lib/libSystem.B.dylib",
+	}
+	if !reflect.DeepEqual(exports, wantExports) {
+		t.Errorf("exports %q, want %q", exports, wantExports)
+	}
+
+	if _, err := readMachoStubs(got, sdk, "x86_64", "macos"); err == nil || !strings.Contains(err.Error(), "puts.tbd: /opt/lib/libputs.dylib does not support x86_64-macos") {
+		t.Errorf("x86_64: got %v, want an error about puts.tbd", err)
+	}
+}
+
+// testMachOCallPuts returns an amd64 Mach-O object whose function cfunc
+// jumps to puts.
+func testMachOCallPuts() []byte {
+	le := binary.LittleEndian
+	const (
+		cmdsSize = 72 + 80 + 24 // LC_SEGMENT_64 with a section, LC_SYMTAB
+		textOff  = 32 + cmdsSize
+		relOff   = textOff + 8
+		symOff   = relOff + 8
+		strOff   = symOff + 2*16
+	)
+	strtab := "\x00_cfunc\x00_puts\x00"
+	name := func(s string) []byte { return append([]byte(s), make([]byte, 16-len(s))...) }
+
+	b := le.AppendUint32(nil, macho.Magic64)
+	b = le.AppendUint32(b, uint32(macho.CpuAmd64))
+	b = le.AppendUint32(b, 3) // CPU_SUBTYPE_X86_64_ALL
+	b = le.AppendUint32(b, uint32(macho.TypeObj))
+	b = le.AppendUint32(b, 2) // ncmds
+	b = le.AppendUint32(b, cmdsSize)
+	b = le.AppendUint64(b, 0) // flags, reserved
+
+	b = le.AppendUint32(b, uint32(macho.LoadCmdSegment64))
+	b = le.AppendUint32(b, 72+80)
+	b = append(b, name("")...)
+	b = le.AppendUint64(b, 0) // vmaddr
+	b = le.AppendUint64(b, 8) // vmsize
+	b = le.AppendUint64(b, textOff)
+	b = le.AppendUint64(b, 8) // filesize
+	b = le.AppendUint32(b, 7) // maxprot
+	b = le.AppendUint32(b, 7) // initprot
+	b = le.AppendUint32(b, 1) // nsects
+	b = le.AppendUint32(b, 0) // flags
+	b = append(append(b, name("__text")...), name("__TEXT")...)
+	b = le.AppendUint64(b, 0) // addr
+	b = le.AppendUint64(b, 8) // size
+	b = le.AppendUint32(b, textOff)
+	b = le.AppendUint32(b, 0) // align
+	b = le.AppendUint32(b, relOff)
+	b = le.AppendUint32(b, 1)          // nreloc
+	b = le.AppendUint32(b, 0x80000400) // S_ATTR_PURE_INSTRUCTIONS|S_ATTR_SOME_INSTRUCTIONS
+	b = append(b, make([]byte, 12)...) // reserved
+
+	b = le.AppendUint32(b, uint32(macho.LoadCmdSymtab))
+	b = le.AppendUint32(b, 24)
+	b = le.AppendUint32(b, symOff)
+	b = le.AppendUint32(b, 2) // nsyms
+	b = le.AppendUint32(b, strOff)
+	b = le.AppendUint32(b, uint32(len(strtab)))
+
+	// jmp puts, padded with nops.
+	b = append(b, 0xe9, 0, 0, 0, 0, 0x90, 0x90, 0x90)
+	// A pc-relative 4-byte X86_64_RELOC_BRANCH to symbol 1 at offset 1.
+	b = le.AppendUint32(b, 1)
+	b = le.AppendUint32(b, 1|1<<24|2<<25|1<<27|2<<28)
+	// cfunc, defined in section 1, and puts, undefined.
+	b = le.AppendUint32(b, 1)
+	b = append(b, 0x0f, 1)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint64(b, 0)
+	b = le.AppendUint32(b, 8)
+	b = append(b, 0x01, 0)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint64(b, 0)
+	return append(b, strtab...)
+}
+
+// TestMachoStubLink links a program with a host object that calls puts
+// internally for darwin/amd64, with no cgo_import_dynamic directive for
+// puts, and checks that -machostub makes puts a dynamic import.
+func TestMachoStubLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for darwin/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"go.mod":        "module stub\n",
+		"main.go":       "package main\n\nfunc cfunc()\n\nfunc main() { cfunc() }\n",
+		"cfunc_amd64.s": "#include \"textflag.h\"\n\nTEXT ·cfunc(SB),NOSPLIT,$0\n\tJMP cfunc(SB)\n",
+		"cfunc.syso":    string(testMachOCallPuts()),
+		"puts.tbd":      testTBD("/opt/lib/libputs.dylib", nil, []string{"_puts"}),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := func(ldflags string) ([]byte, error) {
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal "+ldflags, "-o", "a.out")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH=amd64", "CGO_ENABLED=0")
+		return cmd.CombinedOutput()
+	}
+
+	if out, err := build(""); err == nil || !bytes.Contains(out, []byte("puts")) {
+		t.Errorf("without a stub: %v\n%s", err, out)
+	}
+	tbd := strings.Replace(testTBD("/opt/lib/libputs.dylib", nil, []string{"_puts"}), "arm64", "x86_64", -1)
+	if err := os.WriteFile(filepath.Join(dir, "puts.tbd"), []byte(tbd), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := build("-machostub=puts.tbd"); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	f, err := macho.Open(filepath.Join(dir, "a.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, err := f.ImportedSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	has := func(list []string, s string) bool {
+		for _, x := range list {
+			if x == s {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(libs, "/opt/lib/libputs.dylib") || !has(syms, "_puts") {
+		t.Errorf("imported libraries %q and symbols %q, want /opt/lib/libputs.dylib and _puts among them", libs, syms)
+	}
+}
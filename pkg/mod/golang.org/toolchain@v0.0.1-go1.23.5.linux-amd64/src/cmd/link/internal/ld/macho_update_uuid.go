@@ -18,10 +18,26 @@ package ld
 // final executable generated by the external linker.
 
 import (
+	"bufio"
+	"bytes"
 	"cmd/internal/notsha256"
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	cryptorand "crypto/rand"
 	"debug/macho"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"unsafe"
 )
 
@@ -29,11 +45,216 @@ import (
 // bytes suitable for use as the payload in a Macho LC_UUID load
 // command.
 func uuidFromGoBuildId(buildID string) []byte {
+	return uuidFromGoBuildIdVersion(buildID, uuidVersion)
+}
+
+// uuidVersion is the RFC 4122 version, set by -uuidversion, of the
+// UUIDs derived from the Go build ID.
+var uuidVersion = 3
+
+// uuidFromGoBuildIdVersion is uuidFromGoBuildId for a given UUID
+// version: 3 or 5, a "name-based" UUID that is the hash of the build
+// ID, or 4, a "random" UUID whose bytes are that hash mixed with the
+// output of a generator seeded with it so that they are still
+// reproducible. Versions 4 and 5
+// are for consumers that reject the version 3 UUIDs, whose variant bits
+// do not follow RFC 4122 either; version 3 keeps those bits as they
+// have always been.
+func uuidFromGoBuildIdVersion(buildID string, version int) []byte {
+	if buildID == "" {
+		return make([]byte, 16)
+	}
+	switch version {
+	case 4:
+		// A math/rand source has fewer than 2^31 distinct seeds, far
+		// too few for its output alone to be unique per build ID, so
+		// mix it into the full hash rather than using it on its own.
+		rv := deriveDeterministicID(buildID, 16)
+		rng := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(rv))))
+		var r [16]byte
+		rng.Read(r[:])
+		for i := range rv {
+			rv[i] ^= r[i]
+		}
+		return uuidSetVersion(rv, 4)
+	case 5:
+		return uuidSetVersion(deriveDeterministicID(buildID, 16), 5)
+	}
+	return uuidFromHash(deriveDeterministicID(buildID, 16))
+}
+
+// uuidFromGoBuildIdCpu is uuidFromGoBuildId salted with the cpu type
+// of an architecture, for -uuidpercpu: the slices of a fat binary built
+// from the same build ID get distinct UUIDs, each still determined by
+// the build ID and its architecture alone.
+func uuidFromGoBuildIdCpu(buildID string, cpu macho.Cpu) []byte {
 	if buildID == "" {
 		return make([]byte, 16)
 	}
-	hashedBuildID := notsha256.Sum256([]byte(buildID))
-	rv := hashedBuildID[:16]
+	// A build ID never contains a NUL, so the salted ID cannot be
+	// another build ID.
+	return uuidFromGoBuildId(fmt.Sprintf("%s\x00cpu%d", buildID, uint32(cpu)))
+}
+
+// uuidPgoHash is the hash, in hex, of the PGO profile given by
+// -uuidpgo, or "".
+var uuidPgoHash string
+
+// machoPgoProfileHash returns the hash, in hex, of the contents of the
+// PGO profile at path, for -uuidpgo. Only the contents count, so the
+// same profile gives the same UUIDs wherever it is kept.
+func machoPgoProfileHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := notsha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// uuidImageDigest is the digest of the container image the link runs
+// in, given by -uuidimage or $GO_IMAGE_DIGEST, or "".
+var uuidImageDigest string
+
+// machoCheckImageDigest returns an error if digest is not an image
+// digest of the form algorithm:encoded, such as "sha256:" and 64 hex
+// digits, as used by OCI and Docker image references.
+func machoCheckImageDigest(digest string) error {
+	alg, enc, ok := strings.Cut(digest, ":")
+	if !ok || alg == "" || enc == "" {
+		return fmt.Errorf("image digest %q is not of the form algorithm:encoded", digest)
+	}
+	for _, c := range alg {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.ContainsRune("+._-", c)) {
+			return fmt.Errorf("image digest %q has an invalid algorithm", digest)
+		}
+	}
+	for _, c := range enc {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("=_-", c)) {
+			return fmt.Errorf("image digest %q has an invalid encoded part", digest)
+		}
+	}
+	return nil
+}
+
+// buildIDContent returns the content ID of the Go build ID buildID:
+// its last slash-separated component, which follows the action IDs, or
+// buildID itself if it has no slash.
+func buildIDContent(buildID string) string {
+	return buildID[strings.LastIndexByte(buildID, '/')+1:]
+}
+
+// machoSaltBuildId returns buildID, or with -uuidcontent its content ID
+// alone, salted with the -uuidpgo profile hash and then the -uuidimage
+// digest, for those there are, so that links of the same build ID with
+// different profiles, or in different toolchain images, get distinct
+// UUIDs.
+func machoSaltBuildId(buildID string) string {
+	if buildID == "" {
+		return buildID
+	}
+	if *flagUuidContent {
+		buildID = buildIDContent(buildID)
+	}
+	// As for the cpu salt, the NUL keeps the salted ID from being
+	// another build ID.
+	if uuidPgoHash != "" {
+		buildID += "\x00pgo" + uuidPgoHash
+	}
+	if uuidImageDigest != "" {
+		buildID += "\x00image" + uuidImageDigest
+	}
+	return buildID
+}
+
+// machoBuildIdUuid returns the LC_UUID payload derived from buildID,
+// salted as machoSaltBuildId does, for the
+// architecture cpu: uuidFromGoBuildIdCpu with -uuidpercpu, and
+// uuidFromGoBuildId, which is the same for every architecture,
+// otherwise.
+func machoBuildIdUuid(buildID string, cpu macho.Cpu) []byte {
+	buildID = machoSaltBuildId(buildID)
+	if *flagUuidPerCpu {
+		return uuidFromGoBuildIdCpu(buildID, cpu)
+	}
+	return uuidFromGoBuildId(buildID)
+}
+
+// buildIDsEquivalentForUUID reports whether the Go build IDs a and b
+// give the same LC_UUID, for build systems deciding whether a cached
+// binary can stand in for a new link. By default every component of a
+// build ID goes into the UUID, so two IDs are only equivalent if they
+// are equal; with -uuidcontent only the content ID does, so IDs that
+// differ only in their action IDs are equivalent. The comparison is
+// made on the derived UUIDs so that it keeps agreeing with
+// machoBuildIdUuid.
+func buildIDsEquivalentForUUID(a, b string) bool {
+	return bytes.Equal(uuidFromGoBuildId(machoSaltBuildId(a)), uuidFromGoBuildId(machoSaltBuildId(b)))
+}
+
+// machoTargetCpu returns the Mach-O cpu type of the architecture being
+// linked.
+func machoTargetCpu(arch *sys.Arch) macho.Cpu {
+	if arch.Family == sys.ARM64 {
+		return MACHO_CPU_ARM64
+	}
+	return MACHO_CPU_AMD64
+}
+
+// uuidSetVersion sets the version of the UUID rv and marks it as an
+// RFC 4122 variant UUID.
+func uuidSetVersion(rv []byte, version byte) []byte {
+	rv[6] = rv[6]&0x0f | version<<4
+	rv[8] = rv[8]&0x3f | 0x80
+	return rv
+}
+
+// uuidCheckCollisions reports an error listing every pair of distinct
+// build IDs in buildIDs that uuidOf, normally uuidFromGoBuildId, maps
+// to the same UUID. Truncating the build ID hash makes that
+// astronomically unlikely, but not impossible; this is a check for
+// tests and diagnostics, not for the link itself.
+func uuidCheckCollisions(buildIDs []string, uuidOf func(string) []byte) error {
+	seen := make(map[string]string, len(buildIDs))
+	var msgs []string
+	for _, id := range buildIDs {
+		uuid := string(uuidOf(id))
+		prev, ok := seen[uuid]
+		if !ok {
+			seen[uuid] = id
+			continue
+		}
+		if prev != id {
+			msgs = append(msgs, fmt.Sprintf("build IDs %q and %q both give UUID %x", prev, id, uuid))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("UUID collisions:\n\t%s", strings.Join(msgs, "\n\t"))
+	}
+	return nil
+}
+
+// deriveDeterministicID returns an n-byte fingerprint of the build with
+// Go build ID buildID, for identifiers other than the LC_UUID, such as
+// a compact one stored in a custom section. It is the hash the LC_UUID
+// is made from, truncated to n bytes, so shorter IDs are prefixes of
+// longer ones and all of them agree with the UUID outside its version
+// and variant bits. n must be between 1 and 32.
+func deriveDeterministicID(buildID string, n int) []byte {
+	if n < 1 || n > notsha256.Size {
+		panic(fmt.Sprintf("deriveDeterministicID: bad length %d", n))
+	}
+	if buildID == "" {
+		return make([]byte, n)
+	}
+	h := notsha256.Sum256([]byte(buildID))
+	return h[:n:n]
+}
+
+// uuidFromHash truncates hash to 16 bytes and sets the UUID version and
+// variant bits.
+func uuidFromHash(hash []byte) []byte {
+	rv := hash[:16]
 
 	// RFC 4122 conformance (see RFC 4122 Sections 4.2.2, 4.1.3). We
 	// want the "version" of this UUID to appear as 'hashed' as opposed
@@ -50,54 +271,1233 @@ func uuidFromGoBuildId(buildID string) []byte {
 	return rv
 }
 
+// uuidCodeSections lists the sections, as segment and section name
+// pairs, whose contents -uuidfromcode hashes into the UUID. It is nil
+// if the UUID is derived from the Go build ID instead.
+var uuidCodeSections [][2]string
+
+// machoParseUuidFromCode parses the value of -uuidfromcode, a comma
+// separated list of "text" (the __TEXT,__text section) and "data" (the
+// __DATA,__data section).
+func machoParseUuidFromCode(s string) ([][2]string, error) {
+	var sects [][2]string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		var sect [2]string
+		switch name {
+		case "text":
+			sect = [2]string{"__TEXT", "__text"}
+		case "data":
+			sect = [2]string{"__DATA", "__data"}
+		default:
+			return nil, fmt.Errorf("unknown section %q, want text or data", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("section %q listed twice", name)
+		}
+		seen[name] = true
+		sects = append(sects, sect)
+	}
+	return sects, nil
+}
+
+// uuidFromCode hashes the contents of the given sections of exem and
+// returns a slice of 16 bytes suitable for use as the payload in a
+// Macho LC_UUID load command. Unlike the build ID, this ties the UUID
+// to the code actually present in the file.
+func uuidFromCode(exem *macho.File, sections [][2]string) ([]byte, error) {
+	h := notsha256.New()
+	buf := make([]byte, 64<<10)
+	for _, want := range sections {
+		var sect *macho.Section
+		for _, s := range exem.Sections {
+			if s.Seg == want[0] && s.Name == want[1] {
+				sect = s
+				break
+			}
+		}
+		if sect == nil {
+			return nil, fmt.Errorf("no %s,%s section to derive the UUID from", want[0], want[1])
+		}
+		// Frame each section so that moving bytes from one section
+		// to the other changes the hash.
+		var size [8]byte
+		binary.LittleEndian.PutUint64(size[:], sect.Size)
+		h.Write([]byte(want[0] + "," + want[1] + "\x00"))
+		h.Write(size[:])
+		// Stream the section through the hash in chunks rather than
+		// reading it all into memory: __text can be hundreds of
+		// megabytes.
+		n, err := io.CopyBuffer(h, io.NewSectionReader(sect, 0, int64(sect.Size)), buf)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(n) != sect.Size {
+			return nil, fmt.Errorf("section %s,%s: %v", want[0], want[1], io.ErrUnexpectedEOF)
+		}
+	}
+	return uuidFromHash(h.Sum(nil)), nil
+}
+
+// uuidLikeLd64 returns the LC_UUID payload for the Mach-O file f,
+// described by exem, that -uuidld64 asks for: an approximation of the
+// UUID ld64 computes from its output, for tools that match binaries by
+// that UUID. Like ld64, it hashes the file up to its code signature,
+// which is written after the UUID, with the LC_UUID payload zeroed,
+// and uses the hash as a version 3 UUID. The hash is SHA-256 rather
+// than the notsha256 used for the other UUIDs, to use a standard hash
+// over those bytes. ld64 also leaves out the stabs that record object
+// file paths and times, which this does not, so the two only agree for
+// binaries without them, if at all: the hash ld64 uses is not
+// documented and has changed between versions.
+func uuidLikeLd64(f io.ReaderAt, exem *macho.File) ([]byte, error) {
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return nil, err
+	}
+	uuidOff, end := int64(-1), int64(1<<62) // end: all of the file
+	for _, c := range cmds {
+		switch c.Cmd {
+		case LC_UUID:
+			if len(c.Data) < 24 {
+				return nil, fmt.Errorf("LC_UUID at %#x %w (%d bytes)", c.Offset, errLoadCmdShort, len(c.Data))
+			}
+			uuidOff = c.Offset + 8
+		case LC_CODE_SIGNATURE:
+			if len(c.Data) < 16 {
+				return nil, fmt.Errorf("LC_CODE_SIGNATURE at %#x too short (%d bytes)", c.Offset, len(c.Data))
+			}
+			end = int64(exem.ByteOrder.Uint32(c.Data[8:]))
+		}
+	}
+	if uuidOff < 0 {
+		return nil, fmt.Errorf("no LC_UUID load command")
+	}
+	if end < uuidOff+16 {
+		return nil, fmt.Errorf("code signature at %#x precedes the end of the load commands", end)
+	}
+	h := notsha256.New()
+	buf := make([]byte, 64<<10)
+	if _, err := io.CopyBuffer(h, io.NewSectionReader(f, 0, uuidOff), buf); err != nil {
+		return nil, err
+	}
+	h.Write(make([]byte, 16))
+	if _, err := io.CopyBuffer(h, io.NewSectionReader(f, uuidOff+16, end-uuidOff-16), buf); err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+	for i := range sum {
+		sum[i] ^= 0xFF // convert notsha256 to sha256, as cmd/internal/codesign does
+	}
+	return uuidFromHash(sum), nil
+}
+
+// machoShouldRewriteUuid reports whether hostlink needs to rewrite the
+// LC_UUID of the external linker's output. Internal linking already
+// writes a deterministic UUID (from the build ID, see buildinfo), so
+// there is nothing to rewrite, and doing so anyway would process the
+// file twice.
+func machoShouldRewriteUuid(ctxt *Link) bool {
+	if !ctxt.IsDarwin() {
+		return false
+	}
+	if !ctxt.IsExternal() {
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("not rewriting LC_UUID: not linking externally\n")
+		}
+		return false
+	}
+	if !machoLinkSetsUuid() {
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("not rewriting LC_UUID: left to a later step by -repropasses or -uuidmanifest\n")
+		}
+		return false
+	}
+	return machoHaveOutputUuid()
+}
+
+// machoHaveOutputUuid reports whether the command line gives
+// machoOutputUuid something to compute the UUID from.
+func machoHaveOutputUuid() bool {
+	return *flagBuildid != "" || forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64
+}
+
+// machoOpenFile is os.OpenFile, replaced in tests to simulate
+// filesystems with unusual behavior.
+var machoOpenFile = os.OpenFile
+
+// machoCreateOutput opens path for reading and writing as an empty
+// file, creating it if necessary, as os.OpenFile does with O_TRUNC.
+// Some network and overlay filesystems reject O_TRUNC, or accept it
+// and leave the old contents in place; then the file is removed and
+// created afresh.
+func machoCreateOutput(path string, perm os.FileMode) (*os.File, error) {
+	f, err := machoOpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err == nil {
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		if err == nil && fi.Size() == 0 {
+			return f, nil
+		}
+		f.Close()
+		if err == nil {
+			err = fmt.Errorf("open %s: O_TRUNC left %d bytes in place", path, fi.Size())
+		}
+	}
+	if rmErr := os.Remove(path); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+		return nil, err
+	}
+	return machoOpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+}
+
+// The steps machoReplaceOutput takes, replaced in tests to record them
+// or to simulate a crash between them.
+var (
+	machoSyncFile = machoSyncPath
+	machoSyncDir  = machoSyncPath
+	machoRename   = os.Rename
+)
+
+// machoSyncPath flushes the file or directory at path to stable
+// storage.
+func machoSyncPath(path string) error {
+	if runtime.GOOS == "windows" {
+		// Directories cannot be opened for syncing, and renames are
+		// flushed by the filesystem.
+		if fi, err := os.Stat(path); err != nil || fi.IsDir() {
+			return err
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// machoReplaceOutput replaces the file out with tmp, a complete
+// rewritten copy of it in the same directory, so that a crash at any
+// point leaves out either as it was or fully rewritten, never partly
+// written: tmp is synced, the directory is synced so that tmp's entry
+// is durable, tmp is renamed over out, which readers see atomically,
+// and the directory is synced again to make the rename durable. If a
+// step before the rename fails, tmp is removed and out is untouched.
+func machoReplaceOutput(tmp, out string) error {
+	dir := filepath.Dir(out)
+	err := machoSyncFile(tmp)
+	if err == nil {
+		err = machoSyncDir(dir)
+	}
+	if err == nil {
+		err = machoRename(tmp, out)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return machoSyncDir(dir)
+}
+
+// machoCheckTarget returns an error if ctxt does not link for a Mach-O
+// target. Only the darwin external link runs the Mach-O rewrites; the
+// check makes a driver that wires one into another target's link fail
+// plainly, rather than misread an ELF, PE or wasm output as Mach-O. A
+// nil ctxt, as in tests, is taken to be a darwin link.
+func machoCheckTarget(ctxt *Link) error {
+	if ctxt != nil && ctxt.HeadType != objabi.Hdarwin {
+		return fmt.Errorf("Mach-O UUID rewrite not applicable for target %v", ctxt.HeadType)
+	}
+	return nil
+}
+
+// machoNoteUuidWritten calls written, the optional callback of
+// machoRewriteUuid and machoRewriteFatUuids, if not nil, for the slice
+// described by exem, which has been given uuid.
+func machoNoteUuidWritten(written func(arch string, uuid []byte), exem *macho.File, uuid []byte) {
+	if written != nil {
+		written(machoArchName(exem.Cpu), append([]byte(nil), uuid[:16]...))
+	}
+}
+
+// machoSameFile reports whether f and the file at path are the same
+// file, as when they are reached through different symlinks.
+func machoSameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pfi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, pfi)
+}
+
 // machoRewriteUuid copies over the contents of the Macho executable
 // exef into the output file outexe, and in the process updates the
 // LC_UUID command to a new value recomputed from the Go build id.
-func machoRewriteUuid(ctxt *Link, exef *os.File, exem *macho.File, outexe string) error {
-	outf, err := os.OpenFile(outexe, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+// If outexe is exef under another name, truncating it would destroy
+// the contents being copied, so the LC_UUID is updated in place
+// instead.
+//
+// written, if not nil, is called with the name of the architecture and
+// the LC_UUID once it is written, the code signature repaired with it,
+// so that a driver can hand the UUID to a signing or notarization
+// service without reading the output again. uuid is the caller's to
+// keep.
+func machoRewriteUuid(ctxt *Link, exef *os.File, exem *macho.File, outexe string, written func(arch string, uuid []byte)) error {
+	if err := machoCheckTarget(ctxt); err != nil {
+		return err
+	}
+	if err := machoCheckHeader(exem); err != nil {
+		return err
+	}
+	var outf *os.File
+	var err error
+	if machoSameFile(exef, outexe) {
+		outf, err = machoOpenFile(outexe, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		defer outf.Close()
+	} else {
+		outf, err = machoCreateOutput(outexe, 0755)
+		if err != nil {
+			return err
+		}
+		defer outf.Close()
+
+		// Copy over the file.
+		if _, err := io.Copy(outf, exef); err != nil {
+			return err
+		}
+	}
+
+	uuid, err := machoOutputUuid(exef, exem)
 	if err != nil {
 		return err
 	}
-	defer outf.Close()
+	if !machoRecordingPatches() {
+		rw := &machoRewriter{f: outf, exem: exem}
+		if err := rw.UpdateUuid(uuid); err != nil {
+			return err
+		}
+		machoNoteUuidWritten(written, exem, uuid)
+		return nil
+	}
+	rec := &machoPatchRecorder{f: outf}
+	rw := &machoRewriter{f: rec, exem: exem}
+	if err := rw.UpdateUuid(uuid); err != nil {
+		return err
+	}
+	uuidPatches = rec.patches
+	machoNoteUuidWritten(written, exem, uuid)
+	return nil
+}
+
+// A machoRewriter edits a Mach-O file in place and keeps a parsed view
+// of it that reflects those edits, so that a pass run after another
+// (verification in particular) does not see stale data.
+type machoRewriter struct {
+	f    machoReadWriterAt
+	exem *macho.File
+}
+
+// newMachoRewriter returns a machoRewriter for the Mach-O file f.
+func newMachoRewriter(f machoReadWriterAt) (*machoRewriter, error) {
+	rw := &machoRewriter{f: f}
+	if _, err := rw.Reparse(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// File returns the parsed file as of the last write made through rw.
+func (rw *machoRewriter) File() *macho.File {
+	return rw.exem
+}
+
+// Reparse parses the Mach-O file again from its current contents and
+// returns the result, which File returns from then on.
+func (rw *machoRewriter) Reparse() (*macho.File, error) {
+	exem, err := machoParseFile(rw.f)
+	if err != nil {
+		return nil, err
+	}
+	rw.exem = exem
+	return exem, nil
+}
+
+// machoParseFile parses the thin Mach-O file r with debug/macho, which
+// is stricter than it needs to be for the post-link passes: it rejects
+// a file with a load command it cannot make sense of, even though the
+// passes only need to walk the commands. If that fails, it returns
+// what machoParseRaw finds instead.
+func machoParseFile(r io.ReaderAt) (*macho.File, error) {
+	exem, err := macho.NewFile(r)
+	if err == nil {
+		return exem, nil
+	}
+	if raw, rawErr := machoParseRaw(r); rawErr == nil {
+		return raw, nil
+	}
+	return nil, err
+}
+
+// machoParseRaw returns a macho.File with just the header, byte order
+// and load commands of the thin Mach-O file r, each of them a
+// macho.LoadBytes, without interpreting them. Code using the result
+// finds no segments, sections or symbols.
+func machoParseRaw(r io.ReaderAt) (*macho.File, error) {
+	var magic [4]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(magic[:])&^1 == macho.Magic32:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic[:])&^1 == macho.Magic32:
+		order = binary.BigEndian
+	default:
+		return nil, errBadMagic
+	}
+	exem := &macho.File{ByteOrder: order}
+	sr := io.NewSectionReader(r, 0, 1<<63-1)
+	if err := binary.Read(sr, order, &exem.FileHeader); err != nil {
+		return nil, err
+	}
+	if err := machoCheckHeader(exem); err != nil {
+		return nil, err
+	}
+	cmds := make([]byte, exem.Cmdsz)
+	if _, err := r.ReadAt(cmds, machoHeaderSize(exem)); err != nil {
+		return nil, fmt.Errorf("reading load commands: %w", err)
+	}
+	for i := uint32(0); i < exem.Ncmd; i++ {
+		if len(cmds) < 8 {
+			return nil, machoLoadCmdError(i, 0, errLoadCmdOverrun)
+		}
+		size := order.Uint32(cmds[4:])
+		if size < 8 || uint64(size) > uint64(len(cmds)) {
+			return nil, machoLoadCmdError(i, macho.LoadCmd(order.Uint32(cmds)), fmt.Errorf("%w %d", errLoadCmdSize, size))
+		}
+		exem.Loads = append(exem.Loads, macho.LoadBytes(cmds[:size:size]))
+		cmds = cmds[size:]
+	}
+	return exem, nil
+}
+
+// Truncate changes the size of the file behind rw. It fails if the
+// file cannot be truncated.
+func (rw *machoRewriter) Truncate(size int64) error {
+	t, ok := rw.f.(interface{ Truncate(int64) error })
+	if !ok {
+		return fmt.Errorf("cannot truncate %T", rw.f)
+	}
+	return t.Truncate(size)
+}
+
+// Apply makes the changes e to the file behind rw.
+func (rw *machoRewriter) Apply(e *machoEdits) error {
+	if e.Empty() {
+		return nil
+	}
+	for _, p := range e.Patches {
+		if _, err := rw.f.WriteAt(p.New, p.Offset); err != nil {
+			return err
+		}
+	}
+	if e.Truncate != 0 {
+		if err := rw.Truncate(e.Truncate); err != nil {
+			return err
+		}
+	}
+	if _, err := rw.Reparse(); err != nil {
+		return err
+	}
+	return rw.repairSignature(e.Patches)
+}
+
+// repairSignature updates the code signature of the file behind rw, if
+// it has an ad hoc one, to cover the changes patches made.
+func (rw *machoRewriter) repairSignature(patches []machoPatch) error {
+	cmds, err := machoReadLoadCmds(rw.f, rw.exem)
+	if err != nil {
+		return err
+	}
+	cd, err := machoReadCodeDirectory(rw.f, rw.exem.ByteOrder, cmds)
+	if err != nil || cd == nil || !cd.AdHoc() {
+		// Leave other signatures to whoever signs the file; code
+		// signing with -linkmode=external regenerates them anyway.
+		return err
+	}
+	for _, p := range patches {
+		if _, err := machoRepairSignature(rw.f, cd, p.Offset, int64(len(p.New))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateUuid sets the payload of the LC_UUID command to uuid.
+func (rw *machoRewriter) UpdateUuid(uuid []byte) error {
+	e, err := machoPlanUuid(rw, uuid)
+	if err != nil {
+		return err
+	}
+	return rw.Apply(e)
+}
+
+// Uuid returns the payload of the LC_UUID command, and whether there
+// is one.
+func (rw *machoRewriter) Uuid() ([16]byte, bool) {
+	return machoFileUuid(rw.exem)
+}
+
+// machoFileUuid returns the payload of the LC_UUID command of exem, and
+// whether there is one.
+func machoFileUuid(exem *macho.File) ([16]byte, bool) {
+	var u [16]byte
+	for _, l := range exem.Loads {
+		raw := l.Raw()
+		if macho.LoadCmd(exem.ByteOrder.Uint32(raw)) == LC_UUID && len(raw) >= 24 {
+			copy(u[:], raw[8:24])
+			return u, true
+		}
+	}
+	return u, false
+}
+
+// errNoUuid is the error machoUuidLocation returns for a file with no
+// LC_UUID command.
+var errNoUuid = errors.New("no LC_UUID load command")
+
+// machoUuidLocation returns the file offset and length of the payload
+// of the LC_UUID command of f, whose header is described by exem, and
+// the byte order of the file, for tools that patch the UUID themselves.
+// The length is always 16: the bytes of the command past them, if its
+// cmdsize is larger, are padding. The offset is relative to the start
+// of f, which for a slice of a fat file is the start of the slice. It
+// reports errNoUuid if there is no LC_UUID command.
+func machoUuidLocation(exem *macho.File, f io.ReaderAt) (offset int64, length int, order binary.ByteOrder, err error) {
+	// The load commands occupy the Cmdsz bytes that immediately follow
+	// the header, and LC_UUID is always one of them, no matter where
+	// the segments they describe (__LINKEDIT in particular) have been
+	// placed in the file, so only that region is read.
+	cmds, err := machoReadLoadCmds(f, exem)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for _, c := range cmds {
+		if c.Cmd != LC_UUID {
+			continue
+		}
+		if len(c.Data) < 24 {
+			return 0, 0, nil, fmt.Errorf("LC_UUID at %#x %w (%d bytes)", c.Offset, errLoadCmdShort, len(c.Data))
+		}
+		return c.Offset + 8, 16, exem.ByteOrder, nil
+	}
+	return 0, 0, nil, errNoUuid
+}
+
+// machoReadGoBuildID returns the Go build ID embedded in the Mach-O
+// file exe, thin or fat. The linker writes it at the start of __text
+// (see addbuildinfo and the go:buildid symbol), not in __go_buildinfo,
+// which holds the module information of runtime/debug.BuildInfo.
+func machoReadGoBuildID(exe string) (string, error) {
+	f, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var files []*macho.File
+	if ff, err := macho.NewFatFile(f); err == nil {
+		for _, a := range ff.Arches {
+			files = append(files, a.File)
+		}
+	} else if err != macho.ErrNotFat {
+		return "", fmt.Errorf("%s: %v", exe, err)
+	} else {
+		exem, err := macho.NewFile(f)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", exe, err)
+		}
+		files = append(files, exem)
+	}
+	for _, exem := range files {
+		sect := exem.Section("__text")
+		if sect == nil {
+			continue
+		}
+		// Read as much of __text as the go command does, in case
+		// something precedes the build ID.
+		n := sect.Size
+		if n > 32<<10 {
+			n = 32 << 10
+		}
+		data := make([]byte, n)
+		if _, err := sect.ReadAt(data, 0); err != nil {
+			return "", fmt.Errorf("%s: %v", exe, err)
+		}
+		const prefix, end = "\xff Go build ID: \"", "\"\n \xff"
+		i := bytes.Index(data, []byte(prefix))
+		if i < 0 {
+			continue
+		}
+		i += len(prefix) - 1
+		j := bytes.Index(data[i+1:], []byte(end))
+		if j < 0 {
+			return "", fmt.Errorf("%s: malformed Go build ID", exe)
+		}
+		id, err := strconv.Unquote(string(data[i : i+1+j+1]))
+		if err != nil {
+			return "", fmt.Errorf("%s: malformed Go build ID", exe)
+		}
+		return id, nil
+	}
+	return "", fmt.Errorf("%s: no Go build ID", exe)
+}
 
-	// Copy over the file.
-	if _, err := io.Copy(outf, exef); err != nil {
+// machoVerifyUuid checks that the LC_UUID of the Mach-O file exe is the
+// one derived from the Go build ID embedded in exe, which is what the
+// linker records unless -macho-uuid, -uuidfrom or -uuidfromcode is used.
+// A mismatch means the two got out of sync: the binary, or its build
+// ID, was modified after it was linked. -uuidverify runs this check on
+// the output.
+func machoVerifyUuid(exe string) error {
+	id, err := machoReadGoBuildID(exe)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	exem, err := macho.NewFile(f)
+	if err != nil {
 		return err
 	}
+	got, ok := machoFileUuid(exem)
+	if !ok {
+		return fmt.Errorf("%s: no LC_UUID load command", exe)
+	}
+	if want := machoBuildIdUuid(id, exem.Cpu); !bytes.Equal(got[:], want) {
+		return fmt.Errorf("%s: LC_UUID %s does not match build ID %q (want %s)", exe, machoUuidString(got), id, machoUuidString([16]byte(want)))
+	}
+	return nil
+}
+
+// forcedUuid is the UUID set by the -macho-uuid policy, read by
+// -uuidfrom or recorded in the -uuidstore store, or nil.
+var forcedUuid []byte
+
+// machoUuidPolicy is the -macho-uuid policy of the link, as
+// checkMachoUuidFlags validated it, or "". -forceuuid sets the policy
+// of 0x followed by the hex digits of its UUID.
+var machoUuidPolicy string
+
+// checkMachoUuidFlags checks -macho-uuid, -forceuuid and -uuidfrom, and
+// sets machoUuidPolicy, and forcedUuid for a policy or -uuidfrom that
+// gives the UUID. The gobuildid policy leaves the UUID to addbuildinfo,
+// which derives it as for -B gobuildid.
+func checkMachoUuidFlags(ctxt *Link) {
+	name, policy := "-macho-uuid", *flagMachoUuid
+	if *flagForceUuid != "" {
+		if policy != "" {
+			Exitf("-forceuuid is -macho-uuid=0x... under its old name; give only one of them")
+		}
+		u, err := machoParseUuid(*flagForceUuid)
+		if err != nil {
+			Exitf("-forceuuid: %v", err)
+		}
+		name, policy = "-forceuuid", "0x"+hex.EncodeToString(u[:])
+	}
+	if policy != "" {
+		if !ctxt.IsDarwin() {
+			Exitf("%s is only supported when linking for darwin or ios", name)
+		}
+		if *flagUuidFrom != "" || *flagHostBuildid != "" {
+			Exitf("%s cannot be combined with -uuidfrom or -B", name)
+		}
+		if policy == "gobuildid" {
+			if *flagBuildid == "" {
+				Exitf("-macho-uuid=gobuildid requires a Go build ID supplied via -buildid")
+			}
+		} else {
+			u, err := machoParseUuidPolicy(policy)
+			if err != nil {
+				Exitf("%s: %v", name, err)
+			}
+			forcedUuid = u
+		}
+		machoUuidPolicy = policy
+	}
+
+	if *flagUuidFrom != "" {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidfrom is only supported when linking for darwin or ios")
+		}
+		u, err := machoReadUuidFile(*flagUuidFrom)
+		if err != nil {
+			Exitf("-uuidfrom: %v", err)
+		}
+		typ, err := machoFileType(*flagUuidFrom)
+		if err == nil {
+			err = machoCheckUuidFromType(typ, machoBuildModeType(ctxt.BuildMode), *flagUuidFromConvert)
+		}
+		if err != nil {
+			Exitf("-uuidfrom: %s: %v", *flagUuidFrom, err)
+		}
+		forcedUuid = u
+	}
+	if *flagUuidFromConvert && *flagUuidFrom == "" {
+		Exitf("-uuidfromconvert requires -uuidfrom")
+	}
+}
 
-	// Locate the portion of the binary containing the load commands.
-	cmdOffset := unsafe.Sizeof(exem.FileHeader)
-	if is64bit := exem.Magic == macho.Magic64; is64bit {
+// machoReadUuidFile returns the LC_UUID payload of the Mach-O file at
+// path, for -uuidfrom. The architecture slices of a fat file must all
+// have the same UUID, since there is only one to carry over.
+func machoReadUuidFile(path string) ([]byte, error) {
+	uuids, err := machoAllUuids(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(uuids) == 0 {
+		return nil, fmt.Errorf("%s: no LC_UUID load command", path)
+	}
+	for _, u := range uuids[1:] {
+		if u.Uuid != uuids[0].Uuid {
+			return nil, fmt.Errorf("%s: architectures have different UUIDs", path)
+		}
+	}
+	return uuids[0].Uuid[:], nil
+}
+
+// machoFileType returns the file type of the Mach-O file at path, thin
+// or fat, for -uuidfrom. The slices of a fat file share one type.
+func machoFileType(path string) (macho.Type, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	slices, err := machoFatSlices(f)
+	if err != nil {
+		return 0, err
+	}
+	exem, err := machoParseRaw(slices[0])
+	if err != nil {
+		return 0, err
+	}
+	return exem.Type, nil
+}
+
+// machoBuildModeType returns the Mach-O file type of the output of a
+// link in build mode mode, or 0 if it has no LC_UUID to set.
+func machoBuildModeType(mode BuildMode) macho.Type {
+	switch mode {
+	case BuildModeExe, BuildModePIE:
+		return macho.TypeExec
+	case BuildModeCShared, BuildModeShared, BuildModePlugin:
+		return macho.TypeDylib
+	}
+	return 0
+}
+
+// machoTypeDesc describes the Mach-O file type t for error messages.
+func machoTypeDesc(t macho.Type) string {
+	switch t {
+	case macho.TypeExec:
+		return "an executable"
+	case macho.TypeDylib:
+		return "a dylib"
+	}
+	return fmt.Sprintf("a Mach-O file of type %v", t)
+}
+
+// machoCheckUuidFromType returns an error unless -uuidfrom can carry
+// the UUID of a Mach-O file of type from to an output of type to. The
+// UUID of an executable only goes to a dylib, or that of a dylib to an
+// executable, with -uuidfromconvert (convert), for a module relinked
+// in the other form that should keep its identity; anything else must
+// keep its type.
+func machoCheckUuidFromType(from, to macho.Type, convert bool) error {
+	if to == 0 || from == to {
+		return nil
+	}
+	linkable := func(t macho.Type) bool { return t == macho.TypeExec || t == macho.TypeDylib }
+	if !linkable(from) || !linkable(to) {
+		return fmt.Errorf("cannot carry the UUID of %s to %s", machoTypeDesc(from), machoTypeDesc(to))
+	}
+	if !convert {
+		return fmt.Errorf("%s, but the output is %s (use -uuidfromconvert to carry the UUID across)", machoTypeDesc(from), machoTypeDesc(to))
+	}
+	return nil
+}
+
+// machoOutputUuid returns the UUID to record in the Mach-O file f,
+// described by exem: the value set by -macho-uuid or read by -uuidfrom
+// if any, one derived from the contents of exem with -uuidfromcode or
+// from f with -uuidld64, and otherwise one derived from the Go build
+// ID.
+func machoOutputUuid(f io.ReaderAt, exem *macho.File) ([]byte, error) {
+	if forcedUuid != nil {
+		return forcedUuid, nil
+	}
+	if uuidCodeSections != nil {
+		return uuidFromCode(exem, uuidCodeSections)
+	}
+	if *flagUuidLd64 {
+		return uuidLikeLd64(f, exem)
+	}
+	return machoBuildIdUuid(*flagBuildid, exem.Cpu), nil
+}
+
+// machoHeaderSize returns the size of the header of the Mach-O file
+// described by exem, which is the offset of its first load command.
+func machoHeaderSize(exem *macho.File) int64 {
+	size := int64(unsafe.Sizeof(exem.FileHeader))
+	if exem.Magic == macho.Magic64 {
 		// mach_header_64 has one extra uint32.
-		cmdOffset += unsafe.Sizeof(exem.Magic)
+		size += int64(unsafe.Sizeof(exem.Magic))
+	}
+	return size
+}
+
+// machoUpdateUuid overwrites the payload of the LC_UUID load command
+// in f with uuid. exem describes the header and load commands of f.
+// If there is no LC_UUID command, f is left unchanged.
+//
+// Nothing here depends on the host OS, so the rewrite can be exercised
+// on synthetic images on any platform.
+func machoUpdateUuid(f machoReadWriterAt, exem *macho.File, uuid []byte) error {
+	off, n, _, err := machoUuidLocation(exem, f)
+	if err == errNoUuid {
+		return nil
 	}
-	if _, err := outf.Seek(int64(cmdOffset), 0); err != nil {
+	if err != nil {
 		return err
 	}
+	_, err = f.WriteAt(uuid[:n], off)
+	return err
+}
 
-	// Read the load commands, looking for the LC_UUID cmd. If/when we
-	// locate it, overwrite it with a new value produced by
-	// uuidFromGoBuildId.
-	reader := loadCmdReader{next: int64(cmdOffset),
-		f: outf, order: exem.ByteOrder}
-	for i := uint32(0); i < exem.Ncmd; i++ {
-		cmd, err := reader.Next()
+// machoArchUuid is the UUID recorded in one architecture slice of a
+// Mach-O file, with what identifies and locates the slice, so that
+// the code reporting or salting UUIDs per architecture need not parse
+// the slice again.
+type machoArchUuid struct {
+	Cpu       macho.Cpu
+	SubCpu    uint32
+	ByteOrder binary.ByteOrder
+	Offset    int64 // file offset of the slice, 0 for a thin file, or of the binary in a core
+	Uuid      [16]byte
+}
+
+// machoSameArchUuids reports whether a and b have the same UUIDs for
+// the same architectures, wherever in the file their slices are.
+func machoSameArchUuids(a, b []machoArchUuid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Cpu != b[i].Cpu || a[i].SubCpu != b[i].SubCpu || a[i].Uuid != b[i].Uuid {
+			return false
+		}
+	}
+	return true
+}
+
+// machoAllUuids returns the UUID of each architecture slice of the
+// Mach-O file at path, thin or fat, in file order: what a build system
+// needs to upload per-architecture symbols for a universal binary.
+func machoAllUuids(path string) ([]machoArchUuid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return machoReadUuids(f)
+}
+
+// machoReadUuids returns the LC_UUID payload of each architecture in
+// the Mach-O file r, which may be thin or fat. Slices without an
+// LC_UUID command are omitted. For a core dump (MH_CORE), which has no
+// LC_UUID of its own, it returns those of the binaries whose headers
+// are in its segments instead (see machoCoreUuids).
+//
+// Only the fat header and the header and load commands of each slice
+// are read, not the symbol tables or segment contents macho.NewFile
+// would load, so r can be a remote artifact read with HTTP range
+// requests: checking its UUIDs costs a few kilobytes, not a download.
+func machoReadUuids(r io.ReaderAt) ([]machoArchUuid, error) {
+	slices, err := machoFatSlices(r)
+	if err != nil {
+		return nil, err
+	}
+	var uuids []machoArchUuid
+	for _, s := range slices {
+		f, err := machoParseRaw(s)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if cmd.Cmd == LC_UUID {
-			var u uuidCmd
-			if err := reader.ReadAt(0, &u); err != nil {
-				return err
+		if u, ok := machoFileUuid(f); ok {
+			uuids = append(uuids, machoArchUuid{Cpu: f.Cpu, SubCpu: f.SubCpu, ByteOrder: f.ByteOrder, Offset: s.Offset, Uuid: u})
+		} else if f.Type == MH_CORE {
+			images, err := machoCoreUuids(s, f)
+			if err != nil {
+				return nil, err
 			}
-			copy(u.Uuid[:], uuidFromGoBuildId(*flagBuildid))
-			if err := reader.WriteAt(0, &u); err != nil {
-				return err
+			for _, u := range images {
+				u.Offset += s.Offset
+				uuids = append(uuids, u)
 			}
-			break
 		}
 	}
+	return uuids, nil
+}
+
+// machoCoreUuids returns the LC_UUID payloads of the binaries loaded in
+// the process that the core dump r, whose header is described by core,
+// was taken of. A core has a segment for each region of the process's
+// memory, and a binary's __TEXT starts with its Mach-O header and load
+// commands, so each segment whose data starts with a Mach-O header, other
+// than that of a core, is taken to be a binary; the Offset of each
+// result is the file offset of that header. They come in the order of
+// the segments, which is the order of the regions in memory, not that of
+// loading: finding the crashed executable among them needs the
+// process's dyld image list.
+func machoCoreUuids(r io.ReaderAt, core *macho.File) ([]machoArchUuid, error) {
+	cmds, err := machoReadLoadCmds(r, core)
+	if err != nil {
+		return nil, err
+	}
+	order := core.ByteOrder
+	var uuids []machoArchUuid
+	for _, c := range cmds {
+		// struct segment_command { cmd, cmdsize, segname[16], vmaddr,
+		// vmsize, fileoff, filesize, ... }, with 64-bit addresses and
+		// sizes in segment_command_64.
+		var off, size uint64
+		switch {
+		case c.Cmd == LC_SEGMENT && len(c.Data) >= 40:
+			off, size = uint64(order.Uint32(c.Data[32:])), uint64(order.Uint32(c.Data[36:]))
+		case c.Cmd == LC_SEGMENT_64 && len(c.Data) >= 56:
+			off, size = order.Uint64(c.Data[40:]), order.Uint64(c.Data[48:])
+		default:
+			continue
+		}
+		if size < 4 || int64(off) < 0 || int64(size) < 0 {
+			continue
+		}
+		f, err := machoParseRaw(io.NewSectionReader(r, int64(off), int64(size)))
+		if errors.Is(err, errBadMagic) {
+			continue // memory that is not a binary
+		}
+		if err != nil {
+			return nil, fmt.Errorf("binary in core segment at %#x: %w", off, err)
+		}
+		if f.Type == MH_CORE {
+			continue
+		}
+		if u, ok := machoFileUuid(f); ok {
+			uuids = append(uuids, machoArchUuid{Cpu: f.Cpu, SubCpu: f.SubCpu, ByteOrder: f.ByteOrder, Offset: int64(off), Uuid: u})
+		}
+	}
+	return uuids, nil
+}
+
+// A machoFatSlice is an architecture slice of a Mach-O file, read in
+// offsets relative to its start.
+type machoFatSlice struct {
+	io.ReaderAt
+	Offset int64 // file offset of the slice, 0 for a thin file
+}
+
+// machoFatSlices returns the architecture slices of the fat Mach-O
+// file r, reading only its fat header, or just r if it is not fat.
+func machoFatSlices(r io.ReaderAt) ([]machoFatSlice, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(hdr[:]) != macho.MagicFat {
+		return []machoFatSlice{{ReaderAt: r}}, nil
+	}
+	n := binary.BigEndian.Uint32(hdr[4:])
+	if n == 0 {
+		return nil, fmt.Errorf("fat file has no architectures")
+	}
+	// Buffer the arch headers so that they take one read, not one
+	// per architecture.
+	br := bufio.NewReader(io.NewSectionReader(r, int64(len(hdr)), int64(n)*int64(unsafe.Sizeof(macho.FatArchHeader{}))))
+	var slices []machoFatSlice
+	for i := uint32(0); i < n; i++ {
+		var a macho.FatArchHeader
+		if err := binary.Read(br, binary.BigEndian, &a); err != nil {
+			return nil, fmt.Errorf("fat arch header %d: %v", i, err)
+		}
+		slices = append(slices, machoFatSlice{io.NewSectionReader(r, int64(a.Offset), int64(a.Size)), int64(a.Offset)})
+	}
+	return slices, nil
+}
 
-	// We're done
+// machoRewriteFatUuids sets the LC_UUID payload of each architecture
+// slice of the fat Mach-O file f to the UUID that uuidFor returns for
+// that slice. Slices can differ in byte order, word size and load
+// commands (arm64 slices use chained fixups, amd64 ones classic dyld
+// info, for example), so each one is parsed and rewritten on its own
+// rather than assuming the layout of the first. In particular the
+// load commands of each slice are found after a header sized by that
+// slice's own magic, one uint32 longer for Magic64 than for Magic32,
+// never by the magic of the fat header or of another slice. written, if
+// not nil, is called for each slice as by machoRewriteUuid.
+func machoRewriteFatUuids(f machoReadWriterAt, uuidFor func(f io.ReaderAt, exem *macho.File) ([]byte, error), written func(arch string, uuid []byte)) error {
+	ff, err := macho.NewFatFile(f)
+	if err != nil {
+		return err
+	}
+	for _, a := range ff.Arches {
+		slice := &machoSlice{f: f, off: int64(a.Offset), size: int64(a.Size)}
+		rw, err := newMachoRewriter(slice)
+		if err != nil {
+			return fmt.Errorf("%s slice: %v", machoArchName(a.Cpu), err)
+		}
+		uuid, err := uuidFor(slice, rw.File())
+		if err == nil {
+			err = rw.UpdateUuid(uuid)
+		}
+		if err != nil {
+			return fmt.Errorf("%s slice: %v", machoArchName(a.Cpu), err)
+		}
+		machoNoteUuidWritten(written, rw.File(), uuid)
+	}
 	return nil
 }
+
+// machoRewriteFileUuids is machoRewriteFatUuids for a Mach-O file f
+// that may be thin or fat.
+func machoRewriteFileUuids(f machoReadWriterAt, uuidFor func(f io.ReaderAt, exem *macho.File) ([]byte, error), written func(arch string, uuid []byte)) error {
+	if _, err := macho.NewFatFile(f); err != macho.ErrNotFat {
+		if err != nil {
+			return err
+		}
+		return machoRewriteFatUuids(f, uuidFor, written)
+	}
+	rw, err := newMachoRewriter(f)
+	if err != nil {
+		return err
+	}
+	uuid, err := uuidFor(f, rw.File())
+	if err != nil {
+		return err
+	}
+	if err := rw.UpdateUuid(uuid); err != nil {
+		return err
+	}
+	machoNoteUuidWritten(written, rw.File(), uuid)
+	return nil
+}
+
+// machoKeepUuids runs step, which rewrites the Mach-O file at path as
+// strip does, and then gives each architecture of the file back the
+// LC_UUID it had before, so that a stripped release binary keeps the
+// UUID symbol servers know it by. The file is only written if step
+// changed a UUID. It is an error for step to remove an LC_UUID, or an
+// architecture, since there is then nothing to restore it to.
+func machoKeepUuids(path string, step func() error) error {
+	before, err := machoAllUuids(path)
+	if err != nil {
+		return err
+	}
+	if err := step(); err != nil {
+		return err
+	}
+	after, err := machoAllUuids(path)
+	if err != nil {
+		return err
+	}
+	if machoSameArchUuids(before, after) {
+		return nil
+	}
+	if len(after) != len(before) {
+		return fmt.Errorf("%s: %d architectures had an LC_UUID, now %d do", path, len(before), len(after))
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = machoRewriteFileUuids(f, func(_ io.ReaderAt, exem *macho.File) ([]byte, error) {
+		for _, u := range before {
+			if u.Cpu == exem.Cpu && u.SubCpu == exem.SubCpu {
+				return u.Uuid[:], nil
+			}
+		}
+		return nil, fmt.Errorf("no LC_UUID recorded for %s", machoArchName(exem.Cpu))
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// A machoSlice is the architecture slice of a fat Mach-O file f that
+// starts at off and holds size bytes, read and written in offsets
+// relative to its start.
+type machoSlice struct {
+	f         machoReadWriterAt
+	off, size int64
+}
+
+func (s *machoSlice) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= s.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > s.size-off {
+		n = int(s.size - off)
+	}
+	n, err := s.f.ReadAt(p[:n], s.off+off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *machoSlice) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > s.size {
+		return 0, fmt.Errorf("write of %d bytes at %#x outside the %#x-byte slice", len(p), off, s.size)
+	}
+	return s.f.WriteAt(p, s.off+off)
+}
+
+// machoUuidString formats u in the canonical 8-4-4-4-12 form used by
+// Apple's tools.
+func machoUuidString(u [16]byte) string {
+	return fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// machoParseUuid parses a UUID in the canonical form
+// XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX, where each X is a hex digit.
+func machoParseUuid(s string) ([16]byte, error) {
+	var u [16]byte
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("malformed UUID %q: want XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX", s)
+	}
+	digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:36]
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return u, fmt.Errorf("malformed UUID %q: %v", s, err)
+	}
+	return u, nil
+}
+
+// machoParseUuidPolicy returns the LC_UUID payload that the -macho-uuid
+// policy s sets: for "random", a fresh RFC 4122 version 4 UUID; for
+// "none", all zeros; and for 0x followed by 32 hex digits, those bytes.
+// The "gobuildid" policy, which derives the UUID from the build ID as
+// the link does by default, is not handled here.
+func machoParseUuidPolicy(s string) ([]byte, error) {
+	switch s {
+	case "random":
+		u := make([]byte, 16)
+		if _, err := cryptorand.Read(u); err != nil {
+			return nil, err
+		}
+		u[6] = u[6]&0x0f | 0x40 // version 4
+		u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+		return u, nil
+	case "none":
+		return make([]byte, 16), nil
+	}
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		return nil, fmt.Errorf("unknown policy %q, want gobuildid, random, none or 0x followed by 32 hex digits", s)
+	}
+	if len(digits) != 32 {
+		return nil, fmt.Errorf("%s has %d hex digits, want 32", s, len(digits))
+	}
+	u, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s, err)
+	}
+	return u, nil
+}
+
+// machoArchName returns the architecture name Apple's tools use for cpu.
+func machoArchName(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.Cpu386:
+		return "i386"
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuPpc:
+		return "ppc"
+	case macho.CpuPpc64:
+		return "ppc64"
+	}
+	return fmt.Sprintf("cpu%d", uint32(cpu))
+}
+
+// machoWriteUuidFile writes the UUIDs of the Mach-O file exe to path,
+// in the format printed by "dwarfdump --uuid": one line per
+// architecture slice, in file order, such as
+//
+//	UUID: 0C8EB5F4-3D53-3A3E-9A5E-1D4C8B4B18A6 (arm64) hello
+//
+// This is the format -uuidout produces, and changes to it must keep
+// compatibility with existing consumers.
+func machoWriteUuidFile(path, exe string) error {
+	uuids, err := machoAllUuids(exe)
+	if err != nil {
+		return err
+	}
+	if len(uuids) == 0 {
+		return fmt.Errorf("%s: no LC_UUID load command", exe)
+	}
+	var buf bytes.Buffer
+	for _, u := range uuids {
+		fmt.Fprintf(&buf, "UUID: %s (%s) %s\n", machoUuidString(u.Uuid), machoArchName(u.Cpu), exe)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0666)
+}
+
+// machoUuidJSON is the output of -uuidjson.
+type machoUuidJSON struct {
+	Path   string              `json:"path"`
+	Arches []machoArchUuidJSON `json:"arches"`
+}
+
+type machoArchUuidJSON struct {
+	Cpu  string `json:"cpu"`
+	Uuid string `json:"uuid"`
+}
+
+// machoWriteUuidJSON writes the UUIDs of the Mach-O file exe to w as a
+// single line of JSON, such as
+//
+//	{"path":"hello","arches":[{"cpu":"arm64","uuid":"0C8EB5F4-3D53-3A3E-9A5E-1D4C8B4B18A6"}]}
+//
+// with one entry per architecture slice, in file order.
+func machoWriteUuidJSON(w io.Writer, exe string) error {
+	uuids, err := machoAllUuids(exe)
+	if err != nil {
+		return err
+	}
+	if len(uuids) == 0 {
+		return fmt.Errorf("%s: no LC_UUID load command", exe)
+	}
+	out := machoUuidJSON{Path: exe}
+	for _, u := range uuids {
+		out.Arches = append(out.Arches, machoArchUuidJSON{Cpu: machoArchName(u.Cpu), Uuid: machoUuidString(u.Uuid)})
+	}
+	return json.NewEncoder(w).Encode(&out)
+}
@@ -0,0 +1,2649 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bufio"
+	"bytes"
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	"crypto/sha256"
+	"debug/macho"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/testenv"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testMachO describes a synthetic Mach-O image. It lets the load
+// command walk be tested on any host, without an external linker.
+type testMachO struct {
+	order    binary.ByteOrder
+	is32     bool
+	cpu      macho.Cpu
+	subcpu   uint32
+	filetype macho.Type
+	flags    uint32
+	cmds     [][]byte // raw load commands, in order
+	size     int      // total size of the image
+}
+
+// testSect describes a section in a synthetic segment command.
+type testSect struct {
+	name, seg    string
+	addr, size   uint64
+	offset       uint32
+	align, flags uint32
+}
+
+// newTestMachO returns a small 64-bit little-endian executable with
+// __PAGEZERO, __TEXT (holding __text at 0x400) and __LINKEDIT segments
+// and an LC_UUID command carrying uuid.
+func newTestMachO(uuid [16]byte) *testMachO {
+	m := &testMachO{
+		order:    binary.LittleEndian,
+		cpu:      macho.CpuAmd64,
+		subcpu:   3, // CPU_SUBTYPE_X86_64_ALL
+		filetype: macho.TypeExec,
+		flags:    MH_NOUNDEFS | MH_DYLDLINK | MH_PIE,
+		size:     0x600,
+	}
+	m.cmds = [][]byte{
+		m.segment("__PAGEZERO", 0, 0x100000000, 0, 0),
+		m.segment("__TEXT", 0x100000000, 0x1000, 0, 0x500,
+			testSect{name: "__text", seg: "__TEXT", addr: 0x100000400, size: 0x100, offset: 0x400, align: 4}),
+		m.segment("__LINKEDIT", 0x100001000, 0x1000, 0x500, 0x100),
+		m.uuid(uuid),
+	}
+	return m
+}
+
+func (m *testMachO) padded(b []byte) []byte {
+	align := 8
+	if m.is32 {
+		align = 4
+	}
+	for len(b)%align != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// raw returns a load command of type cmd with the given payload.
+func (m *testMachO) raw(cmd macho.LoadCmd, payload []byte) []byte {
+	b := m.padded(append(make([]byte, 8), payload...))
+	m.order.PutUint32(b[0:], uint32(cmd))
+	m.order.PutUint32(b[4:], uint32(len(b)))
+	return b
+}
+
+func (m *testMachO) uuid(u [16]byte) []byte {
+	return m.raw(LC_UUID, u[:])
+}
+
+func (m *testMachO) segment(name string, addr, memsz, off, filesz uint64, sects ...testSect) []byte {
+	var buf bytes.Buffer
+	var sname [16]byte
+	copy(sname[:], name)
+	if m.is32 {
+		seg := macho.Segment32{
+			Cmd:    macho.LoadCmdSegment,
+			Len:    uint32(binary.Size(macho.Segment32{}) + len(sects)*binary.Size(macho.Section32{})),
+			Name:   sname,
+			Addr:   uint32(addr),
+			Memsz:  uint32(memsz),
+			Offset: uint32(off),
+			Filesz: uint32(filesz),
+			Nsect:  uint32(len(sects)),
+		}
+		binary.Write(&buf, m.order, &seg)
+		for _, s := range sects {
+			var sect macho.Section32
+			copy(sect.Name[:], s.name)
+			copy(sect.Seg[:], s.seg)
+			sect.Addr, sect.Size = uint32(s.addr), uint32(s.size)
+			sect.Offset, sect.Align, sect.Flags = s.offset, s.align, s.flags
+			binary.Write(&buf, m.order, &sect)
+		}
+		return buf.Bytes()
+	}
+	seg := macho.Segment64{
+		Cmd:    macho.LoadCmdSegment64,
+		Len:    uint32(binary.Size(macho.Segment64{}) + len(sects)*binary.Size(macho.Section64{})),
+		Name:   sname,
+		Addr:   addr,
+		Memsz:  memsz,
+		Offset: off,
+		Filesz: filesz,
+		Nsect:  uint32(len(sects)),
+	}
+	binary.Write(&buf, m.order, &seg)
+	for _, s := range sects {
+		var sect macho.Section64
+		copy(sect.Name[:], s.name)
+		copy(sect.Seg[:], s.seg)
+		sect.Addr, sect.Size = s.addr, s.size
+		sect.Offset, sect.Align, sect.Flags = s.offset, s.align, s.flags
+		binary.Write(&buf, m.order, &sect)
+	}
+	return buf.Bytes()
+}
+
+// bytes serializes the image. The space between the end of the load
+// commands and the end of the image is filled with a recognizable
+// pattern, so that stray writes show up in comparisons.
+func (m *testMachO) bytes() []byte {
+	var cmds []byte
+	for _, c := range m.cmds {
+		cmds = append(cmds, c...)
+	}
+	var buf bytes.Buffer
+	magic := uint32(macho.Magic64)
+	if m.is32 {
+		magic = macho.Magic32
+	}
+	hdr := macho.FileHeader{
+		Magic:  magic,
+		Cpu:    m.cpu,
+		Type:   m.filetype,
+		Ncmd:   uint32(len(m.cmds)),
+		Cmdsz:  uint32(len(cmds)),
+		Flags:  m.flags,
+		SubCpu: m.subcpu,
+	}
+	binary.Write(&buf, m.order, &hdr)
+	if !m.is32 {
+		binary.Write(&buf, m.order, uint32(0)) // reserved
+	}
+	buf.Write(cmds)
+	for i := buf.Len(); i < m.size; i++ {
+		buf.WriteByte(byte(i))
+	}
+	return buf.Bytes()
+}
+
+// testFatMachO wraps the thin images slices in a fat (universal)
+// header. Each slice is aligned to 1<<align bytes.
+func testFatMachO(align uint32, slices ...*testMachO) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, [2]uint32{macho.MagicFat, uint32(len(slices))})
+	off := uint32(8 + 20*len(slices))
+	var imgs [][]byte
+	for _, m := range slices {
+		img := m.bytes()
+		off = uint32(Rnd(int64(off), 1<<align))
+		binary.Write(&buf, binary.BigEndian, macho.FatArchHeader{
+			Cpu:    m.cpu,
+			SubCpu: m.subcpu,
+			Offset: off,
+			Size:   uint32(len(img)),
+			Align:  align,
+		})
+		imgs = append(imgs, img)
+		off += uint32(len(img))
+	}
+	for _, img := range imgs {
+		for int64(buf.Len()) != Rnd(int64(buf.Len()), 1<<align) {
+			buf.WriteByte(0)
+		}
+		buf.Write(img)
+	}
+	return buf.Bytes()
+}
+
+// testMachOBuf is an in-memory machoReadWriterAt.
+type testMachOBuf []byte
+
+func (b testMachOBuf) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > int64(len(b)) {
+		return 0, fmt.Errorf("read at %d out of range", off)
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b testMachOBuf) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(b)) {
+		return 0, fmt.Errorf("write at %d out of range", off)
+	}
+	return copy(b[off:], p), nil
+}
+
+func parseTestMachO(t *testing.T, img []byte) *macho.File {
+	t.Helper()
+	f, err := macho.NewFile(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("parsing synthetic Mach-O: %v", err)
+	}
+	return f
+}
+
+// testReadUuid returns the LC_UUID payload of exem.
+func testReadUuid(t *testing.T, exem *macho.File) []byte {
+	t.Helper()
+	for _, l := range exem.Loads {
+		raw := l.Raw()
+		if macho.LoadCmd(exem.ByteOrder.Uint32(raw)) == LC_UUID {
+			return raw[8:24]
+		}
+	}
+	t.Fatalf("no LC_UUID command")
+	return nil
+}
+
+var testUuid = [16]byte{0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+func TestUuidFromGoBuildId(t *testing.T) {
+	if u := uuidFromGoBuildId(""); !bytes.Equal(u, make([]byte, 16)) {
+		t.Errorf("empty build ID: got %x, want zero UUID", u)
+	}
+	u1 := uuidFromGoBuildId("abc/def")
+	u2 := uuidFromGoBuildId("abc/def")
+	if !bytes.Equal(u1, u2) {
+		t.Errorf("UUID not deterministic: %x != %x", u1, u2)
+	}
+	if u3 := uuidFromGoBuildId("abc/xyz"); bytes.Equal(u1, u3) {
+		t.Errorf("different build IDs produced the same UUID %x", u1)
+	}
+	if len(u1) != 16 {
+		t.Fatalf("got %d UUID bytes, want 16", len(u1))
+	}
+	if v := u1[6] >> 4; v != 3 {
+		t.Errorf("UUID version = %d, want 3", v)
+	}
+	if v := u1[8] >> 6; v != 3 {
+		t.Errorf("UUID variant bits = %b, want 11", v)
+	}
+}
+
+func TestBuildIDsEquivalentForUUID(t *testing.T) {
+	defer func(old string) { uuidPgoHash = old }(uuidPgoHash)
+	uuidPgoHash = ""
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"abc/def", "abc/def", true},
+		{"", "", true},
+		{"aaaa/bbbb/cccc/dddd", "aaaa/bbbb/cccc/dddd", true},
+		// The same content ID after a different action ID, and the
+		// other way around: both parts go into the UUID.
+		{"act1/content", "act2/content", false},
+		{"action/content1", "action/content2", false},
+		{"abc/def", "abc/def/", false},
+		{"abc", "", false},
+	} {
+		if got := buildIDsEquivalentForUUID(tc.a, tc.b); got != tc.want {
+			t.Errorf("buildIDsEquivalentForUUID(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		if got := bytes.Equal(uuidFromGoBuildId(tc.a), uuidFromGoBuildId(tc.b)); got != tc.want {
+			t.Errorf("%q and %q give equal UUIDs: %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+
+	// A PGO salt applies to both sides alike.
+	uuidPgoHash = "0123"
+	if !buildIDsEquivalentForUUID("abc/def", "abc/def") || buildIDsEquivalentForUUID("abc/def", "abc/xyz") {
+		t.Error("-uuidpgo changed which build IDs are equivalent")
+	}
+	uuidPgoHash = ""
+
+	// With -uuidcontent, only the content ID counts.
+	defer func(old bool) { *flagUuidContent = old }(*flagUuidContent)
+	*flagUuidContent = true
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"act1/content", "act2/content", true},
+		{"p1/c1/act1/content", "p2/c2/act2/content", true},
+		{"act/content", "content", true},
+		{"action/content1", "action/content2", false},
+		{"abc/def", "abc/def/", false},
+	} {
+		if got := buildIDsEquivalentForUUID(tc.a, tc.b); got != tc.want {
+			t.Errorf("with -uuidcontent, buildIDsEquivalentForUUID(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		ua, ub := machoBuildIdUuid(tc.a, MACHO_CPU_ARM64), machoBuildIdUuid(tc.b, MACHO_CPU_ARM64)
+		if got := bytes.Equal(ua, ub); got != tc.want {
+			t.Errorf("with -uuidcontent, %q and %q give equal UUIDs: %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+	if u, want := machoBuildIdUuid("act/content", MACHO_CPU_ARM64), uuidFromGoBuildId("content"); !bytes.Equal(u, want) {
+		t.Errorf("with -uuidcontent, UUID of act/content is %x, want %x, that of content", u, want)
+	}
+}
+
+func TestUuidCheckCollisions(t *testing.T) {
+	n := 200000
+	if testing.Short() {
+		n = 20000
+	}
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("action%d/content%d", i/2, i)
+	}
+	// A repeated build ID is not a collision.
+	ids = append(ids, ids[0])
+	for _, v := range []int{3, 4, 5} {
+		uuidOf := func(id string) []byte { return uuidFromGoBuildIdVersion(id, v) }
+		if err := uuidCheckCollisions(ids, uuidOf); err != nil {
+			t.Errorf("version %d: %v", v, err)
+		}
+	}
+
+	// Force collisions by keeping only the first byte of the UUID.
+	ids = ids[:1000]
+	err := uuidCheckCollisions(ids, func(id string) []byte { return uuidFromGoBuildId(id)[:1] })
+	if err == nil {
+		t.Fatal("no collisions reported for 1-byte UUIDs")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "both give UUID") || strings.Contains(msg, fmt.Sprintf("%q and %q", ids[0], ids[0])) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDeriveDeterministicID(t *testing.T) {
+	const id = "abc/def"
+	short := deriveDeterministicID(id, 8)
+	if len(short) != 8 {
+		t.Fatalf("got %d bytes, want 8", len(short))
+	}
+	if again := deriveDeterministicID(id, 8); !bytes.Equal(short, again) {
+		t.Errorf("ID not deterministic: %x != %x", short, again)
+	}
+	full := deriveDeterministicID(id, 16)
+	if !bytes.Equal(short, full[:8]) {
+		t.Errorf("8-byte ID %x is not a prefix of 16-byte ID %x", short, full)
+	}
+
+	// The UUID is the 16-byte ID with the version and variant bits set.
+	uuid := uuidFromGoBuildId(id)
+	for i := range full {
+		mask := byte(0xff)
+		switch i {
+		case 6:
+			mask = 0x0f
+		case 8:
+			mask = 0x3f
+		}
+		if uuid[i]&mask != full[i]&mask {
+			t.Errorf("UUID %x and 16-byte ID %x differ at byte %d", uuid, full, i)
+		}
+	}
+	if u := deriveDeterministicID("", 8); !bytes.Equal(u, make([]byte, 8)) {
+		t.Errorf("empty build ID: got %x, want zeros", u)
+	}
+}
+
+func TestUuidFromGoBuildIdVersion(t *testing.T) {
+	const id = "abc/def"
+	seen := make(map[string]int)
+	for _, version := range []int{3, 4, 5} {
+		uuid := uuidFromGoBuildIdVersion(id, version)
+		if got := int(uuid[6] >> 4); got != version {
+			t.Errorf("version %d: UUID %x has version %d", version, uuid, got)
+		}
+		// Version 3 keeps the historical variant bits.
+		wantVariant := byte(0x80)
+		if version == 3 {
+			wantVariant = 0xc0
+		}
+		if got := uuid[8] & 0xc0; got != wantVariant {
+			t.Errorf("version %d: UUID %x has variant bits %#x, want %#x", version, uuid, got, wantVariant)
+		}
+		if again := uuidFromGoBuildIdVersion(id, version); !bytes.Equal(uuid, again) {
+			t.Errorf("version %d: UUID not deterministic: %x != %x", version, uuid, again)
+		}
+		if other := uuidFromGoBuildIdVersion(id+"x", version); bytes.Equal(uuid, other) {
+			t.Errorf("version %d: different build IDs give the same UUID %x", version, uuid)
+		}
+		if v, ok := seen[string(uuid)]; ok {
+			t.Errorf("versions %d and %d give the same UUID %x", v, version, uuid)
+		}
+		seen[string(uuid)] = version
+		if u := uuidFromGoBuildIdVersion("", version); !bytes.Equal(u, make([]byte, 16)) {
+			t.Errorf("version %d: empty build ID: got %x, want zeros", version, u)
+		}
+	}
+}
+
+func TestMachoUpdateUuid(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		order binary.ByteOrder
+		is32  bool
+	}{
+		{"64-bit little-endian", binary.LittleEndian, false},
+		{"64-bit big-endian", binary.BigEndian, false},
+		{"32-bit little-endian", binary.LittleEndian, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &testMachO{order: tc.order, is32: tc.is32, cpu: macho.Cpu386, filetype: macho.TypeExec, size: 0x200}
+			m.cmds = [][]byte{
+				m.segment("__TEXT", 0x1000, 0x1000, 0, 0x200),
+				m.uuid(testUuid),
+			}
+			img := m.bytes()
+			orig := append([]byte(nil), img...)
+			exem := parseTestMachO(t, img)
+
+			want := uuidFromGoBuildId("test/buildid")
+			if err := machoUpdateUuid(testMachOBuf(img), exem, want); err != nil {
+				t.Fatal(err)
+			}
+			if got := testReadUuid(t, parseTestMachO(t, img)); !bytes.Equal(got, want) {
+				t.Errorf("got UUID %x, want %x", got, want)
+			}
+
+			// Only the 16 UUID bytes may change.
+			var diff int
+			for i := range img {
+				if img[i] != orig[i] {
+					diff++
+				}
+			}
+			if diff == 0 || diff > 16 {
+				t.Errorf("%d bytes changed, want between 1 and 16", diff)
+			}
+		})
+	}
+}
+
+func TestMachoUpdateUuidNoUuidCmd(t *testing.T) {
+	m := newTestMachO(testUuid)
+	m.cmds = m.cmds[:len(m.cmds)-1]
+	img := m.bytes()
+	orig := append([]byte(nil), img...)
+	if err := machoUpdateUuid(testMachOBuf(img), parseTestMachO(t, img), uuidFromGoBuildId("x")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img, orig) {
+		t.Errorf("image without LC_UUID was modified")
+	}
+}
+
+// testRewriteUuid runs machoRewriteUuid on img, as hostlink would on
+// the output of the external linker, and returns the rewritten image.
+func testRewriteUuid(t *testing.T, img []byte) []byte {
+	t.Helper()
+	return testRewriteUuidWritten(t, img, nil)
+}
+
+// testRewriteUuidWritten is testRewriteUuid, passing written to
+// machoRewriteUuid.
+func testRewriteUuidWritten(t *testing.T, img []byte, written func(arch string, uuid []byte)) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	if err := os.WriteFile(in, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	exef, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exef.Close()
+	exem, err := macho.NewFile(exef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exef.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoRewriteUuid(nil, exef, exem, out, written); err != nil {
+		t.Fatal(err)
+	}
+	outImg, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outImg
+}
+
+func TestMachoRewriteUuidSameFile(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	m := newTestMachO(testUuid)
+	m.size = 0x1000
+	img := m.signed()
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	if err := os.WriteFile(real, img, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The input and output paths name the same file through different
+	// symlinks.
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	for _, link := range []string{in, out} {
+		if err := os.Symlink("real", link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	exef, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exef.Close()
+	exem, err := macho.NewFile(exef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machoRewriteUuid(nil, exef, exem, out, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(img) {
+		t.Fatalf("file is %d bytes after the rewrite, want %d", len(got), len(img))
+	}
+	if u := testReadUuid(t, parseTestMachO(t, got)); !bytes.Equal(u, uuidFromGoBuildId(*flagBuildid)) {
+		t.Errorf("UUID = %x, want one derived from the build ID", u)
+	}
+	// The rest of the file survives: the result is what a rewrite to a
+	// separate output gives.
+	if want := testRewriteUuid(t, img); !bytes.Equal(got, want) {
+		t.Errorf("rewriting in place through symlinks gave a file differing from a copying rewrite")
+	}
+	if fi, err := os.Lstat(out); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("output symlink replaced: %v, %v", fi, err)
+	}
+}
+
+// testLd64Variants returns n copies of img, each with a different
+// random LC_UUID, simulating the output of n runs of a nondeterministic
+// external linker on the same inputs.
+func testLd64Variants(t *testing.T, img []byte, n int, rng *rand.Rand) [][]byte {
+	t.Helper()
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), parseTestMachO(t, img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var off int64 = -1
+	for _, c := range cmds {
+		if c.Cmd == LC_UUID {
+			off = c.Offset + 8
+		}
+	}
+	if off < 0 {
+		t.Fatal("image has no LC_UUID")
+	}
+	variants := make([][]byte, n)
+	for i := range variants {
+		v := append([]byte(nil), img...)
+		rng.Read(v[off : off+16])
+		variants[i] = v
+	}
+	return variants
+}
+
+func TestMachoRewriteUuid(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	img := newTestMachO(testUuid).bytes()
+	outImg := testRewriteUuid(t, img)
+	if len(outImg) != len(img) {
+		t.Fatalf("output is %d bytes, want %d", len(outImg), len(img))
+	}
+	want := uuidFromGoBuildId(*flagBuildid)
+	if got := testReadUuid(t, parseTestMachO(t, outImg)); !bytes.Equal(got, want) {
+		t.Errorf("got UUID %x, want %x", got, want)
+	}
+}
+
+func TestMachoRewriteUuidNoTrunc(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old func(string, int, os.FileMode) (*os.File, error)) { machoOpenFile = old }(machoOpenFile)
+	*flagBuildid = "test/buildid"
+
+	img := newTestMachO(testUuid).bytes()
+	want := testRewriteUuid(t, img)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	if err := os.WriteFile(in, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	exem := parseTestMachO(t, img)
+
+	errTrunc := errors.New("O_TRUNC not supported")
+	for _, tc := range []struct {
+		name    string
+		open    func(name string, flag int, perm os.FileMode) (*os.File, error)
+		wantErr bool
+	}{
+		{"rejected", func(name string, flag int, perm os.FileMode) (*os.File, error) {
+			if flag&os.O_TRUNC != 0 {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: errTrunc}
+			}
+			return os.OpenFile(name, flag, perm)
+		}, false},
+		{"ignored", func(name string, flag int, perm os.FileMode) (*os.File, error) {
+			return os.OpenFile(name, flag&^os.O_TRUNC, perm)
+		}, false},
+		{"broken", func(name string, flag int, perm os.FileMode) (*os.File, error) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: errTrunc}
+		}, true},
+	} {
+		machoOpenFile = tc.open
+		// A stale output larger than the new one.
+		out := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(out, bytes.Repeat([]byte{0xee}, 2*len(img)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		exef, err := os.Open(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = machoRewriteUuid(nil, exef, exem, out, nil)
+		exef.Close()
+		if tc.wantErr {
+			if !errors.Is(err, errTrunc) {
+				t.Errorf("%s: got error %v, want %v", tc.name, err, errTrunc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got, _ := os.ReadFile(out); !bytes.Equal(got, want) {
+			t.Errorf("%s: output of %d bytes is not the rewritten file", tc.name, len(got))
+		}
+	}
+}
+
+func TestMachoRewriteUuidWrongTarget(t *testing.T) {
+	// A wasm module, which must not be taken for a Mach-O file.
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wasm")
+	if err := os.WriteFile(in, []byte("\x00asm\x01\x00\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exef, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exef.Close()
+	for _, h := range []objabi.HeadType{objabi.Hwasip1, objabi.Hjs, objabi.Hlinux, objabi.Hwindows} {
+		out := filepath.Join(dir, "out."+h.String())
+		ctxt := &Link{Target: Target{HeadType: h, LinkMode: LinkExternal}}
+		err := machoRewriteUuid(ctxt, exef, nil, out, nil)
+		if err == nil || !strings.Contains(err.Error(), "not applicable for target "+h.String()) {
+			t.Errorf("%v: rewrite returned %v, want a not applicable error", h, err)
+		}
+		if _, err := os.Stat(out); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%v: rewrite created its output: %v", h, err)
+		}
+	}
+}
+
+// TestMachoRewriteUuidCorrupt corrupts one field of a valid image at a
+// time and checks that machoRewriteUuid rejects it with the error for
+// that corruption.
+func TestMachoRewriteUuidCorrupt(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	m := newTestMachO(testUuid)
+	// struct mach_header_64 { magic, cputype, cpusubtype, filetype, ncmds, sizeofcmds, ... }
+	const ncmdsOff, sizeofcmdsOff = 16, 20
+	// cmdOff returns the file offset of load command i.
+	cmdOff := func(i int) int {
+		off := machoHeaderSize64
+		for _, c := range m.cmds[:i] {
+			off += len(c)
+		}
+		return off
+	}
+	put := func(off int, v uint32) func([]byte) {
+		return func(img []byte) { m.order.PutUint32(img[off:], v) }
+	}
+	valid := m.bytes()
+	cmdsz := m.order.Uint32(valid[sizeofcmdsOff:])
+	uuidCmd := len(m.cmds) - 1
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name    string
+		corrupt func(img []byte)
+		want    error
+	}{
+		{"magic", put(0, macho.MagicFat), errBadMagic},
+		{"ncmd", put(ncmdsOff, 1<<20), errLoadCmdCount},
+		{"sizeofcmds short", put(sizeofcmdsOff, cmdsz-8), errLoadCmdOverrun},
+		{"sizeofcmds past the end of the file", put(sizeofcmdsOff, 0x10000), io.EOF},
+		{"command Len too small", put(cmdOff(1)+4, 4), errLoadCmdSize},
+		{"command Len past the load commands", put(cmdOff(1)+4, cmdsz), errLoadCmdOverrun},
+		{"LC_UUID Len", put(cmdOff(uuidCmd)+4, 16), errLoadCmdShort},
+	} {
+		img := append([]byte(nil), valid...)
+		tc.corrupt(img)
+		in := filepath.Join(dir, "in")
+		if err := os.WriteFile(in, img, 0644); err != nil {
+			t.Fatal(err)
+		}
+		exef, err := os.Open(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The header as the caller read it, unchecked.
+		exem := &macho.File{ByteOrder: m.order}
+		if err := binary.Read(bytes.NewReader(img), m.order, &exem.FileHeader); err != nil {
+			t.Fatal(err)
+		}
+		err = machoRewriteUuid(nil, exef, exem, filepath.Join(dir, "out"), nil)
+		exef.Close()
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: rewrite returned %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestMachoReplaceOutput(t *testing.T) {
+	defer func(syncFile, syncDir func(string) error, rename func(string, string) error) {
+		machoSyncFile, machoSyncDir, machoRename = syncFile, syncDir, rename
+	}(machoSyncFile, machoSyncDir, machoRename)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "a.out")
+	tmp := out + "~"
+	var steps []string
+	crash := ""
+	errCrash := errors.New("crash")
+	step := func(name string, f func() error) error {
+		steps = append(steps, name)
+		if name == crash {
+			return errCrash
+		}
+		return f()
+	}
+	machoSyncFile = func(path string) error {
+		return step("sync "+filepath.Base(path), func() error { return machoSyncPath(path) })
+	}
+	machoSyncDir = func(path string) error {
+		return step("sync dir", func() error { return machoSyncPath(path) })
+	}
+	machoRename = func(oldpath, newpath string) error {
+		return step("rename", func() error { return os.Rename(oldpath, newpath) })
+	}
+
+	for _, tc := range []struct {
+		crash   string
+		initial bool   // whether out exists beforehand
+		want    string // contents of out afterwards, or "" if it does not exist
+	}{
+		{crash: "", initial: true, want: "new"},
+		{crash: "", initial: false, want: "new"},
+		{crash: "sync a.out~", initial: true, want: "old"},
+		{crash: "sync dir", initial: true, want: "old"},
+		{crash: "rename", initial: true, want: "old"},
+		{crash: "rename", initial: false, want: ""},
+	} {
+		os.Remove(out)
+		if tc.initial {
+			if err := os.WriteFile(out, []byte("old"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(tmp, []byte("new"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		steps, crash = nil, tc.crash
+		err := machoReplaceOutput(tmp, out)
+		if tc.crash == "" {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"sync a.out~", "sync dir", "rename", "sync dir"}; !reflect.DeepEqual(steps, want) {
+				t.Errorf("steps %q, want %q", steps, want)
+			}
+		} else if !errors.Is(err, errCrash) {
+			t.Errorf("crash in %s: got error %v", tc.crash, err)
+		}
+		got, err := os.ReadFile(out)
+		if tc.want == "" {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("crash in %s: output exists (%q, %v)", tc.crash, got, err)
+			}
+		} else if string(got) != tc.want {
+			t.Errorf("crash in %q: output %q, %v; want %q", tc.crash, got, err, tc.want)
+		}
+		if _, err := os.Stat(tmp); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("crash in %q: temporary file left behind", tc.crash)
+		}
+	}
+}
+
+func TestMachoRewriteUuidConverges(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old [][2]string) { uuidCodeSections = old }(uuidCodeSections)
+	*flagBuildid = "test/buildid"
+
+	rng := rand.New(rand.NewSource(1))
+	variants := testLd64Variants(t, newTestMachO(testUuid).bytes(), 8, rng)
+	for _, sects := range [][][2]string{nil, {{"__TEXT", "__text"}}} {
+		uuidCodeSections = sects
+		var first []byte
+		for i, v := range variants {
+			out := testRewriteUuid(t, v)
+			if i == 0 {
+				first = out
+				if bytes.Equal(testReadUuid(t, parseTestMachO(t, out)), testReadUuid(t, parseTestMachO(t, v))) {
+					t.Errorf("sections %v: UUID not rewritten", sects)
+				}
+				continue
+			}
+			if !bytes.Equal(out, first) {
+				t.Errorf("sections %v: rewriting variant %d gave UUID %x, variant 0 gave %x", sects, i,
+					testReadUuid(t, parseTestMachO(t, out)), testReadUuid(t, parseTestMachO(t, first)))
+			}
+		}
+	}
+}
+
+func TestMachoWriteUuidFile(t *testing.T) {
+	dir := t.TempDir()
+	sidecar := filepath.Join(dir, "uuid.txt")
+
+	thin := newTestMachO(testUuid)
+	img := thin.bytes()
+	newUuid := uuidFromGoBuildId("test/buildid")
+	if err := machoUpdateUuid(testMachOBuf(img), parseTestMachO(t, img), newUuid); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "thin")
+	if err := os.WriteFile(exe, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoWriteUuidFile(sidecar, exe); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u [16]byte
+	copy(u[:], newUuid)
+	want := "UUID: " + machoUuidString(u) + " (x86_64) " + exe + "\n"
+	if string(got) != want {
+		t.Errorf("thin sidecar:\ngot  %q\nwant %q", got, want)
+	}
+
+	arm := newTestMachO([16]byte{1})
+	arm.cpu = macho.CpuArm64
+	exe = filepath.Join(dir, "fat")
+	if err := os.WriteFile(exe, testFatMachO(12, newTestMachO(testUuid), arm), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoWriteUuidFile(sidecar, exe); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "UUID: DEADBEEF-0102-0304-0506-0708090A0B0C (x86_64) " + exe + "\n" +
+		"UUID: 01000000-0000-0000-0000-000000000000 (arm64) " + exe + "\n"
+	if string(got) != want {
+		t.Errorf("fat sidecar:\ngot  %q\nwant %q", got, want)
+	}
+
+	noUuid := newTestMachO(testUuid)
+	noUuid.cmds = noUuid.cmds[:len(noUuid.cmds)-1]
+	exe = filepath.Join(dir, "nouuid")
+	if err := os.WriteFile(exe, noUuid.bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoWriteUuidFile(sidecar, exe); err == nil || !strings.Contains(err.Error(), "no LC_UUID") {
+		t.Errorf("got error %v, want missing LC_UUID error", err)
+	}
+}
+
+func TestMachoUuidLocation(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		order binary.ByteOrder
+		is32  bool
+	}{
+		{"64-bit little-endian", binary.LittleEndian, false},
+		{"64-bit big-endian", binary.BigEndian, false},
+		{"32-bit little-endian", binary.LittleEndian, true},
+	} {
+		m := &testMachO{order: tc.order, is32: tc.is32, cpu: macho.Cpu386, filetype: macho.TypeExec, size: 0x200}
+		m.cmds = [][]byte{
+			m.segment("__TEXT", 0x1000, 0x1000, 0, 0x200),
+			m.raw(LC_SOURCE_VERSION, make([]byte, 8)),
+			// An LC_UUID padded past its 16 bytes of payload.
+			m.raw(LC_UUID, append(testUuid[:], 0xff, 0xff, 0xff, 0xff)),
+		}
+		img := m.bytes()
+		off, n, order, err := machoUuidLocation(parseTestMachO(t, img), testMachOBuf(img))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if n != 16 || order != tc.order {
+			t.Errorf("%s: length %d, byte order %v; want 16, %v", tc.name, n, order, tc.order)
+		}
+		if !bytes.Equal(img[off:off+int64(n)], testUuid[:]) {
+			t.Errorf("%s: bytes at %#x are %x, want the UUID %x", tc.name, off, img[off:off+int64(n)], testUuid)
+		}
+		if cmd := m.order.Uint32(img[off-8:]); macho.LoadCmd(cmd) != LC_UUID {
+			t.Errorf("%s: offset %#x is not just past an LC_UUID command header", tc.name, off)
+		}
+	}
+
+	// The offset of a slice of a fat file is relative to the slice.
+	amd, arm := newTestMachO(testUuid), newTestMachO([16]byte{1})
+	arm.cpu = macho.CpuArm64
+	fat := testFatMachO(12, amd, arm)
+	slices, err := machoFatSlices(bytes.NewReader(fat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := slices[1]
+	exem, err := macho.NewFile(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, n, _, err := machoUuidLocation(exem, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fat[s.Offset+off : s.Offset+off+int64(n)]; !bytes.Equal(got, []byte{1, 15: 0}) {
+		t.Errorf("arm64 slice: bytes at %#x+%#x are %x", s.Offset, off, got)
+	}
+
+	m := newTestMachO(testUuid)
+	m.cmds = m.cmds[:3]
+	img := m.bytes()
+	if _, _, _, err := machoUuidLocation(parseTestMachO(t, img), testMachOBuf(img)); err != errNoUuid {
+		t.Errorf("file with no LC_UUID: err = %v, want %v", err, errNoUuid)
+	}
+	m.cmds = append(m.cmds, m.raw(LC_UUID, testUuid[:8]))
+	img = m.bytes()
+	if _, _, _, err := machoUuidLocation(parseTestMachO(t, img), testMachOBuf(img)); !errors.Is(err, errLoadCmdShort) {
+		t.Errorf("short LC_UUID: err = %v, want %v", err, errLoadCmdShort)
+	}
+}
+
+// TestMachoPageZero checks that the __PAGEZERO segment of a typical
+// executable, which maps 4GB at address 0 but has no file data, does
+// not disturb the file offsets the UUID rewrite works with.
+func TestMachoPageZero(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	withZero := newTestMachO(testUuid)
+	if seg := parseTestMachO(t, withZero.bytes()).Segment("__PAGEZERO"); seg == nil || seg.Addr != 0 || seg.Memsz != 1<<32 || seg.Offset != 0 || seg.Filesz != 0 {
+		t.Fatalf("fixture __PAGEZERO is %+v, want the standard one", seg)
+	}
+	noZero := newTestMachO(testUuid)
+	noZero.cmds = noZero.cmds[1:]
+	zeroLen := int64(len(withZero.cmds[0]))
+
+	var offs []int64
+	for _, m := range []*testMachO{withZero, noZero} {
+		img := m.bytes()
+		exem := parseTestMachO(t, img)
+		want := int64(machoHeaderSize64)
+		for _, c := range m.cmds[:len(m.cmds)-1] {
+			want += int64(len(c))
+		}
+		want += 8
+		off, _, _, err := machoUuidLocation(exem, testMachOBuf(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != want {
+			t.Errorf("%d commands: UUID payload at %#x, want %#x", len(m.cmds), off, want)
+		}
+		offs = append(offs, off)
+
+		out := testRewriteUuid(t, img)
+		if got := out[off : off+16]; !bytes.Equal(got, uuidFromGoBuildId(*flagBuildid)) {
+			t.Errorf("%d commands: UUID rewritten to %x", len(m.cmds), got)
+		}
+		for i := range img {
+			if (int64(i) < off || int64(i) >= off+16) && img[i] != out[i] {
+				t.Errorf("%d commands: byte %#x outside the UUID changed", len(m.cmds), i)
+				break
+			}
+		}
+
+		// __text is found at its file offset, not at its address.
+		slack, err := machoHeaderSlack(exem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 0x400 - machoHeaderSize64 - int64(exem.Cmdsz); slack != want {
+			t.Errorf("%d commands: header slack %#x, want %#x", len(m.cmds), slack, want)
+		}
+	}
+	// The segment moves the UUID by the size of its command and no more.
+	if offs[0]-offs[1] != zeroLen {
+		t.Errorf("__PAGEZERO moved the UUID by %#x bytes, want %#x", offs[0]-offs[1], zeroLen)
+	}
+
+	text := [][2]string{{"__TEXT", "__text"}}
+	a, err := uuidFromCode(parseTestMachO(t, withZero.bytes()), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := uuidFromCode(parseTestMachO(t, noZero.bytes()), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("-uuidfromcode UUID depends on __PAGEZERO: %x and %x", a, b)
+	}
+}
+
+func TestMachoAllUuids(t *testing.T) {
+	amd := newTestMachO(testUuid)
+	arm := newTestMachO([16]byte{1})
+	arm.cpu = macho.CpuArm64
+	arm.subcpu = 2 // CPU_SUBTYPE_ARM64E
+	ppc := &testMachO{order: binary.BigEndian, is32: true, cpu: macho.CpuPpc, filetype: macho.TypeExec, size: 0x600}
+	ppc.cmds = [][]byte{ppc.uuid([16]byte{2})}
+	path := filepath.Join(t.TempDir(), "fat")
+	if err := os.WriteFile(path, testFatMachO(14, amd, arm, ppc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := machoAllUuids(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each slice starts on its own 1<<14-byte boundary.
+	want := []machoArchUuid{
+		{Cpu: macho.CpuAmd64, SubCpu: 3, ByteOrder: binary.LittleEndian, Offset: 0x4000, Uuid: testUuid},
+		{Cpu: macho.CpuArm64, SubCpu: 2, ByteOrder: binary.LittleEndian, Offset: 0x8000, Uuid: [16]byte{1}},
+		{Cpu: macho.CpuPpc, SubCpu: 0, ByteOrder: binary.BigEndian, Offset: 0xc000, Uuid: [16]byte{2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// countingReaderAt records the byte ranges read from a Mach-O file,
+// standing in for a client fetching a remote file with range requests.
+type countingReaderAt struct {
+	r      io.ReaderAt
+	n      int64
+	ranges [][2]int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	c.ranges = append(c.ranges, [2]int64{off, off + int64(n)})
+	return n, err
+}
+
+func TestMachoReadUuidsHeadersOnly(t *testing.T) {
+	// The symbol table at the end of __LINKEDIT, which macho.NewFile
+	// would read (and reject, its contents being filler), is well past
+	// the load commands.
+	amd := newTestMachO(testUuid)
+	amd.cmds = append(amd.cmds, amd.symtab(0x500, 8, 0x580, 0x80))
+	arm := newTestMachO([16]byte{1})
+	arm.cpu = macho.CpuArm64
+	arm.cmds = append(arm.cmds, arm.symtab(0x500, 8, 0x580, 0x80))
+
+	for _, tc := range []struct {
+		name string
+		img  []byte
+		want []machoArchUuid
+	}{
+		{"thin", amd.bytes(), []machoArchUuid{{Cpu: macho.CpuAmd64, SubCpu: 3, ByteOrder: binary.LittleEndian, Uuid: testUuid}}},
+		{"fat", testFatMachO(12, amd, arm), []machoArchUuid{
+			{Cpu: macho.CpuAmd64, SubCpu: 3, ByteOrder: binary.LittleEndian, Offset: 0x1000, Uuid: testUuid},
+			{Cpu: macho.CpuArm64, SubCpu: arm.subcpu, ByteOrder: binary.LittleEndian, Offset: 0x2000, Uuid: [16]byte{1}},
+		}},
+	} {
+		r := &countingReaderAt{r: testMachOBuf(tc.img)}
+		got, err := machoReadUuids(r)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+
+		// Every read is of a fat header or of a slice's Mach-O header
+		// and load commands.
+		var allowed [][2]int64
+		slices := [][2]int64{{0, int64(len(tc.img))}}
+		if binary.BigEndian.Uint32(tc.img) == macho.MagicFat {
+			// Not macho.NewFatFile, which parses the slices in full.
+			n := binary.BigEndian.Uint32(tc.img[4:])
+			allowed = append(allowed, [2]int64{0, 8 + 20*int64(n)})
+			slices = slices[:0]
+			for i := uint32(0); i < n; i++ {
+				a := tc.img[8+20*i:]
+				off, size := binary.BigEndian.Uint32(a[8:]), binary.BigEndian.Uint32(a[12:])
+				slices = append(slices, [2]int64{int64(off), int64(off + size)})
+			}
+		}
+		var want int64
+		for _, s := range slices {
+			exem, err := machoParseRaw(testMachOBuf(tc.img[s[0]:s[1]]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			allowed = append(allowed, [2]int64{s[0], s[0] + machoHeaderSize(exem) + int64(exem.Cmdsz)})
+		}
+		for _, a := range allowed {
+			want += a[1] - a[0]
+		}
+	read:
+		for _, rg := range r.ranges {
+			for _, a := range allowed {
+				if a[0] <= rg[0] && rg[1] <= a[1] {
+					continue read
+				}
+			}
+			t.Errorf("%s: read of [%#x, %#x) is outside the headers %#x", tc.name, rg[0], rg[1], allowed)
+		}
+		// The magic numbers are read more than once, but nothing else.
+		if r.n > want+16*int64(len(slices)) {
+			t.Errorf("%s: read %d bytes for %d bytes of headers", tc.name, r.n, want)
+		}
+	}
+}
+
+func TestMachoReadUuidsCore(t *testing.T) {
+	// A core dump of a process with the executable mapped at
+	// 0x100000000 and a page of heap, which is not a binary, after it.
+	exe := newTestMachO(testUuid)
+	heap := make([]byte, 0x1000)
+	binary.LittleEndian.PutUint32(heap, 0xfeedfa) // not a Mach-O magic
+	core := &testMachO{order: binary.LittleEndian, cpu: macho.CpuAmd64, subcpu: 3, filetype: macho.Type(MH_CORE), size: 0x1000}
+	core.cmds = [][]byte{
+		core.raw(LC_THREAD, make([]byte, 16)),
+		core.segment("", 0x100000000, 0x1000, 0x1000, 0x600),
+		core.segment("", 0x200000000, 0x1000, 0x1600, 0x1000),
+	}
+	img := append(append(core.bytes(), exe.bytes()...), heap...)
+
+	got, err := machoReadUuids(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []machoArchUuid{{Cpu: macho.CpuAmd64, SubCpu: 3, ByteOrder: binary.LittleEndian, Offset: 0x1000, Uuid: testUuid}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// A segment that starts with a Mach-O header whose load commands
+	// run past the segment is an error, not a binary without a UUID.
+	core.cmds[1] = core.segment("", 0x100000000, 0x1000, 0x1000, 0x40)
+	img = append(append(core.bytes(), exe.bytes()...), heap...)
+	if _, err := machoReadUuids(testMachOBuf(img)); err == nil {
+		t.Error("reading a core with a truncated binary succeeded")
+	}
+}
+
+func TestMachoRewriteFatUuids(t *testing.T) {
+	// Three slices with their own byte order, word size and load
+	// commands, so that LC_UUID is at a different offset in each.
+	slices := func(uuids map[macho.Cpu][16]byte) []*testMachO {
+		amd := newTestMachO(uuids[macho.CpuAmd64])
+		amd.cmds = append(amd.cmds, amd.raw(LC_DYLD_INFO_ONLY, make([]byte, 40)))
+
+		arm := newTestMachO(uuids[macho.CpuArm64])
+		arm.cpu = macho.CpuArm64
+		arm.subcpu = 0
+		linkedit := make([]byte, 8)
+		arm.order.PutUint32(linkedit[0:], 0x500)
+		arm.order.PutUint32(linkedit[4:], 0x10)
+		arm.cmds = append(arm.cmds[:3:3],
+			arm.raw(LC_DYLD_CHAINED_FIXUPS, linkedit),
+			arm.raw(LC_DYLD_EXPORTS_TRIE, linkedit),
+			arm.filler(0x38),
+			arm.uuid(uuids[macho.CpuArm64]))
+
+		ppc := &testMachO{
+			order:    binary.BigEndian,
+			is32:     true,
+			cpu:      macho.CpuPpc,
+			filetype: macho.TypeExec,
+			size:     0x600,
+		}
+		ppc.cmds = [][]byte{
+			ppc.uuid(uuids[macho.CpuPpc]),
+			ppc.segment("__TEXT", 0x1000, 0x1000, 0, 0x500,
+				testSect{name: "__text", seg: "__TEXT", addr: 0x1400, size: 0x100, offset: 0x400, align: 4}),
+			ppc.segment("__LINKEDIT", 0x2000, 0x1000, 0x500, 0x100),
+		}
+		return []*testMachO{amd, arm, ppc}
+	}
+	old := map[macho.Cpu][16]byte{macho.CpuAmd64: testUuid, macho.CpuArm64: testUuid, macho.CpuPpc: testUuid}
+	want := map[macho.Cpu][16]byte{macho.CpuAmd64: {0xa}, macho.CpuArm64: {0xb}, macho.CpuPpc: {0xc}}
+
+	img := testFatMachO(12, slices(old)...)
+	f := append(testMachOBuf(nil), img...)
+	err := machoRewriteFatUuids(f, func(_ io.ReaderAt, exem *macho.File) ([]byte, error) {
+		if (exem.Cpu == macho.CpuPpc) != (exem.ByteOrder == binary.BigEndian) {
+			t.Errorf("%v slice parsed as %v", exem.Cpu, exem.ByteOrder)
+		}
+		u, ok := want[exem.Cpu]
+		if !ok {
+			t.Fatalf("unexpected slice for %v", exem.Cpu)
+		}
+		return u[:], nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Everything but the three UUIDs is unchanged.
+	if wantImg := testFatMachO(12, slices(want)...); !bytes.Equal(f, wantImg) {
+		t.Error("rewritten fat file is not the one with the new UUIDs")
+	}
+	got, err := machoReadUuids(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range got {
+		if u.Uuid != want[u.Cpu] {
+			t.Errorf("%v slice has UUID %x, want %x", u.Cpu, u.Uuid, want[u.Cpu])
+		}
+	}
+
+	// A thin file is not a fat one.
+	if err := machoRewriteFatUuids(testMachOBuf(newTestMachO(testUuid).bytes()), nil, nil); err == nil {
+		t.Error("rewriting a thin file as fat succeeded")
+	}
+}
+
+func TestMachoRewriteFatUuidsMixedWordSize(t *testing.T) {
+	// A 32-bit slice ahead of a 64-bit one, with the same load
+	// commands: LC_UUID comes after a 28-byte header in the first and a
+	// 32-byte one in the second.
+	i386 := &testMachO{order: binary.LittleEndian, is32: true, cpu: macho.Cpu386, filetype: macho.TypeExec, size: 0x600}
+	i386.cmds = [][]byte{
+		i386.segment("__TEXT", 0x1000, 0x1000, 0, 0x500),
+		i386.uuid(testUuid),
+	}
+	amd := newTestMachO(testUuid)
+	img := testFatMachO(12, i386, amd)
+	ff, err := macho.NewFatFile(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[macho.Cpu]int64{
+		macho.Cpu386:   int64(ff.Arches[0].Offset) + 28 + int64(len(i386.cmds[0])) + 8,
+		macho.CpuAmd64: int64(ff.Arches[1].Offset) + machoHeaderSize64 + int64(len(amd.cmds[0])+len(amd.cmds[1])+len(amd.cmds[2])) + 8,
+	}
+	ff.Close()
+
+	rec := &machoPatchRecorder{f: append(testMachOBuf(nil), img...)}
+	newUuid := map[macho.Cpu][16]byte{macho.Cpu386: {0xa}, macho.CpuAmd64: {0xb}}
+	err = machoRewriteFatUuids(rec, func(_ io.ReaderAt, exem *macho.File) ([]byte, error) {
+		u := newUuid[exem.Cpu]
+		return u[:], nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.patches) != len(want) {
+		t.Fatalf("got %d writes, want one per slice: %+v", len(rec.patches), rec.patches)
+	}
+	for i, a := range []macho.Cpu{macho.Cpu386, macho.CpuAmd64} {
+		p := rec.patches[i]
+		if u := newUuid[a]; p.Offset != want[a] || !bytes.Equal(p.New, u[:]) {
+			t.Errorf("%v slice: UUID written at %#x (%x), want %#x (%x)", a, p.Offset, p.New, want[a], u)
+		}
+	}
+}
+
+func TestMachoUuidWritten(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	type call struct {
+		arch string
+		uuid []byte
+	}
+	var calls []call
+	written := func(arch string, uuid []byte) {
+		calls = append(calls, call{arch, uuid})
+	}
+
+	out := testRewriteUuidWritten(t, newTestMachO(testUuid).signed(), written)
+	want := []call{{"x86_64", testReadUuid(t, parseTestMachO(t, out))}}
+	if !reflect.DeepEqual(calls, want) || !bytes.Equal(want[0].uuid, uuidFromGoBuildId(*flagBuildid)) {
+		t.Errorf("thin rewrite: called with %x, want %x", calls, want)
+	}
+
+	calls = nil
+	amd := newTestMachO(testUuid)
+	arm := newTestMachO(testUuid)
+	arm.cpu, arm.subcpu = macho.CpuArm64, 0
+	f := testMachOBuf(testFatMachO(12, amd, arm))
+	newUuid := map[macho.Cpu][16]byte{macho.CpuAmd64: {0xa}, macho.CpuArm64: {0xb}}
+	err := machoRewriteFatUuids(f, func(_ io.ReaderAt, exem *macho.File) ([]byte, error) {
+		u := newUuid[exem.Cpu]
+		return u[:], nil
+	}, written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuids, err := machoReadUuids(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = nil
+	for _, u := range uuids {
+		want = append(want, call{machoArchName(u.Cpu), append([]byte(nil), u.Uuid[:]...)})
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("fat rewrite: called with %x, want one call per slice with its final UUID %x", calls, want)
+	}
+}
+
+func TestMachoKeepUuids(t *testing.T) {
+	ours := [16]byte(uuidFromGoBuildId("test/buildid"))
+	theirs := [16]byte{0xee, 0xee}
+	path := filepath.Join(t.TempDir(), "exe")
+	writer := func(img []byte) func() error {
+		return func() error { return os.WriteFile(path, img, 0755) }
+	}
+	thin := func(u [16]byte) []byte { return newTestMachO(u).signed() }
+	fat := func(u [16]byte) []byte {
+		amd := newTestMachO(u)
+		arm := newTestMachO(u)
+		arm.cpu, arm.subcpu = macho.CpuArm64, 0
+		return testFatMachO(14, amd, arm)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		image func([16]byte) []byte
+	}{
+		{"thin", thin},
+		{"fat", fat},
+	} {
+		if err := os.WriteFile(path, tc.image(ours), 0755); err != nil {
+			t.Fatal(err)
+		}
+		// A strip that re-signs the file with a UUID of its own.
+		if err := machoKeepUuids(path, writer(tc.image(theirs))); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tc.image(ours)) {
+			t.Errorf("%s: UUID not restored after strip", tc.name)
+		}
+		if tc.name == "thin" {
+			if bad := testBadPages(t, got); len(bad) != 0 {
+				t.Errorf("pages %v do not match the code signature after restoring the UUID", bad)
+			}
+		}
+	}
+
+	// A strip that leaves the UUID alone leaves nothing to write.
+	if err := os.WriteFile(path, thin(ours), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoKeepUuids(path, func() error { return nil }); err != nil {
+		t.Errorf("unchanged UUID: %v", err)
+	}
+
+	// Nothing to restore a removed LC_UUID to.
+	noUuid := newTestMachO(ours)
+	noUuid.cmds = noUuid.cmds[:3]
+	if err := machoKeepUuids(path, writer(noUuid.bytes())); err == nil {
+		t.Error("strip removing LC_UUID not reported")
+	}
+
+	errStrip := errors.New("strip failed")
+	if err := machoKeepUuids(path, func() error { return errStrip }); err != errStrip {
+		t.Errorf("failing strip: got error %v, want %v", err, errStrip)
+	}
+}
+
+func TestMachoUuidPerCpu(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old bool) { *flagUuidPerCpu = old }(*flagUuidPerCpu)
+	*flagBuildid = "test/buildid"
+
+	rewrite := func() map[macho.Cpu][16]byte {
+		amd := newTestMachO(testUuid)
+		arm := newTestMachO(testUuid)
+		arm.cpu = macho.CpuArm64
+		arm.subcpu = 0
+		f := append(testMachOBuf(nil), testFatMachO(12, amd, arm)...)
+		if err := machoRewriteFatUuids(f, machoOutputUuid, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := machoReadUuids(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uuids := make(map[macho.Cpu][16]byte)
+		for _, u := range got {
+			uuids[u.Cpu] = u.Uuid
+		}
+		return uuids
+	}
+
+	*flagUuidPerCpu = false
+	same := rewrite()
+	want := [16]byte(uuidFromGoBuildId(*flagBuildid))
+	if same[macho.CpuAmd64] != want || same[macho.CpuArm64] != want {
+		t.Errorf("without -uuidpercpu, UUIDs are %x, want %x for every slice", same, want)
+	}
+
+	*flagUuidPerCpu = true
+	salted := rewrite()
+	amd, arm := salted[macho.CpuAmd64], salted[macho.CpuArm64]
+	if amd == arm || amd == want || arm == want {
+		t.Errorf("with -uuidpercpu, UUIDs are %x, want a distinct one per slice", salted)
+	}
+	for cpu, u := range salted {
+		if want := [16]byte(uuidFromGoBuildIdCpu(*flagBuildid, cpu)); u != want {
+			t.Errorf("%v slice has UUID %x, want %x", cpu, u, want)
+		}
+		if u[6]>>4 != 3 {
+			t.Errorf("%v slice has UUID version %d, want 3", cpu, u[6]>>4)
+		}
+	}
+	if again := rewrite(); again[macho.CpuAmd64] != amd || again[macho.CpuArm64] != arm {
+		t.Errorf("second link gave UUIDs %x, want %x", again, salted)
+	}
+	if got := machoTargetCpu(sys.ArchARM64); got != macho.CpuArm64 {
+		t.Errorf("machoTargetCpu(arm64) = %v", got)
+	}
+}
+
+func TestMachoUuidPgo(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old string) { *flagUuidPgo = old }(*flagUuidPgo)
+	defer func(old string) { uuidPgoHash = old }(uuidPgoHash)
+	defer func(old bool) { *flagUuidPerCpu = old }(*flagUuidPerCpu)
+	*flagBuildid = "test/buildid"
+
+	dir := t.TempDir()
+	profiles := map[string]string{
+		"a.pprof":      "profile A",
+		"b.pprof":      "profile B",
+		"copy/a.pprof": "profile A", // the same profile kept elsewhere
+	}
+	if err := os.Mkdir(filepath.Join(dir, "copy"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	uuid := func(profile string) [16]byte {
+		t.Helper()
+		path := filepath.Join(dir, profile)
+		if err := os.WriteFile(path, []byte(profiles[profile]), 0666); err != nil {
+			t.Fatal(err)
+		}
+		h, err := machoPgoProfileHash(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*flagUuidPgo, uuidPgoHash = path, h
+		u, err := machoOutputUuid(nil, parseTestMachO(t, newTestMachO(testUuid).bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := machoExplainBuildIdUuid(&buf, *flagBuildid, macho.CpuAmd64, u); err != nil {
+			t.Errorf("%s: %v", profile, err)
+		}
+		return [16]byte(u)
+	}
+
+	for _, perCpu := range []bool{false, true} {
+		*flagUuidPerCpu = perCpu
+		uuidPgoHash = ""
+		plain := [16]byte(machoBuildIdUuid(*flagBuildid, macho.CpuAmd64))
+		a, b := uuid("a.pprof"), uuid("b.pprof")
+		if a == b || a == plain || b == plain {
+			t.Errorf("perCpu=%v: UUIDs %x and %x for two profiles, %x without one; want three distinct", perCpu, a, b, plain)
+		}
+		if again := uuid("a.pprof"); again != a {
+			t.Errorf("perCpu=%v: second link with the same profile gave UUID %x, want %x", perCpu, again, a)
+		}
+		if moved := uuid("copy/a.pprof"); moved != a {
+			t.Errorf("perCpu=%v: the same profile elsewhere gave UUID %x, want %x", perCpu, moved, a)
+		}
+		if a[6]>>4 != 3 {
+			t.Errorf("perCpu=%v: UUID version %d, want 3", perCpu, a[6]>>4)
+		}
+	}
+
+	// No build ID still means no UUID.
+	if u := machoBuildIdUuid("", macho.CpuAmd64); !bytes.Equal(u, make([]byte, 16)) {
+		t.Errorf("UUID for an empty build ID is %x, want zeros", u)
+	}
+}
+
+func TestMachoUuidImage(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old string) { uuidImageDigest = old }(uuidImageDigest)
+	defer func(old string) { uuidPgoHash = old }(uuidPgoHash)
+	defer func(old int) { uuidVersion = old }(uuidVersion)
+	*flagBuildid = "test/buildid"
+	uuidPgoHash = ""
+
+	for _, bad := range []string{"", "sha256", "sha256:", ":abc", "SHA256:abc", "sha256:ab cd", "sha256:ab\x00"} {
+		if err := machoCheckImageDigest(bad); err == nil {
+			t.Errorf("machoCheckImageDigest(%q) succeeded", bad)
+		}
+	}
+	digestA := "sha256:" + strings.Repeat("a", 64)
+	digestB := "sha256:" + strings.Repeat("b", 64)
+	for _, d := range []string{digestA, digestB} {
+		if err := machoCheckImageDigest(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exem := parseTestMachO(t, newTestMachO(testUuid).bytes())
+	uuid := func(digest string) [16]byte {
+		t.Helper()
+		uuidImageDigest = digest
+		u, err := machoOutputUuid(nil, exem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := machoExplainBuildIdUuid(&buf, *flagBuildid, exem.Cpu, u); err != nil {
+			t.Errorf("%q: %v", digest, err)
+		}
+		return [16]byte(u)
+	}
+	for _, v := range []int{3, 4, 5} {
+		uuidVersion = v
+		plain := uuid("")
+		a, b := uuid(digestA), uuid(digestB)
+		if a == b || a == plain || b == plain {
+			t.Errorf("version %d: UUIDs %x and %x for two images, %x without one; want three distinct", v, a, b, plain)
+		}
+		if again := uuid(digestA); again != a {
+			t.Errorf("version %d: second link in the same image gave UUID %x, want %x", v, again, a)
+		}
+		if int(a[6]>>4) != v {
+			t.Errorf("version %d: UUID has version %d", v, a[6]>>4)
+		}
+	}
+}
+
+func TestMachoWriteUuidJSON(t *testing.T) {
+	dir := t.TempDir()
+	thin := filepath.Join(dir, "thin")
+	if err := os.WriteFile(thin, newTestMachO(testUuid).bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arm := newTestMachO([16]byte{1})
+	arm.cpu = macho.CpuArm64
+	fat := filepath.Join(dir, "fat")
+	if err := os.WriteFile(fat, testFatMachO(12, newTestMachO(testUuid), arm), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		exe  string
+		want string
+	}{
+		{thin, `{"path":%q,"arches":[{"cpu":"x86_64","uuid":"DEADBEEF-0102-0304-0506-0708090A0B0C"}]}`},
+		{fat, `{"path":%q,"arches":[{"cpu":"x86_64","uuid":"DEADBEEF-0102-0304-0506-0708090A0B0C"},{"cpu":"arm64","uuid":"01000000-0000-0000-0000-000000000000"}]}`},
+	} {
+		var buf bytes.Buffer
+		if err := machoWriteUuidJSON(&buf, tc.exe); err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("%s: invalid JSON %q", tc.exe, buf.String())
+		}
+		if want := fmt.Sprintf(tc.want, tc.exe) + "\n"; buf.String() != want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tc.exe, buf.String(), want)
+		}
+	}
+}
+
+func TestMachoUpdateUuidUnusualSegments(t *testing.T) {
+	// __LINKEDIT comes first in the load commands and lives far from
+	// the end of __TEXT, and __TEXT's section is not at the start of
+	// the file data. None of this should affect where the UUID is.
+	m := newTestMachO(testUuid)
+	m.size = 0x3000
+	m.cmds = [][]byte{
+		m.segment("__LINKEDIT", 0x100008000, 0x1000, 0x2800, 0x800),
+		m.segment("__DATA", 0x100004000, 0x4000, 0x1000, 0x1000),
+		m.uuid(testUuid),
+		m.segment("__TEXT", 0x100000000, 0x1000, 0, 0x1000,
+			testSect{name: "__text", seg: "__TEXT", addr: 0x100000c00, size: 0x400, offset: 0xc00}),
+	}
+	img := m.bytes()
+	orig := append([]byte(nil), img...)
+
+	// The UUID payload starts 8 bytes into the third command.
+	uuidOff := machoHeaderSize64 + len(m.cmds[0]) + len(m.cmds[1]) + 8
+	if !bytes.Equal(img[uuidOff:uuidOff+16], testUuid[:]) {
+		t.Fatalf("fixture UUID not at offset %#x", uuidOff)
+	}
+
+	want := uuidFromGoBuildId("test/buildid")
+	if err := machoUpdateUuid(testMachOBuf(img), parseTestMachO(t, img), want); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img[uuidOff:uuidOff+16], want) {
+		t.Errorf("UUID at %#x = %x, want %x", uuidOff, img[uuidOff:uuidOff+16], want)
+	}
+	copy(img[uuidOff:], testUuid[:])
+	if !bytes.Equal(img, orig) {
+		t.Errorf("bytes other than the UUID payload changed")
+	}
+}
+
+func TestMachoUpdateUuidCommandsOverrun(t *testing.T) {
+	m := newTestMachO(testUuid)
+	img := m.bytes()
+	exem := parseTestMachO(t, img)
+	// Claim the load commands are shorter than they are, so that the
+	// last command runs past the region.
+	exem.Cmdsz -= 8
+	err := machoUpdateUuid(testMachOBuf(img), exem, uuidFromGoBuildId("x"))
+	if err == nil || !strings.Contains(err.Error(), "past the end") {
+		t.Errorf("got error %v, want overrun error", err)
+	}
+}
+
+func TestMachoUpdateUuidShortCommand(t *testing.T) {
+	for _, size := range []uint32{0, 4} {
+		m := newTestMachO(testUuid)
+		img := m.bytes()
+		exem := parseTestMachO(t, img)
+		// Corrupt the cmdsize of the command before LC_UUID.
+		off := machoHeaderSize64 + len(m.cmds[0]) + len(m.cmds[1])
+		m.order.PutUint32(img[off+4:], size)
+		orig := append([]byte(nil), img...)
+
+		err := machoUpdateUuid(testMachOBuf(img), exem, uuidFromGoBuildId("x"))
+		if err == nil || !strings.Contains(err.Error(), "bad size") {
+			t.Errorf("cmdsize %d: machoUpdateUuid error %v, want bad size", size, err)
+		}
+		if !bytes.Equal(img, orig) {
+			t.Errorf("cmdsize %d: machoUpdateUuid modified the file", size)
+		}
+		if _, err := machoReadLoadCmds(testMachOBuf(img), exem); err == nil || !strings.Contains(err.Error(), "bad size") {
+			t.Errorf("cmdsize %d: machoReadLoadCmds error %v, want bad size", size, err)
+		}
+	}
+}
+
+func TestMachoImplausibleNcmd(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		ncmd, cmdsz uint32
+	}{
+		{"more than fit", 1 << 24, 0x1000},
+		{"over the cap", machoMaxLoadCmds + 1, 8 * (machoMaxLoadCmds + 1)},
+	} {
+		m := newTestMachO(testUuid)
+		img := m.bytes()
+		exem := parseTestMachO(t, img)
+		exem.Ncmd, exem.Cmdsz = tc.ncmd, tc.cmdsz
+		// struct mach_header_64 { magic, cputype, cpusubtype, filetype, ncmds, sizeofcmds, ... }
+		m.order.PutUint32(img[16:], tc.ncmd)
+		m.order.PutUint32(img[20:], tc.cmdsz)
+
+		// Nothing past the header is read.
+		r := &countingReaderAt{r: testMachOBuf(img)}
+		if _, err := machoParseRaw(r); !errors.Is(err, errLoadCmdCount) {
+			t.Errorf("%s: machoParseRaw error %v, want %v", tc.name, err, errLoadCmdCount)
+		}
+		for _, rg := range r.ranges {
+			if rg[1] > machoHeaderSize64 {
+				t.Errorf("%s: machoParseRaw read %#x-%#x, past the header", tc.name, rg[0], rg[1])
+			}
+		}
+		r = &countingReaderAt{r: testMachOBuf(img)}
+		if _, err := machoReadLoadCmds(r, exem); !errors.Is(err, errLoadCmdCount) {
+			t.Errorf("%s: machoReadLoadCmds error %v, want %v", tc.name, err, errLoadCmdCount)
+		}
+		if err := machoUpdateUuid(testMachOBuf(img), exem, uuidFromGoBuildId("x")); !errors.Is(err, errLoadCmdCount) {
+			t.Errorf("%s: machoUpdateUuid error %v, want %v", tc.name, err, errLoadCmdCount)
+		}
+		if r.n != 0 {
+			t.Errorf("%s: %d bytes read before rejecting the header", tc.name, r.n)
+		}
+	}
+}
+
+func TestMachoLoadCmdErrorContext(t *testing.T) {
+	m := newTestMachO(testUuid)
+	img := m.bytes()
+	exem := parseTestMachO(t, img)
+	// Corrupt the cmdsize of __LINKEDIT, command 2.
+	off := machoHeaderSize64 + len(m.cmds[0]) + len(m.cmds[1])
+	m.order.PutUint32(img[off+4:], 4)
+
+	const want = "load command 2 (cmd=LC_SEGMENT_64): "
+	if err := machoUpdateUuid(testMachOBuf(img), exem, uuidFromGoBuildId("x")); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("machoUpdateUuid error %v, want it to start with %q", err, want)
+	}
+	if _, err := machoReadLoadCmds(testMachOBuf(img), exem); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("machoReadLoadCmds error %v, want it to start with %q", err, want)
+	}
+	if _, err := machoParseRaw(testMachOBuf(img)); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("machoParseRaw error %v, want it to start with %q", err, want)
+	}
+
+	// An overrun by a command the linker has no name for.
+	m.cmds = append(m.cmds[:3:3], m.raw(0x7777, make([]byte, 8)))
+	img = m.bytes()
+	exem = parseTestMachO(t, img)
+	exem.Cmdsz -= 8
+	err := machoUpdateUuid(testMachOBuf(img), exem, uuidFromGoBuildId("x"))
+	if want := "load command 3 (cmd=0x7777): "; err == nil || !strings.HasPrefix(err.Error(), want) || !errors.Is(err, errLoadCmdOverrun) {
+		t.Errorf("machoUpdateUuid error %v, want an overrun starting with %q", err, want)
+	}
+}
+
+func TestMachoUpdateUuidEncrypted(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		cryptoff, cryptsize uint32
+		ok                  bool
+	}{
+		{"text", 0x400, 0x100, true},
+		{"header", 0, 0x500, false},
+	} {
+		m := newTestMachO(testUuid)
+		info := make([]byte, 16) // cryptoff, cryptsize, cryptid, pad
+		m.order.PutUint32(info[0:], tc.cryptoff)
+		m.order.PutUint32(info[4:], tc.cryptsize)
+		m.order.PutUint32(info[8:], 1)
+		m.cmds = append(m.cmds, m.raw(LC_ENCRYPTION_INFO_64, info))
+		img := m.bytes()
+		orig := append([]byte(nil), img...)
+
+		rw, err := newMachoRewriter(testMachOBuf(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := uuidFromGoBuildId("x")
+		err = rw.UpdateUuid(want)
+		if tc.ok {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			} else if got := testReadUuid(t, parseTestMachO(t, img)); !bytes.Equal(got, want) {
+				t.Errorf("%s: got UUID %x, want %x", tc.name, got, want)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "encrypted range") {
+			t.Errorf("%s: got error %v, want one about the encrypted range", tc.name, err)
+		}
+		if !bytes.Equal(img, orig) {
+			t.Errorf("%s: rejected rewrite modified the file", tc.name)
+		}
+	}
+}
+
+func TestMachoParseUuid(t *testing.T) {
+	u, err := machoParseUuid("DEADBEEF-0102-0304-0506-0708090a0b0c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != testUuid {
+		t.Errorf("got %x, want %x", u, testUuid)
+	}
+	if s := machoUuidString(u); s != "DEADBEEF-0102-0304-0506-0708090A0B0C" {
+		t.Errorf("round trip gave %s", s)
+	}
+
+	for _, bad := range []string{
+		"",
+		"DEADBEEF01020304050607080910AABB",
+		"DEADBEEF-0102-0304-0506-0708090A0B0",
+		"DEADBEEF-0102-0304-0506-0708090A0B0C0",
+		"DEADBEEF-01020-304-0506-0708090A0B0C",
+		"DEADBEEF-0102-0304-0506-0708090A0B0G",
+		"{DEADBEEF-0102-0304-0506-0708090A0B}",
+	} {
+		if _, err := machoParseUuid(bad); err == nil {
+			t.Errorf("machoParseUuid(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestMachoParseUuidPolicy(t *testing.T) {
+	u, err := machoParseUuidPolicy("0xDEADBEEF0102030405060708090a0b0c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(u, testUuid[:]) {
+		t.Errorf("0x policy gave %x, want %x", u, testUuid)
+	}
+	if u, err := machoParseUuidPolicy("none"); err != nil || !bytes.Equal(u, make([]byte, 16)) {
+		t.Errorf("none policy gave %x, %v, want all zeros", u, err)
+	}
+
+	a, err := machoParseUuidPolicy("random")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := machoParseUuidPolicy("random")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Errorf("two random UUIDs are both %x", a)
+	}
+	for _, u := range [][]byte{a, b} {
+		if u[6]>>4 != 4 || u[8]>>6 != 2 {
+			t.Errorf("random UUID %x is not an RFC 4122 version 4 UUID", u)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"gobuildid", // handled by the caller
+		"Random",
+		"DEADBEEF0102030405060708090a0b0c",
+		"0xDEADBEEF0102030405060708090a0b",
+		"0xDEADBEEF0102030405060708090a0b0c0d",
+		"0xDEADBEEF0102030405060708090a0b0g",
+		"0XDEADBEEF0102030405060708090a0b0c",
+	} {
+		if _, err := machoParseUuidPolicy(bad); err == nil {
+			t.Errorf("machoParseUuidPolicy(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestMachoOutputUuidForced(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old []byte) { forcedUuid = old }(forcedUuid)
+	*flagBuildid = "test/buildid"
+
+	exem := parseTestMachO(t, newTestMachO(testUuid).bytes())
+	forcedUuid = nil
+	if got, err := machoOutputUuid(nil, exem); err != nil || !bytes.Equal(got, uuidFromGoBuildId(*flagBuildid)) {
+		t.Errorf("without -macho-uuid: got %x, %v, want %x", got, err, uuidFromGoBuildId(*flagBuildid))
+	}
+	forcedUuid = testUuid[:]
+	if got, err := machoOutputUuid(nil, exem); err != nil || !bytes.Equal(got, testUuid[:]) {
+		t.Errorf("with -macho-uuid: got %x, %v, want %x", got, err, testUuid)
+	}
+}
+
+func TestCheckMachoUuidFlags(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old string) { *flagForceUuid = old }(*flagForceUuid)
+	defer func(old string) { *flagMachoUuid = old }(*flagMachoUuid)
+	defer func(old string) { *flagHostBuildid = old }(*flagHostBuildid)
+	defer func(old string) { machoUuidPolicy = old }(machoUuidPolicy)
+	defer func(old []byte) { forcedUuid = old }(forcedUuid)
+	defer func(old []byte) { buildinfo = old }(buildinfo)
+	*flagBuildid = "test/buildid"
+	ctxt := &Link{Target: Target{HeadType: objabi.Hdarwin, Arch: sys.ArchARM64}}
+
+	// -forceuuid is the 0x policy of -macho-uuid.
+	for _, tc := range []struct{ force, policy string }{
+		{machoUuidString(testUuid), ""},
+		{"", "0x" + hex.EncodeToString(testUuid[:])},
+	} {
+		*flagForceUuid, *flagMachoUuid = tc.force, tc.policy
+		machoUuidPolicy, forcedUuid = "", nil
+		checkMachoUuidFlags(ctxt)
+		if want := "0x" + hex.EncodeToString(testUuid[:]); machoUuidPolicy != want || !bytes.Equal(forcedUuid, testUuid[:]) {
+			t.Errorf("-forceuuid=%q -macho-uuid=%q: policy %q, UUID %x, want %q, %x", tc.force, tc.policy, machoUuidPolicy, forcedUuid, want, testUuid)
+		}
+	}
+
+	// The gobuildid policy derives the UUID as -B gobuildid does.
+	*flagForceUuid, *flagMachoUuid = "", ""
+	*flagHostBuildid = "gobuildid"
+	machoUuidPolicy, forcedUuid, buildinfo = "", nil, nil
+	addbuildinfo(ctxt)
+	want := buildinfo
+	*flagHostBuildid, *flagMachoUuid = "", "gobuildid"
+	buildinfo = nil
+	checkMachoUuidFlags(ctxt)
+	if machoUuidPolicy != "gobuildid" || forcedUuid != nil {
+		t.Fatalf("-macho-uuid=gobuildid: policy %q, forced UUID %x", machoUuidPolicy, forcedUuid)
+	}
+	addbuildinfo(ctxt)
+	if len(want) != 16 || !bytes.Equal(buildinfo, want) {
+		t.Errorf("-macho-uuid=gobuildid: UUID %x, want %x, that of -B gobuildid", buildinfo, want)
+	}
+}
+
+// TestMachoUuidPolicyLink links for darwin/arm64 internally with
+// -forceuuid and with the -macho-uuid policy it stands for, and checks
+// that both give the LC_UUID and that the two cannot be combined.
+func TestMachoUuidPolicyLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for darwin")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.exe")
+	link := func(ldflags string) ([]byte, error) {
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal "+ldflags, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=0")
+		return cmd.CombinedOutput()
+	}
+	for _, ldflags := range []string{
+		"-forceuuid=" + machoUuidString(testUuid),
+		"-macho-uuid=0x" + hex.EncodeToString(testUuid[:]),
+	} {
+		if out, err := link(ldflags); err != nil {
+			t.Fatalf("%s: %v\n%s", ldflags, err, out)
+		}
+		f, err := macho.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u := testReadUuid(t, f); !bytes.Equal(u, testUuid[:]) {
+			t.Errorf("%s: LC_UUID is %x, want %x", ldflags, u, testUuid)
+		}
+		f.Close()
+	}
+
+	out, err := link("-forceuuid=" + machoUuidString(testUuid) + " -macho-uuid=none")
+	if err == nil || !strings.Contains(string(out), "give only one of them") {
+		t.Errorf("-forceuuid with -macho-uuid: %v\n%s", err, out)
+	}
+}
+
+func TestMachoUuidFrom(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old []byte) { forcedUuid = old }(forcedUuid)
+	dir := t.TempDir()
+
+	// The previous output got its UUID from an earlier build ID.
+	*flagBuildid = "old/buildid"
+	forcedUuid = nil
+	prev := filepath.Join(dir, "prev")
+	if err := os.WriteFile(prev, testRewriteUuid(t, newTestMachO(testUuid).bytes()), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relinking with a new build ID carries the old UUID over.
+	*flagBuildid = "new/buildid"
+	u, err := machoReadUuidFile(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forcedUuid = u
+	out := testRewriteUuid(t, newTestMachO([16]byte{1}).bytes())
+	if got, want := testReadUuid(t, parseTestMachO(t, out)), uuidFromGoBuildId("old/buildid"); !bytes.Equal(got, want) {
+		t.Errorf("relinked UUID = %x, want the previous output's %x", got, want)
+	}
+
+	arm := newTestMachO([16]byte{1})
+	arm.cpu = macho.CpuArm64
+	fat := filepath.Join(dir, "fat")
+	if err := os.WriteFile(fat, testFatMachO(12, newTestMachO(testUuid), arm), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := machoReadUuidFile(fat); err == nil {
+		t.Error("reading one UUID from slices with different UUIDs succeeded")
+	}
+	if _, err := machoReadUuidFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("reading the UUID of a missing file succeeded")
+	}
+}
+
+func TestMachoUuidFromConvert(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old []byte) { forcedUuid = old }(forcedUuid)
+	dir := t.TempDir()
+
+	// The module was an executable.
+	*flagBuildid = "old/buildid"
+	forcedUuid = nil
+	prev := filepath.Join(dir, "prev")
+	if err := os.WriteFile(prev, testRewriteUuid(t, newTestMachO(testUuid).bytes()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	typ, err := machoFileType(prev)
+	if err != nil || typ != macho.TypeExec {
+		t.Fatalf("file type of the executable: %v, %v", typ, err)
+	}
+
+	// It is relinked as a dylib, which only gets the executable's
+	// UUID with -uuidfromconvert.
+	out := machoBuildModeType(BuildModeCShared)
+	if err := machoCheckUuidFromType(typ, out, false); err == nil || !strings.Contains(err.Error(), "-uuidfromconvert") {
+		t.Errorf("carrying an executable's UUID to a dylib without -uuidfromconvert: %v, want an error suggesting it", err)
+	}
+	if err := machoCheckUuidFromType(typ, out, true); err != nil {
+		t.Fatal(err)
+	}
+	*flagBuildid = "new/buildid"
+	u, err := machoReadUuidFile(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forcedUuid = u
+	dylib := newTestMachO([16]byte{1})
+	dylib.filetype = macho.TypeDylib
+	img := testRewriteUuid(t, dylib.bytes())
+	exem := parseTestMachO(t, img)
+	if exem.Type != macho.TypeDylib {
+		t.Fatalf("rewritten file has type %v, want a dylib", exem.Type)
+	}
+	if got, want := testReadUuid(t, exem), uuidFromGoBuildId("old/buildid"); !bytes.Equal(got, want) {
+		t.Errorf("dylib UUID = %x, want the executable's %x", got, want)
+	}
+
+	for _, tc := range []struct {
+		from, to macho.Type
+		convert  bool
+		ok       bool
+	}{
+		{macho.TypeExec, macho.TypeExec, false, true},
+		{macho.TypeDylib, macho.TypeDylib, false, true},
+		{macho.TypeDylib, macho.TypeExec, false, false},
+		{macho.TypeDylib, macho.TypeExec, true, true},
+		{macho.TypeObj, macho.TypeExec, true, false},
+		{macho.TypeBundle, macho.TypeDylib, true, false},
+		{macho.TypeObj, 0, false, true}, // c-archive: nothing to set
+	} {
+		if err := machoCheckUuidFromType(tc.from, tc.to, tc.convert); (err == nil) != tc.ok {
+			t.Errorf("%v to %v, convert=%v: %v, want ok=%v", tc.from, tc.to, tc.convert, err, tc.ok)
+		}
+	}
+}
+
+func TestLoadCmdReaderOffsets(t *testing.T) {
+	m := newTestMachO(testUuid)
+	img := m.bytes()
+	exem := parseTestMachO(t, img)
+
+	r := loadCmdReader{next: machoHeaderSize64, f: testMachOBuf(img), order: exem.ByteOrder}
+	want := int64(machoHeaderSize64)
+	for i := range m.cmds {
+		cmd, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Offset() != want {
+			t.Errorf("command %d: Offset() = %#x, want %#x", i, r.Offset(), want)
+		}
+		if r.PayloadOffset() != want+8 {
+			t.Errorf("command %d: PayloadOffset() = %#x, want %#x", i, r.PayloadOffset(), want+8)
+		}
+		if got := exem.ByteOrder.Uint32(img[r.Offset():]); got != uint32(cmd.Cmd) {
+			t.Errorf("command %d: cmd at Offset() is %#x, want %#x", i, got, cmd.Cmd)
+		}
+		if cmd.Cmd == LC_UUID && !bytes.Equal(img[r.PayloadOffset():r.PayloadOffset()+16], testUuid[:]) {
+			t.Errorf("LC_UUID payload not at PayloadOffset()")
+		}
+		want += int64(len(m.cmds[i]))
+	}
+}
+
+// TestMachoHeaderSize32 runs a 32-bit executable, whose header is one
+// uint32 shorter than the 64-bit one, through the helpers that find the
+// load commands.
+func TestMachoHeaderSize32(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	if got := machoHeaderSize(parseTestMachO(t, newTestMachO(testUuid).bytes())); got != machoHeaderSize64 {
+		t.Errorf("64-bit header size %d, want %d", got, machoHeaderSize64)
+	}
+
+	m := &testMachO{order: binary.LittleEndian, is32: true, cpu: macho.Cpu386, filetype: macho.TypeExec, flags: MH_NOUNDEFS | MH_DYLDLINK, size: 0x600}
+	segs := [][]byte{
+		m.segment("__PAGEZERO", 0, 0x1000, 0, 0),
+		m.segment("__TEXT", 0x1000, 0x1000, 0, 0x500,
+			testSect{name: "__text", seg: "__TEXT", addr: 0x1400, size: 0x100, offset: 0x400, align: 4}),
+		m.segment("__LINKEDIT", 0x2000, 0x1000, 0x500, 0x100),
+	}
+	m.cmds = append(append([][]byte(nil), segs...), m.uuid(testUuid))
+	img := m.bytes()
+	exem := parseTestMachO(t, img)
+	const hdrSize = 7 * 4 // mach_header
+	if got := machoHeaderSize(exem); got != hdrSize {
+		t.Fatalf("32-bit header size %d, want %d", got, hdrSize)
+	}
+
+	// Reading.
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := int64(hdrSize)
+	for i, c := range cmds {
+		if c.Offset != off || !bytes.Equal(c.Data, m.cmds[i]) {
+			t.Errorf("command %d read at %#x, want %#x", i, c.Offset, off)
+		}
+		off += int64(len(m.cmds[i]))
+	}
+	raw, err := machoParseRaw(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range raw.Loads {
+		if !bytes.Equal(l.Raw(), m.cmds[i]) {
+			t.Errorf("raw parse: command %d differs", i)
+		}
+	}
+	if slack, err := machoHeaderSlack(exem); err != nil || slack != 0x400-off {
+		t.Errorf("header slack %#x, %v; want %#x", slack, err, 0x400-off)
+	}
+
+	// Indexing the UUID payload.
+	want := uuidFromGoBuildId(*flagBuildid)
+	patches, err := machoUuidManifest(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuidOff := off - int64(len(m.cmds[3])) + 8
+	if len(patches) != 1 || patches[0].Offset != uuidOff || !bytes.Equal(patches[0].New, want) {
+		t.Errorf("manifest %+v, want one patch of %x at %#x", patches, want, uuidOff)
+	}
+
+	// Rewriting.
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.UpdateUuid(want); err != nil {
+		t.Fatal(err)
+	}
+	uuids, err := machoReadUuids(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uuids) != 1 || uuids[0].Cpu != macho.Cpu386 || !bytes.Equal(uuids[0].Uuid[:], want) {
+		t.Errorf("UUIDs after rewrite %v, want %x", uuids, want)
+	}
+
+	// Reordering puts the segments back ahead of LC_UUID.
+	m.cmds = append([][]byte{m.uuid([16]byte(want))}, segs...)
+	moved := m.bytes()
+	rw, err = newMachoRewriter(testMachOBuf(moved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := machoPlanLoadCmdOrder(rw)
+	if err == nil {
+		err = rw.Apply(e)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(moved[:off], img[:off]) {
+		t.Error("reordered 32-bit load commands are not in canonical order")
+	}
+}
+
+func TestLoadCmdReaderBounds(t *testing.T) {
+	m := newTestMachO(testUuid)
+	img := m.bytes()
+	orig := append([]byte(nil), img...)
+	exem := parseTestMachO(t, img)
+
+	r := loadCmdReader{next: machoHeaderSize64, f: testMachOBuf(img), order: exem.ByteOrder}
+	var u uuidCmd
+	if err := r.ReadAt(0, &u); err == nil {
+		t.Error("ReadAt before Next succeeded")
+	}
+	for {
+		cmd, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd.Cmd == LC_UUID {
+			break
+		}
+	}
+	if err := r.ReadAt(0, &u); err != nil || u.Uuid != testUuid {
+		t.Errorf("ReadAt(0) = %x, %v, want %x", u.Uuid, err, testUuid)
+	}
+	var payload [16]byte
+	if err := r.ReadAt(8, &payload); err != nil || payload != testUuid {
+		t.Errorf("ReadAt(8) = %x, %v, want %x", payload, err, testUuid)
+	}
+	if err := r.WriteAt(8, &payload); err != nil {
+		t.Errorf("WriteAt(8): %v", err)
+	}
+	for _, off := range []int64{-1, 9, 24} {
+		if err := r.ReadAt(off, &payload); err == nil {
+			t.Errorf("ReadAt(%d) of 16 bytes in a 24-byte command succeeded", off)
+		}
+		if err := r.WriteAt(off, [16]byte{0xff}); err == nil {
+			t.Errorf("WriteAt(%d) of 16 bytes in a 24-byte command succeeded", off)
+		}
+	}
+	if !bytes.Equal(img, orig) {
+		t.Error("out-of-bounds writes modified the file")
+	}
+}
+
+func TestMachoRewriterReparse(t *testing.T) {
+	img := newTestMachO(testUuid).bytes()
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := rw.File()
+	if u, ok := rw.Uuid(); !ok || u != testUuid {
+		t.Fatalf("initial UUID = %x, %v; want %x", u, ok, testUuid)
+	}
+
+	var want [16]byte
+	copy(want[:], uuidFromGoBuildId("test/buildid"))
+	if err := rw.UpdateUuid(want[:]); err != nil {
+		t.Fatal(err)
+	}
+	// Verification through the same rewriter must see the new value.
+	if u, ok := rw.Uuid(); !ok || u != want {
+		t.Errorf("UUID after rewrite = %x, %v; want %x", u, ok, want)
+	}
+	if rw.File() == before {
+		t.Errorf("File() still returns the file parsed before the rewrite")
+	}
+}
+
+func TestMachoParseFileFallback(t *testing.T) {
+	// debug/macho rejects an LC_RPATH whose path lies outside the
+	// command, though the command is otherwise well formed.
+	m := newTestMachO(testUuid)
+	m.cmds = append(m.cmds, m.raw(LC_RPATH, []byte{0xff, 0, 0, 0}))
+	img := m.bytes()
+	if _, err := macho.NewFile(testMachOBuf(img)); err == nil {
+		t.Fatal("debug/macho accepts the test file")
+	}
+
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(rw.File().Loads); got != len(m.cmds) {
+		t.Errorf("fallback parse found %d load commands, want %d", got, len(m.cmds))
+	}
+	if u, ok := rw.Uuid(); !ok || u != testUuid {
+		t.Fatalf("UUID = %x, %v; want %x", u, ok, testUuid)
+	}
+	want := uuidFromGoBuildId("test/buildid")
+	if err := rw.UpdateUuid(want); err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := rw.Uuid(); !ok || !bytes.Equal(u[:], want) {
+		t.Errorf("UUID after rewrite = %x, %v; want %x", u, ok, want)
+	}
+	m.cmds[3] = m.uuid([16]byte(want))
+	if !bytes.Equal(img, m.bytes()) {
+		t.Error("rewrite changed more than the UUID")
+	}
+
+	// A file whose header or load commands do not hold together is
+	// still rejected.
+	for _, bad := range [][]byte{
+		[]byte("not a Mach-O file, just some text"),
+		img[:32+8], // 64-bit header and part of the first load command
+	} {
+		if _, err := machoParseFile(testMachOBuf(bad)); err == nil {
+			t.Errorf("parsing %q succeeded", bad[:8])
+		}
+	}
+}
+
+func TestUuidFromCode(t *testing.T) {
+	textOnly, err := machoParseUuidFromCode("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	textData, err := machoParseUuidFromCode("text,data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"", "bss", "text,text", "text,"} {
+		if _, err := machoParseUuidFromCode(bad); err == nil {
+			t.Errorf("machoParseUuidFromCode(%q) succeeded, want error", bad)
+		}
+	}
+
+	newImage := func() *testMachO {
+		m := newTestMachO(testUuid)
+		m.size = 0x700
+		m.cmds[2] = m.segment("__DATA", 0x100001000, 0x1000, 0x500, 0x100,
+			testSect{name: "__data", seg: "__DATA", addr: 0x100001000, size: 0x100, offset: 0x500})
+		m.cmds = append(m.cmds, m.segment("__LINKEDIT", 0x100002000, 0x1000, 0x600, 0x100))
+		return m
+	}
+	uuidOf := func(img []byte, sects [][2]string) []byte {
+		t.Helper()
+		u, err := uuidFromCode(parseTestMachO(t, img), sects)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	base := newImage().bytes()
+	u := uuidOf(base, textOnly)
+	if v := u[6] >> 4; v != 3 {
+		t.Errorf("UUID version = %d, want 3", v)
+	}
+	if got := uuidOf(newImage().bytes(), textOnly); !bytes.Equal(got, u) {
+		t.Errorf("identical code gave different UUIDs %x and %x", u, got)
+	}
+
+	// A different UUID payload or a different __LINKEDIT does not
+	// matter; a different __data only matters if it is hashed.
+	other := newImage()
+	other.cmds[3] = other.uuid([16]byte{1, 2, 3})
+	img := other.bytes()
+	img[0x650] ^= 0xff
+	img[0x550] ^= 0xff
+	if got := uuidOf(img, textOnly); !bytes.Equal(got, u) {
+		t.Errorf("change outside __text changed the UUID from %x to %x", u, got)
+	}
+	if bytes.Equal(uuidOf(img, textData), uuidOf(base, textData)) {
+		t.Errorf("change in __data did not change the text,data UUID")
+	}
+
+	img = newImage().bytes()
+	img[0x450] ^= 0xff
+	if got := uuidOf(img, textOnly); bytes.Equal(got, u) {
+		t.Errorf("change in __text did not change the UUID")
+	}
+
+	noData := newTestMachO(testUuid).bytes()
+	if _, err := uuidFromCode(parseTestMachO(t, noData), textData); err == nil {
+		t.Errorf("missing __DATA,__data section: got no error")
+	}
+}
+
+// BenchmarkUuidFromCode hashes sparse files with ever larger __text
+// sections. Memory use per operation should stay the same.
+func BenchmarkUuidFromCode(b *testing.B) {
+	sects, err := machoParseUuidFromCode("text")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []uint64{1 << 20, 16 << 20, 128 << 20} {
+		m := newTestMachO(testUuid)
+		m.cmds[1] = m.segment("__TEXT", 0x100000000, 0x400+size, 0, 0x400+size,
+			testSect{name: "__text", seg: "__TEXT", addr: 0x100000400, size: size, offset: 0x400, align: 4})
+		m.cmds[2] = m.segment("__LINKEDIT", 0x100000400+size, 0x1000, 0x400+size, 0)
+		m.size = 0x400
+		path := filepath.Join(b.TempDir(), "exe")
+		if err := os.WriteFile(path, m.bytes(), 0644); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.Truncate(path, int64(0x400+size)); err != nil {
+			b.Fatal(err)
+		}
+		exem, err := macho.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				if _, err := uuidFromCode(exem, sects); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		exem.Close()
+	}
+}
+
+func TestUuidLikeLd64(t *testing.T) {
+	img := newTestMachO(testUuid).signed()
+	copy(img[0x400:], "some code")
+	exem := parseTestMachO(t, img)
+	got, err := uuidLikeLd64(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SHA-256 of everything before the code signature at 0x600, with
+	// the LC_UUID payload zeroed.
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := append([]byte(nil), img[:0x600]...)
+	clear(hashed[cmds[3].Offset+8:][:16])
+	sum := sha256.Sum256(hashed)
+	if want := uuidFromHash(sum[:]); !bytes.Equal(got, want) {
+		t.Errorf("uuidLikeLd64 = %x, want %x", got, want)
+	}
+	if want := "dd1d5c22388e3852cf5159ca8306a66d"; fmt.Sprintf("%x", got) != want {
+		t.Errorf("uuidLikeLd64 = %x, want %s", got, want)
+	}
+
+	// The UUID itself and the code signature are not part of the hash;
+	// the code is.
+	for _, tc := range []struct {
+		off  int64
+		same bool
+	}{
+		{cmds[3].Offset + 8, true},
+		{0x650, true},
+		{0x404, false},
+	} {
+		changed := append([]byte(nil), img...)
+		changed[tc.off] ^= 0xff
+		u, err := uuidLikeLd64(testMachOBuf(changed), exem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(u, got) != tc.same {
+			t.Errorf("changing the byte at %#x: UUID %x, was %x", tc.off, u, got)
+		}
+	}
+}
+
+func TestMachoPassesUuidLd64(t *testing.T) {
+	defer func(old bool) { *flagUuidLd64 = old }(*flagUuidLd64)
+	defer func(old bool) { *flagReproducible = old }(*flagReproducible)
+	*flagUuidLd64 = true
+	*flagReproducible = true
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	passes, err := machoPasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := passes[len(passes)-1].name; last != "uuid" {
+		t.Fatalf("last pass is %s, want uuid", last)
+	}
+
+	// The UUID covers the changes of the other passes, so a second run
+	// finds nothing to do.
+	_, img := testReproMachO()
+	rw, err := newMachoRewriter(testMachOBuf(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machoCanonicalize(&Link{}, rw, passes, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range passes {
+		if e, err := p.plan(rw); err != nil || !e.Empty() {
+			t.Errorf("%s pass after canonicalization: %v, %v", p.name, e, err)
+		}
+	}
+}
+
+func TestMachoShouldRewriteUuid(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old bool) { *flagReproducible = old }(*flagReproducible)
+	*flagBuildid = "test/buildid"
+	*flagReproducible = true
+
+	var log bytes.Buffer
+	ctxt := &Link{
+		Target:    Target{HeadType: objabi.Hdarwin, LinkMode: LinkInternal},
+		Bso:       bufio.NewWriter(&log),
+		Debugvlog: 1,
+	}
+	if machoShouldRewriteUuid(ctxt) {
+		t.Errorf("machoShouldRewriteUuid = true for an internal link")
+	}
+	// The file does not exist; a no-op must not notice.
+	if err := machoCanonicalizeFile(ctxt, filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("machoCanonicalizeFile on an internal link: %v", err)
+	}
+	if !strings.Contains(log.String(), "not linking externally") {
+		t.Errorf("no debug log for skipped rewrite; log:\n%s", log.String())
+	}
+
+	ctxt.LinkMode = LinkExternal
+	if !machoShouldRewriteUuid(ctxt) {
+		t.Errorf("machoShouldRewriteUuid = false for an external link")
+	}
+	ctxt.HeadType = objabi.Hlinux
+	if machoShouldRewriteUuid(ctxt) {
+		t.Errorf("machoShouldRewriteUuid = true for linux")
+	}
+}
+
+func TestMachoVerifyUuid(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "abcdefghijklmnopqrst/uvwxyzABCDEFGHIJKL"
+
+	// Embed the build ID at the start of __text, as the linker does.
+	img := newTestMachO(testUuid).bytes()
+	copy(img[0x400:], fmt.Sprintf("\xff Go build ID: %q\n \xff", *flagBuildid))
+
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "linked")
+	if err := os.WriteFile(exe, img, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoVerifyUuid(exe); err == nil {
+		t.Errorf("machoVerifyUuid succeeded before the UUID rewrite")
+	}
+
+	img = testRewriteUuid(t, img)
+	if err := os.WriteFile(exe, img, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoVerifyUuid(exe); err != nil {
+		t.Errorf("freshly linked binary: %v", err)
+	}
+
+	exem := parseTestMachO(t, img)
+	cmds, err := machoReadLoadCmds(testMachOBuf(img), exem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img[cmds[3].Offset+8] ^= 1
+	if err := os.WriteFile(exe, img, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoVerifyUuid(exe); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("tampered binary: got error %v, want mismatch", err)
+	}
+
+	// A post-processing step that replaces the build ID but not the
+	// UUID desyncs them just the same.
+	img[cmds[3].Offset+8] ^= 1
+	copy(img[0x400:], fmt.Sprintf("\xff Go build ID: %q\n \xff", "ABCDEFGHIJKLMNOPQRST/uvwxyzABCDEFGHIJKL"))
+	if err := os.WriteFile(exe, img, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoVerifyUuid(exe); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("binary with a replaced build ID: got error %v, want mismatch", err)
+	}
+
+	if err := os.WriteFile(exe, newTestMachO(testUuid).bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := machoReadGoBuildID(exe); err == nil || !strings.Contains(err.Error(), "no Go build ID") {
+		t.Errorf("binary without a build ID: got error %v", err)
+	}
+}
+
+// TestMachoTrimpathReproducible checks that -trimpath and a fixed build
+// ID are all it takes for two external links of the same program, from
+// different directories, to be byte for byte identical, LC_UUID and
+// code signature included.
+func TestMachoTrimpathReproducible(t *testing.T) {
+	if runtime.GOOS != "darwin" {
+		t.Skip("skipping; test only interesting on darwin")
+	}
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t) // for the external linker
+	t.Parallel()
+
+	const src = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+`
+	const buildID = "trimpath/reproducible"
+	var outs [][]byte
+	for i := 0; i < 2; i++ {
+		dir := filepath.Join(t.TempDir(), fmt.Sprintf("src%d", i))
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		exe := filepath.Join(dir, "a.out")
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-trimpath", "-ldflags=-linkmode=external -buildid="+buildID, "-o", exe, "main.go")
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("build %d: %v\n%s", i, err, out)
+		}
+		if err := machoVerifyUuid(exe); err != nil {
+			t.Errorf("build %d: %v", i, err)
+		}
+		b, err := os.ReadFile(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outs = append(outs, b)
+	}
+	if len(outs[0]) != len(outs[1]) {
+		t.Fatalf("builds differ in size: %d and %d bytes", len(outs[0]), len(outs[1]))
+	}
+	for i := range outs[0] {
+		if outs[0][i] != outs[1][i] {
+			t.Fatalf("builds first differ at offset %#x", i)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -uuidexplain, which prints how the LC_UUID of the
+// output was derived (see machoOutputUuid), for comparing the UUIDs of
+// two builds.
+
+import (
+	"bytes"
+	"cmd/internal/notsha256"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// machoExplainUuid writes to w, step by step, how machoOutputUuid
+// derives the LC_UUID of the Mach-O file f, described by exem, and
+// returns the UUID.
+func machoExplainUuid(w io.Writer, f io.ReaderAt, exem *macho.File) ([]byte, error) {
+	uuid, err := machoOutputUuid(f, exem)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case forcedUuid != nil && *flagUuidStore != "":
+		fmt.Fprintf(w, "UUID: recorded for build ID %q in %s (-uuidstore)\n", *flagBuildid, *flagUuidStore)
+	case forcedUuid != nil && *flagUuidFrom != "":
+		fmt.Fprintf(w, "UUID: copied by -uuidfrom from the LC_UUID of %s\n", *flagUuidFrom)
+	case forcedUuid != nil:
+		fmt.Fprintf(w, "UUID: set by -macho-uuid=%s\n", machoUuidPolicy)
+	case uuidCodeSections != nil:
+		var names []string
+		for _, s := range uuidCodeSections {
+			names = append(names, s[0]+","+s[1])
+		}
+		fmt.Fprintf(w, "input: the contents of %s (-uuidfromcode)\n", strings.Join(names, " and "))
+		fmt.Fprintf(w, "hash: notsha256 (SHA-256 with every bit inverted) of each section's name, size and contents\n")
+		fmt.Fprintf(w, "truncation: the first 16 bytes of the hash\n")
+		fmt.Fprintf(w, "adjustment: version 3 in the high nibble of byte 6, the top two bits of byte 8 set\n")
+	case *flagUuidLd64:
+		fmt.Fprintf(w, "input: the output file up to its code signature, with the LC_UUID payload zeroed (-uuidld64)\n")
+		fmt.Fprintf(w, "hash: SHA-256\n")
+		fmt.Fprintf(w, "truncation: the first 16 bytes of the hash\n")
+		fmt.Fprintf(w, "adjustment: version 3 in the high nibble of byte 6, the top two bits of byte 8 set\n")
+	default:
+		if err := machoExplainBuildIdUuid(w, *flagBuildid, exem.Cpu, uuid); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintf(w, "UUID: %s\n", machoUuidString([16]byte(uuid)))
+	return uuid, nil
+}
+
+// machoExplainBuildIdUuid writes to w how machoBuildIdUuid derives a
+// UUID from buildID for cpu, with the intermediate values, and checks
+// that the result is uuid.
+func machoExplainBuildIdUuid(w io.Writer, buildID string, cpu macho.Cpu, uuid []byte) error {
+	fmt.Fprintf(w, "build ID: %q\n", buildID)
+	if buildID == "" {
+		fmt.Fprintf(w, "no build ID: the UUID is all zeros\n")
+		return nil
+	}
+	input := buildID
+	if *flagUuidContent {
+		input = buildIDContent(buildID)
+		fmt.Fprintf(w, "selection: the content ID alone (-uuidcontent), giving %q\n", input)
+	}
+	if uuidPgoHash != "" {
+		input += "\x00pgo" + uuidPgoHash
+		fmt.Fprintf(w, "salt: the hash of the PGO profile %s (-uuidpgo), giving %q\n", *flagUuidPgo, input)
+	}
+	if uuidImageDigest != "" {
+		input += "\x00image" + uuidImageDigest
+		fmt.Fprintf(w, "salt: the toolchain image digest (-uuidimage), giving %q\n", input)
+	}
+	if *flagUuidPerCpu {
+		input = fmt.Sprintf("%s\x00cpu%d", input, uint32(cpu))
+		fmt.Fprintf(w, "salt: the cpu type of %s (-uuidpercpu), giving %q\n", machoArchName(cpu), input)
+	}
+	sum := notsha256.Sum256([]byte(input))
+	fmt.Fprintf(w, "hash: notsha256 (SHA-256 with every bit inverted): %x\n", sum)
+	rv := append([]byte(nil), sum[:16]...)
+	fmt.Fprintf(w, "truncation: the first 16 bytes: %x\n", rv)
+	version := byte(uuidVersion)
+	if version == 4 {
+		seed := int64(binary.LittleEndian.Uint64(rv))
+		var r [16]byte
+		rand.New(rand.NewSource(seed)).Read(r[:])
+		for i := range rv {
+			rv[i] ^= r[i]
+		}
+		fmt.Fprintf(w, "mixing: XOR with 16 bytes of math/rand output seeded with %d: %x\n", seed, rv)
+	}
+	// Version 3 keeps the variant bits uuidFromHash has always set.
+	variant, desc := byte(0x80), "RFC 4122 variant in the top bits"
+	if version != 4 && version != 5 {
+		variant, desc = 0xc0, "the top two bits set"
+	}
+	b6, b8 := rv[6], rv[8]
+	rv[6] = rv[6]&0x0f | version<<4
+	rv[8] = rv[8]&0x3f | variant
+	fmt.Fprintf(w, "adjustment: version %d in the high nibble of byte 6 (%#02x -> %#02x), %s of byte 8 (%#02x -> %#02x)\n",
+		version, b6, rv[6], desc, b8, rv[8])
+	if !bytes.Equal(rv, uuid) {
+		return fmt.Errorf("explained UUID %x differs from the derived one %x", rv, uuid)
+	}
+	return nil
+}
+
+// machoWriteUuidExplanation writes the -uuidexplain explanation of the
+// LC_UUID of the Mach-O file exe to w. It notes when the LC_UUID
+// recorded in exe is not the derived one, as when -repropasses or
+// -uuidmanifest leave it to a later step.
+func machoWriteUuidExplanation(w io.Writer, exe string) error {
+	f, err := os.Open(exe)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	exem, err := machoParseFile(f)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	uuid, err := machoExplainUuid(&buf, f, exem)
+	if err != nil {
+		return err
+	}
+	if got, ok := machoFileUuid(exem); !ok {
+		fmt.Fprintf(&buf, "note: %s has no LC_UUID load command\n", exe)
+	} else if !bytes.Equal(got[:], uuid) {
+		fmt.Fprintf(&buf, "note: the LC_UUID of %s is %s, not the derived UUID\n", exe, machoUuidString(got))
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
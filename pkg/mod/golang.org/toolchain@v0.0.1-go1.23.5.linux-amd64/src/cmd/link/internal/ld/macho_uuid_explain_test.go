@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMachoExplainUuid(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old int) { uuidVersion = old }(uuidVersion)
+	defer func(old bool) { *flagUuidPerCpu = old }(*flagUuidPerCpu)
+	defer func(old bool) { *flagUuidContent = old }(*flagUuidContent)
+	defer func(old []byte) { forcedUuid = old }(forcedUuid)
+	defer func(old string) { machoUuidPolicy = old }(machoUuidPolicy)
+	*flagBuildid = "abcdefghijklmnopqrst/uvwxyzABCDEFGHIJKL"
+
+	img := newTestMachO(testUuid).bytes()
+	exem := parseTestMachO(t, img)
+	for _, tc := range []struct {
+		name    string
+		version int
+		perCpu  bool
+		content bool
+		forced  []byte
+		policy  string
+		want    []string
+	}{
+		{name: "default", version: 3, want: []string{`build ID: "abcdefghijklmnopqrst/uvwxyzABCDEFGHIJKL"`, "hash: notsha256", "truncation", "version 3"}},
+		{name: "version4", version: 4, want: []string{"build ID", "mixing: XOR", "version 4"}},
+		{name: "version5", version: 5, want: []string{"build ID", "version 5"}},
+		{name: "percpu", version: 3, perCpu: true, want: []string{"build ID", "salt: the cpu type of x86_64"}},
+		{name: "content", version: 3, content: true, want: []string{"build ID", `selection: the content ID alone (-uuidcontent), giving "uvwxyzABCDEFGHIJKL"`}},
+		{name: "forced", version: 3, forced: testUuid[:], policy: "0x" + hex.EncodeToString(testUuid[:]), want: []string{"set by -macho-uuid=0x" + hex.EncodeToString(testUuid[:])}},
+		{name: "policy", version: 3, forced: make([]byte, 16), policy: "none", want: []string{"set by -macho-uuid=none"}},
+	} {
+		uuidVersion = tc.version
+		*flagUuidPerCpu = tc.perCpu
+		*flagUuidContent = tc.content
+		forcedUuid = tc.forced
+		machoUuidPolicy = tc.policy
+		var buf bytes.Buffer
+		uuid, err := machoExplainUuid(&buf, testMachOBuf(img), exem)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		want, _ := machoOutputUuid(testMachOBuf(img), exem)
+		if !bytes.Equal(uuid, want) {
+			t.Errorf("%s: explained UUID %x, want %x", tc.name, uuid, want)
+		}
+		out := buf.String()
+		for _, s := range append(tc.want, "UUID: "+machoUuidString([16]byte(want))) {
+			if !strings.Contains(out, s) {
+				t.Errorf("%s: explanation does not mention %q:\n%s", tc.name, s, out)
+			}
+		}
+		if !strings.HasSuffix(out, "UUID: "+machoUuidString([16]byte(want))+"\n") {
+			t.Errorf("%s: explanation does not end with the UUID:\n%s", tc.name, out)
+		}
+	}
+}
+
+func TestMachoWriteUuidExplanation(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	*flagBuildid = "test/buildid"
+
+	exe := filepath.Join(t.TempDir(), "exe")
+	img := newTestMachO(testUuid).bytes()
+	for _, tc := range []struct {
+		img      []byte
+		mismatch bool
+	}{
+		{testRewriteUuid(t, img), false},
+		{img, true}, // the external linker's UUID, left in place
+	} {
+		if err := os.WriteFile(exe, tc.img, 0755); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := machoWriteUuidExplanation(&buf, exe); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(buf.String(), "not the derived UUID"); got != tc.mismatch {
+			t.Errorf("explanation notes a mismatch: %v, want %v:\n%s", got, tc.mismatch, buf.String())
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -uuidinfo, which writes a JSON record of the
+// inputs the LC_UUID of the output was derived from, and the UUIDs
+// that resulted, to a file next to the output. Unlike -uuidexplain,
+// which is for people, and -reproreport, which covers a whole build,
+// the record is meant to be kept with the binary, so that provenance
+// tools can check its UUIDs against the inputs it claims.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// machoUuidInfoSuffix is appended to the output path to name the file
+// -uuidinfo writes.
+const machoUuidInfoSuffix = ".uuidinfo"
+
+// machoUuidInfo is the record -uuidinfo writes.
+type machoUuidInfo struct {
+	Path      string `json:"path"`
+	BuildID   string `json:"buildid"`
+	Algorithm string `json:"algorithm"`
+	// Version is the RFC 4122 version of UUIDs derived from the build
+	// ID, and 0 for the other algorithms.
+	Version int `json:"version,omitempty"`
+	// Content is whether only the content ID of the build ID was
+	// hashed, with -uuidcontent.
+	Content bool `json:"content,omitempty"`
+	// PgoHash is the -uuidpgo profile hash the build ID was salted
+	// with, if any.
+	PgoHash string `json:"pgohash,omitempty"`
+	// ImageDigest is the -uuidimage toolchain image digest the build
+	// ID was salted with, if any.
+	ImageDigest string              `json:"imagedigest,omitempty"`
+	PerCpu      bool                `json:"percpu,omitempty"`
+	Arches      []machoArchUuidInfo `json:"arches"`
+}
+
+// machoArchUuidInfo is the part of a machoUuidInfo for one
+// architecture slice.
+type machoArchUuidInfo struct {
+	Cpu string `json:"cpu"`
+	// Input is the string hashed for the UUID, the build ID with any
+	// salts added, for UUIDs derived from the build ID.
+	Input string `json:"input,omitempty"`
+	// Seed is the math/rand seed of a version 4 UUID.
+	Seed *int64 `json:"seed,omitempty"`
+	Uuid string `json:"uuid"`
+}
+
+// machoUuidAlgorithm returns the name -uuidinfo records for the way
+// machoOutputUuid derives the LC_UUID.
+func machoUuidAlgorithm() string {
+	switch {
+	case forcedUuid != nil && *flagUuidStore != "":
+		return "uuidstore"
+	case forcedUuid != nil && *flagUuidFrom != "":
+		return "uuidfrom"
+	case forcedUuid != nil:
+		return "macho-uuid"
+	case uuidCodeSections != nil:
+		return "uuidfromcode"
+	case *flagUuidLd64:
+		return "uuidld64"
+	}
+	return "buildid"
+}
+
+// machoUuidInfoFor returns the -uuidinfo record for the Mach-O file
+// exe, thin or fat. It is an error for the LC_UUID of a slice not to be
+// the one its derivation gives, as when -uuidmanifest leaves the
+// rewrite to a later step: the record would not describe the binary.
+func machoUuidInfoFor(exe string) (*machoUuidInfo, error) {
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	slices, err := machoFatSlices(f)
+	if err != nil {
+		return nil, err
+	}
+	info := &machoUuidInfo{
+		Path:      exe,
+		BuildID:   *flagBuildid,
+		Algorithm: machoUuidAlgorithm(),
+	}
+	if info.Algorithm == "buildid" {
+		info.Version = uuidVersion
+		info.Content = *flagUuidContent
+		info.PgoHash = uuidPgoHash
+		info.ImageDigest = uuidImageDigest
+		info.PerCpu = *flagUuidPerCpu
+	}
+	for _, s := range slices {
+		exem, err := machoParseFile(s)
+		if err != nil {
+			return nil, err
+		}
+		got, ok := machoFileUuid(exem)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s slice has no LC_UUID load command", exe, machoArchName(exem.Cpu))
+		}
+		uuid, err := machoOutputUuid(s, exem)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(got[:], uuid) {
+			return nil, fmt.Errorf("%s: LC_UUID of the %s slice is %s, not the derived UUID %s", exe, machoArchName(exem.Cpu), machoUuidString(got), machoUuidString([16]byte(uuid)))
+		}
+		a := machoArchUuidInfo{Cpu: machoArchName(exem.Cpu), Uuid: machoUuidString(got)}
+		if info.Algorithm == "buildid" && *flagBuildid != "" {
+			// As in machoBuildIdUuid.
+			a.Input = machoSaltBuildId(*flagBuildid)
+			if *flagUuidPerCpu {
+				a.Input = fmt.Sprintf("%s\x00cpu%d", a.Input, uint32(exem.Cpu))
+			}
+			if uuidVersion == 4 {
+				seed := int64(binary.LittleEndian.Uint64(deriveDeterministicID(a.Input, 16)))
+				a.Seed = &seed
+			}
+		}
+		info.Arches = append(info.Arches, a)
+	}
+	return info, nil
+}
+
+// machoWriteUuidInfo writes the -uuidinfo record for the Mach-O file
+// exe to exe+machoUuidInfoSuffix.
+func machoWriteUuidInfo(exe string) error {
+	info, err := machoUuidInfoFor(exe)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(exe+machoUuidInfoSuffix, append(data, '\n'), 0666)
+}
+
+// machoCheckUuidInfo reports whether the UUIDs in the -uuidinfo record
+// info follow from the inputs it records, by deriving them again.
+func machoCheckUuidInfo(info *machoUuidInfo) error {
+	if info.Algorithm != "buildid" {
+		return fmt.Errorf("cannot rederive a UUID from algorithm %q", info.Algorithm)
+	}
+	for _, a := range info.Arches {
+		want := machoUuidString([16]byte(uuidFromGoBuildIdVersion(a.Input, info.Version)))
+		if a.Uuid != want {
+			return fmt.Errorf("%s: UUID %s, but input %q gives %s", a.Cpu, a.Uuid, a.Input, want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMachoUuidInfo(t *testing.T) {
+	defer func(old string) { *flagBuildid = old }(*flagBuildid)
+	defer func(old int) { uuidVersion = old }(uuidVersion)
+	defer func(old bool) { *flagUuidPerCpu = old }(*flagUuidPerCpu)
+	defer func(old string) { uuidPgoHash = old }(uuidPgoHash)
+	*flagBuildid = "abcdefghijklmnopqrst/uvwxyzABCDEFGHIJKL"
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name    string
+		version int
+		perCpu  bool
+		pgoHash string
+	}{
+		{name: "default", version: 3},
+		{name: "version4", version: 4},
+		{name: "percpu", version: 5, perCpu: true},
+		{name: "pgo", version: 3, pgoHash: "0123abcd"},
+	} {
+		uuidVersion = tc.version
+		*flagUuidPerCpu = tc.perCpu
+		uuidPgoHash = tc.pgoHash
+
+		amd := newTestMachO(testUuid)
+		arm := newTestMachO(testUuid)
+		arm.cpu, arm.subcpu = macho.CpuArm64, 0
+		f := testMachOBuf(testFatMachO(12, amd, arm))
+		err := machoRewriteFatUuids(f, func(r io.ReaderAt, exem *macho.File) ([]byte, error) {
+			return machoOutputUuid(r, exem)
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exe := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(exe, f, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := machoWriteUuidInfo(exe); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		data, err := os.ReadFile(exe + ".uuidinfo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var info machoUuidInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			t.Fatalf("%s: %v\n%s", tc.name, err, data)
+		}
+
+		if info.Path != exe || info.BuildID != *flagBuildid || info.Algorithm != "buildid" ||
+			info.Version != tc.version || info.PerCpu != tc.perCpu || info.PgoHash != tc.pgoHash {
+			t.Errorf("%s: record %+v does not match the derivation", tc.name, info)
+		}
+		uuids, err := machoReadUuids(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info.Arches) != len(uuids) {
+			t.Fatalf("%s: %d arches recorded, want %d", tc.name, len(info.Arches), len(uuids))
+		}
+		for i, u := range uuids {
+			a := info.Arches[i]
+			if a.Cpu != machoArchName(u.Cpu) || a.Uuid != machoUuidString(u.Uuid) {
+				t.Errorf("%s: arch %d recorded as %s %s, want %s %s", tc.name, i, a.Cpu, a.Uuid, machoArchName(u.Cpu), machoUuidString(u.Uuid))
+			}
+			if want := machoUuidString([16]byte(machoBuildIdUuid(*flagBuildid, u.Cpu))); a.Uuid != want {
+				t.Errorf("%s: %s UUID %s, want %s", tc.name, a.Cpu, a.Uuid, want)
+			}
+			if want := uuidFromGoBuildIdVersion(a.Input, tc.version); machoUuidString([16]byte(want)) != a.Uuid {
+				t.Errorf("%s: %s input %q does not give UUID %s", tc.name, a.Cpu, a.Input, a.Uuid)
+			}
+			if tc.version == 4 {
+				want := int64(binary.LittleEndian.Uint64(deriveDeterministicID(a.Input, 16)))
+				if a.Seed == nil || *a.Seed != want {
+					t.Errorf("%s: %s seed %v, want %d", tc.name, a.Cpu, a.Seed, want)
+				}
+			} else if a.Seed != nil {
+				t.Errorf("%s: %s has a seed for a version %d UUID", tc.name, a.Cpu, tc.version)
+			}
+		}
+		if tc.perCpu && info.Arches[0].Uuid == info.Arches[1].Uuid {
+			t.Errorf("%s: both architectures share UUID %s", tc.name, info.Arches[0].Uuid)
+		}
+		if err := machoCheckUuidInfo(&info); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+
+		// A record whose UUID does not follow from its inputs fails
+		// the check.
+		info.Arches[0].Input += "x"
+		if err := machoCheckUuidInfo(&info); err == nil {
+			t.Errorf("%s: record with a wrong input passed the check", tc.name)
+		}
+	}
+
+	// The external linker's UUID, left in place, is not described by a
+	// record of the derivation.
+	exe := filepath.Join(dir, "unrewritten")
+	if err := os.WriteFile(exe, newTestMachO(testUuid).bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := machoWriteUuidInfo(exe); err == nil {
+		t.Error("wrote a record for a binary whose UUID was not derived")
+	}
+}
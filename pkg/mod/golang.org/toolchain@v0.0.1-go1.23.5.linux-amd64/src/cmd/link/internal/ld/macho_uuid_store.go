@@ -0,0 +1,89 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -uuidstore, which records the Mach-O UUID derived
+// from each build ID in a file and reuses the recorded UUID on later
+// links with that build ID, so that rebuilding an archived program
+// gives it the same UUID even after a toolchain upgrade has changed the
+// derivation in uuidFromGoBuildId.
+//
+// The store is a text file with one "<build ID> <UUID>" line per build
+// ID, the UUID in the form machoUuidString prints. Links sharing a
+// store do not lock it: each adds its line with a single append, and
+// the first line for a build ID is the one every link uses, so links
+// racing to record the same build ID agree on its UUID. Lines that do
+// not parse, such as one cut short by a crash, are ignored.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// machoLookupStoredUuid returns the UUID recorded for buildID in the
+// store at path, and whether there is one. It also reports whether the
+// store ends in the middle of a line. A missing store records nothing.
+func machoLookupStoredUuid(path, buildID string) (uuid []byte, ok, partial bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, false, nil
+	}
+	if err != nil {
+		return nil, false, false, err
+	}
+	partial = len(data) > 0 && data[len(data)-1] != '\n'
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		id, u, found := strings.Cut(s.Text(), " ")
+		if !found || id != buildID {
+			continue
+		}
+		if u, err := machoParseUuid(u); err == nil {
+			return u[:], true, partial, nil
+		}
+	}
+	return nil, false, partial, s.Err()
+}
+
+// machoStoreUuid returns the UUID recorded for buildID in the store at
+// path, first recording uuid, the one derived from buildID, if the
+// store has none.
+func machoStoreUuid(path, buildID string, uuid []byte) ([]byte, error) {
+	if strings.ContainsAny(buildID, " \n") {
+		return nil, fmt.Errorf("build ID %q cannot be recorded", buildID)
+	}
+	stored, ok, partial, err := machoLookupStoredUuid(path, buildID)
+	if err != nil || ok {
+		return stored, err
+	}
+	line := fmt.Sprintf("%s %s\n", buildID, machoUuidString([16]byte(uuid)))
+	if partial {
+		// Do not extend a line left unfinished by an interrupted link.
+		line = "\n" + line
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write([]byte(line))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Another link may have recorded buildID in the meantime; the
+	// first line for it wins.
+	stored, ok, _, err = machoLookupStoredUuid(path, buildID)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s: UUID for build ID %q not found after recording it", path, buildID)
+	}
+	return stored, err
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMachoStoreUuid(t *testing.T) {
+	defer func(old int) { uuidVersion = old }(uuidVersion)
+	const buildID = "abcdefghijklmnopqrst/uvwxyzABCDEFGHIJKL"
+	store := filepath.Join(t.TempDir(), "uuids")
+
+	// The first link records the UUID it derives.
+	uuidVersion = 3
+	first := uuidFromGoBuildId(buildID)
+	got, err := machoStoreUuid(store, buildID, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, first) {
+		t.Errorf("first link: UUID %x, want the derived %x", got, first)
+	}
+
+	// A rebuild after the derivation changed still gets the recorded
+	// UUID.
+	uuidVersion = 5
+	changed := uuidFromGoBuildId(buildID)
+	if bytes.Equal(changed, first) {
+		t.Fatal("derivation did not change")
+	}
+	got, err = machoStoreUuid(store, buildID, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, first) {
+		t.Errorf("rebuild: UUID %x, want the recorded %x", got, first)
+	}
+
+	// Another build ID gets its own entry.
+	if got, err := machoStoreUuid(store, "other", changed); err != nil || !bytes.Equal(got, changed) {
+		t.Errorf("other build ID: UUID %x, %v; want %x", got, err, changed)
+	}
+	data, _ := os.ReadFile(store)
+	if want := fmt.Sprintf("%s %s\nother %s\n", buildID, machoUuidString([16]byte(first)), machoUuidString([16]byte(changed))); string(data) != want {
+		t.Errorf("store:\n%s\nwant:\n%s", data, want)
+	}
+
+	if _, err := machoStoreUuid(store, "bad id", first); err == nil {
+		t.Error("build ID with a space recorded")
+	}
+}
+
+func TestMachoStoreUuidPartialLine(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "uuids")
+	// An entry cut short by an interrupted link.
+	if err := os.WriteFile(store, []byte("test/buildid 8BF87F92-53"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	u := uuidFromGoBuildId("test/buildid")
+	got, err := machoStoreUuid(store, "test/buildid", u)
+	if err != nil || !bytes.Equal(got, u) {
+		t.Errorf("UUID %x, %v; want %x", got, err, u)
+	}
+	if data, _ := os.ReadFile(store); !strings.HasSuffix(string(data), "-53\ntest/buildid "+machoUuidString([16]byte(u))+"\n") {
+		t.Errorf("entry appended to the partial line:\n%s", data)
+	}
+}
+
+func TestMachoStoreUuidConcurrent(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "uuids")
+	// Links racing to record the same build ID, each deriving a
+	// different UUID, as links by different toolchains would, all end
+	// up with the same one.
+	const n = 16
+	var wg sync.WaitGroup
+	got := make([][]byte, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], errs[i] = machoStoreUuid(store, "test/buildid", []byte(fmt.Sprintf("uuid of link %3d", i)))
+		}(i)
+	}
+	wg.Wait()
+	for i := range got {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if !bytes.Equal(got[i], got[0]) {
+			t.Errorf("link %d got UUID %x, link 0 got %x", i, got[i], got[0])
+		}
+	}
+	u, ok, partial, err := machoLookupStoredUuid(store, "test/buildid")
+	if err != nil || !ok || partial || !bytes.Equal(u, got[0]) {
+		t.Errorf("store gives %x, %v, %v, %v; want %x", u, ok, partial, err, got[0])
+	}
+}
@@ -39,6 +39,7 @@ import (
 	"cmd/internal/telemetry/counter"
 	"cmd/link/internal/benchmark"
 	"flag"
+	"fmt"
 	"internal/buildcfg"
 	"log"
 	"os"
@@ -58,6 +59,8 @@ func init() {
 	flag.Var(&rpath, "r", "set the ELF dynamic linker search `path` to dir1:dir2:...")
 	flag.Var(&flagExtld, "extld", "use `linker` when linking in external mode")
 	flag.Var(&flagExtldflags, "extldflags", "pass `flags` to external linker")
+	flag.Var(&flagPostLinkTool, "post-link-tool", "run `command` with the path of the output and of a JSON file describing the link once the output is complete")
+	flag.Var(&flagMachoSigner, "machosigner", "sign Mach-O output with a signing identity, running `command` with the path of a file holding the code directory to print its CMS signature")
 	flag.Var(&flagW, "w", "disable DWARF generation")
 }
 
@@ -65,27 +68,98 @@ func init() {
 var (
 	flagBuildid = flag.String("buildid", "", "record `id` as Go toolchain build id")
 	flagBindNow = flag.Bool("bindnow", false, "mark a dynamically linked ELF object for immediate function binding")
+	flagICF     = flag.String("icf", "none", "fold identical functions: `mode` none, safe (those whose address is not taken) or all")
+
+	flagBTF                = flag.Bool("btf", false, "write the DWARF types in BTF to a .BTF section (ELF)")
+	flagFuncAlign          = flag.String("funcalign", "", "align functions to `[hot:]n` bytes, with hot: only those of the -pgo profile")
+	flagEmitRelocs         = flag.Bool("emitrelocs", false, "keep the relocations of an internally linked ELF executable in the output (amd64 and arm64)")
+	flagPackRelativeRelocs = flag.Bool("packrelativerelocs", false, "pack the relative relocations of an ELF PIE into a SHT_RELR section (amd64 and arm64)")
+	flagStaticPIE          = flag.Bool("static-pie", false, "with -buildmode=pie, write an ELF executable that relocates itself at startup and needs no dynamic linker")
+	flagSoname             = flag.String("soname", "", "set the DT_SONAME of an ELF shared object to `name`")
+	flagVersionScript      = flag.String("version-script", "", "version and hide the dynamic symbols of ELF output by the GNU version script `file`")
+	flagDebugNames         = flag.Bool("debugnames", false, "write a DWARF 5 .debug_names index of the names in the DWARF")
+	flagProvenance         = flag.String("provenance", "", "embed a SLSA-style provenance record of the build naming `builder` as the builder in a .note.go.provenance ELF note or __TEXT,__go_provenance Mach-O section")
+	flagSplitDwarf         = flag.String("split-dwarf", "", "write DWARF to `file`, a dSYM bundle for Mach-O, instead of into the output")
 
 	flagOutfile    = flag.String("o", "", "write output to `file`")
 	flagPluginPath = flag.String("pluginpath", "", "full path name for plugin")
 
 	flagInstallSuffix = flag.String("installsuffix", "", "set package directory `suffix`")
 	flagDumpDep       = flag.Bool("dumpdep", false, "dump symbol dependency graph")
+	flagWhyLive       = flag.String("why-live", "", "print the chain of references that keeps `symbol` live in the deadcode pass")
+	flagUndefs        = flag.Bool("undefs", false, "report every undefined symbol, grouped by the package or host object that refers to it, with the sites of the references, instead of stopping after 20 errors")
 	flagRace          = flag.Bool("race", false, "enable race detector")
 	flagMsan          = flag.Bool("msan", false, "enable MSan interface")
 	flagAsan          = flag.Bool("asan", false, "enable ASan interface")
 	flagAslr          = flag.Bool("aslr", true, "enable ASLR for buildmode=c-shared on windows")
 
+	flagDisallowPackages = flag.String("disallow-packages", "", "fail the link if a package of the comma-separated `list`, or of the file given as @file, has live symbols after the deadcode pass, printing why")
+
+	flagIncremental = flag.String("incremental", "", "experimental: keep the state of the link in `dir`, and patch the output of the last link there if only the contents of functions changed")
+
+	flagMaxBinarySize = flag.String("max-binary-size", "", "fail the link if the output is larger than `size` bytes, optionally followed by K, M or G, printing where the size went")
+	flagSizeBaseline  = flag.String("size-baseline", "", "with a size budget, print how the size changed since the link whose -json-report is `file` when it is exceeded")
+
 	flagFieldTrack = flag.String("k", "", "set field tracking `symbol`")
 	flagLibGCC     = flag.String("libgcc", "", "compiler support lib for internal linking; use \"none\" to disable")
 	flagTmpdir     = flag.String("tmpdir", "", "use `directory` for temporary files")
+	flagStreamOut  = flag.Bool("streamout", false, "write the output file as a stream with bounded memory instead of mapping it")
 
 	flagExtld      quoted.Flag
 	flagExtldflags quoted.Flag
 	flagExtar      = flag.String("extar", "", "archive program for buildmode=c-archive")
 
+	flagArSymtab = flag.String("arsymtab", "auto", "write the symbol index of the archive of buildmode=c-archive as `flavor` gnu, gnu64, bsd or none")
+	flagArNames  = flag.String("arnames", "auto", "store the member names of the archive of buildmode=c-archive as `flavor` gnu or bsd")
+
+	flagExtldCache   = flag.String("extldcache", "", "cache the results of probing the external linker in `directory`, across links")
+	flagExtldVerbose = flag.Bool("extld-verbose", false, "print each command run of the external linker, including its probes, as a shell command line")
+
 	flagCaptureHostObjs = flag.String("capturehostobjs", "", "capture host object files loaded during internal linking to specified dir")
 
+	flagUuidOut    = flag.String("uuidout", "", "write the Mach-O UUID of the output to `file`")
+	flagForceUuid  = flag.String("forceuuid", "", "set the Mach-O UUID to `uuid`, as -macho-uuid=0x followed by its hex digits does (deprecated)")
+	flagUuidExpl   = flag.Bool("uuidexplain", false, "print how the Mach-O UUID of the output was derived to standard output")
+	flagUuidJSON   = flag.Bool("uuidjson", false, "print the Mach-O UUID of the output to standard output as JSON")
+	flagUuidFrom   = flag.String("uuidfrom", "", "set the Mach-O UUID to that of the existing Mach-O `file`, such as the previous output")
+	flagUuidVerify = flag.Bool("uuidverify", false, "check that the Mach-O UUID of the output matches its Go build ID")
+	flagUuidVers   = flag.Int("uuidversion", 3, "set the RFC 4122 `version` (3, 4 or 5) of Mach-O UUIDs derived from the build ID")
+	flagMachoFat   = flag.String("machofat", "", "combine the Mach-O output with the thin Mach-O `files` of other architectures, a comma-separated list, into a universal binary")
+	flagMachoUuid  = flag.String("macho-uuid", "", "set the Mach-O UUID by `policy`: gobuildid, random, none (all zeros) or 0x followed by 32 hex digits")
+
+	flagUuidContent       = flag.Bool("uuidcontent", false, "derive Mach-O UUIDs from the content ID, the last component of the build ID, alone")
+	flagUuidFromCode      = flag.String("uuidfromcode", "", "derive the Mach-O UUID from the contents of `sections` (text, data) instead of the build ID")
+	flagUuidFromConvert   = flag.Bool("uuidfromconvert", false, "let -uuidfrom carry the UUID of an executable to a dylib, or of a dylib to an executable")
+	flagUuidImage         = flag.String("uuidimage", "", "salt Mach-O UUIDs derived from the build ID with the toolchain container image `digest` (default $GO_IMAGE_DIGEST)")
+	flagUuidInfo          = flag.Bool("uuidinfo", false, "write a JSON record of how the Mach-O UUID of the output was derived to the output path with .uuidinfo appended")
+	flagUuidInsert        = flag.Bool("uuidinsert", false, "add an LC_UUID to Mach-O output from the external linker that has none (external linking only)")
+	flagUuidLabel         = flag.String("uuidlabel", "", "record `label` in an LC_NOTE next to the Mach-O UUID (external linking only)")
+	flagUuidLd64          = flag.Bool("uuidld64", false, "derive the Mach-O UUID from a SHA-256 hash of the output, approximating ld64 (external linking only)")
+	flagUuidManifest      = flag.String("uuidmanifest", "", "do not rewrite the Mach-O UUID; write the changes that would to `file` instead (external linking only)")
+	flagUuidManifestMin   = flag.Bool("uuidmanifestmin", false, "with -uuidmanifest, record only the change to the Mach-O UUID, not to the code signature")
+	flagUuidPatch         = flag.String("uuidpatch", "", "write a record of the bytes the Mach-O UUID rewrite changed to `file` (external linking only)")
+	flagUuidPgo           = flag.String("uuidpgo", "", "salt Mach-O UUIDs derived from the build ID with a hash of the PGO profile `file`")
+	flagUuidPerCpu        = flag.Bool("uuidpercpu", false, "salt Mach-O UUIDs derived from the build ID with the cpu type, giving each architecture its own")
+	flagUuidStore         = flag.String("uuidstore", "", "record the Mach-O UUID derived from each build ID in `file`, and reuse the recorded UUID on later links")
+	flagReproBuildinfo    = flag.Bool("reprobuildinfo", false, "write a reproducible-builds.org style record of how the Mach-O output was made to the output path with .buildinfo appended")
+	flagReproducible      = flag.Bool("reproducible", false, "normalize nondeterministic fields of Mach-O output from the external linker")
+	flagReproOrder        = flag.Bool("reproorder", false, "sort the load commands of Mach-O output from the external linker into a canonical order")
+	flagReproPasses       = flag.String("repropasses", "", "run only the post-link Mach-O `passes` in this comma-separated list (implies -reproducible)")
+	flagReproReport       = flag.String("reproreport", "", "append a row for each change the Mach-O UUID rewrite and -reproducible passes made to the output to `file` (external linking only)")
+	flagReproReportFormat = flag.String("reproreportformat", "csv", "write -reproreport rows in `format` csv or json")
+	flagReproStrict       = flag.Bool("reprostrict", false, "fail if a post-link Mach-O pass finds nothing to act on, such as a missing LC_BUILD_VERSION (external linking only)")
+	flagAdHocSign         = flag.Bool("adhocsign", false, "give Mach-O output from the external linker a fresh ad hoc code signature after the post-link passes (external linking only)")
+	flagVerifyRepro       = flag.Bool("verify-reproducible", false, "link a second time and fail if the output differs, naming the sections and symbols that do")
+	flagReproducibleMtime = flag.Bool("reproduciblemtime", false, "set the modification time of Mach-O output from the external linker to $SOURCE_DATE_EPOCH, or the Unix epoch")
+	flagMachoSDK          = flag.String("machosdk", "", "resolve the symbols host objects import from dynamic libraries, when linking internally, from the .tbd stubs of the SDK at `dir`")
+	flagMachoSignID       = flag.String("machosignid", "", "set the identifier of the -machosigner code signature to `identifier`, such as com.example.tool")
+	flagMachoSignTeam     = flag.String("machosignteam", "", "record the team `id` of the signing identity in the -machosigner code signature")
+	flagMachoFixups       = flag.String("machofixups", "auto", "encode the dynamic relocations of an internally linked Mach-O PIE in `format`: chained (LC_DYLD_CHAINED_FIXUPS), opcodes (LC_DYLD_INFO_ONLY), or auto, chained if the minimum macOS version is 12.0 or later")
+	flagPeDllChars        = flag.String("pedllcharacteristics", "", "set the PE DLL `characteristics` in this comma-separated list of dynamicbase, highentropyva, nxcompat, tsaware, guardcf and cetcompat, or clear those prefixed with no")
+	flagPeImplib          = flag.String("peimplib", "", "write an import library for the DLL to `file` (windows c-shared only)")
+	flagPeSubsystem       = flag.String("pesubsystemversion", "", "set the minimum Windows `version` of the PE subsystem, major.minor (default 6.1)")
+	flagPlatformVersion   = flag.String("platform-version", "", "set the Mach-O platform, minimum OS version and SDK version to `platform,minos[,sdk]`, platform one of macos, maccatalyst, ios or iossimulator")
+
 	flagA             = flag.Bool("a", false, "no-op (deprecated)")
 	FlagC             = flag.Bool("c", false, "dump call graph")
 	FlagD             = flag.Bool("d", false, "disable dynamic executable")
@@ -94,11 +168,15 @@ var (
 	flagH             = flag.Bool("h", false, "halt on error")
 	flagN             = flag.Bool("n", false, "no-op (deprecated)")
 	FlagS             = flag.Bool("s", false, "disable symbol table")
+	flagStrip         = flag.String("strip", "", "strip the comma-separated `kinds` all, symtab, dwarf, lines and frame from the output")
 	flag8             bool // use 64-bit addresses in symbol table
 	flagHostBuildid   = flag.String("B", "", "set ELF NT_GNU_BUILD_ID `note` or Mach-O UUID; use \"gobuildid\" to generate it from the Go build ID")
+	flagBuildidHash   = flag.String("buildidhash", "", "derive -B gobuildid with `algorithm` notsha256, sha1, sha256 or uuid")
+	flagBuildidLen    = flag.Int("buildidlen", 0, "truncate the -B note to `n` bytes, or check the length of the one given")
 	flagInterpreter   = flag.String("I", "", "use `linker` as ELF dynamic linker")
 	flagCheckLinkname = flag.Bool("checklinkname", true, "check linkname symbol references")
 	FlagDebugTramp    = flag.Int("debugtramp", 0, "debug trampolines")
+	flagTramp         = flag.String("tramp", "auto", "insert trampolines for direct calls that are out of range (`mode` auto), for all calls between packages (always), or for none (never)")
 	FlagDebugTextSize = flag.Int("debugtextsize", 0, "debug text section max size")
 	flagDebugNosplit  = flag.Bool("debugnosplit", false, "dump nosplit call graph")
 	FlagStrictDups    = flag.Int("strictdups", 0, "sanity check duplicate symbol contents during object file reading (1=warn 2=err).")
@@ -107,14 +185,30 @@ var (
 	flagEntrySymbol   = flag.String("E", "", "set `entry` symbol name")
 	flagPruneWeakMap  = flag.Bool("pruneweakmap", true, "prune weak mapinit refs")
 	flagRandLayout    = flag.Int64("randlayout", 0, "randomize function layout")
+	flagPgo           = flag.String("pgo", "", "place the functions that call each other most in the CPU profile `file` next to each other")
+	flagSplitCold     = flag.Bool("splitcold", false, "with -pgo, place the functions the profile does not see called in a .text.unlikely section after the others")
+	flagSymbolOrder   = flag.String("symbol-ordering-file", "", "place the functions and data listed in `file` first in their sections, in order")
+	flagM             = flag.Bool("M", false, "print a map of the section and symbol layout of the output to standard output")
+	flagLinkMap       = flag.String("linkmap", "", "write a map of the section and symbol layout of the output to `file`")
+	flagLinkMapFormat = flag.String("linkmapformat", "text", "write the -linkmap file in `format` text or json")
+	FlagWasmNames     = flag.Bool("wasmnames", false, "name every function, local and global of a wasm module in its name section")
+	flagCref          = flag.String("cref", "", "write a cross-reference table of the symbols and the symbols that refer to them to `file`")
+	flagJSONReport    = flag.String("json-report", "", "write a JSON report attributing the size of the output to sections, symbol kinds, packages and host objects to `file`")
 	cpuprofile        = flag.String("cpuprofile", "", "write cpu profile to `file`")
 	memprofile        = flag.String("memprofile", "", "write memory profile to `file`")
 	memprofilerate    = flag.Int64("memprofilerate", 0, "set runtime.MemProfileRate to `rate`")
 	benchmarkFlag     = flag.String("benchmark", "", "set to 'mem' or 'cpu' to enable phase benchmarking")
 	benchmarkFileFlag = flag.String("benchmarkprofile", "", "emit phase profiles to `base`_phase.{cpu,mem}prof")
+	flagTrace         = flag.String("trace", "", "write the wall time, allocations, peak RSS and symbol and section counts of each phase of the link to `file`")
+	flagTraceFormat   = flag.String("traceformat", "json", "write the -trace file in `format` json or go, a runtime/trace execution trace")
 
 	flagW ternaryFlag
 	FlagW = new(bool) // the -w flag, computed in main from flagW
+
+	flagWrap []string // the symbols given by -wrap
+
+	flagPostLinkTool quoted.Flag // the command given by -post-link-tool
+	flagMachoSigner  quoted.Flag // the command given by -machosigner
 )
 
 // ternaryFlag is like a boolean flag, but has a default value that is
@@ -154,6 +248,57 @@ func (t *ternaryFlag) String() string {
 
 func (t *ternaryFlag) IsBoolFlag() bool { return true } // parse like a boolean flag
 
+// compressFlag is the value of -compressdwarf: a boolean, or the
+// compression to use, zlib or zstd with an optional level, as in
+// zstd:7. Unlike zlib, the default, zstd needs recent debuggers.
+//
+// compressFlag implements flag.Value.
+type compressFlag struct {
+	on    *bool
+	level *int // zstd level, or 0 for zlib
+}
+
+func (f compressFlag) Set(s string) error {
+	switch alg, level, ok := strings.Cut(s, ":"); alg {
+	case "zlib":
+		if ok {
+			return fmt.Errorf("zlib takes no level")
+		}
+		*f.on, *f.level = true, 0
+	case "zstd":
+		l := zstdDefaultLevel
+		if ok {
+			var err error
+			l, err = strconv.Atoi(level)
+			if err != nil || l < 1 || l > zstdMaxLevel {
+				return fmt.Errorf("zstd level %q is not from 1 to %d", level, zstdMaxLevel)
+			}
+		}
+		*f.on, *f.level = true, l
+	default:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("want a boolean, zlib or zstd[:level]")
+		}
+		*f.on, *f.level = v, 0
+	}
+	return nil
+}
+
+func (f compressFlag) String() string {
+	switch {
+	case f.on == nil:
+		return ""
+	case !*f.on:
+		return "false"
+	case *f.level != 0:
+		return fmt.Sprintf("zstd:%d", *f.level)
+	}
+	return "true"
+}
+
+func (f compressFlag) IsBoolFlag() bool { return true } // parse like a boolean flag
+
 // Main is the main entry point for the linker code.
 func Main(arch *sys.Arch, theArch Arch) {
 	log.SetPrefix("link: ")
@@ -196,10 +341,24 @@ func Main(arch *sys.Arch, theArch Arch) {
 	flag.BoolVar(&ctxt.linkShared, "linkshared", false, "link against installed Go shared libraries")
 	flag.Var(&ctxt.LinkMode, "linkmode", "set link `mode`")
 	flag.Var(&ctxt.BuildMode, "buildmode", "set build `mode`")
-	flag.BoolVar(&ctxt.compressDWARF, "compressdwarf", true, "compress DWARF if possible")
+	ctxt.compressDWARF = true
+	flag.Var(compressFlag{&ctxt.compressDWARF, &ctxt.compressZstd}, "compressdwarf", "compress DWARF if possible, with zlib, or with zstd given as zstd[:level]")
 	objabi.Flagfn1("L", "add specified `directory` to library path", func(a string) { Lflag(ctxt, a) })
 	objabi.AddVersionFlag() // -V
 	objabi.Flagfn1("X", "add string value `definition` of the form importpath.name=value", func(s string) { addstrdata1(ctxt, s) })
+	objabi.Flagfn1("z", "set the ELF hardening or layout `keyword` relro, norelro, now, lazy, max-page-size=size, separate-code, noseparate-code, ibt, shstk, cet-report=report, force-bti or bti-report=report, as GNU ld does", elfZ)
+	objabi.Flagfn1("add-note", "add an ELF note given as `section:owner:type=file`, with the contents of file as its description, to the SHT_NOTE section", addNote)
+	objabi.Flagfn1("add-section", "add a read-only section given as `name=file` with the contents of file, named __TEXT,section for Mach-O", addSection)
+	objabi.Flagfn1("export-symbols", "export just the symbols listed in `file`, one per line, from a -buildmode=c-shared or plugin shared library", func(s string) { flagExportSymbols = append(flagExportSymbols, s) })
+	objabi.Flagfn1("export-dynamic-symbol", "export `symbol`, and only the symbols given by it or -export-symbols, from a -buildmode=c-shared or plugin shared library", func(s string) { flagExportDynamicSymbol = append(flagExportDynamicSymbol, s) })
+	objabi.Flagfn1("dwarf-prefix-map", "rewrite the paths in DWARF, including that of host objects when linking externally, that start with old, given as `old=new`, to start with new", addDwarfPrefixMap)
+	objabi.Flagfn1("max-section-size", "fail the link if a section is larger than the size given as `section=size`, in bytes, optionally followed by K, M or G, printing where the size went", addSectionBudget)
+	objabi.Flagfn1("rsrc", "add the resources of the compiled resource (.res) or COFF object `file` to the .rsrc section of a PE file", func(s string) { flagRsrc = append(flagRsrc, s) })
+	objabi.Flagfn1("machostub", "resolve the symbols host objects import from dynamic libraries, when linking internally, from the .tbd stub `file` too", func(s string) { flagMachoStubs = append(flagMachoStubs, s) })
+	objabi.Flagfn1("wasmsection", "add a custom section to a wasm module, given as `name=file` with the contents of file", wasmSection)
+	objabi.Flagfn1("wasmproducer", "add the value `field=name[@version]` to the producers section of a wasm module", wasmProducer)
+	objabi.Flagfn1("wasmtargetfeatures", "write a target_features section listing the wasm `features`, each used or, when prefixed with -, disallowed", wasmTargetFeatures)
+	objabi.Flagfn1("wrap", "resolve references to `symbol` to __wrap_symbol, and to __real_symbol to symbol", func(s string) { flagWrap = append(flagWrap, s) })
 	objabi.Flagcount("v", "print link trace", &ctxt.Debugvlog)
 	objabi.Flagfn1("importcfg", "read import configuration from `file`", ctxt.readImportCfg)
 
@@ -246,12 +405,16 @@ func Main(arch *sys.Arch, theArch Arch) {
 	isPowerOfTwo := func(n int64) bool {
 		return n > 0 && n&(n-1) == 0
 	}
+	elfZSetPageSize()
 	if *FlagRound != -1 && (*FlagRound < 4096 || !isPowerOfTwo(*FlagRound)) {
 		Exitf("invalid -R value 0x%x", *FlagRound)
 	}
 
 	checkStrictDups = *FlagStrictDups
 
+	if *flagStrip != "" {
+		applyStrip(*flagStrip)
+	}
 	switch flagW {
 	case ternaryFlagFalse:
 		*FlagW = false
@@ -294,9 +457,48 @@ func Main(arch *sys.Arch, theArch Arch) {
 		*flagBuildid = "go-openbsd"
 	}
 
-	if *flagHostBuildid != "" {
+	switch *flagUuidVers {
+	case 3, 4, 5:
+		uuidVersion = *flagUuidVers
+	default:
+		Exitf("-uuidversion must be 3, 4 or 5, not %d", *flagUuidVers)
+	}
+
+	if *flagUuidPgo != "" {
+		// Before addbuildinfo, which derives the UUID.
+		h, err := machoPgoProfileHash(*flagUuidPgo)
+		if err != nil {
+			Exitf("-uuidpgo: %v", err)
+		}
+		uuidPgoHash = h
+	}
+	uuidImageDigest = *flagUuidImage
+	if uuidImageDigest == "" {
+		uuidImageDigest = os.Getenv("GO_IMAGE_DIGEST")
+	}
+	if uuidImageDigest != "" {
+		if err := machoCheckImageDigest(uuidImageDigest); err != nil {
+			Exitf("-uuidimage: %v", err)
+		}
+	}
+
+	checkMachoUuidFlags(ctxt)
+	if *flagHostBuildid != "" || machoUuidPolicy == "gobuildid" {
 		addbuildinfo(ctxt)
 	}
+	if *flagHostBuildid == "" && machoUuidPolicy != "gobuildid" && (*flagBuildidHash != "" || *flagBuildidLen != 0) {
+		Exitf("-buildidhash and -buildidlen require -B or -macho-uuid=gobuildid")
+	}
+
+	if *flagUuidOut != "" && !ctxt.IsDarwin() {
+		Exitf("-uuidout is only supported when linking for darwin or ios")
+	}
+	if *flagUuidJSON && !ctxt.IsDarwin() {
+		Exitf("-uuidjson is only supported when linking for darwin or ios")
+	}
+	if *flagUuidExpl && !ctxt.IsDarwin() {
+		Exitf("-uuidexplain is only supported when linking for darwin or ios")
+	}
 
 	// enable benchmarking
 	var bench *benchmark.Metrics
@@ -310,12 +512,30 @@ func Main(arch *sys.Arch, theArch Arch) {
 			usage()
 		}
 	}
+	if *flagTrace != "" {
+		bench = startLinkTrace(ctxt, bench)
+	}
 
 	bench.Start("libinit")
 	libinit(ctxt) // creates outfile
 	bench.Start("computeTLSOffset")
 	ctxt.computeTLSOffset()
 	bench.Start("Archinit")
+	switch *flagTramp {
+	case "auto":
+	case "never":
+		if ctxt.IsRISCV64() {
+			// Calls are JALs, which reach 1MB, unless the
+			// trampoline pass finds the target too far.
+			Exitf("-tramp=never is not supported on %s", buildcfg.GOARCH)
+		}
+	case "always":
+		if thearch.Trampoline == nil {
+			Exitf("-tramp=always is not supported on %s", buildcfg.GOARCH)
+		}
+	default:
+		Exitf("-tramp: unknown mode %q, want auto, always or never", *flagTramp)
+	}
 	thearch.Archinit(ctxt)
 
 	if ctxt.linkShared && !ctxt.IsELF {
@@ -357,14 +577,431 @@ func Main(arch *sys.Arch, theArch Arch) {
 	bench.Start("loadlib")
 	ctxt.loadlib()
 
+	if *flagSplitDwarf != "" && !ctxt.IsInternal() {
+		Exitf("-split-dwarf requires internal linking")
+	}
+
+	if len(flagWrap) > 0 {
+		if ctxt.IsInternal() {
+			ctxt.loader.WrapSyms(flagWrap)
+		} else if ctxt.IsDarwin() || ctxt.IsAIX() {
+			Exitf("-wrap is not supported by the external linker for %s", ctxt.HeadType)
+		}
+	}
+
+	if *flagUuidFromCode != "" {
+		if !ctxt.IsDarwin() || !ctxt.IsExternal() {
+			Exitf("-uuidfromcode requires external linking for darwin or ios")
+		}
+		sects, err := machoParseUuidFromCode(*flagUuidFromCode)
+		if err != nil {
+			Exitf("-uuidfromcode: %v", err)
+		}
+		uuidCodeSections = sects
+	}
+	if *flagUuidLd64 {
+		if !ctxt.IsDarwin() || !ctxt.IsExternal() {
+			Exitf("-uuidld64 requires external linking for darwin or ios")
+		}
+		if forcedUuid != nil || uuidCodeSections != nil {
+			Exitf("-uuidld64 cannot be combined with -macho-uuid, -uuidfrom or -uuidfromcode")
+		}
+	}
+	if *flagUuidLabel != "" && (!ctxt.IsDarwin() || !ctxt.IsExternal()) {
+		Exitf("-uuidlabel requires external linking for darwin or ios")
+	}
+	if *flagReproOrder && (!ctxt.IsDarwin() || !ctxt.IsExternal()) {
+		Exitf("-reproorder requires external linking for darwin or ios")
+	}
+	if *flagAdHocSign && (!ctxt.IsDarwin() || !ctxt.IsExternal() || ctxt.BuildMode == BuildModeCArchive) {
+		Exitf("-adhocsign requires external linking for darwin or ios, and a build mode other than c-archive")
+	}
+	if *flagReproStrict && (!ctxt.IsDarwin() || !ctxt.IsExternal()) {
+		Exitf("-reprostrict requires external linking for darwin or ios")
+	}
+	if *flagReproPasses != "" {
+		if !ctxt.IsDarwin() || !ctxt.IsExternal() {
+			Exitf("-repropasses requires external linking for darwin or ios")
+		}
+		passes, err := machoParseReproPasses(*flagReproPasses)
+		if err != nil {
+			Exitf("-repropasses: %v", err)
+		}
+		reproPasses = passes
+	}
+	if err := machoCheckReproBuildId(ctxt); err != nil {
+		Exitf("-reproducible: %v", err)
+	}
+	if *flagUuidVerify {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidverify is only supported when linking for darwin or ios")
+		}
+		if forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64 || !machoPassSelected("uuid") {
+			Exitf("-uuidverify requires the Mach-O UUID to be derived from the build ID")
+		}
+	}
+	if *flagUuidInfo {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidinfo is only supported when linking for darwin or ios")
+		}
+		if !machoLinkSetsUuid() {
+			Exitf("-uuidinfo requires the link to set the Mach-O UUID")
+		}
+	}
+	if *flagReproBuildinfo {
+		if !ctxt.IsDarwin() {
+			Exitf("-reprobuildinfo is only supported when linking for darwin or ios")
+		}
+		if !machoLinkSetsUuid() {
+			Exitf("-reprobuildinfo requires the link to set the Mach-O UUID")
+		}
+	}
+	if *flagMachoFat != "" {
+		if !ctxt.IsDarwin() {
+			Exitf("-machofat is only supported when linking for darwin or ios")
+		}
+		if ctxt.BuildMode == BuildModeCArchive {
+			Exitf("-machofat is not supported with -buildmode=c-archive")
+		}
+	}
+	if err := checkLinkMapFormat(*flagLinkMapFormat); err != nil {
+		Exitf("-linkmapformat: %v", err)
+	}
+	if err := checkICFMode(*flagICF); err != nil {
+		Exitf("-icf: %v", err)
+	}
+	if *flagICF != "none" {
+		if !ctxt.IsELF && !ctxt.IsDarwin() && !ctxt.IsWindows() {
+			Exitf("-icf is only supported for ELF, Mach-O and PE")
+		}
+		if !ctxt.IsInternal() || ctxt.DynlinkingGo() {
+			Exitf("-icf requires internal linking and is not supported when dynamically linking Go")
+		}
+	}
+	if len(elfZKeywords) > 0 {
+		if !ctxt.IsELF {
+			Exitf("-z is only supported for ELF")
+		}
+		if elfZRelro < 0 && ctxt.UseRelro() {
+			Exitf("-z norelro is only supported for -buildmode=exe without -linkshared, as other outputs need relro for their dynamic relocations")
+		}
+		checkElfZProperties(ctxt)
+		elfZLayout(ctxt)
+	}
+	if *flagEmitRelocs {
+		if !ctxt.IsELF || !emitRelocsSupported(ctxt.Arch) {
+			Exitf("-emitrelocs is only supported for ELF on amd64 and arm64")
+		}
+		if !ctxt.IsInternal() {
+			Exitf("-emitrelocs requires internal linking")
+		}
+		if *FlagS {
+			Exitf("-emitrelocs needs the symbol table, which -s omits")
+		}
+	}
+	if *flagPackRelativeRelocs && (!ctxt.IsELF || !elfRelrSupported(ctxt.Arch)) {
+		Exitf("-packrelativerelocs is only supported for ELF on amd64 and arm64")
+	}
+	if *flagStaticPIE {
+		if ctxt.BuildMode != BuildModePIE || !ctxt.IsELF {
+			Exitf("-static-pie requires -buildmode=pie and ELF output")
+		}
+		if *FlagD || *flagInterpreter != "" {
+			Exitf("-static-pie cannot be combined with -d or -I")
+		}
+		if ctxt.IsInternal() && (!ctxt.IsLinux() || !staticPIESupported(ctxt.Arch)) {
+			Exitf("-static-pie requires external linking except on linux/amd64 and linux/arm64")
+		}
+	}
+	if len(wasmFlags) > 0 && !ctxt.IsWasm() {
+		Exitf("%s is only supported for wasm", wasmFlags[0])
+	}
+	if *FlagWasmNames {
+		if !ctxt.IsWasm() {
+			Exitf("-wasmnames is only supported for wasm")
+		}
+		if *FlagS {
+			Exitf("-wasmnames needs the name section, which -s omits")
+		}
+	}
+	if *flagRandLayout != 0 && (*flagPgo != "" || *flagSymbolOrder != "") {
+		Exitf("-randlayout cannot be combined with -pgo or -symbol-ordering-file")
+	}
+	if *flagSymbolOrder != "" {
+		names, err := readSymbolOrderingFile(*flagSymbolOrder)
+		if err != nil {
+			Exitf("-symbol-ordering-file: %v", err)
+		}
+		textOrderNames = names
+	}
+	if ctxt.compressZstd != 0 && !ctxt.IsELF {
+		Exitf("-compressdwarf=zstd is only supported for ELF")
+	}
+	if *flagSplitCold {
+		checkSplitCold(ctxt)
+	}
+	if *flagPgo != "" {
+		p, err := readTextOrderProfile(*flagPgo)
+		if err != nil {
+			Exitf("-pgo: %v", err)
+		}
+		textOrderProfile = p
+	}
+	if *flagFuncAlign != "" {
+		if ctxt.IsWasm() {
+			Exitf("-funcalign is not supported for wasm")
+		}
+		align, hot, err := parseFuncAlign(*flagFuncAlign)
+		if err != nil {
+			Exitf("-funcalign: %v", err)
+		}
+		if align < int32(ctxt.Arch.MinLC) {
+			Exitf("-funcalign: %d is less than the instruction alignment %d of %s", align, ctxt.Arch.MinLC, ctxt.Arch.Name)
+		}
+		if hot && *flagPgo == "" {
+			Exitf("-funcalign=hot:n needs -pgo")
+		}
+		funcAlign, funcAlignHot = align, hot
+	}
+	if len(flagPostLinkTool) > 0 {
+		addPostLinkPass("post-link-tool", postLinkTool)
+	}
+	if *flagBTF {
+		if !ctxt.IsELF {
+			Exitf("-btf is only supported for ELF")
+		}
+		if *FlagW {
+			Exitf("-btf needs DWARF, which -w or -s omits")
+		}
+	}
+	if *flagProvenance != "" {
+		addProvenanceSection(ctxt)
+	}
+	if len(addedSections) > 0 {
+		checkAddedSections(ctxt)
+	}
+	if *flagSoname != "" && !ctxt.IsELF {
+		Exitf("-soname is only supported for ELF")
+	}
+	if *flagVersionScript != "" {
+		if !ctxt.IsELF {
+			Exitf("-version-script is only supported for ELF")
+		}
+		script, err := os.ReadFile(*flagVersionScript)
+		if err == nil {
+			elfVersions, err = parseElfVersionScript(string(script))
+		}
+		if err != nil {
+			Exitf("-version-script: %v", err)
+		}
+	}
+	if *flagDebugNames {
+		if ctxt.HeadType == objabi.Haix {
+			Exitf("-debugnames is not supported for aix")
+		}
+		if *FlagW {
+			Exitf("-debugnames needs DWARF, which -w or -s omits")
+		}
+	}
+	if *flagSplitDwarf != "" {
+		if !ctxt.IsELF && !ctxt.IsDarwin() && !ctxt.IsWindows() {
+			Exitf("-split-dwarf is only supported for ELF, Mach-O and PE")
+		}
+		if *FlagW {
+			Exitf("-split-dwarf needs DWARF, which -w or -s omits")
+		}
+	}
+	if *flagPeDllChars != "" {
+		if !ctxt.IsWindows() {
+			Exitf("-pedllcharacteristics is only supported when linking for windows")
+		}
+		c, err := parsePeDllCharacteristics(*flagPeDllChars)
+		if err == nil {
+			err = c.check(ctxt)
+		}
+		if err != nil {
+			Exitf("-pedllcharacteristics: %v", err)
+		}
+		peDllChars = c
+	}
+	if *flagPeImplib != "" {
+		if !ctxt.IsWindows() || ctxt.BuildMode != BuildModeCShared {
+			Exitf("-peimplib is only supported with -buildmode=c-shared on windows")
+		}
+		addPostLinkPass("peimplib", peImplib)
+	}
+	if *flagPeSubsystem != "" {
+		if !ctxt.IsWindows() {
+			Exitf("-pesubsystemversion is only supported when linking for windows")
+		}
+		major, minor, err := parsePeSubsystemVersion(*flagPeSubsystem)
+		if err != nil {
+			Exitf("-pesubsystemversion: %v", err)
+		}
+		peSubsystemMajor, peSubsystemMinor = major, minor
+	}
+	if len(flagMachoSigner) > 0 {
+		if !ctxt.IsDarwin() || ctxt.BuildMode == BuildModeCArchive {
+			Exitf("-machosigner requires darwin or ios, and a build mode other than c-archive")
+		}
+		if *flagMachoSignID == "" {
+			Exitf("-machosigner needs the identifier of the signature, given by -machosignid")
+		}
+		if *flagAdHocSign || *flagMachoFat != "" {
+			Exitf("-machosigner cannot be combined with -adhocsign or -machofat")
+		}
+		// The last pass, as the signature must cover the changes of
+		// the others.
+		addPostLinkPass("machosigner", machoSignPass)
+	} else if *flagMachoSignID != "" || *flagMachoSignTeam != "" {
+		Exitf("-machosignid and -machosignteam need -machosigner")
+	}
+	if (*flagMachoSDK != "" || len(flagMachoStubs) > 0) && !ctxt.IsDarwin() {
+		Exitf("-machosdk and -machostub are only supported when linking for darwin or ios")
+	}
+	if len(flagRsrc) > 0 {
+		readRsrcFiles(ctxt)
+	}
+	if exportsRestricted() {
+		readExportSymbols(ctxt)
+	}
+	if *flagPlatformVersion != "" {
+		if !ctxt.IsDarwin() {
+			Exitf("-platform-version is only supported when linking for darwin or ios")
+		}
+		v, err := machoParsePlatformVersion(*flagPlatformVersion, buildcfg.GOOS)
+		if err != nil {
+			Exitf("-platform-version: %v", err)
+		}
+		machoPlatformFlag = v
+	}
+	switch *flagMachoFixups {
+	case "auto", "opcodes":
+	case "chained":
+		if !ctxt.IsDarwin() {
+			Exitf("-machofixups is only supported when linking for darwin or ios")
+		}
+	default:
+		Exitf("-machofixups=%s: want auto, chained or opcodes", *flagMachoFixups)
+	}
+	if *flagUuidPerCpu {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidpercpu is only supported when linking for darwin or ios")
+		}
+		if forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64 {
+			Exitf("-uuidpercpu requires the Mach-O UUID to be derived from the build ID")
+		}
+	}
+	if *flagUuidContent {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidcontent is only supported when linking for darwin or ios")
+		}
+		if forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64 {
+			Exitf("-uuidcontent requires the Mach-O UUID to be derived from the build ID")
+		}
+	}
+	if *flagUuidPgo != "" {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidpgo is only supported when linking for darwin or ios")
+		}
+		if forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64 {
+			Exitf("-uuidpgo requires the Mach-O UUID to be derived from the build ID")
+		}
+	}
+	if *flagUuidImage != "" {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidimage is only supported when linking for darwin or ios")
+		}
+		if forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64 {
+			Exitf("-uuidimage requires the Mach-O UUID to be derived from the build ID")
+		}
+	}
+	if *flagUuidStore != "" {
+		if !ctxt.IsDarwin() {
+			Exitf("-uuidstore is only supported when linking for darwin or ios")
+		}
+		if *flagBuildid == "" || forcedUuid != nil || uuidCodeSections != nil || *flagUuidLd64 || *flagUuidContent || *flagUuidPerCpu || *flagUuidPgo != "" || *flagUuidImage != "" {
+			Exitf("-uuidstore requires the Mach-O UUID to be derived from the build ID alone")
+		}
+		if *flagUuidVerify {
+			Exitf("-uuidstore cannot be combined with -uuidverify")
+		}
+		u, err := machoStoreUuid(*flagUuidStore, *flagBuildid, uuidFromGoBuildId(*flagBuildid))
+		if err != nil {
+			Exitf("-uuidstore: %v", err)
+		}
+		forcedUuid = u
+	}
+	if forcedUuid != nil {
+		// Internal linking writes buildinfo as the LC_UUID payload.
+		buildinfo = forcedUuid
+	}
+	if *flagUuidManifest != "" {
+		if !ctxt.IsDarwin() || !ctxt.IsExternal() || ctxt.BuildMode == BuildModeCArchive {
+			Exitf("-uuidmanifest requires external linking of an executable or shared library for darwin or ios")
+		}
+		if *flagUuidPatch != "" || *flagUuidVerify {
+			Exitf("-uuidmanifest cannot be combined with -uuidpatch or -uuidverify")
+		}
+	}
+	if *flagUuidManifestMin && *flagUuidManifest == "" {
+		Exitf("-uuidmanifestmin requires -uuidmanifest")
+	}
+	if *flagUuidPatch != "" && (!ctxt.IsDarwin() || !ctxt.IsExternal()) {
+		Exitf("-uuidpatch requires external linking for darwin or ios")
+	}
+	if *flagUuidInsert {
+		if !ctxt.IsDarwin() || !ctxt.IsExternal() {
+			Exitf("-uuidinsert requires external linking for darwin or ios")
+		}
+		if *flagUuidLd64 || !machoLinkSetsUuid() || !machoHaveOutputUuid() {
+			Exitf("-uuidinsert requires the link to set the Mach-O UUID, and cannot be combined with -uuidld64")
+		}
+	}
+	if *flagReproReport != "" {
+		if !ctxt.IsDarwin() || !ctxt.IsExternal() {
+			Exitf("-reproreport requires external linking for darwin or ios")
+		}
+		if err := machoCheckReproReportFormat(*flagReproReportFormat); err != nil {
+			Exitf("-reproreportformat: %v", err)
+		}
+	}
+	checkArchiveFlags(ctxt)
+	checkSizeBudgetFlags(ctxt)
+	if *flagVerifyRepro {
+		// The last pass, as it compares the outputs of all the others.
+		addPostLinkPass("verify-reproducible", verifyReproducible)
+	}
+
+	if *flagIncremental != "" {
+		bench.Start("incremental")
+		if ctxt.incrementalRelink() {
+			ctxt.Bso.Flush()
+			errorexit()
+		}
+	}
+
 	bench.Start("inittasks")
 	ctxt.inittasks()
 
+	if *flagDisallowPackages != "" {
+		readDisallowedPackages(*flagDisallowPackages)
+	}
 	bench.Start("deadcode")
 	deadcode(ctxt)
 
 	bench.Start("linksetup")
 	ctxt.linksetup()
+	// linksetup decides whether an internally linked executable is
+	// static.
+	if (*flagSoname != "" || *flagVersionScript != "") && ctxt.IsInternal() && *FlagD {
+		Exitf("-soname and -version-script need a dynamically linked output, not a static one")
+	}
+
+	if *flagICF != "none" {
+		bench.Start("icf")
+		ctxt.icf()
+	}
 
 	bench.Start("dostrdata")
 	ctxt.dostrdata()
@@ -414,6 +1051,10 @@ func Main(arch *sys.Arch, theArch Arch) {
 
 	bench.Start("textaddress")
 	ctxt.textaddress()
+	if peGuardCF(ctxt) {
+		bench.Start("addGuardCF")
+		ctxt.addGuardCF()
+	}
 	bench.Start("typelink")
 	ctxt.typelink()
 	bench.Start("buildinfo")
@@ -427,14 +1068,33 @@ func Main(arch *sys.Arch, theArch Arch) {
 	dwarfGenerateDebugSyms(ctxt)
 	bench.Start("symtab")
 	symGroupType := ctxt.symtab(pclnState)
+	if *flagCref != "" {
+		bench.Start("cref")
+		cref(ctxt)
+	}
 	bench.Start("dodata")
 	ctxt.dodata(symGroupType)
 	bench.Start("address")
 	order := ctxt.address()
+	if ctxt.IsELF {
+		elfWriteRelr(ctxt)
+	}
 	bench.Start("dwarfcompress")
 	dwarfcompress(ctxt)
 	bench.Start("layout")
 	filesize := ctxt.layout(order)
+	if *flagM || *flagLinkMap != "" {
+		bench.Start("linkmap")
+		linkMap(ctxt, order)
+	}
+	if *flagJSONReport != "" {
+		bench.Start("jsonReport")
+		jsonReport(ctxt, order)
+	}
+	if sizeBudgetsSet() {
+		bench.Start("layoutSizeReport")
+		layoutSizeReport(ctxt, order)
+	}
 
 	// Write out the output file.
 	// It is split into two parts (Asmb and Asmb2). The first
@@ -445,15 +1105,16 @@ func Main(arch *sys.Arch, theArch Arch) {
 	if ctxt.Arch.Family != sys.Wasm {
 		// Don't mmap if we're building for Wasm. Wasm file
 		// layout is very different so filesize is meaningless.
-		if err := ctxt.Out.Mmap(filesize); err != nil {
-			Exitf("mapping output file failed: %v", err)
-		}
+		ctxt.mmapOutput(filesize)
 	}
 	// asmb will redirect symbols to the output file mmap, and relocations
 	// will be applied directly there.
 	bench.Start("Asmb")
 	asmb(ctxt)
 
+	if *flagUndefs {
+		ctxt.reportUnresolved()
+	}
 	exitIfErrors()
 
 	// Generate additional symbols for the native symbol table just prior
@@ -471,6 +1132,68 @@ func Main(arch *sys.Arch, theArch Arch) {
 
 	bench.Start("hostlink")
 	ctxt.hostlink()
+	if *flagSplitDwarf != "" {
+		bench.Start("splitDwarf")
+		splitDwarf(ctxt)
+	}
+	if *flagUuidOut != "" && ctxt.BuildMode != BuildModeCArchive {
+		if err := machoWriteUuidFile(*flagUuidOut, *flagOutfile); err != nil {
+			Exitf("writing -uuidout file failed: %v", err)
+		}
+	}
+	if *flagUuidJSON && ctxt.BuildMode != BuildModeCArchive {
+		if err := machoWriteUuidJSON(os.Stdout, *flagOutfile); err != nil {
+			Exitf("-uuidjson: %v", err)
+		}
+	}
+	if *flagUuidExpl && ctxt.BuildMode != BuildModeCArchive {
+		if err := machoWriteUuidExplanation(os.Stdout, *flagOutfile); err != nil {
+			Exitf("-uuidexplain: %v", err)
+		}
+	}
+	if *flagUuidInfo && ctxt.BuildMode != BuildModeCArchive {
+		if err := machoWriteUuidInfo(*flagOutfile); err != nil {
+			Exitf("writing -uuidinfo file failed: %v", err)
+		}
+	}
+	if *flagReproBuildinfo && ctxt.BuildMode != BuildModeCArchive {
+		if err := machoWriteReproBuildinfo(*flagOutfile, reproPassResults); err != nil {
+			Exitf("writing -reprobuildinfo file failed: %v", err)
+		}
+	}
+	if *flagUuidVerify && ctxt.BuildMode != BuildModeCArchive {
+		if err := machoVerifyUuid(*flagOutfile); err != nil {
+			Exitf("-uuidverify: %v", err)
+		}
+	}
+	if *flagUuidManifest != "" {
+		if err := machoWriteUuidManifest(*flagUuidManifest, *flagOutfile); err != nil {
+			Exitf("writing -uuidmanifest file failed: %v", err)
+		}
+	}
+	if *flagUuidPatch != "" && ctxt.BuildMode != BuildModeCArchive {
+		if err := machoWritePatchFile(*flagUuidPatch); err != nil {
+			Exitf("writing -uuidpatch file failed: %v", err)
+		}
+	}
+	if *flagReproReport != "" && ctxt.BuildMode != BuildModeCArchive {
+		patches := append(uuidPatches[:len(uuidPatches):len(uuidPatches)], reproPatches...)
+		if err := machoAppendReproReport(*flagReproReport, *flagReproReportFormat, *flagOutfile, patches, reproPassResults); err != nil {
+			Exitf("writing -reproreport file failed: %v", err)
+		}
+	}
+	if *flagMachoFat != "" {
+		// Last, so that the records above describe this link's slice
+		// alone.
+		if err := machoMergeFat(*flagOutfile, strings.Split(*flagMachoFat, ",")); err != nil {
+			Exitf("-machofat: %v", err)
+		}
+		if *flagReproducibleMtime {
+			if err := machoSetOutputTimes(*flagOutfile); err != nil {
+				Exitf("%v", err)
+			}
+		}
+	}
 	if ctxt.Debugvlog != 0 {
 		ctxt.Logf("%s", ctxt.loader.Stat())
 		ctxt.Logf("%d liveness data\n", liveness)
@@ -479,7 +1202,24 @@ func Main(arch *sys.Arch, theArch Arch) {
 	ctxt.Bso.Flush()
 	bench.Start("archive")
 	ctxt.archive()
-	bench.Report(os.Stdout)
+	if len(postLinkPasses) > 0 {
+		bench.Start("postLinkPasses")
+		runPostLinkPasses(ctxt)
+	}
+	if sizeBudgetsSet() {
+		bench.Start("checkSizeBudgets")
+		ctxt.checkSizeBudgets()
+	}
+	if *flagIncremental != "" {
+		bench.Start("writeIncrementalState")
+		ctxt.writeIncrementalState()
+	}
+	if *flagTrace != "" {
+		finishLinkTrace(ctxt, bench)
+	}
+	if len(*benchmarkFlag) != 0 {
+		bench.Report(os.Stdout)
+	}
 
 	errorexit()
 }
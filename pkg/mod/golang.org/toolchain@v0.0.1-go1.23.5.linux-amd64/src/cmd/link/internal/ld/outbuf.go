@@ -37,6 +37,10 @@ const outbufMode = 0775
 //     backed buffer that will get synced to disk.
 //   - Munmap the output file
 //
+// If the output file cannot be mapped, or -streamout is given, it instead
+// writes the output file as a stream with bounded memory (see
+// outbuf_stream.go).
+//
 // And finally, it provides a mechanism by which you can multithread the
 // writing of output files. This mechanism is accomplished by copying a OutBuf,
 // and using it in the thread/goroutine.
@@ -70,6 +74,10 @@ type OutBuf struct {
 	f      *os.File
 	encbuf [8]byte // temp buffer used by WriteN methods
 	isView bool    // true if created from View()
+
+	stream     bool  // written as a stream, with heap as its window
+	winOff     int64 // offset of the window in the file
+	streamSize int64 // size of the file written so far
 }
 
 func (out *OutBuf) Open(name string) error {
@@ -95,6 +103,9 @@ func NewOutBuf(arch *sys.Arch) *OutBuf {
 var viewError = errors.New("output not mmapped")
 
 func (out *OutBuf) View(start uint64) (*OutBuf, error) {
+	if out.stream {
+		return nil, viewError
+	}
 	return &OutBuf{
 		arch:   out.arch,
 		name:   out.name,
@@ -119,7 +130,9 @@ func (out *OutBuf) Close() error {
 	if out.f == nil {
 		return nil
 	}
-	if len(out.heap) != 0 {
+	if out.stream {
+		out.flushStream()
+	} else if len(out.heap) != 0 {
 		if _, err := out.f.Write(out.heap); err != nil {
 			return err
 		}
@@ -151,7 +164,12 @@ func (out *OutBuf) isMmapped() bool {
 }
 
 // Data returns the whole written OutBuf as a byte slice.
+// It is not supported when the OutBuf is streamed; use ReadAt and
+// WriteAt instead.
 func (out *OutBuf) Data() []byte {
+	if out.stream {
+		panic("Data of a streamed OutBuf")
+	}
 	if out.isMmapped() {
 		out.copyHeap()
 		return out.buf
@@ -188,6 +206,9 @@ const maxOutBufHeapLen = 10 << 20
 // writing. When the mmapped section is full, we switch over the heap memory
 // for writing.
 func (out *OutBuf) writeLoc(lenToWrite int64) (int64, []byte) {
+	if out.stream {
+		return out.streamLoc(lenToWrite)
+	}
 	// See if we have enough space in the mmaped area.
 	bufLen := int64(len(out.buf))
 	if out.off+lenToWrite <= bufLen {
@@ -0,0 +1,164 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the streamed mode of OutBuf, for builders with
+// little memory or file systems that cannot map files, which -streamout
+// asks for and which is used when the output file cannot be mapped.
+// Rather than mapping the whole output, the OutBuf writes it through a
+// window of bounded size, a heap buffer that holds the bytes written
+// last, contiguous in the file, and that is written to the file when a
+// write falls outside it. Writes that seek elsewhere, as those of the
+// headers do once the sections are written, start a new window.
+//
+// A symbol written by WriteSym is edited in the window, by relocation
+// or by its generator, before the next write, so each window is a new
+// buffer: the data of a generator symbol, which is set to the window,
+// stays valid once the window is written. Views are not supported, so
+// the output is written by a single goroutine, and the fixups that
+// read and edit the output once it is written use ReadAt and WriteAt.
+
+import (
+	"errors"
+)
+
+// streamWindowSize is the size of the window of a streamed OutBuf,
+// which only grows beyond it to hold a single larger write.
+const streamWindowSize = 4 << 20
+
+// MmapOrStream maps the output file with the given size, as Mmap does,
+// unless stream is set or the file cannot be mapped, in which case the
+// file is written as a stream from then on. It returns the error of
+// mapping the file, if the file is streamed because of it.
+func (out *OutBuf) MmapOrStream(filesize uint64, stream bool) error {
+	if out.stream {
+		out.startStream(filesize, 0)
+		return nil
+	}
+	// The heap holds the data that follows the mapped area, if any,
+	// which Mmap unmaps before it fails.
+	heapOff := int64(len(out.buf))
+	var err error
+	if !stream {
+		if err = out.Mmap(filesize); err == nil {
+			return nil
+		}
+	}
+	out.startStream(filesize, heapOff)
+	return err
+}
+
+// startStream makes out a streamed OutBuf of at least the given size,
+// writing the data of its heap, which is at heapOff in the file, and
+// any mapped data to the file.
+func (out *OutBuf) startStream(filesize uint64, heapOff int64) {
+	if out.stream {
+		out.flushStream()
+	} else {
+		if out.isMmapped() {
+			out.purgeSignatureCache()
+			out.munmap()
+		}
+		if len(out.heap) != 0 {
+			if _, err := out.f.WriteAt(out.heap, heapOff); err != nil {
+				Exitf("writing output file failed: %v", err)
+			}
+		}
+		out.stream = true
+		out.heap = nil
+		out.winOff = out.off
+		fi, err := out.f.Stat()
+		if err != nil {
+			Exitf("writing output file failed: %v", err)
+		}
+		out.streamSize = fi.Size()
+	}
+	if int64(filesize) > out.streamSize {
+		if err := out.f.Truncate(int64(filesize)); err != nil {
+			Exitf("resize output file failed: %v", err)
+		}
+		out.streamSize = int64(filesize)
+	}
+}
+
+// streamLoc is writeLoc for a streamed OutBuf. It writes the window to
+// the file and starts a new one at the offset if the write does not
+// continue or overwrite the window, or does not fit in it.
+func (out *OutBuf) streamLoc(lenToWrite int64) (int64, []byte) {
+	pos := out.off - out.winOff
+	winLen := int64(len(out.heap))
+	if pos < 0 || pos > winLen || (winLen != 0 && pos+lenToWrite > streamWindowSize) {
+		out.flushStream()
+		pos = 0
+		winLen = 0
+	}
+	if lenNeeded := pos + lenToWrite; lenNeeded > winLen {
+		if out.heap == nil {
+			c := int64(streamWindowSize)
+			if lenNeeded > c {
+				c = lenNeeded
+			}
+			out.heap = make([]byte, 0, c)
+		}
+		out.heap = append(out.heap, make([]byte, lenNeeded-winLen)...)
+	}
+	return pos, out.heap
+}
+
+// flushStream writes the window of a streamed OutBuf to the file and
+// starts a new, empty one at the offset.
+func (out *OutBuf) flushStream() {
+	if len(out.heap) != 0 {
+		if _, err := out.f.WriteAt(out.heap, out.winOff); err != nil {
+			Exitf("writing output file failed: %v", err)
+		}
+		if end := out.winOff + int64(len(out.heap)); end > out.streamSize {
+			out.streamSize = end
+		}
+	}
+	out.heap = nil
+	out.winOff = out.off
+}
+
+var errReadPastEnd = errors.New("read past the end of the output")
+
+// ReadAt reads len(p) bytes of the output written at off, leaving the
+// offset where it is.
+func (out *OutBuf) ReadAt(p []byte, off int64) (int, error) {
+	if out.stream {
+		out.flushStream()
+		return out.f.ReadAt(p, off)
+	}
+	data := out.Data()
+	if off+int64(len(p)) > int64(len(data)) {
+		return 0, errReadPastEnd
+	}
+	return copy(p, data[off:]), nil
+}
+
+// WriteAt writes p to the output at off, which is already written,
+// leaving the offset where it is.
+func (out *OutBuf) WriteAt(p []byte, off int64) (int, error) {
+	if out.stream {
+		out.flushStream()
+		return out.f.WriteAt(p, off)
+	}
+	data := out.Data()
+	if off+int64(len(p)) > int64(len(data)) {
+		return 0, errReadPastEnd
+	}
+	return copy(data[off:], p), nil
+}
+
+// Size returns the size of the output written so far.
+func (out *OutBuf) Size() int64 {
+	if out.stream {
+		if end := out.winOff + int64(len(out.heap)); end > out.streamSize {
+			return end
+		}
+		return out.streamSize
+	}
+	return int64(len(out.Data()))
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestOutBufStream writes a file through a streamed OutBuf, seeking
+// back and forth and reading and editing what is written, and checks
+// the file and the size of the window.
+func TestOutBufStream(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out")
+	out := NewOutBuf(nil)
+	if err := out.Open(name); err != nil {
+		t.Fatal(err)
+	}
+	defer out.ErrorClose()
+
+	const size = 3*streamWindowSize + 100
+	want := make([]byte, size+10)
+	out.Write([]byte("head")) // written before the output is streamed
+	copy(want, "head")
+	if err := out.MmapOrStream(size, true); err != nil {
+		t.Fatal(err)
+	}
+	if !out.stream || out.isMmapped() {
+		t.Fatal("OutBuf is not streamed")
+	}
+	if _, err := out.View(0); err == nil {
+		t.Error("View of a streamed OutBuf succeeded")
+	}
+
+	// Write the file in chunks, editing each as relocation does.
+	chunk := make([]byte, 1000)
+	for off := int64(100); off+int64(len(chunk)) <= size; off += int64(len(chunk)) {
+		for i := range chunk {
+			chunk[i] = byte(off/1000 + int64(i))
+		}
+		out.SeekSet(off)
+		pos, buf := out.writeLoc(int64(len(chunk)))
+		copy(buf[pos:], chunk)
+		out.off += int64(len(chunk))
+		buf[pos] = 0xff
+		copy(want[off:], chunk)
+		want[off] = 0xff
+		if len(out.heap) > streamWindowSize {
+			t.Fatalf("window of %d bytes, more than %d", len(out.heap), streamWindowSize)
+		}
+	}
+
+	// Seek back to the header, and write past the size.
+	out.SeekSet(0)
+	out.WriteString("HEAD")
+	copy(want, "HEAD")
+	out.SeekSet(size)
+	out.Write(bytes.Repeat([]byte{1}, 10))
+	copy(want[size:], bytes.Repeat([]byte{1}, 10))
+
+	var b [4]byte
+	if _, err := out.ReadAt(b[:], 0); err != nil || string(b[:]) != "HEAD" {
+		t.Errorf("ReadAt(0) = %q, %v, want HEAD", b, err)
+	}
+	if _, err := out.WriteAt([]byte("fix"), 2000); err != nil {
+		t.Fatal(err)
+	}
+	copy(want[2000:], "fix")
+	out.Write([]byte{2})
+	want = append(want, 2)
+	if got := out.Size(); got != int64(len(want)) {
+		t.Errorf("Size() = %d, want %d", got, len(want))
+	}
+
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("streamed file differs from the one written")
+	}
+}
+
+func TestStreamOut(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "main")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-streamout", "-o", exe, src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	out, err := testenv.Command(t, exe).CombinedOutput()
+	if err != nil || string(out) != "hello\n" {
+		t.Errorf("%s: %q, %v, want hello", exe, out, err)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"internal/buildcfg"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -301,7 +302,6 @@ type Dll struct {
 }
 
 var (
-	rsrcsyms    []loader.Sym
 	PESECTHEADR int32
 	PEFILEHEADR int32
 	pe64        int
@@ -837,6 +837,9 @@ func (f *peFile) writeSymbols(ctxt *Link) {
 	// Add text symbols.
 	for _, s := range ctxt.Textp {
 		addsym(s)
+		for _, a := range ctxt.icfAliases[s] {
+			addsym(a)
+		}
 	}
 
 	shouldBeInSymbolTable := func(s loader.Sym) bool {
@@ -995,10 +998,10 @@ func (f *peFile) writeOptionalHeader(ctxt *Link) {
 	oh.MajorImageVersion = 1
 	oh64.MinorImageVersion = 0
 	oh.MinorImageVersion = 0
-	oh64.MajorSubsystemVersion = PeMinimumTargetMajorVersion
-	oh.MajorSubsystemVersion = PeMinimumTargetMajorVersion
-	oh64.MinorSubsystemVersion = PeMinimumTargetMinorVersion
-	oh.MinorSubsystemVersion = PeMinimumTargetMinorVersion
+	oh64.MajorSubsystemVersion = peSubsystemMajor
+	oh.MajorSubsystemVersion = peSubsystemMajor
+	oh64.MinorSubsystemVersion = peSubsystemMinor
+	oh.MinorSubsystemVersion = peSubsystemMinor
 	oh64.SizeOfImage = f.nextSectOffset
 	oh.SizeOfImage = f.nextSectOffset
 	oh64.SizeOfHeaders = uint32(PEFILEHEADR)
@@ -1030,6 +1033,10 @@ func (f *peFile) writeOptionalHeader(ctxt *Link) {
 		oh64.DllCharacteristics |= pe.IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA
 	}
 
+	// Apply -pedllcharacteristics.
+	oh64.DllCharacteristics = oh64.DllCharacteristics&^peDllChars.clear | peDllChars.set
+	oh.DllCharacteristics = oh.DllCharacteristics&^peDllChars.clear | peDllChars.set
+
 	// Disable stack growth as we don't want Windows to
 	// fiddle with the thread stack limits, which we set
 	// ourselves to circumvent the stack checks in the
@@ -1650,17 +1657,20 @@ func (ctxt *Link) dope() {
 	writeSEH(ctxt)
 }
 
-func setpersrc(ctxt *Link, syms []loader.Sym) {
-	if len(rsrcsyms) != 0 {
-		Errorf(nil, "too many .rsrc sections")
-	}
-	rsrcsyms = syms
+// setpersrc records the .rsrc sections syms of the host object pn.
+func setpersrc(ctxt *Link, pn string, syms []loader.Sym) {
+	rsrcObjs = append(rsrcObjs, rsrcObj{pn, syms})
 }
 
 func addpersrc(ctxt *Link) {
-	if len(rsrcsyms) == 0 {
+	if res, ok := mergedResources(ctxt); ok {
+		addMergedRsrc(ctxt, res)
+		return
+	}
+	if len(rsrcObjs) == 0 {
 		return
 	}
+	rsrcsyms := rsrcObjs[0].syms
 
 	var size int64
 	for _, rsrcsym := range rsrcsyms {
@@ -1744,6 +1754,9 @@ func asmbPe(ctxt *Link) {
 
 	pefile.addSEH(ctxt)
 	pefile.addDWARF()
+	if *flagSplitDwarf != "" {
+		pefile.addDebuglink(filepath.Base(*flagSplitDwarf))
+	}
 
 	if ctxt.LinkMode == LinkExternal {
 		pefile.ctorsSect = pefile.addInitArray(ctxt)
@@ -1757,6 +1770,10 @@ func asmbPe(ctxt *Link) {
 	}
 	pefile.writeSymbolTableAndStringTable(ctxt)
 	addpersrc(ctxt)
+	if ctxt.LinkMode != LinkExternal {
+		pefile.addDllCharacteristicsEx(ctxt)
+		pefile.setLoadConfigDirectory(ctxt)
+	}
 	if ctxt.LinkMode == LinkExternal {
 		pefile.emitRelocations(ctxt)
 	}
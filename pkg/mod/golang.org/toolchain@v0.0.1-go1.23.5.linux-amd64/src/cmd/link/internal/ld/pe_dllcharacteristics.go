@@ -0,0 +1,163 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	IMAGE_DEBUG_TYPE_EX_DLLCHARACTERISTICS   = 20
+	IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT   = 0x0001
+	peDebugDirectoryEntrySize                = 28 // IMAGE_DEBUG_DIRECTORY
+	peDllCharacteristicsExDebugDirectorySize = peDebugDirectoryEntrySize + 4
+)
+
+// peDllCharacteristicNames are the DLL characteristics that
+// -pedllcharacteristics sets, with the options that set and clear them
+// in the GNU ld and lld MinGW drivers. guardcf, which only lld has an
+// option for, needs the Control Flow Guard metadata of pe_guardcf.go.
+// cetcompat is an extended DLL characteristic, which a debug directory
+// entry holds.
+var peDllCharacteristicNames = []struct {
+	name    string
+	bit     uint16
+	ex      uint32
+	on, off string
+}{
+	{"dynamicbase", pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE, 0, "--dynamicbase", "--disable-dynamicbase"},
+	{"highentropyva", pe.IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA, 0, "--high-entropy-va", "--disable-high-entropy-va"},
+	{"nxcompat", pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT, 0, "--nxcompat", "--disable-nxcompat"},
+	{"tsaware", pe.IMAGE_DLLCHARACTERISTICS_TERMINAL_SERVER_AWARE, 0, "--tsaware", "--disable-tsaware"},
+	{"guardcf", pe.IMAGE_DLLCHARACTERISTICS_GUARD_CF, 0, "--guard-cf", "--no-guard-cf"},
+	{"cetcompat", 0, IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT, "", ""},
+}
+
+// A peDllCharacteristics is the -pedllcharacteristics setting: the DLL
+// characteristics to set and to clear, in the order given.
+type peDllCharacteristics struct {
+	set, clear uint16
+	ex         uint32   // extended DLL characteristics to set
+	hostArgs   []string // for the external linker, in the order given
+}
+
+var (
+	// peDllChars is the -pedllcharacteristics setting.
+	peDllChars peDllCharacteristics
+
+	// peSubsystemMajor and peSubsystemMinor are the subsystem version
+	// that -pesubsystemversion sets.
+	peSubsystemMajor uint16 = PeMinimumTargetMajorVersion
+	peSubsystemMinor uint16 = PeMinimumTargetMinorVersion
+)
+
+// parsePeDllCharacteristics parses a -pedllcharacteristics setting, a
+// comma-separated list of DLL characteristics to set, each prefixed with
+// "no" to clear it instead.
+func parsePeDllCharacteristics(s string) (peDllCharacteristics, error) {
+	var c peDllCharacteristics
+	for _, name := range strings.Split(s, ",") {
+		off := false
+		if n, ok := strings.CutPrefix(name, "no"); ok {
+			name, off = n, true
+		}
+		found := false
+		for _, p := range peDllCharacteristicNames {
+			if p.name != name {
+				continue
+			}
+			found = true
+			if p.ex != 0 {
+				if off {
+					return c, fmt.Errorf("cannot clear %s, which is never set by default", name)
+				}
+				c.ex |= p.ex
+				continue
+			}
+			if off {
+				c.clear |= p.bit
+				c.set &^= p.bit
+				c.hostArgs = append(c.hostArgs, "-Wl,"+p.off)
+			} else {
+				c.set |= p.bit
+				c.clear &^= p.bit
+				c.hostArgs = append(c.hostArgs, "-Wl,"+p.on)
+			}
+		}
+		if !found {
+			var names []string
+			for _, p := range peDllCharacteristicNames {
+				names = append(names, p.name)
+			}
+			return c, fmt.Errorf("unknown DLL characteristic %q; use %s, each optionally prefixed with no", name, strings.Join(names, ", "))
+		}
+	}
+	return c, nil
+}
+
+// check reports whether the link can have the DLL characteristics c.
+func (c *peDllCharacteristics) check(ctxt *Link) error {
+	if c.set&pe.IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA != 0 && ctxt.Arch.PtrSize != 8 {
+		return fmt.Errorf("highentropyva needs a 64-bit image")
+	}
+	if c.set&pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE != 0 && ctxt.IsInternal() && !needPEBaseReloc(ctxt) {
+		return fmt.Errorf("dynamicbase needs base relocations, which only -buildmode=pie writes for %s", ctxt.Arch.Name)
+	}
+	if c.ex&IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT != 0 {
+		if !ctxt.Is386() && !ctxt.IsAMD64() {
+			return fmt.Errorf("cetcompat is only supported for 386 and amd64")
+		}
+		if ctxt.IsExternal() {
+			return fmt.Errorf("cetcompat is not supported when linking externally, as the GNU ld and lld MinGW drivers have no option for it")
+		}
+	}
+	return nil
+}
+
+// parsePeSubsystemVersion parses a -pesubsystemversion setting,
+// major.minor, which must not be older than the oldest Windows Go
+// supports.
+func parsePeSubsystemVersion(s string) (major, minor uint16, err error) {
+	ma, mi, ok := strings.Cut(s, ".")
+	x, err1 := strconv.ParseUint(ma, 10, 16)
+	y, err2 := strconv.ParseUint(mi, 10, 16)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("malformed version %q; want major.minor", s)
+	}
+	if x < PeMinimumTargetMajorVersion || x == PeMinimumTargetMajorVersion && y < PeMinimumTargetMinorVersion {
+		return 0, 0, fmt.Errorf("version %s is older than %d.%d, the oldest Go supports", s, PeMinimumTargetMajorVersion, PeMinimumTargetMinorVersion)
+	}
+	return uint16(x), uint16(y), nil
+}
+
+// addDllCharacteristicsEx adds the debug directory that holds the
+// extended DLL characteristics of -pedllcharacteristics to the COFF file
+// f, in a section of its own, as GNU ld does for its build ID.
+func (f *peFile) addDllCharacteristicsEx(ctxt *Link) {
+	if peDllChars.ex == 0 {
+		return
+	}
+	size := peDllCharacteristicsExDebugDirectorySize
+	h := f.addSection(".dbgdir", size, size)
+	h.characteristics = IMAGE_SCN_CNT_INITIALIZED_DATA | IMAGE_SCN_MEM_READ
+	h.checkOffset(ctxt.Out.Offset())
+
+	// IMAGE_DEBUG_DIRECTORY, with no time stamp, then its data.
+	var b [peDllCharacteristicsExDebugDirectorySize]byte
+	binary.LittleEndian.PutUint32(b[12:], IMAGE_DEBUG_TYPE_EX_DLLCHARACTERISTICS)
+	binary.LittleEndian.PutUint32(b[16:], 4)
+	binary.LittleEndian.PutUint32(b[20:], h.virtualAddress+peDebugDirectoryEntrySize)
+	binary.LittleEndian.PutUint32(b[24:], h.pointerToRawData+peDebugDirectoryEntrySize)
+	binary.LittleEndian.PutUint32(b[28:], peDllChars.ex)
+	ctxt.Out.Write(b[:])
+	h.pad(ctxt.Out, uint32(size))
+
+	f.dataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG].VirtualAddress = h.virtualAddress
+	f.dataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG].Size = peDebugDirectoryEntrySize
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePeDllCharacteristics(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want peDllCharacteristics
+		ok   bool
+	}{
+		{"cetcompat", peDllCharacteristics{ex: IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT}, true},
+		{"nonxcompat,highentropyva", peDllCharacteristics{
+			set:      pe.IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA,
+			clear:    pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT,
+			hostArgs: []string{"-Wl,--disable-nxcompat", "-Wl,--high-entropy-va"},
+		}, true},
+		{"notsaware,tsaware", peDllCharacteristics{
+			set:      pe.IMAGE_DLLCHARACTERISTICS_TERMINAL_SERVER_AWARE,
+			hostArgs: []string{"-Wl,--disable-tsaware", "-Wl,--tsaware"},
+		}, true},
+		{"dynamicbase,nodynamicbase", peDllCharacteristics{
+			clear:    pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE,
+			hostArgs: []string{"-Wl,--dynamicbase", "-Wl,--disable-dynamicbase"},
+		}, true},
+		{"guardcf", peDllCharacteristics{
+			set:      pe.IMAGE_DLLCHARACTERISTICS_GUARD_CF,
+			hostArgs: []string{"-Wl,--guard-cf"},
+		}, true},
+		{"nocetcompat", peDllCharacteristics{}, false},
+		{"nx", peDllCharacteristics{}, false},
+		{"nxcompat,", peDllCharacteristics{}, false},
+	} {
+		got, err := parsePeDllCharacteristics(tc.s)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%q: got %+v, want error", tc.s, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %+v, %v, want %+v", tc.s, got, err, tc.want)
+		}
+	}
+}
+
+func TestParsePeSubsystemVersion(t *testing.T) {
+	for _, tc := range []struct {
+		s            string
+		major, minor uint16
+		ok           bool
+	}{
+		{"6.1", 6, 1, true},
+		{"6.2", 6, 2, true},
+		{"10.0", 10, 0, true},
+		{"6.0", 0, 0, false},
+		{"5.2", 0, 0, false},
+		{"10", 0, 0, false},
+		{"10.0.1", 0, 0, false},
+		{"65536.0", 0, 0, false},
+	} {
+		major, minor, err := parsePeSubsystemVersion(tc.s)
+		if (err == nil) != tc.ok || major != tc.major || minor != tc.minor {
+			t.Errorf("%q: got %d.%d, %v", tc.s, major, minor, err)
+		}
+	}
+}
+
+// TestPeDllCharacteristicsLink links for windows/amd64 internally with
+// -pedllcharacteristics and -pesubsystemversion and checks the optional
+// header and the debug directory entry of cetcompat.
+func TestPeDllCharacteristicsLink(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for windows")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.exe")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode=pie", "-ldflags=-linkmode=internal -pedllcharacteristics=nohighentropyva,cetcompat -pesubsystemversion=10.0", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=windows", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	f, err := pe.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	oh := f.OptionalHeader.(*pe.OptionalHeader64)
+	want := uint16(pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE | pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT | pe.IMAGE_DLLCHARACTERISTICS_TERMINAL_SERVER_AWARE)
+	if oh.DllCharacteristics != want {
+		t.Errorf("DllCharacteristics %#x, want %#x", oh.DllCharacteristics, want)
+	}
+	if oh.MajorSubsystemVersion != 10 || oh.MinorSubsystemVersion != 0 {
+		t.Errorf("subsystem version %d.%d, want 10.0", oh.MajorSubsystemVersion, oh.MinorSubsystemVersion)
+	}
+
+	dd := oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG]
+	if dd.Size != peDebugDirectoryEntrySize {
+		t.Fatalf("debug directory is %d bytes, want one entry", dd.Size)
+	}
+	img, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range f.Sections {
+		if dd.VirtualAddress < s.VirtualAddress || dd.VirtualAddress >= s.VirtualAddress+s.VirtualSize {
+			continue
+		}
+		e := img[s.Offset+dd.VirtualAddress-s.VirtualAddress:][:peDebugDirectoryEntrySize]
+		typ, size := binary.LittleEndian.Uint32(e[12:]), binary.LittleEndian.Uint32(e[16:])
+		addr, off := binary.LittleEndian.Uint32(e[20:]), binary.LittleEndian.Uint32(e[24:])
+		if typ != IMAGE_DEBUG_TYPE_EX_DLLCHARACTERISTICS || size != 4 {
+			t.Fatalf("debug directory entry of type %d and size %d", typ, size)
+		}
+		if off-s.Offset != addr-s.VirtualAddress {
+			t.Errorf("debug directory data at address %#x but file offset %#x", addr, off)
+		}
+		if got := binary.LittleEndian.Uint32(img[off:]); got != IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT {
+			t.Errorf("extended DLL characteristics %#x, want CET_COMPAT", got)
+		}
+		return
+	}
+	t.Fatalf("debug directory at %#x is in no section", dd.VirtualAddress)
+}
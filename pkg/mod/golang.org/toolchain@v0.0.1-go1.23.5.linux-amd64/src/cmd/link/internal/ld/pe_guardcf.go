@@ -0,0 +1,136 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the Control Flow Guard metadata of PE output, which
+// -pedllcharacteristics=guardcf asks for. Windows checks the targets of
+// the indirect calls of the code built with /guard:cf, such as the
+// callbacks of the system DLLs, against the table of valid targets of
+// each module, so the table lists every function of the output, which
+// the linker knows, and each entry of runtime.callbackasm, which
+// syscall.NewCallback hands out. The Go code makes no checks of its
+// own. The table and the load config directory that points to it are
+// read-only data.
+
+import (
+	"cmd/internal/sys"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"debug/pe"
+	"sort"
+)
+
+const (
+	IMAGE_GUARD_CF_INSTRUMENTED           = 0x00000100
+	IMAGE_GUARD_CF_FUNCTION_TABLE_PRESENT = 0x00000400
+
+	// The sizes of IMAGE_LOAD_CONFIG_DIRECTORY32 and 64 up to and
+	// including GuardFlags, and the offsets of GuardCFFunctionTable
+	// in them.
+	peLoadConfigSize32   = 0x5c
+	peLoadConfigSize64   = 0x94
+	peGuardCFFuncTable32 = 0x50
+	peGuardCFFuncTable64 = 0x80
+)
+
+// peLoadConfig is the load config directory symbol, or 0.
+var peLoadConfig loader.Sym
+
+// peGuardCF reports whether the output has Control Flow Guard metadata.
+func peGuardCF(ctxt *Link) bool {
+	return peDllChars.set&pe.IMAGE_DLLCHARACTERISTICS_GUARD_CF != 0 && ctxt.IsInternal()
+}
+
+// callbackasmEntrySize returns the size of an entry of
+// runtime.callbackasm, as callbackasmAddr in the runtime has it.
+func callbackasmEntrySize(arch *sys.Arch) int64 {
+	if arch.Family == sys.ARM64 || arch.Family == sys.ARM {
+		return 8
+	}
+	return 5
+}
+
+// A guardCFTarget is a valid indirect call target, at off in the
+// function s.
+type guardCFTarget struct {
+	s   loader.Sym
+	off int64
+}
+
+// guardCFTargets returns the valid indirect call targets of the output
+// in address order, without duplicates. It is called once the text is
+// laid out.
+func guardCFTargets(ctxt *Link) []guardCFTarget {
+	ldr := ctxt.loader
+	var targets []guardCFTarget
+	seen := make(map[int64]bool)
+	add := func(s loader.Sym, off int64) {
+		if addr := ldr.SymValue(s) + off; !seen[addr] {
+			seen[addr] = true
+			targets = append(targets, guardCFTarget{s, off})
+		}
+	}
+	callbackasm := ldr.Lookup("runtime.callbackasm", 0)
+	for _, s := range ctxt.Textp {
+		if ldr.SymType(s) != sym.STEXT {
+			continue
+		}
+		add(s, 0)
+		if s == callbackasm {
+			n := callbackasmEntrySize(ctxt.Arch)
+			for off := n; off+n <= ldr.SymSize(s); off += n {
+				add(s, off)
+			}
+		}
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return ldr.SymValue(targets[i].s)+targets[i].off < ldr.SymValue(targets[j].s)+targets[j].off
+	})
+	return targets
+}
+
+// addGuardCF creates the load config directory and the table of valid
+// indirect call targets of Control Flow Guard. It is called once the
+// text is laid out, as the table is sorted by address.
+func (ctxt *Link) addGuardCF() {
+	ldr := ctxt.loader
+	targets := guardCFTargets(ctxt)
+
+	table := ldr.CreateSymForUpdate("go:guardcf.functable", 0)
+	table.SetType(sym.SRODATA)
+	table.SetAlign(4)
+	table.SetReachable(true)
+	for _, t := range targets {
+		table.AddPEImageRelativeAddrPlus(ctxt.Arch, t.s, t.off)
+	}
+
+	size, tableOff, countOff := int64(peLoadConfigSize32), int64(peGuardCFFuncTable32), int64(peGuardCFFuncTable32+4)
+	if ctxt.Arch.PtrSize == 8 {
+		size, tableOff, countOff = peLoadConfigSize64, peGuardCFFuncTable64, peGuardCFFuncTable64+8
+	}
+	lc := ldr.CreateSymForUpdate("go:guardcf.loadconfig", 0)
+	lc.SetType(sym.SRODATA)
+	lc.SetAlign(int32(ctxt.Arch.PtrSize))
+	lc.SetReachable(true)
+	lc.AddBytes(make([]byte, size))
+	lc.SetUint32(ctxt.Arch, 0, uint32(size))
+	if len(targets) > 0 {
+		lc.SetAddr(ctxt.Arch, tableOff, table.Sym())
+	}
+	lc.SetUint(ctxt.Arch, countOff, uint64(len(targets)))
+	lc.SetUint32(ctxt.Arch, size-4, IMAGE_GUARD_CF_INSTRUMENTED|IMAGE_GUARD_CF_FUNCTION_TABLE_PRESENT)
+	peLoadConfig = lc.Sym()
+}
+
+// setLoadConfigDirectory points the load config directory entry of the
+// COFF file f at the load config of Control Flow Guard.
+func (f *peFile) setLoadConfigDirectory(ctxt *Link) {
+	if peLoadConfig == 0 {
+		return
+	}
+	ldr := ctxt.loader
+	f.dataDirectory[pe.IMAGE_DIRECTORY_ENTRY_LOAD_CONFIG].VirtualAddress = uint32(ldr.SymValue(peLoadConfig) - PEBASE)
+	f.dataDirectory[pe.IMAGE_DIRECTORY_ENTRY_LOAD_CONFIG].Size = uint32(ldr.SymSize(peLoadConfig))
+}
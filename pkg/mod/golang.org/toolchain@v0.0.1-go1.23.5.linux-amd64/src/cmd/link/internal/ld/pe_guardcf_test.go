@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPeGuardCF links programs for windows with
+// -pedllcharacteristics=guardcf and checks the load config directory
+// and that the table of valid indirect call targets is sorted and
+// holds the functions and the entries of runtime.callbackasm.
+func TestPeGuardCF(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for windows")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, goarch := range []string{"amd64", "386"} {
+		exe := filepath.Join(dir, goarch+".exe")
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -pedllcharacteristics=guardcf", "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=windows", "GOARCH="+goarch, "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		f, err := pe.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var dd pe.DataDirectory
+		var chars uint16
+		var base uint64
+		wantSize, tableOff := uint32(peLoadConfigSize32), peGuardCFFuncTable32
+		switch oh := f.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			dd, chars, base = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_LOAD_CONFIG], oh.DllCharacteristics, uint64(oh.ImageBase)
+		case *pe.OptionalHeader64:
+			dd, chars, base = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_LOAD_CONFIG], oh.DllCharacteristics, oh.ImageBase
+			wantSize, tableOff = peLoadConfigSize64, peGuardCFFuncTable64
+		}
+		if chars&pe.IMAGE_DLLCHARACTERISTICS_GUARD_CF == 0 {
+			t.Errorf("%s: DllCharacteristics %#x without GUARD_CF", goarch, chars)
+		}
+		if dd.Size != wantSize {
+			t.Fatalf("%s: load config directory of %d bytes, want %d", goarch, dd.Size, wantSize)
+		}
+		read := func(rva, n uint32) []byte {
+			for _, s := range f.Sections {
+				if rva >= s.VirtualAddress && rva+n <= s.VirtualAddress+s.VirtualSize {
+					data, err := s.Data()
+					if err != nil {
+						t.Fatal(err)
+					}
+					return data[rva-s.VirtualAddress:][:n]
+				}
+			}
+			t.Fatalf("%s: %d bytes at %#x are in no section", goarch, n, rva)
+			return nil
+		}
+		lc := read(dd.VirtualAddress, dd.Size)
+		if size := binary.LittleEndian.Uint32(lc); size != wantSize {
+			t.Errorf("%s: load config Size %d, want %d", goarch, size, wantSize)
+		}
+		if flags := binary.LittleEndian.Uint32(lc[wantSize-4:]); flags != IMAGE_GUARD_CF_INSTRUMENTED|IMAGE_GUARD_CF_FUNCTION_TABLE_PRESENT {
+			t.Errorf("%s: GuardFlags %#x", goarch, flags)
+		}
+		var table, count uint64
+		if wantSize == peLoadConfigSize64 {
+			table, count = binary.LittleEndian.Uint64(lc[tableOff:]), binary.LittleEndian.Uint64(lc[tableOff+8:])
+		} else {
+			table, count = uint64(binary.LittleEndian.Uint32(lc[tableOff:])), uint64(binary.LittleEndian.Uint32(lc[tableOff+4:]))
+		}
+		targets := make(map[uint32]bool)
+		data := read(uint32(table-base), uint32(count*4))
+		for i := 0; i < len(data); i += 4 {
+			rva := binary.LittleEndian.Uint32(data[i:])
+			if i > 0 && rva <= binary.LittleEndian.Uint32(data[i-4:]) {
+				t.Fatalf("%s: function table is not sorted at entry %d", goarch, i/4)
+			}
+			targets[rva] = true
+		}
+
+		found := 0
+		for _, s := range f.Symbols {
+			if s.SectionNumber <= 0 {
+				continue
+			}
+			rva := f.Sections[s.SectionNumber-1].VirtualAddress + s.Value
+			switch s.Name {
+			case "main.main":
+				found++
+				if !targets[rva] {
+					t.Errorf("%s: main.main is not a valid target", goarch)
+				}
+			case "runtime.callbackasm", "runtime.callbackasm.abi0":
+				found++
+				for i := uint32(0); i < 3; i++ {
+					if !targets[rva+i*5] {
+						t.Errorf("%s: entry %d of %s is not a valid target", goarch, i, s.Name)
+					}
+				}
+			}
+		}
+		if found != 2 {
+			t.Errorf("%s: found %d of main.main and runtime.callbackasm in the symbol table", goarch, found)
+		}
+	}
+}
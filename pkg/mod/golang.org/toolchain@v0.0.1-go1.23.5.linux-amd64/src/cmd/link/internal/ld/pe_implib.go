@@ -0,0 +1,403 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the writing of the import library of -peimplib,
+// with which MSVC, lld and MinGW link against a c-shared DLL. It is a
+// COFF archive in the short import format of the PE specification, as
+// lib /def and llvm-dlltool write: an import member for each export of
+// the DLL, which the linker using the library turns into a thunk and
+// an import address table entry, and the three objects that give the
+// DLL its entry in the import directory of the program.
+//
+// The exports are read from the DLL once it is linked, so that the
+// library matches the DLL whichever linker wrote it.
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	IMAGE_SYM_CLASS_SECTION = 0x68
+
+	// The types and name types of a short import member.
+	IMPORT_OBJECT_CODE           = 0
+	IMPORT_OBJECT_DATA           = 1
+	IMPORT_OBJECT_NAME           = 1
+	IMPORT_OBJECT_NAME_NO_PREFIX = 2
+
+	peImportObjectHeaderSize   = 20
+	peImportDirectoryEntrySize = 20
+)
+
+// A peExport is an export of a DLL.
+type peExport struct {
+	name string
+	hint uint16 // the index of name in the export name pointer table
+	data bool
+}
+
+// peImplib is the post-link pass of -peimplib, which writes the import
+// library of the DLL at path.
+func peImplib(ctxt *Link, path string) error {
+	f, err := pe.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dll, exports, err := peDLLExports(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("peimplib: %d exports of %s\n", len(exports), dll)
+	}
+	return os.WriteFile(*flagPeImplib, peImportLibrary(f.Machine, dll, exports), 0666)
+}
+
+// peDLLExports returns the name that the export directory of f gives
+// the DLL, and the exports it names, leaving out those forwarded to
+// other DLLs.
+func peDLLExports(f *pe.File) (string, []peExport, error) {
+	var dirs []pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs = oh.DataDirectory[:oh.NumberOfRvaAndSizes]
+	case *pe.OptionalHeader64:
+		dirs = oh.DataDirectory[:oh.NumberOfRvaAndSizes]
+	}
+	if len(dirs) <= pe.IMAGE_DIRECTORY_ENTRY_EXPORT || dirs[pe.IMAGE_DIRECTORY_ENTRY_EXPORT].Size == 0 {
+		return "", nil, fmt.Errorf("no export directory")
+	}
+	dir := dirs[pe.IMAGE_DIRECTORY_ENTRY_EXPORT]
+
+	// at returns the contents of f from the address rva on, and the
+	// section holding them.
+	at := func(rva uint32) ([]byte, *pe.Section, error) {
+		for _, s := range f.Sections {
+			if rva < s.VirtualAddress || rva-s.VirtualAddress >= s.Size {
+				continue
+			}
+			data, err := s.Data()
+			if err != nil {
+				return nil, nil, err
+			}
+			return data[rva-s.VirtualAddress:], s, nil
+		}
+		return nil, nil, fmt.Errorf("address %#x is in no section", rva)
+	}
+	u32 := func(rva uint32) (uint32, error) {
+		b, _, err := at(rva)
+		if err == nil && len(b) < 4 {
+			err = fmt.Errorf("address %#x is out of range", rva)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint32(b), nil
+	}
+	cstring := func(rva uint32) (string, error) {
+		b, _, err := at(rva)
+		if err != nil {
+			return "", err
+		}
+		n := bytes.IndexByte(b, 0)
+		if n < 0 {
+			return "", fmt.Errorf("unterminated string at %#x", rva)
+		}
+		return string(b[:n]), nil
+	}
+
+	var e IMAGE_EXPORT_DIRECTORY
+	b, _, err := at(dir.VirtualAddress)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &e); err != nil {
+		return "", nil, err
+	}
+	dll, err := cstring(e.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	var exports []peExport
+	for i := uint32(0); i < e.NumberOfNames; i++ {
+		nameRVA, err := u32(e.AddressOfNames + 4*i)
+		if err != nil {
+			return "", nil, err
+		}
+		name, err := cstring(nameRVA)
+		if err != nil {
+			return "", nil, err
+		}
+		b, _, err := at(e.AddressOfNameOrdinals + 2*i)
+		if err != nil || len(b) < 2 {
+			return "", nil, fmt.Errorf("no ordinal for export %s", name)
+		}
+		ordinal := uint32(binary.LittleEndian.Uint16(b))
+		if ordinal >= e.NumberOfFunctions {
+			return "", nil, fmt.Errorf("export %s has ordinal %d of %d", name, ordinal, e.NumberOfFunctions)
+		}
+		rva, err := u32(e.AddressOfFunctions + 4*ordinal)
+		if err != nil {
+			return "", nil, err
+		}
+		if rva >= dir.VirtualAddress && rva-dir.VirtualAddress < dir.Size {
+			// A forwarder, to an export of another DLL.
+			continue
+		}
+		_, s, err := at(rva)
+		if err != nil {
+			return "", nil, fmt.Errorf("export %s: %v", name, err)
+		}
+		exports = append(exports, peExport{name, uint16(i), s.Characteristics&IMAGE_SCN_CNT_CODE == 0})
+	}
+	return dll, exports, nil
+}
+
+// A peImplibSection is a section of an object of an import library.
+type peImplibSection struct {
+	name            string
+	characteristics uint32
+	data            []byte
+	relocs          []pe.Reloc
+}
+
+// A peImplibSymbol is a symbol of an object of an import library.
+type peImplibSymbol struct {
+	name  string
+	sect  int16
+	class uint8
+}
+
+// peImplibObject returns a COFF object with the sections and symbols.
+func peImplibObject(machine uint16, sects []peImplibSection, syms []peImplibSymbol) []byte {
+	var buf bytes.Buffer
+	off := uint32(binary.Size(pe.FileHeader{}) + len(sects)*binary.Size(pe.SectionHeader32{}))
+	var hdrs []pe.SectionHeader32
+	for _, s := range sects {
+		h := pe.SectionHeader32{
+			SizeOfRawData:    uint32(len(s.data)),
+			PointerToRawData: off,
+			Characteristics:  s.characteristics,
+		}
+		copy(h.Name[:], s.name)
+		off += uint32(len(s.data))
+		if len(s.relocs) > 0 {
+			h.PointerToRelocations = off
+			h.NumberOfRelocations = uint16(len(s.relocs))
+			off += uint32(len(s.relocs) * 10)
+		}
+		hdrs = append(hdrs, h)
+	}
+	fh := pe.FileHeader{
+		Machine:              machine,
+		NumberOfSections:     uint16(len(sects)),
+		PointerToSymbolTable: off,
+		NumberOfSymbols:      uint32(len(syms)),
+	}
+	if peImplibIs32Bit(machine) {
+		fh.Characteristics = pe.IMAGE_FILE_32BIT_MACHINE
+	}
+	binary.Write(&buf, binary.LittleEndian, &fh)
+	binary.Write(&buf, binary.LittleEndian, hdrs)
+	for _, s := range sects {
+		buf.Write(s.data)
+		for _, r := range s.relocs {
+			binary.Write(&buf, binary.LittleEndian, &r)
+		}
+	}
+	strtab := []byte{0, 0, 0, 0}
+	for _, s := range syms {
+		sym := pe.COFFSymbol{SectionNumber: s.sect, StorageClass: s.class}
+		if len(s.name) <= len(sym.Name) {
+			copy(sym.Name[:], s.name)
+		} else {
+			binary.LittleEndian.PutUint32(sym.Name[4:], uint32(len(strtab)))
+			strtab = append(strtab, s.name...)
+			strtab = append(strtab, 0)
+		}
+		binary.Write(&buf, binary.LittleEndian, &sym)
+	}
+	binary.LittleEndian.PutUint32(strtab, uint32(len(strtab)))
+	buf.Write(strtab)
+	return buf.Bytes()
+}
+
+func peImplibIs32Bit(machine uint16) bool {
+	return machine == pe.IMAGE_FILE_MACHINE_I386 || machine == pe.IMAGE_FILE_MACHINE_ARMNT
+}
+
+// peImportLibrary returns the import library of the exports of the
+// DLL named dll, for the machine.
+func peImportLibrary(machine uint16, dll string, exports []peExport) []byte {
+	lib := strings.TrimSuffix(dll, filepath.Ext(dll))
+	descName := "__IMPORT_DESCRIPTOR_" + lib
+	nullDescName := "__NULL_IMPORT_DESCRIPTOR"
+	nullThunkName := "\x7f" + lib + "_NULL_THUNK_DATA"
+	var relType uint16
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		relType = IMAGE_REL_I386_DIR32NB
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		relType = IMAGE_REL_AMD64_ADDR32NB
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		relType = IMAGE_REL_ARM_ADDR32NB
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		relType = IMAGE_REL_ARM64_ADDR32NB
+	}
+	ptrAlign, ptrSize := uint32(IMAGE_SCN_ALIGN_8BYTES), 8
+	if peImplibIs32Bit(machine) {
+		ptrAlign, ptrSize = IMAGE_SCN_ALIGN_4BYTES, 4
+	}
+	const rw = IMAGE_SCN_CNT_INITIALIZED_DATA | IMAGE_SCN_MEM_READ | IMAGE_SCN_MEM_WRITE
+
+	type member struct {
+		data []byte
+		syms []string
+	}
+	dllName := append([]byte(dll), 0)
+	if len(dllName)%2 != 0 {
+		dllName = append(dllName, 0)
+	}
+	members := []member{
+		// The entry of the DLL in the import directory, with its name
+		// and, through the sections it refers to, its lookup and
+		// address tables.
+		{peImplibObject(machine, []peImplibSection{
+			{".idata$2", IMAGE_SCN_ALIGN_4BYTES | rw, make([]byte, peImportDirectoryEntrySize), []pe.Reloc{
+				{VirtualAddress: 12, SymbolTableIndex: 2, Type: relType}, // Name
+				{VirtualAddress: 0, SymbolTableIndex: 3, Type: relType},  // OriginalFirstThunk
+				{VirtualAddress: 16, SymbolTableIndex: 4, Type: relType}, // FirstThunk
+			}},
+			{".idata$6", IMAGE_SCN_ALIGN_2BYTES | rw, dllName, nil},
+		}, []peImplibSymbol{
+			{descName, 1, IMAGE_SYM_CLASS_EXTERNAL},
+			{".idata$2", 1, IMAGE_SYM_CLASS_SECTION},
+			{".idata$6", 2, IMAGE_SYM_CLASS_STATIC},
+			{".idata$4", 0, IMAGE_SYM_CLASS_SECTION},
+			{".idata$5", 0, IMAGE_SYM_CLASS_SECTION},
+			{nullDescName, 0, IMAGE_SYM_CLASS_EXTERNAL},
+			{nullThunkName, 0, IMAGE_SYM_CLASS_EXTERNAL},
+		}), []string{descName}},
+		// The entry that ends the import directory.
+		{peImplibObject(machine, []peImplibSection{
+			{".idata$3", IMAGE_SCN_ALIGN_4BYTES | rw, make([]byte, peImportDirectoryEntrySize), nil},
+		}, []peImplibSymbol{
+			{nullDescName, 1, IMAGE_SYM_CLASS_EXTERNAL},
+		}), []string{nullDescName}},
+		// The entries that end the lookup and address tables of the DLL.
+		{peImplibObject(machine, []peImplibSection{
+			{".idata$5", ptrAlign | rw, make([]byte, ptrSize), nil},
+			{".idata$4", ptrAlign | rw, make([]byte, ptrSize), nil},
+		}, []peImplibSymbol{
+			{nullThunkName, 1, IMAGE_SYM_CLASS_EXTERNAL},
+		}), []string{nullThunkName}},
+	}
+	for _, e := range exports {
+		// The C names of 386 have a leading underscore, which the
+		// name type strips to give the name of the export.
+		name, nameType := e.name, uint16(IMPORT_OBJECT_NAME)
+		if machine == pe.IMAGE_FILE_MACHINE_I386 {
+			name, nameType = "_"+name, IMPORT_OBJECT_NAME_NO_PREFIX
+		}
+		typ, syms := uint16(IMPORT_OBJECT_CODE), []string{"__imp_" + name, name}
+		if e.data {
+			typ, syms = IMPORT_OBJECT_DATA, []string{"__imp_" + name}
+		}
+		data := make([]byte, peImportObjectHeaderSize, peImportObjectHeaderSize+len(name)+len(dll)+2)
+		binary.LittleEndian.PutUint16(data[2:], 0xffff) // Sig2; Sig1 and Version are 0
+		binary.LittleEndian.PutUint16(data[6:], machine)
+		binary.LittleEndian.PutUint32(data[12:], uint32(len(name)+len(dll)+2))
+		binary.LittleEndian.PutUint16(data[16:], e.hint)
+		binary.LittleEndian.PutUint16(data[18:], typ|nameType<<2)
+		data = append(data, name...)
+		data = append(data, 0)
+		data = append(data, dll...)
+		data = append(data, 0)
+		members = append(members, member{data, syms})
+	}
+
+	// The archive, with the two linker members that index the symbols
+	// of the members, the first in member order and big-endian, the
+	// second sorted and little-endian, and the long names member.
+	memberName, longNames := dll+"/", []byte(nil)
+	if len(memberName) > 16 {
+		memberName = "/0"
+		longNames = append([]byte(dll), 0)
+	}
+	nsym, symNamesLen := 0, 0
+	for _, m := range members {
+		for _, s := range m.syms {
+			nsym++
+			symNamesLen += len(s) + 1
+		}
+	}
+	linker1Size := 4 + 4*nsym + symNamesLen
+	linker2Size := 4 + 4*len(members) + 4 + 2*nsym + symNamesLen
+	pad := func(n int) int { return n + n%2 }
+	off := 8 + 60 + pad(linker1Size) + 60 + pad(linker2Size) + 60 + pad(len(longNames))
+	offsets := make([]uint32, len(members))
+	for i, m := range members {
+		offsets[i] = uint32(off)
+		off += 60 + pad(len(m.data))
+	}
+
+	type symIndex struct {
+		name   string
+		member uint16
+	}
+	var linker1, linker2 bytes.Buffer
+	var sorted []symIndex
+	binary.Write(&linker1, binary.BigEndian, uint32(nsym))
+	for i, m := range members {
+		for _, s := range m.syms {
+			binary.Write(&linker1, binary.BigEndian, offsets[i])
+			sorted = append(sorted, symIndex{s, uint16(i + 1)})
+		}
+	}
+	for _, m := range members {
+		for _, s := range m.syms {
+			linker1.WriteString(s)
+			linker1.WriteByte(0)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	binary.Write(&linker2, binary.LittleEndian, uint32(len(members)))
+	binary.Write(&linker2, binary.LittleEndian, offsets)
+	binary.Write(&linker2, binary.LittleEndian, uint32(nsym))
+	for _, s := range sorted {
+		binary.Write(&linker2, binary.LittleEndian, s.member)
+	}
+	for _, s := range sorted {
+		linker2.WriteString(s.name)
+		linker2.WriteByte(0)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("!<arch>\n")
+	writeMember := func(name, mode string, data []byte) {
+		fmt.Fprintf(&out, "%-16s%-12s%-6s%-6s%-8s%-10s`\n", name, "0", "0", "0", mode, strconv.Itoa(len(data)))
+		out.Write(data)
+		if len(data)%2 != 0 {
+			out.WriteByte('\n')
+		}
+	}
+	writeMember("/", "0", linker1.Bytes())
+	writeMember("/", "0", linker2.Bytes())
+	writeMember("//", "0", longNames)
+	for _, m := range members {
+		writeMember(memberName, "644", m.data)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readPeImportLibrary returns the symbols in the second linker member
+// of the import library b, and the member each is in.
+func readPeImportLibrary(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte("!<arch>\n")) {
+		t.Fatalf("no archive header: %q", b[:min(len(b), 8)])
+	}
+	at := func(off uint32) (string, []byte) {
+		h := b[off : off+60]
+		size, err := strconv.Atoi(strings.TrimSpace(string(h[48:58])))
+		if err != nil || string(h[58:]) != "`\n" {
+			t.Fatalf("member header at %d: %q", off, h)
+		}
+		return strings.TrimSpace(string(h[:16])), b[off+60 : int(off)+60+size]
+	}
+	name1, linker1 := at(8)
+	name2, linker2 := at(uint32(8 + 60 + len(linker1) + len(linker1)%2))
+	if name1 != "/" || name2 != "/" {
+		t.Fatalf("linker members named %q and %q", name1, name2)
+	}
+	if nsym := binary.BigEndian.Uint32(linker1); nsym != uint32(strings.Count(string(linker1[4+4*nsym:]), "\x00")) {
+		t.Errorf("first linker member has %d symbols and names %q", nsym, linker1[4+4*nsym:])
+	}
+
+	le := binary.LittleEndian
+	nmem := le.Uint32(linker2)
+	offsets := linker2[4 : 4+4*nmem]
+	nsym := le.Uint32(linker2[4+4*nmem:])
+	indices := linker2[8+4*nmem : 8+4*nmem+2*nsym]
+	names := strings.Split(strings.TrimSuffix(string(linker2[8+4*nmem+2*nsym:]), "\x00"), "\x00")
+	if uint32(len(names)) != nsym {
+		t.Fatalf("second linker member has %d symbols and %d names", nsym, len(names))
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("second linker member symbols not sorted: %q", names)
+	}
+	syms := make(map[string][]byte)
+	for i, name := range names {
+		_, data := at(le.Uint32(offsets[4*(le.Uint16(indices[2*i:])-1):]))
+		syms[name] = data
+	}
+	return syms
+}
+
+func TestPeImportLibrary(t *testing.T) {
+	exports := []peExport{{"Abc", 0, false}, {"Var", 1, true}, {"zfunc", 2, false}}
+	for _, tc := range []struct {
+		machine uint16
+		dll     string
+		prefix  string
+	}{
+		{pe.IMAGE_FILE_MACHINE_AMD64, "foo.dll", ""},
+		{pe.IMAGE_FILE_MACHINE_ARM64, "libverylongname.dll", ""},
+		{pe.IMAGE_FILE_MACHINE_I386, "foo.dll", "_"},
+	} {
+		syms := readPeImportLibrary(t, peImportLibrary(tc.machine, tc.dll, exports))
+		lib := strings.TrimSuffix(tc.dll, ".dll")
+		var got []string
+		for name := range syms {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		p := tc.prefix
+		want := []string{p + "Abc", "__IMPORT_DESCRIPTOR_" + lib, "__NULL_IMPORT_DESCRIPTOR",
+			"__imp_" + p + "Abc", "__imp_" + p + "Var", "__imp_" + p + "zfunc", p + "zfunc", "\x7f" + lib + "_NULL_THUNK_DATA"}
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got symbols %q, want %q", tc.dll, got, want)
+			continue
+		}
+
+		// The import members.
+		le := binary.LittleEndian
+		for i, e := range exports {
+			m := syms["__imp_"+p+e.name]
+			typ := uint16(IMPORT_OBJECT_CODE)
+			if e.data {
+				typ = IMPORT_OBJECT_DATA
+			}
+			nameType := uint16(IMPORT_OBJECT_NAME)
+			if p != "" {
+				nameType = IMPORT_OBJECT_NAME_NO_PREFIX
+			}
+			if le.Uint16(m[2:]) != 0xffff || le.Uint16(m[6:]) != tc.machine || le.Uint16(m[16:]) != uint16(i) || le.Uint16(m[18:]) != typ|nameType<<2 {
+				t.Errorf("%s: import member of %s has header %x", tc.dll, e.name, m[:peImportObjectHeaderSize])
+			}
+			if s := string(m[peImportObjectHeaderSize:]); s != p+e.name+"\x00"+tc.dll+"\x00" {
+				t.Errorf("%s: import member of %s names %q", tc.dll, e.name, s)
+			}
+		}
+
+		// The import descriptor.
+		f, err := pe.NewFile(bytes.NewReader(syms["__IMPORT_DESCRIPTOR_"+lib]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := f.Section(".idata$2"); s == nil || s.Size != peImportDirectoryEntrySize || len(s.Relocs) != 3 {
+			t.Errorf("%s: import descriptor has .idata$2 %+v", tc.dll, s)
+		}
+		if s := f.Section(".idata$6"); s == nil {
+			t.Errorf("%s: import descriptor has no .idata$6", tc.dll)
+		} else if data, _ := s.Data(); string(bytes.TrimRight(data, "\x00")) != tc.dll {
+			t.Errorf("%s: import descriptor names %q", tc.dll, data)
+		}
+	}
+}
+
+// TestPeImplib builds a c-shared DLL with -peimplib and checks the
+// import library for the exports of the DLL.
+func TestPeImplib(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("skipping: needs an external linker for windows")
+	}
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t)
+	testenv.MustHaveBuildMode(t, "c-shared")
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(`package main
+
+import "C"
+
+//export GoAdd
+func GoAdd(a, b C.int) C.int { return a + b }
+
+func main() {}
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dll := filepath.Join(dir, "add.dll")
+	lib := filepath.Join(dir, "add.lib")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildmode=c-shared", "-ldflags=-peimplib="+lib, "-o", dll, src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	f, err := pe.Open(dll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	name, exports, err := peDLLExports(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "add.dll" {
+		t.Errorf("DLL named %q, want add.dll", name)
+	}
+	b, err := os.ReadFile(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms := readPeImportLibrary(t, b)
+	found := false
+	for _, e := range exports {
+		if e.name == "GoAdd" {
+			found = true
+			if e.data {
+				t.Errorf("GoAdd exported as data")
+			}
+		}
+		p := ""
+		if f.Machine == pe.IMAGE_FILE_MACHINE_I386 {
+			p = "_"
+		}
+		if syms["__imp_"+p+e.name] == nil {
+			t.Errorf("import library has no import of %s", e.name)
+		}
+	}
+	if !found {
+		t.Errorf("DLL does not export GoAdd: %v", exports)
+	}
+	if syms["__IMPORT_DESCRIPTOR_add"] == nil {
+		t.Errorf("import library has no import descriptor")
+	}
+}
@@ -0,0 +1,590 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the merging of the resources of PE output, such as
+// manifests, icons and version info. The resources come from the files
+// of -rsrc, compiled resource (.res) files as rc and windres write them
+// or COFF objects with .rsrc sections, and from the .rsrc sections of
+// the host objects, such as the .syso files of packages. Rather than
+// copying the one .rsrc section there may be, the linker reads the
+// resource directory tree of each source and writes a single tree of
+// all of the resources, as link.exe and cvtres do, sorted as Windows
+// expects, failing if two sources have the same resource.
+//
+// When linking externally, the resources of -rsrc are written to a
+// COFF object with a .rsrc section, as windres would, which is passed
+// to the external linker with the host objects.
+
+import (
+	"bytes"
+	"cmd/internal/sys"
+	"cmd/link/internal/loader"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	// flagRsrc are the files of -rsrc.
+	flagRsrc []string
+
+	// rsrcObjs are the .rsrc sections of the host objects, a .rsrc
+	// section or the .rsrc$01 and .rsrc$02 sections of each.
+	rsrcObjs []rsrcObj
+
+	// rsrcFileResources are the resources of the files of -rsrc.
+	rsrcFileResources []*peResource
+)
+
+// An rsrcObj holds the .rsrc sections of the host object pn.
+type rsrcObj struct {
+	pn   string
+	syms []loader.Sym
+}
+
+// A peResourceID is the type or name of a resource: a string, if name
+// is set, or otherwise an integer.
+type peResourceID struct {
+	name string
+	id   uint16
+}
+
+func (r peResourceID) String() string {
+	if r.name != "" {
+		return r.name
+	}
+	return fmt.Sprint(r.id)
+}
+
+// less orders resource IDs as the resource directory does: strings
+// first, ignoring case, then integers.
+func (r peResourceID) less(o peResourceID) bool {
+	if (r.name != "") != (o.name != "") {
+		return r.name != ""
+	}
+	if r.name != "" {
+		if u, v := strings.ToUpper(r.name), strings.ToUpper(o.name); u != v {
+			return u < v
+		}
+		return r.name < o.name
+	}
+	return r.id < o.id
+}
+
+// A peResource is a resource of the output, read from the file from.
+type peResource struct {
+	typ, name peResourceID
+	lang      uint16
+	codepage  uint32
+	data      []byte
+	from      string
+}
+
+// resFileHeader is the empty resource that starts a .res file.
+var resFileHeader = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00,
+}
+
+// readRsrcFiles reads the resources of the files of -rsrc.
+func readRsrcFiles(ctxt *Link) {
+	if !ctxt.IsWindows() {
+		Exitf("-rsrc is only supported for windows")
+	}
+	for _, file := range flagRsrc {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			Exitf("-rsrc: %v", err)
+		}
+		var res []*peResource
+		if bytes.HasPrefix(data, resFileHeader) {
+			res, err = readResFile(data, file)
+		} else {
+			res, err = readCOFFResources(data, file)
+		}
+		if err != nil {
+			Exitf("-rsrc: %v", err)
+		}
+		rsrcFileResources = append(rsrcFileResources, res...)
+	}
+}
+
+// readResFile reads the resources of the .res file data, read from
+// the file from.
+func readResFile(data []byte, from string) ([]*peResource, error) {
+	le := binary.LittleEndian
+	var res []*peResource
+	for off := 0; off < len(data); off = int(Rnd(int64(off), 4)) {
+		if len(data)-off < 8 {
+			return nil, fmt.Errorf("%s: truncated resource header at %#x", from, off)
+		}
+		dataSize, headerSize := int(le.Uint32(data[off:])), int(le.Uint32(data[off+4:]))
+		if headerSize < 8 || dataSize < 0 || headerSize > len(data)-off || dataSize > len(data)-off-headerSize {
+			return nil, fmt.Errorf("%s: truncated resource at %#x", from, off)
+		}
+		h := data[off+8 : off+headerSize]
+		typ, n, err := resFileID(h)
+		if err != nil {
+			return nil, fmt.Errorf("%s: resource at %#x: %v", from, off, err)
+		}
+		name, m, err := resFileID(h[n:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: resource at %#x: %v", from, off, err)
+		}
+		// The IDs are followed, 4-byte aligned, by the data version,
+		// the memory flags and the language.
+		n = int(Rnd(int64(8+n+m), 4)) - 8
+		if len(h) < n+8 {
+			return nil, fmt.Errorf("%s: truncated resource header at %#x", from, off)
+		}
+		lang := le.Uint16(h[n+6:])
+		body := data[off+headerSize : off+headerSize+dataSize]
+		off += headerSize + dataSize
+		if dataSize == 0 && typ == (peResourceID{}) && name == (peResourceID{}) {
+			continue // the empty resource of the file header
+		}
+		res = append(res, &peResource{typ: typ, name: name, lang: lang, data: body, from: from})
+	}
+	return res, nil
+}
+
+// resFileID reads a type or name of a .res file resource header at
+// the start of h, returning the ID and its size.
+func resFileID(h []byte) (peResourceID, int, error) {
+	le := binary.LittleEndian
+	if len(h) >= 4 && le.Uint16(h) == 0xffff {
+		return peResourceID{id: le.Uint16(h[2:])}, 4, nil
+	}
+	var s []uint16
+	for i := 0; i+1 < len(h); i += 2 {
+		c := le.Uint16(h[i:])
+		if c == 0 {
+			return peResourceID{name: string(utf16.Decode(s))}, i + 2, nil
+		}
+		s = append(s, c)
+	}
+	return peResourceID{}, 0, fmt.Errorf("unterminated name")
+}
+
+// An rsrcSect is a .rsrc section of an object or image, for
+// readRsrcTree.
+type rsrcSect struct {
+	name   string
+	data   []byte
+	addr   uint32               // the virtual address in an image, or 0
+	relocs map[uint32]rsrcReloc // the relocated fields, by offset
+}
+
+// An rsrcReloc is a field of an rsrcSect relocated to the offset add
+// of the section sect.
+type rsrcReloc struct {
+	sect int
+	add  int64
+}
+
+// readCOFFResources reads the resources of the .rsrc sections of the
+// COFF object data, read from the file from.
+func readCOFFResources(data []byte, from string) ([]*peResource, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: neither a .res file nor a COFF object: %v", from, err)
+	}
+	defer f.Close()
+	var sects []rsrcSect
+	index := make(map[int]int) // section number to index in sects
+	for i, s := range f.Sections {
+		if s.Name != ".rsrc" && !strings.HasPrefix(s.Name, ".rsrc$") {
+			continue
+		}
+		d, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", from, err)
+		}
+		index[i+1] = len(sects)
+		sects = append(sects, rsrcSect{name: s.Name, data: d})
+	}
+	for i, s := range f.Sections {
+		j, ok := index[i+1]
+		if !ok {
+			continue
+		}
+		sects[j].relocs = make(map[uint32]rsrcReloc)
+		for _, r := range s.Relocs {
+			if int(r.SymbolTableIndex) >= len(f.COFFSymbols) || int(r.VirtualAddress)+4 > len(sects[j].data) {
+				return nil, fmt.Errorf("%s: bad relocation in %s", from, s.Name)
+			}
+			sym := &f.COFFSymbols[r.SymbolTableIndex]
+			k, ok := index[int(sym.SectionNumber)]
+			if !ok {
+				return nil, fmt.Errorf("%s: relocation in %s to a section with no resources", from, s.Name)
+			}
+			add := int64(int32(binary.LittleEndian.Uint32(sects[j].data[r.VirtualAddress:]))) + int64(sym.Value)
+			sects[j].relocs[r.VirtualAddress] = rsrcReloc{k, add}
+		}
+	}
+	if len(sects) == 0 {
+		return nil, fmt.Errorf("%s: no .rsrc section", from)
+	}
+	return readRsrcTree(sects, from)
+}
+
+// objRsrcResources reads the resources of the .rsrc sections of the
+// host object o.
+func objRsrcResources(ldr *loader.Loader, o rsrcObj) ([]*peResource, error) {
+	index := make(map[loader.Sym]int)
+	for i, s := range o.syms {
+		index[s] = i
+	}
+	sects := make([]rsrcSect, len(o.syms))
+	for i, s := range o.syms {
+		sects[i] = rsrcSect{name: ldr.SymName(s), data: ldr.Data(s), relocs: make(map[uint32]rsrcReloc)}
+		relocs := ldr.Relocs(s)
+		for ri := 0; ri < relocs.Count(); ri++ {
+			r := relocs.At(ri)
+			k, ok := index[r.Sym()]
+			if !ok {
+				k, ok = index[ldr.OuterSym(r.Sym())]
+			}
+			if !ok {
+				// The resource data is in the other section of
+				// a split pair, as addpersrc assumes.
+				k = (i + 1) % len(o.syms)
+			}
+			sects[i].relocs[uint32(r.Off())] = rsrcReloc{k, r.Add()}
+		}
+	}
+	return readRsrcTree(sects, o.pn)
+}
+
+// readRsrcTree reads the resources of the resource directory tree of
+// the sections sects, which starts at the start of the .rsrc or
+// .rsrc$01 section, read from the file from.
+func readRsrcTree(sects []rsrcSect, from string) ([]*peResource, error) {
+	dir := 0
+	for i, s := range sects {
+		if s.name == ".rsrc" || s.name == ".rsrc$01" {
+			dir = i
+			break
+		}
+	}
+	d := sects[dir].data
+	le := binary.LittleEndian
+	bad := func(format string, args ...any) error {
+		return fmt.Errorf("%s: bad resource directory: "+format, append([]any{from}, args...)...)
+	}
+	readID := func(v uint32) (peResourceID, error) {
+		if v&0x80000000 == 0 {
+			return peResourceID{id: uint16(v)}, nil
+		}
+		off := int(v &^ 0x80000000)
+		if off+2 > len(d) || off+2+2*int(le.Uint16(d[off:])) > len(d) {
+			return peResourceID{}, bad("name at %#x out of range", off)
+		}
+		s := make([]uint16, le.Uint16(d[off:]))
+		for i := range s {
+			s[i] = le.Uint16(d[off+2+2*i:])
+		}
+		return peResourceID{name: string(utf16.Decode(s))}, nil
+	}
+
+	var res []*peResource
+	var walk func(off int, level int, path [2]peResourceID) error
+	walk = func(off int, level int, path [2]peResourceID) error {
+		if off+16 > len(d) {
+			return bad("directory at %#x out of range", off)
+		}
+		n := int(le.Uint16(d[off+12:])) + int(le.Uint16(d[off+14:]))
+		if off+16+8*n > len(d) {
+			return bad("directory at %#x out of range", off)
+		}
+		for i := 0; i < n; i++ {
+			e := off + 16 + 8*i
+			id, err := readID(le.Uint32(d[e:]))
+			if err != nil {
+				return err
+			}
+			to := le.Uint32(d[e+4:])
+			if level < 2 {
+				if to&0x80000000 == 0 {
+					return bad("data entry at level %d", level)
+				}
+				p := path
+				p[level] = id
+				if err := walk(int(to&^0x80000000), level+1, p); err != nil {
+					return err
+				}
+				continue
+			}
+			if to&0x80000000 != 0 || int(to)+16 > len(d) {
+				return bad("data entry at %#x out of range", to)
+			}
+			size := int64(le.Uint32(d[to+4:]))
+			var data []byte
+			if r, ok := sects[dir].relocs[to]; ok {
+				t := sects[r.sect].data
+				if r.add < 0 || r.add+size > int64(len(t)) {
+					return bad("data of %d bytes at %#x out of range", size, r.add)
+				}
+				data = t[r.add : r.add+size]
+			} else {
+				rva := int64(le.Uint32(d[to:]))
+				for _, s := range sects {
+					if s.addr != 0 && rva >= int64(s.addr) && rva+size <= int64(s.addr)+int64(len(s.data)) {
+						data = s.data[rva-int64(s.addr) : rva-int64(s.addr)+size]
+						break
+					}
+				}
+				if data == nil && size != 0 {
+					return bad("data entry at %#x is not relocated", to)
+				}
+			}
+			res = append(res, &peResource{
+				typ:      path[0],
+				name:     path[1],
+				lang:     uint16(id.id),
+				codepage: le.Uint32(d[to+8:]),
+				data:     data,
+				from:     from,
+			})
+		}
+		return nil
+	}
+	if err := walk(0, 0, [2]peResourceID{}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// sortResources sorts the resources res in the order of the resource
+// directory tree, and reports those that are given twice.
+func sortResources(res []*peResource) {
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		switch {
+		case a.typ != b.typ:
+			return a.typ.less(b.typ)
+		case a.name != b.name:
+			return a.name.less(b.name)
+		}
+		return a.lang < b.lang
+	})
+	for i := 1; i < len(res); i++ {
+		a, b := res[i-1], res[i]
+		if a.typ == b.typ && a.name == b.name && a.lang == b.lang {
+			Errorf(nil, "duplicate resource: type %v, name %v, language %#x, in %s and %s", a.typ, a.name, a.lang, a.from, b.from)
+		}
+	}
+}
+
+// peResourceTree writes the resource directory tree of the sorted
+// resources res, with the data of the resources after it, for a .rsrc
+// section at the virtual address addr. It returns the section and the
+// offsets of the fields that hold the addresses of the data.
+func peResourceTree(res []*peResource, addr uint32) ([]byte, []uint32) {
+	// A directory has the types, the names of a type or the languages
+	// of a name, and the strings of all of them come after all of the
+	// directories, followed by the data entries and the data.
+	type dir struct {
+		off     int
+		ids     []peResourceID
+		sub     []*dir // or nil, for the languages
+		first   int    // index in res of the first resource of the languages
+		entries int    // number of resources of the languages
+	}
+	root := &dir{}
+	var dirs []*dir
+	for i, r := range res {
+		if n := len(root.ids); n == 0 || root.ids[n-1] != r.typ {
+			root.ids = append(root.ids, r.typ)
+			root.sub = append(root.sub, &dir{sub: []*dir{}})
+		}
+		t := root.sub[len(root.sub)-1]
+		if n := len(t.ids); n == 0 || t.ids[n-1] != r.name {
+			t.ids = append(t.ids, r.name)
+			t.sub = append(t.sub, &dir{first: i})
+		}
+		l := t.sub[len(t.sub)-1]
+		l.ids = append(l.ids, peResourceID{id: r.lang})
+		l.entries++
+	}
+	dirs = append(dirs, root)
+	dirs = append(dirs, root.sub...)
+	for _, t := range root.sub {
+		dirs = append(dirs, t.sub...)
+	}
+
+	off := 0
+	for _, d := range dirs {
+		d.off = off
+		off += 16 + 8*len(d.ids)
+	}
+	strOff := make(map[string]int)
+	var strs []string
+	for _, d := range dirs {
+		for _, id := range d.ids {
+			if id.name != "" {
+				if _, ok := strOff[id.name]; !ok {
+					strOff[id.name] = off
+					strs = append(strs, id.name)
+					off += 2 + 2*len(utf16.Encode([]rune(id.name)))
+				}
+			}
+		}
+	}
+	off = int(Rnd(int64(off), 4))
+	entryOff := off
+	off += 16 * len(res)
+	dataOff := make([]int, len(res))
+	for i, r := range res {
+		off = int(Rnd(int64(off), 8))
+		dataOff[i] = off
+		off += len(r.data)
+	}
+
+	le := binary.LittleEndian
+	out := make([]byte, Rnd(int64(off), 4))
+	for _, d := range dirs {
+		named := 0
+		for _, id := range d.ids {
+			if id.name != "" {
+				named++
+			}
+		}
+		le.PutUint16(out[d.off+12:], uint16(named))
+		le.PutUint16(out[d.off+14:], uint16(len(d.ids)-named))
+		for i, id := range d.ids {
+			e := d.off + 16 + 8*i
+			if id.name != "" {
+				le.PutUint32(out[e:], 0x80000000|uint32(strOff[id.name]))
+			} else {
+				le.PutUint32(out[e:], uint32(id.id))
+			}
+			if d.sub != nil {
+				le.PutUint32(out[e+4:], 0x80000000|uint32(d.sub[i].off))
+			} else {
+				le.PutUint32(out[e+4:], uint32(entryOff+16*(d.first+i)))
+			}
+		}
+	}
+	for _, s := range strs {
+		u := utf16.Encode([]rune(s))
+		o := strOff[s]
+		le.PutUint16(out[o:], uint16(len(u)))
+		for i, c := range u {
+			le.PutUint16(out[o+2+2*i:], c)
+		}
+	}
+	var relocs []uint32
+	for i, r := range res {
+		e := entryOff + 16*i
+		le.PutUint32(out[e:], addr+uint32(dataOff[i]))
+		le.PutUint32(out[e+4:], uint32(len(r.data)))
+		le.PutUint32(out[e+8:], r.codepage)
+		relocs = append(relocs, uint32(e))
+		copy(out[dataOff[i]:], r.data)
+	}
+	return out, relocs
+}
+
+// mergedResources returns the resources of the output, sorted, if they
+// are to be merged: if there are files of -rsrc or more than one host
+// object with resources.
+func mergedResources(ctxt *Link) ([]*peResource, bool) {
+	if len(flagRsrc) == 0 && len(rsrcObjs) <= 1 {
+		return nil, false
+	}
+	res := append([]*peResource(nil), rsrcFileResources...)
+	for _, o := range rsrcObjs {
+		r, err := objRsrcResources(ctxt.loader, o)
+		if err != nil {
+			Errorf(nil, "%v", err)
+			continue
+		}
+		res = append(res, r...)
+	}
+	sortResources(res)
+	return res, true
+}
+
+// addMergedRsrc writes the .rsrc section of the resources res.
+func addMergedRsrc(ctxt *Link, res []*peResource) {
+	exitIfErrors()
+	data, _ := peResourceTree(res, 0)
+	h := pefile.addSection(".rsrc", len(data), len(data))
+	h.characteristics = IMAGE_SCN_MEM_READ | IMAGE_SCN_CNT_INITIALIZED_DATA
+	h.checkOffset(ctxt.Out.Offset())
+	data, _ = peResourceTree(res, h.virtualAddress)
+	ctxt.Out.Write(data)
+	h.pad(ctxt.Out, uint32(len(data)))
+
+	pefile.dataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE].VirtualAddress = h.virtualAddress
+	pefile.dataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE].Size = h.virtualSize
+}
+
+// writeRsrcObject writes the resources of -rsrc to a COFF object in
+// the temporary directory for the external linker, and returns its
+// path.
+func writeRsrcObject(ctxt *Link) string {
+	res := append([]*peResource(nil), rsrcFileResources...)
+	sortResources(res)
+	exitIfErrors()
+	data, relocs := peResourceTree(res, 0)
+
+	var machine, rtype uint16
+	switch ctxt.Arch.Family {
+	case sys.AMD64:
+		machine, rtype = pe.IMAGE_FILE_MACHINE_AMD64, IMAGE_REL_AMD64_ADDR32NB
+	case sys.I386:
+		machine, rtype = pe.IMAGE_FILE_MACHINE_I386, IMAGE_REL_I386_DIR32NB
+	case sys.ARM:
+		machine, rtype = pe.IMAGE_FILE_MACHINE_ARMNT, IMAGE_REL_ARM_ADDR32NB
+	case sys.ARM64:
+		machine, rtype = pe.IMAGE_FILE_MACHINE_ARM64, IMAGE_REL_ARM64_ADDR32NB
+	}
+	const hdrSize = 20 + 40
+	relOff := hdrSize + len(data)
+	symOff := relOff + 10*len(relocs)
+
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, pe.FileHeader{
+		Machine:              machine,
+		NumberOfSections:     1,
+		PointerToSymbolTable: uint32(symOff),
+		NumberOfSymbols:      2,
+	})
+	sh := pe.SectionHeader32{
+		SizeOfRawData:        uint32(len(data)),
+		PointerToRawData:     hdrSize,
+		PointerToRelocations: uint32(relOff),
+		NumberOfRelocations:  uint16(len(relocs)),
+		Characteristics:      IMAGE_SCN_CNT_INITIALIZED_DATA | IMAGE_SCN_MEM_READ | IMAGE_SCN_ALIGN_4BYTES,
+	}
+	copy(sh.Name[:], ".rsrc")
+	binary.Write(&buf, le, sh)
+	buf.Write(data)
+	for _, off := range relocs {
+		// The data addresses hold their offsets in the section,
+		// the addends of the relocations to its symbol.
+		binary.Write(&buf, le, pe.Reloc{VirtualAddress: off, SymbolTableIndex: 0, Type: rtype})
+	}
+	sym := pe.COFFSymbol{SectionNumber: 1, StorageClass: IMAGE_SYM_CLASS_STATIC, NumberOfAuxSymbols: 1}
+	copy(sym.Name[:], ".rsrc")
+	binary.Write(&buf, le, sym)
+	binary.Write(&buf, le, pe.COFFSymbolAuxFormat5{Size: uint32(len(data)), NumRelocs: uint16(len(relocs))})
+	binary.Write(&buf, le, uint32(4)) // the empty string table
+
+	path := filepath.Join(*flagTmpdir, "go.rsrc.o")
+	if err := os.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		Exitf("-rsrc: %v", err)
+	}
+	return path
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// resFile returns a .res file of the resources res, as rc writes it.
+func resFile(res []*peResource) []byte {
+	le := binary.LittleEndian
+	id := func(b []byte, r peResourceID) []byte {
+		if r.name == "" {
+			return le.AppendUint16(le.AppendUint16(b, 0xffff), r.id)
+		}
+		for _, c := range utf16.Encode([]rune(r.name)) {
+			b = le.AppendUint16(b, c)
+		}
+		return le.AppendUint16(b, 0)
+	}
+	out := append([]byte(nil), resFileHeader...)
+	out = append(out, make([]byte, 16)...)
+	for _, r := range res {
+		h := id(id(nil, r.typ), r.name)
+		for len(h)%4 != 0 {
+			h = append(h, 0)
+		}
+		h = le.AppendUint32(h, 0)      // DataVersion
+		h = le.AppendUint16(h, 0x1030) // MemoryFlags
+		h = le.AppendUint16(h, r.lang)
+		h = le.AppendUint32(h, 0) // Version
+		h = le.AppendUint32(h, 0) // Characteristics
+		out = le.AppendUint32(out, uint32(len(r.data)))
+		out = le.AppendUint32(out, uint32(8+len(h)))
+		out = append(out, h...)
+		out = append(out, r.data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+func resString(res []*peResource) string {
+	var b strings.Builder
+	for _, r := range res {
+		fmt.Fprintf(&b, "%v/%v/%#x %q\n", r.typ, r.name, r.lang, r.data)
+	}
+	return b.String()
+}
+
+var testResources = [][]*peResource{
+	{
+		{typ: peResourceID{id: 24}, name: peResourceID{id: 1}, lang: 0x409, data: []byte("<assembly/>")},
+		{typ: peResourceID{name: "TEXT"}, name: peResourceID{name: "readme"}, lang: 0x409, data: []byte("hello")},
+	},
+	{
+		{typ: peResourceID{id: 16}, name: peResourceID{id: 1}, lang: 0x409, data: []byte("version")},
+		{typ: peResourceID{name: "TEXT"}, name: peResourceID{name: "readme"}, lang: 0x407, data: []byte("hallo")},
+		{typ: peResourceID{name: "TEXT"}, name: peResourceID{name: "License"}, lang: 0, data: []byte("BSD")},
+	},
+}
+
+// testResourcesWant are the resources of testResources, sorted.
+const testResourcesWant = `TEXT/License/0x0 "BSD"
+TEXT/readme/0x407 "hallo"
+TEXT/readme/0x409 "hello"
+16/1/0x409 "version"
+24/1/0x409 "<assembly/>"
+`
+
+// TestPEResourceTree reads the resources of .res files and checks that
+// the directory tree of them is read back the same.
+func TestPEResourceTree(t *testing.T) {
+	var res []*peResource
+	for i, r := range testResources {
+		got, err := readResFile(resFile(r), "test.res")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resString(got) != resString(r) {
+			t.Fatalf("readResFile of resources %d:\n%swant:\n%s", i, resString(got), resString(r))
+		}
+		res = append(res, got...)
+	}
+	sortResources(res)
+	if got := resString(res); got != testResourcesWant {
+		t.Fatalf("sorted resources:\n%swant:\n%s", got, testResourcesWant)
+	}
+
+	const addr = 0x5000
+	data, relocs := peResourceTree(res, addr)
+	if len(relocs) != len(res) {
+		t.Errorf("%d data entries, want %d", len(relocs), len(res))
+	}
+	got, err := readRsrcTree([]rsrcSect{{name: ".rsrc", data: data, addr: addr}}, "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resString(got) != testResourcesWant {
+		t.Errorf("resources of the tree:\n%swant:\n%s", resString(got), testResourcesWant)
+	}
+
+	if _, err := readResFile(resFile(res)[:60], "short.res"); err == nil {
+		t.Error("readResFile of a truncated file succeeded")
+	}
+}
+
+// TestRsrcObject writes the resources of .res files to a COFF object,
+// as for the external linker, and reads them back.
+func TestRsrcObject(t *testing.T) {
+	defer func(tmpdir string) { *flagTmpdir = tmpdir }(*flagTmpdir)
+	defer func() { rsrcFileResources = nil }()
+	*flagTmpdir = t.TempDir()
+	rsrcFileResources = append(append([]*peResource(nil), testResources[0]...), testResources[1]...)
+
+	ctxt := &Link{Target: Target{Arch: sys.ArchAMD64, HeadType: objabi.Hwindows}}
+	data, err := os.ReadFile(writeRsrcObject(ctxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Machine != pe.IMAGE_FILE_MACHINE_AMD64 || len(f.Sections) != 1 || len(f.Sections[0].Relocs) != 5 {
+		t.Errorf("object of machine %#x, %d sections, want amd64 and a .rsrc section with 5 relocations", f.Machine, len(f.Sections))
+	}
+	res, err := readCOFFResources(data, "go.rsrc.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resString(res); got != testResourcesWant {
+		t.Errorf("resources of the object:\n%swant:\n%s", got, testResourcesWant)
+	}
+}
+
+func TestRsrc(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for i, r := range testResources {
+		file := filepath.Join(dir, fmt.Sprintf("%d.res", i))
+		if err := os.WriteFile(file, resFile(r), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	build := func(exe string, files ...string) ([]byte, error) {
+		ldflags := "-ldflags="
+		for _, f := range files {
+			ldflags += " -rsrc=" + f
+		}
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", ldflags, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=windows", "GOARCH=amd64", "CGO_ENABLED=0")
+		return cmd.CombinedOutput()
+	}
+
+	exe := filepath.Join(dir, "main.exe")
+	if out, err := build(exe, files...); err != nil {
+		t.Fatalf("build: %v\n%s", err, out)
+	}
+	f, err := pe.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := f.Section(".rsrc")
+	if s == nil {
+		t.Fatal("no .rsrc section")
+	}
+	data, err := s.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dd := f.OptionalHeader.(*pe.OptionalHeader64).DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+	if dd.VirtualAddress != s.VirtualAddress {
+		t.Errorf("resource directory at %#x, want the .rsrc section at %#x", dd.VirtualAddress, s.VirtualAddress)
+	}
+	res, err := readRsrcTree([]rsrcSect{{name: ".rsrc", data: data, addr: s.VirtualAddress}}, exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resString(res); got != testResourcesWant {
+		t.Errorf("resources of %s:\n%swant:\n%s", exe, got, testResourcesWant)
+	}
+
+	out, err := build(filepath.Join(dir, "dup.exe"), files[0], files[0])
+	if err == nil || !strings.Contains(string(out), "duplicate resource: type 24, name 1") {
+		t.Errorf("build with the same resources twice: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the post-link passes, which transform the output
+// once it is complete: after the external linker, the Mach-O passes
+// and the archiver are done with it. A pass runs inside the link, so
+// that the build ID the go command records for the output covers what
+// the pass writes, which a script run on the output after the build
+// would not be.
+//
+// -post-link-tool adds a pass that runs a command, such as a signing
+// or watermarking tool, with the path of the output and that of a JSON
+// file describing the link as its last two arguments. The command may
+// change the output in place or replace it.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"internal/buildcfg"
+	"os"
+	"os/exec"
+)
+
+// A postLinkPass transforms the output of the link at path. It has the
+// Link, and through it the loader, as they are at the end of the link.
+type postLinkPass struct {
+	name string
+	run  func(ctxt *Link, path string) error
+}
+
+// postLinkPasses are the post-link passes, in the order they run.
+var postLinkPasses []postLinkPass
+
+// addPostLinkPass adds the pass name, which runs run, to the end of the
+// post-link passes.
+func addPostLinkPass(name string, run func(ctxt *Link, path string) error) {
+	postLinkPasses = append(postLinkPasses, postLinkPass{name, run})
+}
+
+// runPostLinkPasses runs the post-link passes on the output, in order.
+func runPostLinkPasses(ctxt *Link) {
+	exitIfErrors()
+	for _, p := range postLinkPasses {
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("post-link pass %s\n", p.name)
+		}
+		if err := p.run(ctxt, *flagOutfile); err != nil {
+			Exitf("post-link pass %s: %v", p.name, err)
+		}
+	}
+}
+
+// postLinkMetadata is the description of the link that -post-link-tool
+// gives its command.
+type postLinkMetadata struct {
+	Output    string `json:"output"`
+	GOOS      string `json:"goos"`
+	GOARCH    string `json:"goarch"`
+	Format    string `json:"format"`
+	BuildMode string `json:"buildmode"`
+	LinkMode  string `json:"linkmode"`
+	BuildID   string `json:"buildid,omitempty"`
+	Entry     string `json:"entry,omitempty"`
+	// Sections are the sections of the Go segments of the output. They
+	// are only known when linking internally: the external linker lays
+	// out its output anew.
+	Sections []postLinkSection `json:"sections,omitempty"`
+}
+
+// A postLinkSection is a section of the output in the metadata of
+// -post-link-tool.
+type postLinkSection struct {
+	Name    string `json:"name"`
+	Segment string `json:"segment"`
+	Addr    uint64 `json:"addr"`
+	Size    uint64 `json:"size"`
+	Fileoff uint64 `json:"fileoff"`
+	NoBits  bool   `json:"nobits,omitempty"`
+}
+
+// postLinkFormat returns the name of the object file format of the
+// output.
+func postLinkFormat(ctxt *Link) string {
+	switch {
+	case ctxt.IsELF:
+		return "elf"
+	case ctxt.IsDarwin():
+		return "macho"
+	case ctxt.IsWindows():
+		return "pe"
+	case ctxt.IsAIX():
+		return "xcoff"
+	case ctxt.IsWasm():
+		return "wasm"
+	case ctxt.IsPlan9():
+		return "plan9"
+	}
+	return ctxt.HeadType.String()
+}
+
+// newPostLinkMetadata returns the metadata of the link for the output
+// at path.
+func newPostLinkMetadata(ctxt *Link, path string) *postLinkMetadata {
+	md := &postLinkMetadata{
+		Output:    path,
+		GOOS:      buildcfg.GOOS,
+		GOARCH:    buildcfg.GOARCH,
+		Format:    postLinkFormat(ctxt),
+		BuildMode: ctxt.BuildMode.String(),
+		LinkMode:  ctxt.LinkMode.String(),
+		BuildID:   *flagBuildid,
+		Entry:     *flagEntrySymbol,
+	}
+	if ctxt.IsExternal() || ctxt.BuildMode == BuildModeCArchive {
+		return md
+	}
+	for _, seg := range Segments {
+		for _, sect := range seg.Sections {
+			ps := postLinkSection{
+				Name:    sect.Name,
+				Segment: linkMapSegmentName(seg),
+				Addr:    sect.Vaddr,
+				Size:    sect.Length,
+			}
+			if sect.Vaddr < seg.Vaddr+seg.Filelen {
+				ps.Fileoff = seg.Fileoff + sect.Vaddr - seg.Vaddr
+			} else {
+				ps.NoBits = true
+			}
+			md.Sections = append(md.Sections, ps)
+		}
+	}
+	return md
+}
+
+// postLinkTool runs the -post-link-tool command on the output at path.
+func postLinkTool(ctxt *Link, path string) error {
+	md, err := json.MarshalIndent(newPostLinkMetadata(ctxt, path), "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(*flagTmpdir, "go-link-post-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(md, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	argv := append(flagPostLinkTool[1:len(flagPostLinkTool):len(flagPostLinkTool)], path, f.Name())
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("post-link tool: %s %q\n", flagPostLinkTool[0], argv)
+	}
+	cmd := exec.Command(flagPostLinkTool[0], argv...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s failed: %v\n%s", flagPostLinkTool[0], err, out.Bytes())
+	}
+	if out.Len() > 0 {
+		// Show what the tool printed, as hostlink does for the warnings
+		// of the external linker.
+		ctxt.Logf("%s", out.Bytes())
+	}
+	return nil
+}
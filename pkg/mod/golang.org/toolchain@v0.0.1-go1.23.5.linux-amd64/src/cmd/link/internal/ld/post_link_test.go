@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"encoding/json"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const postLinkToolSrc = `package main
+
+import "os"
+
+// Append a mark to the output and keep a copy of the metadata.
+func main() {
+	out, md := os.Args[len(os.Args)-2], os.Args[len(os.Args)-1]
+	b, err := os.ReadFile(md)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(os.Args[1], b, 0666); err != nil {
+		panic(err)
+	}
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := f.WriteString("post-link mark"); err != nil {
+		panic(err)
+	}
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+}
+`
+
+// TestPostLinkTool links with a -post-link-tool that marks the output
+// and checks the output and the metadata the tool was given.
+func TestPostLinkTool(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	toolSrc := filepath.Join(dir, "tool.go")
+	if err := os.WriteFile(toolSrc, []byte(postLinkToolSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	tool := filepath.Join(dir, "tool.exe")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-o", tool, toolSrc)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	mdCopy := filepath.Join(dir, "md.json")
+	exe := filepath.Join(dir, "a.exe")
+	cmd = testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal '-post-link-tool="+tool+" "+mdCopy+"'", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	b, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(b, []byte("post-link mark")) {
+		t.Error("output does not end with the mark of the tool")
+	}
+	out, err := testenv.Command(t, exe).CombinedOutput()
+	if err != nil || string(out) != "hello\n" {
+		t.Errorf("running the output: %v\n%s", err, out)
+	}
+
+	b, err = os.ReadFile(mdCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md postLinkMetadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		t.Fatal(err)
+	}
+	if md.Output == "" || md.GOOS != runtime.GOOS || md.GOARCH != runtime.GOARCH || md.BuildMode != "exe" || md.LinkMode != "internal" || md.BuildID == "" {
+		t.Errorf("metadata %+v", md)
+	}
+	for _, s := range md.Sections {
+		if s.Name == ".text" && s.Segment == "text" && s.Size > 0 && s.Addr != 0 {
+			return
+		}
+	}
+	t.Errorf("metadata has no .text section: %+v", md.Sections)
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -provenance, which embeds a record of how the
+// output was built in the style of a SLSA provenance predicate
+// (https://slsa.dev/spec/v1.0/provenance): the builder named by the
+// flag, the toolchain, the Go build ID and build settings, the VCS
+// revision of the main module and the modules the output was built
+// from with their go.sum hashes, all as the go command gave them to
+// the linker, and a digest of the fingerprints of the packages linked.
+//
+// The record is a single line of JSON, whose fields are always in the
+// order of the types below and whose maps are sorted by key, so the
+// same inputs give the same bytes. It goes in a .note.go.provenance
+// note, of owner Go and type ELF_NOTE_GOPROVENANCE_TAG, for ELF, and
+// in a __TEXT,__go_provenance section for Mach-O, where tools can find
+// it without knowing the layout of the rest of the output.
+
+import (
+	"bytes"
+	"cmd/internal/notsha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"internal/buildcfg"
+	"runtime/debug"
+	"sort"
+)
+
+// provenanceBuildType identifies the format of the -provenance record.
+const provenanceBuildType = "https://golang.org/cmd/link/provenance@v1"
+
+type provenance struct {
+	BuildDefinition provenanceBuildDefinition `json:"buildDefinition"`
+	RunDetails      provenanceRunDetails      `json:"runDetails"`
+}
+
+type provenanceBuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   provenanceExternal     `json:"externalParameters"`
+	InternalParameters   provenanceInternal     `json:"internalParameters"`
+	ResolvedDependencies []provenanceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type provenanceExternal struct {
+	Path      string `json:"path,omitempty"` // of the main package
+	GOOS      string `json:"goos"`
+	GOARCH    string `json:"goarch"`
+	BuildMode string `json:"buildmode"`
+	LinkMode  string `json:"linkmode"`
+}
+
+type provenanceInternal struct {
+	Toolchain string            `json:"toolchain"`
+	BuildID   string            `json:"buildID,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+type provenanceDescriptor struct {
+	Name        string            `json:"name,omitempty"`
+	URI         string            `json:"uri,omitempty"`
+	Digest      map[string]string `json:"digest,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type provenanceRunDetails struct {
+	Builder    provenanceBuilder      `json:"builder"`
+	Byproducts []provenanceDescriptor `json:"byproducts,omitempty"`
+}
+
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+// Names of the section -provenance adds.
+const (
+	provenanceELFSection   = ".note.go.provenance"
+	provenanceMachoSection = "__TEXT,__go_provenance"
+)
+
+// addProvenanceSection adds the -provenance record of the link to
+// addedSections. It is called once the libraries are loaded.
+func addProvenanceSection(ctxt *Link) {
+	var name string
+	switch {
+	case ctxt.IsELF:
+		name = provenanceELFSection
+	case ctxt.IsDarwin():
+		name = provenanceMachoSection
+	default:
+		Exitf("-provenance is only supported for ELF and Mach-O")
+	}
+	if addedSectionNamed(name) != nil {
+		Exitf("-provenance: section %s is also given to -add-section or -add-note", name)
+	}
+	p, err := provenanceRecord(ctxt, *flagProvenance)
+	if err != nil {
+		Exitf("-provenance: %v", err)
+	}
+	s := &addedSection{name: name}
+	if ctxt.IsELF {
+		// The owner is written with a NUL after it, so this gives
+		// the 4-byte ELF_NOTE_GO_NAME of the other Go notes.
+		s.notes = []addedNote{{string(ELF_NOTE_GO_NAME[:3]), ELF_NOTE_GOPROVENANCE_TAG, p}}
+	} else {
+		s.data = p
+	}
+	addedSections = append(addedSections, s)
+}
+
+// provenanceRecord returns the -provenance record of the link, naming
+// builder as the builder.
+func provenanceRecord(ctxt *Link, builder string) ([]byte, error) {
+	var p provenance
+	d := &p.BuildDefinition
+	d.BuildType = provenanceBuildType
+	d.ExternalParameters = provenanceExternal{
+		GOOS:      buildcfg.GOOS,
+		GOARCH:    buildcfg.GOARCH,
+		BuildMode: ctxt.BuildMode.String(),
+		LinkMode:  ctxt.LinkMode.String(),
+	}
+	d.InternalParameters = provenanceInternal{
+		Toolchain: buildcfg.Version,
+		BuildID:   *flagBuildid,
+	}
+	if mod := modinfoText(strdata["runtime.modinfo"]); mod != "" {
+		info, err := debug.ParseBuildInfo(mod)
+		if err != nil {
+			return nil, fmt.Errorf("reading the module information: %v", err)
+		}
+		d.ExternalParameters.Path = info.Path
+		if len(info.Settings) > 0 {
+			d.InternalParameters.Settings = make(map[string]string)
+			for _, s := range info.Settings {
+				d.InternalParameters.Settings[s.Key] = s.Value
+			}
+		}
+		if info.Main.Path != "" {
+			d.ResolvedDependencies = append(d.ResolvedDependencies, provenanceMainModule(info))
+		}
+		for _, m := range info.Deps {
+			d.ResolvedDependencies = append(d.ResolvedDependencies, provenanceModule(m))
+		}
+	}
+
+	p.RunDetails.Builder.ID = builder
+	p.RunDetails.Byproducts = []provenanceDescriptor{{
+		Name:   "packages",
+		Digest: map[string]string{"sha256": provenancePackagesDigest(ctxt)},
+	}}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// modinfoText returns the text of the module information mod, the
+// runtime.modinfo string with the sentinels around it, as the go
+// command gives it, or "" if mod has none. See debug/buildinfo.
+func modinfoText(mod string) string {
+	if len(mod) >= 33 && mod[len(mod)-17] == '\n' {
+		return mod[16 : len(mod)-16]
+	}
+	return ""
+}
+
+// provenanceMainModule returns the descriptor of the main module of
+// info, with its VCS revision if the go command stamped one.
+func provenanceMainModule(info *debug.BuildInfo) provenanceDescriptor {
+	d := provenanceModule(&info.Main)
+	settings := make(map[string]string)
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	if vcs, rev := settings["vcs"], settings["vcs.revision"]; vcs != "" && rev != "" {
+		key := vcs
+		if vcs == "git" {
+			key = "gitCommit"
+		}
+		if d.Digest == nil {
+			d.Digest = make(map[string]string)
+		}
+		d.Digest[key] = rev
+		for _, k := range []string{"vcs", "vcs.time", "vcs.modified"} {
+			if v := settings[k]; v != "" {
+				if d.Annotations == nil {
+					d.Annotations = make(map[string]string)
+				}
+				d.Annotations[k] = v
+			}
+		}
+	}
+	return d
+}
+
+// provenanceModule returns the descriptor of module m, as a package
+// URL with its go.sum hash, and that of the module replacing it if it
+// is replaced by another. A module replaced by a directory, which has
+// no version or hash, keeps its own URL with the directory noted.
+func provenanceModule(m *debug.Module) provenanceDescriptor {
+	purl := func(m *debug.Module) string {
+		if m.Version == "" || m.Version == "(devel)" {
+			return "pkg:golang/" + m.Path
+		}
+		return "pkg:golang/" + m.Path + "@" + m.Version
+	}
+	d := provenanceDescriptor{URI: purl(m)}
+	if r := m.Replace; r != nil {
+		if r.Version == "" || r.Version == "(devel)" {
+			d.Annotations = map[string]string{"replaceDir": r.Path}
+			return d
+		}
+		d.Annotations = map[string]string{"replaces": d.URI}
+		d.URI = purl(r)
+		m = r
+	}
+	if m.Sum != "" {
+		d.Digest = map[string]string{"dirHash": m.Sum}
+	}
+	return d
+}
+
+// provenancePackagesDigest returns the SHA-256 hash, in hex, of the
+// import paths and fingerprints of the packages linked, sorted by path,
+// one per line.
+func provenancePackagesDigest(ctxt *Link) string {
+	var lines []string
+	for _, l := range ctxt.Library {
+		lines = append(lines, fmt.Sprintf("%s %x\n", l.Pkg, l.Fingerprint[:]))
+	}
+	sort.Strings(lines)
+	h := notsha256.New()
+	for _, l := range lines {
+		h.Write([]byte(l))
+	}
+	sum := h.Sum(nil)
+	for i := range sum {
+		sum[i] ^= 0xFF // convert notsha256 to sha256, as cmd/internal/codesign does
+	}
+	return hex.EncodeToString(sum)
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	debugbuildinfo "debug/buildinfo"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"encoding/json"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"testing"
+)
+
+func TestProvenanceModule(t *testing.T) {
+	for _, tc := range []struct {
+		m    debug.Module
+		want provenanceDescriptor
+	}{
+		{
+			debug.Module{Path: "example.com/m", Version: "(devel)"},
+			provenanceDescriptor{URI: "pkg:golang/example.com/m"},
+		},
+		{
+			debug.Module{Path: "golang.org/x/text", Version: "v0.14.0", Sum: "h1:ScX5w1eTa3QqT8oi6+ziP7dTV1S2+ALU0bI+0zXKWiQ="},
+			provenanceDescriptor{
+				URI:    "pkg:golang/golang.org/x/text@v0.14.0",
+				Digest: map[string]string{"dirHash": "h1:ScX5w1eTa3QqT8oi6+ziP7dTV1S2+ALU0bI+0zXKWiQ="},
+			},
+		},
+		{
+			debug.Module{Path: "example.com/old", Version: "v1.0.0", Sum: "h1:old", Replace: &debug.Module{Path: "example.com/new", Version: "v1.1.0", Sum: "h1:new"}},
+			provenanceDescriptor{
+				URI:         "pkg:golang/example.com/new@v1.1.0",
+				Digest:      map[string]string{"dirHash": "h1:new"},
+				Annotations: map[string]string{"replaces": "pkg:golang/example.com/old@v1.0.0"},
+			},
+		},
+		{
+			debug.Module{Path: "example.com/old", Version: "v1.0.0", Replace: &debug.Module{Path: "../old", Version: "(devel)"}},
+			provenanceDescriptor{
+				URI:         "pkg:golang/example.com/old@v1.0.0",
+				Annotations: map[string]string{"replaceDir": "../old"},
+			},
+		},
+	} {
+		if got := provenanceModule(&tc.m); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("provenanceModule(%+v) = %+v, want %+v", tc.m, got, tc.want)
+		}
+	}
+}
+
+// TestProvenance links a module that depends on another with
+// -provenance for linux and darwin, and checks the record against the
+// build information of the output, and that linking again gives the
+// same record.
+func TestProvenance(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64 and darwin/arm64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"go.mod":     "module example.com/prov\n\ngo 1.21\n\nrequire example.com/dep v1.0.0\n\nreplace example.com/dep => ./dep\n",
+		"main.go":    "package main\n\nimport \"example.com/dep\"\n\nfunc main() { dep.F() }\n",
+		"dep/go.mod": "module example.com/dep\n\ngo 1.21\n",
+		"dep/dep.go": "package dep\n\nfunc F() {}\n",
+	} {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const builder = "https://builder.example.com/ci@v1"
+	build := func(goos, goarch, out string) string {
+		out = filepath.Join(dir, out)
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-buildvcs=false", "-ldflags=-provenance="+builder, "-o", out)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0", "GOFLAGS=-mod=mod")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		return out
+	}
+	check := func(exe string, rec []byte, goos, goarch, buildmode string) {
+		t.Helper()
+		var p provenance
+		if err := json.Unmarshal(rec, &p); err != nil {
+			t.Fatalf("%s: %v\n%s", exe, err, rec)
+		}
+		// The serialization is the one the linker writes.
+		if b, err := json.Marshal(&p); err != nil || !bytes.Equal(append(b, '\n'), rec) {
+			t.Errorf("%s: record is not in its canonical form:\n%s", exe, rec)
+		}
+		info, err := debugbuildinfo.ReadFile(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := p.BuildDefinition
+		if d.BuildType != provenanceBuildType || p.RunDetails.Builder.ID != builder {
+			t.Errorf("%s: build type %q and builder %q", exe, d.BuildType, p.RunDetails.Builder.ID)
+		}
+		ext := provenanceExternal{Path: "example.com/prov", GOOS: goos, GOARCH: goarch, BuildMode: buildmode, LinkMode: "internal"}
+		if d.ExternalParameters != ext {
+			t.Errorf("%s: external parameters %+v, want %+v", exe, d.ExternalParameters, ext)
+		}
+		if d.InternalParameters.Toolchain != info.GoVersion || d.InternalParameters.BuildID == "" {
+			t.Errorf("%s: toolchain %q and build ID %q, want toolchain %q", exe, d.InternalParameters.Toolchain, d.InternalParameters.BuildID, info.GoVersion)
+		}
+		for _, s := range info.Settings {
+			if got := d.InternalParameters.Settings[s.Key]; got != s.Value {
+				t.Errorf("%s: setting %s is %q, want %q", exe, s.Key, got, s.Value)
+			}
+		}
+		deps := []provenanceDescriptor{
+			{URI: "pkg:golang/example.com/prov"},
+			{URI: "pkg:golang/example.com/dep@v1.0.0", Annotations: map[string]string{"replaceDir": "./dep"}},
+		}
+		if !reflect.DeepEqual(d.ResolvedDependencies, deps) {
+			t.Errorf("%s: dependencies %+v, want %+v", exe, d.ResolvedDependencies, deps)
+		}
+		if b := p.RunDetails.Byproducts; len(b) != 1 || b[0].Name != "packages" || len(b[0].Digest["sha256"]) != 64 {
+			t.Errorf("%s: byproducts %+v, want a packages digest", exe, b)
+		}
+	}
+
+	elfNote := func(exe string) []byte {
+		f, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		s := f.Section(provenanceELFSection)
+		if s == nil || s.Type != elf.SHT_NOTE {
+			t.Fatalf("%s: no %s note section", exe, provenanceELFSection)
+		}
+		b, err := s.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		le := binary.LittleEndian
+		if len(b) < 16 || le.Uint32(b) != 4 || le.Uint32(b[8:]) != ELF_NOTE_GOPROVENANCE_TAG || !bytes.Equal(b[12:16], ELF_NOTE_GO_NAME) {
+			t.Fatalf("%s: bad note header % x", exe, b[:min(len(b), 16)])
+		}
+		return b[16 : 16+le.Uint32(b[4:])]
+	}
+	a := elfNote(build("linux", "amd64", "a"))
+	check(filepath.Join(dir, "a"), a, "linux", "amd64", "exe")
+
+	// A change to the packages linked changes the digest of their
+	// fingerprints, but nothing else.
+	if err := os.WriteFile(filepath.Join(dir, "dep/dep.go"), []byte("package dep\n\nfunc F() { println() }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	b := elfNote(build("linux", "amd64", "b"))
+	var pa, pb provenance
+	json.Unmarshal(a, &pa)
+	json.Unmarshal(b, &pb)
+	if pa.RunDetails.Byproducts[0].Digest["sha256"] == pb.RunDetails.Byproducts[0].Digest["sha256"] {
+		t.Errorf("changing a package does not change the packages digest")
+	}
+	pa.RunDetails.Byproducts, pb.RunDetails.Byproducts = nil, nil
+	pa.BuildDefinition.InternalParameters.BuildID, pb.BuildDefinition.InternalParameters.BuildID = "", ""
+	if !reflect.DeepEqual(pa, pb) {
+		t.Errorf("changing a package changes more than the digest and build ID:\n%+v\n%+v", pa, pb)
+	}
+
+	m, err := macho.Open(build("darwin", "arm64", "m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s := m.Section("__go_provenance")
+	if s == nil || s.Seg != "__TEXT" {
+		t.Fatal("no __TEXT,__go_provenance section")
+	}
+	rec, err := s.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(dir, "m"), rec, "darwin", "arm64", "pie")
+}
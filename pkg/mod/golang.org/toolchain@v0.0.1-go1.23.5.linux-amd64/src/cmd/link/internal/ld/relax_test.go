@@ -0,0 +1,246 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRISCV64Object returns a riscv64 ELF object as a C compiler that
+// leaves relaxation to the linker writes it, of
+//
+//	cfunc:
+//		addi	sp, sp, -16
+//		sd	ra, 8(sp)
+//		beqz	a0, 1f
+//		call	helper
+//	1:	lla	a1, table
+//		ld	ra, 8(sp)
+//		addi	sp, sp, 16
+//		tail	helper
+//		.p2align 4
+//	helper:
+//		li	a0, 42
+//		ret
+//
+//		.section .rodata
+//	table:
+//		.quad	helper, 1b
+//
+// where the reference to 1b is one to .text+0x14.
+func testRISCV64Object() []byte {
+	le := binary.LittleEndian
+	var text []byte
+	for _, ins := range []uint32{
+		0xff010113, 0x00113423, 0x00050063, 0x00000097, 0x000080e7,
+		0x00000597, 0x00058593, 0x00813083, 0x01010113, 0x00000317,
+		0x00030067, 0x00000013, 0x00000013, 0x00000013, 0x02a00513,
+		0x00008067,
+	} {
+		text = le.AppendUint32(text, ins)
+	}
+	rodata := make([]byte, 16)
+	strtab := "\x00.Ltmp0\x00.Lpcrel_hi0\x00helper\x00table\x00cfunc\x00"
+	shstrtab := "\x00.text\x00.rela.text\x00.rodata\x00.rela.rodata\x00.symtab\x00.strtab\x00.shstrtab\x00"
+	name := func(tab, s string) uint32 { return uint32(bytes.Index([]byte(tab), []byte("\x00"+s+"\x00")) + 1) }
+	local := func(t elf.SymType) byte { return elf.ST_INFO(elf.STB_LOCAL, t) }
+
+	var symtab bytes.Buffer
+	for _, s := range []elf.Sym64{
+		{},
+		{Info: local(elf.STT_SECTION), Shndx: 1},
+		{Name: name(strtab, ".Ltmp0"), Info: local(elf.STT_NOTYPE), Shndx: 1, Value: 0x14},
+		{Name: name(strtab, ".Lpcrel_hi0"), Info: local(elf.STT_NOTYPE), Shndx: 1, Value: 0x14},
+		{Name: name(strtab, "helper"), Info: local(elf.STT_FUNC), Shndx: 1, Value: 0x38, Size: 8},
+		{Name: name(strtab, "table"), Info: local(elf.STT_NOTYPE), Shndx: 3},
+		{Name: name(strtab, "cfunc"), Info: elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC), Shndx: 1, Size: 0x2c},
+	} {
+		binary.Write(&symtab, le, s)
+	}
+	rela := func(rels ...elf.Rela64) []byte {
+		var b bytes.Buffer
+		binary.Write(&b, le, rels)
+		return b.Bytes()
+	}
+	r := func(off uint64, sym uint32, typ elf.R_RISCV, add int64) elf.Rela64 {
+		return elf.Rela64{Off: off, Info: elf.R_INFO(sym, uint32(typ)), Addend: add}
+	}
+	relaText := rela(
+		r(0x08, 2, elf.R_RISCV_BRANCH, 0),
+		r(0x0c, 4, elf.R_RISCV_CALL_PLT, 0),
+		r(0x0c, 0, elf.R_RISCV_RELAX, 0),
+		r(0x14, 5, elf.R_RISCV_PCREL_HI20, 0),
+		r(0x14, 0, elf.R_RISCV_RELAX, 0),
+		r(0x18, 3, elf.R_RISCV_PCREL_LO12_I, 0),
+		r(0x18, 0, elf.R_RISCV_RELAX, 0),
+		r(0x24, 4, elf.R_RISCV_CALL_PLT, 0),
+		r(0x24, 0, elf.R_RISCV_RELAX, 0),
+		r(0x2c, 0, elf.R_RISCV_ALIGN, 0xc),
+	)
+	relaRodata := rela(
+		r(0, 4, elf.R_RISCV_64, 0),
+		r(8, 1, elf.R_RISCV_64, 0x14),
+	)
+
+	// The contents follow the ELF header, and the section headers them.
+	type section struct {
+		elf.Section64
+		data []byte
+	}
+	sects := []section{
+		{},
+		{elf.Section64{Name: name(shstrtab, ".text"), Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR), Addralign: 16}, text},
+		{elf.Section64{Name: name(shstrtab, ".rela.text"), Type: uint32(elf.SHT_RELA), Flags: uint64(elf.SHF_INFO_LINK), Link: 5, Info: 1, Addralign: 8, Entsize: 24}, relaText},
+		{elf.Section64{Name: name(shstrtab, ".rodata"), Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC), Addralign: 8}, rodata},
+		{elf.Section64{Name: name(shstrtab, ".rela.rodata"), Type: uint32(elf.SHT_RELA), Flags: uint64(elf.SHF_INFO_LINK), Link: 5, Info: 3, Addralign: 8, Entsize: 24}, relaRodata},
+		{elf.Section64{Name: name(shstrtab, ".symtab"), Type: uint32(elf.SHT_SYMTAB), Link: 6, Info: 6, Addralign: 8, Entsize: 24}, symtab.Bytes()},
+		{elf.Section64{Name: name(shstrtab, ".strtab"), Type: uint32(elf.SHT_STRTAB), Addralign: 1}, []byte(strtab)},
+		{elf.Section64{Name: name(shstrtab, ".shstrtab"), Type: uint32(elf.SHT_STRTAB), Addralign: 1}, []byte(shstrtab)},
+	}
+	var contents []byte
+	for i := range sects {
+		s := &sects[i]
+		if s.Type == 0 {
+			continue
+		}
+		for (64+len(contents))%8 != 0 {
+			contents = append(contents, 0)
+		}
+		s.Off = uint64(64 + len(contents))
+		s.Size = uint64(len(s.data))
+		contents = append(contents, s.data...)
+	}
+	for len(contents)%8 != 0 {
+		contents = append(contents, 0)
+	}
+
+	var b bytes.Buffer
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(elf.EM_RISCV),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(64 + len(contents)),
+		Flags:     0x4, // EF_RISCV_FLOAT_ABI_DOUBLE
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(sects)),
+		Shstrndx:  uint16(len(sects) - 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&b, le, hdr)
+	b.Write(contents)
+	for _, s := range sects {
+		binary.Write(&b, le, s.Section64)
+	}
+	return b.Bytes()
+}
+
+// TestRISCV64Relax links a program with a host object for linux/riscv64
+// internally and checks that its calls are relaxed to JALs and the rest
+// of its code and references moved to match. The program imports a
+// dynamic library so as not to be linked statically, in which case the
+// relocations of host objects are not applied.
+func TestRISCV64Relax(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/riscv64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"go.mod":          "module relax\n",
+		"main.go":         "package main\n\n//go:cgo_import_dynamic _ _ \"libc.so.6\"\n\nfunc cfunc()\n\nfunc main() { cfunc() }\n",
+		"cfunc_riscv64.s": "#include \"textflag.h\"\n\nTEXT ·cfunc(SB),NOSPLIT,$0\n\tJMP cfunc(SB)\n",
+		"cfunc.syso":      string(testRISCV64Object()),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal", "-o", "a.out")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=riscv64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	f, err := elf.Open(filepath.Join(dir, "a.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := make(map[string]elf.Symbol)
+	for _, s := range syms {
+		addr[s.Name] = s
+	}
+	cfunc, helper := addr["cfunc"], addr["helper"]
+	if cfunc.Value == 0 || helper.Value == 0 {
+		t.Fatalf("no cfunc or helper among the symbols")
+	}
+	if cfunc.Size != 0x24 || helper.Value != cfunc.Value+0x30 {
+		t.Errorf("cfunc is %#x bytes at %#x and helper at %#x, want 0x24 bytes and helper at cfunc+0x30", cfunc.Size, cfunc.Value, helper.Value)
+	}
+	read := func(addr, n uint64) []byte {
+		for _, p := range f.Progs {
+			if p.Type == elf.PT_LOAD && addr >= p.Vaddr && addr+n <= p.Vaddr+p.Filesz {
+				b := make([]byte, n)
+				if _, err := p.ReadAt(b, int64(addr-p.Vaddr)); err != nil {
+					t.Fatal(err)
+				}
+				return b
+			}
+		}
+		t.Fatalf("no segment holds %#x", addr)
+		return nil
+	}
+	code := read(cfunc.Value, 0x38)
+	ins := func(off uint64) uint32 { return binary.LittleEndian.Uint32(code[off:]) }
+	jal := func(rd uint32, off int64) uint32 {
+		v := uint32(off)
+		return v&0x100000<<11 | v&0x7fe<<20 | v&0x800<<9 | v&0xff000 | rd<<7 | 0x6f
+	}
+	for _, tc := range []struct {
+		off  uint64
+		want uint32
+		what string
+	}{
+		{0x08, 0x00050463, "branch past the call"},
+		{0x0c, jal(1, 0x30-0x0c), "call of helper"},
+		{0x20, jal(0, 0x30-0x20), "tail call of helper"},
+		{0x24, 0x00000013, "padding"},
+		{0x30, 0x02a00513, "helper"},
+	} {
+		if got := ins(tc.off); got != tc.want {
+			t.Errorf("%s at cfunc+%#x: got %#08x, want %#08x", tc.what, tc.off, got, tc.want)
+		}
+	}
+
+	// lla a1, table.
+	if got := ins(0x10) & 0xfff; got != 0x597 {
+		t.Fatalf("cfunc+0x10: got %#08x, want auipc a1", ins(0x10))
+	}
+	table := cfunc.Value + 0x10 + uint64(int32(ins(0x10))&^0xfff) + uint64(int32(ins(0x14))>>20)
+	tab := read(table, 16)
+	if got := binary.LittleEndian.Uint64(tab); got != helper.Value {
+		t.Errorf("table[0] = %#x, want helper at %#x", got, helper.Value)
+	}
+	if got := binary.LittleEndian.Uint64(tab[8:]); got != cfunc.Value+0x10 {
+		t.Errorf("table[1] = %#x, want cfunc+0x10 at %#x", got, cfunc.Value+0x10)
+	}
+}
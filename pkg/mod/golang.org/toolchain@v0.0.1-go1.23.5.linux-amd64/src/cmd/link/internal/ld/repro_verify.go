@@ -0,0 +1,292 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -verify-reproducible, which links a second time,
+// in a linker process of its own with the same arguments, and fails the
+// link if the output of the second link differs from the first,
+// naming the sections and symbols that hold the bytes that differ. The
+// second process iterates over maps in another order, runs its
+// goroutines in another order and, when linking externally, runs the
+// external linker again, so that the nondeterminism of the linker and
+// of the host toolchain shows up as a difference.
+//
+// The check runs as the last post-link pass, so that it compares the
+// outputs that the other passes, such as the signing ones, made. The
+// second link writes its output, and the debug file of -split-dwarf,
+// with the same base names as the first, which the output may record,
+// in a temporary directory, and none of the reports and profiles that
+// the first link writes elsewhere.
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// reproVerifyDropFlags are the flags that the second link of
+// -verify-reproducible is run without: itself, and those of the
+// reports, profiles and files that the first link writes besides the
+// output.
+var reproVerifyDropFlags = map[string]bool{
+	"verify-reproducible": true,
+	"benchmark":           true,
+	"benchmarkprofile":    true,
+	"cpuprofile":          true,
+	"cref":                true,
+	"json-report":         true,
+	"linkmap":             true,
+	"memprofile":          true,
+	"peimplib":            true,
+	"reproreport":         true,
+	"trace":               true,
+	"uuidmanifest":        true,
+}
+
+// reproVerifyArgs returns the arguments of the second link of
+// -verify-reproducible, given args, those of the first: the flags of
+// reproVerifyDropFlags are dropped and the values of -o and
+// -split-dwarf are replaced by out and splitDwarf.
+func reproVerifyArgs(args []string, out, splitDwarf string) []string {
+	var res []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			return append(res, args[i:]...)
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		name, value, hasValue := strings.Cut(name, "=")
+		flagArgs := []string{arg}
+		if f := flag.Lookup(name); f != nil && !hasValue && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			value = args[i]
+			flagArgs = append(flagArgs, value)
+		}
+		switch {
+		case reproVerifyDropFlags[name]:
+			continue
+		case name == "o":
+			flagArgs = []string{"-o=" + out}
+		case name == "split-dwarf":
+			flagArgs = []string{"-split-dwarf=" + splitDwarf}
+		}
+		res = append(res, flagArgs...)
+	}
+	return res
+}
+
+// isBoolFlag reports whether the flag f takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// verifyReproducible is the post-link pass of -verify-reproducible.
+func verifyReproducible(ctxt *Link, path string) error {
+	dir, err := os.MkdirTemp("", "go-link-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, filepath.Base(path))
+	var splitDwarf string
+	if *flagSplitDwarf != "" {
+		splitDwarf = filepath.Join(dir, filepath.Base(*flagSplitDwarf))
+		if splitDwarf == out {
+			splitDwarf += ".debug"
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	cmd := exec.Command(exe, reproVerifyArgs(os.Args[1:], out, splitDwarf)...)
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("verify-reproducible: %s\n", strings.Join(cmd.Args, " "))
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("second link: %v\n%s", err, output)
+	}
+
+	if err := reproCompare(path, out); err != nil {
+		return err
+	}
+	if splitDwarf != "" {
+		if fi, err := os.Stat(*flagSplitDwarf); err == nil && fi.Mode().IsRegular() {
+			return reproCompare(*flagSplitDwarf, splitDwarf)
+		}
+	}
+	return nil
+}
+
+// reproCompare compares the file path of the first link with the file
+// other of the second.
+func reproCompare(path, other string) error {
+	a, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(other)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	return fmt.Errorf("%s is not reproducible: %s", path, reproDiff(a, b))
+}
+
+// reproMaxPlaces is the most places that differ that reproDiff lists.
+const reproMaxPlaces = 20
+
+// reproDiff describes the differences between the outputs a and b of
+// the two links: their sizes, the number of bytes that differ and the
+// sections and symbols of a that hold them.
+func reproDiff(a, b []byte) string {
+	var sb strings.Builder
+	if len(a) != len(b) {
+		fmt.Fprintf(&sb, "a second link writes %d bytes instead of %d", len(b), len(a))
+	}
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	var diffs []int64 // the start of each run of bytes that differ
+	count := 0
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			if i == 0 || a[i-1] == b[i-1] {
+				diffs = append(diffs, int64(i))
+			}
+			count++
+		}
+	}
+	if count > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("; ")
+		}
+		fmt.Fprintf(&sb, "%d bytes of the first %d differ from those of a second link", count, n)
+	}
+
+	loc := reproLocator(a)
+	var places []string
+	seen := make(map[string]bool)
+	for _, off := range diffs {
+		p := loc(off)
+		if !seen[p] {
+			seen[p] = true
+			places = append(places, fmt.Sprintf("%s, from offset %#x", p, off))
+		}
+		if len(places) == reproMaxPlaces {
+			places = append(places, "...")
+			break
+		}
+	}
+	for _, p := range places {
+		sb.WriteString("\n\t")
+		sb.WriteString(p)
+	}
+	return sb.String()
+}
+
+// A reproSection is a section of an output, with its symbols in
+// address order, for reproLocator.
+type reproSection struct {
+	name      string
+	off, size uint64
+	addr      uint64
+	syms      []reproSym
+}
+
+// A reproSym is a symbol of a reproSection. Its size is 0 if it is
+// not known, and then it runs to the next symbol.
+type reproSym struct {
+	name       string
+	addr, size uint64
+}
+
+// reproLocator returns a function that names the place of the offset
+// in the output data: the section and the symbol of an ELF, Mach-O or
+// PE file that hold the byte at the offset, or the offset alone.
+func reproLocator(data []byte) func(off int64) string {
+	sects := reproSections(data)
+	return func(off int64) string {
+		for _, s := range sects {
+			if uint64(off) < s.off || uint64(off) >= s.off+s.size {
+				continue
+			}
+			addr := s.addr + uint64(off) - s.off
+			i := sort.Search(len(s.syms), func(i int) bool { return s.syms[i].addr > addr }) - 1
+			if i >= 0 && (s.syms[i].size == 0 || addr < s.syms[i].addr+s.syms[i].size) {
+				return fmt.Sprintf("section %s, symbol %s+%#x", s.name, s.syms[i].name, addr-s.syms[i].addr)
+			}
+			return "section " + s.name
+		}
+		return "no section"
+	}
+}
+
+// reproSections returns the sections with contents in the file of the
+// output data, or none if it is not an ELF, Mach-O or PE file.
+func reproSections(data []byte) []reproSection {
+	r := bytes.NewReader(data)
+	var sects []reproSection
+	if f, err := elf.NewFile(r); err == nil {
+		syms, _ := f.Symbols()
+		for i, s := range f.Sections {
+			if s.Type == elf.SHT_NOBITS || s.Size == 0 {
+				continue
+			}
+			rs := reproSection{name: s.Name, off: s.Offset, size: s.Size, addr: s.Addr}
+			for _, sym := range syms {
+				if int(sym.Section) == i && elf.ST_TYPE(sym.Info) != elf.STT_SECTION {
+					rs.syms = append(rs.syms, reproSym{sym.Name, sym.Value, sym.Size})
+				}
+			}
+			sects = append(sects, rs)
+		}
+	} else if f, err := macho.NewFile(r); err == nil {
+		for i, s := range f.Sections {
+			if s.Offset == 0 || s.Size == 0 {
+				continue
+			}
+			rs := reproSection{name: s.Seg + "," + s.Name, off: uint64(s.Offset), size: s.Size, addr: s.Addr}
+			if f.Symtab != nil {
+				for _, sym := range f.Symtab.Syms {
+					if int(sym.Sect) == i+1 {
+						rs.syms = append(rs.syms, reproSym{sym.Name, sym.Value, 0})
+					}
+				}
+			}
+			sects = append(sects, rs)
+		}
+	} else if f, err := pe.NewFile(r); err == nil {
+		for i, s := range f.Sections {
+			if s.Offset == 0 || s.Size == 0 {
+				continue
+			}
+			rs := reproSection{name: s.Name, off: uint64(s.Offset), size: uint64(s.Size), addr: uint64(s.VirtualAddress)}
+			for _, sym := range f.Symbols {
+				if int(sym.SectionNumber) == i+1 {
+					rs.syms = append(rs.syms, reproSym{sym.Name, uint64(s.VirtualAddress + sym.Value), 0})
+				}
+			}
+			sects = append(sects, rs)
+		}
+	}
+	for _, s := range sects {
+		sort.SliceStable(s.syms, func(i, j int) bool { return s.syms[i].addr < s.syms[j].addr })
+	}
+	return sects
+}
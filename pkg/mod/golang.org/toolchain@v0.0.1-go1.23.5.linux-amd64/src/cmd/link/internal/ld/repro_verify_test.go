@@ -0,0 +1,104 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReproVerifyArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args, want []string
+	}{
+		{
+			[]string{"-o", "x", "-trace=t", "-verify-reproducible", "-s", "-split-dwarf", "d", "main.a"},
+			[]string{"-o=out", "-s", "-split-dwarf=sd", "main.a"},
+		},
+		{
+			[]string{"-buildid=b", "--linkmap", "m", "-o=x", "-", "-o", "y"},
+			[]string{"-buildid=b", "-o=out", "-", "-o", "y"},
+		},
+		{
+			[]string{"-verify-reproducible", "--", "-cref"},
+			[]string{"--", "-cref"},
+		},
+	} {
+		if got := reproVerifyArgs(tt.args, "out", "sd"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("reproVerifyArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+// TestReproDiff changes a byte of the .rodata of a program and checks
+// that reproDiff names the section and the symbol that holds it.
+func TestReproDiff(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "main")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	a, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sect := f.Section(".rodata")
+	if sect == nil {
+		t.Fatal("no .rodata section")
+	}
+	b := append([]byte(nil), a...)
+	b[sect.Offset+sect.Size/2] ^= 0xff
+
+	if got := reproDiff(a, a); got != "" {
+		t.Errorf("reproDiff of the same outputs = %q, want none", got)
+	}
+	got := reproDiff(a, b)
+	if !strings.Contains(got, "1 bytes of the first") || !strings.Contains(got, "section .rodata, symbol ") {
+		t.Errorf("reproDiff = %q, want 1 byte of .rodata and its symbol", got)
+	}
+	got = reproDiff(a, b[:len(b)-1])
+	if !strings.Contains(got, "a second link writes") {
+		t.Errorf("reproDiff of a shorter output = %q, want its size", got)
+	}
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-verify-reproducible -v", "-o", filepath.Join(dir, "main"), src)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	if !strings.Contains(string(out), "verify-reproducible: ") {
+		t.Errorf("-v does not print the second link:\n%s", out)
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the size budgets of -max-binary-size, for the size
+// of the output file, and -max-section-size, for the size of a section
+// of it. A link that exceeds a budget fails, removing its output, and
+// prints where the size went, from the attribution of -json-report:
+// with -size-baseline, the -json-report of an earlier link, how the
+// sizes of the sections, of the kinds of symbol and of the packages
+// changed since, largest change first, so that the change that broke
+// the budget stands out; without it, the largest of them.
+
+import (
+	"cmd/link/internal/sym"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A sizeBudget is a budget of -max-section-size.
+type sizeBudget struct {
+	section string
+	max     uint64
+}
+
+var (
+	// sectionBudgets are the budgets of -max-section-size, and
+	// maxBinarySize that of -max-binary-size, or 0.
+	sectionBudgets []sizeBudget
+	maxBinarySize  uint64
+
+	// sizeBaseline is the report of -size-baseline, and sizeReport
+	// that of the link, once it is laid out.
+	sizeBaseline *linkReport
+	sizeReport   *linkReport
+)
+
+// sizeReportRows is the number of rows of each part of the size report.
+const sizeReportRows = 10
+
+// parseSize parses a size in bytes, with an optional suffix K, M or G
+// for KiB, MiB or GiB.
+func parseSize(s string) (uint64, error) {
+	shift := 0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	n, err := strconv.ParseUint(strings.TrimRight(s, "KMG"), 10, 64)
+	if err != nil || shift > 0 && n > ^uint64(0)>>shift {
+		return 0, fmt.Errorf("invalid size %q, want a number of bytes, optionally followed by K, M or G", s)
+	}
+	return n << shift, nil
+}
+
+// addSectionBudget adds the budget arg of -max-section-size, of the form
+// section=size.
+func addSectionBudget(arg string) {
+	name, size, ok := strings.Cut(arg, "=")
+	if !ok || name == "" {
+		Exitf("-max-section-size: %q is not of the form section=size", arg)
+	}
+	n, err := parseSize(size)
+	if err != nil {
+		Exitf("-max-section-size: %v", err)
+	}
+	sectionBudgets = append(sectionBudgets, sizeBudget{name, n})
+}
+
+// sizeBudgetsSet reports whether the link has a size budget.
+func sizeBudgetsSet() bool {
+	return maxBinarySize > 0 || len(sectionBudgets) > 0
+}
+
+// checkSizeBudgetFlags checks -max-binary-size and -size-baseline, and
+// reads the baseline.
+func checkSizeBudgetFlags(ctxt *Link) {
+	if *flagMaxBinarySize != "" {
+		n, err := parseSize(*flagMaxBinarySize)
+		if err != nil {
+			Exitf("-max-binary-size: %v", err)
+		}
+		if ctxt.BuildMode == BuildModeCArchive {
+			Exitf("-max-binary-size is not supported with -buildmode=c-archive")
+		}
+		maxBinarySize = n
+	}
+	if *flagSizeBaseline == "" {
+		return
+	}
+	if !sizeBudgetsSet() {
+		Exitf("-size-baseline needs -max-binary-size or -max-section-size")
+	}
+	data, err := os.ReadFile(*flagSizeBaseline)
+	if err != nil {
+		Exitf("-size-baseline: %v", err)
+	}
+	sizeBaseline = new(linkReport)
+	if err := json.Unmarshal(data, sizeBaseline); err != nil {
+		Exitf("-size-baseline: %s is not a -json-report file: %v", *flagSizeBaseline, err)
+	}
+}
+
+// layoutSizeReport attributes the size of the output, once it is laid
+// out, for checkSizeBudgets.
+func layoutSizeReport(ctxt *Link, order []*sym.Segment) {
+	sizeReport = newLinkReport(linkReportSymbols(ctxt, order))
+}
+
+// checkSizeBudgets fails the link if its output exceeds a size budget,
+// printing the size report.
+func (ctxt *Link) checkSizeBudgets() {
+	var over []string
+	if maxBinarySize > 0 {
+		fi, err := os.Stat(*flagOutfile)
+		if err != nil {
+			Exitf("-max-binary-size: %v", err)
+		}
+		if size := uint64(fi.Size()); size > maxBinarySize {
+			over = append(over, fmt.Sprintf("output is %d bytes, %d over the -max-binary-size budget of %d", size, size-maxBinarySize, maxBinarySize))
+		}
+	}
+	for _, b := range sectionBudgets {
+		var size uint64
+		found := false
+		for _, s := range sizeReport.Sections {
+			if s.Name == b.section {
+				size += s.Size
+				found = true
+			}
+		}
+		switch {
+		case !found:
+			over = append(over, fmt.Sprintf("-max-section-size: the output has no section %s", b.section))
+		case size > b.max:
+			over = append(over, fmt.Sprintf("section %s is %d bytes, %d over the -max-section-size budget of %d", b.section, size, size-b.max, b.max))
+		}
+	}
+	if len(over) == 0 {
+		return
+	}
+	for _, msg := range over {
+		Errorf(nil, "%s", msg)
+	}
+	writeSizeReport(os.Stderr, sizeBaseline, sizeReport)
+	exitIfErrors()
+}
+
+// A sizeRow is a row of the size report: the size of a section, kind or
+// package in the baseline and in the link.
+type sizeRow struct {
+	name      string
+	base, cur uint64
+	detail    string
+}
+
+func (r sizeRow) delta() int64 { return int64(r.cur) - int64(r.base) }
+
+// writeSizeReport writes to w how the sizes of the sections, kinds and
+// packages of rep changed since base, largest change first, or, if base
+// is nil, the largest of them.
+func writeSizeReport(w io.Writer, base, rep *linkReport) {
+	if base == nil {
+		base = &linkReport{}
+		fmt.Fprintf(w, "largest sizes of the output:\n")
+	} else {
+		fmt.Fprintf(w, "size changes against the -size-baseline:\n")
+	}
+
+	sections := make(map[string]*sizeRow)
+	var sectionNames []string
+	section := func(s linkReportSection) *sizeRow {
+		r := sections[s.Name]
+		if r == nil {
+			r = &sizeRow{name: s.Name}
+			sections[s.Name] = r
+			sectionNames = append(sectionNames, s.Name)
+		}
+		return r
+	}
+	for _, s := range base.Sections {
+		section(s).base += s.Size
+	}
+	for _, s := range rep.Sections {
+		section(s).cur += s.Size
+	}
+	var rows []sizeRow
+	for _, name := range sectionNames {
+		rows = append(rows, *sections[name])
+	}
+	writeSizeRows(w, "section", rows, base.Sections == nil)
+
+	rows = mergeSizeRows(base.Kinds, rep.Kinds, func(k linkReportSize) (string, uint64) { return k.Name, k.Size })
+	writeSizeRows(w, "kind", rows, base.Kinds == nil)
+
+	baseKinds := make(map[string]map[string]uint64)
+	curKinds := make(map[string]map[string]uint64)
+	rows = mergeSizeRows(base.Packages, rep.Packages, func(p linkReportPackage) (string, uint64) { return p.Name, p.Size })
+	for _, p := range base.Packages {
+		baseKinds[p.Name] = p.Kinds
+	}
+	for _, p := range rep.Packages {
+		curKinds[p.Name] = p.Kinds
+	}
+	for i := range rows {
+		r := &rows[i]
+		bk, ck := baseKinds[r.name], curKinds[r.name]
+		if r.name == "" {
+			r.name = "(linker)"
+		}
+		var kinds []string
+		for k := range ck {
+			kinds = append(kinds, k)
+		}
+		for k := range bk {
+			if _, ok := ck[k]; !ok {
+				kinds = append(kinds, k)
+			}
+		}
+		sort.Strings(kinds)
+		var parts []string
+		for _, k := range kinds {
+			if base.Packages == nil {
+				parts = append(parts, fmt.Sprintf("%s %d", k, ck[k]))
+			} else if d := int64(ck[k]) - int64(bk[k]); d != 0 {
+				parts = append(parts, fmt.Sprintf("%s %+d", k, d))
+			}
+		}
+		r.detail = strings.Join(parts, ", ")
+	}
+	writeSizeRows(w, "package", rows, base.Packages == nil)
+}
+
+// mergeSizeRows returns the rows of the sizes of base and cur, which
+// sizeOf gives the names and sizes of.
+func mergeSizeRows[T any](base, cur []T, sizeOf func(T) (string, uint64)) []sizeRow {
+	index := make(map[string]int)
+	var rows []sizeRow
+	row := func(name string) *sizeRow {
+		i, ok := index[name]
+		if !ok {
+			i = len(rows)
+			index[name] = i
+			rows = append(rows, sizeRow{name: name})
+		}
+		return &rows[i]
+	}
+	for _, x := range base {
+		name, size := sizeOf(x)
+		row(name).base += size
+	}
+	for _, x := range cur {
+		name, size := sizeOf(x)
+		row(name).cur += size
+	}
+	return rows
+}
+
+// writeSizeRows writes to w the rows of a part of the size report: if
+// largest, the largest, and otherwise those that changed, largest
+// change first.
+func writeSizeRows(w io.Writer, what string, rows []sizeRow, largest bool) {
+	if largest {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if rows[i].cur != rows[j].cur {
+				return rows[i].cur > rows[j].cur
+			}
+			return rows[i].name < rows[j].name
+		})
+	} else {
+		changed := rows[:0]
+		for _, r := range rows {
+			if r.delta() != 0 {
+				changed = append(changed, r)
+			}
+		}
+		rows = changed
+		abs := func(x int64) int64 {
+			if x < 0 {
+				return -x
+			}
+			return x
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			if di, dj := abs(rows[i].delta()), abs(rows[j].delta()); di != dj {
+				return di > dj
+			}
+			return rows[i].name < rows[j].name
+		})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if largest {
+		fmt.Fprintf(tw, "\t%s\tsize\t\n", what)
+	} else {
+		fmt.Fprintf(tw, "\t%s\tbaseline\tsize\tchange\t\n", what)
+	}
+	for i, r := range rows {
+		if i == sizeReportRows {
+			fmt.Fprintf(tw, "\t(%d more)\t\n", len(rows)-i)
+			break
+		}
+		name := r.name
+		if r.detail != "" {
+			name += " (" + r.detail + ")"
+		}
+		if largest {
+			fmt.Fprintf(tw, "\t%s\t%d\t\n", name, r.cur)
+		} else {
+			fmt.Fprintf(tw, "\t%s\t%d\t%d\t%+d\t\n", name, r.base, r.cur, r.delta())
+		}
+	}
+	if len(rows) == 0 {
+		fmt.Fprintf(tw, "\t(no change)\t\n")
+	}
+	tw.Flush()
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want uint64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1234", 1234, true},
+		{"4K", 4 << 10, true},
+		{"3M", 3 << 20, true},
+		{"2G", 2 << 30, true},
+		{"", 0, false},
+		{"K", 0, false},
+		{"1.5M", 0, false},
+		{"-1", 0, false},
+		{"1MB", 0, false},
+		{"17179869184G", 0, false},
+	} {
+		got, err := parseSize(tt.s)
+		if ok := err == nil; ok != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWriteSizeReport(t *testing.T) {
+	base := &linkReport{
+		Sections: []linkReportSection{{Name: ".text", Size: 100}, {Name: ".data", Size: 50}},
+		Kinds:    []linkReportSize{{Name: "text", Size: 100}, {Name: "data", Size: 50}},
+		Packages: []linkReportPackage{
+			{Name: "main", Size: 60, Kinds: map[string]uint64{"text": 60}},
+			{Name: "fmt", Size: 90, Kinds: map[string]uint64{"text": 40, "data": 50}},
+		},
+	}
+	rep := &linkReport{
+		Sections: []linkReportSection{{Name: ".text", Size: 400}, {Name: ".data", Size: 40}},
+		Kinds:    []linkReportSize{{Name: "text", Size: 400}, {Name: "data", Size: 40}},
+		Packages: []linkReportPackage{
+			{Name: "main", Size: 60, Kinds: map[string]uint64{"text": 60}},
+			{Name: "fmt", Size: 80, Kinds: map[string]uint64{"text": 40, "data": 40}},
+			{Name: "net", Size: 300, Kinds: map[string]uint64{"text": 300}},
+		},
+	}
+
+	// report returns the size report, with its columns separated by a
+	// single space.
+	report := func(base *linkReport) string {
+		var b bytes.Buffer
+		writeSizeReport(&b, base, rep)
+		lines := strings.Split(b.String(), "\n")
+		for i, l := range lines {
+			lines[i] = strings.Join(strings.Fields(l), " ")
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	out := report(base)
+	for _, want := range []string{
+		".text 100 400 +300",
+		".data 50 40 -10",
+		"net (text +300) 0 300 +300",
+		"fmt (data -10) 90 80 -10",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report has no line %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "main") {
+		t.Errorf("report lists package main, which did not change:\n%s", out)
+	}
+	if i, j := strings.Index(out, "net"), strings.Index(out, "fmt"); i > j {
+		t.Errorf("report lists fmt before net, which changed more:\n%s", out)
+	}
+
+	out = report(nil)
+	for _, want := range []string{
+		"largest sizes",
+		"net (text 300) 300",
+		"main (text 60) 60",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report without a baseline has no line %q:\n%s", want, out)
+		}
+	}
+}
+
+// TestSizeBudget links a program with size budgets it fits in, records
+// its -json-report and links a larger version of it against budgets it
+// exceeds, which fails with the growth of its package.
+func TestSizeBudget(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	build := func(prog, ldflags string) ([]byte, error) {
+		if err := os.WriteFile(src, []byte(prog), 0666); err != nil {
+			t.Fatal(err)
+		}
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags="+ldflags, "-o", filepath.Join(dir, "a.exe"), src)
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+		return cmd.CombinedOutput()
+	}
+
+	report := filepath.Join(dir, "report.json")
+	if out, err := build("package main\n\nfunc main() {}\n", "-max-binary-size=1G -max-section-size=.noptrdata=1M -json-report="+report); err != nil {
+		t.Fatalf("link within the budgets: %v\n%s", err, out)
+	}
+
+	out, err := build("package main\n\nvar x = [1 << 20]byte{1}\n\nfunc main() { println(x[0]) }\n", "-max-binary-size=1M -max-section-size=.noptrdata=1M -size-baseline="+report)
+	if err == nil {
+		t.Fatalf("link over the budgets succeeded:\n%s", out)
+	}
+	for _, want := range []string{
+		"over the -max-binary-size budget of 1048576",
+		"section .noptrdata is ",
+		"size changes against the -size-baseline",
+		"main (data +",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("link over the budgets did not print %q:\n%s", want, out)
+		}
+	}
+
+	if out, err := build("package main\n\nfunc main() {}\n", "-max-section-size=.nosuchsection=1M"); err == nil || !strings.Contains(string(out), "no section .nosuchsection") {
+		t.Errorf("link with a budget for a missing section: %v\n%s", err, out)
+	}
+}
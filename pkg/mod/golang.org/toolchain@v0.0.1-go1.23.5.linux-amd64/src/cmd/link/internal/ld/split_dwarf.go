@@ -0,0 +1,293 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains -split-dwarf, which moves the DWARF of an
+// internally linked output into a companion file once the output is
+// written, the way objcopy --only-keep-debug and dsymutil are used to:
+//
+//   - for ELF, the companion file is the unstripped output, and the
+//     DWARF sections are cut from the output, which gets a
+//     .gnu_debuglink section naming the companion file and holding its
+//     CRC-32. A GNU build ID note (see -B) is in both files, so
+//     debuggers can also find the companion file under a .build-id
+//     directory.
+//   - for PE, the companion file is the unstripped output, and the
+//     DWARF sections of the output keep their headers, and so their
+//     place in the image, but lose their contents; a .gnu_debuglink
+//     section reserved at link time names the companion file.
+//   - for Mach-O, the companion file is a dSYM bundle holding the
+//     unstripped output as an MH_DSYM file, and the __DWARF segment is
+//     cut from the output, whose code signature, if any, is redone.
+//
+// The DWARF sections are laid out after everything the program loads,
+// so cutting them moves just the symbol table and the sections after
+// them down.
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// splitDwarf moves the DWARF of the output to its companion file for
+// -split-dwarf.
+func splitDwarf(ctxt *Link) {
+	var err error
+	switch {
+	case ctxt.IsELF:
+		err = splitDwarfDebugFile(*flagOutfile, *flagSplitDwarf, elfSplitDwarf)
+	case ctxt.IsWindows():
+		err = splitDwarfDebugFile(*flagOutfile, *flagSplitDwarf, peSplitDwarf)
+	case ctxt.IsDarwin():
+		err = machoSplitDwarf(*flagOutfile, *flagSplitDwarf, ctxt.BuildMode == BuildModeExe || ctxt.BuildMode == BuildModePIE)
+	}
+	if err != nil {
+		Exitf("-split-dwarf: %v", err)
+	}
+}
+
+// splitDwarfDebugFile writes the output at path to the debug file
+// debugPath, and replaces it with the result of strip, which is given
+// its contents and the base name of the debug file.
+func splitDwarfDebugFile(path, debugPath string, strip func(data []byte, debugName string) ([]byte, error)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	stripped, err := strip(data, filepath.Base(debugPath))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(debugPath, data, 0644); err != nil {
+		return err
+	}
+	// Rewriting the output in place keeps its mode.
+	return os.WriteFile(path, stripped, 0)
+}
+
+// isDwarfSection reports whether name is that of a DWARF section in an
+// ELF or PE file, compressed or not.
+func isDwarfSection(name string) bool {
+	return strings.HasPrefix(name, ".debug_") || strings.HasPrefix(name, ".zdebug_")
+}
+
+// gnuDebuglinkSize returns the size of the .gnu_debuglink contents that
+// name the debug file debugName: the name, NUL terminated and padded to
+// 4 bytes, and a CRC-32.
+func gnuDebuglinkSize(debugName string) int {
+	return (len(debugName)+1+3)&^3 + 4
+}
+
+// gnuDebuglink returns the .gnu_debuglink contents that name the debug
+// file debugName, with contents debug.
+func gnuDebuglink(debugName string, debug []byte, order binary.ByteOrder) []byte {
+	b := make([]byte, gnuDebuglinkSize(debugName))
+	copy(b, debugName)
+	order.PutUint32(b[len(b)-4:], crc32.ChecksumIEEE(debug))
+	return b
+}
+
+// elfSplitDwarf returns the ELF file data without its DWARF sections and
+// with its empty .gnu_debuglink section filled in to name the debug file
+// debugName, which holds data. The DWARF sections must have the last
+// section headers, and lie after the contents of every segment.
+func elfSplitDwarf(data []byte, debugName string) ([]byte, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	first := len(f.Sections)
+	for first > 0 && isDwarfSection(f.Sections[first-1].Name) {
+		first--
+	}
+	if first == len(f.Sections) {
+		return nil, fmt.Errorf("no DWARF sections")
+	}
+	lo, hi := ^uint64(0), uint64(0)
+	for _, s := range f.Sections[first:] {
+		if s.Offset < lo {
+			lo = s.Offset
+		}
+		if end := s.Offset + s.FileSize; end > hi {
+			hi = end
+		}
+	}
+	var link *elf.Section
+	linkIndex := 0
+	align := uint64(1)
+	for i, s := range f.Sections[:first] {
+		switch {
+		case isDwarfSection(s.Name):
+			return nil, fmt.Errorf("DWARF section %s is not at the end of the section headers", s.Name)
+		case s.Name == ".gnu_debuglink":
+			link, linkIndex = s, i
+		case s.Type == elf.SHT_NOBITS:
+		case s.Offset+s.FileSize > lo && s.Offset < hi:
+			return nil, fmt.Errorf("section %s overlaps the DWARF sections", s.Name)
+		case s.Offset >= hi && s.Addralign > align:
+			align = s.Addralign
+		}
+	}
+	if link == nil || link.Type != elf.SHT_PROGBITS || link.FileSize != 0 {
+		return nil, fmt.Errorf("no empty .gnu_debuglink section")
+	}
+	for _, p := range f.Progs {
+		if p.Filesz != 0 && p.Off+p.Filesz > lo {
+			return nil, fmt.Errorf("segment at file offset %#x overlaps the DWARF sections", p.Off)
+		}
+	}
+
+	// What follows the DWARF moves down by a multiple of the alignment
+	// of its sections.
+	delta := (hi - lo) / align * align
+	out := make([]byte, 0, uint64(len(data))-delta+uint64(gnuDebuglinkSize(debugName))+3)
+	out = append(out, data[:lo]...)
+	out = append(out, make([]byte, hi-lo-delta)...)
+	out = append(out, data[hi:]...)
+
+	order := f.ByteOrder
+	// The offsets of e_shoff, e_shnum and the sh_offset and sh_size of
+	// a section header, and the size of one.
+	shoffOff, shnumOff, offOff, sizeOff, shdrSize := 0x28, 0x3c, 0x18, 0x20, 64
+	get := func(b []byte) uint64 { return order.Uint64(b) }
+	put := func(b []byte, v uint64) { order.PutUint64(b, v) }
+	if f.Class == elf.ELFCLASS32 {
+		shoffOff, shnumOff, offOff, sizeOff, shdrSize = 0x20, 0x30, 0x10, 0x14, 40
+		get = func(b []byte) uint64 { return uint64(order.Uint32(b)) }
+		put = func(b []byte, v uint64) { order.PutUint32(b, uint32(v)) }
+	}
+	shoff := get(out[shoffOff:])
+	if shoff >= hi {
+		shoff -= delta
+		put(out[shoffOff:], shoff)
+	}
+	shdr := func(i int) []byte { return out[shoff+uint64(i*shdrSize):][:shdrSize] }
+	for i, s := range f.Sections[:first] {
+		if s.Offset >= hi {
+			put(shdr(i)[offOff:], s.Offset-delta)
+		}
+	}
+	for i := first; i < len(f.Sections); i++ {
+		copy(shdr(i), make([]byte, shdrSize))
+	}
+	order.PutUint16(out[shnumOff:], uint16(first))
+
+	for len(out)%4 != 0 {
+		out = append(out, 0)
+	}
+	put(shdr(linkIndex)[offOff:], uint64(len(out)))
+	put(shdr(linkIndex)[sizeOff:], uint64(gnuDebuglinkSize(debugName)))
+	return append(out, gnuDebuglink(debugName, data, order)...), nil
+}
+
+// addDebuglink adds the .gnu_debuglink section that peSplitDwarf
+// fills in to the COFF file f, after its DWARF sections.
+func (f *peFile) addDebuglink(debugName string) {
+	n := gnuDebuglinkSize(debugName)
+	off := f.stringTable.add(".gnu_debuglink")
+	h := f.addSection(".gnu_debuglink", n, n)
+	h.shortName = fmt.Sprintf("/%d", off)
+	h.characteristics = IMAGE_SCN_ALIGN_4BYTES | IMAGE_SCN_MEM_READ | IMAGE_SCN_MEM_DISCARDABLE | IMAGE_SCN_CNT_INITIALIZED_DATA
+}
+
+// peSplitDwarf returns the PE file data with the contents of its DWARF
+// sections removed and its .gnu_debuglink section filled in to name the
+// debug file debugName, which holds data. The DWARF sections keep their
+// headers, and so their addresses, as zero-filled ones. Their contents
+// must be together in the file.
+func peSplitDwarf(data []byte, debugName string) ([]byte, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var fileAlign uint32
+	var debugDir pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		fileAlign = oh.FileAlignment
+		debugDir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG]
+	case *pe.OptionalHeader64:
+		fileAlign = oh.FileAlignment
+		debugDir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG]
+	default:
+		return nil, fmt.Errorf("no optional header")
+	}
+	lo, hi := ^uint32(0), uint32(0)
+	for _, s := range f.Sections {
+		if isDwarfSection(s.Name) && s.Size != 0 {
+			if s.Offset < lo {
+				lo = s.Offset
+			}
+			if end := s.Offset + s.Size; end > hi {
+				hi = end
+			}
+		}
+	}
+	if hi == 0 {
+		return nil, fmt.Errorf("no DWARF sections")
+	}
+	link := -1
+	for i, s := range f.Sections {
+		switch {
+		case isDwarfSection(s.Name):
+		case s.Name == ".gnu_debuglink":
+			link = i
+		case s.Size != 0 && s.Offset+s.Size > lo && s.Offset < hi:
+			return nil, fmt.Errorf("section %s is among the DWARF sections", s.Name)
+		}
+	}
+	if link < 0 || f.Sections[link].Offset < hi || f.Sections[link].VirtualSize != uint32(gnuDebuglinkSize(debugName)) {
+		return nil, fmt.Errorf("no .gnu_debuglink section for %s", debugName)
+	}
+
+	delta := (hi - lo) / fileAlign * fileAlign
+	out := make([]byte, 0, uint32(len(data))-delta)
+	out = append(out, data[:lo]...)
+	out = append(out, make([]byte, hi-lo-delta)...)
+	out = append(out, data[hi:]...)
+
+	order := binary.LittleEndian
+	coff := int(order.Uint32(out[0x3c:])) + 4
+	if p := f.PointerToSymbolTable; p >= hi {
+		order.PutUint32(out[coff+8:], p-delta)
+	}
+	shdrs := coff + 20 + int(f.SizeOfOptionalHeader)
+
+	for i, s := range f.Sections {
+		h := out[shdrs+40*i:][:40]
+		switch {
+		case isDwarfSection(s.Name):
+			order.PutUint32(h[16:], 0)
+			order.PutUint32(h[20:], 0)
+		case s.Size != 0 && s.Offset >= hi:
+			order.PutUint32(h[20:], s.Offset-delta)
+		}
+	}
+	// Debug directory entries, such as that of -pedllcharacteristics,
+	// hold the file offsets of their data too.
+	for _, s := range f.Sections {
+		if debugDir.Size == 0 || s.Size == 0 || debugDir.VirtualAddress < s.VirtualAddress || debugDir.VirtualAddress+debugDir.Size > s.VirtualAddress+s.Size {
+			continue
+		}
+		off := s.Offset + debugDir.VirtualAddress - s.VirtualAddress
+		if s.Offset >= hi {
+			off -= delta
+		}
+		for e := off; e+peDebugDirectoryEntrySize <= off+debugDir.Size; e += peDebugDirectoryEntrySize {
+			if p := order.Uint32(out[e+24:]); p >= hi {
+				order.PutUint32(out[e+24:], p-delta)
+			}
+		}
+	}
+	copy(out[f.Sections[link].Offset-delta:], gnuDebuglink(debugName, data, order))
+	return out, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"hash/crc32"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGnuDebuglink(t *testing.T) {
+	for _, name := range []string{"a", "ab.debug", "abc", "abcd"} {
+		b := gnuDebuglink(name, []byte("debug"), binary.BigEndian)
+		if len(b) != gnuDebuglinkSize(name) || len(b)%4 != 0 {
+			t.Errorf("%s: %d bytes, want a multiple of 4", name, len(b))
+		}
+		if got, _, _ := bytes.Cut(b, []byte{0}); string(got) != name {
+			t.Errorf("%s: names %q", name, got)
+		}
+		if got := binary.BigEndian.Uint32(b[len(b)-4:]); got != crc32.ChecksumIEEE([]byte("debug")) {
+			t.Errorf("%s: CRC %#x", name, got)
+		}
+		if n := len(b) - 4; n <= len(name) || n > len(name)+4 {
+			t.Errorf("%s: name padded to %d bytes", name, n)
+		}
+	}
+}
+
+// testSplitDwarfBuild builds a program with -split-dwarf=debugFile for
+// GOOS and GOARCH set by env, and returns the path of the output.
+func testSplitDwarfBuild(t *testing.T, dir, debugFile string, env ...string) string {
+	t.Helper()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nvar x = 42\n\nfunc main() { println(\"x\", x) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.exe")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-linkmode=internal -split-dwarf="+debugFile, "-o", exe, src)
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	return exe
+}
+
+// testCheckDebuglink checks that the contents of a .gnu_debuglink
+// section name debugFile and hold its CRC-32.
+func testCheckDebuglink(t *testing.T, link []byte, order binary.ByteOrder, debugFile string) {
+	t.Helper()
+	debug, err := os.ReadFile(debugFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gnuDebuglink(filepath.Base(debugFile), debug, order); !bytes.Equal(link, want) {
+		t.Errorf(".gnu_debuglink holds %q, want %q", link, want)
+	}
+}
+
+func TestSplitDwarfLinkELF(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: builds a program")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("skipping: runs an ELF program")
+	}
+	testenv.MustInternalLink(t, false)
+	t.Parallel()
+
+	dir := t.TempDir()
+	debugFile := filepath.Join(dir, "debug", "a.debug")
+	if err := os.Mkdir(filepath.Dir(debugFile), 0777); err != nil {
+		t.Fatal(err)
+	}
+	exe := testSplitDwarfBuild(t, dir, debugFile)
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, s := range f.Sections {
+		if isDwarfSection(s.Name) {
+			t.Errorf("output has DWARF section %s", s.Name)
+		}
+	}
+	if f.Section(".symtab") == nil {
+		t.Error("output has no .symtab")
+	} else if _, err := f.Symbols(); err != nil {
+		t.Errorf("reading symbols: %v", err)
+	}
+	link := f.Section(".gnu_debuglink")
+	if link == nil {
+		t.Fatal("output has no .gnu_debuglink")
+	}
+	data, err := link.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCheckDebuglink(t, data, f.ByteOrder, debugFile)
+
+	df, err := elf.Open(debugFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+	if _, err := df.DWARF(); err != nil {
+		t.Errorf("debug file DWARF: %v", err)
+	}
+
+	out, err := testenv.Command(t, exe).CombinedOutput()
+	if err != nil || strings.TrimSpace(string(out)) != "x 42" {
+		t.Errorf("%s: %v\n%s, want x 42", exe, err, out)
+	}
+}
+
+func TestSplitDwarfLinkPE(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for windows")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	debugFile := filepath.Join(dir, "a.exe.debug")
+	exe := testSplitDwarfBuild(t, dir, debugFile, "GOOS=windows", "GOARCH=amd64", "CGO_ENABLED=0")
+
+	f, err := pe.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	df, err := pe.Open(debugFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+	if _, err := df.DWARF(); err != nil {
+		t.Errorf("debug file DWARF: %v", err)
+	}
+	if len(f.Sections) != len(df.Sections) {
+		t.Fatalf("output has %d sections, debug file %d", len(f.Sections), len(df.Sections))
+	}
+	for i, s := range f.Sections {
+		ds := df.Sections[i]
+		if s.Name != ds.Name || s.VirtualAddress != ds.VirtualAddress || s.VirtualSize != ds.VirtualSize {
+			t.Errorf("section %d is %s at %#x+%#x in the output, %s at %#x+%#x in the debug file", i, s.Name, s.VirtualAddress, s.VirtualSize, ds.Name, ds.VirtualAddress, ds.VirtualSize)
+		}
+		if isDwarfSection(s.Name) {
+			if s.Size != 0 || s.Offset != 0 {
+				t.Errorf("DWARF section %s has %#x bytes at %#x in the output", s.Name, s.Size, s.Offset)
+			}
+			continue
+		}
+		got, err := s.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ds.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch s.Name {
+		case ".gnu_debuglink":
+			testCheckDebuglink(t, got[:s.VirtualSize], binary.LittleEndian, debugFile)
+		case ".text":
+			// The go command rewrites the build ID at its start in
+			// the output only.
+		default:
+			if !bytes.Equal(got, want) {
+				t.Errorf("section %s differs from the debug file's", s.Name)
+			}
+		}
+	}
+	if len(f.Symbols) == 0 || len(f.Symbols) != len(df.Symbols) {
+		t.Errorf("output has %d symbols, debug file %d", len(f.Symbols), len(df.Symbols))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// stripLines is set by -strip=lines: the DWARF line tables keep
+	// their headers, with the file tables the DWARF refers to, but
+	// lose their rows.
+	stripLines bool
+
+	// stripFrame is set by -strip=frame: no .debug_frame is written.
+	stripFrame bool
+)
+
+// stripKinds are the kinds of -strip, in the order the usage lists
+// them.
+var stripKinds = []string{"all", "symtab", "dwarf", "lines", "frame"}
+
+// A stripSet is the set of kinds -strip gives.
+type stripSet struct {
+	symtab, dwarf, lines, frame bool
+}
+
+// parseStrip parses the comma-separated kinds of -strip.
+func parseStrip(s string) (stripSet, error) {
+	var set stripSet
+	for _, kind := range strings.Split(s, ",") {
+		switch kind {
+		case "all":
+			set.symtab, set.dwarf = true, true
+		case "symtab":
+			set.symtab = true
+		case "dwarf":
+			set.dwarf = true
+		case "lines":
+			set.lines = true
+		case "frame":
+			set.frame = true
+		default:
+			return stripSet{}, fmt.Errorf("unknown kind %q; use %s", kind, strings.Join(stripKinds, ", "))
+		}
+	}
+	if set.dwarf && (set.lines || set.frame) {
+		return stripSet{}, fmt.Errorf("dwarf strips all of the DWARF and cannot be combined with lines or frame")
+	}
+	return set, nil
+}
+
+// applyStrip sets -s, -w and the finer controls from -strip, before
+// main works out whether -s implies -w. Unlike -s, -strip=symtab leaves
+// the DWARF in place unless dwarf is given too.
+func applyStrip(s string) {
+	if *FlagS || flagW != ternaryFlagUnset {
+		Exitf("-strip cannot be combined with -s or -w")
+	}
+	set, err := parseStrip(s)
+	if err != nil {
+		Exitf("-strip: %v", err)
+	}
+	*FlagS = set.symtab
+	if set.dwarf {
+		flagW = ternaryFlagTrue
+	} else {
+		flagW = ternaryFlagFalse
+	}
+	stripLines, stripFrame = set.lines, set.frame
+}
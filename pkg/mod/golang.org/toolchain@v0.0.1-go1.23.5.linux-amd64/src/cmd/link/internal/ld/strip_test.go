@@ -0,0 +1,130 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseStrip(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want stripSet
+		ok   bool
+	}{
+		{"symtab", stripSet{symtab: true}, true},
+		{"all", stripSet{symtab: true, dwarf: true}, true},
+		{"symtab,lines,frame", stripSet{symtab: true, lines: true, frame: true}, true},
+		{"lines", stripSet{lines: true}, true},
+		{"dwarf,lines", stripSet{}, false},
+		{"all,frame", stripSet{}, false},
+		{"line", stripSet{}, false},
+		{"", stripSet{}, false},
+	} {
+		got, err := parseStrip(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseStrip(%q) = %+v, %v; want %+v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestStrip links a program with each kind of -strip and checks the
+// symbol table and DWARF sections of the output.
+func TestStrip(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		strip         string
+		symtab, dwarf bool
+		lines, frame  bool
+	}{
+		{"symtab", false, true, true, true},
+		{"dwarf", true, false, false, false},
+		{"all", false, false, false, false},
+		{"lines", true, true, false, true},
+		{"frame", true, true, true, false},
+	} {
+		exe := filepath.Join(dir, tt.strip)
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-strip="+tt.strip, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", cmd, err, out)
+		}
+		f, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if got := f.Section(".symtab") != nil; got != tt.symtab {
+			t.Errorf("-strip=%s: has .symtab = %v, want %v", tt.strip, got, tt.symtab)
+		}
+		if got := f.Section(".debug_info") != nil; got != tt.dwarf {
+			t.Errorf("-strip=%s: has .debug_info = %v, want %v", tt.strip, got, tt.dwarf)
+		}
+		if got := f.Section(".debug_frame") != nil; got != tt.frame {
+			t.Errorf("-strip=%s: has .debug_frame = %v, want %v", tt.strip, got, tt.frame)
+		}
+		if !tt.dwarf {
+			continue
+		}
+		d, err := f.DWARF()
+		if err != nil {
+			t.Fatalf("-strip=%s: %v", tt.strip, err)
+		}
+		// Every unit keeps its line table header, with its files, but
+		// -strip=lines leaves no rows in it.
+		rows, files := 0, 0
+		r := d.Reader()
+		for {
+			e, err := r.Next()
+			if err != nil {
+				t.Fatalf("-strip=%s: %v", tt.strip, err)
+			}
+			if e == nil {
+				break
+			}
+			if !e.Children {
+				continue
+			}
+			lr, err := d.LineReader(e)
+			if err != nil {
+				t.Fatalf("-strip=%s: %v", tt.strip, err)
+			}
+			r.SkipChildren()
+			if lr == nil {
+				continue
+			}
+			files += len(lr.Files())
+			for {
+				var le dwarf.LineEntry
+				if lr.Next(&le) != nil {
+					break
+				}
+				rows++
+			}
+		}
+		if files == 0 {
+			t.Errorf("-strip=%s: the line tables have no files", tt.strip)
+		}
+		if got := rows > 0; got != tt.lines {
+			t.Errorf("-strip=%s: line table rows = %d, want rows %v", tt.strip, rows, tt.lines)
+		}
+	}
+}
@@ -211,6 +211,9 @@ func genelfsym(ctxt *Link, elfbind elf.SymBind) {
 	// Text symbols.
 	for _, s := range ctxt.Textp {
 		putelfsym(ctxt, s, elf.STT_FUNC, elfbind)
+		for _, a := range ctxt.icfAliases[s] {
+			putelfsym(ctxt, a, elf.STT_FUNC, elfbind)
+		}
 	}
 
 	// runtime.etext marker symbol.
@@ -270,6 +273,7 @@ func asmElfSym(ctxt *Link) {
 	putelfsyment(ctxt.Out, 0, 0, 0, elf.ST_INFO(elf.STB_LOCAL, elf.STT_NOTYPE), 0, 0)
 
 	dwarfaddelfsectionsyms(ctxt)
+	emitRelocsSectionSyms(ctxt)
 
 	// Some linkers will add a FILE sym if one is not present.
 	// Avoid having the working directory inserted into the symbol table.
@@ -454,6 +458,11 @@ func (ctxt *Link) symtab(pcln *pclntab) []sym.SymKind {
 	ctxt.xdefine("runtime.edata", sym.SDATA, 0)
 	ctxt.xdefine("runtime.bss", sym.SBSS, 0)
 	ctxt.xdefine("runtime.ebss", sym.SBSS, 0)
+	if ctxt.isStaticPIE() {
+		// The start of the dynamic section, at which the runtime
+		// finds the relocations it applies to itself.
+		ctxt.xdefine("_DYNAMIC", sym.SELFSECT, 0)
+	}
 	ctxt.xdefine("runtime.noptrbss", sym.SNOPTRBSS, 0)
 	ctxt.xdefine("runtime.enoptrbss", sym.SNOPTRBSS, 0)
 	ctxt.xdefine("runtime.covctrs", sym.SNOPTRBSS, 0)
@@ -72,6 +72,9 @@ func (t *Target) IsDynlinkingGo() bool {
 // UseRelro reports whether to make use of "read only relocations" aka
 // relro.
 func (t *Target) UseRelro() bool {
+	if t.IsELF && elfZRelro > 0 {
+		return true
+	}
 	switch t.BuildMode {
 	case BuildModeCArchive, BuildModeCShared, BuildModeShared, BuildModePIE, BuildModePlugin:
 		return t.IsELF || t.HeadType == objabi.Haix || t.HeadType == objabi.Hdarwin
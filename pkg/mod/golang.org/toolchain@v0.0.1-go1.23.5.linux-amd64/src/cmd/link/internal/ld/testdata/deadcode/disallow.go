@@ -0,0 +1,28 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Test that -disallow-packages fails the link for a package with live
+// symbols, encoding/hex, and not for one that is imported but dead,
+// container/list.
+
+package main
+
+import (
+	"container/list"
+	"encoding/hex"
+	"os"
+)
+
+//go:noinline
+func encode(b []byte) string {
+	return hex.Dump(b)
+}
+
+func unused() *list.List {
+	return list.New()
+}
+
+func main() {
+	os.Stdout.WriteString(encode([]byte("go")) + "\n")
+}
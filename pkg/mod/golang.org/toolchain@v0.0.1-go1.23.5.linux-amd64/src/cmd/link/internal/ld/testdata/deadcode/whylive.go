@@ -0,0 +1,18 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+//go:noinline
+func leaf() int { return 42 }
+
+//go:noinline
+func middle() int { return leaf() + 1 }
+
+//go:noinline
+func unused() int { return 0 }
+
+func main() {
+	println(middle())
+}
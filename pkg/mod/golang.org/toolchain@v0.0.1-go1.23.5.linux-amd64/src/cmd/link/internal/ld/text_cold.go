@@ -0,0 +1,68 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the splitting of the text by -splitcold, which
+// moves the functions that the -pgo profile never sees called, nor
+// -symbol-ordering-file lists, to a .text.unlikely section after the
+// others, so that the code that runs is packed into fewer pages.
+//
+// The two sections are contiguous, with runtime.text at the start of
+// .text and runtime.etext at the end of .text.unlikely, so the runtime
+// sees a single range of text and the pclntab and the method offsets
+// are relative to runtime.text as usual.
+
+import (
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+)
+
+// coldTextSect is the name of the section of the cold functions.
+const coldTextSect = ".text.unlikely"
+
+// coldTextAlign is the alignment of .text.unlikely: a page, so that no
+// page holds both hot and cold code. No function needs a larger one.
+const coldTextAlign = 4096
+
+// coldText is the first function of .text.unlikely, or 0. The others
+// follow it in ctxt.Textp.
+var coldText loader.Sym
+
+// isTextSect reports whether sect holds functions: one of the .text
+// sections or .text.unlikely.
+func isTextSect(sect *sym.Section) bool {
+	return sect.Name == ".text" || sect.Name == coldTextSect
+}
+
+// checkSplitCold checks that -splitcold can be used for the link.
+func checkSplitCold(ctxt *Link) {
+	if *flagPgo == "" {
+		Exitf("-splitcold needs -pgo")
+	}
+	if !ctxt.IsELF || (!ctxt.IsAMD64() && !ctxt.IsARM64()) || !ctxt.IsInternal() {
+		Exitf("-splitcold is only supported for ELF on amd64 and arm64, linking internally")
+	}
+}
+
+// splitColdText returns the section in which to place the function s,
+// which follows va in the text section sect: sect, ended at va, and
+// .text.unlikely when s is coldText. It returns the address from which
+// to place s too.
+func splitColdText(ctxt *Link, sect *sym.Section, s loader.Sym, va uint64) (*sym.Section, uint64) {
+	if s == 0 || s != coldText || sect.Name == coldTextSect {
+		return sect, va
+	}
+	sect.Length = va - sect.Vaddr
+	// The trampoline pass lays the text out again, into the section
+	// of the first pass.
+	cold := Segtext.Sections[len(Segtext.Sections)-1]
+	if cold.Name != coldTextSect {
+		cold = addsection(ctxt.loader, ctxt.Arch, &Segtext, coldTextSect, 05)
+	}
+	cold.Align = coldTextAlign
+	va = uint64(Rnd(int64(va), coldTextAlign))
+	cold.Vaddr = va
+	return cold, va
+}
@@ -0,0 +1,305 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains the ordering of functions in the text segment by
+// -symbol-ordering-file, which lists functions and data to place first,
+// as the option of lld does, and by -pgo, which takes the profile the compiler
+// optimizes with and places the functions that call each other most
+// next to each other, for fewer instruction cache and TLB misses.
+//
+// The profile gives the weights of call edges. As in the call-chain
+// clustering of lld's --call-graph-profile-sort, each function starts
+// in a cluster of its own and, from the heaviest edge down, the cluster
+// of a callee is appended to that of its caller when the callee is the
+// first function of its cluster and the two together are not too large.
+// The clusters are then placed in order of decreasing density, the
+// weight of the calls into a cluster per byte of it.
+
+import (
+	"bufio"
+	"bytes"
+	"cmd/internal/pgo"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// textOrderClusterLimit is the size beyond which -pgo stops growing a
+// cluster of functions.
+const textOrderClusterLimit = 1 << 20
+
+var (
+	// textOrderNames are the symbols of -symbol-ordering-file, in
+	// order.
+	textOrderNames []string
+
+	// symbolOrderFound are the names of -symbol-ordering-file that
+	// name functions or data of the link.
+	symbolOrderFound = make(map[string]bool)
+
+	// dataOrder gives the place in -symbol-ordering-file, from 1, of
+	// the data symbols it names.
+	dataOrder map[loader.Sym]int
+
+	// textOrderProfile is the profile of -pgo, or nil.
+	textOrderProfile *pgo.Profile
+
+	// textReordered is set once the functions are no longer in the order
+	// of their packages, so that calls within a package may be out of
+	// reach.
+	textReordered bool
+)
+
+// readSymbolOrderingFile reads the -symbol-ordering-file at path: the
+// name of a symbol on each line, ignoring blank lines and those that
+// start with #.
+func readSymbolOrderingFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+// readTextOrderProfile reads the -pgo profile at path, a pprof CPU
+// profile or one preprocessed by go tool preprofile, as the compiler's
+// -pgo does. It returns nil for a profile without samples.
+func readTextOrderProfile(path string) (*pgo.Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	isSerialized, err := pgo.IsSerialized(r)
+	if err != nil {
+		return nil, err
+	}
+	var p *pgo.Profile
+	if isSerialized {
+		p, err = pgo.FromSerialized(r)
+	} else {
+		p, err = pgo.FromPProf(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.TotalWeight == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// pgoTextOrder returns the functions of the call edges of p in the order
+// to place them, clustering callees with their callers. size reports
+// the size of a function of the link, and false for the names that are
+// not functions of the link, which are left out.
+func pgoTextOrder(p *pgo.Profile, size func(name string) (int64, bool)) []string {
+	type cluster struct {
+		names        []string
+		size, weight int64
+	}
+	clusters := make(map[string]*cluster)
+	get := func(name string) *cluster {
+		if c, ok := clusters[name]; ok {
+			return c
+		}
+		n, ok := size(name)
+		if !ok {
+			clusters[name] = nil
+			return nil
+		}
+		c := &cluster{names: []string{name}, size: n}
+		clusters[name] = c
+		return c
+	}
+	for _, e := range p.NamedEdgeMap.ByWeight {
+		w := p.NamedEdgeMap.Weight[e]
+		caller, callee := get(e.CallerName), get(e.CalleeName)
+		if caller == nil || callee == nil {
+			// A call between a function of the link and, say, one
+			// inlined everywhere still makes the first hot.
+			if caller != nil {
+				caller.weight += w
+			} else if callee != nil {
+				callee.weight += w
+			}
+			continue
+		}
+		callee.weight += w
+		if caller == callee || callee.names[0] != e.CalleeName || caller.size+callee.size > textOrderClusterLimit {
+			continue
+		}
+		caller.names = append(caller.names, callee.names...)
+		caller.size += callee.size
+		caller.weight += callee.weight
+		for _, name := range callee.names {
+			clusters[name] = caller
+		}
+	}
+
+	// The clusters that were not merged into others, densest first and
+	// otherwise in the order of their heaviest edges.
+	var heads []*cluster
+	seen := make(map[*cluster]bool)
+	for _, e := range p.NamedEdgeMap.ByWeight {
+		for _, name := range []string{e.CallerName, e.CalleeName} {
+			if c := clusters[name]; c != nil && !seen[c] {
+				seen[c] = true
+				heads = append(heads, c)
+			}
+		}
+	}
+	density := func(c *cluster) float64 {
+		if c.size == 0 {
+			return float64(c.weight)
+		}
+		return float64(c.weight) / float64(c.size)
+	}
+	sort.SliceStable(heads, func(i, j int) bool {
+		return density(heads[i]) > density(heads[j])
+	})
+	var names []string
+	for _, c := range heads {
+		names = append(names, c.names...)
+	}
+	return names
+}
+
+// movableText returns the part of ctxt.Textp that -randlayout and the
+// text ordering may rearrange: all but the build ID, which stays first,
+// and the functions of host objects, which come first and stay with the
+// other functions of their sections.
+func movableText(ctxt *Link) []loader.Sym {
+	ldr := ctxt.loader
+	textp := ctxt.Textp
+	i := 0
+	// don't move the buildid symbol
+	if len(textp) > 0 && ldr.SymName(textp[0]) == "go:buildid" {
+		i++
+	}
+	// Skip over C symbols, as functions in a (C object) section must stay together.
+	// TODO: maybe we can move a section as a whole.
+	// Note: we load C symbols before Go symbols, so we can scan from the start.
+	for i < len(textp) && (ldr.SubSym(textp[i]) != 0 || ldr.AttrSubSymbol(textp[i])) {
+		i++
+	}
+	return textp[i:]
+}
+
+// orderText moves the functions that -symbol-ordering-file lists, then
+// those that -pgo orders, to the start of the Go functions, leaving the
+// others in their order after them.
+func (ctxt *Link) orderText() {
+	ldr := ctxt.loader
+	textp := movableText(ctxt)
+	byName := make(map[string][]loader.Sym)
+	for _, s := range textp {
+		name := ldr.SymName(s)
+		byName[name] = append(byName[name], s)
+	}
+
+	names := textOrderNames
+	if textOrderProfile != nil {
+		names = append(names[:len(names):len(names)], pgoTextOrder(textOrderProfile, func(name string) (int64, bool) {
+			syms, ok := byName[name]
+			var n int64
+			for _, s := range syms {
+				n += ldr.SymSize(s)
+			}
+			return n, ok
+		})...)
+	}
+
+	placed := make(map[loader.Sym]bool)
+	order := make([]loader.Sym, 0, len(textp))
+	var missing bytes.Buffer
+	for i, name := range names {
+		syms, ok := byName[name]
+		if !ok && ctxt.Debugvlog != 0 {
+			fmt.Fprintf(&missing, " %s", name)
+		}
+		if ok && i < len(textOrderNames) {
+			symbolOrderFound[name] = true
+		}
+		for _, s := range syms {
+			if !placed[s] {
+				placed[s] = true
+				order = append(order, s)
+			}
+		}
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("text order: placed %d of %d functions first\n", len(order), len(textp))
+		if missing.Len() > 0 {
+			ctxt.Logf("text order: not functions of the link:%s\n", missing.Bytes())
+		}
+	}
+	if len(order) == 0 {
+		return
+	}
+	nplaced := len(order)
+	for _, s := range textp {
+		if !placed[s] {
+			order = append(order, s)
+		}
+	}
+	copy(textp, order)
+	textReordered = true
+	if *flagSplitCold && nplaced < len(textp) {
+		coldText = textp[nplaced]
+		if ctxt.Debugvlog != 0 {
+			ctxt.Logf("text order: %d cold functions from %s\n", len(textp)-nplaced, ldr.SymName(coldText))
+		}
+	}
+}
+
+// orderableData are the kinds of data symbols that -symbol-ordering-file
+// may place first in their sections.
+var orderableData = []sym.SymKind{sym.SRODATA, sym.SNOPTRDATA, sym.SDATA, sym.SBSS, sym.SNOPTRBSS}
+
+// orderData records the place of the data symbols that
+// -symbol-ordering-file names, for dodataSect to lay them out first in
+// their sections, in the order of the file, and warns of the names of
+// the file that are neither functions nor data of the link. It is
+// called by dodata once the data symbols are collected, after the text
+// is ordered.
+func (state *dodataState) orderData(ctxt *Link) {
+	ldr := ctxt.loader
+	place := make(map[string]int)
+	for i, name := range textOrderNames {
+		if _, ok := place[name]; !ok {
+			place[name] = i + 1
+		}
+	}
+	dataOrder = make(map[loader.Sym]int)
+	for _, kind := range orderableData {
+		for _, s := range state.data[kind] {
+			name := ldr.SymName(s)
+			if p, ok := place[name]; ok {
+				dataOrder[s] = p
+				symbolOrderFound[name] = true
+			}
+		}
+	}
+	for _, name := range textOrderNames {
+		if !symbolOrderFound[name] {
+			ctxt.Logf("warning: -symbol-ordering-file: no such symbol: %s\n", name)
+			symbolOrderFound[name] = true // once for each name
+		}
+	}
+}
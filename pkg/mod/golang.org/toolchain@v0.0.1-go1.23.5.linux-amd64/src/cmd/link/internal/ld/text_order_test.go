@@ -0,0 +1,225 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"cmd/internal/pgo"
+	"debug/elf"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testTextOrderProfile returns a profile with the call edges, given as
+// caller, callee and weight.
+func testTextOrderProfile(edges ...any) *pgo.Profile {
+	p := &pgo.Profile{NamedEdgeMap: pgo.NamedEdgeMap{Weight: make(map[pgo.NamedCallEdge]int64)}}
+	for i := 0; i < len(edges); i += 3 {
+		e := pgo.NamedCallEdge{CallerName: edges[i].(string), CalleeName: edges[i+1].(string)}
+		w := int64(edges[i+2].(int))
+		p.NamedEdgeMap.Weight[e] = w
+		p.NamedEdgeMap.ByWeight = append(p.NamedEdgeMap.ByWeight, e)
+		p.TotalWeight += w
+	}
+	sort.SliceStable(p.NamedEdgeMap.ByWeight, func(i, j int) bool {
+		return p.NamedEdgeMap.Weight[p.NamedEdgeMap.ByWeight[i]] > p.NamedEdgeMap.Weight[p.NamedEdgeMap.ByWeight[j]]
+	})
+	return p
+}
+
+func TestPgoTextOrder(t *testing.T) {
+	sizes := map[string]int64{"a": 100, "b": 100, "c": 100, "d": 100, "e": 100, "big": textOrderClusterLimit}
+	size := func(name string) (int64, bool) {
+		n, ok := sizes[name]
+		return n, ok
+	}
+	for _, tc := range []struct {
+		p    *pgo.Profile
+		want []string
+	}{
+		// A chain of calls stays together, callees after callers.
+		{testTextOrderProfile("a", "b", 10, "b", "c", 5), []string{"a", "b", "c"}},
+		// A callee already placed after another caller stays there.
+		{testTextOrderProfile("a", "c", 10, "b", "c", 5), []string{"a", "c", "b"}},
+		// Denser clusters come first.
+		{testTextOrderProfile("a", "b", 10, "d", "e", 50, "d", "d", 1000), []string{"d", "e", "a", "b"}},
+		// Functions not in the link are left out.
+		{testTextOrderProfile("a", "missing", 10, "missing", "b", 5), []string{"a", "b"}},
+		// Clusters do not grow too large.
+		{testTextOrderProfile("a", "big", 10), []string{"big", "a"}},
+	} {
+		if got := pgoTextOrder(tc.p, size); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.p.NamedEdgeMap.ByWeight, got, tc.want)
+		}
+	}
+}
+
+const textOrderSrc = `package main
+
+//go:noinline
+func fa() int { return 1 }
+
+//go:noinline
+func fb() int { return fa() + 2 }
+
+//go:noinline
+func fc() int { return 3 }
+
+//go:noinline
+func fd() int { return fc() + 4 }
+
+var da [16]byte
+
+var db [32]byte
+
+func main() { println(fb() + fd() + int(da[0]) + int(db[0])) }
+`
+
+// TestTextOrder links for linux/amd64 with -symbol-ordering-file and
+// -pgo and checks the order of the functions in the text segment and
+// of the data, and the warning of a name that is not in the link.
+func TestTextOrder(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(textOrderSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	orderFile := filepath.Join(dir, "order.txt")
+	if err := os.WriteFile(orderFile, []byte("# hot\nmain.fd\nmain.db\n\nmain.fc\nmain.nosuchfunc\nmain.da\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	prof := filepath.Join(dir, "prof.pgo")
+	f, err := os.Create(prof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTextOrderProfile("main.fb", "main.fa", 100, "main.main", "main.fb", 10).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := filepath.Join(dir, "a.out")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-symbol-ordering-file="+orderFile+" -pgo="+prof, "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	if want := "warning: -symbol-ordering-file: no such symbol: main.nosuchfunc"; !strings.Contains(string(out), want) {
+		t.Errorf("output does not contain %q:\n%s", want, out)
+	}
+
+	ef, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	syms, err := ef.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := make(map[string]uint64)
+	for _, s := range syms {
+		addr[s.Name] = s.Value
+	}
+	for _, want := range [][]string{
+		{"main.fd", "main.fc", "main.main", "main.fb", "main.fa", "runtime.main"},
+		{"main.db", "main.da"},
+	} {
+		for i := 1; i < len(want); i++ {
+			if addr[want[i-1]] == 0 || addr[want[i-1]] >= addr[want[i]] {
+				t.Errorf("%s at %#x, not before %s at %#x", want[i-1], addr[want[i-1]], want[i], addr[want[i]])
+			}
+		}
+	}
+}
+
+// TestSplitCold links for linux/amd64 with -pgo and -splitcold and
+// checks that the functions of the profile are in .text and the others
+// in .text.unlikely, and that the program still runs and unwinds.
+func TestSplitCold(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte(textOrderSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	prof := filepath.Join(dir, "prof.pgo")
+	f, err := os.Create(prof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTextOrderProfile("main.fb", "main.fa", 100, "main.main", "main.fb", 10, "runtime.main", "main.main", 10).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := filepath.Join(dir, "a.out")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-pgo="+prof+" -splitcold", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	ef, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	text, cold := ef.Section(".text"), ef.Section(coldTextSect)
+	if text == nil || cold == nil {
+		t.Fatalf("missing .text or %s section", coldTextSect)
+	}
+	if cold.Addr%coldTextAlign != 0 || cold.Addr < text.Addr+text.Size {
+		t.Errorf("%s at %#x, not page aligned after .text at %#x+%#x", coldTextSect, cold.Addr, text.Addr, text.Size)
+	}
+	syms, err := ef.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(map[string]*elf.Section)
+	for _, s := range syms {
+		if int(s.Section) < len(ef.Sections) {
+			in[s.Name] = ef.Sections[s.Section]
+		}
+	}
+	for _, name := range []string{"main.main", "main.fa", "main.fb", "runtime.main"} {
+		if in[name] != text {
+			t.Errorf("%s is not in .text", name)
+		}
+	}
+	for _, name := range []string{"main.fc", "main.fd", "runtime.gcStart"} {
+		if in[name] != cold {
+			t.Errorf("%s is not in %s", name, coldTextSect)
+		}
+	}
+
+	if runtime.GOOS == "linux" && runtime.GOARCH == "amd64" {
+		out, err := testenv.Command(t, exe).CombinedOutput()
+		if err != nil || string(out) != "10\n" {
+			t.Errorf("%s: %v: %q", exe, err, out)
+		}
+	}
+}
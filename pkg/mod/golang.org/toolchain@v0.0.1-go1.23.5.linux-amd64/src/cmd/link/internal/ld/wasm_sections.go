@@ -0,0 +1,161 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"fmt"
+	"internal/buildcfg"
+	"os"
+	"strings"
+)
+
+// A WasmCustomSection is a custom section that -wasmsection adds to a
+// wasm module.
+type WasmCustomSection struct {
+	Name string
+	Data []byte
+}
+
+// A WasmProducer is a value that -wasmproducer adds to a field of the
+// producers section of a wasm module.
+type WasmProducer struct {
+	Field   string // language, processed-by or sdk
+	Name    string
+	Version string
+}
+
+// A WasmTargetFeature is an entry of the target_features section of a
+// wasm module: a feature, such as sign-ext, that the module uses
+// (Prefix '+') or must not be linked with a module using (Prefix '-').
+type WasmTargetFeature struct {
+	Prefix byte
+	Name   string
+}
+
+var (
+	// WasmCustomSections are the sections given by -wasmsection, in
+	// order.
+	WasmCustomSections []WasmCustomSection
+
+	// WasmProducers are the values given by -wasmproducer, in order.
+	WasmProducers []WasmProducer
+
+	// WasmTargetFeatures are the features given by -wasmtargetfeatures.
+	WasmTargetFeatures []WasmTargetFeature
+
+	// wasmFlags are the wasm flags given, for the check that the
+	// target is wasm.
+	wasmFlags []string
+)
+
+// wasmReservedSections are the custom sections the linker writes
+// itself, which -wasmsection cannot add.
+var wasmReservedSections = []string{"go:buildid", "name", "producers", "target_features"}
+
+// wasmProducerFields are the fields of the producers section.
+var wasmProducerFields = []string{"language", "processed-by", "sdk"}
+
+// WasmDefaultProducers returns the values the linker adds to the
+// producers section of every wasm module.
+func WasmDefaultProducers() []WasmProducer {
+	return []WasmProducer{
+		{"language", "Go", buildcfg.Version},
+		{"processed-by", "Go cmd/compile", buildcfg.Version},
+	}
+}
+
+// parseWasmSection parses the name=file argument of -wasmsection.
+func parseWasmSection(s string) (WasmCustomSection, error) {
+	name, file, ok := strings.Cut(s, "=")
+	if !ok || name == "" || file == "" {
+		return WasmCustomSection{}, fmt.Errorf("%q is not of the form name=file", s)
+	}
+	for _, r := range wasmReservedSections {
+		if name == r {
+			return WasmCustomSection{}, fmt.Errorf("the linker writes the %s section itself", name)
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return WasmCustomSection{}, err
+	}
+	return WasmCustomSection{name, data}, nil
+}
+
+// parseWasmProducer parses the field=name[@version] argument of
+// -wasmproducer. A value is unique within its field, so one named as a
+// value of prev in the field is an error.
+func parseWasmProducer(s string, prev []WasmProducer) (WasmProducer, error) {
+	field, value, ok := strings.Cut(s, "=")
+	name, version, _ := strings.Cut(value, "@")
+	if !ok || name == "" {
+		return WasmProducer{}, fmt.Errorf("%q is not of the form field=name[@version]", s)
+	}
+	known := false
+	for _, f := range wasmProducerFields {
+		known = known || f == field
+	}
+	if !known {
+		return WasmProducer{}, fmt.Errorf("unknown field %q (want one of %s)", field, strings.Join(wasmProducerFields, ", "))
+	}
+	for _, p := range prev {
+		if p.Field == field && p.Name == name {
+			return WasmProducer{}, fmt.Errorf("%s %q is given twice", field, name)
+		}
+	}
+	return WasmProducer{field, name, version}, nil
+}
+
+// parseWasmTargetFeatures parses the comma-separated list of features
+// given to -wasmtargetfeatures. A feature without a prefix is used.
+func parseWasmTargetFeatures(s string) ([]WasmTargetFeature, error) {
+	var features []WasmTargetFeature
+	for _, f := range strings.Split(s, ",") {
+		prefix := byte('+')
+		if f != "" && (f[0] == '+' || f[0] == '-') {
+			prefix, f = f[0], f[1:]
+		}
+		if f == "" {
+			return nil, fmt.Errorf("empty feature in %q", s)
+		}
+		for _, g := range features {
+			if g.Name == f {
+				return nil, fmt.Errorf("feature %q is given twice", f)
+			}
+		}
+		features = append(features, WasmTargetFeature{prefix, f})
+	}
+	return features, nil
+}
+
+// wasmSection handles -wasmsection name=file.
+func wasmSection(s string) {
+	sect, err := parseWasmSection(s)
+	if err != nil {
+		Exitf("-wasmsection: %v", err)
+	}
+	WasmCustomSections = append(WasmCustomSections, sect)
+	wasmFlags = append(wasmFlags, "-wasmsection")
+}
+
+// wasmProducer handles -wasmproducer field=name[@version].
+func wasmProducer(s string) {
+	p, err := parseWasmProducer(s, append(WasmDefaultProducers(), WasmProducers...))
+	if err != nil {
+		Exitf("-wasmproducer: %v", err)
+	}
+	WasmProducers = append(WasmProducers, p)
+	wasmFlags = append(wasmFlags, "-wasmproducer")
+}
+
+// wasmTargetFeatures handles -wasmtargetfeatures list.
+func wasmTargetFeatures(s string) {
+	features, err := parseWasmTargetFeatures(s)
+	if err != nil {
+		Exitf("-wasmtargetfeatures: %v", err)
+	}
+	WasmTargetFeatures = features
+	wasmFlags = append(wasmFlags, "-wasmtargetfeatures")
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"encoding/binary"
+	"internal/testenv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseWasmProducer(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want WasmProducer
+		ok   bool
+	}{
+		{"processed-by=wasm-opt@116", WasmProducer{"processed-by", "wasm-opt", "116"}, true},
+		{"sdk=mysdk", WasmProducer{"sdk", "mysdk", ""}, true},
+		{"language=Go", WasmProducer{}, false},
+		{"compiler=x@1", WasmProducer{}, false},
+		{"sdk=", WasmProducer{}, false},
+		{"sdk", WasmProducer{}, false},
+	} {
+		got, err := parseWasmProducer(tc.s, WasmDefaultProducers())
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("%q: got %+v, %v", tc.s, got, err)
+		}
+	}
+}
+
+func TestParseWasmTargetFeatures(t *testing.T) {
+	got, err := parseWasmTargetFeatures("sign-ext,+bulk-memory,-simd128")
+	want := []WasmTargetFeature{{'+', "sign-ext"}, {'+', "bulk-memory"}, {'-', "simd128"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, %v, want %+v", got, err, want)
+	}
+	for _, s := range []string{"", "sign-ext,", "-", "simd128,-simd128"} {
+		if got, err := parseWasmTargetFeatures(s); err == nil {
+			t.Errorf("%q: got %+v, want error", s, got)
+		}
+	}
+}
+
+// TestWasmSections links for wasip1 with the wasm section flags and
+// checks the custom sections of the module.
+func TestWasmSections(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for wasip1")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "data")
+	if err := os.WriteFile(data, []byte("some data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "a.wasm")
+	ldflags := "-ldflags=-wasmnames -wasmsection=mysection=" + data + " -wasmproducer=processed-by=wasm-opt@116 -wasmtargetfeatures=sign-ext,-simd128"
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", ldflags, "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+
+	b, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := make(map[string][]byte)
+	var order []string
+	for b = b[8:]; len(b) > 0; {
+		id := b[0]
+		size, n := binary.Uvarint(b[1:])
+		body := b[1+n : 1+n+int(size)]
+		b = b[1+n+int(size):]
+		if id != 0 {
+			continue
+		}
+		nlen, n := binary.Uvarint(body)
+		name := string(body[n : n+int(nlen)])
+		custom[name] = body[n+int(nlen):]
+		order = append(order, name)
+	}
+
+	if got := string(custom["mysection"]); got != "some data" {
+		t.Errorf("mysection holds %q", got)
+	}
+	if p := custom["producers"]; !bytes.Contains(p, []byte("\x0cprocessed-by\x02")) || !bytes.Contains(p, []byte("\x08wasm-opt\x03116")) {
+		t.Errorf("producers section %q", p)
+	}
+	if got, want := custom["target_features"], []byte("\x02+\x08sign-ext-\x07simd128"); !bytes.Equal(got, want) {
+		t.Errorf("target_features section %q, want %q", got, want)
+	}
+
+	// The name section has subsections for the functions, the locals
+	// and the globals, in that order.
+	var ids []byte
+	for ns := custom["name"]; len(ns) > 0; {
+		size, n := binary.Uvarint(ns[1:])
+		ids = append(ids, ns[0])
+		ns = ns[1+n+int(size):]
+	}
+	if !bytes.Equal(ids, []byte{1, 2, 7}) {
+		t.Errorf("name section has subsections %v, want [1 2 7]", ids)
+	}
+	for _, name := range []string{"wasi_snapshot_preview1.fd_write", "PC_B", "RET0"} {
+		if !bytes.Contains(custom["name"], []byte(name)) {
+			t.Errorf("name section does not hold %s", name)
+		}
+	}
+	if len(order) == 0 || order[len(order)-1] != "name" {
+		t.Errorf("custom sections %v, want name last", order)
+	}
+}
@@ -1774,12 +1774,14 @@ dwarfLoop:
 // xcoffCreateExportFile creates a file with exported symbols for
 // -Wl,-bE option.
 // ld won't export symbols unless they are listed in an export file.
+// With -export-symbols or -export-dynamic-symbol, it lists just the
+// symbols given by them.
 func xcoffCreateExportFile(ctxt *Link) (fname string) {
 	fname = filepath.Join(*flagTmpdir, "export_file.exp")
 	var buf bytes.Buffer
 
 	ldr := ctxt.loader
-	for s, nsym := loader.Sym(1), loader.Sym(ldr.NSym()); s < nsym; s++ {
+	for s, nsym := loader.Sym(1), loader.Sym(ldr.NSym()); s < nsym && !exportsRestricted(); s++ {
 		if !ldr.AttrCgoExport(s) {
 			continue
 		}
@@ -1799,6 +1801,9 @@ func xcoffCreateExportFile(ctxt *Link) (fname string) {
 
 		buf.Write([]byte(name + "\n"))
 	}
+	for _, name := range exportSymbols {
+		buf.WriteString(name + "\n")
+	}
 
 	err := os.WriteFile(fname, buf.Bytes(), 0666)
 	if err != nil {
@@ -0,0 +1,657 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+// This file contains a zstd compressor (RFC 8878) for the DWARF
+// sections of -compressdwarf=zstd. It writes a single frame of
+// compressed blocks, with FSE-coded sequences found by a hash chain
+// search whose depth the level sets. It never uses repeat offsets or
+// dictionaries, and the output depends only on the input and the level.
+//
+// The literals are stored raw: Huffman coding them saves only about 1%
+// of the DWARF, while the FSE tables fitted to the sequences of each
+// block are what makes the output smaller than that of zlib.
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+const (
+	zstdMagic        = 0xFD2FB528
+	zstdBlockSize    = 128 << 10 // largest block content
+	zstdWindowLog    = 22        // window of frames too large for one segment
+	zstdMinMatch     = 4         // shortest match searched for
+	zstdDefaultLevel = 3
+	zstdMaxLevel     = 22
+)
+
+// zstdCompress appends to dst the zstd frame of src, compressed at
+// level, from 1 to zstdMaxLevel.
+func zstdCompress(dst, src []byte, level int) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, zstdMagic)
+
+	// A frame small enough has a single segment, whose window is the
+	// whole content. Otherwise decoders need only keep the window.
+	n := uint64(len(src))
+	single := n <= 1<<zstdWindowLog
+	var fcsFlag byte
+	switch {
+	case single && n < 256:
+		fcsFlag = 0
+	case n >= 256 && n < 256+1<<16:
+		fcsFlag = 1
+	case n <= math.MaxUint32:
+		fcsFlag = 2
+	default:
+		fcsFlag = 3
+	}
+	desc := fcsFlag << 6
+	if single {
+		desc |= 1 << 5
+	}
+	dst = append(dst, desc)
+	if !single {
+		dst = append(dst, (zstdWindowLog-10)<<3)
+	}
+	switch fcsFlag {
+	case 0:
+		dst = append(dst, byte(n))
+	case 1:
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(n-256))
+	case 2:
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(n))
+	case 3:
+		dst = binary.LittleEndian.AppendUint64(dst, n)
+	}
+
+	if len(src) == 0 {
+		return zstdAppendBlockHeader(dst, true, 0, 0)
+	}
+	e := newZstdEncoder(src, level)
+	for start := 0; start < len(src); start += zstdBlockSize {
+		end := start + zstdBlockSize
+		if end > len(src) {
+			end = len(src)
+		}
+		dst = e.appendBlock(dst, start, end)
+	}
+	return dst
+}
+
+// zstdAppendBlockHeader appends the header of a block of type typ:
+// 0 for raw blocks and 2 for compressed ones.
+func zstdAppendBlockHeader(dst []byte, last bool, typ, size int) []byte {
+	h := typ<<1 | size<<3
+	if last {
+		h |= 1
+	}
+	return append(dst, byte(h), byte(h>>8), byte(h>>16))
+}
+
+// A zstdSeq is a sequence: literals followed by a match.
+type zstdSeq struct {
+	litLen, matchLen, offset uint32
+}
+
+// A zstdEncoder finds the sequences of the blocks of src.
+type zstdEncoder struct {
+	src []byte
+
+	depth     int  // candidates tried for each match
+	nice      int  // length of a match good enough to stop at
+	lazy      bool // try a longer match at the next byte
+	insertAll bool // hash every position of a match
+	hashShift uint
+	head      []int32 // last position + 1 by hash
+	chain     []int32 // previous position + 1 with the same hash, by position
+
+	lits []byte
+	seqs []zstdSeq
+}
+
+func newZstdEncoder(src []byte, level int) *zstdEncoder {
+	e := &zstdEncoder{
+		src:       src,
+		depth:     1 << zstdMin(level-1, 8),
+		nice:      16 << zstdMin(level/2, 6),
+		lazy:      level >= 4,
+		insertAll: level >= 3,
+	}
+	hashLog := zstdMin(16+zstdMin(level, 4), bits.Len(uint(len(src)))+1)
+	e.hashShift = uint(32 - hashLog)
+	e.head = make([]int32, 1<<hashLog)
+	if e.depth > 1 {
+		chainLog := zstdMin(zstdMin(16+level/2, zstdWindowLog), bits.Len(uint(len(src))))
+		e.chain = make([]int32, 1<<chainLog)
+	}
+	return e
+}
+
+// zstdMin returns the smaller of a and b.
+func zstdMin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (e *zstdEncoder) hash(p int) uint32 {
+	return binary.LittleEndian.Uint32(e.src[p:]) * 2654435761 >> e.hashShift
+}
+
+func (e *zstdEncoder) insert(p int) {
+	h := e.hash(p)
+	if e.chain != nil {
+		e.chain[p&(len(e.chain)-1)] = e.head[h]
+	}
+	e.head[h] = int32(p + 1)
+}
+
+// findMatch returns the longest match for the bytes at p, which end
+// at end, among the candidates searched, and its offset.
+func (e *zstdEncoder) findMatch(p, end int) (length, offset int) {
+	src := e.src
+	cand := int(e.head[e.hash(p)]) - 1
+	for n := e.depth; n > 0 && cand >= 0; n-- {
+		off := p - cand
+		if off >= 1<<zstdWindowLog {
+			break
+		}
+		if l := zstdMatchLen(src[cand:], src[p:end]); l > length {
+			length, offset = l, off
+			if l >= e.nice || l == end-p {
+				break
+			}
+		}
+		// The chain holds the positions within its length of p only.
+		if e.chain == nil || off >= len(e.chain) {
+			break
+		}
+		cand = int(e.chain[cand&(len(e.chain)-1)]) - 1
+	}
+	return length, offset
+}
+
+// zstdMatchLen returns the length of the common prefix of a and b,
+// where a is at least as long as b.
+func zstdMatchLen(a, b []byte) int {
+	n := 0
+	for len(b)-n >= 8 {
+		if x := binary.LittleEndian.Uint64(a[n:]) ^ binary.LittleEndian.Uint64(b[n:]); x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// parse sets e.lits and e.seqs to the literals and the sequences of
+// the block from start to end.
+func (e *zstdEncoder) parse(start, end int) {
+	e.lits, e.seqs = e.lits[:0], e.seqs[:0]
+	lit := start
+	last := end - zstdMinMatch // last position to hash
+	for p := start; p <= last; {
+		l, off := e.findMatch(p, end)
+		e.insert(p)
+		if l < zstdMinMatch {
+			p++
+			continue
+		}
+		for e.lazy && p+1 <= last {
+			l1, off1 := e.findMatch(p+1, end)
+			if l1 <= l {
+				break
+			}
+			p++
+			e.insert(p)
+			l, off = l1, off1
+		}
+		e.lits = append(e.lits, e.src[lit:p]...)
+		e.seqs = append(e.seqs, zstdSeq{uint32(p - lit), uint32(l), uint32(off)})
+		if e.insertAll {
+			for q := p + 1; q < p+l && q <= last; q++ {
+				e.insert(q)
+			}
+		}
+		p += l
+		lit = p
+	}
+	e.lits = append(e.lits, e.src[lit:end]...)
+}
+
+// appendBlock appends the block from start to end, compressed unless
+// that would not make it smaller.
+func (e *zstdEncoder) appendBlock(dst []byte, start, end int) []byte {
+	last := end == len(e.src)
+	e.parse(start, end)
+	h := len(dst)
+	dst = zstdAppendBlockHeader(dst, last, 2, 0)
+	dst = zstdAppendLiterals(dst, e.lits)
+	dst = e.appendSeqs(dst)
+	if size := len(dst) - h - 3; size < end-start {
+		zstdAppendBlockHeader(dst[:h], last, 2, size)
+		return dst
+	}
+	dst = zstdAppendBlockHeader(dst[:h], last, 0, end-start)
+	return append(dst, e.src[start:end]...)
+}
+
+// zstdAppendLiterals appends the literals section of lits, raw.
+func zstdAppendLiterals(dst, lits []byte) []byte {
+	switch n := len(lits); {
+	case n < 32:
+		dst = append(dst, byte(n)<<3)
+	case n < 4096:
+		dst = append(dst, 1<<2|byte(n)<<4, byte(n>>4))
+	default:
+		dst = append(dst, 3<<2|byte(n)<<4, byte(n>>4), byte(n>>12))
+	}
+	return append(dst, lits...)
+}
+
+// The literal length codes from 16 and the match length codes from
+// 32 up to the first one of a power of 2: the baseline of each, with
+// the number of extra bits above 16 bits. RFC 8878 3.1.1.3.2.1.1.
+var (
+	zstdLitLenCodes = []uint32{
+		16 | 1<<16, 18 | 1<<16, 20 | 1<<16, 22 | 1<<16, 24 | 2<<16,
+		28 | 2<<16, 32 | 3<<16, 40 | 3<<16, 48 | 4<<16,
+	}
+	zstdMatchLenCodes = []uint32{
+		35 | 1<<16, 37 | 1<<16, 39 | 1<<16, 41 | 1<<16, 43 | 2<<16,
+		47 | 2<<16, 51 | 3<<16, 59 | 3<<16, 67 | 4<<16, 83 | 4<<16,
+		99 | 5<<16,
+	}
+)
+
+// zstdTableCode returns the code, from first, of v in codes, its extra
+// bits and their number.
+func zstdTableCode(v uint32, first int, codes []uint32) (uint8, uint32, uint8) {
+	i := len(codes) - 1
+	for v < codes[i]&0xffff {
+		i--
+	}
+	base := codes[i] & 0xffff
+	return uint8(first + i), v - base, uint8(codes[i] >> 16)
+}
+
+// zstdSeqCodes returns the literal length, offset and match length
+// codes of s, with their extra bits and the number of these.
+func zstdSeqCodes(s zstdSeq) (codes [3]uint8, extra [3]uint32, nbits [3]uint8) {
+	switch ll := s.litLen; {
+	case ll < 16:
+		codes[0] = uint8(ll)
+	case ll < 64:
+		codes[0], extra[0], nbits[0] = zstdTableCode(ll, 16, zstdLitLenCodes)
+	default:
+		hb := bits.Len32(ll) - 1
+		codes[0], extra[0], nbits[0] = uint8(19+hb), ll-1<<hb, uint8(hb)
+	}
+
+	// Offsets are above 3, which are the repeat offsets.
+	ov := s.offset + 3
+	hb := bits.Len32(ov) - 1
+	codes[1], extra[1], nbits[1] = uint8(hb), ov-1<<hb, uint8(hb)
+
+	switch ml := s.matchLen; {
+	case ml < 35:
+		codes[2] = uint8(ml - 3)
+	case ml < 131:
+		codes[2], extra[2], nbits[2] = zstdTableCode(ml, 32, zstdMatchLenCodes)
+	default:
+		hb := bits.Len32(ml-3) - 1
+		codes[2], extra[2], nbits[2] = uint8(36+hb), ml-3-1<<hb, uint8(hb)
+	}
+	return codes, extra, nbits
+}
+
+// The predefined distributions of the literal length, offset and match
+// length codes. RFC 8878 3.1.1.3.2.2.
+var zstdPredefined = [3]struct {
+	log  uint8
+	norm []int16
+}{
+	{6, []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}},
+	{5, []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}},
+	{6, []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}},
+}
+
+// zstdMaxSeqLog is the largest accuracy log of the literal length,
+// offset and match length codes.
+var zstdMaxSeqLog = [3]int{9, 8, 9}
+
+// appendSeqs appends the sequences section of e.seqs. Each kind of
+// code uses the predefined distribution, or its own when describing
+// it costs less than it saves.
+func (e *zstdEncoder) appendSeqs(dst []byte) []byte {
+	n := len(e.seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8)+128, byte(n))
+	default:
+		dst = append(dst, 0xFF, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+
+	codes := make([][3]uint8, n)
+	extra := make([][3]uint32, n)
+	nbits := make([][3]uint8, n)
+	var counts [3][53]int
+	for i, s := range e.seqs {
+		codes[i], extra[i], nbits[i] = zstdSeqCodes(s)
+		for k, c := range codes[i] {
+			counts[k][c]++
+		}
+	}
+
+	modes := len(dst)
+	dst = append(dst, 0)
+	var tables [3]*zstdFSE
+	for k := range tables {
+		mode, norm, log, desc := zstdChooseTable(counts[k][:], n, zstdPredefined[k].norm, zstdPredefined[k].log, zstdMaxSeqLog[k])
+		dst[modes] |= mode << (6 - 2*k)
+		dst = append(dst, desc...)
+		tables[k] = newZstdFSE(norm, log)
+	}
+
+	// The decoder reads the sequences from the first, and the fields
+	// of each in the reverse order of their writing.
+	const ll, of, ml = 0, 1, 2
+	w := zstdBitWriter{out: dst}
+	var state [3]uint32
+	for i := n - 1; i >= 0; i-- {
+		if i == n-1 {
+			state[ml] = tables[ml].init(codes[i][ml])
+			state[of] = tables[of].init(codes[i][of])
+			state[ll] = tables[ll].init(codes[i][ll])
+		} else {
+			tables[of].encode(&w, &state[of], codes[i][of])
+			tables[ml].encode(&w, &state[ml], codes[i][ml])
+			tables[ll].encode(&w, &state[ll], codes[i][ll])
+		}
+		w.add(extra[i][ll], uint(nbits[i][ll]))
+		w.add(extra[i][ml], uint(nbits[i][ml]))
+		w.add(extra[i][of], uint(nbits[i][of]))
+	}
+	tables[ml].flush(&w, state[ml])
+	tables[of].flush(&w, state[of])
+	tables[ll].flush(&w, state[ll])
+	w.close()
+	return w.out
+}
+
+// zstdChooseTable returns the compression mode of the n codes with
+// counts, its distribution and accuracy log, and the description of
+// the table that follows the modes: RLE for a single code, the
+// predefined distribution, or one normalized from counts.
+func zstdChooseTable(counts []int, n int, pre []int16, preLog uint8, maxLog int) (mode byte, norm []int16, log uint8, desc []byte) {
+	distinct, last := 0, 0
+	for c, k := range counts {
+		if k != 0 {
+			distinct++
+			last = c
+		}
+	}
+	if distinct == 1 {
+		norm = make([]int16, last+1)
+		norm[last] = 1
+		return 1, norm, 0, []byte{byte(last)}
+	}
+
+	// The cost in bits of coding the counts with a distribution.
+	cost := func(norm []int16, log uint8) float64 {
+		bits := 0.0
+		for c, k := range counts {
+			if k == 0 {
+				continue
+			}
+			if c >= len(norm) || norm[c] == 0 {
+				return math.Inf(1)
+			}
+			p := float64(norm[c])
+			if p < 1 {
+				p = 1 // -1, a probability below 1
+			}
+			bits += float64(k) * (float64(log) - math.Log2(p))
+		}
+		return bits
+	}
+
+	l := bits.Len(uint(n)) - 1
+	if d := bits.Len(uint(distinct)) + 1; d > l {
+		l = d
+	}
+	if l < 5 {
+		l = 5
+	}
+	log = uint8(zstdMin(l, maxLog))
+	norm = zstdNormalize(counts[:last+1], n, log)
+	var w zstdBitWriter
+	w.appendNCount(norm, log)
+	if cost(norm, log)+float64(8*len(w.out)) < cost(pre, preLog) {
+		return 2, norm, log, w.out
+	}
+	return 0, pre, preLog, nil
+}
+
+// zstdNormalize scales the counts, of total, to a distribution of
+// 1<<log, in which every symbol that occurs has at least 1.
+func zstdNormalize(counts []int, total int, log uint8) []int16 {
+	size := 1 << log
+	norm := make([]int16, len(counts))
+	sum := 0
+	for s, c := range counts {
+		if c != 0 {
+			norm[s] = 1
+			if n := (c*size + total/2) / total; n > 1 {
+				norm[s] = int16(n)
+			}
+			sum += int(norm[s])
+		}
+	}
+	largest := func() int {
+		l := 0
+		for s, n := range norm {
+			if n > norm[l] {
+				l = s
+			}
+		}
+		return l
+	}
+	for ; sum > size; sum-- {
+		norm[largest()]--
+	}
+	norm[largest()] += int16(size - sum)
+	return norm
+}
+
+// A zstdFSE is an FSE encoding table. RFC 8878 4.1.
+type zstdFSE struct {
+	log    uint8
+	states []uint16 // next state, from the state and the symbol
+	syms   []zstdFSESym
+}
+
+type zstdFSESym struct {
+	deltaBits  uint32 // gives the bits to write from the state
+	deltaState int32  // start of the symbol's states, less its count
+}
+
+// newZstdFSE returns the encoding table of the distribution norm, of
+// 1<<log, in which -1 is a probability below 1.
+func newZstdFSE(norm []int16, log uint8) *zstdFSE {
+	size := 1 << log
+	mask := size - 1
+	high := size - 1
+	symAt := make([]uint8, size)
+	start := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			start[s+1] = start[s] + 1
+			symAt[high] = uint8(s)
+			high--
+		} else {
+			start[s+1] = start[s] + int(n)
+		}
+	}
+	// Spread the symbols over the states as the decoder does.
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			symAt[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	t := &zstdFSE{
+		log:    log,
+		states: make([]uint16, size),
+		syms:   make([]zstdFSESym, len(norm)),
+	}
+	next := append([]int(nil), start...)
+	for u, s := range symAt {
+		t.states[next[s]] = uint16(size + u)
+		next[s]++
+	}
+	for s, n := range norm {
+		switch n {
+		case 0:
+		case -1, 1:
+			t.syms[s] = zstdFSESym{uint32(log)<<16 - uint32(size), int32(start[s] - 1)}
+		default:
+			maxBits := uint32(log) - uint32(bits.Len16(uint16(n-1))-1)
+			t.syms[s] = zstdFSESym{maxBits<<16 - uint32(n)<<maxBits, int32(start[s] - int(n))}
+		}
+	}
+	return t
+}
+
+// init returns the state in which the last symbol sym is encoded: the
+// one with the fewest states, so the decoder's last update of it
+// needs bits.
+func (t *zstdFSE) init(sym uint8) uint32 {
+	st := t.syms[sym]
+	nbits := (st.deltaBits + 1<<15) >> 16
+	v := nbits<<16 - st.deltaBits
+	return uint32(t.states[int32(v>>nbits)+st.deltaState])
+}
+
+// encode writes the bits of state and moves it to one that decodes sym.
+func (t *zstdFSE) encode(w *zstdBitWriter, state *uint32, sym uint8) {
+	st := t.syms[sym]
+	nbits := (*state + st.deltaBits) >> 16
+	w.add(*state, uint(nbits))
+	*state = uint32(t.states[int32(*state>>nbits)+st.deltaState])
+}
+
+// flush writes state, the decoder's initial one.
+func (t *zstdFSE) flush(w *zstdBitWriter, state uint32) {
+	w.add(state, uint(t.log))
+}
+
+// A zstdBitWriter writes bits from the lowest up.
+type zstdBitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+// add writes the low n bits of v, n at most 32.
+func (w *zstdBitWriter) add(v uint32, n uint) {
+	w.bits |= uint64(v) & (1<<n - 1) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// pad writes the partial last byte.
+func (w *zstdBitWriter) pad() {
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits, w.nbits = 0, 0
+	}
+}
+
+// close ends a stream read backward, from the 1 bit that follows the
+// last written.
+func (w *zstdBitWriter) close() {
+	w.add(1, 1)
+	w.pad()
+}
+
+// appendNCount writes the FSE table description of the distribution
+// norm, of 1<<log. RFC 8878 4.1.1.
+func (w *zstdBitWriter) appendNCount(norm []int16, log uint8) {
+	w.add(uint32(log-5), 4)
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbits := uint(log) + 1
+	prev0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if prev0 {
+			// A run of zeros, in repeat flags of 2 bits.
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for ; s >= start+3; start += 3 {
+				w.add(3, 2)
+			}
+			w.add(uint32(s-start), 2)
+		}
+		count := int(norm[s])
+		s++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += max
+		}
+		if count < max {
+			w.add(uint32(count), nbits-1)
+		} else {
+			w.add(uint32(count), nbits)
+		}
+		prev0 = count == 1
+		for remaining < threshold {
+			nbits--
+			threshold >>= 1
+		}
+	}
+	w.pad()
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"internal/testenv"
+	"internal/zstd"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestZstdCompress compresses inputs of several kinds and sizes at
+// several levels and checks that they decompress to themselves.
+func TestZstdCompress(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 300<<10)
+	rnd.Read(random)
+
+	// Text of few distinct bytes with words that repeat far apart.
+	var text bytes.Buffer
+	for text.Len() < 1<<20 {
+		fmt.Fprintf(&text, "runtime.func%d /src/pkg%d/file%d.go:%d\x00", rnd.Intn(5000), rnd.Intn(40), rnd.Intn(300), rnd.Intn(1<<rnd.Intn(16)))
+	}
+	// Bytes of every value with matches of all lengths and offsets.
+	var binary []byte
+	for len(binary) < 600<<10 {
+		if n := len(binary); n > 0 && rnd.Intn(2) == 0 {
+			off := 1 + rnd.Intn(min(n, 1<<rnd.Intn(20)))
+			for l := 3 + rnd.Intn(1<<rnd.Intn(12)); l > 0; l-- {
+				binary = append(binary, binary[len(binary)-off])
+			}
+		} else {
+			for l := rnd.Intn(1 << rnd.Intn(10)); l > 0; l-- {
+				binary = append(binary, byte(rnd.Intn(1<<rnd.Intn(9))))
+			}
+		}
+	}
+	self, err := os.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"byte", []byte{7}},
+		{"short", []byte("hello, hello, hello, world")},
+		{"zeros", make([]byte, 1<<20+5)},
+		{"random", random},
+		{"text", text.Bytes()},
+		{"binary", binary},
+		{"self", self},
+	}
+	for _, in := range inputs {
+		for _, level := range []int{1, 3, 7, 12} {
+			if testing.Short() && in.name == "self" && level > 3 {
+				continue
+			}
+			z := zstdCompress(nil, in.data, level)
+			got, err := io.ReadAll(zstd.NewReader(bytes.NewReader(z)))
+			if err != nil {
+				t.Errorf("%s at level %d: %v", in.name, level, err)
+				continue
+			}
+			if !bytes.Equal(got, in.data) {
+				t.Errorf("%s at level %d: decompressed %d bytes differ from the %d compressed", in.name, level, len(got), len(in.data))
+				continue
+			}
+			t.Logf("%s at level %d: %d -> %d", in.name, level, len(in.data), len(z))
+		}
+	}
+}
+
+func TestCompressFlag(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		on    bool
+		level int
+		ok    bool
+	}{
+		{"true", true, 0, true},
+		{"false", false, 0, true},
+		{"zlib", true, 0, true},
+		{"zstd", true, zstdDefaultLevel, true},
+		{"zstd:7", true, 7, true},
+		{"zstd:22", true, 22, true},
+		{"zstd:0", false, 0, false},
+		{"zstd:23", false, 0, false},
+		{"zlib:6", false, 0, false},
+		{"lz4", false, 0, false},
+	} {
+		var on bool
+		var level int
+		err := compressFlag{&on, &level}.Set(tt.in)
+		if (err == nil) != tt.ok || tt.ok && (on != tt.on || level != tt.level) {
+			t.Errorf("Set(%q) = %v, level %d, %v; want %v, level %d, ok %v", tt.in, on, level, err, tt.on, tt.level, tt.ok)
+		}
+	}
+}
+
+// TestCompressDWARFZstd links a program with -compressdwarf=zstd:5 and
+// checks that its DWARF sections are compressed with zstd and read
+// back.
+func TestCompressDWARFZstd(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping in short mode: cross-builds the runtime for linux/amd64")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "x")
+	cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-compressdwarf=zstd:5", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", cmd, err, out)
+	}
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{".debug_info", ".debug_line"} {
+		s := f.Section(name)
+		if s == nil {
+			t.Fatalf("no %s section", name)
+		}
+		if s.Flags&elf.SHF_COMPRESSED == 0 {
+			t.Errorf("%s is not compressed", name)
+			continue
+		}
+		var ch elf.Chdr64
+		if err := binary.Read(bytes.NewReader(data[s.Offset:]), f.ByteOrder, &ch); err != nil {
+			t.Fatal(err)
+		}
+		if elf.CompressionType(ch.Type) != elf.COMPRESS_ZSTD {
+			t.Errorf("%s is compressed with %v, want %v", name, elf.CompressionType(ch.Type), elf.COMPRESS_ZSTD)
+		}
+	}
+	d, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := d.Reader()
+	found := false
+	for {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			break
+		}
+		if name, _ := e.Val(dwarf.AttrName).(string); name == "main.main" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("main.main is not in the DWARF")
+	}
+}
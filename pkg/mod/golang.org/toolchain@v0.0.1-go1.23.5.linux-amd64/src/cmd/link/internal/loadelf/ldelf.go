@@ -12,9 +12,11 @@ import (
 	"cmd/internal/sys"
 	"cmd/link/internal/loader"
 	"cmd/link/internal/sym"
+	"compress/zlib"
 	"debug/elf"
 	"encoding/binary"
 	"fmt"
+	"internal/zstd"
 	"io"
 	"log"
 	"strings"
@@ -233,6 +235,63 @@ func parseArmAttributes(e binary.ByteOrder, data []byte) (found bool, ehdrFlags
 	return found, ehdrFlags, nil
 }
 
+// GNUProperties are the properties of the NT_GNU_PROPERTY_TYPE_0 note
+// of the .note.gnu.property section of an object, by type. Those of
+// the hardware control-flow protection features of x86 and arm64 say
+// which of them all of the code of the object is compatible with.
+type GNUProperties map[uint32][]byte
+
+// ntGNUPropertyType0 is the type of the note of the GNU properties.
+const ntGNUPropertyType0 = 5
+
+// parseGNUProperties returns the properties of the .note.gnu.property
+// section data. Its notes, their descriptions and the properties in
+// them are aligned to 8 bytes in 64-bit objects and 4 in 32-bit ones.
+func parseGNUProperties(e binary.ByteOrder, data []byte, is64 bool) (GNUProperties, error) {
+	align := 4
+	if is64 {
+		align = 8
+	}
+	pad := func(n int) int { return (n + align - 1) &^ (align - 1) }
+	props := make(GNUProperties)
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, fmt.Errorf("truncated note header")
+		}
+		namesz, descsz, typ := int(e.Uint32(data)), int(e.Uint32(data[4:])), e.Uint32(data[8:])
+		descoff := pad(12 + namesz)
+		if namesz > len(data) || descoff+descsz > len(data) {
+			return nil, fmt.Errorf("truncated note")
+		}
+		name, desc := data[12:12+namesz], data[descoff:descoff+descsz]
+		next := pad(descoff + descsz)
+		if next > len(data) {
+			next = len(data)
+		}
+		data = data[next:]
+		if string(name) != "GNU\x00" || typ != ntGNUPropertyType0 {
+			continue
+		}
+		for len(desc) > 0 {
+			if len(desc) < 8 {
+				return nil, fmt.Errorf("truncated property")
+			}
+			ptyp, size := e.Uint32(desc), int(e.Uint32(desc[4:]))
+			desc = desc[8:]
+			if size > len(desc) {
+				return nil, fmt.Errorf("truncated property %#x", ptyp)
+			}
+			props[ptyp] = desc[:size]
+			next := pad(size)
+			if next > len(desc) {
+				next = len(desc)
+			}
+			desc = desc[next:]
+		}
+	}
+	return props, nil
+}
+
 // Load loads the ELF file pn from f.
 // Symbols are installed into the loader, and a slice of the text symbols is returned.
 //
@@ -241,9 +300,12 @@ func parseArmAttributes(e binary.ByteOrder, data []byte) (found bool, ehdrFlags
 // parameter initEhdrFlags contains the current header flags for the output
 // object, and the returned ehdrFlags contains what this Load function computes.
 // TODO: find a better place for this logic.
-func Load(l *loader.Loader, arch *sys.Arch, localSymVersion int, f *bio.Reader, pkg string, length int64, pn string, initEhdrFlags uint32) (textp []loader.Sym, ehdrFlags uint32, err error) {
-	errorf := func(str string, args ...interface{}) ([]loader.Sym, uint32, error) {
-		return nil, 0, fmt.Errorf("loadelf: %s: %v", pn, fmt.Sprintf(str, args...))
+//
+// Load returns the properties of the .note.gnu.property section of the
+// file too, or nil if it has none.
+func Load(l *loader.Loader, arch *sys.Arch, localSymVersion int, f *bio.Reader, pkg string, length int64, pn string, initEhdrFlags uint32) (textp []loader.Sym, ehdrFlags uint32, props GNUProperties, err error) {
+	errorf := func(str string, args ...interface{}) ([]loader.Sym, uint32, GNUProperties, error) {
+		return nil, 0, nil, fmt.Errorf("loadelf: %s: %v", pn, fmt.Sprintf(str, args...))
 	}
 
 	ehdrFlags = initEhdrFlags
@@ -495,6 +557,16 @@ func Load(l *loader.Loader, arch *sys.Arch, localSymVersion int, f *bio.Reader,
 				ehdrFlags = newEhdrFlags
 			}
 		}
+		if sect.type_ == elf.SHT_NOTE && sect.name == ".note.gnu.property" {
+			if err := elfmap(elfobj, sect); err != nil {
+				return errorf("malformed elf file: %v", err)
+			}
+			props, err = parseGNUProperties(e, sect.base[:sect.size], is64 != 0)
+			if err != nil {
+				return errorf("malformed .note.gnu.property: %v", err)
+			}
+			continue
+		}
 		if (sect.type_ != elf.SHT_PROGBITS && sect.type_ != elf.SHT_NOBITS) || sect.flags&elf.SHF_ALLOC == 0 {
 			continue
 		}
@@ -780,7 +852,7 @@ func Load(l *loader.Loader, arch *sys.Arch, localSymVersion int, f *bio.Reader,
 			rType := objabi.ElfRelocOffset + objabi.RelocType(relocType)
 			rSize, addendSize, err := relSize(arch, pn, uint32(relocType))
 			if err != nil {
-				return nil, 0, err
+				return nil, 0, nil, err
 			}
 			if rela != 0 {
 				rAdd = int64(add)
@@ -812,7 +884,7 @@ func Load(l *loader.Loader, arch *sys.Arch, localSymVersion int, f *bio.Reader,
 		sb.SortRelocs() // just in case
 	}
 
-	return textp, ehdrFlags, nil
+	return textp, ehdrFlags, props, nil
 }
 
 func section(elfobj *ElfObj, name string) *ElfSect {
@@ -824,6 +896,8 @@ func section(elfobj *ElfObj, name string) *ElfSect {
 	return nil
 }
 
+// elfmap reads the contents of sect into sect.base, decompressing those
+// of an SHF_COMPRESSED section.
 func elfmap(elfobj *ElfObj, sect *ElfSect) (err error) {
 	if sect.base != nil {
 		return nil
@@ -840,6 +914,55 @@ func elfmap(elfobj *ElfObj, sect *ElfSect) (err error) {
 		return fmt.Errorf("short read: %v", err)
 	}
 
+	if sect.flags&elf.SHF_COMPRESSED != 0 {
+		return decompress(elfobj, sect)
+	}
+	return nil
+}
+
+// decompress replaces the contents of the SHF_COMPRESSED section sect,
+// a compression header and the compressed data, with the data. Recent
+// GCC and Clang compress sections with zlib or zstd.
+func decompress(elfobj *ElfObj, sect *ElfSect) error {
+	var ctype elf.CompressionType
+	var size uint64
+	var hdrSize int
+	b := sect.base
+	if elfobj.is64 != 0 {
+		hdrSize = binary.Size(elf.Chdr64{})
+		if len(b) >= hdrSize {
+			ctype, size = elf.CompressionType(elfobj.e.Uint32(b)), elfobj.e.Uint64(b[8:])
+		}
+	} else {
+		hdrSize = binary.Size(elf.Chdr32{})
+		if len(b) >= hdrSize {
+			ctype, size = elf.CompressionType(elfobj.e.Uint32(b)), uint64(elfobj.e.Uint32(b[4:]))
+		}
+	}
+	if len(b) < hdrSize {
+		return fmt.Errorf("compressed section %s too short for its header", sect.name)
+	}
+
+	var r io.Reader
+	switch ctype {
+	case elf.COMPRESS_ZLIB:
+		zr, err := zlib.NewReader(bytes.NewReader(b[hdrSize:]))
+		if err != nil {
+			return fmt.Errorf("compressed section %s: %v", sect.name, err)
+		}
+		defer zr.Close()
+		r = zr
+	case elf.COMPRESS_ZSTD:
+		r = zstd.NewReader(bytes.NewReader(b[hdrSize:]))
+	default:
+		return fmt.Errorf("section %s has unknown compression type %v", sect.name, ctype)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("compressed section %s: %v", sect.name, err)
+	}
+	sect.base, sect.size, sect.readOnlyMem = data, size, false
+	sect.flags &^= elf.SHF_COMPRESSED
 	return nil
 }
 
@@ -1139,6 +1262,7 @@ func relSize(arch *sys.Arch, pn string, elftype uint32) (uint8, uint8, error) {
 
 	case RISCV64 | uint32(elf.R_RISCV_32)<<16,
 		RISCV64 | uint32(elf.R_RISCV_BRANCH)<<16,
+		RISCV64 | uint32(elf.R_RISCV_JAL)<<16,
 		RISCV64 | uint32(elf.R_RISCV_HI20)<<16,
 		RISCV64 | uint32(elf.R_RISCV_LO12_I)<<16,
 		RISCV64 | uint32(elf.R_RISCV_LO12_S)<<16,
@@ -1150,7 +1274,8 @@ func relSize(arch *sys.Arch, pn string, elftype uint32) (uint8, uint8, error) {
 		RISCV64 | uint32(elf.R_RISCV_SET32)<<16,
 		RISCV64 | uint32(elf.R_RISCV_SUB32)<<16,
 		RISCV64 | uint32(elf.R_RISCV_32_PCREL)<<16,
-		RISCV64 | uint32(elf.R_RISCV_RELAX)<<16:
+		RISCV64 | uint32(elf.R_RISCV_RELAX)<<16,
+		RISCV64 | uint32(elf.R_RISCV_ALIGN)<<16:
 		return 4, 4, nil
 
 	case RISCV64 | uint32(elf.R_RISCV_64)<<16,
@@ -0,0 +1,125 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadelf
+
+import (
+	"bytes"
+	"cmd/internal/bio"
+	"compress/zlib"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// zstdRaw returns a zstd frame of a single raw block holding data.
+func zstdRaw(data []byte) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 0xFD2FB528)
+	b = append(b, 0x20, byte(len(data))) // single segment, 1-byte content size
+	hdr := uint32(len(data))<<3 | 1      // last block, raw
+	b = append(b, byte(hdr), byte(hdr>>8), byte(hdr>>16))
+	return append(b, data...)
+}
+
+func TestElfmapCompressed(t *testing.T) {
+	want := []byte("uncompressed section contents")
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write(want)
+	zw.Close()
+
+	for _, tc := range []struct {
+		name  string
+		is64  int
+		ctype elf.CompressionType
+		data  []byte
+	}{
+		{"zlib64", 1, elf.COMPRESS_ZLIB, zbuf.Bytes()},
+		{"zstd64", 1, elf.COMPRESS_ZSTD, zstdRaw(want)},
+		{"zstd32", 0, elf.COMPRESS_ZSTD, zstdRaw(want)},
+	} {
+		var hdr bytes.Buffer
+		le := binary.LittleEndian
+		if tc.is64 != 0 {
+			binary.Write(&hdr, le, elf.Chdr64{Type: uint32(tc.ctype), Size: uint64(len(want)), Addralign: 1})
+		} else {
+			binary.Write(&hdr, le, elf.Chdr32{Type: uint32(tc.ctype), Size: uint32(len(want)), Addralign: 1})
+		}
+		contents := append(hdr.Bytes(), tc.data...)
+		path := filepath.Join(t.TempDir(), "obj")
+		if err := os.WriteFile(path, contents, 0666); err != nil {
+			t.Fatal(err)
+		}
+		f, err := bio.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := &ElfObj{f: f, length: int64(len(contents)), is64: tc.is64, e: le}
+		sect := &ElfSect{name: ".debug_info", flags: elf.SHF_COMPRESSED, size: uint64(len(contents))}
+		err = elfmap(obj, sect)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(sect.base, want) || sect.size != uint64(len(want)) || sect.flags&elf.SHF_COMPRESSED != 0 {
+			t.Errorf("%s: got %q, size %d, flags %v", tc.name, sect.base, sect.size, sect.flags)
+		}
+	}
+}
+
+// gnuPropertyNote returns a NT_GNU_PROPERTY_TYPE_0 note of the
+// properties, each of 4 bytes of data.
+func gnuPropertyNote(e binary.AppendByteOrder, is64 bool, props ...[2]uint32) []byte {
+	align := 4
+	if is64 {
+		align = 8
+	}
+	var desc []byte
+	for _, p := range props {
+		desc = e.AppendUint32(desc, p[0])
+		desc = e.AppendUint32(desc, 4)
+		desc = e.AppendUint32(desc, p[1])
+		for len(desc)%align != 0 {
+			desc = append(desc, 0)
+		}
+	}
+	b := e.AppendUint32(nil, 4)
+	b = e.AppendUint32(b, uint32(len(desc)))
+	b = e.AppendUint32(b, ntGNUPropertyType0)
+	b = append(b, "GNU\x00"...)
+	return append(b, desc...)
+}
+
+func TestParseGNUProperties(t *testing.T) {
+	const x86Feature1And, aarch64Feature1And = 0xc0000002, 0xc0000000
+	for _, is64 := range []bool{false, true} {
+		for _, e := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			a := e.(binary.AppendByteOrder)
+			data := gnuPropertyNote(a, is64, [2]uint32{x86Feature1And, 3}, [2]uint32{aarch64Feature1And, 1})
+			// Notes of other owners and types are skipped.
+			other := a.AppendUint32(nil, 4)
+			other = a.AppendUint32(other, 4)
+			other = a.AppendUint32(other, ntGNUPropertyType0)
+			other = append(other, "XYZ\x00\x2a\x00\x00\x00"...)
+			if is64 {
+				other = append(other, 0, 0, 0, 0)
+			}
+			data = append(other, data...)
+
+			props, err := parseGNUProperties(e, data, is64)
+			if err != nil {
+				t.Fatalf("is64 %v, %v: %v", is64, e, err)
+			}
+			if len(props) != 2 || e.Uint32(props[x86Feature1And]) != 3 || e.Uint32(props[aarch64Feature1And]) != 1 {
+				t.Errorf("is64 %v, %v: got %x", is64, e, props)
+			}
+			if _, err := parseGNUProperties(e, data[:len(data)-6], is64); err == nil {
+				t.Errorf("is64 %v, %v: no error for truncated note", is64, e)
+			}
+		}
+	}
+}
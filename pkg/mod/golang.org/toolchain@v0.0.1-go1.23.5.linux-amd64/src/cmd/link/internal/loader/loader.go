@@ -535,6 +535,36 @@ func (l *Loader) LookupOrCreateCgoExport(name string, ver int) Sym {
 	return l.LookupOrCreateSym(name, 0)
 }
 
+// WrapSyms redirects the relocations of the external symbols, which
+// include those of host objects, as GNU ld --wrap does for each of
+// names: those that target name target __wrap_name instead, and those
+// that target __real_name target name. The wrapper may be a cgo export.
+// Only the relocations there are at the time of the call are affected,
+// so it is to be called once the host objects are loaded. The
+// relocations of Go object files are left alone.
+func (l *Loader) WrapSyms(names []string) {
+	wrap := make(map[Sym]Sym)
+	for _, name := range names {
+		if s := l.Lookup(name, 0); s != 0 {
+			wrap[s] = l.LookupOrCreateCgoExport("__wrap_"+name, 0)
+		}
+		if s := l.Lookup("__real_"+name, 0); s != 0 {
+			wrap[s] = l.LookupOrCreateCgoExport(name, 0)
+		}
+	}
+	if len(wrap) == 0 {
+		return
+	}
+	for _, pp := range l.payloads {
+		for i := range pp.relocs {
+			r := &pp.relocs[i]
+			if w, ok := wrap[Sym(r.Sym().SymIdx)]; ok {
+				r.SetSym(goobj.SymRef{PkgIdx: 0, SymIdx: uint32(w)})
+			}
+		}
+	}
+}
+
 func (l *Loader) IsExternal(i Sym) bool {
 	r, _ := l.toLocal(i)
 	return l.isExtReader(r)
@@ -463,3 +463,37 @@ func TestOuterSub(t *testing.T) {
 		t.Errorf("expected %d in sub list got %d", 5, count)
 	}
 }
+
+func TestWrapSyms(t *testing.T) {
+	ldr := mkLoader()
+	arch := sys.ArchAMD64
+	foo := ldr.LookupOrCreateSym("foo", 0)
+	realFoo := ldr.LookupOrCreateSym("__real_foo", 0)
+	bar := ldr.LookupOrCreateSym("bar", 0)
+	caller := ldr.MakeSymbolUpdater(ldr.LookupOrCreateSym("caller", 0))
+	for _, s := range []Sym{foo, realFoo, bar} {
+		caller.AddAddrPlus(arch, s, 0)
+	}
+	wrapper := ldr.MakeSymbolUpdater(ldr.LookupOrCreateSym("__wrap_foo", 0))
+	wrapper.AddAddrPlus(arch, realFoo, 0)
+
+	ldr.WrapSyms([]string{"foo", "unused"})
+
+	targets := func(s Sym) []string {
+		var names []string
+		relocs := ldr.Relocs(s)
+		for i := 0; i < relocs.Count(); i++ {
+			names = append(names, ldr.SymName(relocs.At(i).Sym()))
+		}
+		return names
+	}
+	if got, want := fmt.Sprint(targets(caller.Sym())), "[__wrap_foo foo bar]"; got != want {
+		t.Errorf("caller relocations target %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(targets(wrapper.Sym())), "[foo]"; got != want {
+		t.Errorf("wrapper relocations target %s, want %s", got, want)
+	}
+	if ldr.Lookup("__wrap_unused", 0) != 0 {
+		t.Errorf("__wrap_unused created for a symbol nothing references")
+	}
+}
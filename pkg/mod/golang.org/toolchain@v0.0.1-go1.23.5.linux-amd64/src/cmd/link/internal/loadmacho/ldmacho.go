@@ -616,6 +616,11 @@ func Load(l *loader.Loader, arch *sys.Arch, localSymVersion int, f *bio.Reader,
 		}
 		machsym.sym = s
 		if machsym.sectnum == 0 { // undefined
+			if l.SymType(s) == 0 {
+				// As loadelf and loadpe do, so that the linker can
+				// look for it in libraries.
+				l.MakeSymbolUpdater(s).SetType(sym.SXREF)
+			}
 			continue
 		}
 		if uint32(machsym.sectnum) > c.seg.nsect {
@@ -0,0 +1,612 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package loadtbd implements a reader of text-based dylib stubs (.tbd
+// files), which an Apple SDK has in place of the Mach-O dynamic
+// libraries of the system.
+//
+// A .tbd file is a stream of YAML documents, each describing a library:
+// its install name, the libraries it re-exports and the symbols it
+// exports, for each target. The first is the library of the file; the
+// others are inlined in it, usually because it re-exports them. Only
+// versions 1 to 4 of the format, the YAML ones, are read, and only the
+// subset of YAML that they use.
+package loadtbd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Library is a dynamic library described by a .tbd file.
+type Library struct {
+	InstallName string
+	Reexports   []string // the install names of the libraries it re-exports
+	Symbols     []string // the symbols it exports, with their leading underscore
+}
+
+// legacyPlatforms are the platform names of versions 1 to 3 of the
+// format, which have no targets, by the platform name of version 4.
+var legacyPlatforms = map[string]string{
+	"macos":         "macosx",
+	"maccatalyst":   "iosmac",
+	"ios":           "ios",
+	"ios-simulator": "ios",
+}
+
+// Parse returns the libraries that the .tbd file data describes for the
+// architecture arch, such as x86_64 or arm64, and the platform, such as
+// macos or ios-simulator, as version 4 of the format names it. The
+// first is the library of the file. It is an error for it not to
+// support the target; inlined libraries that do not are left out.
+func Parse(data []byte, arch, platform string) ([]*Library, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return nil, fmt.Errorf("JSON .tbd files (version 5) are not supported")
+	}
+	docs, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no libraries")
+	}
+	var libs []*Library
+	for i, d := range docs {
+		lib, err := library(d, arch, platform)
+		if err != nil {
+			return nil, err
+		}
+		if lib == nil {
+			if i == 0 {
+				return nil, fmt.Errorf("%s does not support %s-%s", str(d.m, "install-name"), arch, platform)
+			}
+			continue
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// library returns the library of document d for the target, or nil if
+// it does not support the target.
+func library(d *document, arch, platform string) (*Library, error) {
+	version := 1
+	switch d.tag {
+	case "!tapi-tbd":
+		if v := str(d.m, "tbd-version"); v != "4" {
+			return nil, fmt.Errorf("line %d: tbd-version %q is not supported", d.line, v)
+		}
+		version = 4
+	case "!tapi-tbd-v3":
+		version = 3
+	case "!tapi-tbd-v2":
+		version = 2
+	case "":
+	default:
+		return nil, fmt.Errorf("line %d: unknown document tag %s", d.line, d.tag)
+	}
+	lib := &Library{InstallName: str(d.m, "install-name")}
+	if lib.InstallName == "" {
+		return nil, fmt.Errorf("line %d: library has no install-name", d.line)
+	}
+
+	// An arm64 binary can use the arm64e slice of a library, which is
+	// all some SDKs describe for system libraries.
+	isArch := func(a string) bool { return a == arch || arch == "arm64" && a == "arm64e" }
+	if version == 4 {
+		hasTarget := func(targets []string) bool {
+			for _, t := range targets {
+				if a, p, ok := strings.Cut(t, "-"); ok && p == platform && isArch(a) {
+					return true
+				}
+			}
+			return false
+		}
+		if !hasTarget(strs(d.m, "targets")) {
+			return nil, nil
+		}
+		for _, r := range maps(d.m, "reexported-libraries") {
+			if hasTarget(strs(r, "targets")) {
+				lib.Reexports = append(lib.Reexports, strs(r, "libraries")...)
+			}
+		}
+		for _, key := range []string{"exports", "reexports"} {
+			for _, e := range maps(d.m, key) {
+				if hasTarget(strs(e, "targets")) {
+					lib.addSymbols(e, "weak-symbols", false)
+				}
+			}
+		}
+		return lib, nil
+	}
+
+	hasArch := func(archs []string) bool {
+		for _, a := range archs {
+			if isArch(a) {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasArch(strs(d.m, "archs")) || str(d.m, "platform") != legacyPlatforms[platform] {
+		return nil, nil
+	}
+	for _, e := range maps(d.m, "exports") {
+		if hasArch(strs(e, "archs")) {
+			lib.Reexports = append(lib.Reexports, strs(e, "re-exports")...)
+			lib.addSymbols(e, "weak-def-symbols", true)
+		}
+	}
+	return lib, nil
+}
+
+// addSymbols adds the symbols of the export list e, whose weak symbols
+// are under the key weak. The Objective-C names of versions before 4
+// have a leading underscore.
+func (lib *Library) addSymbols(e map[string]any, weak string, legacy bool) {
+	for _, key := range []string{"symbols", weak, "thread-local-symbols"} {
+		lib.Symbols = append(lib.Symbols, strs(e, key)...)
+	}
+	objc := func(key string, prefixes ...string) {
+		for _, name := range strs(e, key) {
+			if legacy {
+				name = strings.TrimPrefix(name, "_")
+			}
+			for _, p := range prefixes {
+				lib.Symbols = append(lib.Symbols, p+name)
+			}
+		}
+	}
+	objc("objc-classes", "_OBJC_CLASS_$_", "_OBJC_METACLASS_$_")
+	objc("objc-eh-types", "_OBJC_EHTYPE_$_")
+	objc("objc-ivars", "_OBJC_IVAR_$_")
+}
+
+// str returns the string under key in m, or "".
+func str(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// strs returns the list of strings under key in m. A single string is
+// a list of one.
+func strs(m map[string]any, key string) []string {
+	switch v := m[key].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []any:
+		var list []string
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// maps returns the list of mappings under key in m.
+func maps(m map[string]any, key string) []map[string]any {
+	v, _ := m[key].([]any)
+	var list []map[string]any
+	for _, x := range v {
+		if m, ok := x.(map[string]any); ok {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// A document is a YAML document of a .tbd file.
+type document struct {
+	tag  string // the tag of the document, such as !tapi-tbd
+	line int    // the line it starts at
+	m    map[string]any
+}
+
+// A line is a line of a YAML document, without its indentation and
+// comment.
+type line struct {
+	indent int
+	text   string
+	n      int // line number
+}
+
+// parseYAML returns the documents of data, which must be mappings. The
+// values in them are strings, lists ([]any) and mappings
+// (map[string]any).
+func parseYAML(data []byte) ([]*document, error) {
+	var docs []*document
+	var doc *document
+	var lines []line
+	end := func() error {
+		if doc == nil {
+			return nil
+		}
+		p := &parser{lines: lines}
+		v, err := p.block(0)
+		if err != nil {
+			return err
+		}
+		if p.pos < len(p.lines) {
+			return fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].n)
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("line %d: document is not a mapping", doc.line)
+		}
+		doc.m = m
+		docs = append(docs, doc)
+		doc, lines = nil, nil
+		return nil
+	}
+	for i, s := range strings.Split(string(data), "\n") {
+		s = strings.TrimRight(stripComment(s), " \t\r")
+		switch {
+		case s == "---" || strings.HasPrefix(s, "--- "):
+			if err := end(); err != nil {
+				return nil, err
+			}
+			doc = &document{tag: strings.TrimSpace(s[3:]), line: i + 1}
+			continue
+		case s == "...":
+			if err := end(); err != nil {
+				return nil, err
+			}
+			continue
+		case strings.TrimSpace(s) == "" || strings.HasPrefix(s, "%"):
+			// Blank lines and directives.
+			continue
+		}
+		if strings.HasPrefix(s, "\t") {
+			return nil, fmt.Errorf("line %d: tab in indentation", i+1)
+		}
+		if doc == nil {
+			// Version 1 files need not start with ---.
+			doc = &document{line: i + 1}
+		}
+		text := strings.TrimLeft(s, " ")
+		lines = append(lines, line{len(s) - len(text), text, i + 1})
+	}
+	if err := end(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// stripComment returns s without its comment, if any.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// A parser parses the block structure of the lines of a document.
+type parser struct {
+	lines []line
+	pos   int
+}
+
+// isItem reports whether s starts a sequence item.
+func isItem(s string) bool { return s == "-" || strings.HasPrefix(s, "- ") }
+
+// block parses the node starting at the current line, which is indented
+// by indent.
+func (p *parser) block(indent int) (any, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent < indent {
+		return "", nil
+	}
+	l := p.lines[p.pos]
+	if isItem(l.text) {
+		return p.sequence(l.indent)
+	}
+	if _, _, ok := splitKey(l.text); !ok {
+		return p.value(l.text)
+	}
+	return p.mapping(l.indent)
+}
+
+// sequence parses a block sequence whose items are indented by indent.
+func (p *parser) sequence(indent int) (any, error) {
+	seq := []any{}
+	for p.pos < len(p.lines) {
+		l := &p.lines[p.pos]
+		if l.indent != indent || !isItem(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		var v any
+		var err error
+		if rest == "" {
+			p.pos++
+			v, err = p.block(indent + 1)
+		} else {
+			// Parse the item as if it started on a line of its own,
+			// indented past the "- ".
+			l.indent += len(l.text) - len(rest)
+			l.text = rest
+			v, err = p.block(l.indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, nil
+}
+
+// mapping parses a block mapping whose keys are indented by indent.
+func (p *parser) mapping(indent int) (any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || isItem(l.text) {
+			break
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key: value", l.n)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %s", l.n, key)
+		}
+		if rest != "" {
+			v, err := p.value(rest)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		// The value is a node indented below the key, or a sequence
+		// at its indentation, or empty.
+		p.pos++
+		m[key] = ""
+		if p.pos < len(p.lines) {
+			if next := p.lines[p.pos]; next.indent > indent || next.indent == indent && isItem(next.text) {
+				v, err := p.block(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+			}
+		}
+	}
+	return m, nil
+}
+
+// value parses the flow node s, which starts on the current line and,
+// for a flow sequence or mapping, may go on over the lines after it.
+func (p *parser) value(s string) (any, error) {
+	n := p.lines[p.pos].n
+	p.pos++
+	if s[0] == '[' || s[0] == '{' {
+		for !flowEnded(s) {
+			if p.pos >= len(p.lines) {
+				return nil, fmt.Errorf("line %d: unterminated %c", n, s[0])
+			}
+			s += " " + p.lines[p.pos].text
+			p.pos++
+		}
+	}
+	f := &flow{s: s, line: n}
+	v, err := f.node()
+	if err != nil {
+		return nil, err
+	}
+	if f.space(); f.i < len(f.s) {
+		return nil, f.errorf("unexpected %q", f.s[f.i:])
+	}
+	return v, nil
+}
+
+// flowEnded reports whether the flow sequence or mapping s is closed.
+func flowEnded(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitKey splits the mapping entry s into its key and the rest of the
+// line after the colon, with ok false if s is not a mapping entry.
+func splitKey(s string) (key, rest string, ok bool) {
+	if s[0] == '\'' || s[0] == '"' {
+		f := &flow{s: s}
+		k, err := f.quoted()
+		if err != nil || !strings.HasPrefix(s[f.i:], ":") {
+			return "", "", false
+		}
+		rest = s[f.i+1:]
+		if rest != "" && rest[0] != ' ' {
+			return "", "", false
+		}
+		return k, strings.TrimSpace(rest), true
+	}
+	if s[0] == '[' || s[0] == '{' {
+		return "", "", false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ') {
+			return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// A flow parses a flow node: a scalar, or a sequence or mapping in
+// brackets.
+type flow struct {
+	s    string
+	i    int
+	line int
+}
+
+func (f *flow) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", f.line, fmt.Sprintf(format, args...))
+}
+
+func (f *flow) space() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+// node parses the node at f.i.
+func (f *flow) node() (any, error) {
+	f.space()
+	if f.i >= len(f.s) {
+		return "", nil
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		seq := []any{}
+		for {
+			if f.space(); f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return seq, nil
+			}
+			v, err := f.node()
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			if err := f.next(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.i++
+		m := map[string]any{}
+		for {
+			if f.space(); f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			k, err := f.scalar()
+			if err != nil {
+				return nil, err
+			}
+			if f.space(); f.i >= len(f.s) || f.s[f.i] != ':' {
+				return nil, f.errorf("want : after key %s", k)
+			}
+			f.i++
+			v, err := f.node()
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+			if err := f.next('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.scalar()
+}
+
+// next moves past the comma after an entry of a flow node that close
+// ends, if there is one.
+func (f *flow) next(close byte) error {
+	f.space()
+	switch {
+	case f.i < len(f.s) && f.s[f.i] == ',':
+		f.i++
+		return nil
+	case f.i < len(f.s) && f.s[f.i] == close:
+		return nil
+	}
+	return f.errorf("want , or %c", close)
+}
+
+// scalar parses the quoted or plain scalar at f.i.
+func (f *flow) scalar() (string, error) {
+	f.space()
+	if f.i < len(f.s) && (f.s[f.i] == '\'' || f.s[f.i] == '"') {
+		return f.quoted()
+	}
+	start := f.i
+	for ; f.i < len(f.s); f.i++ {
+		c := f.s[f.i]
+		if c == ',' || c == ']' || c == '}' || c == ':' && (f.i+1 == len(f.s) || f.s[f.i+1] == ' ') {
+			break
+		}
+	}
+	return strings.TrimSpace(f.s[start:f.i]), nil
+}
+
+// quoted parses the single- or double-quoted scalar at f.i.
+func (f *flow) quoted() (string, error) {
+	q := f.s[f.i]
+	var b strings.Builder
+	for i := f.i + 1; i < len(f.s); i++ {
+		c := f.s[i]
+		switch {
+		case c == q && q == '\'' && i+1 < len(f.s) && f.s[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case c == q:
+			f.i = i + 1
+			return b.String(), nil
+		case c == '\\' && q == '"':
+			j := i + 2
+			switch {
+			case i+1 >= len(f.s):
+				return "", f.errorf("unterminated string")
+			case f.s[i+1] == 'x':
+				j = i + 4
+			case f.s[i+1] == 'u':
+				j = i + 6
+			}
+			if j > len(f.s) {
+				return "", f.errorf("bad escape in string")
+			}
+			r, _, _, err := strconv.UnquoteChar(f.s[i:j], '"')
+			if err != nil {
+				return "", f.errorf("bad escape %s in string", f.s[i:j])
+			}
+			b.WriteRune(r)
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", f.errorf("unterminated string")
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadtbd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const libSystemTBD = `--- !tapi-tbd
+tbd-version:     4
+targets:         [ x86_64-macos, arm64e-macos ]
+uuids:
+  - target:          x86_64-macos
+    value:           2F3CF6F5-6D3A-3A5C-8B93-A0B6E2D3F2A1
+  - target:          arm64e-macos
+    value:           7B8E4D3C-1A2B-3C4D-5E6F-708192A3B4C5
+install-name:    '/usr/lib/libSystem.B.dylib'
+current-version: 1319
+reexported-libraries:
+  - targets:         [ x86_64-macos, arm64e-macos ]
+    libraries:       [ '/usr/lib/system/libsystem_c.dylib', '/usr/lib/system/libsystem_kernel.dylib' ]
+exports:
+  - targets:         [ x86_64-macos, arm64e-macos ]
+    symbols:         [ 'R8289209$_close', _mach_init_routine ]
+  - targets:         [ x86_64-macos ]
+    symbols:         [ ___x86_only ]
+--- !tapi-tbd
+tbd-version:     4
+targets:         [ x86_64-macos, arm64e-macos ]
+install-name:    '/usr/lib/system/libsystem_c.dylib'
+parent-umbrella:
+  - targets:         [ x86_64-macos, arm64e-macos ]
+    umbrella:        System
+exports:
+  - targets:         [ x86_64-macos, arm64e-macos ]
+    symbols:         [ ___stack_chk_guard, _printf,
+                       _puts ]   # a comment
+    weak-symbols:    [ _malloc_weak ]
+    thread-local-symbols: [ _tls ]
+--- !tapi-tbd
+tbd-version:     4
+targets:         [ x86_64-macos ]
+install-name:    '/usr/lib/system/libsystem_x86.dylib'
+exports:
+  - targets:         [ x86_64-macos ]
+    symbols:         [ _x86 ]
+...
+`
+
+func TestParseV4(t *testing.T) {
+	libs, err := Parse([]byte(libSystemTBD), "arm64", "macos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Library{
+		{
+			InstallName: "/usr/lib/libSystem.B.dylib",
+			Reexports:   []string{"/usr/lib/system/libsystem_c.dylib", "/usr/lib/system/libsystem_kernel.dylib"},
+			Symbols:     []string{"R8289209$_close", "_mach_init_routine"},
+		},
+		{
+			InstallName: "/usr/lib/system/libsystem_c.dylib",
+			Symbols:     []string{"___stack_chk_guard", "_printf", "_puts", "_malloc_weak", "_tls"},
+		},
+	}
+	if !reflect.DeepEqual(libs, want) {
+		t.Errorf("arm64: got %+v, want %+v", libs, want)
+	}
+
+	libs, err = Parse([]byte(libSystemTBD), "x86_64", "macos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libs) != 3 || !reflect.DeepEqual(libs[0].Symbols, []string{"R8289209$_close", "_mach_init_routine", "___x86_only"}) {
+		t.Errorf("x86_64: got %+v", libs)
+	}
+
+	if _, err := Parse([]byte(libSystemTBD), "arm64", "ios"); err == nil || !strings.Contains(err.Error(), "does not support arm64-ios") {
+		t.Errorf("arm64-ios: got %v, want an error", err)
+	}
+}
+
+const foundationTBD = `--- !tapi-tbd
+tbd-version:     4
+targets:         [ arm64-macos, arm64-maccatalyst ]
+install-name:    '/System/Library/Frameworks/Foundation.framework/Versions/C/Foundation'
+exports:
+  - targets:         [ arm64-macos, arm64-maccatalyst ]
+    symbols:         [ _NSLog ]
+    objc-classes:    [ NSString ]
+    objc-eh-types:   [ NSException ]
+    objc-ivars:      [ NSString._length ]
+reexports:
+  - targets:         [ arm64-macos ]
+    symbols:         [ _kCFNull ]
+`
+
+func TestParseObjC(t *testing.T) {
+	libs, err := Parse([]byte(foundationTBD), "arm64", "macos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"_NSLog",
+		"_OBJC_CLASS_$_NSString", "_OBJC_METACLASS_$_NSString",
+		"_OBJC_EHTYPE_$_NSException",
+		"_OBJC_IVAR_$_NSString._length",
+		"_kCFNull",
+	}
+	if len(libs) != 1 || !reflect.DeepEqual(libs[0].Symbols, want) {
+		t.Errorf("got %+v, want symbols %q", libs, want)
+	}
+}
+
+const legacyTBD = `--- !tapi-tbd-v3
+archs:           [ x86_64, arm64e ]
+uuids:           [ 'x86_64: 11111111-2222-3333-4444-555555555555', 'arm64e: 66666666-7777-8888-9999-AAAAAAAAAAAA' ]
+platform:        macosx
+install-name:    /usr/lib/libz.1.dylib
+current-version: 1.2.11
+exports:
+  - archs:           [ x86_64, arm64e ]
+    re-exports:      [ /usr/lib/libother.dylib ]
+    symbols:         [ _deflate, _inflate ]
+    objc-classes:    [ _ZObject ]
+    weak-def-symbols: [ "_w\x65ak" ]
+...
+`
+
+func TestParseLegacy(t *testing.T) {
+	libs, err := Parse([]byte(legacyTBD), "arm64", "macos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Library{{
+		InstallName: "/usr/lib/libz.1.dylib",
+		Reexports:   []string{"/usr/lib/libother.dylib"},
+		Symbols:     []string{"_deflate", "_inflate", "_weak", "_OBJC_CLASS_$_ZObject", "_OBJC_METACLASS_$_ZObject"},
+	}}
+	if !reflect.DeepEqual(libs, want) {
+		t.Errorf("got %+v, want %+v", libs, want)
+	}
+	if _, err := Parse([]byte(legacyTBD), "arm64", "ios"); err == nil {
+		t.Errorf("arm64-ios: no error")
+	}
+
+	// Version 1 has no document tag, nor need it start with ---.
+	v1 := "archs: [ x86_64 ]\nplatform: macosx\ninstall-name: /usr/lib/libv1.dylib\nexports:\n- archs: [ x86_64 ]\n  symbols: [ _v1 ]\n"
+	libs, err = Parse([]byte(v1), "x86_64", "macos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libs) != 1 || libs[0].InstallName != "/usr/lib/libv1.dylib" || !reflect.DeepEqual(libs[0].Symbols, []string{"_v1"}) {
+		t.Errorf("version 1: got %+v", libs)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		tbd, want string
+	}{
+		{`{"tapi_tbd_version": 5}`, "version 5"},
+		{"--- !tapi-tbd\ntbd-version: 5\n", "tbd-version \"5\""},
+		{"--- !tapi-tbd-v9\ninstall-name: /a\n", "unknown document tag"},
+		{"--- !tapi-tbd\ntbd-version: 4\ntargets: [ arm64-macos\n", "line 3: unterminated ["},
+		{"--- !tapi-tbd\ntbd-version: 4\ntargets: [ arm64-macos ]\n", "no install-name"},
+		{"--- !tapi-tbd\ntbd-version: 4\n  install-name: /a\n", "line 3: unexpected indentation"},
+		{"--- !tapi-tbd\ntbd-version: 4\ntbd-version: 4\n", "line 3: duplicate key"},
+		{"--- !tapi-tbd\ninstall-name: '/a\n", "unterminated string"},
+		{"--- !tapi-tbd\nsymbols: [ a b ] c\n", "unexpected \"c\""},
+		{"", "no libraries"},
+	} {
+		if _, err := Parse([]byte(tc.tbd), "arm64", "macos"); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want an error with %q", tc.tbd, err, tc.want)
+		}
+	}
+}
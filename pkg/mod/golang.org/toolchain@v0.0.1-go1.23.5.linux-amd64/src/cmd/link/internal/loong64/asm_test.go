@@ -0,0 +1,86 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loong64
+
+import (
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+	"cmd/link/internal/ld"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"testing"
+)
+
+// TestTrampoline checks that a call gets a trampoline only once its
+// target is out of the range of a B or BL, and that the trampoline
+// reaches the target.
+func TestTrampoline(t *testing.T) {
+	const caller = 1 << 28
+	for _, tt := range []struct {
+		off   int64 // of the callee from the call
+		tramp bool
+	}{
+		{1<<27 - 4, false},
+		{1 << 27, true},
+		{-1 << 27, false},
+		{-1<<27 - 4, true},
+		{1 << 29, true},
+	} {
+		ldr := loader.NewLoader(0, &loader.ErrorReporter{})
+		ctxt := &ld.Link{Target: ld.Target{Arch: sys.ArchLoong64, LinkMode: ld.LinkInternal}}
+		text := func(name string, value int64) loader.Sym {
+			s := ldr.CreateExtSym(name, 0)
+			sb := ldr.MakeSymbolUpdater(s)
+			sb.SetType(sym.STEXT)
+			sb.SetReachable(true)
+			sb.SetSize(8)
+			sb.SetValue(value)
+			ldr.SetSymPkg(s, "main")
+			return s
+		}
+		callee := text("main.callee", caller+4+tt.off)
+		s := text("main.caller", caller)
+		r, _ := ldr.MakeSymbolUpdater(s).AddRel(objabi.R_CALLLOONG64)
+		r.SetOff(4)
+		r.SetSiz(4)
+		r.SetSym(callee)
+
+		trampoline(ctxt, ldr, 0, callee, s)
+		relocs := ldr.Relocs(s)
+		target := relocs.At(0).Sym()
+		if got := target != callee; got != tt.tramp {
+			t.Errorf("call of offset %#x: trampoline %v, want %v", tt.off, got, tt.tramp)
+			continue
+		}
+		if !tt.tramp {
+			continue
+		}
+
+		// Lay the trampoline down after the caller and relocate it.
+		ldr.SetSymValue(target, caller+8)
+		relocs = ldr.Relocs(target)
+		data := ldr.Data(target)
+		var pc int64
+		for i := 0; i < relocs.Count(); i++ {
+			r := relocs.At(i)
+			val := int64(ctxt.Arch.ByteOrder.Uint32(data[r.Off():]))
+			o, _, ok := archreloc(&ctxt.Target, ldr, nil, r, target, val)
+			if !ok {
+				t.Fatalf("archreloc of %v failed", r.Type())
+			}
+			switch r.Type() {
+			case objabi.R_LOONG64_ADDR_HI:
+				// pcalau12i: the page of the pc plus si20 pages.
+				pc = (caller+8)&^0xfff + int64(int32(o&0x1ffffe0)<<7)
+			case objabi.R_LOONG64_ADDR_LO:
+				// addi.d: plus si12.
+				pc += int64(int32(o&0x3ffc00) << 10 >> 20)
+			}
+		}
+		if want := ldr.SymValue(callee); pc != want {
+			t.Errorf("call of offset %#x: trampoline jumps to %#x, want %#x", tt.off, pc, want)
+		}
+	}
+}
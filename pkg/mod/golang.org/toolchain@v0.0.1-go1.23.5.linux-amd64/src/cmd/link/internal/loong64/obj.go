@@ -20,6 +20,8 @@ func Init() (*sys.Arch, ld.Arch) {
 		Dwarfregsp:       dwarfRegSP,
 		Dwarfreglr:       dwarfRegLR,
 		CodePad:          []byte{0x00, 0x00, 0x2a, 0x00}, // BREAK 0
+		TrampLimit:       0x7c00000,                      // 26-bit signed offset * 4, leave room for PLT etc.
+		TrampSize:        12,                             // Trampolines in LOONG64 are 3 instructions.
 		Adddynrel:        adddynrel,
 		Archinit:         archinit,
 		Archreloc:        archreloc,
@@ -27,6 +29,7 @@ func Init() (*sys.Arch, ld.Arch) {
 		Extreloc:         extreloc,
 		Machoreloc1:      machoreloc1,
 		Gentext:          gentext,
+		Trampoline:       trampoline,
 
 		ELF: ld.ELFArch{
 			Linuxdynld:     "/lib64/ld-linux-loongarch-lp64d.so.1",
@@ -55,6 +55,7 @@ func Init() (*sys.Arch, ld.Arch) {
 		Dwarfregsp: dwarfRegSP,
 		Dwarfreglr: dwarfRegLR,
 		TrampLimit: 0x1c00000,
+		TrampSize:  16, // Trampolines in PPC64 are 4 instructions.
 
 		Adddynrel:        adddynrel,
 		Archinit:         archinit,
@@ -99,6 +99,14 @@ func adddynrel(target *ld.Target, ldr *loader.Loader, syms *ld.ArchSyms, s loade
 		su.SetRelocType(rIdx, objabi.R_RISCV_PCREL_LO12_S)
 		return true
 
+	case objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_64):
+		if targType == sym.SDYNIMPORT {
+			ldr.Errorf(s, "unexpected R_RISCV_64 relocation for dynamic symbol %s", ldr.SymName(targ))
+		}
+		su := ldr.MakeSymbolUpdater(s)
+		su.SetRelocType(rIdx, objabi.R_ADDR)
+		return true
+
 	case objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_RVC_BRANCH):
 		su := ldr.MakeSymbolUpdater(s)
 		su.SetRelocType(rIdx, objabi.R_RISCV_RVC_BRANCH)
@@ -114,8 +122,14 @@ func adddynrel(target *ld.Target, ldr *loader.Loader, syms *ld.ArchSyms, s loade
 		su.SetRelocType(rIdx, objabi.R_RISCV_BRANCH)
 		return true
 
-	case objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_RELAX):
-		// Ignore relaxations, at least for now.
+	case objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_JAL):
+		su := ldr.MakeSymbolUpdater(s)
+		su.SetRelocType(rIdx, objabi.R_RISCV_JAL)
+		return true
+
+	case objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_RELAX),
+		objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_ALIGN):
+		// Done by relax, as the text was laid out.
 		return true
 
 	default:
@@ -643,7 +657,7 @@ func trampoline(ctxt *ld.Link, ldr *loader.Loader, ri int, rs, s loader.Sym) {
 
 		// Relocation symbol has an address and is directly reachable,
 		// therefore there is no need for a trampoline.
-		if ldr.SymValue(rs) != 0 && off >= -(1<<20) && off < (1<<20) && (*ld.FlagDebugTramp <= 1 || ldr.SymPkg(s) == ldr.SymPkg(rs)) {
+		if ldr.SymValue(rs) != 0 && off >= -(1<<20) && off < (1<<20) && (!ld.ForceTramps() || ldr.SymPkg(s) == ldr.SymPkg(rs)) {
 			break
 		}
 
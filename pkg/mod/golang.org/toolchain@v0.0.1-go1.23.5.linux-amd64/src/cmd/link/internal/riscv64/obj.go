@@ -31,11 +31,13 @@ func Init() (*sys.Arch, ld.Arch) {
 		// symbols require the use of trampolines, regardless of the
 		// text size.
 		TrampLimit: 1,
+		TrampSize:  8, // Trampolines in RISCV64 are 2 instructions.
 		Trampoline: trampoline,
 
 		Gentext:     gentext,
 		GenSymsLate: genSymsLate,
 		Machoreloc1: machoreloc1,
+		Relax:       relax,
 
 		ELF: ld.ELFArch{
 			Linuxdynld: "/lib/ld.so.1",
@@ -0,0 +1,239 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package riscv64
+
+// This file contains the relaxation of the text of host objects, which
+// a C compiler leaves to the linker. A call that the assembler wrote as
+// an AUIPC and a JALR, marked with R_RISCV_RELAX, becomes a JAL when its
+// target is in reach, and the NOPs before an R_RISCV_ALIGN are cut to
+// those that the alignment needs where the code ends up. Go code has no
+// such marks: its calls are JALs to begin with.
+//
+// References are not relaxed to be relative to the global pointer, as a
+// program linked internally does not start in the crt1.o that would set
+// gp to __global_pointer$. Nor are calls of dynamic imports, whose PLT
+// entries have no address yet.
+
+import (
+	"cmd/internal/objabi"
+	"cmd/link/internal/ld"
+	"cmd/link/internal/loader"
+	"cmd/link/internal/sym"
+	"debug/elf"
+	"encoding/binary"
+	"sort"
+)
+
+// jalReach is the distance to its target up to which relax makes a call
+// a JAL. It leaves half of the reach of a JAL to the trampolines that
+// are laid down afterwards; a call they put out of reach goes through a
+// trampoline of its own.
+const jalReach = 1 << 19
+
+// relaxed records the sections whose R_RISCV_ALIGN padding is the one
+// relax laid down rather than that of the assembler.
+var relaxed = make(map[loader.Sym]bool)
+
+// An edit replaces the n bytes at off of a section with data.
+type edit struct {
+	off, n int64
+	data   []byte
+	delta  int64 // the change in size by the edits before
+	ri     int   // the relocation replaced, if a call
+}
+
+// relax relaxes the text of host objects at the addresses assigned to
+// it and reports whether it changed any.
+func relax(ctxt *ld.Link, ldr *loader.Loader) bool {
+	edits := make(map[loader.Sym][]edit)
+	saved := int64(0)
+	for _, s := range ctxt.Textp {
+		if !ldr.IsExternal(s) || ldr.OuterSym(s) != 0 {
+			continue
+		}
+		if e := relaxEdits(ldr, s); len(e) > 0 {
+			edits[s] = e
+			last := e[len(e)-1]
+			saved -= last.delta + int64(len(last.data)) - last.n
+		}
+	}
+	if len(edits) == 0 {
+		return false
+	}
+	if ctxt.Debugvlog != 0 {
+		ctxt.Logf("relaxed %d host object text sections by %d bytes\n", len(edits), saved)
+	}
+
+	// Move the references into the sections edited from the offsets
+	// they are at, such as those of jump tables and the deltas of
+	// .eh_frame, before the edits move the symbols.
+	for s := loader.Sym(1); s < loader.Sym(ldr.NSym()); s++ {
+		if !ldr.IsExternal(s) || !ldr.AttrReachable(s) {
+			continue
+		}
+		relocs := ldr.Relocs(s)
+		for ri := 0; ri < relocs.Count(); ri++ {
+			r := relocs.At(ri)
+			if r.Add() == 0 {
+				continue
+			}
+			rs := r.Sym()
+			outer := rs
+			if o := ldr.OuterSym(rs); o != 0 {
+				outer = o
+			}
+			e, ok := edits[outer]
+			if !ok {
+				continue
+			}
+			off := ldr.SymValue(rs) - ldr.SymValue(outer)
+			r.SetAdd(newOffset(e, off+r.Add()) - newOffset(e, off))
+		}
+	}
+	for s, e := range edits {
+		applyEdits(ldr, s, e)
+	}
+	return true
+}
+
+// relaxEdits returns the edits that relax the section s, in order.
+func relaxEdits(ldr *loader.Loader, s loader.Sym) []edit {
+	var edits []edit
+	delta := func() int64 {
+		if len(edits) == 0 {
+			return 0
+		}
+		last := edits[len(edits)-1]
+		return last.delta + int64(len(last.data)) - last.n
+	}
+	add := func(off, n int64, data []byte, ri int) {
+		edits = append(edits, edit{off: off, n: n, data: data, delta: delta(), ri: ri})
+	}
+
+	p := ldr.Data(s)
+	relocs := ldr.Relocs(s)
+	for ri := 0; ri < relocs.Count(); ri++ {
+		r := relocs.At(ri)
+		off := int64(r.Off())
+		switch r.Type() {
+		case objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_CALL),
+			objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_CALL_PLT):
+			rs := r.Sym()
+			if !hasRelax(relocs, ri) || ldr.SymType(rs) != sym.STEXT || !ldr.AttrReachable(rs) || off+8 > int64(len(p)) {
+				continue
+			}
+			d := ldr.SymValue(rs) + r.Add() - (ldr.SymValue(s) + off)
+			if d < -jalReach || d >= jalReach {
+				continue
+			}
+			// JAL with the destination register of the JALR.
+			rd := binary.LittleEndian.Uint32(p[off+4:]) >> 7 & 0x1f
+			add(off, 8, binary.LittleEndian.AppendUint32(nil, rd<<7|0x6f), ri)
+
+		case objabi.ElfRelocOffset + objabi.RelocType(elf.R_RISCV_ALIGN):
+			// The addend is the padding of the assembler, which is
+			// the alignment less the size of the smallest instruction.
+			align := int64(1)
+			for align <= r.Add() {
+				align <<= 1
+			}
+			pad := r.Add()
+			if relaxed[s] {
+				pad = -off & (align - 1)
+			}
+			want := -(off + delta()) & (align - 1)
+			if want == pad {
+				continue
+			}
+			var nops []byte
+			for ; want >= 4; want -= 4 {
+				nops = append(nops, 0x13, 0, 0, 0) // NOP
+			}
+			if want == 2 {
+				nops = append(nops, 0x01, 0) // C.NOP
+			}
+			add(off, pad, nops, ri)
+		}
+	}
+	if !relaxed[s] && len(edits) == 0 {
+		relaxed[s] = true
+	}
+	return edits
+}
+
+// hasRelax reports whether the relocation ri is marked with R_RISCV_RELAX.
+func hasRelax(relocs loader.Relocs, ri int) bool {
+	off := relocs.At(ri).Off()
+	for i := ri - 1; i >= 0 && relocs.At(i).Off() == off; i-- {
+		if relocs.At(i).Type() == objabi.ElfRelocOffset+objabi.RelocType(elf.R_RISCV_RELAX) {
+			return true
+		}
+	}
+	for i := ri + 1; i < relocs.Count() && relocs.At(i).Off() == off; i++ {
+		if relocs.At(i).Type() == objabi.ElfRelocOffset+objabi.RelocType(elf.R_RISCV_RELAX) {
+			return true
+		}
+	}
+	return false
+}
+
+// newOffset returns the offset that the edits move off of a section to.
+// An offset in the bytes an edit replaces moves to the start of the
+// edit, and one at an edit that inserts bytes moves past them.
+func newOffset(edits []edit, off int64) int64 {
+	i := sort.Search(len(edits), func(i int) bool { return edits[i].off+edits[i].n > off })
+	if i == len(edits) {
+		last := edits[len(edits)-1]
+		return off + last.delta + int64(len(last.data)) - last.n
+	}
+	e := edits[i]
+	if off <= e.off {
+		return off + e.delta
+	}
+	if d := off - e.off; d < int64(len(e.data)) {
+		return e.off + e.delta + d
+	}
+	return e.off + e.delta + int64(len(e.data))
+}
+
+// applyEdits edits the section s, its relocations and its symbols.
+func applyEdits(ldr *loader.Loader, s loader.Sym, edits []edit) {
+	su := ldr.MakeSymbolUpdater(s)
+	p := su.Data()
+	var data []byte
+	prev := int64(0)
+	for _, e := range edits {
+		data = append(append(data, p[prev:e.off]...), e.data...)
+		prev = e.off + e.n
+	}
+	data = append(data, p[prev:]...)
+
+	relocs := su.Relocs()
+	for ri := 0; ri < relocs.Count(); ri++ {
+		r := relocs.At(ri)
+		r.SetOff(int32(newOffset(edits, int64(r.Off()))))
+	}
+	for _, e := range edits {
+		r := relocs.At(e.ri)
+		r.SetOff(int32(e.off + e.delta))
+		if r.Type() != objabi.ElfRelocOffset+objabi.RelocType(elf.R_RISCV_ALIGN) {
+			r.SetType(objabi.R_RISCV_JAL)
+			r.SetSiz(4)
+		}
+	}
+
+	base := ldr.SymValue(s)
+	for sub := ldr.SubSym(s); sub != 0; sub = ldr.SubSym(sub) {
+		off := ldr.SymValue(sub) - base
+		end := newOffset(edits, off+ldr.SymSize(sub))
+		off = newOffset(edits, off)
+		ldr.SetSymValue(sub, base+off)
+		ldr.MakeSymbolUpdater(sub).SetSize(end - off)
+	}
+	su.SetData(data)
+	su.SetSize(int64(len(data)))
+	su.SetReadOnly(false)
+	relaxed[s] = true
+}
@@ -290,9 +290,15 @@ func asmb2(ctxt *ld.Link, ldr *loader.Loader) {
 	writeElementSec(ctxt, uint64(len(hostImports)), uint64(len(fns)))
 	writeCodeSec(ctxt, fns)
 	writeDataSec(ctxt)
+	for _, cs := range ld.WasmCustomSections {
+		writeCustomSec(ctxt, cs)
+	}
 	writeProducerSec(ctxt)
+	if len(ld.WasmTargetFeatures) > 0 {
+		writeTargetFeaturesSec(ctxt)
+	}
 	if !*ld.FlagS {
-		writeNameSec(ctxt, len(hostImports), fns)
+		writeNameSec(ctxt, hostImports, fns, types)
 	}
 }
 
@@ -415,27 +421,32 @@ func writeMemorySec(ctxt *ld.Link, ldr *loader.Loader) {
 	writeSecSize(ctxt, sizeOffset)
 }
 
+// globalRegs are the registers kept in global variables, in the order
+// of their indexes.
+var globalRegs = []struct {
+	name string
+	typ  byte
+}{
+	{"SP", I32},
+	{"CTXT", I64},
+	{"g", I64},
+	{"RET0", I64},
+	{"RET1", I64},
+	{"RET2", I64},
+	{"RET3", I64},
+	{"PAUSE", I32},
+}
+
 // writeGlobalSec writes the section that declares global variables.
 func writeGlobalSec(ctxt *ld.Link) {
 	sizeOffset := writeSecHeader(ctxt, sectionGlobal)
 
-	globalRegs := []byte{
-		I32, // 0: SP
-		I64, // 1: CTXT
-		I64, // 2: g
-		I64, // 3: RET0
-		I64, // 4: RET1
-		I64, // 5: RET2
-		I64, // 6: RET3
-		I32, // 7: PAUSE
-	}
-
 	writeUleb128(ctxt.Out, uint64(len(globalRegs))) // number of globals
 
-	for _, typ := range globalRegs {
-		ctxt.Out.WriteByte(typ)
+	for _, g := range globalRegs {
+		ctxt.Out.WriteByte(g.typ)
 		ctxt.Out.WriteByte(0x01) // var
-		switch typ {
+		switch g.typ {
 		case I32:
 			writeI32Const(ctxt.Out, 0)
 		case I64:
@@ -591,22 +602,62 @@ func writeDataSec(ctxt *ld.Link) {
 	writeSecSize(ctxt, sizeOffset)
 }
 
-// writeProducerSec writes an optional section that reports the source language and compiler version.
+// writeCustomSec writes a custom section given by -wasmsection.
+func writeCustomSec(ctxt *ld.Link, cs ld.WasmCustomSection) {
+	sizeOffset := writeSecHeader(ctxt, sectionCustom)
+	writeName(ctxt.Out, cs.Name)
+	ctxt.Out.Write(cs.Data)
+	writeSecSize(ctxt, sizeOffset)
+}
+
+// writeProducerSec writes an optional section that reports the source language and compiler version,
+// and the producers added with -wasmproducer.
 func writeProducerSec(ctxt *ld.Link) {
 	sizeOffset := writeSecHeader(ctxt, sectionCustom)
 	writeName(ctxt.Out, "producers")
 
-	writeUleb128(ctxt.Out, 2) // number of fields
+	producers := append(ld.WasmDefaultProducers(), ld.WasmProducers...)
+	var fields []string // in the order they first appear
+	for _, p := range producers {
+		seen := false
+		for _, f := range fields {
+			seen = seen || f == p.Field
+		}
+		if !seen {
+			fields = append(fields, p.Field)
+		}
+	}
+	writeUleb128(ctxt.Out, uint64(len(fields))) // number of fields
+	for _, field := range fields {
+		n := 0
+		for _, p := range producers {
+			if p.Field == field {
+				n++
+			}
+		}
+		writeName(ctxt.Out, field)        // field name
+		writeUleb128(ctxt.Out, uint64(n)) // number of values
+		for _, p := range producers {
+			if p.Field == field {
+				writeName(ctxt.Out, p.Name)    // value: name
+				writeName(ctxt.Out, p.Version) // value: version
+			}
+		}
+	}
 
-	writeName(ctxt.Out, "language")       // field name
-	writeUleb128(ctxt.Out, 1)             // number of values
-	writeName(ctxt.Out, "Go")             // value: name
-	writeName(ctxt.Out, buildcfg.Version) // value: version
+	writeSecSize(ctxt, sizeOffset)
+}
 
-	writeName(ctxt.Out, "processed-by")   // field name
-	writeUleb128(ctxt.Out, 1)             // number of values
-	writeName(ctxt.Out, "Go cmd/compile") // value: name
-	writeName(ctxt.Out, buildcfg.Version) // value: version
+// writeTargetFeaturesSec writes the section that lists the features given by -wasmtargetfeatures.
+func writeTargetFeaturesSec(ctxt *ld.Link) {
+	sizeOffset := writeSecHeader(ctxt, sectionCustom)
+	writeName(ctxt.Out, "target_features")
+
+	writeUleb128(ctxt.Out, uint64(len(ld.WasmTargetFeatures)))
+	for _, f := range ld.WasmTargetFeatures {
+		ctxt.Out.WriteByte(f.Prefix)
+		writeName(ctxt.Out, f.Name)
+	}
 
 	writeSecSize(ctxt, sizeOffset)
 }
@@ -615,22 +666,123 @@ var nameRegexp = regexp.MustCompile(`[^\w.]`)
 
 // writeNameSec writes an optional section that assigns names to the functions declared by the "func" section.
 // The names are only used by WebAssembly stack traces, debuggers and decompilers.
+// With -wasmnames, it also names the host imports, the parameters and locals of every function and the globals.
 // TODO(neelance): add symbol table of DATA symbols
-func writeNameSec(ctxt *ld.Link, firstFnIndex int, fns []*wasmFunc) {
+func writeNameSec(ctxt *ld.Link, hostImports, fns []*wasmFunc, types []*wasmFuncType) {
 	sizeOffset := writeSecHeader(ctxt, sectionCustom)
 	writeName(ctxt.Out, "name")
 
+	firstFnIndex := len(hostImports)
 	sizeOffset2 := writeSecHeader(ctxt, 0x01) // function names
-	writeUleb128(ctxt.Out, uint64(len(fns)))
+	if *ld.FlagWasmNames {
+		writeUleb128(ctxt.Out, uint64(len(hostImports)+len(fns)))
+		for i, fn := range hostImports {
+			writeUleb128(ctxt.Out, uint64(i))
+			writeName(ctxt.Out, nameRegexp.ReplaceAllString(fn.Module+"."+fn.Name, "_"))
+		}
+	} else {
+		writeUleb128(ctxt.Out, uint64(len(fns)))
+	}
 	for i, fn := range fns {
 		writeUleb128(ctxt.Out, uint64(firstFnIndex+i))
 		writeName(ctxt.Out, fn.Name)
 	}
 	writeSecSize(ctxt, sizeOffset2)
 
+	if *ld.FlagWasmNames {
+		sizeOffset2 := writeSecHeader(ctxt, 0x02) // local names
+		writeUleb128(ctxt.Out, uint64(len(hostImports)+len(fns)))
+		for i, fn := range hostImports {
+			writeUleb128(ctxt.Out, uint64(i))
+			writeLocalNames(ctxt, localNames(types[fn.Type], nil, false))
+		}
+		for i, fn := range fns {
+			writeUleb128(ctxt.Out, uint64(firstFnIndex+i))
+			writeLocalNames(ctxt, localNames(types[fn.Type], fn.Code, fn.Type == 0))
+		}
+		writeSecSize(ctxt, sizeOffset2)
+
+		sizeOffset2 = writeSecHeader(ctxt, 0x07) // global names
+		writeUleb128(ctxt.Out, uint64(len(globalRegs)))
+		for i, g := range globalRegs {
+			writeUleb128(ctxt.Out, uint64(i))
+			writeName(ctxt.Out, g.name)
+		}
+		writeSecSize(ctxt, sizeOffset2)
+	}
+
 	writeSecSize(ctxt, sizeOffset)
 }
 
+// localNames returns the names of the parameters and locals of a
+// function of type sig and with the body code, nil for an import.
+//
+// In the normal calling convention, the parameter is PC_B and the first
+// local caches SP. The other locals hold the registers the function
+// uses, which the body does not record, so they are named by their type
+// and index, like the parameters of the other functions.
+func localNames(sig *wasmFuncType, code []byte, normal bool) []string {
+	var names []string
+	add := func(typ byte) {
+		i := len(names)
+		switch {
+		case normal && i == 0:
+			names = append(names, "PC_B")
+		case normal && i == 1:
+			names = append(names, "SP")
+		default:
+			names = append(names, fmt.Sprintf("%s_%d", valueTypeName(typ), i))
+		}
+	}
+	for _, typ := range sig.Params {
+		add(typ)
+	}
+	if len(code) == 0 {
+		return names
+	}
+	// The body starts with the declarations of the locals: their number,
+	// then a count and a type for each run of locals of one type.
+	ndecls, n := binary.Uvarint(code)
+	for ; ndecls > 0 && n > 0 && n < len(code); ndecls-- {
+		code = code[n:]
+		var count uint64
+		count, n = binary.Uvarint(code)
+		if n <= 0 || n >= len(code) {
+			break
+		}
+		for typ := code[n]; count > 0; count-- {
+			add(typ)
+		}
+		n++
+	}
+	return names
+}
+
+// valueTypeName returns the name of the value type typ in the text format.
+func valueTypeName(typ byte) string {
+	switch typ {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	}
+	return "local"
+}
+
+// writeLocalNames writes the names of the locals of a function, indexed
+// from 0.
+func writeLocalNames(ctxt *ld.Link, names []string) {
+	writeUleb128(ctxt.Out, uint64(len(names)))
+	for i, name := range names {
+		writeUleb128(ctxt.Out, uint64(i))
+		writeName(ctxt.Out, name)
+	}
+}
+
 type nameWriter interface {
 	io.ByteWriter
 	io.Writer
@@ -7,8 +7,10 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"debug/elf"
 	"debug/macho"
 	"errors"
+	"fmt"
 	"internal/platform"
 	"internal/testenv"
 	"os"
@@ -411,6 +413,64 @@ func TestMachOBuildVersion(t *testing.T) {
 	}
 }
 
+// TestMachOTestBinaryUUID checks that test binaries built with external
+// linking get their LC_UUID rewritten from the Go build ID, so that two
+// "go test -c" builds of the same package are identical, UUID included.
+func TestMachOTestBinaryUUID(t *testing.T) {
+	if runtime.GOOS != "darwin" {
+		t.Skip("skipping on non-darwin platform")
+	}
+	testenv.MustHaveGoBuild(t)
+	testenv.MustHaveCGO(t) // this test requires -linkmode=external
+	t.Parallel()
+
+	tmpdir := t.TempDir()
+	files := map[string]string{
+		"go.mod":    "module x\ngo 1.21\n",
+		"x.go":      "package x\n\nfunc F() int { return 1 }\n",
+		"x_test.go": "package x\n\nimport \"testing\"\n\nfunc TestF(t *testing.T) { F() }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readUUID := func(exe string) []byte {
+		f, err := macho.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		for _, l := range f.Loads {
+			raw := l.Raw()
+			const LC_UUID = 0x1b
+			if f.ByteOrder.Uint32(raw) == LC_UUID {
+				return raw[8:24]
+			}
+		}
+		t.Fatalf("%s: no LC_UUID load command", exe)
+		return nil
+	}
+
+	var uuids [2][]byte
+	for i := range uuids {
+		exe := filepath.Join(tmpdir, fmt.Sprintf("x%d.test", i))
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "test", "-c", "-ldflags=-linkmode=external", "-o", exe)
+		cmd.Dir = tmpdir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v:\n%s", cmd.Args, err, out)
+		}
+		uuids[i] = readUUID(exe)
+	}
+	if !bytes.Equal(uuids[0], uuids[1]) {
+		t.Errorf("test binaries have different UUIDs: %x != %x", uuids[0], uuids[1])
+	}
+	if bytes.Equal(uuids[0], make([]byte, 16)) {
+		t.Errorf("test binary has an all-zero UUID; was it derived from the build ID?")
+	}
+}
+
 const Issue34788src = `
 
 package blah
@@ -671,7 +731,7 @@ func TestTrampoline(t *testing.T) {
 	// calls will use trampolines.
 	buildmodes := []string{"default"}
 	switch runtime.GOARCH {
-	case "arm", "arm64", "ppc64":
+	case "arm", "arm64", "loong64", "ppc64":
 	case "ppc64le":
 		// Trampolines are generated differently when internal linking PIE, test them too.
 		buildmodes = append(buildmodes, "pie")
@@ -709,6 +769,75 @@ func TestTrampoline(t *testing.T) {
 	}
 }
 
+func TestTrampolineMode(t *testing.T) {
+	// Test that -tramp=always makes cross-package calls use trampolines
+	// on every architecture that supports them, and that -tramp=never
+	// inserts none.
+	testenv.MustHaveGoBuild(t)
+	if testing.Short() {
+		t.Skip("skipping cross-builds in short mode")
+	}
+
+	t.Parallel()
+
+	tmpdir := t.TempDir()
+
+	src := filepath.Join(tmpdir, "hello.go")
+	err := os.WriteFile(src, []byte(testTrampSrc), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(tmpdir, "hello.exe")
+
+	tramps := func(goarch, mode string) (int, error) {
+		cmd := testenv.Command(t, testenv.GoToolPath(t), "build", "-ldflags=-tramp="+mode, "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+goarch, "CGO_ENABLED=0")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return 0, fmt.Errorf("%v\n%s", err, out)
+		}
+		f, err := elf.Open(exe)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		syms, err := f.Symbols()
+		if err != nil {
+			return 0, err
+		}
+		n := 0
+		for _, s := range syms {
+			if strings.Contains(s.Name, "-tramp") {
+				n++
+			}
+		}
+		return n, nil
+	}
+	for _, goarch := range []string{"arm", "arm64", "loong64", "ppc64le", "riscv64"} {
+		n, err := tramps(goarch, "always")
+		if err != nil {
+			t.Errorf("build (%s, -tramp=always) failed: %v", goarch, err)
+		} else if n == 0 {
+			t.Errorf("%s: -tramp=always inserted no trampolines", goarch)
+		}
+		n, err = tramps(goarch, "never")
+		if goarch == "riscv64" {
+			if err == nil || !strings.Contains(err.Error(), "-tramp=never is not supported") {
+				t.Errorf("build (%s, -tramp=never) = %v, want an error", goarch, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("build (%s, -tramp=never) failed: %v", goarch, err)
+		} else if n != 0 {
+			t.Errorf("%s: -tramp=never inserted %d trampolines", goarch, n)
+		}
+	}
+	if _, err := tramps("amd64", "always"); err == nil || !strings.Contains(err.Error(), "-tramp=always is not supported on amd64") {
+		t.Errorf("build (amd64, -tramp=always) = %v, want an error", err)
+	}
+}
+
 const testTrampCgoSrc = `
 package main
 
@@ -728,7 +857,7 @@ func TestTrampolineCgo(t *testing.T) {
 	// calls will use trampolines.
 	buildmodes := []string{"default"}
 	switch runtime.GOARCH {
-	case "arm", "arm64", "ppc64":
+	case "arm", "arm64", "loong64", "ppc64":
 	case "ppc64le":
 		// Trampolines are generated differently when internal linking PIE, test them too.
 		buildmodes = append(buildmodes, "pie")
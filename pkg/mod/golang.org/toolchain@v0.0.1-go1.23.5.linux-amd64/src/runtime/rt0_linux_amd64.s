@@ -9,3 +9,102 @@ TEXT _rt0_amd64_linux(SB),NOSPLIT,$-8
 
 TEXT _rt0_amd64_linux_lib(SB),NOSPLIT,$0
 	JMP	_rt0_amd64_lib(SB)
+
+// _rt0_amd64_linux_static_pie is the entry point of a PIE linked with
+// -static-pie, which no dynamic linker loads. Before anything reads a
+// pointer from its data, it applies the relative relocations of its
+// .dynamic section to itself, which are all there are, as the dynamic
+// linker would.
+TEXT _rt0_amd64_linux_static_pie(SB),NOSPLIT|NOFRAME,$0
+	// The load bias is the distance from the address _DYNAMIC was
+	// linked at, which the word at _rt0_amd64_linux_static_pie_dynamic
+	// holds until it is relocated, to the one it is at.
+	LEAQ	_DYNAMIC(SB), SI
+	MOVQ	SI, DI
+	SUBQ	_rt0_amd64_linux_static_pie_dynamic<>(SB), DI
+
+	XORQ	R8, R8	// .rela
+	XORQ	R9, R9	// its size
+	XORQ	R10, R10	// .relr.dyn
+	XORQ	R11, R11	// its size
+dyn:
+	MOVQ	0(SI), AX	// d_tag
+	MOVQ	8(SI), BX	// d_val
+	ADDQ	$16, SI
+	CMPQ	AX, $0	// DT_NULL
+	JEQ	rela
+	CMPQ	AX, $7	// DT_RELA
+	JNE	2(PC)
+	MOVQ	BX, R8
+	CMPQ	AX, $8	// DT_RELASZ
+	JNE	2(PC)
+	MOVQ	BX, R9
+	CMPQ	AX, $36	// DT_RELR
+	JNE	2(PC)
+	MOVQ	BX, R10
+	CMPQ	AX, $35	// DT_RELRSZ
+	JNE	2(PC)
+	MOVQ	BX, R11
+	JMP	dyn
+
+rela:
+	// *(bias+r_offset) = bias+r_addend for each R_X86_64_RELATIVE.
+	TESTQ	R8, R8
+	JEQ	relr
+	ADDQ	DI, R8
+	ADDQ	R8, R9
+relaloop:
+	CMPQ	R8, R9
+	JAE	relr
+	CMPL	8(R8), $8	// R_X86_64_RELATIVE
+	JNE	bad
+	MOVQ	0(R8), AX
+	MOVQ	16(R8), BX
+	ADDQ	DI, BX
+	MOVQ	BX, 0(DI)(AX*1)
+	ADDQ	$24, R8
+	JMP	relaloop
+
+relr:
+	// An even word of .relr.dyn is the address of a word to add the
+	// bias to, and an odd one a bitmap of which of the 63 words after
+	// the last to add it to.
+	TESTQ	R10, R10
+	JEQ	done
+	ADDQ	DI, R10
+	ADDQ	R10, R11
+	XORQ	DX, DX	// the word the next bitmap starts at
+relrloop:
+	CMPQ	R10, R11
+	JAE	done
+	MOVQ	0(R10), AX
+	ADDQ	$8, R10
+	TESTQ	$1, AX
+	JNE	bitmap
+	LEAQ	0(DI)(AX*1), DX
+	ADDQ	DI, 0(DX)
+	ADDQ	$8, DX
+	JMP	relrloop
+bitmap:
+	MOVQ	DX, BX
+	SHRQ	$1, AX
+bitloop:
+	TESTQ	AX, AX
+	JEQ	bitdone
+	TESTQ	$1, AX
+	JEQ	2(PC)
+	ADDQ	DI, 0(BX)
+	ADDQ	$8, BX
+	SHRQ	$1, AX
+	JMP	bitloop
+bitdone:
+	ADDQ	$(63*8), DX
+	JMP	relrloop
+
+done:
+	JMP	_rt0_amd64_linux(SB)
+bad:
+	MOVL	$0xf1, 0xf1  // crash
+
+DATA _rt0_amd64_linux_static_pie_dynamic<>+0(SB)/8, $_DYNAMIC(SB)
+GLOBL _rt0_amd64_linux_static_pie_dynamic<>(SB), NOPTR, $8
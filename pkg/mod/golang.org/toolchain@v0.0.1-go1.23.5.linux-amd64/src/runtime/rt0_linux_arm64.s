@@ -71,3 +71,100 @@ exit:
 	MOVD	$94, R8	// sys_exit
 	SVC
 	B	exit
+
+// _rt0_arm64_linux_static_pie is the entry point of a PIE linked with
+// -static-pie, which no dynamic linker loads. Before anything reads a
+// pointer from its data, it applies the relative relocations of its
+// .dynamic section to itself, which are all there are, as the dynamic
+// linker would.
+TEXT _rt0_arm64_linux_static_pie(SB),NOSPLIT|NOFRAME,$0
+	// The load bias is the distance from the address _DYNAMIC was
+	// linked at, which the word at _rt0_arm64_linux_static_pie_dynamic
+	// holds until it is relocated, to the one it is at.
+	MOVD	$_DYNAMIC(SB), R2
+	MOVD	_rt0_arm64_linux_static_pie_dynamic<>(SB), R3
+	SUB	R3, R2, R4
+
+	MOVD	ZR, R5	// .rela
+	MOVD	ZR, R6	// its size
+	MOVD	ZR, R7	// .relr.dyn
+	MOVD	ZR, R8	// its size
+dyn:
+	MOVD	0(R2), R0	// d_tag
+	MOVD	8(R2), R1	// d_val
+	ADD	$16, R2
+	CBZ	R0, rela	// DT_NULL
+	CMP	$7, R0	// DT_RELA
+	BNE	2(PC)
+	MOVD	R1, R5
+	CMP	$8, R0	// DT_RELASZ
+	BNE	2(PC)
+	MOVD	R1, R6
+	CMP	$36, R0	// DT_RELR
+	BNE	2(PC)
+	MOVD	R1, R7
+	CMP	$35, R0	// DT_RELRSZ
+	BNE	2(PC)
+	MOVD	R1, R8
+	B	dyn
+
+rela:
+	// *(bias+r_offset) = bias+r_addend for each R_AARCH64_RELATIVE.
+	CBZ	R5, relr
+	ADD	R4, R5
+	ADD	R5, R6
+relaloop:
+	CMP	R6, R5
+	BHS	relr
+	MOVWU	8(R5), R1
+	CMP	$1027, R1	// R_AARCH64_RELATIVE
+	BNE	bad
+	MOVD	0(R5), R0
+	MOVD	16(R5), R1
+	ADD	R4, R1
+	MOVD	R1, (R4)(R0)
+	ADD	$24, R5
+	B	relaloop
+
+relr:
+	// An even word of .relr.dyn is the address of a word to add the
+	// bias to, and an odd one a bitmap of which of the 63 words after
+	// the last to add it to.
+	CBZ	R7, done
+	ADD	R4, R7
+	ADD	R7, R8
+	MOVD	ZR, R9	// the word the next bitmap starts at
+relrloop:
+	CMP	R8, R7
+	BHS	done
+	MOVD.P	8(R7), R0
+	TBNZ	$0, R0, bitmap
+	ADD	R4, R0, R9
+	MOVD	(R9), R1
+	ADD	R4, R1
+	MOVD.P	R1, 8(R9)
+	B	relrloop
+bitmap:
+	MOVD	R9, R10
+	LSR	$1, R0
+bitloop:
+	CBZ	R0, bitdone
+	TBZ	$0, R0, 4(PC)
+	MOVD	(R10), R1
+	ADD	R4, R1
+	MOVD	R1, (R10)
+	ADD	$8, R10
+	LSR	$1, R0
+	B	bitloop
+bitdone:
+	ADD	$(63*8), R9
+	B	relrloop
+
+done:
+	B	_rt0_arm64_linux(SB)
+bad:
+	MOVD	$0, R0
+	MOVD	R0, (R0)	// crash
+
+DATA _rt0_arm64_linux_static_pie_dynamic<>+0(SB)/8, $_DYNAMIC(SB)
+GLOBL _rt0_arm64_linux_static_pie_dynamic<>(SB), NOPTR, $8